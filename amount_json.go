@@ -0,0 +1,130 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// amount_json.go implements lenient, overflow-safe decoding of JSON amounts
+package vandargo
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// maxExponentDigits bounds the exponent of a JSON amount before it is known to be
+// out of range, so huge exponents never reach integer arithmetic
+const maxExponentDigits = 9
+
+// UnmarshalJSON decodes a payment init request. Amount may be an integer, a
+// number without a fractional part such as 1e6, or a string of digits;
+// fractional and out-of-range amounts are reported as a ValidationError on
+// "amount" rather than a parse failure.
+func (r *PaymentInitRequest) UnmarshalJSON(data []byte) error {
+	type plain PaymentInitRequest
+	aux := struct {
+		*plain
+		Amount json.RawMessage `json:"amount"`
+	}{plain: (*plain)(r)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if len(aux.Amount) == 0 {
+		return nil
+	}
+
+	amount, err := parseJSONAmount(aux.Amount)
+	if err != nil {
+		return err
+	}
+	r.Amount = amount
+	return nil
+}
+
+// parseJSONAmount parses the raw JSON value of an amount in Rials
+func parseJSONAmount(raw json.RawMessage) (int64, error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case bytes.Equal(raw, []byte("null")):
+		return 0, nil
+	case len(raw) > 0 && raw[0] == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return 0, NewValidationError("amount", "must be a number")
+		}
+		s = strings.TrimSpace(s)
+		if s == "" || keepDigits(s) != s {
+			return 0, NewValidationError("amount", "must be a whole number of Rials")
+		}
+		return parseDecimalAmount(s)
+	case len(raw) > 0 && (raw[0] == '-' || (raw[0] >= '0' && raw[0] <= '9')):
+		return parseDecimalAmount(string(raw))
+	default:
+		return 0, NewValidationError("amount", "must be a number")
+	}
+}
+
+// parseDecimalAmount parses a JSON number, which may have a fraction and an
+// exponent, into a whole number of Rials without going through float64
+func parseDecimalAmount(s string) (int64, error) {
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	// Split off the exponent
+	exponent := 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		expPart := strings.TrimPrefix(s[i+1:], "+")
+		s = s[:i]
+
+		expNegative := strings.HasPrefix(expPart, "-")
+		expDigits := strings.TrimLeft(strings.TrimPrefix(expPart, "-"), "0")
+		if expDigits == "" {
+			expDigits = "0"
+		}
+		if len(expDigits) > maxExponentDigits || keepDigits(expDigits) != expDigits {
+			// Far beyond int64 either way; only a zero mantissa survives
+			if strings.Trim(strings.Replace(s, ".", "", 1), "0") == "" {
+				return 0, nil
+			}
+			if expNegative {
+				return 0, NewValidationError("amount", "must be a whole number of Rials")
+			}
+			return 0, NewValidationError("amount", "is out of range")
+		}
+		exponent, _ = strconv.Atoi(expDigits)
+		if expNegative {
+			exponent = -exponent
+		}
+	}
+
+	// The value is digits * 10^exponent
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	digits := intPart + fracPart
+	if digits == "" || keepDigits(digits) != digits {
+		return 0, NewValidationError("amount", "must be a number")
+	}
+	exponent -= len(fracPart)
+
+	// Trailing zeros move into the exponent, leading zeros don't count
+	trimmed := strings.TrimRight(digits, "0")
+	exponent += len(digits) - len(trimmed)
+	digits = strings.TrimLeft(trimmed, "0")
+	if digits == "" {
+		return 0, nil
+	}
+
+	if exponent < 0 {
+		return 0, NewValidationError("amount", "must be a whole number of Rials")
+	}
+	if len(digits)+exponent > 19 {
+		return 0, NewValidationError("amount", "is out of range")
+	}
+
+	if negative {
+		digits = "-" + digits
+	}
+	amount, err := strconv.ParseInt(digits+strings.Repeat("0", exponent), 10, 64)
+	if err != nil {
+		return 0, NewValidationError("amount", "is out of range")
+	}
+	return amount, nil
+}
@@ -0,0 +1,133 @@
+package vandargo
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPaymentInitRequestAmount(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want int64
+		ok   bool
+	}{
+		{`100000`, 100000, true},
+		{`100000.0`, 100000, true},
+		{`1e6`, 1000000, true},
+		{`1.5E3`, 1500, true},
+		{`15000e-1`, 1500, true},
+		{`"100000"`, 100000, true},
+		{`" 100000 "`, 100000, true},
+		{`9223372036854775807`, 9223372036854775807, true},
+		{`-9223372036854775808`, -9223372036854775808, true},
+		{`-5000`, -5000, true},
+		{`0e99999999999999`, 0, true},
+		{`null`, 0, true},
+		{`9223372036854775808`, 0, false},
+		{`1e20`, 0, false},
+		{`1e999999999999`, 0, false},
+		{`100.5`, 0, false},
+		{`1e-999999999999`, 0, false},
+		{`"1,000"`, 0, false},
+		{`"1e6"`, 0, false},
+		{`"-5000"`, 0, false},
+		{`""`, 0, false},
+		{`"abc"`, 0, false},
+		{`true`, 0, false},
+		{`[100000]`, 0, false},
+		{`{"value":100000}`, 0, false},
+	}
+
+	for _, tt := range tests {
+		var req PaymentInitRequest
+		err := json.Unmarshal([]byte(`{"amount":`+tt.raw+`,"description":"Order 1042"}`), &req)
+		if tt.ok {
+			if err != nil || req.Amount != tt.want || req.Description != "Order 1042" {
+				t.Errorf("amount %s: %d, %v; want %d", tt.raw, req.Amount, err, tt.want)
+			}
+			continue
+		}
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Field != "amount" {
+			t.Errorf("amount %s: %d, %v; want a validation error on amount", tt.raw, req.Amount, err)
+		}
+	}
+}
+
+func TestInitHandlerRejectsAmountWithField(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport())
+
+	for _, amount := range []string{`1e20`, `100.5`, `"ten"`} {
+		rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/init", `{"amount":`+amount+`,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+
+		var body struct {
+			Errors map[string]string `json:"errors"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != http.StatusUnprocessableEntity || body.Errors["amount"] == "" {
+			t.Errorf("amount %s: status %d: %s", amount, rec.Code, rec.Body)
+		}
+	}
+
+	// Digit strings are accepted
+	if rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/init", `{"amount":"1000000","callback_url":"https://shop.example.com/callback","description":"Order 1042"}`); rec.Code != http.StatusOK {
+		t.Fatalf("string amount: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// routeRequestBody sends an authenticated JSON request to handler
+func routeRequestBody(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func FuzzPaymentInitRequestAmount(f *testing.F) {
+	for _, seed := range []string{
+		`100000`, `1e6`, `1.5E3`, `"100000"`, `9223372036854775807`, `9223372036854775808`,
+		`-9223372036854775809`, `1e20`, `1e999999999999`, `1e-999999999999`, `0.0000e5`,
+		`100.5`, `"1,000"`, `null`, `true`, `00012`, `1e+0`, `-0`, `1E`, `.5`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		var req PaymentInitRequest
+		err := json.Unmarshal([]byte(`{"amount":`+raw+`}`), &req)
+
+		// Only valid JSON numbers have a value to compare with
+		if !json.Valid([]byte(raw)) {
+			return
+		}
+		raw = strings.Trim(raw, " \t\r\n")
+		if raw[0] != '-' && (raw[0] < '0' || raw[0] > '9') {
+			return
+		}
+		exact, _, parseErr := big.ParseFloat(raw, 10, 4096, big.ToNearestEven)
+		if parseErr != nil || exact.IsInf() {
+			if err == nil && req.Amount != 0 {
+				t.Fatalf("amount %s decoded to %d", raw, req.Amount)
+			}
+			return
+		}
+		value, accuracy := exact.Int64()
+		if !exact.IsInt() || accuracy != big.Exact {
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Field != "amount" {
+				t.Fatalf("amount %s: %d, %v; want a validation error on amount", raw, req.Amount, err)
+			}
+			return
+		}
+		if err != nil || req.Amount != value {
+			t.Fatalf("amount %s: %d, %v; want %d", raw, req.Amount, err, value)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// async_logger.go implements a buffered logger that writes from a background goroutine
+package vandargo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultDropWarningInterval is how often dropped entries are reported
+const defaultDropWarningInterval = time.Minute
+
+// asyncLogEntry is a queued log call, or a flush marker when flushed is set
+type asyncLogEntry struct {
+	ctx     context.Context
+	level   LogLevel
+	message string
+	err     error
+	fields  map[string]interface{}
+	flushed chan struct{}
+}
+
+// AsyncLoggerOption configures an AsyncLogger
+type AsyncLoggerOption func(*AsyncLogger)
+
+// WithErrorBypass writes Error entries synchronously instead of queueing them, so
+// they are never dropped. Such entries may appear before earlier queued entries.
+func WithErrorBypass() AsyncLoggerOption {
+	return func(l *AsyncLogger) {
+		l.errorBypass = true
+	}
+}
+
+// WithDropMetrics reports dropped entries to a metrics recorder
+func WithDropMetrics(metrics MetricsInterface) AsyncLoggerOption {
+	return func(l *AsyncLogger) {
+		if metrics != nil {
+			l.metrics = metrics
+		}
+	}
+}
+
+// WithDropWarningInterval sets how often a warning about dropped entries is logged
+func WithDropWarningInterval(interval time.Duration) AsyncLoggerOption {
+	return func(l *AsyncLogger) {
+		l.warnInterval = interval
+	}
+}
+
+// AsyncLogger wraps a LoggerInterface and writes entries from a dedicated goroutine.
+// When the buffer is full new entries are dropped and counted. Entries logged from
+// one goroutine are written in the order they were logged.
+type AsyncLogger struct {
+	inner        LoggerInterface
+	entries      chan asyncLogEntry
+	done         chan struct{}
+	errorBypass  bool
+	metrics      MetricsInterface
+	warnInterval time.Duration
+
+	dropped  atomic.Uint64
+	reported uint64
+
+	// mutex guards closing the entries channel against concurrent sends
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewAsyncLogger creates an AsyncLogger with the given buffer size and starts its writer
+func NewAsyncLogger(inner LoggerInterface, bufferSize int, opts ...AsyncLoggerOption) *AsyncLogger {
+	if bufferSize <= 0 {
+		bufferSize = 1024
+	}
+
+	l := &AsyncLogger{
+		inner:        loggerOrDiscard(inner),
+		entries:      make(chan asyncLogEntry, bufferSize),
+		done:         make(chan struct{}),
+		metrics:      noopMetrics{},
+		warnInterval: defaultDropWarningInterval,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	go l.run()
+
+	return l
+}
+
+// Debug queues a debug level message
+func (l *AsyncLogger) Debug(ctx context.Context, message string, fields map[string]interface{}) {
+	l.enqueue(asyncLogEntry{ctx: ctx, level: Debug, message: message, fields: fields})
+}
+
+// Info queues an informational message
+func (l *AsyncLogger) Info(ctx context.Context, message string, fields map[string]interface{}) {
+	l.enqueue(asyncLogEntry{ctx: ctx, level: Info, message: message, fields: fields})
+}
+
+// Warn queues a warning message
+func (l *AsyncLogger) Warn(ctx context.Context, message string, fields map[string]interface{}) {
+	l.enqueue(asyncLogEntry{ctx: ctx, level: Warn, message: message, fields: fields})
+}
+
+// Error queues an error message, or writes it directly when error bypass is enabled
+func (l *AsyncLogger) Error(ctx context.Context, message string, err error, fields map[string]interface{}) {
+	if l.errorBypass {
+		l.inner.Error(ctx, message, err, fields)
+		return
+	}
+
+	l.enqueue(asyncLogEntry{ctx: ctx, level: Error, message: message, err: err, fields: fields})
+}
+
+// Dropped returns the number of entries dropped because the buffer was full
+func (l *AsyncLogger) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Flush blocks until all entries queued before the call have been written
+func (l *AsyncLogger) Flush(ctx context.Context) error {
+	marker := asyncLogEntry{flushed: make(chan struct{})}
+
+	l.mutex.RLock()
+	if l.closed {
+		l.mutex.RUnlock()
+		return nil
+	}
+
+	select {
+	case l.entries <- marker:
+		l.mutex.RUnlock()
+	case <-ctx.Done():
+		l.mutex.RUnlock()
+		return ctx.Err()
+	}
+
+	select {
+	case <-marker.flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting entries and waits until the queue is drained or ctx is done
+func (l *AsyncLogger) Close(ctx context.Context) error {
+	l.mutex.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.entries)
+	}
+	l.mutex.Unlock()
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueue adds an entry to the buffer without blocking, dropping it when full
+func (l *AsyncLogger) enqueue(entry asyncLogEntry) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if l.closed {
+		l.drop()
+		return
+	}
+
+	select {
+	case l.entries <- entry:
+	default:
+		l.drop()
+	}
+}
+
+// drop counts a dropped entry
+func (l *AsyncLogger) drop() {
+	l.dropped.Add(1)
+	l.metrics.IncCounter(MetricLogEntriesDropped, nil)
+}
+
+// run writes queued entries until the queue is closed and drained
+func (l *AsyncLogger) run() {
+	defer close(l.done)
+
+	var ticks <-chan time.Time
+	if l.warnInterval > 0 {
+		ticker := time.NewTicker(l.warnInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case entry, ok := <-l.entries:
+			if !ok {
+				l.reportDropped()
+				return
+			}
+			l.write(entry)
+		case <-ticks:
+			l.reportDropped()
+		}
+	}
+}
+
+// write passes an entry to the wrapped logger
+func (l *AsyncLogger) write(entry asyncLogEntry) {
+	if entry.flushed != nil {
+		close(entry.flushed)
+		return
+	}
+
+	switch entry.level {
+	case Debug:
+		l.inner.Debug(entry.ctx, entry.message, entry.fields)
+	case Info:
+		l.inner.Info(entry.ctx, entry.message, entry.fields)
+	case Warn:
+		l.inner.Warn(entry.ctx, entry.message, entry.fields)
+	default:
+		l.inner.Error(entry.ctx, entry.message, entry.err, entry.fields)
+	}
+}
+
+// reportDropped logs a warning when entries were dropped since the last report
+func (l *AsyncLogger) reportDropped() {
+	dropped := l.dropped.Load()
+	if dropped == l.reported {
+		return
+	}
+
+	l.inner.Warn(context.Background(), "Async logger dropped entries", map[string]interface{}{
+		"dropped":       dropped - l.reported,
+		"dropped_total": dropped,
+	})
+	l.reported = dropped
+}
@@ -0,0 +1,207 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingLogger holds every entry until release is closed
+type blockingLogger struct {
+	captureLogger
+	release chan struct{}
+}
+
+func newBlockingLogger() *blockingLogger {
+	return &blockingLogger{release: make(chan struct{})}
+}
+
+func (l *blockingLogger) Info(ctx context.Context, message string, fields map[string]interface{}) {
+	<-l.release
+	l.captureLogger.Info(ctx, message, fields)
+}
+
+func TestAsyncLoggerOrderingPerGoroutine(t *testing.T) {
+	inner := &captureLogger{}
+	logger := NewAsyncLogger(inner, 10000)
+
+	const goroutines, entries = 8, 500
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < entries; i++ {
+				logger.Info(context.Background(), "entry", map[string]interface{}{"goroutine": g, "seq": i})
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := logger.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	next := make(map[int]int)
+	for _, entry := range inner.all() {
+		g, seq := entry.fields["goroutine"].(int), entry.fields["seq"].(int)
+		if seq != next[g] {
+			t.Fatalf("goroutine %d: entry %d written before %d", g, seq, next[g])
+		}
+		next[g]++
+	}
+	for g := 0; g < goroutines; g++ {
+		if next[g] != entries {
+			t.Fatalf("goroutine %d: %d of %d entries written", g, next[g], entries)
+		}
+	}
+}
+
+func TestAsyncLoggerDropsWhenFull(t *testing.T) {
+	inner := newBlockingLogger()
+	metrics := newRecordingMetrics()
+	logger := NewAsyncLogger(inner, 4, WithDropMetrics(metrics), WithDropWarningInterval(0))
+
+	// The writer holds one entry and the buffer four more
+	for i := 0; i < 20; i++ {
+		logger.Info(context.Background(), "entry", map[string]interface{}{"seq": i})
+	}
+	dropped := logger.Dropped()
+	if dropped < 15 || dropped > 16 {
+		t.Fatalf("%d entries dropped, want 15 or 16", dropped)
+	}
+	if got := metrics.counter(MetricLogEntriesDropped); uint64(got) != dropped {
+		t.Fatalf("drop metric %d, want %d", got, dropped)
+	}
+
+	close(inner.release)
+	if err := logger.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close reports the drops that no periodic warning covered
+	warning, ok := inner.find("dropped entries")
+	if !ok || warning.fields["dropped_total"] != dropped {
+		t.Fatalf("drop warning %+v", warning)
+	}
+	if written := len(inner.at("info")); uint64(written)+dropped != 20 {
+		t.Fatalf("%d written and %d dropped of 20", written, dropped)
+	}
+}
+
+func TestAsyncLoggerPeriodicDropWarning(t *testing.T) {
+	inner := newBlockingLogger()
+	logger := NewAsyncLogger(inner, 1, WithDropWarningInterval(10*time.Millisecond))
+	defer logger.Close(context.Background())
+
+	for i := 0; i < 5; i++ {
+		logger.Info(context.Background(), "entry", nil)
+	}
+	close(inner.release)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := inner.find("dropped entries"); ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("no drop warning while the logger was running")
+}
+
+func TestAsyncLoggerErrorBypass(t *testing.T) {
+	inner := newBlockingLogger()
+	logger := NewAsyncLogger(inner, 1, WithErrorBypass())
+
+	// Errors are written even while the queue is stuck and full
+	logger.Info(context.Background(), "stuck", nil)
+	logger.Info(context.Background(), "queued", nil)
+	logger.Info(context.Background(), "dropped", nil)
+	logger.Error(context.Background(), "failure", errors.New("boom"), nil)
+
+	if errs := inner.at("error"); len(errs) != 1 || errs[0].message != "failure" {
+		t.Fatalf("errors %+v, want the bypassed failure", errs)
+	}
+
+	close(inner.release)
+	logger.Close(context.Background())
+}
+
+func TestAsyncLoggerFlush(t *testing.T) {
+	inner := &captureLogger{}
+	logger := NewAsyncLogger(inner, 100)
+	defer logger.Close(context.Background())
+
+	for i := 0; i < 50; i++ {
+		logger.Info(context.Background(), "entry", nil)
+	}
+	if err := logger.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(inner.all()); n != 50 {
+		t.Fatalf("%d entries written after Flush, want 50", n)
+	}
+}
+
+func TestAsyncLoggerCloseDeadline(t *testing.T) {
+	inner := newBlockingLogger()
+	logger := NewAsyncLogger(inner, 10)
+	logger.Info(context.Background(), "stuck", nil)
+	logger.Info(context.Background(), "queued", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := logger.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close with a stuck writer: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Close returned after %v", elapsed)
+	}
+
+	// Entries after Close are dropped, not sent on the closed queue
+	logger.Info(context.Background(), "late", nil)
+	if logger.Dropped() != 1 {
+		t.Fatalf("%d dropped after Close, want 1", logger.Dropped())
+	}
+	if err := logger.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush after Close: %v", err)
+	}
+
+	close(inner.release)
+	if err := logger.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(inner.at("info")); n != 2 {
+		t.Fatalf("%d entries written, want both queued before Close", n)
+	}
+}
+
+func BenchmarkLoggerInfo(b *testing.B) {
+	fields := map[string]interface{}{"token": "****0001", "amount": 100000, "status": "INIT"}
+
+	b.Run("sync", func(b *testing.B) {
+		logger := NewDefaultLoggerWithOutput("INFO", io.Discard, io.Discard)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.Info(context.Background(), "Payment initialized", fields)
+		}
+	})
+
+	b.Run("async", func(b *testing.B) {
+		logger := NewAsyncLogger(NewDefaultLoggerWithOutput("INFO", io.Discard, io.Discard), b.N+1)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info(context.Background(), "Payment initialized", fields)
+		}
+		b.StopTimer()
+		logger.Close(context.Background())
+		if logger.Dropped() != 0 {
+			b.Fatalf("%d entries dropped", logger.Dropped())
+		}
+	})
+}
@@ -0,0 +1,205 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// backoff.go implements the strategies deciding how long to wait between retries
+package vandargo
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// defaultMaxBackoff caps the default exponential retry backoff
+const defaultMaxBackoff = time.Minute
+
+// backoffOverrideKey stores a per-call backoff strategy in the context
+const backoffOverrideKey contextKey = "backoff_override"
+
+// BackoffStrategy decides how long to wait before the next retry. NextDelay is
+// called with the number of attempts made so far, starting at 1, and the error of
+// the last one; done reports that no further retry should be made.
+type BackoffStrategy interface {
+	NextDelay(attempt int, lastErr error) (delay time.Duration, done bool)
+}
+
+// backoffSequencer is implemented by strategies that keep state between the
+// retries of one operation; each operation gets its own sequence
+type backoffSequencer interface {
+	newSequence() BackoffStrategy
+}
+
+// backoffSequence returns the strategy to use for one retry loop
+func backoffSequence(strategy BackoffStrategy) BackoffStrategy {
+	if sequencer, ok := strategy.(backoffSequencer); ok {
+		return sequencer.newSequence()
+	}
+	return strategy
+}
+
+// FixedBackoff waits the same delay before every retry
+type FixedBackoff struct {
+	// Delay is the wait before each retry
+	Delay time.Duration
+
+	// Jitter randomizes each delay by up to this fraction, e.g. 0.2 for ±20%
+	Jitter float64
+
+	// MaxRetries stops retrying after this many retries; zero leaves the limit to the caller
+	MaxRetries int
+}
+
+// NewFixedBackoff creates a strategy waiting delay before every retry
+func NewFixedBackoff(delay time.Duration) *FixedBackoff {
+	return &FixedBackoff{Delay: delay}
+}
+
+// NextDelay returns the fixed delay
+func (b *FixedBackoff) NextDelay(attempt int, lastErr error) (time.Duration, bool) {
+	if b.MaxRetries > 0 && attempt > b.MaxRetries {
+		return 0, true
+	}
+	return jitterInterval(b.Delay, b.Jitter), false
+}
+
+// ExponentialBackoff multiplies the delay after every attempt, up to a maximum
+type ExponentialBackoff struct {
+	// Base is the wait before the first retry
+	Base time.Duration
+
+	// Max caps the wait; zero means no cap
+	Max time.Duration
+
+	// Multiplier is the growth factor between retries (2 when not above 1)
+	Multiplier float64
+
+	// MaxRetries stops retrying after this many retries; zero leaves the limit to the caller
+	MaxRetries int
+}
+
+// NewExponentialBackoff creates a strategy waiting base before the first retry
+// and multiplier times longer before each following one, up to max
+func NewExponentialBackoff(base, max time.Duration, multiplier float64) *ExponentialBackoff {
+	return &ExponentialBackoff{Base: base, Max: max, Multiplier: multiplier}
+}
+
+// NextDelay returns base * multiplier^(attempt-1), capped at the maximum
+func (b *ExponentialBackoff) NextDelay(attempt int, lastErr error) (time.Duration, bool) {
+	if b.MaxRetries > 0 && attempt > b.MaxRetries {
+		return 0, true
+	}
+	if b.Base <= 0 {
+		return 0, false
+	}
+
+	multiplier := b.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+
+	delay := float64(b.Base) * math.Pow(multiplier, float64(attempt-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		return b.Max, false
+	}
+	if delay > math.MaxInt64 {
+		return time.Duration(math.MaxInt64), false
+	}
+
+	return time.Duration(delay), false
+}
+
+// DecorrelatedJitter waits a random delay between base and three times the
+// previous delay, capped at a maximum. Retries of many clients spread out instead
+// of arriving in waves, which suits batch jobs.
+type DecorrelatedJitter struct {
+	// Base is the minimum wait
+	Base time.Duration
+
+	// Max caps the wait; zero means no cap
+	Max time.Duration
+
+	// MaxRetries stops retrying after this many retries; zero leaves the limit to the caller
+	MaxRetries int
+
+	random *lockedRand
+	prev   time.Duration
+}
+
+// NewDecorrelatedJitter creates a decorrelated jitter strategy. The random source
+// may be seeded for reproducible delays; nil uses a time-seeded one.
+func NewDecorrelatedJitter(base, max time.Duration, random *rand.Rand) *DecorrelatedJitter {
+	if random == nil {
+		random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return &DecorrelatedJitter{Base: base, Max: max, random: &lockedRand{random: random}}
+}
+
+// NextDelay returns a random delay between base and three times the previous one
+func (b *DecorrelatedJitter) NextDelay(attempt int, lastErr error) (time.Duration, bool) {
+	if b.MaxRetries > 0 && attempt > b.MaxRetries {
+		return 0, true
+	}
+	if b.Base <= 0 {
+		return 0, false
+	}
+	if b.random == nil {
+		b.random = &lockedRand{random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	}
+
+	prev := b.prev
+	if attempt <= 1 || prev < b.Base {
+		prev = b.Base
+	}
+
+	upper := 3 * prev
+	if b.Max > 0 && upper > b.Max {
+		upper = b.Max
+	}
+
+	delay := b.Base
+	if upper > b.Base {
+		delay += time.Duration(b.random.int63n(int64(upper - b.Base)))
+	}
+
+	b.prev = delay
+	return delay, false
+}
+
+// newSequence returns a copy tracking its own previous delay and sharing the random source
+func (b *DecorrelatedJitter) newSequence() BackoffStrategy {
+	sequence := *b
+	sequence.prev = 0
+	return &sequence
+}
+
+// lockedRand is a random source safe for concurrent sequences
+type lockedRand struct {
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+// int63n returns a random number in [0, n)
+func (r *lockedRand) int63n(n int64) int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.random.Int63n(n)
+}
+
+// WithBackoff returns a context that overrides the client's retry backoff for
+// client calls made with it
+func WithBackoff(ctx context.Context, strategy BackoffStrategy) context.Context {
+	return context.WithValue(ctx, backoffOverrideKey, strategy)
+}
+
+// retryStrategy returns the backoff of a call: the context override, the client's
+// strategy, or exponential backoff from Config.RetryWaitTime
+func (c *Client) retryStrategy(ctx context.Context) BackoffStrategy {
+	if strategy, ok := ctx.Value(backoffOverrideKey).(BackoffStrategy); ok && strategy != nil {
+		return backoffSequence(strategy)
+	}
+	if c.backoff != nil {
+		return backoffSequence(c.backoff)
+	}
+	return NewExponentialBackoff(configValues(c.config).RetryWaitTime, defaultMaxBackoff, 2)
+}
@@ -0,0 +1,210 @@
+package vandargo
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingBackoff records the attempts it is asked about and never waits
+type recordingBackoff struct {
+	mutex    sync.Mutex
+	attempts []int
+}
+
+func (b *recordingBackoff) NextDelay(attempt int, lastErr error) (time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.attempts = append(b.attempts, attempt)
+	return 0, false
+}
+
+// delays returns the first n delays of a strategy
+func delays(strategy BackoffStrategy, n int) []time.Duration {
+	var sequence []time.Duration
+	for attempt := 1; attempt <= n; attempt++ {
+		delay, done := strategy.NextDelay(attempt, nil)
+		if done {
+			break
+		}
+		sequence = append(sequence, delay)
+	}
+	return sequence
+}
+
+func TestFixedBackoff(t *testing.T) {
+	backoff := NewFixedBackoff(200 * time.Millisecond)
+	backoff.MaxRetries = 3
+	want := []time.Duration{200 * time.Millisecond, 200 * time.Millisecond, 200 * time.Millisecond}
+	if got := delays(backoff, 10); !reflect.DeepEqual(got, want) {
+		t.Fatalf("delays %v, want %v", got, want)
+	}
+
+	// Jitter stays within its fraction
+	jittered := &FixedBackoff{Delay: time.Second, Jitter: 0.2}
+	for _, delay := range delays(jittered, 100) {
+		if delay < 800*time.Millisecond || delay > 1200*time.Millisecond {
+			t.Fatalf("jittered delay %v outside ±20%%", delay)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff *ExponentialBackoff
+		want    []time.Duration
+	}{
+		{
+			"doubling up to the cap",
+			NewExponentialBackoff(100*time.Millisecond, time.Second, 2),
+			[]time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			"multiplier 1.5",
+			NewExponentialBackoff(time.Second, 0, 1.5),
+			[]time.Duration{time.Second, 1500 * time.Millisecond, 2250 * time.Millisecond, 3375 * time.Millisecond},
+		},
+		{
+			"multiplier defaults to 2",
+			NewExponentialBackoff(time.Second, 0, 1),
+			[]time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			"retry limit",
+			&ExponentialBackoff{Base: time.Second, MaxRetries: 2},
+			[]time.Duration{time.Second, 2 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := delays(tt.backoff, len(tt.want)+2)[:len(tt.want)]; !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("delays %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Late attempts saturate instead of overflowing
+	if delay, _ := NewExponentialBackoff(time.Second, 0, 10).NextDelay(100, nil); delay <= 0 {
+		t.Fatalf("attempt 100 delay %v", delay)
+	}
+}
+
+func TestDecorrelatedJitterSeeded(t *testing.T) {
+	const base, max = 100 * time.Millisecond, 5 * time.Second
+
+	// The sequence for seed 42 replays the documented formula with the same source
+	random := rand.New(rand.NewSource(42))
+	var want []time.Duration
+	prev := base
+	for i := 0; i < 20; i++ {
+		upper := 3 * prev
+		if upper > max {
+			upper = max
+		}
+		delay := base + time.Duration(random.Int63n(int64(upper-base)))
+		want = append(want, delay)
+		prev = delay
+	}
+
+	got := delays(NewDecorrelatedJitter(base, max, rand.New(rand.NewSource(42))), 20)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("seeded delays\n%v\nwant\n%v", got, want)
+	}
+	if again := delays(NewDecorrelatedJitter(base, max, rand.New(rand.NewSource(42))), 20); !reflect.DeepEqual(again, got) {
+		t.Fatal("one seed produced two sequences")
+	}
+	if other := delays(NewDecorrelatedJitter(base, max, rand.New(rand.NewSource(7))), 20); reflect.DeepEqual(other, got) {
+		t.Fatal("different seeds produced one sequence")
+	}
+
+	// Every delay lies between base and three times the previous one, capped
+	prev = base
+	for i, delay := range got {
+		upper := 3 * prev
+		if upper > max {
+			upper = max
+		}
+		if delay < base || delay > upper {
+			t.Fatalf("delay %d is %v, want within [%v, %v]", i, delay, base, upper)
+		}
+		prev = delay
+	}
+}
+
+func TestDecorrelatedJitterSequences(t *testing.T) {
+	shared := NewDecorrelatedJitter(time.Second, time.Minute, rand.New(rand.NewSource(1)))
+	shared.MaxRetries = 5
+
+	// Each retry loop starts from the base delay and stops at the retry limit
+	for i := 0; i < 3; i++ {
+		sequence := backoffSequence(shared)
+		if sequence == BackoffStrategy(shared) {
+			t.Fatal("retry loops share the strategy's state")
+		}
+		got := delays(sequence, 10)
+		if len(got) != 5 || got[0] > 3*time.Second {
+			t.Fatalf("sequence %d: %v", i, got)
+		}
+	}
+
+	// Stateless strategies are used as they are
+	fixed := NewFixedBackoff(time.Second)
+	if backoffSequence(fixed) != BackoffStrategy(fixed) {
+		t.Fatal("fixed backoff was copied")
+	}
+}
+
+func TestRetryStrategySelection(t *testing.T) {
+	failing := func() *stubTransport {
+		return newStubTransport(
+			stubStep{status: http.StatusServiceUnavailable, body: `{"message":"unavailable"}`},
+			stubStep{status: http.StatusServiceUnavailable, body: `{"message":"unavailable"}`},
+			jsonStep(http.StatusOK, map[string]interface{}{"status": 1}),
+		)
+	}
+	clientBackoff := &recordingBackoff{}
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) { c.MaxRetries = 3 }), failing(), WithClientBackoff(clientBackoff))
+
+	// The client option drives the retry loop
+	if _, _, err := client.makeRequest(context.Background(), http.MethodGet, client.statusEndpoint("tok"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(clientBackoff.attempts, []int{1, 2}) {
+		t.Fatalf("client backoff asked about attempts %v", clientBackoff.attempts)
+	}
+
+	// A per-call override wins over the client option
+	callBackoff := &recordingBackoff{}
+	client = client.WithHTTPClient(failing())
+	if _, _, err := client.makeRequest(WithBackoff(context.Background(), callBackoff), http.MethodGet, client.statusEndpoint("tok"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(callBackoff.attempts, []int{1, 2}) || len(clientBackoff.attempts) != 2 {
+		t.Fatalf("call backoff asked about %v, client backoff about %v", callBackoff.attempts, clientBackoff.attempts)
+	}
+
+	// A strategy that is done stops retrying
+	done := &FixedBackoff{MaxRetries: 1}
+	transport := failing()
+	client = client.WithHTTPClient(transport)
+	if _, _, err := client.makeRequest(WithBackoff(context.Background(), done), http.MethodGet, client.statusEndpoint("tok"), nil); err == nil {
+		t.Fatal("request succeeded after the strategy gave up")
+	}
+	if transport.count() != 2 {
+		t.Fatalf("%d requests, want one retry", transport.count())
+	}
+
+	// Without a strategy the client backs off exponentially from RetryWaitTime
+	defaults, _, _ := newTestClient(t, testConfig(t, func(c *Config) { c.RetryWaitTime = 250 * time.Millisecond }), nil)
+	want := []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, time.Second}
+	if got := delays(defaults.retryStrategy(context.Background()), 3); !reflect.DeepEqual(got, want) {
+		t.Fatalf("default delays %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// balance.go implements the business wallet balance lookup
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// WalletBalance is the balance of the business wallet
+type WalletBalance struct {
+	// Status indicates if the lookup was successful
+	Status bool `json:"status"`
+
+	// Balance is the wallet balance in Rials
+	Balance FlexibleAmount `json:"balance"`
+
+	// BlockedBalance is the part of the wallet balance that can't be spent yet
+	BlockedBalance FlexibleAmount `json:"blocked_balance,omitempty"`
+
+	// Message contains any message from the API
+	Message string `json:"message,omitempty"`
+}
+
+// Available returns the part of the balance that can be spent, e.g. on refunds
+func (b *WalletBalance) Available() int64 {
+	available := b.Balance.Int64() - b.BlockedBalance.Int64()
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// GetWalletBalance returns the balance of the business wallet, which refunds and
+// transfers are paid from
+func (c *Client) GetWalletBalance(ctx context.Context) (*WalletBalance, error) {
+	ctx, cancel := c.operationContext(ctx, operationStatus)
+	defer cancel()
+
+	respBody, _, err := c.makeRequest(ctx, http.MethodGet, c.balanceEndpoint(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wallet balance: %w", err)
+	}
+
+	var balance WalletBalance
+	if err := json.Unmarshal(respBody, &balance); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+	if !balance.Status {
+		return nil, fmt.Errorf("wallet balance lookup failed: %s", balance.Message)
+	}
+
+	return &balance, nil
+}
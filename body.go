@@ -0,0 +1,125 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// body.go implements request body buffering shared by middleware and handlers
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	// DefaultMaxBodyBytes is the request body limit used by the registered routes
+	DefaultMaxBodyBytes = 1 << 20
+
+	// maxLoggedBodyBytes caps the request body included in error logs
+	maxLoggedBodyBytes = 2048
+)
+
+// requestBodyKey is the context key for the buffered request body
+const requestBodyKey contextKey = "request_body"
+
+// BufferBodyMiddleware reads the request body once, up to maxBytes, and stores it in
+// the request context so several components can consume it. r.Body is replaced with
+// a reader over the buffered bytes. Larger bodies are rejected with 413.
+func BufferBodyMiddleware(maxBytes int64) Middleware {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBodyBytes
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			// Nothing to buffer, or an earlier middleware already did
+			if r.Body == nil || r.Body == http.NoBody {
+				next(w, r)
+				return
+			}
+			if _, ok := bufferedBody(r); ok {
+				next(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+			r.Body.Close()
+			if err != nil {
+				writeJSONError(w, r, http.StatusBadRequest, ErrInvalidRequest, "Failed to read request body")
+				return
+			}
+			if int64(len(body)) > maxBytes {
+				writeJSONError(w, r, http.StatusRequestEntityTooLarge, ErrInvalidRequest, "Request body too large")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), requestBodyKey, body)
+			r = r.WithContext(ctx)
+			rewindBody(r)
+
+			next(w, r)
+		}
+	}
+}
+
+// bufferedBody returns the body stored by BufferBodyMiddleware
+func bufferedBody(r *http.Request) ([]byte, bool) {
+	body, ok := r.Context().Value(requestBodyKey).([]byte)
+	return body, ok
+}
+
+// rewindBody resets r.Body to the start of the buffered body, if there is one
+func rewindBody(r *http.Request) {
+	body, ok := bufferedBody(r)
+	if !ok {
+		return
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.ContentLength = int64(len(body))
+}
+
+// readBody returns the request body, using the buffered copy when available and
+// reading r.Body directly otherwise
+func readBody(r *http.Request) ([]byte, error) {
+	if body, ok := bufferedBody(r); ok {
+		return body, nil
+	}
+
+	if r.Body == nil {
+		return nil, nil
+	}
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, DefaultMaxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > DefaultMaxBodyBytes {
+		return nil, fmt.Errorf("request body exceeds %d bytes", DefaultMaxBodyBytes)
+	}
+
+	return body, nil
+}
+
+// loggableBody returns a redacted, truncated copy of the buffered request body
+func loggableBody(r *http.Request) (string, bool) {
+	body, ok := bufferedBody(r)
+	if !ok || len(body) == 0 {
+		return "", false
+	}
+
+	truncated := len(body) > maxLoggedBodyBytes
+	if truncated {
+		body = body[:maxLoggedBodyBytes]
+	}
+
+	logged := redactBody(string(body))
+	if truncated {
+		logged += "...(truncated)"
+	}
+
+	return logged, true
+}
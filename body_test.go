@@ -0,0 +1,138 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBufferBodyMiddleware(t *testing.T) {
+	const body = `{"token":"` + webhookToken + `"}`
+
+	// An earlier reader drains r.Body; the handler still sees the whole body
+	var handled []string
+	drain := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			next(w, r)
+		}
+	}
+	handler := Chain(func(w http.ResponseWriter, r *http.Request) {
+		buffered, err := readBody(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rewindBody(r)
+		again, _ := io.ReadAll(r.Body)
+		handled = append(handled, string(buffered), string(again))
+	}, BufferBodyMiddleware(64), drain)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/payments/verify", strings.NewReader(body)))
+	if len(handled) != 2 || handled[0] != body || handled[1] != body {
+		t.Fatalf("handler read %q", handled)
+	}
+
+	// Bodies over the limit never reach the handler
+	handled = nil
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/payments/verify", strings.NewReader(strings.Repeat("x", 65))))
+	if rec.Code != http.StatusRequestEntityTooLarge || handled != nil {
+		t.Fatalf("oversized body: status %d, handled %q", rec.Code, handled)
+	}
+
+	// A body of exactly the limit is accepted
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/payments/verify", strings.NewReader(strings.Repeat("x", 64))))
+	if rec.Code != http.StatusOK || len(handled) != 2 {
+		t.Fatalf("body at the limit: status %d", rec.Code)
+	}
+}
+
+func TestHandlersWithoutBufferedBody(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(WithSimulatorPaidAfter(0)))
+
+	// The handlers are called directly, with none of the route middleware
+	call := func(handler http.HandlerFunc, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := call(client.handlePaymentInit, "application/json", `{"amount":100000,"callback_url":"https://shop.example.com/callback"}`)
+	var initResp PaymentInitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &initResp); err != nil || rec.Code != http.StatusOK || initResp.Token == "" {
+		t.Fatalf("init: status %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := call(client.handleCallback, formContentType, "token="+initResp.Token+"&status=OK"); rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := call(client.handlePaymentVerify, "application/json", `{"token":"`+initResp.Token+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("verify: status %d: %s", rec.Code, rec.Body)
+	}
+
+	transaction, err := storage.GetTransaction(context.Background(), initResp.Token)
+	if err != nil || transaction.Status != StatusPaid {
+		t.Fatalf("transaction %+v, %v", transaction, err)
+	}
+
+	// The default limit still applies to unbuffered bodies
+	oversized := `{"description":"` + strings.Repeat("x", DefaultMaxBodyBytes) + `"}`
+	if rec := call(client.handlePaymentInit, "application/json", oversized); rec.Code != http.StatusBadRequest {
+		t.Fatalf("oversized init: status %d", rec.Code)
+	}
+}
+
+func TestLoggingMiddlewareClientErrorBodies(t *testing.T) {
+	const body = `{"amount":10,"valid_card_number":"` + fullCardNumber + `","token":"` + webhookToken + `"}`
+
+	tests := []struct {
+		name     string
+		buffered bool
+		status   int
+		logged   bool
+	}{
+		{"client error", true, http.StatusUnprocessableEntity, true},
+		{"success", true, http.StatusOK, false},
+		{"server error", true, http.StatusInternalServerError, false},
+		{"unbuffered", false, http.StatusUnprocessableEntity, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &captureLogger{}
+			// The body is buffered outside the logger, as in the route chains
+			var middlewares []Middleware
+			if tt.buffered {
+				middlewares = append(middlewares, BufferBodyMiddleware(0))
+			}
+			middlewares = append(middlewares, LoggingMiddleware(logger, WithClientErrorBodies()))
+			handler := Chain(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				w.WriteHeader(tt.status)
+			}, middlewares...)
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/payments/init", strings.NewReader(body)))
+
+			entry, ok := logger.find("HTTP Request")
+			if !ok {
+				t.Fatal("request not logged")
+			}
+			logged, ok := entry.fields["request_body"].(string)
+			if ok != tt.logged {
+				t.Fatalf("request_body logged = %v, want %v", ok, tt.logged)
+			}
+			if ok && (leaksCardNumber(logged) || strings.Contains(logged, webhookToken) || !strings.Contains(logged, `"amount":10`)) {
+				t.Fatalf("request_body = %s", logged)
+			}
+		})
+	}
+}
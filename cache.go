@@ -0,0 +1,171 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// cache.go implements response caching for transaction info and status lookups
+package vandargo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCacheTTL is used when Config.CacheTTL is not set
+	defaultCacheTTL = 3 * time.Second
+
+	// defaultVerifyMemoTTL is used when Config.VerifyMemoTTL is not set
+	defaultVerifyMemoTTL = 30 * time.Second
+
+	// cacheKindTransactionInfo prefixes cached transaction info responses
+	cacheKindTransactionInfo = "transaction_info"
+
+	// cacheKindPaymentStatus prefixes cached payment status responses
+	cacheKindPaymentStatus = "payment_status"
+)
+
+// cacheEntry holds a cached value and its expiry
+type cacheEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryCache is a simple in-memory implementation of CacheInterface with per-entry TTL
+type MemoryCache struct {
+	entries map[string]cacheEntry
+	mutex   sync.RWMutex
+
+	// clock decides when entries expire
+	clock Clock
+}
+
+// MemoryCacheOption configures a MemoryCache
+type MemoryCacheOption func(*MemoryCache)
+
+// WithCacheClock sets the clock entries expire on (RealClock by default)
+func WithCacheClock(clock Clock) MemoryCacheOption {
+	return func(m *MemoryCache) {
+		if clock != nil {
+			m.clock = clock
+		}
+	}
+}
+
+// NewMemoryCache creates a new in-memory cache
+func NewMemoryCache(opts ...MemoryCacheOption) *MemoryCache {
+	m := &MemoryCache{
+		entries: make(map[string]cacheEntry),
+		clock:   RealClock(),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Get returns the cached value for a key and whether it was found
+func (m *MemoryCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	m.mutex.RLock()
+	entry, exists := m.entries[key]
+	m.mutex.RUnlock()
+
+	if !exists {
+		return nil, false
+	}
+
+	// Drop expired entries lazily
+	if !entry.expiresAt.IsZero() && m.clock.Now().After(entry.expiresAt) {
+		m.Delete(ctx, key)
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+// Set stores a value for a key; a ttl of zero or less keeps it until deleted
+func (m *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	entry := cacheEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = m.clock.Now().Add(ttl)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries[key] = entry
+}
+
+// Delete removes a key from the cache
+func (m *MemoryCache) Delete(ctx context.Context, key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.entries, key)
+}
+
+// cachedFetch returns the cached response body for a token or fetches it once for
+// all concurrent callers. Responses for transactions known to be terminal are cached
+// without expiry; terminal may be nil when the response itself carries no status.
+func (c *Client) cachedFetch(ctx context.Context, kind, token string, fetch func(context.Context) ([]byte, error), terminal func([]byte) bool) ([]byte, error) {
+	key := kind + ":" + token
+	labels := map[string]string{"kind": kind}
+
+	if c.cache != nil {
+		if body, found := c.cache.Get(ctx, key); found {
+			c.metrics.IncCounter(MetricCacheHits, labels)
+			return body, nil
+		}
+		c.metrics.IncCounter(MetricCacheMisses, labels)
+	}
+
+	val, err, _ := c.flights.Do(key, func() (interface{}, error) {
+		body, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.cache != nil {
+			ttl := c.cacheTTL()
+			if (terminal != nil && terminal(body)) || c.isStoredTerminal(ctx, token) {
+				ttl = 0
+			}
+			c.cache.Set(ctx, key, body, ttl)
+		}
+
+		return body, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return val.([]byte), nil
+}
+
+// invalidateCache removes cached responses for a token after its state changed
+func (c *Client) invalidateCache(ctx context.Context, token string) {
+	if c.cache == nil {
+		return
+	}
+
+	c.cache.Delete(ctx, cacheKindTransactionInfo+":"+token)
+	c.cache.Delete(ctx, cacheKindPaymentStatus+":"+token)
+}
+
+// cacheTTL returns the configured cache lifetime for non-terminal responses
+func (c *Client) cacheTTL() time.Duration {
+	if ttl := configValues(c.config).CacheTTL; ttl > 0 {
+		return ttl
+	}
+
+	return defaultCacheTTL
+}
+
+// isStoredTerminal reports whether the locally stored transaction is in a terminal state
+func (c *Client) isStoredTerminal(ctx context.Context, token string) bool {
+	transaction, err := c.storage.GetTransaction(ctx, token)
+	if err != nil {
+		return false
+	}
+
+	return transaction.Status.IsTerminal()
+}
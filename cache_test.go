@@ -0,0 +1,158 @@
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statusGateway answers status lookups with a transaction status, counting them
+func statusGateway(status *atomic.Value, calls *atomic.Int32, delay time.Duration) transportFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		time.Sleep(delay)
+		return stubResponse(req, http.StatusOK, map[string]interface{}{
+			"status": true, "amount": 100000, "transactionStatus": status.Load(),
+		}), nil
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	cache := NewMemoryCache(WithCacheClock(clock))
+	ctx := context.Background()
+
+	cache.Set(ctx, "short", []byte("a"), time.Second)
+	cache.Set(ctx, "forever", []byte("b"), 0)
+	if value, found := cache.Get(ctx, "short"); !found || string(value) != "a" {
+		t.Fatalf("Get(short) = %q, %v", value, found)
+	}
+
+	clock.Advance(time.Second + time.Nanosecond)
+	if _, found := cache.Get(ctx, "short"); found {
+		t.Fatal("expired entry returned")
+	}
+	if _, found := cache.Get(ctx, "forever"); !found {
+		t.Fatal("entry without a TTL expired")
+	}
+
+	cache.Delete(ctx, "forever")
+	if _, found := cache.Get(ctx, "forever"); found {
+		t.Fatal("deleted entry returned")
+	}
+}
+
+func TestCachedStatusTTL(t *testing.T) {
+	var status atomic.Value
+	status.Store("INIT")
+	var calls atomic.Int32
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	metrics := newRecordingMetrics()
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) { c.CacheTTL = 2 * time.Second }), statusGateway(&status, &calls, 0),
+		WithClientCache(NewMemoryCache(WithCacheClock(clock))), WithClientClock(clock), WithClientMetrics(metrics))
+	ctx := context.Background()
+
+	// Polls within the TTL are answered from the cache
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetPaymentStatus(ctx, webhookToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 1 || metrics.counter(MetricCacheHits) != 2 || metrics.counter(MetricCacheMisses) != 1 {
+		t.Fatalf("%d calls, %d hits, %d misses", calls.Load(), metrics.counter(MetricCacheHits), metrics.counter(MetricCacheMisses))
+	}
+
+	// Once it expires the gateway is asked again; a terminal status is kept
+	status.Store("PAID")
+	clock.Advance(3 * time.Second)
+	resp, _ := client.GetPaymentStatus(ctx, webhookToken)
+	if calls.Load() != 2 || resp.TransactionStatus != "PAID" {
+		t.Fatalf("%d calls, status %s after the TTL", calls.Load(), resp.TransactionStatus)
+	}
+	clock.Advance(24 * time.Hour)
+	client.GetPaymentStatus(ctx, webhookToken)
+	if calls.Load() != 2 {
+		t.Fatalf("terminal status fetched again: %d calls", calls.Load())
+	}
+}
+
+func TestCachedTransactionInfoTerminalStored(t *testing.T) {
+	var calls atomic.Int32
+	transport := transportFunc(func(req *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		return stubResponse(req, http.StatusOK, map[string]interface{}{"status": 1, "amount": "100000", "transId": 160000000001}), nil
+	})
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t), transport,
+		WithClientCache(NewMemoryCache(WithCacheClock(clock))), WithClientClock(clock))
+	ctx := context.Background()
+
+	// Info of a payment stored as paid doesn't change anymore
+	storeWebhookPayment(t, storage, StatusPaid)
+	client.GetTransactionInfo(ctx, webhookToken)
+	clock.Advance(time.Hour)
+	client.GetTransactionInfo(ctx, webhookToken)
+	if calls.Load() != 1 {
+		t.Fatalf("%d calls for a paid payment", calls.Load())
+	}
+
+	// Until it is invalidated
+	client.invalidateCache(ctx, webhookToken)
+	client.GetTransactionInfo(ctx, webhookToken)
+	if calls.Load() != 2 {
+		t.Fatalf("%d calls after invalidation", calls.Load())
+	}
+}
+
+func TestCacheInvalidatedByVerify(t *testing.T) {
+	sim := NewSimulatorTransport(WithSimulatorPaidAfter(0))
+	var statusCalls atomic.Int32
+	transport := transportFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodGet {
+			statusCalls.Add(1)
+		}
+		return sim.Do(req)
+	})
+	client, _, _ := newTestClient(t, testConfig(t), transport, WithClientCache(NewMemoryCache()))
+	ctx := context.Background()
+
+	token := initSimulatedPayment(t, client)
+	client.GetPaymentStatus(ctx, token)
+	client.GetPaymentStatus(ctx, token)
+	if statusCalls.Load() != 1 {
+		t.Fatalf("%d status calls before verifying", statusCalls.Load())
+	}
+
+	if _, err := client.VerifyPayment(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	client.GetPaymentStatus(ctx, token)
+	if statusCalls.Load() != 2 {
+		t.Fatalf("stale status served after verifying: %d status calls", statusCalls.Load())
+	}
+}
+
+func TestCachedFetchCollapsesConcurrentLookups(t *testing.T) {
+	var status atomic.Value
+	status.Store("INIT")
+	var calls atomic.Int32
+	client, _, _ := newTestClient(t, testConfig(t), statusGateway(&status, &calls, 50*time.Millisecond), WithClientCache(NewMemoryCache()))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetPaymentStatus(context.Background(), webhookToken); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("%d gateway calls for concurrent lookups of one token", calls.Load())
+	}
+}
@@ -0,0 +1,144 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// callback_page.go implements browser-facing HTML result pages for the callback endpoint
+package vandargo
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// defaultCallbackTemplates holds the embedded success and failure pages
+var defaultCallbackTemplates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// CallbackPageData is the data passed to callback result templates
+type CallbackPageData struct {
+	// Success reports whether the payment succeeded
+	Success bool
+
+	// Status is the status reported by the gateway
+	Status string
+
+	// Amount is the payment amount in Rials, zero when unknown
+	Amount int64
+
+	// CardMask is the masked card number used for the payment
+	CardMask string
+
+	// RefNumber is the bank reference number
+	RefNumber string
+
+	// ReturnURL is the merchant URL with the payment token appended
+	ReturnURL string
+}
+
+// callbackSucceeded reports whether a callback status describes a successful payment
+func callbackSucceeded(status string) bool {
+	switch strings.ToUpper(strings.TrimSpace(status)) {
+	case "OK", "1", "PAID", "SUCCESS", "SUCCEED":
+		return true
+	default:
+		return false
+	}
+}
+
+// wantsHTML reports whether the callback response should be an HTML page
+func (c *Client) wantsHTML(r *http.Request) bool {
+	if configValues(c.config).CallbackHTML {
+		return true
+	}
+	return prefersHTML(r.Header.Get("Accept"))
+}
+
+// prefersHTML reports whether an Accept header ranks text/html above JSON
+func prefersHTML(accept string) bool {
+	htmlQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(raw, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		switch mediaType {
+		case "text/html", "application/xhtml+xml":
+			if q > htmlQ {
+				htmlQ = q
+			}
+		case "application/json":
+			if q > jsonQ {
+				jsonQ = q
+			}
+		}
+	}
+
+	return htmlQ > 0 && htmlQ > jsonQ
+}
+
+// callbackReturnURL appends the payment token to the configured return URL
+func (c *Client) callbackReturnURL(token string) string {
+	returnURL := configValues(c.config).ReturnURL
+	if returnURL == "" {
+		return ""
+	}
+
+	parsed, err := url.Parse(returnURL)
+	if err != nil {
+		return ""
+	}
+
+	query := parsed.Query()
+	query.Set("token", token)
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String()
+}
+
+// renderCallbackPage writes the success or failure page, reporting whether it succeeded
+func (c *Client) renderCallbackPage(w http.ResponseWriter, r *http.Request, data *CallbackPageData) bool {
+	values := configValues(c.config)
+
+	tmpl := values.CallbackFailureTemplate
+	name := "callback_failure.html"
+	if data.Success {
+		tmpl = values.CallbackSuccessTemplate
+		name = "callback_success.html"
+	}
+
+	// Render into a buffer so a template error can still fall back to JSON
+	var buf bytes.Buffer
+	var err error
+	if tmpl != nil {
+		err = tmpl.Execute(&buf, data)
+	} else {
+		err = defaultCallbackTemplates.ExecuteTemplate(&buf, name, data)
+	}
+	if err != nil {
+		c.log(r.Context()).Error(r.Context(), "Failed to render callback page", err, map[string]interface{}{
+			"template": name,
+		})
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		c.log(r.Context()).Error(r.Context(), "Failed to write response", err, nil)
+	}
+
+	return true
+}
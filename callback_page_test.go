@@ -0,0 +1,126 @@
+package vandargo
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// browserCallback sends a gateway callback for the token with an Accept header
+func browserCallback(handler http.Handler, status, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/payments/callback", strings.NewReader("token="+webhookToken+"&status="+status))
+	req.Header.Set("Content-Type", formContentType)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// storePagePayment stores the callback payment with details shown on the pages
+func storePagePayment(t *testing.T, storage *MemoryStorage, refNumber string) {
+	t.Helper()
+
+	err := storage.StoreTransaction(context.Background(), &Transaction{
+		ID: "tx-webhook", Token: webhookToken, Amount: 100000, Status: StatusInit,
+		CardNumber: "603799******7999", RefNumber: refNumber, CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrefersHTML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true},
+		{"application/json", false},
+		{"application/json, text/html;q=0.5", false},
+		{"text/html;q=0.9, application/json;q=0.5", true},
+		{"text/html;q=0", false},
+		{"*/*", false},
+		{"", false},
+		{"not a media type", false},
+	}
+	for _, tt := range tests {
+		if got := prefersHTML(tt.accept); got != tt.want {
+			t.Errorf("prefersHTML(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCallbackHTMLPages(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.ReturnURL = "https://shop.example.com/orders?from=vandar"
+	}), newStubTransport())
+	storePagePayment(t, storage, "ref-1")
+	handler := client.Handler()
+
+	rec := browserCallback(handler, "OK", "text/html")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"Payment successful", "100000 Rials", "603799******7999", "ref-1", `href="https://shop.example.com/orders?from=vandar&amp;token=` + webhookToken + `"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("success page lacks %q:\n%s", want, body)
+		}
+	}
+
+	// The same browser gets JSON when it asks for it
+	if rec := browserCallback(handler, "OK", "application/json"); !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("JSON callback answered %q", rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestCallbackHTMLFailurePage(t *testing.T) {
+	// The config flag forces pages whatever the Accept header
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) { c.CallbackHTML = true }), newStubTransport())
+	storePagePayment(t, storage, "")
+
+	rec := browserCallback(client.Handler(), "NOK", "application/json")
+	if body := rec.Body.String(); !strings.Contains(body, "<html") || strings.Contains(body, "Payment successful") || strings.Contains(body, "Return to store") {
+		t.Fatalf("failure page:\n%s", body)
+	}
+}
+
+func TestCallbackHTMLEscapes(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.ReturnURL = `https://shop.example.com/"><script>alert(2)</script>`
+	}), newStubTransport())
+	storePagePayment(t, storage, "<script>alert(1)</script>")
+
+	body := browserCallback(client.Handler(), "OK", "text/html").Body.String()
+	if strings.Contains(body, "<script>") || !strings.Contains(body, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Fatalf("injected values not escaped:\n%s", body)
+	}
+}
+
+func TestCallbackCustomTemplates(t *testing.T) {
+	success := template.Must(template.New("success").Parse(`<p>Paid {{.Amount}} with {{.RefNumber}}</p>`))
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) { c.CallbackSuccessTemplate = success }), newStubTransport())
+	storePagePayment(t, storage, "<b>ref</b>")
+
+	if body := browserCallback(client.Handler(), "OK", "text/html").Body.String(); body != "<p>Paid 100000 with &lt;b&gt;ref&lt;/b&gt;</p>" {
+		t.Fatalf("custom page %q", body)
+	}
+
+	// A template failing to render falls back to JSON
+	broken := template.Must(template.New("broken").Parse(`{{.Missing}}`))
+	client, storage, logger := newTestClient(t, testConfig(t, func(c *Config) { c.CallbackFailureTemplate = broken }), newStubTransport())
+	storePagePayment(t, storage, "")
+	rec := browserCallback(client.Handler(), "NOK", "text/html")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("broken template: status %d, content type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	if _, found := logger.find("Failed to render callback page"); !found {
+		t.Fatalf("render failure not logged:\n%s", logger.dump())
+	}
+}
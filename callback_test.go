@@ -0,0 +1,138 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// callbackCounts counts the lifecycle hooks fired for callbacks
+type callbackCounts struct {
+	callbacks     atomic.Int32
+	statusChanges atomic.Int32
+}
+
+func (c *callbackCounts) hooks() Hooks {
+	return Hooks{
+		OnCallback: func(ctx context.Context, transaction *Transaction, data *CallbackData) {
+			c.callbacks.Add(1)
+		},
+		OnStatusChange: func(ctx context.Context, transaction *Transaction, from, to TransactionStatus) {
+			c.statusChanges.Add(1)
+		},
+	}
+}
+
+// sendCallback posts a gateway callback for the token to handler and decodes the answer
+func sendCallback(t *testing.T, handler http.HandlerFunc, token, status string) map[string]interface{} {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/callback", strings.NewReader("token="+token+"&status="+status))
+	req.Header.Set("Content-Type", formContentType)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Errorf("callback: status %d: %s", rec.Code, rec.Body)
+	}
+	return body
+}
+
+func TestRepeatedCallbacksForTerminalTransaction(t *testing.T) {
+	counts := &callbackCounts{}
+	client, storage, _ := newTestClient(t, testConfig(t), newStubTransport(), WithClientHooks(counts.hooks()))
+	storeWebhookPayment(t, storage, StatusInit)
+	handler := client.Handler().ServeHTTP
+
+	// The first callback fails the payment
+	if body := sendCallback(t, handler, webhookToken, "NOK"); body["duplicate"] != nil {
+		t.Fatalf("first callback answered %v", body)
+	}
+	first, err := storage.GetTransaction(context.Background(), webhookToken)
+	if err != nil || first.Status != StatusFailed || first.CallbackCount != 1 {
+		t.Fatalf("after the first callback: %+v, %v", first, err)
+	}
+
+	// Repeats, whatever their status, change nothing
+	for _, status := range []string{"NOK", "NOK", "OK"} {
+		if body := sendCallback(t, handler, webhookToken, status); body["status"] != true || body["duplicate"] != true {
+			t.Fatalf("repeated callback answered %v", body)
+		}
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusFailed || transaction.CallbackCount != 1 || !transaction.UpdatedAt.Equal(first.UpdatedAt) {
+		t.Fatalf("repeats changed the transaction: %+v", transaction)
+	}
+	if counts.callbacks.Load() != 1 || counts.statusChanges.Load() != 1 {
+		t.Fatalf("%d callback and %d status change hooks, want 1 each", counts.callbacks.Load(), counts.statusChanges.Load())
+	}
+}
+
+func TestRepeatedCallbacksBeforeVerification(t *testing.T) {
+	counts := &callbackCounts{}
+	client, storage, _ := newTestClient(t, testConfig(t), newStubTransport(), WithClientHooks(counts.hooks()))
+	storeWebhookPayment(t, storage, StatusInit)
+	handler := client.Handler().ServeHTTP
+
+	// Successful callbacks leave the payment open for verification, so each is processed and counted
+	for i := 0; i < 3; i++ {
+		if body := sendCallback(t, handler, webhookToken, "OK"); body["duplicate"] != nil {
+			t.Fatalf("callback %d answered %v", i, body)
+		}
+	}
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusInit || transaction.CallbackCount != 3 {
+		t.Fatalf("after three callbacks: %+v", transaction)
+	}
+	if counts.callbacks.Load() != 3 || counts.statusChanges.Load() != 0 {
+		t.Fatalf("%d callback and %d status change hooks, want 3 and 0", counts.callbacks.Load(), counts.statusChanges.Load())
+	}
+
+	// Once verified, the payment is terminal and later callbacks are duplicates
+	transaction.Status = StatusPaid
+	if err := storage.UpdateTransaction(context.Background(), transaction); err != nil {
+		t.Fatal(err)
+	}
+	if body := sendCallback(t, handler, webhookToken, "OK"); body["duplicate"] != true {
+		t.Fatalf("callback after verification answered %v", body)
+	}
+	if transaction, _ := storage.GetTransaction(context.Background(), webhookToken); transaction.CallbackCount != 3 {
+		t.Fatalf("callback count %d after a duplicate", transaction.CallbackCount)
+	}
+}
+
+func TestConcurrentCallbacksProcessedOnce(t *testing.T) {
+	counts := &callbackCounts{}
+	client, storage, _ := newTestClient(t, testConfig(t), newStubTransport(), WithClientHooks(counts.hooks()))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	// Called directly, so the callback rate limit doesn't turn deliveries away
+	const deliveries = 20
+	var duplicates atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < deliveries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body := sendCallback(t, client.handleCallback, webhookToken, "NOK"); body["duplicate"] == true {
+				duplicates.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.CallbackCount != 1 || duplicates.Load() != deliveries-1 {
+		t.Fatalf("callback count %d, %d duplicates of %d deliveries", transaction.CallbackCount, duplicates.Load(), deliveries)
+	}
+	if counts.callbacks.Load() != 1 || counts.statusChanges.Load() != 1 {
+		t.Fatalf("%d callback and %d status change hooks, want 1 each", counts.callbacks.Load(), counts.statusChanges.Load())
+	}
+}
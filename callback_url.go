@@ -0,0 +1,97 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// callback_url.go implements per-payment callback URL validation
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// validateCallbackURL checks that a callback URL is an absolute http or https URL
+// without credentials or fragment whose host is in the allowlist; an empty
+// allowlist allows any host
+func validateCallbackURL(raw string, allowList []string) error {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return NewValidationError("callback_url", "callback URL must be a valid HTTP(S) URL")
+	}
+
+	if parsed.User != nil || parsed.Fragment != "" {
+		return NewValidationError("callback_url", "callback URL must not contain credentials or a fragment")
+	}
+
+	if !callbackHostAllowed(parsed.Hostname(), allowList) {
+		return NewValidationError("callback_url", "callback URL host is not allowed")
+	}
+
+	return nil
+}
+
+// callbackHostAllowed reports whether a host matches the allowlist. Entries match
+// the host exactly, and entries starting with "*." match any subdomain.
+func callbackHostAllowed(host string, allowList []string) bool {
+	if len(allowList) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	for _, allowed := range allowList {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveCallbackURL returns the callback URL of a payment, falling back to the
+// configured one, after validating it against the callback host allowlist
+func (c *Client) resolveCallbackURL(requested string) (string, error) {
+	if requested == "" {
+		return c.config.GetCallbackURL(), nil
+	}
+
+	values := configValues(c.config)
+	if err := validateCallbackURL(requested, values.CallbackHostAllowList); err != nil {
+		return "", err
+	}
+
+	if err := values.checkURLScheme("callback_url", requested); err != nil {
+		return "", err
+	}
+
+	return requested, nil
+}
+
+// checkCallbackURL logs a warning when a callback arrives on another path than the
+// callback URL recorded for its transaction. Only paths are compared because scheme
+// and host usually differ behind proxies.
+func (c *Client) checkCallbackURL(ctx context.Context, r *http.Request, transaction *Transaction) {
+	if transaction.CallbackURL == "" {
+		return
+	}
+
+	expected, err := url.Parse(transaction.CallbackURL)
+	if err != nil {
+		return
+	}
+
+	if strings.TrimSuffix(expected.Path, "/") == strings.TrimSuffix(r.URL.Path, "/") {
+		return
+	}
+
+	c.log(ctx).Warn(ctx, "Callback arrived on an unexpected URL", map[string]interface{}{
+		"token":         redactToken(transaction.Token),
+		"expected_path": expected.Path,
+		"path":          r.URL.Path,
+	})
+}
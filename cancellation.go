@@ -0,0 +1,103 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// cancellation.go implements stopping request work once the caller went away
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// StatusClientClosedRequest is the status recorded in logs and metrics for
+// requests whose caller went away before the response, as nginx does. Nothing
+// is sent, since nobody is listening.
+const StatusClientClosedRequest = 499
+
+// clientGone reports whether the caller of a request went away, e.g. a browser
+// that navigated off the page. A context whose deadline passed is a timeout, not
+// a departure.
+func clientGone(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
+
+// isClientCancellation reports whether err was only caused by the caller going away
+func isClientCancellation(ctx context.Context, err error) bool {
+	return clientGone(ctx) && errors.Is(err, context.Canceled)
+}
+
+// abandoned reports whether the caller of a request went away before a step
+// worth skipping, such as a gateway call changing a payment
+func (c *Client) abandoned(ctx context.Context, step string) bool {
+	if !clientGone(ctx) {
+		return false
+	}
+
+	c.log(ctx).Debug(ctx, "Client went away, abandoning request", map[string]interface{}{
+		"step": step,
+	})
+	return true
+}
+
+// clientGoneMiddleware drops the response of a request whose caller went away,
+// so writing it neither fails nor reports errors
+func clientGoneMiddleware() Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			next(&clientGoneWriter{ResponseWriter: w, ctx: r.Context()}, r)
+		}
+	}
+}
+
+// clientGoneWriter discards the response once its request's caller went away
+type clientGoneWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+}
+
+// WriteHeader writes the status code unless the caller went away
+func (w *clientGoneWriter) WriteHeader(code int) {
+	if clientGone(w.ctx) {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes the body unless the caller went away, when it is discarded
+func (w *clientGoneWriter) Write(p []byte) (int, error) {
+	if clientGone(w.ctx) {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush passes through to the underlying writer when it supports flushing
+func (w *clientGoneWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && !clientGone(w.ctx) {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *clientGoneWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// clientGoneLogger logs errors caused by the caller going away at debug level,
+// since they need no attention
+type clientGoneLogger struct {
+	LoggerInterface
+}
+
+// Error logs at debug level when the error only says the caller went away
+func (l clientGoneLogger) Error(ctx context.Context, message string, err error, fields map[string]interface{}) {
+	if err == nil || !isClientCancellation(ctx, err) {
+		l.LoggerInterface.Error(ctx, message, err, fields)
+		return
+	}
+
+	fields = mergeFields(fields, map[string]interface{}{
+		"client_gone": true,
+		"error":       err.Error(),
+	})
+	l.LoggerInterface.Debug(ctx, message, fields)
+}
@@ -0,0 +1,179 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hangingGateway is a gateway that signals started and answers nothing until
+// the request is cancelled
+func hangingGateway(started chan<- struct{}) transportFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		started <- struct{}{}
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+}
+
+// waitForGoroutines waits until no more than want goroutines are running
+func waitForGoroutines(t *testing.T, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > want {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines left, want %d:\n%s", runtime.NumGoroutine(), want, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCancelMidHandler(t *testing.T) {
+	routes := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/payments/init", `{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`},
+		{http.MethodPost, "/payments/verify", `{"token":"` + webhookToken + `"}`},
+		{http.MethodGet, "/payments/status?token=" + webhookToken, ""},
+		{http.MethodPost, "/payments/refund", `{"transaction_id":"160000000001","amount":50000}`},
+	}
+
+	for _, rt := range routes {
+		t.Run(rt.method+" "+rt.path, func(t *testing.T) {
+			started := make(chan struct{}, 1)
+			registry := NewMetricsRegistry()
+			client, storage, logger := newTestClient(t, testConfig(t, func(c *Config) {
+				c.VerifyRetryDelay = -1
+			}), hangingGateway(started), WithClientMetrics(registry))
+			storage.StoreTransaction(context.Background(), &Transaction{
+				ID: "tx-webhook", Token: webhookToken, TransactionID: 160000000001, Amount: 100000, Status: StatusPaid, CreatedAt: time.Now(),
+			})
+			handler := client.Handler()
+			baseline := runtime.NumGoroutine()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			req := httptest.NewRequest(rt.method, rt.path, strings.NewReader(rt.body)).WithContext(ctx)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+testAPIKey)
+			rec := httptest.NewRecorder()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				handler.ServeHTTP(rec, req)
+			}()
+
+			// The browser goes away while the gateway is called
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("gateway not called")
+			}
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler kept running after the client went away")
+			}
+
+			if entries := append(logger.at("error"), logger.at("warn")...); len(entries) > 0 {
+				t.Fatalf("departed client logged as a failure:\n%s", logger.dump())
+			}
+			if rec.Body.Len() != 0 {
+				t.Fatalf("response written to a departed client: %s", rec.Body)
+			}
+
+			var scraped strings.Builder
+			registry.WritePrometheus(&scraped)
+			if !strings.Contains(scraped.String(), `status="499"`) {
+				t.Fatalf("request not recorded as 499:\n%s", scraped.String())
+			}
+
+			waitForGoroutines(t, baseline)
+		})
+	}
+}
+
+func TestCancelBeforeGatewayCall(t *testing.T) {
+	var calls atomic.Int32
+	transport := transportFunc(func(req *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		return stubResponse(req, http.StatusOK, map[string]interface{}{"status": 1, "token": webhookToken}), nil
+	})
+	client, _, logger := newTestClient(t, testConfig(t), transport)
+
+	rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/init", `{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("live request: status %d: %s", rec.Code, rec.Body)
+	}
+	calls.Store(0)
+
+	// A client that went away before the handler ran
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodPost, "/payments/init", strings.NewReader(`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	rec = httptest.NewRecorder()
+	client.Handler().ServeHTTP(rec, req)
+
+	if calls.Load() != 0 {
+		t.Fatalf("gateway called %d times for a departed client", calls.Load())
+	}
+	if _, found := logger.find("Client went away"); !found {
+		t.Fatalf("abandoned request not logged:\n%s", logger.dump())
+	}
+	if len(logger.at("error")) > 0 {
+		t.Fatalf("departed client logged as a failure:\n%s", logger.dump())
+	}
+}
+
+func TestCancelDuringRetryBackoff(t *testing.T) {
+	started := make(chan struct{}, 8)
+	transport := transportFunc(func(req *http.Request) (*http.Response, error) {
+		started <- struct{}{}
+		return stubResponse(req, http.StatusServiceUnavailable, map[string]interface{}{"status": 0, "message": "maintenance"}), nil
+	})
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.MaxRetries = 3
+		c.RetryWaitTime = time.Second
+	}), transport)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.GetPaymentStatus(ctx, webhookToken)
+		errs <- err
+	}()
+
+	<-started
+	cancel()
+	start := time.Now()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("GetPaymentStatus() error = %v, want context.Canceled", err)
+		}
+		if status := errorToStatus(err); status != StatusClientClosedRequest {
+			t.Fatalf("status %d, want %d", status, StatusClientClosedRequest)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("backoff kept waiting after cancellation")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("cancellation took %v to end the backoff", elapsed)
+	}
+	if len(started) != 0 {
+		t.Fatal("retried after cancellation")
+	}
+}
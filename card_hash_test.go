@@ -0,0 +1,119 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashCardNumberKeyed(t *testing.T) {
+	first := HashCardNumberKeyed(fullCardNumber, "first-key")
+	second := HashCardNumberKeyed(fullCardNumber, "second-key")
+
+	if first == second {
+		t.Fatal("different keys gave the same digest")
+	}
+	if first != HashCardNumberKeyed("6037-9912 3456-7890", "first-key") {
+		t.Fatal("formatting changed the digest")
+	}
+	if first == HashCardNumberKeyed("6037991234567891", "first-key") {
+		t.Fatal("different cards gave the same digest")
+	}
+	if !IsKeyedCardHash(first) || IsKeyedCardHash(HashCardNumber(fullCardNumber)) {
+		t.Fatalf("keyed hash %q not told apart from the unkeyed one", first)
+	}
+	if strings.Contains(first, HashCardNumber(fullCardNumber)) {
+		t.Fatal("keyed hash contains the unkeyed digest")
+	}
+	if got := HashCardNumberKeyed(fullCardNumber, ""); got != "" {
+		t.Fatalf("hash without a key = %q", got)
+	}
+}
+
+func TestClientCardHashKey(t *testing.T) {
+	tests := []struct {
+		name          string
+		encryptionKey string
+		hashKey       string
+		want          string
+	}{
+		{"hash key", "encryption-key", "hash-key", HashCardNumberKeyed(fullCardNumber, "hash-key")},
+		{"encryption key fallback", "encryption-key", "", HashCardNumberKeyed(fullCardNumber, "encryption-key")},
+		{"no key", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": webhookToken}))
+			client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+				c.EncryptionKey, c.HashKey = tt.encryptionKey, tt.hashKey
+			}), transport)
+
+			_, err := client.InitiatePaymentWithRequest(context.Background(), &PaymentInitRequest{
+				Amount:          100000,
+				Description:     "Order 1042",
+				ValidCardNumber: fullCardNumber,
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			transaction, err := storage.GetTransaction(context.Background(), webhookToken)
+			if err != nil || transaction.CardHash != tt.want {
+				t.Fatalf("stored card hash %q, want %q (%v)", transaction.CardHash, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestMigrateCardHashes(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) { c.HashKey = "hash-key" }), nil)
+	ctx := context.Background()
+	want := HashCardNumberKeyed(fullCardNumber, "hash-key")
+
+	for i, cardHash := range []string{
+		HashCardNumber(fullCardNumber),
+		strings.ToUpper(HashCardNumber(fullCardNumber)),
+		want,
+		"",
+	} {
+		err := storage.StoreTransaction(ctx, &Transaction{
+			ID:        fmt.Sprintf("tx-%d", i),
+			Token:     fmt.Sprintf("sim%017d", i),
+			Amount:    100000,
+			Status:    []TransactionStatus{StatusPaid, StatusFailed, StatusPaid, StatusInit}[i],
+			CardHash:  cardHash,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrated, err := client.MigrateCardHashes(ctx)
+	if err != nil || migrated != 2 {
+		t.Fatalf("MigrateCardHashes() = %d, %v, want 2", migrated, err)
+	}
+
+	// Migrated hashes match hashes made with the key directly
+	for i, want := range []string{want, want, want, ""} {
+		transaction, _ := storage.GetTransaction(ctx, fmt.Sprintf("sim%017d", i))
+		if transaction.CardHash != want {
+			t.Errorf("transaction %d: card hash %q, want %q", i, transaction.CardHash, want)
+		}
+	}
+
+	// Running it again changes nothing
+	if migrated, err := client.MigrateCardHashes(ctx); err != nil || migrated != 0 {
+		t.Fatalf("second MigrateCardHashes() = %d, %v", migrated, err)
+	}
+
+	unkeyed, _, _ := newTestClient(t, testConfig(t), nil)
+	if _, err := unkeyed.MigrateCardHashes(ctx); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("MigrateCardHashes() without a key = %v", err)
+	}
+}
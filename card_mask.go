@@ -0,0 +1,88 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// card_mask.go implements the selectable card number masking styles
+package vandargo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CardMaskStyle selects how card numbers are masked in logs and admin responses
+type CardMaskStyle string
+
+const (
+	// CardMaskLast4 shows only the last four digits, e.g. ************1234 (the default)
+	CardMaskLast4 CardMaskStyle = "last4"
+
+	// CardMaskBIN also shows the first six digits identifying the issuing bank,
+	// e.g. 603799******1234, as PCI DSS masking rules permit
+	CardMaskBIN CardMaskStyle = "bin"
+)
+
+// minBINMaskLength is the shortest input whose first six and last four digits may be shown
+const minBINMaskLength = 10
+
+// MaskCardNumberBIN masks a card number showing the first six and last four digits.
+// Non-digits other than existing mask characters are dropped, and inputs shorter
+// than ten digits are masked completely.
+func MaskCardNumberBIN(cardNumber string) string {
+	clean := cardMaskDigits(cardNumber)
+	if len(clean) < minBINMaskLength {
+		return strings.Repeat("*", max(len(clean), 4))
+	}
+
+	return clean[:6] + strings.Repeat("*", len(clean)-10) + clean[len(clean)-4:]
+}
+
+// cardMaskDigits keeps the digits and mask characters of a card number, so already
+// masked numbers keep their length
+func cardMaskDigits(cardNumber string) string {
+	var b strings.Builder
+	for _, r := range cardNumber {
+		if (r >= '0' && r <= '9') || r == '*' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Mask masks a card number in the style
+func (s CardMaskStyle) Mask(cardNumber string) string {
+	if s == CardMaskBIN {
+		return MaskCardNumberBIN(cardNumber)
+	}
+
+	// Keep the length of numbers the gateway already masked
+	clean := cardMaskDigits(cardNumber)
+	if len(clean) < 4 {
+		return "****"
+	}
+	return strings.Repeat("*", len(clean)-4) + clean[len(clean)-4:]
+}
+
+// validateCardMaskStyle checks that a card mask style is known
+func validateCardMaskStyle(style CardMaskStyle) error {
+	switch style {
+	case "", CardMaskLast4, CardMaskBIN:
+		return nil
+	default:
+		return fmt.Errorf("unsupported card mask style %q", style)
+	}
+}
+
+// maskCard masks a card number in the configured style
+func (c *Client) maskCard(cardNumber string) string {
+	if cardNumber == "" {
+		return ""
+	}
+	return configValues(c.config).CardMaskStyle.Mask(cardNumber)
+}
+
+// maskedTransaction returns a redacted copy of a transaction with its card number
+// masked in the configured style and its type filled in, for admin responses
+func (c *Client) maskedTransaction(transaction *Transaction) *Transaction {
+	txCopy := transaction.Redacted()
+	txCopy.CardNumber = c.maskCard(transaction.CardNumber)
+	txCopy.Type = txCopy.Type.OrDefault()
+	return &txCopy
+}
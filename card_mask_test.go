@@ -0,0 +1,91 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCardMaskStyles(t *testing.T) {
+	tests := []struct {
+		card  string
+		last4 string
+		bin   string
+	}{
+		{"6037991234567890", "************7890", "603799******7890"},
+		{"6037-9912 3456-7890", "************7890", "603799******7890"},
+		{"6037991234567890123", "***************0123", "603799*********0123"},
+		{"603799******7890", "************7890", "603799******7890"},
+		{"6037991234", "******1234", "6037991234"},
+		{"603799123", "*****9123", "*********"},
+		{"12345", "*2345", "*****"},
+		{"123", "****", "****"},
+		{"", "****", "****"},
+		{"card 6037 99xx 1234 5678 90", "************7890", "603799******7890"},
+		{"not a card", "****", "****"},
+		{"۶۰۳۷۹۹۱۲۳۴۵۶۷۸۹۰", "****", "****"},
+	}
+
+	for _, tt := range tests {
+		if got := CardMaskLast4.Mask(tt.card); got != tt.last4 {
+			t.Errorf("last4 mask of %q = %q, want %q", tt.card, got, tt.last4)
+		}
+		if got := CardMaskStyle("").Mask(tt.card); got != tt.last4 {
+			t.Errorf("default mask of %q = %q, want %q", tt.card, got, tt.last4)
+		}
+		if got := CardMaskBIN.Mask(tt.card); got != tt.bin {
+			t.Errorf("BIN mask of %q = %q, want %q", tt.card, got, tt.bin)
+		}
+		if got := MaskCardNumberBIN(tt.card); got != tt.bin {
+			t.Errorf("MaskCardNumberBIN(%q) = %q, want %q", tt.card, got, tt.bin)
+		}
+	}
+}
+
+func TestCardMaskStyleConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.APIKey = testAPIKey
+	config.CallbackURL = "https://shop.example.com/payments/callback"
+	config.CardMaskStyle = "first6"
+	if _, err := NewConfig(config); err == nil {
+		t.Fatal("unknown card mask style accepted")
+	}
+}
+
+func TestTransactionDetailMaskStyle(t *testing.T) {
+	for _, tt := range []struct {
+		style CardMaskStyle
+		want  string
+	}{
+		{"", "************7890"},
+		{CardMaskLast4, "************7890"},
+		{CardMaskBIN, "603799******7890"},
+	} {
+		t.Run(string(tt.style), func(t *testing.T) {
+			client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+				c.AdminKey = "admin-key"
+				c.CardMaskStyle = tt.style
+			}), nil)
+			storeWebhookPayment(t, storage, StatusPaid)
+			transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+			transaction.CardNumber = "6037991234567890"
+			storage.UpdateTransaction(context.Background(), transaction)
+
+			req := httptest.NewRequest(http.MethodGet, "/payments/transactions/"+webhookToken, nil)
+			req.Header.Set("Authorization", "Bearer "+testAPIKey)
+			req.Header.Set(AdminKeyHeader, "admin-key")
+			rec := httptest.NewRecorder()
+			client.Handler().ServeHTTP(rec, req)
+
+			var detail TransactionDetail
+			if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil || rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if detail.CardNumber != tt.want {
+				t.Fatalf("card number %q, want %q", detail.CardNumber, tt.want)
+			}
+		})
+	}
+}
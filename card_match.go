@@ -0,0 +1,154 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// card_match.go implements recognizing returning payers by card fingerprint (CID)
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// cidRegex matches a card fingerprint: the hex SHA-256 of a card number
+var cidRegex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// CIDStorageInterface is implemented by storages that can look up transactions
+// by card fingerprint; other storages are scanned by status
+type CIDStorageInterface interface {
+	// GetTransactionsByCID retrieves the transactions paid with a card fingerprint
+	GetTransactionsByCID(ctx context.Context, cid string) ([]*Transaction, error)
+}
+
+// normalizeCID returns the canonical form of a card fingerprint
+func normalizeCID(cid string) string {
+	return strings.ToLower(strings.TrimSpace(cid))
+}
+
+// cardFingerprint turns a card number or a CID into a CID. Raw card numbers are
+// hashed with HashCardNumber and never kept; errors don't repeat the input.
+func cardFingerprint(cardNumberOrCID string) (string, error) {
+	input := strings.TrimSpace(cardNumberOrCID)
+	if cidRegex.MatchString(input) {
+		return normalizeCID(input), nil
+	}
+
+	if !cardNumberRegex.MatchString(sanitizeCardNumber(input)) {
+		return "", NewValidationError("card", "card must be a 16-digit card number or a card fingerprint")
+	}
+
+	return HashCardNumber(input), nil
+}
+
+// FindPaymentsByCard returns the stored transactions paid with a card, given either
+// the card number or its fingerprint (CID)
+func (c *Client) FindPaymentsByCard(ctx context.Context, cardNumberOrCID string) ([]*Transaction, error) {
+	cid, err := cardFingerprint(cardNumberOrCID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.transactionsByCID(ctx, cid)
+}
+
+// transactionsByCID looks up transactions by fingerprint through the storage index,
+// scanning every status when the storage has none
+func (c *Client) transactionsByCID(ctx context.Context, cid string) ([]*Transaction, error) {
+	if lookup, ok := c.storage.(CIDStorageInterface); ok {
+		transactions, err := lookup.GetTransactionsByCID(ctx, cid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up transactions by card: %w", err)
+		}
+		return transactions, nil
+	}
+
+	var result []*Transaction
+	for _, status := range transactionStatuses {
+		transactions, err := c.storage.GetTransactionsByStatus(ctx, string(status))
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up transactions by card: %w", err)
+		}
+		for _, transaction := range transactions {
+			if normalizeCID(transaction.CID) == cid {
+				result = append(result, transaction)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// markReturningCustomer counts the earlier paid transactions made with the same card
+// and sets the returning customer fields of a result
+func (c *Client) markReturningCustomer(ctx context.Context, result *PaymentResult, cid string) {
+	cid = normalizeCID(cid)
+	if result == nil || cid == "" {
+		return
+	}
+
+	transactions, err := c.transactionsByCID(ctx, cid)
+	if err != nil {
+		c.log(ctx).Warn(ctx, "Failed to look up earlier payments by card", map[string]interface{}{
+			"token": redactToken(result.Token),
+			"error": err.Error(),
+		})
+		return
+	}
+
+	count := 0
+	for _, transaction := range transactions {
+		if transaction.Token != result.Token && transaction.Status == StatusPaid {
+			count++
+		}
+	}
+
+	result.PreviousPayments = count
+	result.ReturningCustomer = count > 0
+}
+
+// GetTransactionsByCID retrieves the transactions paid with a card fingerprint
+func (s *MemoryStorage) GetTransactionsByCID(ctx context.Context, cid string) ([]*Transaction, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	var result []*Transaction
+	for token := range s.cidIndex[normalizeCID(cid)] {
+		if transaction, exists := s.transactions[token]; exists {
+			// Create a copy to prevent external modifications
+			transactionCopy := *transaction
+			result = append(result, &transactionCopy)
+		}
+	}
+
+	return result, nil
+}
+
+// reindexCID moves a token between card fingerprints in the index; the caller holds the lock
+func (s *MemoryStorage) reindexCID(token, previousCID, cid string) {
+	previousCID, cid = normalizeCID(previousCID), normalizeCID(cid)
+	if previousCID == cid {
+		return
+	}
+
+	if tokens := s.cidIndex[previousCID]; tokens != nil {
+		delete(tokens, token)
+		if len(tokens) == 0 {
+			delete(s.cidIndex, previousCID)
+		}
+	}
+
+	if cid == "" {
+		return
+	}
+	if s.cidIndex[cid] == nil {
+		s.cidIndex[cid] = make(map[string]struct{})
+	}
+	s.cidIndex[cid][token] = struct{}{}
+}
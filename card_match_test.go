@@ -0,0 +1,166 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCardNumber = "6037991234567890"
+
+// storeCardPayment stores a transaction paid with a card fingerprint
+func storeCardPayment(t *testing.T, storage StorageInterface, token string, status TransactionStatus, cid string) {
+	t.Helper()
+
+	err := storage.StoreTransaction(context.Background(), &Transaction{
+		ID:        "tx-" + token,
+		Token:     token,
+		Amount:    100000,
+		Status:    status,
+		CID:       cid,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindPaymentsByCard(t *testing.T) {
+	cid := HashCardNumber(testCardNumber)
+
+	for _, indexed := range []bool{true, false} {
+		memory := NewMemoryStorage()
+		var storage StorageInterface = memory
+		if !indexed {
+			storage = statusScanStorage{memory}
+		}
+		client, _, _ := newTestClient(t, testConfig(t), nil, WithClientStorage(storage))
+
+		storeCardPayment(t, memory, "tok-1", StatusPaid, cid)
+		storeCardPayment(t, memory, "tok-2", StatusFailed, strings.ToUpper(cid))
+		storeCardPayment(t, memory, "tok-other", StatusPaid, HashCardNumber("6219861234567890"))
+
+		// The card number, however it is written, and its fingerprint find the same payments
+		for _, input := range []string{testCardNumber, "6037 9912 3456 7890", "6037-9912-3456-7890", cid, " " + strings.ToUpper(cid) + " "} {
+			transactions, err := client.FindPaymentsByCard(context.Background(), input)
+			if err != nil {
+				t.Fatalf("indexed %v, %q: %v", indexed, input, err)
+			}
+			var tokens []string
+			for _, transaction := range transactions {
+				tokens = append(tokens, transaction.Token)
+			}
+			sort.Strings(tokens)
+			if strings.Join(tokens, ",") != "tok-1,tok-2" {
+				t.Fatalf("indexed %v, %q: found %v", indexed, input, tokens)
+			}
+		}
+
+		transactions, err := client.FindPaymentsByCard(context.Background(), HashCardNumber("5022291234567890"))
+		if err != nil || len(transactions) != 0 {
+			t.Fatalf("indexed %v, unknown card: %d found, %v", indexed, len(transactions), err)
+		}
+	}
+}
+
+func TestFindPaymentsByCardRejectsInput(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), nil)
+
+	for _, input := range []string{"", "6037991234", "603799123456789012", "not a card", strings.Repeat("g", 64)} {
+		_, err := client.FindPaymentsByCard(context.Background(), input)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Field != "card" {
+			t.Fatalf("%q: %v", input, err)
+		}
+	}
+
+	// The rejected input is not repeated in the error
+	_, err := client.FindPaymentsByCard(context.Background(), "60379912345678")
+	if err == nil || strings.Contains(err.Error(), "60379912345678") {
+		t.Fatalf("error %v", err)
+	}
+}
+
+func TestGetPaymentMarksReturningCustomer(t *testing.T) {
+	cid := HashCardNumber(testCardNumber)
+	info := func() stubStep {
+		return jsonStep(http.StatusOK, map[string]interface{}{
+			"status":      1,
+			"amount":      "100000",
+			"transId":     160000000001,
+			"paymentDate": "2026-10-16 12:30:00",
+			"CID":         cid,
+		})
+	}
+	noCache := func(c *Config) {
+		c.CacheTTL = -1
+	}
+
+	// A first payment with the card is not a returning customer, nor does it count itself
+	client, storage, _ := newTestClient(t, testConfig(t, noCache), newStubTransport(info()))
+	storeCardPayment(t, storage, webhookToken, StatusPaid, cid)
+	storeCardPayment(t, storage, "tok-failed", StatusFailed, cid)
+	result, err := client.GetPayment(context.Background(), webhookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ReturningCustomer || result.PreviousPayments != 0 {
+		t.Fatalf("first payment %+v", result)
+	}
+
+	// Earlier paid transactions with the card are counted
+	client, storage, _ = newTestClient(t, testConfig(t, noCache), newStubTransport(info()))
+	storeCardPayment(t, storage, webhookToken, StatusPaid, cid)
+	storeCardPayment(t, storage, "tok-1", StatusPaid, cid)
+	storeCardPayment(t, storage, "tok-2", StatusPaid, cid)
+	storeCardPayment(t, storage, "tok-other", StatusPaid, HashCardNumber("6219861234567890"))
+	result, err = client.GetPayment(context.Background(), webhookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.ReturningCustomer || result.PreviousPayments != 2 {
+		t.Fatalf("returning payment %+v", result)
+	}
+}
+
+func TestCIDIndexFollowsUpdates(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	cid := HashCardNumber(testCardNumber)
+	storeCardPayment(t, storage, "tok-1", StatusInit, "")
+
+	found := func(cid string) int {
+		t.Helper()
+		transactions, err := storage.GetTransactionsByCID(ctx, cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(transactions)
+	}
+
+	if found(cid) != 0 {
+		t.Fatal("transaction without a card indexed")
+	}
+
+	transaction, _ := storage.GetTransaction(ctx, "tok-1")
+	transaction.CID = cid
+	if err := storage.UpdateTransaction(ctx, transaction); err != nil {
+		t.Fatal(err)
+	}
+	if found(cid) != 1 || found(strings.ToUpper(cid)) != 1 {
+		t.Fatal("card of an updated transaction not indexed")
+	}
+
+	other := HashCardNumber("6219861234567890")
+	transaction.CID = other
+	if err := storage.UpdateTransaction(ctx, transaction); err != nil {
+		t.Fatal(err)
+	}
+	if found(cid) != 0 || found(other) != 1 {
+		t.Fatal("index kept the previous card")
+	}
+}
@@ -0,0 +1,222 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// challenge.go implements CAPTCHA and Turnstile verification of payment initializations
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ChallengeTokenHeader carries the challenge token of a payment initialization
+	// when it isn't sent as challenge_token in the body
+	ChallengeTokenHeader = "X-Challenge-Token"
+
+	// ChallengeFailedCode is the error code of payment initializations rejected
+	// for a missing or invalid challenge token
+	ChallengeFailedCode = "challenge_failed"
+
+	// DefaultTurnstileVerifyURL is Cloudflare Turnstile's siteverify endpoint
+	DefaultTurnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+	// challengeVerifyTimeout bounds a challenge verification
+	challengeVerifyTimeout = 5 * time.Second
+
+	// maxChallengeResponseSize caps the verification response read into memory
+	maxChallengeResponseSize = 64 << 10
+)
+
+// ErrChallengeFailed is returned for payment initializations without a valid
+// challenge token; handlers answer it with 403 and ChallengeFailedCode
+var ErrChallengeFailed = fmt.Errorf("%w: challenge verification failed", ErrPermission)
+
+// ChallengeVerifier checks the CAPTCHA or Turnstile token a payer's browser
+// obtained, so scripted card testing can be refused
+type ChallengeVerifier interface {
+	// Verify returns nil when the token is valid for the payer at remoteIP
+	Verify(ctx context.Context, token string, remoteIP string) error
+}
+
+// TurnstileVerifier verifies challenge tokens at a Cloudflare Turnstile
+// compatible siteverify endpoint, which hCaptcha and reCAPTCHA also follow
+type TurnstileVerifier struct {
+	secret     string
+	verifyURL  string
+	httpClient HTTPClientInterface
+}
+
+// NewTurnstileVerifier creates a verifier posting tokens with secret to verifyURL,
+// DefaultTurnstileVerifyURL when empty, through httpClient, a client with a
+// short timeout when nil
+func NewTurnstileVerifier(secret, verifyURL string, httpClient HTTPClientInterface) (*TurnstileVerifier, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("%w: challenge secret is required", ErrInvalidConfig)
+	}
+
+	if verifyURL == "" {
+		verifyURL = DefaultTurnstileVerifyURL
+	}
+	if parsed, err := url.Parse(verifyURL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return nil, fmt.Errorf("%w: invalid challenge verify URL", ErrInvalidConfig)
+	}
+
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: challengeVerifyTimeout, CheckRedirect: noRedirects}
+	}
+
+	return &TurnstileVerifier{
+		secret:     secret,
+		verifyURL:  verifyURL,
+		httpClient: httpClient,
+	}, nil
+}
+
+// turnstileResponse is the siteverify response
+type turnstileResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// Verify posts the token to the siteverify endpoint
+func (v *TurnstileVerifier) Verify(ctx context.Context, token string, remoteIP string) error {
+	form := url.Values{
+		"secret":   {v.secret},
+		"response": {token},
+	}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create challenge request: %w", err)
+	}
+	req.Header.Set("Content-Type", formContentType)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: challenge request failed: %w", ErrNetworkFailure, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxChallengeResponseSize))
+	if err != nil {
+		return fmt.Errorf("%w: failed to read challenge response: %w", ErrNetworkFailure, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("challenge endpoint returned status %d", resp.StatusCode)
+	}
+
+	var result turnstileResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse challenge response: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("challenge token rejected: %s", strings.Join(result.ErrorCodes, ", "))
+	}
+
+	return nil
+}
+
+// ChallengeSwitch turns the challenge requirement on and off at runtime, e.g.
+// from a callback watching the rate limiter; pass its Required method to
+// WithClientChallengeRequirement. The zero value is off.
+type ChallengeSwitch struct {
+	required atomic.Bool
+}
+
+// Set turns the requirement on or off
+func (s *ChallengeSwitch) Set(required bool) {
+	s.required.Store(required)
+}
+
+// Required reports whether the requirement is on
+func (s *ChallengeSwitch) Required(r *http.Request) bool {
+	return s.required.Load()
+}
+
+// challengeVerifierOf returns the client's challenge verifier, the Turnstile
+// verifier of Config.ChallengeSecret, or nil
+func (c *Client) challengeVerifierOf(values *Config) (ChallengeVerifier, error) {
+	if c.challengeVerifier != nil {
+		return c.challengeVerifier, nil
+	}
+	if values.ChallengeSecret == "" {
+		return nil, nil
+	}
+
+	verifier, err := NewTurnstileVerifier(values.ChallengeSecret, values.ChallengeVerifyURL, c.httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return verifier, nil
+}
+
+// checkChallenge verifies the challenge token of a payment initialization when
+// one is sent or required. It fails closed: a required token that can't be
+// verified, e.g. because the verifier timed out, rejects the payment.
+func (c *Client) checkChallenge(r *http.Request, req *PaymentInitRequest) error {
+	ctx := r.Context()
+	values := configValues(c.config)
+
+	token := strings.TrimSpace(r.Header.Get(ChallengeTokenHeader))
+	if token == "" {
+		token = strings.TrimSpace(req.ChallengeToken)
+	}
+	required := values.RequireChallenge || (c.challengeRequired != nil && c.challengeRequired(r))
+
+	verifier, err := c.challengeVerifierOf(values)
+	if err != nil {
+		c.log(ctx).Error(ctx, "Invalid challenge configuration", err, nil)
+	}
+
+	switch {
+	case verifier == nil && !required:
+		return nil
+	case verifier == nil:
+		return c.challengeFailed(r, "unconfigured", errors.New("no challenge verifier is configured"))
+	case token == "" && !required:
+		return nil
+	case token == "":
+		return c.challengeFailed(r, "missing", errors.New("challenge token is required"))
+	}
+
+	verifyCtx, cancel := context.WithTimeout(ctx, challengeVerifyTimeout)
+	defer cancel()
+
+	if err := verifier.Verify(verifyCtx, token, getClientIP(r)); err != nil {
+		// A payer who went away didn't fail the challenge
+		if isClientCancellation(ctx, err) {
+			return err
+		}
+
+		reason := "rejected"
+		if IsNetworkError(err) || errors.Is(err, context.DeadlineExceeded) {
+			reason = "unavailable"
+		}
+		return c.challengeFailed(r, reason, err)
+	}
+
+	return nil
+}
+
+// challengeFailed records a rejected challenge and returns its error
+func (c *Client) challengeFailed(r *http.Request, reason string, err error) error {
+	ctx := r.Context()
+	c.metrics.IncCounter(MetricChallengeFailures, map[string]string{"reason": reason})
+	c.log(ctx).Warn(ctx, "Payment initialization failed the challenge", map[string]interface{}{
+		"reason":    reason,
+		"client_ip": getClientIP(r),
+		"error":     err.Error(),
+	})
+	return fmt.Errorf("%w: %w", ErrChallengeFailed, err)
+}
@@ -0,0 +1,218 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// challengeURL is the siteverify endpoint of the challenge tests
+const challengeURL = "https://challenge.example.com/siteverify"
+
+// challengeGateway answers siteverify requests with verify and everything else
+// with a simulator, recording the forms posted to siteverify
+func challengeGateway(verify func(req *http.Request, form url.Values) (*http.Response, error)) (transportFunc, *[]url.Values) {
+	sim := NewSimulatorTransport()
+	var forms []url.Values
+	return func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host != "challenge.example.com" {
+			return sim.Do(req)
+		}
+		body, _ := io.ReadAll(req.Body)
+		form, _ := url.ParseQuery(string(body))
+		forms = append(forms, form)
+		return verify(req, form)
+	}, &forms
+}
+
+// siteverify answers a siteverify request with success for the token "human"
+func siteverify(req *http.Request, form url.Values) (*http.Response, error) {
+	if form.Get("response") == "human" {
+		return stubResponse(req, http.StatusOK, map[string]interface{}{"success": true}), nil
+	}
+	return stubResponse(req, http.StatusOK, map[string]interface{}{"success": false, "error-codes": []string{"invalid-input-response"}}), nil
+}
+
+// initWithChallenge sends an init request with a challenge token in the body
+// and, when header is set, in ChallengeTokenHeader
+func initWithChallenge(handler http.Handler, bodyToken, header string) *httptest.ResponseRecorder {
+	body := `{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042","challenge_token":"` + bodyToken + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/payments/init", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	if header != "" {
+		req.Header.Set(ChallengeTokenHeader, header)
+	}
+	req.RemoteAddr = "203.0.113.9:1234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// challengeCode returns the error code of a response
+func challengeCode(rec *httptest.ResponseRecorder) string {
+	var body struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.Code
+}
+
+func TestChallengeTurnstile(t *testing.T) {
+	unavailable := func(req *http.Request, form url.Values) (*http.Response, error) {
+		return stubResponse(req, http.StatusServiceUnavailable, map[string]interface{}{}), nil
+	}
+	timeout := func(req *http.Request, form url.Values) (*http.Response, error) {
+		return nil, context.DeadlineExceeded
+	}
+
+	tests := []struct {
+		name     string
+		verify   func(req *http.Request, form url.Values) (*http.Response, error)
+		require  bool
+		token    string
+		header   string
+		status   int
+		verifies int
+		reason   string
+	}{
+		{"valid token", siteverify, true, "human", "", http.StatusOK, 1, ""},
+		{"valid header", siteverify, true, "", "human", http.StatusOK, 1, ""},
+		{"header wins", siteverify, false, "bot", "human", http.StatusOK, 1, ""},
+		{"invalid token", siteverify, false, "bot", "", http.StatusForbidden, 1, "rejected"},
+		{"missing token", siteverify, true, "", "", http.StatusForbidden, 0, "missing"},
+		{"optional", siteverify, false, "", "", http.StatusOK, 0, ""},
+		{"endpoint down", unavailable, true, "human", "", http.StatusForbidden, 1, "rejected"},
+		{"timeout", timeout, true, "human", "", http.StatusForbidden, 1, "unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, forms := challengeGateway(tt.verify)
+			metrics := newRecordingMetrics()
+			client, _, logger := newTestClient(t, testConfig(t, func(c *Config) {
+				c.ChallengeSecret = "challenge-secret"
+				c.ChallengeVerifyURL = challengeURL
+				c.RequireChallenge = tt.require
+			}), transport, WithClientMetrics(metrics))
+
+			rec := initWithChallenge(client.Handler(), tt.token, tt.header)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if len(*forms) != tt.verifies {
+				t.Fatalf("%d siteverify requests, want %d", len(*forms), tt.verifies)
+			}
+			if tt.verifies > 0 {
+				form := (*forms)[0]
+				if form.Get("secret") != "challenge-secret" || form.Get("remoteip") != "203.0.113.9" {
+					t.Fatalf("siteverify form %v", form)
+				}
+			}
+
+			if tt.reason == "" {
+				return
+			}
+			if challengeCode(rec) != ChallengeFailedCode || metrics.counter(MetricChallengeFailures) != 1 {
+				t.Fatalf("code %q, %d failures counted: %s", challengeCode(rec), metrics.counter(MetricChallengeFailures), rec.Body)
+			}
+			if entry, found := logger.find("failed the challenge"); !found || entry.fields["reason"] != tt.reason {
+				t.Fatalf("failure not logged with reason %s:\n%s", tt.reason, logger.dump())
+			}
+		})
+	}
+}
+
+// challengeFunc is a ChallengeVerifier calling a function
+type challengeFunc func(ctx context.Context, token string, remoteIP string) error
+
+func (f challengeFunc) Verify(ctx context.Context, token string, remoteIP string) error {
+	return f(ctx, token, remoteIP)
+}
+
+func TestChallengeSwitch(t *testing.T) {
+	var verified []string
+	verifier := challengeFunc(func(ctx context.Context, token string, remoteIP string) error {
+		verified = append(verified, token)
+		if token != "human" {
+			return errors.New("not a human")
+		}
+		return nil
+	})
+	challenges := &ChallengeSwitch{}
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(),
+		WithClientChallengeVerifier(verifier), WithClientChallengeRequirement(challenges.Required))
+	handler := client.Handler()
+
+	// Off, payments without a token pass
+	if rec := initWithChallenge(handler, "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("switch off: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// On, they need one
+	challenges.Set(true)
+	if rec := initWithChallenge(handler, "", ""); rec.Code != http.StatusForbidden || challengeCode(rec) != ChallengeFailedCode {
+		t.Fatalf("switch on: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := initWithChallenge(handler, "human", ""); rec.Code != http.StatusOK {
+		t.Fatalf("switch on with a token: status %d: %s", rec.Code, rec.Body)
+	}
+
+	challenges.Set(false)
+	if rec := initWithChallenge(handler, "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("switch off again: status %d: %s", rec.Code, rec.Body)
+	}
+	if len(verified) != 1 || verified[0] != "human" {
+		t.Fatalf("verified %v", verified)
+	}
+}
+
+func TestChallengeRequiredWithoutVerifier(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) { c.RequireChallenge = true }), NewSimulatorTransport())
+	if rec := initWithChallenge(client.Handler(), "human", ""); rec.Code != http.StatusForbidden || challengeCode(rec) != ChallengeFailedCode {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTurnstileVerifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("response") == "slow" {
+			<-r.Context().Done()
+			return
+		}
+		siteverifyResp, _ := siteverify(r, r.PostForm)
+		w.WriteHeader(siteverifyResp.StatusCode)
+		io.Copy(w, siteverifyResp.Body)
+	}))
+	defer server.Close()
+
+	verifier, err := NewTurnstileVerifier("challenge-secret", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(context.Background(), "human", "203.0.113.9"); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	if err := verifier.Verify(context.Background(), "bot", ""); err == nil || !strings.Contains(err.Error(), "invalid-input-response") {
+		t.Fatalf("invalid token: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := verifier.Verify(ctx, "slow", ""); !IsNetworkError(err) {
+		t.Fatalf("timeout: %v", err)
+	}
+
+	for _, config := range [][2]string{{"", server.URL}, {"secret", "ftp://challenge.example.com"}, {"secret", "https://"}} {
+		if _, err := NewTurnstileVerifier(config[0], config[1], nil); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("NewTurnstileVerifier(%q, %q) = %v", config[0], config[1], err)
+		}
+	}
+}
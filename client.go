@@ -6,9 +6,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,9 +21,94 @@ type Client struct {
 	httpClient HTTPClientInterface
 	logger     LoggerInterface
 	storage    StorageInterface
+
+	// tokenProvider supplies access tokens for business API endpoints (optional)
+	tokenProvider TokenProvider
+
+	// cache stores status and transaction info responses (optional)
+	cache CacheInterface
+
+	// metrics records operational metrics
+	metrics MetricsInterface
+
+	// flights deduplicates concurrent identical gateway lookups
+	flights *flightGroup
+
+	// verifyResults memoizes recent successful verifications by token
+	verifyResults *MemoryCache
+
+	// responseEncoder shapes JSON written by the HTTP handlers (optional)
+	responseEncoder ResponseEncoder
+
+	// hooks are called on payment lifecycle events
+	hooks Hooks
+
+	// provider replaces direct Vandar calls in the HTTP handlers (optional)
+	provider PaymentProvider
+
+	// factorLocks serializes payment initialization per factor number
+	factorLocks *keyedMutex
+
+	// tokenLocks serializes verification, callbacks, expiry and overrides per token
+	tokenLocks *keyedMutex
+
+	// requestTimeout replaces Config.Timeout as the per-attempt timeout when set
+	requestTimeout time.Duration
+
+	// ownsTokenProvider reports whether tokenProvider was created from the refresh token
+	ownsTokenProvider bool
+
+	// ids creates transaction and request IDs
+	ids IDGenerator
+
+	// inflight tracks critical sections so shutdown can wait for them
+	inflight *inflightTracker
+
+	// sessions keeps payment sessions when the storage cannot
+	sessions *MemorySessionStore
+
+	// clock is the source of time for timestamps, expiry and retry waits
+	clock Clock
+
+	// backoff decides the waits between retries; nil uses exponential backoff
+	// from Config.RetryWaitTime
+	backoff BackoffStrategy
+
+	// hedges limits how many slow lookups are hedged with a second request
+	hedges *hedgeBudget
+
+	// traces holds the tokens logged verbosely, shared by clones
+	traces *traceSet
+
+	// requestValidator validates requests; nil uses the default patterns
+	requestValidator *Validator
+
+	// challengeVerifier checks the challenge tokens of payment initializations;
+	// nil uses Turnstile when Config.ChallengeSecret is set
+	challengeVerifier ChallengeVerifier
+
+	// challengeRequired decides per request whether a challenge token is required,
+	// in addition to Config.RequireChallenge (optional)
+	challengeRequired func(r *http.Request) bool
+
+	// velocity limits payment initializations per payer (optional)
+	velocity *VelocityChecker
+
+	// kv shares idempotency keys, nonces, velocity counters and rate limits
+	// between instances (optional)
+	kv KVStore
+
+	// simulated reports that dev mode swapped in the simulator for the gateway
+	simulated bool
+
+	// health keeps the gateway outcomes and queue depths of health reports, shared by clones
+	health *healthTracker
+
+	// gatewayCalls counts and caps the requests in flight to the gateway, shared by clones
+	gatewayCalls *gatewayLimiter
 }
 
-// NewClient creates a new Vandar API client
+// NewClient creates a new Vandar API client. A nil logger drops all entries.
 func NewClient(config ConfigInterface, storage StorageInterface, logger LoggerInterface) (*Client, error) {
 	if config == nil {
 		return nil, fmt.Errorf("config cannot be nil")
@@ -30,36 +118,181 @@ func NewClient(config ConfigInterface, storage StorageInterface, logger LoggerIn
 		return nil, fmt.Errorf("storage cannot be nil")
 	}
 
-	if logger == nil {
-		return nil, fmt.Errorf("logger cannot be nil")
+	// Without a logger entries are dropped
+	logger = loggerOrDiscard(logger)
+
+	// Mixing sandbox and production settings fails validation only when strict
+	if err := configValues(config).CheckEnvironment(); err != nil {
+		logger.Error(context.Background(), "Configuration mixes sandbox and production settings, check the API key, base URL and sandbox mode", err, nil)
 	}
 
 	// Create HTTP client with appropriate timeouts
 	httpClient := &http.Client{
-		Timeout: time.Duration(config.GetTimeout()) * time.Second,
+		Timeout:       time.Duration(config.GetTimeout()) * time.Second,
+		CheckRedirect: noRedirects,
 	}
 
-	return &Client{
+	client := &Client{
 		config:     config,
 		httpClient: httpClient,
 		logger:     logger,
 		storage:    storage,
-	}, nil
+		metrics:    noopMetrics{},
+		flights:    newFlightGroup(),
+
+		verifyResults: NewMemoryCache(),
+		factorLocks:   newKeyedMutex(),
+		tokenLocks:    newKeyedMutex(),
+		ids:           defaultIDGenerator,
+		inflight:      newInflightTracker(),
+		sessions:      NewMemorySessionStore(),
+		clock:         RealClock(),
+		hedges:        &hedgeBudget{},
+		traces:        newTraceSet(),
+		health:        newHealthTracker(),
+		gatewayCalls:  newGatewayLimiter(configValues(config).MaxConcurrentGatewayCalls, configValues(config).GatewayCallWait),
+	}
+
+	// Use the refresh token flow for business API endpoints when configured
+	if configValues(config).RefreshToken != "" {
+		tokenProvider, err := NewRefreshTokenProvider(config, clientTransport{client: client}, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create token provider: %w", err)
+		}
+		client.tokenProvider = tokenProvider
+		client.ownsTokenProvider = true
+	}
+
+	// Without sandbox credentials dev mode answers from the simulator
+	if devModeSimulated(configValues(config)) {
+		client.useDevSimulator()
+	}
+
+	return client, nil
 }
 
-// WithHTTPClient allows setting a custom HTTP client
+// The With* methods return a copy of the client with one setting changed and leave
+// the receiver untouched, so they are safe to call on a client serving requests.
+// Use the returned client: client = client.WithCache(cache).
+
+// WithHTTPClient returns a copy of the client using a custom HTTP client
 func (c *Client) WithHTTPClient(httpClient HTTPClientInterface) *Client {
-	c.httpClient = httpClient
-	return c
+	return c.Clone(WithClientHTTPClient(httpClient))
+}
+
+// WithTokenProvider returns a copy of the client using a custom access token provider for business API endpoints
+func (c *Client) WithTokenProvider(tokenProvider TokenProvider) *Client {
+	return c.Clone(WithClientTokenProvider(tokenProvider))
+}
+
+// WithCache returns a copy of the client caching status and transaction info responses
+func (c *Client) WithCache(cache CacheInterface) *Client {
+	return c.Clone(WithClientCache(cache))
+}
+
+// WithResponseEncoder returns a copy of the client using an encoder for JSON responses and error envelopes
+func (c *Client) WithResponseEncoder(encoder ResponseEncoder) *Client {
+	return c.Clone(WithClientResponseEncoder(encoder))
+}
+
+// encoder returns the configured response encoder or the default one
+func (c *Client) encoder() ResponseEncoder {
+	if c.responseEncoder == nil {
+		return DefaultResponseEncoder()
+	}
+	return c.responseEncoder
+}
+
+// WithMetrics returns a copy of the client recording metrics
+func (c *Client) WithMetrics(metrics MetricsInterface) *Client {
+	return c.Clone(WithClientMetrics(metrics))
+}
+
+// WithClock returns a copy of the client using a custom clock, e.g. a FakeClock in tests
+func (c *Client) WithClock(clock Clock) *Client {
+	return c.Clone(WithClientClock(clock))
+}
+
+// WithBackoff returns a copy of the client waiting between retries as the strategy decides
+func (c *Client) WithBackoff(strategy BackoffStrategy) *Client {
+	return c.Clone(WithClientBackoff(strategy))
+}
+
+// clientTransport forwards requests to the client's current HTTP client
+type clientTransport struct {
+	client *Client
+}
+
+// Do executes an HTTP request using the client's HTTP client
+func (t clientTransport) Do(req *http.Request) (*http.Response, error) {
+	return t.client.httpClient.Do(req)
 }
 
 // InitiatePayment starts a new payment transaction
 func (c *Client) InitiatePayment(ctx context.Context, amount int64, description string, metadata map[string]string) (*PaymentInitResponse, error) {
-	// Create payment init request
-	req := &PaymentInitRequest{
+	return c.InitiatePaymentWithRequest(ctx, &PaymentInitRequest{
 		Amount:      amount,
-		CallbackURL: c.config.GetCallbackURL(),
 		Description: description,
+	}, metadata)
+}
+
+// InitiatePaymentWithRequest starts a new payment transaction from a full request,
+// including the optional mobile, factor number and allowed card fields
+func (c *Client) InitiatePaymentWithRequest(ctx context.Context, req *PaymentInitRequest, metadata map[string]string) (*PaymentInitResponse, error) {
+	if req == nil {
+		return nil, fmt.Errorf("request cannot be nil")
+	}
+
+	// The gateway call and the stored transaction must not be cut apart by a shutdown
+	ctx, done, err := c.beginOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	ctx, cancel := c.operationContext(ctx, operationInit)
+	defer cancel()
+
+	// Use the configured callback URL unless the request sets an allowed one
+	callbackURL, err := c.resolveCallbackURL(req.CallbackURL)
+	if err != nil {
+		return nil, err
+	}
+	if callbackURL != req.CallbackURL {
+		reqCopy := *req
+		reqCopy.CallbackURL = callbackURL
+		req = &reqCopy
+	}
+
+	// Metadata passed separately takes precedence over the request's
+	metadata = mergeMetadata(req.Metadata, metadata)
+
+	// Fill in the configured description
+	if req.Description == "" {
+		if description := c.defaultDescription(ctx, metadata); description != "" {
+			reqCopy := *req
+			reqCopy.Description = description
+			req = &reqCopy
+		}
+	}
+
+	// Refuse payers initiating too many or too large payments
+	if err := c.checkVelocity(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// Suppress duplicate payments for the same factor number
+	existing, release, err := c.reserveFactorNumber(ctx, req.FactorNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if existing != nil {
+		// A queued initialization has no token to hand out yet
+		if existing.Status == StatusQueued {
+			return nil, fmt.Errorf("%w: factor number %s has a queued payment", ErrDuplicatePayment, req.FactorNumber)
+		}
+		return reusedInitResponse(existing), nil
 	}
 
 	// Prepare API request body
@@ -73,6 +306,26 @@ func (c *Client) InitiatePayment(ctx context.Context, amount int64, description
 		apiReq["description"] = req.Description
 	}
 
+	if req.Mobile != "" {
+		apiReq["mobile"] = req.Mobile
+	}
+
+	if req.FactorNumber != "" {
+		apiReq["factorNumber"] = req.FactorNumber
+	}
+
+	if req.ValidCardNumber != "" {
+		apiReq["valid_card_number"] = req.ValidCardNumber
+	}
+
+	if req.NationalCode != "" {
+		apiReq["national_code"] = req.NationalCode
+	}
+
+	if req.RequireCardOwnerMatch {
+		apiReq[cardOwnerCheckField] = true
+	}
+
 	// Add metadata if provided
 	if metadata != nil {
 		for key, value := range metadata {
@@ -81,7 +334,7 @@ func (c *Client) InitiatePayment(ctx context.Context, amount int64, description
 	}
 
 	// Make API request
-	respBody, _, err := c.makeRequest(ctx, http.MethodPost, "/api/v4/send", apiReq)
+	respBody, _, err := c.makeRequest(ctx, http.MethodPost, c.endpoints().Send, apiReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize payment: %w", err)
 	}
@@ -97,32 +350,68 @@ func (c *Client) InitiatePayment(ctx context.Context, amount int64, description
 		return &apiResp, fmt.Errorf("payment initialization failed: %s", apiResp.Message)
 	}
 
-	// Create transaction record
-	transaction := &Transaction{
-		ID:          generateRequestID(),
-		Token:       apiResp.Token,
-		Amount:      req.Amount,
-		Status:      "INIT",
-		Description: req.Description,
-		Metadata:    metadata,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
-	}
-
-	// Store transaction
-	err = c.storage.StoreTransaction(ctx, transaction)
-	if err != nil {
-		c.logger.Error(ctx, "Failed to store transaction", err, map[string]interface{}{
-			"transaction": transaction,
-		})
-		// Continue with the response even if storage fails
-	}
+	// Record the transaction, and when its token expires so it can be expired locally
+	c.recordPaymentInit(ctx, req, &apiResp, metadata)
 
 	return &apiResp, nil
 }
 
 // VerifyPayment verifies a payment transaction
+// Concurrent calls for the same token share a single upstream request, and
+// successful results are memoized briefly so immediate repeats don't hit the gateway.
 func (c *Client) VerifyPayment(ctx context.Context, token string) (*PaymentVerifyResponse, error) {
+	ctx = c.traceContext(ctx, token)
+
+	// The verification and its storage update must not be cut apart by a shutdown
+	ctx, done, err := c.beginOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	ctx, cancel := c.operationContext(ctx, operationVerify)
+	defer cancel()
+
+	// Serve immediate repeats from the memoized result
+	if resp, found := c.memoizedVerification(ctx, token); found {
+		c.log(ctx).Debug(ctx, "Returning memoized verification result", nil)
+		resp.Warnings = c.checkEcho(ctx, token, resp.Description, resp.FactorNumber, "verify")
+		return resp, nil
+	}
+
+	val, err, shared := c.flights.Do("verify:"+token, func() (interface{}, error) {
+		return c.verifyPayment(ctx, token)
+	})
+	if shared {
+		c.log(ctx).Debug(ctx, "Shared in-flight verification result", nil)
+	}
+
+	// Give each caller its own copy of the shared result
+	resp, _ := val.(*PaymentVerifyResponse)
+	if resp != nil {
+		respCopy := *resp
+		resp = &respCopy
+	}
+
+	// The result stands, but a payment echoed with other details is flagged
+	if err == nil && resp != nil {
+		resp.Warnings = c.checkEcho(ctx, token, resp.Description, resp.FactorNumber, "verify")
+	}
+
+	return resp, err
+}
+
+// verifyPayment calls the verify endpoint and records the result in storage
+func (c *Client) verifyPayment(ctx context.Context, token string) (*PaymentVerifyResponse, error) {
+	// Callbacks, expiry and overrides of the token wait for the verification
+	release := c.tokenLocks.Lock(token)
+	defer release()
+
+	// A verification that finished while waiting for the lock is reused
+	if resp, found := c.memoizedVerification(ctx, token); found {
+		return resp, nil
+	}
+
 	// Create verify request
 	req := &PaymentVerifyRequest{
 		Token: token,
@@ -135,51 +424,124 @@ func (c *Client) VerifyPayment(ctx context.Context, token string) (*PaymentVerif
 	}
 
 	// Make API request
-	respBody, _, err := c.makeRequest(ctx, http.MethodPost, "/api/v4/verify", apiReq)
+	respBody, statusCode, err := c.makeRequest(ctx, http.MethodPost, c.endpoints().Verify, apiReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to verify payment: %w", err)
+		err = fmt.Errorf("failed to verify payment: %w", err)
+		reason := classifyVerifyRequestError(statusCode, err)
+		if reason == VerifyAlreadyVerified {
+			return c.verifyAlreadyVerified(ctx, token, err)
+		}
+		return nil, c.verifyFailed(ctx, token, reason, err)
 	}
 
+	// Keep the exact response for disputes, whatever its outcome
+	c.captureVerifyEvidence(context.WithoutCancel(ctx), token, respBody)
+
 	// Parse API response
 	var apiResp PaymentVerifyResponse
 	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		return nil, fmt.Errorf("failed to parse API response: %w", err)
+		return nil, c.verifyFailed(ctx, token, VerifyIndeterminate, fmt.Errorf("failed to parse API response: %w", err))
 	}
 
 	// Check if payment verification was successful
 	if apiResp.Status != 1 {
-		return &apiResp, fmt.Errorf("payment verification failed: %s", apiResp.Message)
+		err := fmt.Errorf("payment verification failed: %s", apiResp.Message)
+		reason := classifyVerifyResponse(&apiResp)
+		if reason == VerifyAlreadyVerified {
+			return c.verifyAlreadyVerified(ctx, token, err)
+		}
+		return &apiResp, c.verifyFailed(ctx, token, reason, err)
 	}
 
+	c.recordVerification(ctx, token, &apiResp, respBody)
+
+	return &apiResp, nil
+}
+
+// recordVerification memoizes a successful verification and records it in storage
+func (c *Client) recordVerification(ctx context.Context, token string, apiResp *PaymentVerifyResponse, respBody []byte) {
+	// Cached lookups no longer reflect the transaction state
+	defer c.invalidateCache(ctx, token)
+
+	// Remember the result for immediate repeats
+	c.memoizeVerification(ctx, token, respBody)
+
+	// The gateway has committed the verification, so record it even if the
+	// caller gave up in the meantime
+	storeCtx := context.WithoutCancel(ctx)
+
 	// Get transaction from storage
-	transaction, err := c.storage.GetTransaction(ctx, token)
-	if err == nil {
-		// Update transaction status
-		transaction.Status = "PAID"
-		transaction.TransactionID = apiResp.TransID
-		transaction.CardNumber = apiResp.CardNumber
-		transaction.CID = apiResp.CID
-		transaction.UpdatedAt = time.Now()
-
-		completedAt := time.Now()
-		transaction.CompletedAt = &completedAt
-
-		// Store updated transaction
-		err = c.storage.UpdateTransaction(ctx, transaction)
-		if err != nil {
-			c.logger.Error(ctx, "Failed to update transaction", err, map[string]interface{}{
-				"transaction": transaction,
-			})
-			// Continue with the response even if storage fails
-		}
-	} else {
-		c.logger.Warn(ctx, "Transaction not found in storage", map[string]interface{}{
-			"token": token,
+	transaction, err := c.storage.GetTransaction(storeCtx, token)
+	if err != nil {
+		c.log(ctx).Warn(ctx, "Transaction not found in storage", map[string]interface{}{
+			"token": redactToken(token),
 		})
 		// Continue with the response even if transaction is not found
+		return
+	}
+
+	// Update only the verification fields so concurrent writers aren't overwritten
+	previousStatus := transaction.Status
+	status := StatusPaid
+	if previousStatus == StatusSuspect {
+		// A flagged payment stays flagged until someone reviews it
+		status = StatusSuspect
+	}
+	completedAt := c.clock.Now()
+	patch := TransactionPatch{
+		Status:        &status,
+		TransactionID: &apiResp.TransID,
+		CardNumber:    &apiResp.CardNumber,
+		CID:           &apiResp.CID,
+		CompletedAt:   &completedAt,
+	}
+	if apiResp.RealAmount > 0 {
+		patch.NetAmount = &apiResp.RealAmount
+	}
+	if apiResp.CardOwnerMatch != nil {
+		patch.Metadata = map[string]string{
+			CardOwnerMatchMetadataKey: strconv.FormatBool(*apiResp.CardOwnerMatch),
+		}
 	}
+	c.applyPatch(&patch, transaction)
 
-	return &apiResp, nil
+	// Store updated transaction
+	err = c.patchTransaction(storeCtx, token, patch)
+	if err != nil {
+		c.log(ctx).Error(ctx, "Failed to update transaction", err, transactionLogFields(transaction))
+		// Continue with the response even if storage fails
+	}
+
+	c.fireStatusChange(ctx, transaction, previousStatus)
+	c.firePaymentVerified(ctx, transaction)
+}
+
+// memoizedVerification returns a recent successful verification result for a token
+func (c *Client) memoizedVerification(ctx context.Context, token string) (*PaymentVerifyResponse, bool) {
+	body, found := c.verifyResults.Get(ctx, token)
+	if !found {
+		return nil, false
+	}
+
+	var resp PaymentVerifyResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, false
+	}
+
+	return &resp, true
+}
+
+// memoizeVerification remembers a successful verification result for the configured window
+func (c *Client) memoizeVerification(ctx context.Context, token string, body []byte) {
+	ttl := configValues(c.config).VerifyMemoTTL
+	if ttl < 0 {
+		return
+	}
+	if ttl == 0 {
+		ttl = defaultVerifyMemoTTL
+	}
+
+	c.verifyResults.Set(ctx, token, body, ttl)
 }
 
 // GetTransactionInfo retrieves detailed information about a transaction
@@ -187,15 +549,44 @@ func (c *Client) GetTransactionInfo(ctx context.Context, token string) (*Transac
 	if token == "" {
 		return nil, fmt.Errorf("token is required")
 	}
+	ctx = c.traceContext(ctx, token)
 
-	// Prepare API request body
-	apiReq := map[string]interface{}{
-		"api_key": c.config.GetAPIKey(),
-		"token":   token,
+	apiResp, err := c.transactionInfo(ctx, token)
+	if err != nil {
+		return nil, err
 	}
 
-	// Make API request
-	respBody, _, err := c.makeRequest(ctx, http.MethodPost, "/api/v4/transaction", apiReq)
+	// The details stand, but a payment echoed with other details is flagged
+	if apiResp.Status == 1 {
+		apiResp.Warnings = c.checkEcho(ctx, token, apiResp.Description, apiResp.FactorNumber, "transaction_info")
+	}
+
+	return apiResp, nil
+}
+
+// transactionInfo fetches the transaction info of a token and records it in
+// storage, without the echo check, which takes the token lock. It may be called
+// with the token lock held.
+func (c *Client) transactionInfo(ctx context.Context, token string) (*TransactionInfoResponse, error) {
+
+	ctx, cancel := c.operationContext(ctx, operationStatus)
+	defer cancel()
+
+	// Fetch from cache or API, sharing concurrent lookups for the same token
+	respBody, err := c.cachedFetch(ctx, cacheKindTransactionInfo, token, func(ctx context.Context) ([]byte, error) {
+		// Prepare API request body
+		apiReq := map[string]interface{}{
+			"api_key": c.config.GetAPIKey(),
+			"token":   token,
+		}
+
+		// Make API request
+		respBody, _, err := c.makeRequest(ctx, http.MethodPost, c.endpoints().Transaction, apiReq)
+		if err == nil {
+			c.captureTransactionInfoEvidence(ctx, token, respBody)
+		}
+		return respBody, err
+	}, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transaction info: %w", err)
 	}
@@ -206,17 +597,134 @@ func (c *Client) GetTransactionInfo(ctx context.Context, token string) (*Transac
 		return nil, fmt.Errorf("failed to parse API response: %w", err)
 	}
 
+	// Keep the stored transaction's wages and references in sync
+	if apiResp.Status == 1 {
+		c.recordTransactionInfo(ctx, token, &apiResp)
+	}
+
+	return &apiResp, nil
+}
+
+// recordTransactionInfo stores the wage breakdown and references from a
+// transaction info response. Only those fields are patched, so a verification
+// or callback changing the transaction meanwhile isn't undone. It may be called
+// with the token lock held.
+func (c *Client) recordTransactionInfo(ctx context.Context, token string, info *TransactionInfoResponse) {
+	transaction, err := c.storage.GetTransaction(ctx, token)
+	if err != nil {
+		return
+	}
+
+	wage, shaparakWage, net := info.Wage.Int64(), info.ShaparakWage.Int64(), info.Net()
+	if transaction.Wage == wage &&
+		transaction.ShaparakWage == shaparakWage &&
+		transaction.NetAmount == net &&
+		transaction.RefNumber == info.RefNumber &&
+		transaction.TrackingCode == info.TrackingCode {
+		return
+	}
+
+	patch := TransactionPatch{
+		Wage:         &wage,
+		ShaparakWage: &shaparakWage,
+		NetAmount:    &net,
+		RefNumber:    &info.RefNumber,
+		TrackingCode: &info.TrackingCode,
+	}
+	if err := c.patchTransaction(ctx, token, patch); err != nil {
+		c.log(ctx).Error(ctx, "Failed to update transaction details", err, transactionLogFields(transaction))
+	}
+}
+
+// GetPaymentStatus retrieves the current status of a payment
+func (c *Client) GetPaymentStatus(ctx context.Context, token string) (*PaymentStatusResponse, error) {
+	ctx = c.traceContext(ctx, token)
+
+	// Validate request
+	req := &PaymentStatusRequest{
+		Token: token,
+	}
+	if err := c.validator().ValidatePaymentStatusRequest(req); err != nil {
+		return nil, err
+	}
+
+	// Queued initializations have no gateway token yet
+	if strings.HasPrefix(token, queuedTokenPrefix) {
+		return c.queuedStatus(ctx, token)
+	}
+
+	// Expired tokens can't change anymore, so the gateway isn't asked
+	if expired := c.expiredStatus(ctx, token); expired != nil {
+		return expired, nil
+	}
+
+	ctx, cancel := c.operationContext(ctx, operationStatus)
+	defer cancel()
+
+	// Fetch from cache or API, sharing concurrent lookups for the same token
+	respBody, err := c.cachedFetch(ctx, cacheKindPaymentStatus, token, func(ctx context.Context) ([]byte, error) {
+		respBody, _, err := c.makeRequest(ctx, http.MethodGet, c.statusEndpoint(token), nil)
+		return respBody, err
+	}, isTerminalStatusBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check payment status: %w", err)
+	}
+
+	// Parse API response
+	var apiResp PaymentStatusResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	// Record payments the gateway reports as ended
+	c.syncStatusCheck(ctx, token, c.normalizeStatusResponse(ctx, token, &apiResp))
+
 	return &apiResp, nil
 }
 
-// RefundPayment initiates a refund for a transaction
+// isTerminalStatusBody reports whether a payment status response body describes a terminal state
+func isTerminalStatusBody(body []byte) bool {
+	var resp PaymentStatusResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false
+	}
+
+	return resp.NormalizedStatus().IsTerminal()
+}
+
+// RefundPayment initiates a refund for a transaction. A wallet without enough
+// balance returns ErrInsufficientBalance, any other declined refund ErrRefundFailed.
 func (c *Client) RefundPayment(ctx context.Context, transactionID string, amount int64) (*RefundResponse, error) {
+	resp, _, err := c.refund(ctx, transactionID, amount)
+	return resp, err
+}
+
+// refund refunds a transaction and also returns the refunded amount, which for
+// full refunds comes from the gateway or the stored transaction
+func (c *Client) refund(ctx context.Context, transactionID string, amount int64) (*RefundResponse, int64, error) {
+	// The refund and its tracking record must not be cut apart by a shutdown
+	ctx, done, err := c.beginOperation(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer done()
+
+	ctx, cancel := c.operationContext(ctx, operationInit)
+	defer cancel()
+
 	// Create refund request
 	req := &RefundRequest{
 		TransactionID: transactionID,
 		Amount:        amount,
 	}
 
+	// Refuse refunding more than the recorded refunds left
+	transaction, release, err := c.beginRefund(ctx, transactionID, amount)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+
 	// Prepare API request body
 	apiReq := map[string]interface{}{
 		"api_key":        c.config.GetAPIKey(),
@@ -231,40 +739,90 @@ func (c *Client) RefundPayment(ctx context.Context, transactionID string, amount
 	respBody, _, err := c.makeRequest(
 		ctx,
 		http.MethodPost,
-		fmt.Sprintf("/v3/business/%s/transaction/%s/refund", "business", req.TransactionID),
+		c.refundEndpoint(req.TransactionID),
 		apiReq,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to refund payment: %w", err)
+		if isInsufficientBalance(err) {
+			return nil, 0, fmt.Errorf("%w: %v", ErrInsufficientBalance, err)
+		}
+		return nil, 0, fmt.Errorf("failed to refund payment: %w", err)
 	}
 
 	// Parse API response
 	var apiResp RefundResponse
 	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		return nil, fmt.Errorf("failed to parse API response: %w", err)
+		return nil, 0, fmt.Errorf("failed to parse API response: %w", err)
 	}
 
 	// Check if refund was successful
 	if !apiResp.Status {
-		return &apiResp, fmt.Errorf("payment refund failed: %s", apiResp.Message)
+		if containsInsufficientBalanceHint(apiResp.Message) {
+			return &apiResp, 0, fmt.Errorf("%w: %s", ErrInsufficientBalance, apiResp.Message)
+		}
+		return &apiResp, 0, fmt.Errorf("payment %w: %s", ErrRefundFailed, apiResp.Message)
 	}
 
-	return &apiResp, nil
+	// Refunds settle later; record this one for the refund tracker even if the
+	// caller went away, since the gateway accepted it
+	c.trackRefund(context.WithoutCancel(ctx), transaction, transactionID, amount, &apiResp)
+
+	refunded := apiResp.Amount
+	if refunded == 0 {
+		refunded = amount
+	}
+	if refunded == 0 && transaction != nil {
+		// The guard only allows full refunds of transactions without earlier refunds
+		refunded = transaction.Amount
+	}
+
+	return &apiResp, refunded, nil
 }
 
 // makeRequest creates and executes an HTTP request to the Vandar API
 func (c *Client) makeRequest(ctx context.Context, method, endpoint string, body interface{}) ([]byte, int, error) {
-	url := c.config.GetBaseURL() + endpoint
-
-	var bodyReader io.Reader
+	var jsonData []byte
 	if body != nil {
-		jsonData, err := json.Marshal(body)
+		var err error
+		jsonData, err = json.Marshal(body)
 		if err != nil {
 			return nil, 0, fmt.Errorf("failed to marshal request body: %w", err)
 		}
+	}
+
+	respBody, statusCode, err := c.doWithRetry(ctx, method, endpoint, jsonData)
+
+	// The access token may have been revoked before its expiry, refresh it and retry once
+	if statusCode == http.StatusUnauthorized && c.usesAccessToken(endpoint) {
+		c.log(ctx).Warn(ctx, "Access token rejected, refreshing and retrying", map[string]interface{}{
+			"method":   method,
+			"endpoint": redactEndpoint(endpoint),
+		})
+		c.tokenProvider.Invalidate()
+		respBody, statusCode, err = c.doWithRetry(ctx, method, endpoint, jsonData)
+	}
+
+	return respBody, statusCode, err
+}
+
+// doRequest performs a single HTTP request to the Vandar API
+func (c *Client) doRequest(ctx context.Context, method, endpoint string, jsonData []byte) ([]byte, int, error) {
+	url, err := joinURL(c.config.GetBaseURL(), endpoint)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build request url: %w", err)
+	}
+
+	var bodyReader io.Reader
+	if jsonData != nil {
 		bodyReader = bytes.NewReader(jsonData)
 	}
 
+	// Resolve the credential for this endpoint
+	authToken, err := c.authorizationToken(ctx, endpoint)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	// Create the request
 	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
 	if err != nil {
@@ -274,32 +832,46 @@ func (c *Client) makeRequest(ctx context.Context, method, endpoint string, body
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.config.GetAPIKey())
+	req.Header.Set("Authorization", "Bearer "+authToken)
 
 	// Add tracking information
-	requestID := generateRequestID()
+	requestID := c.idGenerator().NewRequestID()
 	req.Header.Set("X-Request-ID", requestID)
+	if correlationID, ok := CorrelationIDFromContext(ctx); ok {
+		req.Header.Set(outboundCorrelationHeader(c.config), correlationID)
+	}
 
-	// Log the request (without sensitive data)
-	c.logger.Debug(ctx, "Making API request", map[string]interface{}{
-		"method":     method,
-		"endpoint":   endpoint,
-		"request_id": requestID,
-	})
+	// Let the gateway know how long we are willing to wait
+	if hint, ok := timeoutHint(ctx); ok {
+		req.Header.Set("X-Timeout", hint)
+	}
 
-	// Execute the request with retry mechanism
-	var resp *http.Response
-	var respErr error
+	// Log the request (without sensitive data); traced tokens include the body
+	requestFields := map[string]interface{}{
+		"method":             method,
+		"endpoint":           redactEndpoint(endpoint),
+		"gateway_request_id": requestID,
+	}
+	if IsTraced(ctx) && len(jsonData) > 0 {
+		requestFields["request_body"] = traceBody(jsonData)
+	}
+	c.log(ctx).Debug(ctx, "Making API request", requestFields)
 
 	// Execute request
-	resp, respErr = c.httpClient.Do(req)
+	resp, respErr := c.httpClient.Do(req)
 	if respErr != nil {
-		c.logger.Error(ctx, "API request failed", respErr, map[string]interface{}{
-			"method":     method,
-			"endpoint":   endpoint,
-			"request_id": requestID,
-		})
-		return nil, 0, fmt.Errorf("api request failed: %w", respErr)
+		fields := map[string]interface{}{
+			"method":             method,
+			"endpoint":           redactEndpoint(endpoint),
+			"gateway_request_id": requestID,
+		}
+		// Requests given up on, e.g. the loser of a hedged lookup, aren't failures
+		if errors.Is(ctx.Err(), context.Canceled) {
+			c.log(ctx).Debug(ctx, "API request cancelled", fields)
+		} else {
+			c.log(ctx).Error(ctx, "API request failed", respErr, fields)
+		}
+		return nil, 0, fmt.Errorf("%w: api request failed: %w", ErrNetworkFailure, respErr)
 	}
 	defer resp.Body.Close()
 
@@ -309,13 +881,40 @@ func (c *Client) makeRequest(ctx context.Context, method, endpoint string, body
 		return nil, resp.StatusCode, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	// Log response (without sensitive data)
-	c.logger.Debug(ctx, "Received API response", map[string]interface{}{
-		"method":      method,
-		"endpoint":    endpoint,
-		"status_code": resp.StatusCode,
-		"request_id":  requestID,
-	})
+	// Rejected credentials come as 401/403 or a redirect to the login page
+	if authErr := gatewayAuthFailure(req, resp, respBody); authErr != nil {
+		c.log(ctx).Error(ctx, "Payment gateway rejected the credentials, check the API key", authErr, map[string]interface{}{
+			"method":             method,
+			"endpoint":           redactEndpoint(endpoint),
+			"status_code":        resp.StatusCode,
+			"gateway_request_id": requestID,
+		})
+		return nil, resp.StatusCode, authErr
+	}
+
+	// Maintenance pages are HTML rather than JSON
+	if unavailable := gatewayUnavailable(resp, respBody); unavailable != nil {
+		c.log(ctx).Warn(ctx, "Payment gateway returned a non-JSON response", map[string]interface{}{
+			"method":             method,
+			"endpoint":           redactEndpoint(endpoint),
+			"status_code":        resp.StatusCode,
+			"content_type":       unavailable.ContentType,
+			"gateway_request_id": requestID,
+		})
+		return nil, resp.StatusCode, unavailable
+	}
+
+	// Log response (without sensitive data); traced tokens include the body
+	responseFields := map[string]interface{}{
+		"method":             method,
+		"endpoint":           redactEndpoint(endpoint),
+		"status_code":        resp.StatusCode,
+		"gateway_request_id": requestID,
+	}
+	if IsTraced(ctx) {
+		responseFields["response_body"] = traceBody(respBody)
+	}
+	c.log(ctx).Debug(ctx, "Received API response", responseFields)
 
 	// Handle non-2xx responses
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
@@ -323,9 +922,15 @@ func (c *Client) makeRequest(ctx context.Context, method, endpoint string, body
 		if err := json.Unmarshal(respBody, &apiErr); err != nil {
 			// If can't parse as APIError, create a generic one
 			apiErr = APIError{
-				Message: string(respBody),
+				Message: redactBody(string(respBody)),
 				Code:    fmt.Sprintf("%d", resp.StatusCode),
 			}
+		} else {
+			// Gateway messages may quote the card number, and end up in logs
+			apiErr.Message = redactBody(apiErr.Message)
+			for field, message := range apiErr.Errors {
+				apiErr.Errors[field] = redactBody(message)
+			}
 		}
 
 		return nil, resp.StatusCode, &apiErr
@@ -334,7 +939,31 @@ func (c *Client) makeRequest(ctx context.Context, method, endpoint string, body
 	return respBody, resp.StatusCode, nil
 }
 
-// generateRequestID creates a unique ID for request tracking
-func generateRequestID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+// authorizationToken returns the bearer credential for an endpoint: an OAuth access
+// token for business API paths when a token provider is configured, the API key otherwise
+func (c *Client) authorizationToken(ctx context.Context, endpoint string) (string, error) {
+	if !c.usesAccessToken(endpoint) {
+		return c.config.GetAPIKey(), nil
+	}
+
+	token, err := c.tokenProvider.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain access token: %w", err)
+	}
+
+	return token, nil
+}
+
+// usesAccessToken reports whether requests to the endpoint authenticate with an access token
+func (c *Client) usesAccessToken(endpoint string) bool {
+	return c.tokenProvider != nil && c.isBusinessEndpoint(endpoint)
+}
+
+// businessSlug returns the configured business name used in business API paths
+func (c *Client) businessSlug() string {
+	if business := configValues(c.config).Business; business != "" {
+		return business
+	}
+
+	return "business"
 }
@@ -0,0 +1,65 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// client_info.go implements recording the device that initiated a payment
+package vandargo
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// MaxUserAgentLength is the longest user agent stored on a transaction, in bytes
+const MaxUserAgentLength = 512
+
+// ClientInfo describes the device that initiated a payment, kept on the
+// transaction for fraud analysis
+type ClientInfo struct {
+	// IP is the payer's IP address
+	IP string
+
+	// UserAgent is the payer's User-Agent header
+	UserAgent string
+}
+
+// clientInfoFromRequest returns the client info of an HTTP request. The IP is
+// resolved by ClientIPMiddleware, so it is the real client behind trusted proxies.
+func clientInfoFromRequest(r *http.Request) *ClientInfo {
+	return &ClientInfo{
+		IP:        getClientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// normalized returns the info as stored: an unparsable IP is dropped and the
+// user agent is sanitized and truncated to MaxUserAgentLength
+func (i *ClientInfo) normalized() ClientInfo {
+	if i == nil {
+		return ClientInfo{}
+	}
+
+	var info ClientInfo
+	if addr, err := netip.ParseAddr(strings.TrimSpace(i.IP)); err == nil {
+		info.IP = addr.Unmap().WithZone("").String()
+	}
+	info.UserAgent = truncateUTF8(SanitizeInput(i.UserAgent), MaxUserAgentLength)
+	return info
+}
+
+// applyClientInfo records a payment's client info on its transaction
+func applyClientInfo(transaction *Transaction, info *ClientInfo) {
+	normalized := info.normalized()
+	transaction.ClientIP = normalized.IP
+	transaction.UserAgent = normalized.UserAgent
+}
+
+// clientInfoLogFields adds a transaction's client info to its log fields for the
+// audit trail
+func clientInfoLogFields(transaction *Transaction, fields map[string]interface{}) map[string]interface{} {
+	if transaction.ClientIP != "" {
+		fields["client_ip"] = transaction.ClientIP
+	}
+	if transaction.UserAgent != "" {
+		fields["user_agent"] = transaction.UserAgent
+	}
+	return fields
+}
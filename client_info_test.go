@@ -0,0 +1,113 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestClientInfoNormalized(t *testing.T) {
+	tests := []struct {
+		info ClientInfo
+		want ClientInfo
+	}{
+		{ClientInfo{IP: "198.51.100.9", UserAgent: "Mozilla/5.0"}, ClientInfo{IP: "198.51.100.9", UserAgent: "Mozilla/5.0"}},
+		{ClientInfo{IP: " ::ffff:198.51.100.9 "}, ClientInfo{IP: "198.51.100.9"}},
+		{ClientInfo{IP: "fe80::1%eth0"}, ClientInfo{IP: "fe80::1"}},
+		{ClientInfo{IP: "not-an-ip", UserAgent: " Mozilla/5.0\r\nX-Injected: 1 "}, ClientInfo{UserAgent: "Mozilla/5.0X-Injected: 1"}},
+	}
+	for _, tt := range tests {
+		if got := tt.info.normalized(); got != tt.want {
+			t.Errorf("%+v: got %+v, want %+v", tt.info, got, tt.want)
+		}
+	}
+
+	// Long user agents are cut on a character boundary
+	long := (&ClientInfo{UserAgent: strings.Repeat("é", MaxUserAgentLength)}).normalized().UserAgent
+	if len(long) > MaxUserAgentLength || !utf8.ValidString(long) {
+		t.Fatalf("user agent of %d bytes", len(long))
+	}
+
+	if got := (*ClientInfo)(nil).normalized(); got != (ClientInfo{}) {
+		t.Fatalf("nil info %+v", got)
+	}
+}
+
+func TestClientInfoRecordedByHandler(t *testing.T) {
+	config := testConfig(t, func(c *Config) {
+		c.TrustedProxies = []string{"10.0.0.0/8"}
+	})
+	client, storage, logger := newTestClient(t, config, newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": "tok-device"})))
+	handler := ClientIPMiddleware(config)(client.Handler().ServeHTTP)
+
+	// The payer can't set the client info through the body
+	req := httptest.NewRequest(http.MethodPost, "/payments/init", strings.NewReader(
+		`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042","ClientInfo":{"IP":"1.2.3.4"},"client_ip":"1.2.3.4"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Android 14)")
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	req.RemoteAddr = "10.0.0.5:5000"
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	transaction, err := storage.GetTransaction(context.Background(), "tok-device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.ClientIP != "198.51.100.9" || transaction.UserAgent != "Mozilla/5.0 (Android 14)" {
+		t.Fatalf("client IP %q, user agent %q", transaction.ClientIP, transaction.UserAgent)
+	}
+
+	// The payer's response doesn't repeat them
+	if strings.Contains(rec.Body.String(), "198.51.100.9") || strings.Contains(rec.Body.String(), "Android") {
+		t.Fatalf("init response %s", rec.Body)
+	}
+
+	entry, found := logger.find("Payment initialized")
+	if !found || entry.fields["client_ip"] != "198.51.100.9" || entry.fields["user_agent"] != "Mozilla/5.0 (Android 14)" {
+		t.Fatalf("client info not logged:\n%s", logger.dump())
+	}
+}
+
+func TestClientInfoProgrammatic(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.AdminKey = "admin-key"
+	}), newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": "tok-device"})))
+
+	_, err := client.InitiatePaymentWithRequest(context.Background(), &PaymentInitRequest{
+		Amount:      100000,
+		Description: "Order 1042",
+		ClientInfo:  &ClientInfo{IP: "2001:db8::1", UserAgent: "ShopApp/3.2"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), "tok-device")
+	if transaction.ClientIP != "2001:db8::1" || transaction.UserAgent != "ShopApp/3.2" {
+		t.Fatalf("client IP %q, user agent %q", transaction.ClientIP, transaction.UserAgent)
+	}
+
+	// Admins see the device in the transaction detail
+	req := httptest.NewRequest(http.MethodGet, "/payments/transactions/tok-device", nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	req.Header.Set(AdminKeyHeader, "admin-key")
+	rec := httptest.NewRecorder()
+	client.Handler().ServeHTTP(rec, req)
+
+	var detail TransactionDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if detail.ClientIP != "2001:db8::1" || detail.UserAgent != "ShopApp/3.2" {
+		t.Fatalf("detail %s", rec.Body)
+	}
+}
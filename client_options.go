@@ -0,0 +1,210 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// client_options.go implements deriving configured copies of a client
+package vandargo
+
+import (
+	"net/http"
+	"time"
+)
+
+// ClientOption changes a setting of a client derived with Clone
+type ClientOption func(*Client)
+
+// WithClientHTTPClient sets the HTTP client used for gateway requests
+func WithClientHTTPClient(httpClient HTTPClientInterface) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+		c.simulated = false
+	}
+}
+
+// WithClientTimeout sets the per-attempt timeout of gateway requests, e.g. a longer
+// one for batch jobs; it replaces Config.Timeout for the derived client
+func WithClientTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.requestTimeout = timeout
+
+		// Keep a default HTTP client from cutting requests off earlier
+		if httpClient, ok := c.httpClient.(*http.Client); ok {
+			clientCopy := *httpClient
+			clientCopy.Timeout = timeout
+			c.httpClient = &clientCopy
+		}
+	}
+}
+
+// WithClientConfig sets the configuration
+func WithClientConfig(config ConfigInterface) ClientOption {
+	return func(c *Client) {
+		c.config = config
+	}
+}
+
+// WithClientLogger sets the logger; nil drops all entries
+func WithClientLogger(logger LoggerInterface) ClientOption {
+	return func(c *Client) {
+		c.logger = loggerOrDiscard(logger)
+	}
+}
+
+// WithClientStorage sets the transaction storage
+func WithClientStorage(storage StorageInterface) ClientOption {
+	return func(c *Client) {
+		c.storage = storage
+	}
+}
+
+// WithClientTokenProvider sets the access token provider for business API endpoints
+func WithClientTokenProvider(tokenProvider TokenProvider) ClientOption {
+	return func(c *Client) {
+		c.tokenProvider = tokenProvider
+		c.ownsTokenProvider = false
+	}
+}
+
+// WithClientCache sets the cache of status and transaction info responses
+func WithClientCache(cache CacheInterface) ClientOption {
+	return func(c *Client) {
+		c.cache = cache
+	}
+}
+
+// WithClientMetrics sets the metrics recorder; nil disables metrics
+func WithClientMetrics(metrics MetricsInterface) ClientOption {
+	return func(c *Client) {
+		if metrics == nil {
+			metrics = noopMetrics{}
+		}
+		c.metrics = metrics
+	}
+}
+
+// WithClientResponseEncoder sets the encoder of handler responses
+func WithClientResponseEncoder(encoder ResponseEncoder) ClientOption {
+	return func(c *Client) {
+		c.responseEncoder = encoder
+	}
+}
+
+// WithClientIDGenerator sets the generator of transaction and request IDs
+func WithClientIDGenerator(generator IDGenerator) ClientOption {
+	return func(c *Client) {
+		if generator == nil {
+			generator = defaultIDGenerator
+		}
+		c.ids = generator
+	}
+}
+
+// WithClientValidator sets the validator of requests, e.g. one accepting other
+// card number lengths; nil restores the default patterns
+func WithClientValidator(validator *Validator) ClientOption {
+	return func(c *Client) {
+		c.requestValidator = validator
+	}
+}
+
+// WithClientChallengeVerifier sets the verifier of the challenge tokens sent with
+// payment initializations
+func WithClientChallengeVerifier(verifier ChallengeVerifier) ClientOption {
+	return func(c *Client) {
+		c.challengeVerifier = verifier
+	}
+}
+
+// WithClientChallengeRequirement sets a function deciding per request whether
+// payment initializations need a challenge token, e.g. a ChallengeSwitch turned
+// on while traffic is elevated; Config.RequireChallenge still applies
+func WithClientChallengeRequirement(required func(r *http.Request) bool) ClientOption {
+	return func(c *Client) {
+		c.challengeRequired = required
+	}
+}
+
+// WithClientVelocityChecker sets the velocity rules evaluated before payment
+// initializations; nil disables them
+func WithClientVelocityChecker(checker *VelocityChecker) ClientOption {
+	return func(c *Client) {
+		c.velocity = checker.withKVStore(c.kv)
+	}
+}
+
+// WithClientKVStore shares state between the instances of a deployment through
+// one store, e.g. a RedisKVStore: route rate limits count in it, authenticated
+// POST routes honor Idempotency-Key headers, the callback signature requires a
+// signed X-Nonce accepted once, and a velocity checker created without a store
+// keeps its counters in it. nil stops sharing rate limits, idempotency keys and nonces.
+func WithClientKVStore(kv KVStore) ClientOption {
+	return func(c *Client) {
+		c.kv = kv
+		c.velocity = c.velocity.withKVStore(kv)
+	}
+}
+
+// WithClientHooks sets the lifecycle hooks
+func WithClientHooks(hooks Hooks) ClientOption {
+	return func(c *Client) {
+		c.hooks = hooks
+	}
+}
+
+// WithClientProvider sets the payment provider used by the HTTP handlers
+func WithClientProvider(provider PaymentProvider) ClientOption {
+	return func(c *Client) {
+		c.provider = provider
+	}
+}
+
+// WithClientClock sets the clock; nil restores the real clock
+func WithClientClock(clock Clock) ClientOption {
+	return func(c *Client) {
+		if clock == nil {
+			clock = RealClock()
+		}
+		c.clock = clock
+		// Memoized verifications expire on the same clock
+		c.verifyResults = NewMemoryCache(WithCacheClock(clock))
+	}
+}
+
+// WithClientBackoff sets the strategy deciding the waits between retries of
+// gateway requests; nil restores exponential backoff from Config.RetryWaitTime
+func WithClientBackoff(strategy BackoffStrategy) ClientOption {
+	return func(c *Client) {
+		c.backoff = strategy
+	}
+}
+
+// Clone returns a copy of the client with the options applied. The original is
+// never modified, so clients already serving requests can safely be cloned. The copy
+// shares storage, logger, cache and in-flight request deduplication with the original
+// unless an option replaces them.
+func (c *Client) Clone(opts ...ClientOption) *Client {
+	clone := *c
+	for _, opt := range opts {
+		opt(&clone)
+	}
+
+	// The built-in token provider refreshes through the client that created it. The
+	// clone keeps the tokens of the original, since the gateway rotates the refresh
+	// token and only the latest one is accepted, unless it uses another account.
+	if clone.ownsTokenProvider {
+		shared, ok := c.tokenProvider.(*RefreshTokenProvider)
+		if ok && sameTokenAccount(c.config, clone.config) {
+			clone.tokenProvider = shared.withTransport(clientTransport{client: &clone}, clone.logger)
+		} else if tokenProvider, err := NewRefreshTokenProvider(clone.config, clientTransport{client: &clone}, clone.logger); err == nil {
+			clone.tokenProvider = tokenProvider
+		}
+	}
+
+	return &clone
+}
+
+// sameTokenAccount reports whether two configurations obtain access tokens for
+// the same account from the same endpoint
+func sameTokenAccount(a, b ConfigInterface) bool {
+	valuesA, valuesB := configValues(a), configValues(b)
+	return a.GetBaseURL() == b.GetBaseURL() &&
+		valuesA.RefreshToken == valuesB.RefreshToken &&
+		valuesA.TokenEndpoint == valuesB.TokenEndpoint
+}
@@ -0,0 +1,154 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// rotatingTokenGateway issues access tokens for a refresh token it rotates on
+// every exchange, accepting only the latest one, like the business API
+type rotatingTokenGateway struct {
+	mutex       sync.Mutex
+	refresh     string
+	access      string
+	exchanges   int
+	rejected    int
+	balanceHits int
+}
+
+func newRotatingTokenGateway(refresh string) *rotatingTokenGateway {
+	return &rotatingTokenGateway{refresh: refresh}
+}
+
+func (g *rotatingTokenGateway) Do(req *http.Request) (*http.Response, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/refreshtoken"):
+		body, _ := io.ReadAll(req.Body)
+		var payload map[string]string
+		_ = json.Unmarshal(body, &payload)
+		if payload["refreshtoken"] != g.refresh {
+			g.rejected++
+			return stubResponse(req, http.StatusUnauthorized, map[string]string{"message": "refresh token consumed"}), nil
+		}
+		g.exchanges++
+		g.refresh = fmt.Sprintf("refresh-%d", g.exchanges)
+		g.access = fmt.Sprintf("access-%d", g.exchanges)
+		return stubResponse(req, http.StatusOK, map[string]interface{}{
+			"access_token":  g.access,
+			"refresh_token": g.refresh,
+			"expires_in":    3600,
+		}), nil
+	case strings.Contains(req.URL.Path, "/balance"):
+		if req.Header.Get("Authorization") != "Bearer "+g.access {
+			return stubResponse(req, http.StatusUnauthorized, map[string]string{"message": "invalid access token"}), nil
+		}
+		g.balanceHits++
+		return stubResponse(req, http.StatusOK, map[string]interface{}{"status": true, "balance": 5000}), nil
+	}
+	return stubResponse(req, http.StatusNotFound, map[string]string{"message": "not found"}), nil
+}
+
+func TestCloneSharesRotatedRefreshToken(t *testing.T) {
+	gateway := newRotatingTokenGateway("refresh-0")
+	config := testConfig(t, func(c *Config) {
+		c.RefreshToken = "refresh-0"
+		c.Business = "shop"
+		c.MaxRetries = 0
+	})
+	client, _, _ := newTestClient(t, config, gateway)
+
+	if _, err := client.GetWalletBalance(context.Background()); err != nil {
+		t.Fatalf("original: %v", err)
+	}
+
+	// The clone reuses the cached access token
+	clone := client.WithHTTPClient(gateway)
+	if _, err := clone.GetWalletBalance(context.Background()); err != nil {
+		t.Fatalf("clone: %v", err)
+	}
+	if gateway.exchanges != 1 {
+		t.Fatalf("%d token exchanges, want the clone to reuse the access token", gateway.exchanges)
+	}
+
+	// Once the access token is revoked, the clone refreshes with the rotated token
+	clone.tokenProvider.Invalidate()
+	if _, err := clone.GetWalletBalance(context.Background()); err != nil {
+		t.Fatalf("clone after invalidation: %v", err)
+	}
+	if gateway.rejected != 0 || gateway.exchanges != 2 {
+		t.Fatalf("%d exchanges, %d rejected; want the clone to use the rotated refresh token", gateway.exchanges, gateway.rejected)
+	}
+
+	// And the original sees the token its clone rotated
+	if _, err := client.GetWalletBalance(context.Background()); err != nil {
+		t.Fatalf("original after the clone refreshed: %v", err)
+	}
+	if gateway.exchanges != 2 || gateway.balanceHits != 4 {
+		t.Fatalf("%d exchanges, %d balance lookups", gateway.exchanges, gateway.balanceHits)
+	}
+}
+
+func TestCloneWithOtherAccountGetsOwnTokens(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) { c.RefreshToken = "refresh-a" }), nil)
+	clone := client.Clone(WithClientConfig(testConfig(t, func(c *Config) { c.RefreshToken = "refresh-b" })))
+
+	original := client.tokenProvider.(*RefreshTokenProvider)
+	other := clone.tokenProvider.(*RefreshTokenProvider)
+	if original.tokenState == other.tokenState || other.refreshToken != "refresh-b" {
+		t.Fatal("clone for another account shares the original's tokens")
+	}
+}
+
+func TestCloneLeavesOriginalUntouched(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{}))
+	client, _, _ := newTestClient(t, testConfig(t), transport)
+
+	cache := NewMemoryCache()
+	clone := client.WithCache(cache).WithHTTPClient(NewSimulatorTransport())
+
+	if client.cache != nil || client.httpClient != HTTPClientInterface(transport) {
+		t.Fatal("deriving a client changed the original")
+	}
+	if clone.cache != cache || clone == client {
+		t.Fatal("clone didn't get its settings")
+	}
+}
+
+func TestWithHTTPClientConcurrentWithInitiatePayment(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := client.InitiatePayment(context.Background(), 10000+int64(i), "Order", nil)
+			if err != nil {
+				errs <- err
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			derived := client.WithHTTPClient(NewSimulatorTransport())
+			if _, err := derived.InitiatePayment(context.Background(), 20000, "Derived order", nil); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,298 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// verifySuccess is a successful verify response for webhookToken
+func verifySuccess() stubStep {
+	return jsonStep(http.StatusOK, map[string]interface{}{
+		"status":       1,
+		"amount":       "100000",
+		"transId":      160000000001,
+		"factorNumber": "1042",
+		"description":  "Order 1042",
+	})
+}
+
+func TestConcurrentVerifySharesOneRequest(t *testing.T) {
+	step := verifySuccess()
+	step.delay = 50 * time.Millisecond
+	transport := newStubTransport(step)
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	const callers = 50
+	responses := make([]*PaymentVerifyResponse, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.VerifyPayment(context.Background(), webhookToken)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			responses[i] = resp
+		}(i)
+	}
+	wg.Wait()
+
+	if transport.count() != 1 {
+		t.Fatalf("%d verify requests, want 1", transport.count())
+	}
+
+	// Every caller gets its own copy of the shared result
+	for i, resp := range responses {
+		if resp == nil || resp.TransID != 160000000001 {
+			t.Fatalf("caller %d got %+v", i, resp)
+		}
+		for j := 0; j < i; j++ {
+			if responses[j] == resp {
+				t.Fatalf("callers %d and %d share a response", j, i)
+			}
+		}
+	}
+
+	transaction, err := storage.GetTransaction(context.Background(), webhookToken)
+	if err != nil || transaction.Status != StatusPaid {
+		t.Fatalf("transaction %+v, %v", transaction, err)
+	}
+
+	// An immediate repeat is served from the memoized result
+	if _, err := client.VerifyPayment(context.Background(), webhookToken); err != nil || transport.count() != 1 {
+		t.Fatalf("repeat: %v after %d requests", err, transport.count())
+	}
+}
+
+func TestVerifyMemoization(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	transport := newStubTransport(verifySuccess())
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.VerifyMemoTTL = 20 * time.Second
+	}), transport, WithClientClock(clock))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.VerifyPayment(context.Background(), webhookToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if transport.count() != 1 {
+		t.Fatalf("%d requests within the memo TTL, want 1", transport.count())
+	}
+
+	clock.Advance(20*time.Second + time.Millisecond)
+	client.VerifyPayment(context.Background(), webhookToken)
+	if transport.count() != 2 {
+		t.Fatalf("%d requests after the memo TTL, want 2", transport.count())
+	}
+}
+
+func TestVerifyMemoizationDisabled(t *testing.T) {
+	transport := newStubTransport(verifySuccess())
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.VerifyMemoTTL = -1
+	}), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	client.VerifyPayment(context.Background(), webhookToken)
+	client.VerifyPayment(context.Background(), webhookToken)
+	if transport.count() != 2 {
+		t.Fatalf("%d requests, want every sequential call to reach the gateway", transport.count())
+	}
+}
+
+func TestTransactionInfoRecordsWages(t *testing.T) {
+	info := func(wages map[string]interface{}) stubStep {
+		body := map[string]interface{}{
+			"status":       1,
+			"amount":       "100000",
+			"transId":      160000000001,
+			"refnumber":    "GmshtyjwKSu1aUafmLYT0m7ieMhs5Tec",
+			"trackingCode": "212475",
+			"factorNumber": "1042",
+			"description":  "Order 1042",
+		}
+		for key, value := range wages {
+			body[key] = value
+		}
+		return jsonStep(http.StatusOK, body)
+	}
+
+	transport := newStubTransport(
+		verifySuccess(),
+		info(map[string]interface{}{"wage": "1,000", "shaparakWage": "120"}),
+		info(nil),
+	)
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.VerifyMemoTTL = -1
+		c.CacheTTL = -1
+	}), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	result, err := client.VerifyPaymentDetailed(context.Background(), webhookToken, WithEnrichment(true))
+	if err != nil || !result.Enriched {
+		t.Fatalf("verify: %+v, %v", result, err)
+	}
+	if result.Wage != 1000 || result.ShaparakWage != 120 {
+		t.Fatalf("result wages %d and %d", result.Wage, result.ShaparakWage)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Wage != 1000 || transaction.ShaparakWage != 120 || transaction.NetAmount != 98880 {
+		t.Fatalf("stored wage %d, shaparak wage %d, net %d", transaction.Wage, transaction.ShaparakWage, transaction.NetAmount)
+	}
+	if transaction.TrackingCode != "212475" || transaction.Status != StatusPaid {
+		t.Fatalf("stored transaction %+v", transaction)
+	}
+
+	// A later answer without wages means there were none
+	if _, err := client.GetTransactionInfo(context.Background(), webhookToken); err != nil {
+		t.Fatal(err)
+	}
+	transaction, _ = storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Wage != 0 || transaction.ShaparakWage != 0 || transaction.NetAmount != 100000 {
+		t.Fatalf("stored wage %d, shaparak wage %d, net %d", transaction.Wage, transaction.ShaparakWage, transaction.NetAmount)
+	}
+}
+
+// interleavingStorage runs a function once, right after a transaction was read,
+// to change it before the reader writes back
+type interleavingStorage struct {
+	*MemoryStorage
+	once  sync.Once
+	after func()
+}
+
+func (s *interleavingStorage) GetTransaction(ctx context.Context, token string) (*Transaction, error) {
+	transaction, err := s.MemoryStorage.GetTransaction(ctx, token)
+	s.once.Do(s.after)
+	return transaction, err
+}
+
+func TestTransactionInfoKeepsConcurrentStatus(t *testing.T) {
+	memory := NewMemoryStorage()
+	storeWebhookPayment(t, memory, StatusInit)
+	paid := StatusPaid
+	storage := &interleavingStorage{MemoryStorage: memory, after: func() {
+		// A verification lands while the info is being recorded
+		memory.PatchTransaction(context.Background(), webhookToken, TransactionPatch{Status: &paid})
+	}}
+
+	client, err := NewClient(testConfig(t), storage, &captureLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.recordTransactionInfo(context.Background(), webhookToken, &TransactionInfoResponse{
+		Status: 1, Amount: 100000, Wage: 1000, RefNumber: "ref-1", TrackingCode: "212475",
+	})
+
+	transaction, _ := memory.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusPaid || transaction.Wage != 1000 || transaction.TrackingCode != "212475" {
+		t.Fatalf("stored transaction %+v", transaction)
+	}
+}
+
+func TestTransactionDetailIncludesWages(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.AdminKey = "admin-key"
+	}), nil)
+	storeWebhookPayment(t, storage, StatusPaid)
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	transaction.Wage, transaction.ShaparakWage, transaction.NetAmount = 1000, 120, 98880
+	storage.UpdateTransaction(context.Background(), transaction)
+
+	req := httptest.NewRequest(http.MethodGet, "/payments/transactions/"+webhookToken, nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	req.Header.Set(AdminKeyHeader, "admin-key")
+	rec := httptest.NewRecorder()
+	client.Handler().ServeHTTP(rec, req)
+
+	var detail TransactionDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got := detail.Transaction; got.Wage != 1000 || got.ShaparakWage != 120 || got.NetAmount != 98880 {
+		t.Fatalf("detail wages %d, %d, net %d", got.Wage, got.ShaparakWage, got.NetAmount)
+	}
+}
+
+func TestCardOwnerMatchGatewayFlag(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": "tok-owner"}))
+	client, _, logger := newTestClient(t, testConfig(t), transport)
+
+	_, err := client.InitiatePaymentWithRequest(context.Background(), &PaymentInitRequest{
+		Amount:                100000,
+		Description:           "Order 1042",
+		Mobile:                "09123456789",
+		NationalCode:          "0012345679",
+		RequireCardOwnerMatch: true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]interface{}
+	_, raw := transport.request(0)
+	if err := json.Unmarshal(raw, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["national_code"] != "0012345679" || body[cardOwnerCheckField] != true || body["mobile"] != "09123456789" {
+		t.Fatalf("gateway body %v", body)
+	}
+
+	// The national code is masked in logs
+	if strings.Contains(logger.dump(), "0012345679") {
+		t.Fatalf("national code logged:\n%s", logger.dump())
+	}
+}
+
+func TestCardOwnerMatchRecorded(t *testing.T) {
+	for _, match := range []bool{true, false} {
+		step := jsonStep(http.StatusOK, map[string]interface{}{
+			"status":         1,
+			"amount":         "100000",
+			"transId":        160000000001,
+			"factorNumber":   "1042",
+			"description":    "Order 1042",
+			"cardOwnerMatch": match,
+		})
+		client, storage, _ := newTestClient(t, testConfig(t), newStubTransport(step))
+		storeWebhookPayment(t, storage, StatusInit)
+
+		result, err := client.Verify(context.Background(), webhookToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.CardOwnerMatch == nil || *result.CardOwnerMatch != match {
+			t.Fatalf("match %v: result %v", match, result.CardOwnerMatch)
+		}
+
+		transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+		if transaction.Metadata[CardOwnerMatchMetadataKey] != strconv.FormatBool(match) {
+			t.Fatalf("match %v: metadata %v", match, transaction.Metadata)
+		}
+	}
+
+	// Without the indication nothing is recorded
+	client, storage, _ := newTestClient(t, testConfig(t), newStubTransport(verifySuccess()))
+	storeWebhookPayment(t, storage, StatusInit)
+	result, err := client.Verify(context.Background(), webhookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if _, found := transaction.Metadata[CardOwnerMatchMetadataKey]; found || result.CardOwnerMatch != nil {
+		t.Fatalf("metadata %v, result %v", transaction.Metadata, result.CardOwnerMatch)
+	}
+}
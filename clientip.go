@@ -0,0 +1,166 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// clientip.go implements client IP resolution with trusted proxy handling
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// clientIPKey stores the resolved client IP in the request context
+const clientIPKey contextKey = "client_ip"
+
+// ClientIPMiddleware resolves the real client IP once per request and stores it in
+// the context for other middleware. Forwarding headers are only honored when the
+// request comes from one of Config.TrustedProxies.
+func ClientIPMiddleware(config ConfigInterface) Middleware {
+	return ClientIPMiddlewareWithLogger(config, nil)
+}
+
+// ClientIPMiddlewareWithLogger is ClientIPMiddleware logging trusted proxies that
+// don't parse. The list is parsed once per configuration; while it is invalid no
+// proxy is trusted and forwarding headers are ignored.
+func ClientIPMiddlewareWithLogger(config ConfigInterface, logger LoggerInterface) Middleware {
+	logger = loggerOrDiscard(logger)
+	var cache atomic.Pointer[trustedProxies]
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			proxies := configValues(config).TrustedProxies
+
+			trusted := cache.Load()
+			if trusted == nil || !slices.Equal(trusted.source, proxies) {
+				trusted = newTrustedProxies(proxies)
+				cache.Store(trusted)
+				if trusted.err != nil {
+					logger.Error(r.Context(), "Ignoring forwarding headers: invalid trusted proxies", trusted.err, nil)
+				}
+			}
+			ip := resolveClientIP(r, trusted.prefixes)
+
+			ctx := context.WithValue(r.Context(), clientIPKey, ip)
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// trustedProxies is a parsed Config.TrustedProxies list
+type trustedProxies struct {
+	source   []string
+	prefixes []netip.Prefix
+	err      error
+}
+
+// newTrustedProxies parses a trusted proxy list, trusting none when it is invalid
+func newTrustedProxies(proxies []string) *trustedProxies {
+	prefixes, err := parseTrustedProxies(proxies)
+	return &trustedProxies{source: slices.Clone(proxies), prefixes: prefixes, err: err}
+}
+
+// parseTrustedProxies parses IP addresses and CIDR ranges into prefixes
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+// resolveClientIP returns the client IP, consulting X-Forwarded-For and X-Real-IP
+// only when the direct peer is a trusted proxy
+func resolveClientIP(r *http.Request, trusted []netip.Prefix) string {
+	remoteIP := remoteAddrIP(r)
+
+	remote, err := netip.ParseAddr(remoteIP)
+	if err != nil || !isTrustedProxy(remote, trusted) {
+		return remoteIP
+	}
+
+	// Walk X-Forwarded-For from the right, skipping our own proxies
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// A malformed entry can't be trusted further; stop at the last good hop
+			break
+		}
+
+		if !isTrustedProxy(addr.Unmap(), trusted) {
+			return addr.Unmap().String()
+		}
+
+		if i == 0 {
+			return addr.Unmap().String()
+		}
+	}
+
+	// Fall back to X-Real-IP set by the trusted proxy
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		if addr, err := netip.ParseAddr(realIP); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+
+	return remoteIP
+}
+
+// isTrustedProxy reports whether an address belongs to a trusted proxy range
+func isTrustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddrIP extracts the IP from the request's RemoteAddr
+func remoteAddrIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	// Normalize IPv4-mapped IPv6 addresses and drop zones
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		return addr.Unmap().WithZone("").String()
+	}
+
+	return ip
+}
@@ -0,0 +1,122 @@
+package vandargo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// resolveWith runs a request through middleware and returns the client IP it resolved
+func resolveWith(middleware Middleware, remoteAddr string, header map[string]string) string {
+	var ip string
+	handler := middleware(func(w http.ResponseWriter, r *http.Request) {
+		ip, _ = r.Context().Value(clientIPKey).(string)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/payments/status", nil)
+	req.RemoteAddr = remoteAddr
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	handler(httptest.NewRecorder(), req)
+	return ip
+}
+
+func TestClientIPResolution(t *testing.T) {
+	config := testConfig(t, func(c *Config) {
+		c.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"}
+	})
+	middleware := ClientIPMiddleware(config)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     map[string]string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"spoofed by an untrusted peer", "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "5.6.7.8"}, "203.0.113.7"},
+		{"one trusted proxy", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "198.51.100.9"}, "198.51.100.9"},
+		{"spoofed entry before the client", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.9"}, "198.51.100.9"},
+		{"chained proxies", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.9, 192.168.1.1, 10.1.2.3"}, "198.51.100.9"},
+		{"only proxies", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "192.168.1.1, 10.1.2.3"}, "192.168.1.1"},
+		{"malformed hop", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "198.51.100.9, not-an-ip", "X-Real-IP": "198.51.100.10"}, "198.51.100.10"},
+		{"real IP from a trusted proxy", "10.0.0.5:5000", map[string]string{"X-Real-IP": "198.51.100.9"}, "198.51.100.9"},
+		{"no forwarding headers", "10.0.0.5:5000", nil, "10.0.0.5"},
+		{"IPv6 client", "[2001:db8::1]:5000", nil, "2001:db8::1"},
+		{"IPv6 proxy", "[fd00::1]:5000", map[string]string{"X-Forwarded-For": "2001:db8::2, fd00::3"}, "2001:db8::2"},
+		{"IPv6 zone dropped", "[fe80::1%eth0]:5000", nil, "fe80::1"},
+		{"IPv4-mapped peer", "[::ffff:10.0.0.5]:5000", map[string]string{"X-Forwarded-For": "::ffff:198.51.100.9"}, "198.51.100.9"},
+	}
+
+	for _, tt := range tests {
+		if got := resolveWith(middleware, tt.remoteAddr, tt.header); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientIPInvalidTrustedProxies(t *testing.T) {
+	// ConfigWrapper skips validation, so the list can be invalid
+	config := &ConfigWrapper{Config: Config{TrustedProxies: []string{"10.0.0.0/8", "10.0.0.300"}}}
+	logger := &captureLogger{}
+	middleware := ClientIPMiddlewareWithLogger(config, logger)
+
+	header := map[string]string{"X-Forwarded-For": "1.2.3.4"}
+	for i := 0; i < 3; i++ {
+		if got := resolveWith(middleware, "10.0.0.5:5000", header); got != "10.0.0.5" {
+			t.Fatalf("got %q; an invalid list must not trust any proxy", got)
+		}
+	}
+	if errs := logger.at("error"); len(errs) != 1 {
+		t.Fatalf("%d errors logged, want the list parsed and reported once:\n%s", len(errs), logger.dump())
+	}
+
+	// Fixing the list takes effect without rebuilding the middleware
+	config.TrustedProxies = []string{"10.0.0.0/8"}
+	if got := resolveWith(middleware, "10.0.0.5:5000", header); got != "1.2.3.4" {
+		t.Fatalf("after fixing the list: got %q", got)
+	}
+}
+
+func TestClientIPFollowsDynamicConfig(t *testing.T) {
+	initial := DefaultConfig()
+	initial.APIKey = testAPIKey
+	initial.CallbackURL = "https://shop.example.com/payments/callback"
+	config, err := NewDynamicConfig(initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	middleware := ClientIPMiddleware(config)
+
+	header := map[string]string{"X-Forwarded-For": "198.51.100.9"}
+	if got := resolveWith(middleware, "10.0.0.5:5000", header); got != "10.0.0.5" {
+		t.Fatalf("before trusting the proxy: got %q", got)
+	}
+
+	updated := config.Snapshot()
+	updated.TrustedProxies = []string{"10.0.0.5"}
+	if err := config.Update(updated); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolveWith(middleware, "10.0.0.5:5000", header); got != "198.51.100.9" {
+		t.Fatalf("after trusting the proxy: got %q", got)
+	}
+}
+
+func BenchmarkClientIPMiddleware(b *testing.B) {
+	config := testConfig(b, func(c *Config) {
+		c.TrustedProxies = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fd00::/8"}
+	})
+	handler := ClientIPMiddleware(config)(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/payments/status", nil)
+	req.RemoteAddr = "10.0.0.5:5000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9, 172.16.0.1")
+	rec := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		handler(rec, req)
+	}
+}
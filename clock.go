@@ -0,0 +1,253 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// clock.go implements the source of time used for timestamps, expiry and waiting
+package vandargo
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time used by the client, storage and background jobs.
+// RealClock is used by default; a FakeClock makes time-dependent code deterministic.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// After returns a channel receiving the time once d has passed
+	After(d time.Duration) <-chan time.Time
+
+	// NewTimer creates a timer firing once after d
+	NewTimer(d time.Duration) Timer
+
+	// NewTicker creates a ticker firing every d
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a single-shot timer created by a Clock
+type Timer interface {
+	// C returns the channel the time is delivered on
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing, reporting whether it was still pending
+	Stop() bool
+}
+
+// Ticker delivers ticks at intervals, created by a Clock
+type Ticker interface {
+	// C returns the channel the ticks are delivered on
+	C() <-chan time.Time
+
+	// Stop turns off the ticker
+	Stop()
+}
+
+// RealClock returns the clock backed by the time package
+func RealClock() Clock {
+	return realClock{}
+}
+
+// realClock implements Clock with the time package
+type realClock struct{}
+
+// Now returns the current time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// After returns a channel receiving the time once d has passed
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NewTimer creates a timer firing once after d
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{timer: time.NewTimer(d)}
+}
+
+// NewTicker creates a ticker firing every d
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{ticker: time.NewTicker(d)}
+}
+
+// realTimer wraps a time.Timer
+type realTimer struct {
+	timer *time.Timer
+}
+
+// C returns the channel the time is delivered on
+func (t realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+// Stop prevents the timer from firing
+func (t realTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+// realTicker wraps a time.Ticker
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+// C returns the channel the ticks are delivered on
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+// Stop turns off the ticker
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// FakeClock is a Clock that only moves when Advance is called. Timers and tickers
+// fire during Advance once their time is reached, so tests of expiry, backoff and
+// janitors run without sleeping.
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a timer or ticker of a FakeClock
+type fakeWaiter struct {
+	clock  *FakeClock
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+// NewFakeClock creates a fake clock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the fake current time
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// After returns a channel receiving the time once the clock advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer creates a timer firing once the clock advanced by d
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.addWaiter(d, 0)
+}
+
+// NewTicker creates a ticker firing each time the clock advanced by d
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("vandargo: non-positive interval for FakeClock.NewTicker")
+	}
+	return fakeTicker{waiter: c.addWaiter(d, d)}
+}
+
+// Advance moves the clock forward by d, firing the timers and tickers that became
+// due in the order of their deadlines. Like the time package, a ticker whose
+// receiver is behind drops ticks.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].at.Before(c.waiters[j].at)
+	})
+
+	pending := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.at.After(c.now) {
+			pending = append(pending, waiter)
+			continue
+		}
+
+		select {
+		case waiter.ch <- waiter.at:
+		default:
+		}
+
+		if waiter.period > 0 {
+			for !waiter.at.After(c.now) {
+				waiter.at = waiter.at.Add(waiter.period)
+			}
+			pending = append(pending, waiter)
+		}
+	}
+	c.waiters = pending
+}
+
+// Waiters returns the number of timers and tickers that have not fired or been
+// stopped, so tests can wait for a goroutine to start waiting before advancing
+func (c *FakeClock) Waiters() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.waiters)
+}
+
+// addWaiter registers a timer or ticker, firing timers that are already due
+func (c *FakeClock) addWaiter(d, period time.Duration) *fakeWaiter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	waiter := &fakeWaiter{
+		clock:  c,
+		at:     c.now.Add(d),
+		period: period,
+		ch:     make(chan time.Time, 1),
+	}
+
+	if d <= 0 && period == 0 {
+		waiter.ch <- c.now
+		return waiter
+	}
+
+	c.waiters = append(c.waiters, waiter)
+	return waiter
+}
+
+// remove unregisters a waiter, reporting whether it was still pending
+func (c *FakeClock) remove(waiter *fakeWaiter) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, w := range c.waiters {
+		if w == waiter {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// C returns the channel the time is delivered on
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.ch
+}
+
+// Stop prevents the timer from firing
+func (w *fakeWaiter) Stop() bool {
+	return w.clock.remove(w)
+}
+
+// fakeTicker adapts a fakeWaiter to the Ticker interface
+type fakeTicker struct {
+	waiter *fakeWaiter
+}
+
+// C returns the channel the ticks are delivered on
+func (t fakeTicker) C() <-chan time.Time {
+	return t.waiter.ch
+}
+
+// Stop turns off the ticker
+func (t fakeTicker) Stop() {
+	t.waiter.clock.remove(t.waiter)
+}
@@ -0,0 +1,166 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fired reports whether a channel holds a value, without waiting
+func fired(ch <-chan time.Time) (time.Time, bool) {
+	select {
+	case at := <-ch:
+		return at, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeClockTimers(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	short := clock.NewTimer(time.Second)
+	long := clock.NewTimer(time.Minute)
+	stopped := clock.NewTimer(time.Second)
+	after := clock.After(2 * time.Second)
+	if clock.Waiters() != 4 {
+		t.Fatalf("%d waiters, want 4", clock.Waiters())
+	}
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop() should report only the first stop of a pending timer")
+	}
+
+	clock.Advance(999 * time.Millisecond)
+	if _, ok := fired(short.C()); ok {
+		t.Fatal("timer fired early")
+	}
+
+	// Timers fire with their own deadline, not the time advanced to
+	clock.Advance(5 * time.Second)
+	if at, ok := fired(short.C()); !ok || !at.Equal(start.Add(time.Second)) {
+		t.Fatalf("short timer: %v, %v", at, ok)
+	}
+	if at, ok := fired(after); !ok || !at.Equal(start.Add(2*time.Second)) {
+		t.Fatalf("After: %v, %v", at, ok)
+	}
+	if _, ok := fired(stopped.C()); ok {
+		t.Fatal("stopped timer fired")
+	}
+	if _, ok := fired(long.C()); ok || clock.Waiters() != 1 {
+		t.Fatalf("long timer fired or %d waiters left", clock.Waiters())
+	}
+	if short.Stop() {
+		t.Fatal("Stop() of a fired timer reported it pending")
+	}
+	if !clock.Now().Equal(start.Add(5999 * time.Millisecond)) {
+		t.Fatalf("Now() = %v", clock.Now())
+	}
+
+	// Timers that are already due fire at once
+	if _, ok := fired(clock.NewTimer(0).C()); !ok {
+		t.Fatal("zero timer did not fire")
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	ticker := clock.NewTicker(time.Second)
+
+	clock.Advance(time.Second)
+	if _, ok := fired(ticker.C()); !ok {
+		t.Fatal("ticker did not tick")
+	}
+
+	// A receiver that falls behind misses ticks rather than queueing them
+	clock.Advance(3 * time.Second)
+	if _, ok := fired(ticker.C()); !ok {
+		t.Fatal("ticker did not tick after a long advance")
+	}
+	if _, ok := fired(ticker.C()); ok {
+		t.Fatal("ticker queued missed ticks")
+	}
+
+	clock.Advance(time.Second)
+	if _, ok := fired(ticker.C()); !ok {
+		t.Fatal("ticker stopped ticking")
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	if _, ok := fired(ticker.C()); ok || clock.Waiters() != 0 {
+		t.Fatal("stopped ticker ticked")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("non-positive ticker interval accepted")
+		}
+	}()
+	clock.NewTicker(0)
+}
+
+func TestRealClock(t *testing.T) {
+	clock := RealClock()
+	if since := time.Since(clock.Now()); since < 0 || since > time.Minute {
+		t.Fatalf("Now() is %v off", since)
+	}
+
+	timer := clock.NewTimer(time.Millisecond)
+	select {
+	case <-timer.C():
+	case <-time.After(5 * time.Second):
+		t.Fatal("real timer did not fire")
+	}
+}
+
+func TestMemoryCacheClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	cache := NewMemoryCache(WithCacheClock(clock))
+	ctx := context.Background()
+
+	cache.Set(ctx, "expiring", []byte("1"), time.Minute)
+	cache.Set(ctx, "lasting", []byte("2"), 0)
+
+	clock.Advance(time.Minute)
+	if _, found := cache.Get(ctx, "expiring"); !found {
+		t.Fatal("entry expired at its deadline")
+	}
+	clock.Advance(time.Nanosecond)
+	if _, found := cache.Get(ctx, "expiring"); found {
+		t.Fatal("entry outlived its TTL")
+	}
+	if value, found := cache.Get(ctx, "lasting"); !found || string(value) != "2" {
+		t.Fatal("entry without a TTL expired")
+	}
+}
+
+func TestRunExpiryJanitor(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t), nil, WithClientClock(clock))
+	storeAged(t, storage, clock, "unpaid", StatusInit, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- client.RunExpiryJanitor(ctx, time.Minute) }()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Each tick expires what became due since the last one
+	clock.Advance(defaultTokenLifetime + time.Minute)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if transaction, _ := storage.GetTransaction(context.Background(), "unpaid"); transaction.Status == StatusExpired {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not expire the payment")
+		}
+	}
+
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunExpiryJanitor() = %v", err)
+	}
+}
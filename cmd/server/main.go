@@ -0,0 +1,49 @@
+// cmd/server/main.go
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/uussoop/vandargo"
+	"github.com/uussoop/vandargo/server"
+)
+
+func main() {
+	// Initialize configuration
+	config, err := vandargo.NewConfig(vandargo.Config{
+		APIKey:      os.Getenv("VANDAR_API_KEY"),
+		BaseURL:     "https://ipg.vandar.io",
+		SandboxMode: true,
+		Timeout:     30,
+		CallbackURL: "https://shop.example.com/payments/callback", // Replace with your actual callback URL
+	})
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Create storage and logger
+	storage := vandargo.NewMemoryStorage()
+	logger := vandargo.NewDefaultLogger("INFO")
+
+	// Create a new Vandar client
+	client, err := vandargo.NewClient(config, storage, logger)
+	if err != nil {
+		log.Fatalf("Failed to create Vandar client: %v", err)
+	}
+
+	// Register the payment routes on a standard library mux next to your own routes
+	mux := http.NewServeMux()
+	// Point liveness probes at /payments/health?probe=live and readiness probes at /payments/health?probe=ready
+	server.RegisterRoutes(client, server.NewServeMuxRouter(mux), server.WithOpenAPIRoute(), server.WithHealthRoute())
+
+	// Connect to the gateway before the first payment has to; failures are logged
+	_ = client.Warmup(context.Background())
+
+	log.Println("Listening on :8080")
+	if err := http.ListenAndServe(":8080", mux); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
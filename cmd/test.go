@@ -3,105 +3,116 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/uussoop/vandargo"
 )
 
 func main() {
+	live := flag.Bool("live", false, "use the Vandar gateway with VANDAR_API_KEY instead of the simulator")
+	flag.Parse()
+
 	// Initialize configuration
-	config := vandargo.ConfigWrapper{
-		vandargo.Config{
-			APIKey:      "api_key", // Replace with your actual API key
-			BaseURL:     "https://ipg.vandar.io",
-			SandboxMode: true,
-			Timeout:     30,
-			CallbackURL: "https://test.com/callback", // Replace with your actual callback URL
-		},
+	config, err := vandargo.NewConfig(vandargo.Config{
+		APIKey:      apiKey(*live),
+		BaseURL:     "https://ipg.vandar.io",
+		SandboxMode: true,
+		Timeout:     30,
+		CallbackURL: "https://shop.example.com/payments/callback", // Replace with your actual callback URL
+	})
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
 	// Create storage and logger
 	storage := vandargo.NewMemoryStorage()
-	logger := vandargo.NewSimpleLogger("DEBUG")
+	logger := vandargo.NewSimpleLogger("INFO")
 
 	// Create a new Vandar client
-	client, err := vandargo.NewClient(&config, storage, logger)
+	client, err := vandargo.NewClient(config, storage, logger)
 	if err != nil {
 		log.Fatalf("Failed to create Vandar client: %v", err)
 	}
 
-	// Create a transaction
-	transaction := &vandargo.PaymentInitRequest{
-		Amount:      1000000, // Amount in Rials
-		CallbackURL: config.GetCallbackURL(),
-		Description: "Payment for product",
-		Mobile:      "09123456789", // Optional
+	// Answer gateway requests in-process unless a live run was requested;
+	// the simulated payment becomes paid after three status polls
+	if !*live {
+		client = client.WithHTTPClient(vandargo.NewSimulatorTransport(vandargo.WithSimulatorPaidAfter(3)))
 	}
 
-	// Call the payment initialization method
+	// Initialize payment
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Create metadata for the transaction
 	metadata := map[string]string{
 		"customer_id": "12345",
 		"order_id":    "ORD-98765",
 	}
 
-	// Initialize payment
-	response, err := client.InitiatePayment(ctx, transaction.Amount, transaction.Description, metadata)
+	response, err := client.InitiatePayment(ctx, 1000000, "Payment for product", metadata)
 	if err != nil {
 		log.Fatalf("Failed to initiate payment: %v", err)
 	}
 
-	// Print the response
 	fmt.Printf("Payment Token: %s\n", response.Token)
-	fmt.Printf("Payment Status: %t\n", response.Status)
-	fmt.Printf("Payment Message: %s\n", response.Message)
-
-	// Print payment URL
-	fmt.Printf("Payment URL: %s/v4/%s\n", config.GetBaseURL(), response.Token)
-	fmt.Println("\nPlease open the above URL in your browser to complete the payment.")
-	fmt.Println("After payment, you will be redirected to the callback URL.")
-
-	// Wait for user input to verify payment
-	fmt.Println("\nPress Enter after completing the payment to verify it...")
-	fmt.Scanln()
+	fmt.Printf("Payment Status: %d\n", response.Status)
+	fmt.Printf("Payment URL: %s\n", client.PaymentURL(response.Token))
+	if *live {
+		fmt.Println("\nPlease open the above URL in your browser to complete the payment.")
+	}
 
-	// Get transaction information
-	info, err := client.GetTransactionInfo(ctx, "YOUR_PAYMENT_TOKEN")
+	// Wait until the payment is completed, failed or expired
+	fmt.Println("\nWaiting for the payment to complete...")
+	interval := 3 * time.Second
+	if !*live {
+		interval = 100 * time.Millisecond
+	}
+	result, err := client.WaitForPayment(context.Background(), response.Token,
+		vandargo.WithWaitInterval(interval),
+		vandargo.WithWaitProgress(func(attempt int, result *vandargo.PaymentResult, err error) {
+			if err != nil {
+				fmt.Printf("Poll %d failed: %v\n", attempt, err)
+				return
+			}
+			fmt.Printf("Poll %d: %s\n", attempt, result.State)
+		}),
+	)
 	if err != nil {
-		log.Fatalf("Failed to get transaction info: %v", err)
+		log.Fatalf("Payment did not complete: %v", err)
 	}
-
-	// Check the response
-	if info.Status == 1 {
-		fmt.Printf("Transaction Amount: %s Rials\n", info.Amount)
-		fmt.Printf("Transaction ID: %d\n", info.TransID)
-		fmt.Printf("Reference Number: %s\n", info.RefNumber)
-		fmt.Printf("Tracking Code: %s\n", info.TrackingCode)
-		fmt.Printf("Card Number: %s\n", info.CardNumber)
-		fmt.Printf("Created At: %s\n", info.CreatedAt)
-		fmt.Printf("Payment Date: %s\n", info.PaymentDate)
-	} else {
-		fmt.Printf("Error Code: %d\n", info.Code)
-		fmt.Printf("Error Message: %s\n", info.Message)
+	if result.State != vandargo.StatusPaid {
+		log.Fatalf("Payment ended as %s", result.State)
 	}
+
 	// Verify payment
-	verifyResponse, err := client.VerifyPayment(ctx, response.Token)
+	verifyCtx, verifyCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer verifyCancel()
+
+	verifyResponse, err := client.VerifyPayment(verifyCtx, response.Token)
 	if err != nil {
 		log.Fatalf("Failed to verify payment: %v", err)
 	}
 
-	// Print verification response
-	fmt.Printf("\nVerification Status: %t\n", verifyResponse.Status)
-	if verifyResponse.Status {
-		fmt.Printf("Amount: %d Rials\n", verifyResponse.Amount)
-		fmt.Printf("Reference ID: %s\n", verifyResponse.RefID)
-		fmt.Printf("Card Number: %s\n", verifyResponse.CardNumber)
-	} else {
-		fmt.Printf("Verification Message: %s\n", verifyResponse.Message)
+	fmt.Printf("\nVerification Status: %d\n", verifyResponse.Status)
+	fmt.Printf("Amount: %s Rials\n", verifyResponse.Amount)
+	fmt.Printf("Transaction ID: %d\n", verifyResponse.TransID)
+	fmt.Printf("Card Number: %s\n", verifyResponse.CardNumber)
+	fmt.Printf("Payment Date: %s\n", verifyResponse.PaymentDate)
+}
+
+// apiKey returns the API key from the environment for live runs
+func apiKey(live bool) string {
+	if !live {
+		return "simulator"
+	}
+
+	key := os.Getenv("VANDAR_API_KEY")
+	if key == "" {
+		log.Fatal("VANDAR_API_KEY is required with -live")
 	}
+	return key
 }
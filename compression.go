@@ -0,0 +1,211 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// compression.go implements gzip response compression middleware
+package vandargo
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipMinSize is the smallest response body worth compressing
+const gzipMinSize = 1024
+
+// gzipWriterPool reuses gzip writers across responses
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// incompressibleTypes lists content type prefixes that are already compressed
+var incompressibleTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/octet-stream", "application/pdf",
+}
+
+// GzipMiddleware compresses responses for clients that accept gzip. Bodies smaller
+// than 1 KiB and already-compressed content types are sent as-is.
+func GzipMiddleware() Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			if !acceptsGzip(r) {
+				next(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{
+				ResponseWriter: w,
+				status:         http.StatusOK,
+			}
+			defer gw.close()
+
+			next(gw, r)
+		}
+	}
+}
+
+// gzipResponseWriter buffers the start of a response to decide whether to compress it
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	buf      []byte
+	decided  bool
+	gzWriter *gzip.Writer
+}
+
+// WriteHeader records the status code until the compression decision is made
+func (gw *gzipResponseWriter) WriteHeader(code int) {
+	if gw.decided {
+		return
+	}
+	gw.status = code
+}
+
+// Write buffers data until enough is known to decide on compression
+func (gw *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !gw.decided {
+		gw.buf = append(gw.buf, p...)
+		if len(gw.buf) < gzipMinSize {
+			return len(p), nil
+		}
+
+		if err := gw.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if gw.gzWriter != nil {
+		return gw.gzWriter.Write(p)
+	}
+
+	return gw.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.decided {
+		if err := gw.decide(); err != nil {
+			return
+		}
+	}
+
+	if gw.gzWriter != nil {
+		gw.gzWriter.Flush()
+	}
+
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection when the underlying writer supports it
+func (gw *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := gw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// decide chooses whether to compress, writes the header and flushes the buffer
+func (gw *gzipResponseWriter) decide() error {
+	gw.decided = true
+
+	header := gw.ResponseWriter.Header()
+	if header.Get("Content-Type") == "" && len(gw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(gw.buf))
+	}
+
+	compress := len(gw.buf) >= gzipMinSize &&
+		header.Get("Content-Encoding") == "" &&
+		isCompressible(header.Get("Content-Type")) &&
+		bodyAllowedForStatus(gw.status)
+
+	if compress {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+
+		gw.gzWriter = gzipWriterPool.Get().(*gzip.Writer)
+		gw.gzWriter.Reset(gw.ResponseWriter)
+	}
+
+	gw.ResponseWriter.WriteHeader(gw.status)
+
+	if len(gw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if gw.gzWriter != nil {
+		_, err = gw.gzWriter.Write(gw.buf)
+	} else {
+		_, err = gw.ResponseWriter.Write(gw.buf)
+	}
+	gw.buf = nil
+
+	return err
+}
+
+// close finishes the response, sending small bodies uncompressed
+func (gw *gzipResponseWriter) close() {
+	if !gw.decided {
+		_ = gw.decide()
+	}
+
+	if gw.gzWriter != nil {
+		_ = gw.gzWriter.Close()
+		gzipWriterPool.Put(gw.gzWriter)
+		gw.gzWriter = nil
+	}
+}
+
+// acceptsGzip reports whether the client accepts gzip-encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(encoding) != "gzip" {
+			continue
+		}
+
+		// Honor an explicit refusal such as "gzip;q=0"
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			q, err := strconv.ParseFloat(value, 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+
+	return false
+}
+
+// isCompressible reports whether a content type benefits from compression
+func isCompressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// bodyAllowedForStatus reports whether a response with the status may have a body
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
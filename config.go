@@ -4,6 +4,10 @@ package vandargo
 
 import (
 	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -18,6 +22,28 @@ type Config struct {
 	// SandboxMode determines whether to use the sandbox environment
 	SandboxMode bool
 
+	// DevMode lets the client answer from the in-process simulator when the API
+	// key is missing or a placeholder, so the example service runs without
+	// sandbox credentials. It requires SandboxMode.
+	DevMode bool
+
+	// EnforceHTTPS rejects http base, callback and return URLs; when nil it is
+	// enabled outside sandbox mode
+	EnforceHTTPS *bool
+
+	// AllowInsecureLocalhost allows http URLs pointing at localhost or a loopback
+	// address while https is enforced, for local development
+	AllowInsecureLocalhost bool
+
+	// StrictEnvironment fails validation when the configuration looks like it
+	// mixes sandbox and production settings, which is otherwise only logged
+	// when the client is created
+	StrictEnvironment bool
+
+	// StrictWarmup makes Serve fail to start when warming up the client fails,
+	// e.g. because the gateway can't be reached; otherwise failures are logged
+	StrictWarmup bool
+
 	// Timeout is the HTTP client timeout in seconds
 	Timeout int
 
@@ -30,27 +56,247 @@ type Config struct {
 	// RetryWaitTime is the initial wait time between retries (exponential backoff)
 	RetryWaitTime time.Duration
 
+	// MinAttemptBudget is the least remaining deadline worth starting a retry with
+	MinAttemptBudget time.Duration
+
 	// EncryptionKey is used for encrypting sensitive data
 	EncryptionKey string
 
+	// HashKey is the secret key of stored card hashes; EncryptionKey is used when empty
+	HashKey string
+
+	// ServerAPIKeys are the bearer keys accepted by the payment endpoints, each
+	// limited to its scopes; when empty, APIKey is accepted with every scope
+	ServerAPIKeys []ServerKey
+
+	// CorrelationHeader is the header carrying end-to-end correlation IDs, which
+	// are echoed, logged and forwarded unlike per-hop request IDs
+	// (X-Correlation-ID when empty)
+	CorrelationHeader string
+
+	// CorrelationOutboundHeader is the header correlation IDs are forwarded to
+	// Vandar in (CorrelationHeader when empty)
+	CorrelationOutboundHeader string
+
+	// GenerateCorrelationID creates a correlation ID for requests arriving without one
+	GenerateCorrelationID bool
+
+	// AdminKey enables the administrative endpoints, which require it in the X-Admin-Key header (optional)
+	AdminKey string
+
+	// SensitiveDataKey allows admin responses to include unmasked card data when sent
+	// in the X-Sensitive-Data-Key header with include_sensitive=true (optional)
+	SensitiveDataKey string
+
+	// WebhookSecret enables the business webhook endpoint and is the HMAC key of the
+	// X-Vandar-Signature header (optional)
+	WebhookSecret string
+
+	// ChallengeSecret enables Turnstile verification of the challenge tokens sent
+	// with payment initializations, unless a ChallengeVerifier is set (optional)
+	ChallengeSecret string
+
+	// ChallengeVerifyURL is the Turnstile-compatible endpoint challenge tokens are
+	// verified at (Cloudflare Turnstile when empty)
+	ChallengeVerifyURL string
+
+	// RequireChallenge rejects payment initializations without a valid challenge
+	// token; without it only tokens that are sent are verified
+	RequireChallenge bool
+
 	// IPAllowList contains allowed IP addresses for callbacks (optional)
 	IPAllowList []string
+
+	// CallbackHostAllowList restricts the hosts of per-payment callback URLs; entries
+	// starting with "*." allow subdomains. Any host is allowed when empty.
+	CallbackHostAllowList []string
+
+	// TrustedProxies lists proxy IPs or CIDR ranges whose forwarding headers are honored (optional)
+	TrustedProxies []string
+
+	// Business is the business slug used in business API paths (v3 endpoints)
+	Business string
+
+	// RefreshToken is the OAuth refresh token used to obtain business API access tokens (optional)
+	RefreshToken string
+
+	// TokenEndpoint is the path of the token refresh endpoint
+	TokenEndpoint string
+
+	// APIVersion selects the default endpoint paths (v4 when empty)
+	APIVersion APIVersion
+
+	// Endpoints overrides individual endpoint paths (optional)
+	Endpoints Endpoints
+
+	// InitTimeout bounds payment initialization and refund calls when the caller sets no earlier deadline
+	InitTimeout time.Duration
+
+	// VerifyTimeout bounds payment verification calls when the caller sets no earlier deadline
+	VerifyTimeout time.Duration
+
+	// StatusTimeout bounds status and transaction info lookups when the caller sets no earlier deadline
+	StatusTimeout time.Duration
+
+	// CacheTTL is how long non-terminal status and transaction info responses are cached
+	CacheTTL time.Duration
+
+	// VerifyMemoTTL is how long a successful verification is reused for repeated
+	// verify calls on the same token; a negative value disables memoization
+	VerifyMemoTTL time.Duration
+
+	// HedgeDelay sends a second identical request for a GET lookup, such as a
+	// payment status, that hasn't been answered within this delay and uses whichever
+	// answers first; zero disables hedging
+	HedgeDelay time.Duration
+
+	// HedgePercent caps the share of lookups that may be hedged (10 when zero)
+	HedgePercent int
+
+	// MaxConcurrentGatewayCalls caps the requests in flight to the gateway across
+	// the client and its clones; zero means no limit
+	MaxConcurrentGatewayCalls int
+
+	// GatewayCallWait is how long a gateway request waits for a free slot under
+	// MaxConcurrentGatewayCalls, bounded by its context (5s when zero)
+	GatewayCallWait time.Duration
+
+	// EnrichAfterVerify fetches transaction info after a successful verification
+	// to fill in tracking code, ref number and wages
+	EnrichAfterVerify bool
+
+	// SkipEchoCheck turns off comparing the description and factor number the
+	// gateway echoes on verification and transaction info with those sent at
+	// init, for merchants whose descriptions the gateway rewrites
+	SkipEchoCheck bool
+
+	// ReturnURL is the merchant page linked from the callback result pages (optional)
+	ReturnURL string
+
+	// ReturnRedirect redirects the payer to ReturnURL with signed result parameters after a callback
+	ReturnRedirect bool
+
+	// ReturnSecret is the shared secret used to sign return redirect parameters
+	ReturnSecret string
+
+	// AutoVerifyCallback verifies successful payments while handling their callback
+	AutoVerifyCallback bool
+
+	// CardMaskStyle selects how card numbers are masked in logs and admin responses
+	// (last four digits when empty)
+	CardMaskStyle CardMaskStyle
+
+	// CallbackHTML forces HTML callback result pages regardless of the Accept header
+	CallbackHTML bool
+
+	// ListRoutesOnNotFound makes the built-in Handler answer unknown paths with
+	// the available endpoints; production deployments may keep terse 404s
+	ListRoutesOnNotFound bool
+
+	// RejectDuplicateFactorNumbers suppresses a new payment while another one with
+	// the same factor number is still in progress
+	RejectDuplicateFactorNumbers bool
+
+	// DuplicateFactorMode selects whether duplicates are rejected or reuse the
+	// existing token (strict when empty)
+	DuplicateFactorMode DuplicateFactorMode
+
+	// DuplicateFactorWindow is how long an unpaid transaction blocks its factor number
+	// before its token is considered expired
+	DuplicateFactorWindow time.Duration
+
+	// TokenLifetime is how long payment tokens live when the gateway doesn't report
+	// it (20 minutes when zero)
+	TokenLifetime time.Duration
+
+	// VerifyWarningThreshold is how long after a successful callback an unverified
+	// payment is reported at risk of reversal (10 minutes when zero)
+	VerifyWarningThreshold time.Duration
+
+	// VerifyRetryDelay is how long after a verification with an unknown outcome,
+	// such as a timeout, it is retried once (30 seconds when zero); a negative
+	// value disables the retry
+	VerifyRetryDelay time.Duration
+
+	// DeferredInit queues payment initializations that fail because the gateway
+	// is unreachable and retries them in the background, answering the payer with
+	// a reference to poll instead of an error
+	DeferredInit bool
+
+	// DeferredInitDeadline is how long a queued initialization is retried before
+	// it is marked FAILED (10 minutes when zero)
+	DeferredInitDeadline time.Duration
+
+	// DeferredInitRetryInterval is how long to wait between attempts of a queued
+	// initialization (15 seconds when zero)
+	DeferredInitRetryInterval time.Duration
+
+	// HealthStorageDegradedLatency is how slow a storage probe may be before the
+	// storage is reported degraded (250 milliseconds when zero)
+	HealthStorageDegradedLatency time.Duration
+
+	// HealthStorageTimeout is how long a storage probe may take before the
+	// storage is reported down (2 seconds when zero)
+	HealthStorageTimeout time.Duration
+
+	// HealthQueueDegradedDepth is how many waiting items report a queue degraded
+	// (100 when zero)
+	HealthQueueDegradedDepth int
+
+	// HealthGatewayDownFailures is how many gateway outages in a row report the
+	// gateway down rather than degraded (5 when zero)
+	HealthGatewayDownFailures int
+
+	// EvidenceRetention is how long the raw verify and transaction info responses
+	// of each transaction are kept as dispute evidence; zero disables retention
+	EvidenceRetention time.Duration
+
+	// EvidenceMaxBytes caps the size of each retained response (16 KiB when zero)
+	EvidenceMaxBytes int
+
+	// DefaultDescription is the description of payments initialized without one (optional)
+	DefaultDescription string
+
+	// DefaultDescriptionTemplate is a text/template rendered against the payment's
+	// metadata for payments initialized without a description, such as
+	// "Subscription renewal {{.plan}} for {{.customer_id}}". DefaultDescription is
+	// used when a key is missing. (optional)
+	DefaultDescriptionTemplate string
+
+	// CallbackSuccessTemplate replaces the built-in callback success page (optional)
+	CallbackSuccessTemplate *template.Template
+
+	// CallbackFailureTemplate replaces the built-in callback failure page (optional)
+	CallbackFailureTemplate *template.Template
 }
 
 // DefaultConfig returns a Config with safe default values
 func DefaultConfig() Config {
 	return Config{
-		BaseURL:       "https://api.vandar.io",
-		SandboxMode:   true,
-		Timeout:       30,
-		MaxRetries:    3,
-		RetryWaitTime: 2 * time.Second,
+		BaseURL:          ProductionBaseURL,
+		SandboxMode:      true,
+		Timeout:          30,
+		MaxRetries:       3,
+		RetryWaitTime:    2 * time.Second,
+		MinAttemptBudget: defaultMinAttemptBudget,
+		TokenEndpoint:    "/v3/refreshtoken",
+		APIVersion:       APIVersionV4,
+		InitTimeout:      defaultInitTimeout,
+		VerifyTimeout:    defaultVerifyTimeout,
+		StatusTimeout:    defaultStatusTimeout,
+		CacheTTL:         defaultCacheTTL,
+		VerifyMemoTTL:    defaultVerifyMemoTTL,
 	}
 }
 
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
-	if c.APIKey == "" {
+	if c.DevMode && !c.SandboxMode {
+		return errors.New("dev mode requires sandbox mode")
+	}
+
+	// Dev mode runs on the simulator without a key
+	if c.APIKey == "" && !c.DevMode {
 		return errors.New("api key is required")
 	}
 
@@ -58,17 +304,103 @@ func (c *Config) Validate() error {
 		return errors.New("base url is required")
 	}
 
+	if _, err := parseBaseURL(c.BaseURL); err != nil {
+		return err
+	}
+
 	if c.CallbackURL == "" {
 		return errors.New("callback url is required")
 	}
 
+	if err := validateCallbackURL(c.CallbackURL, c.CallbackHostAllowList); err != nil {
+		return fmt.Errorf("invalid callback url %q: %w", c.CallbackURL, err)
+	}
+
+	if err := c.validateURLSchemes(); err != nil {
+		return err
+	}
+
+	if err := validateAPIVersion(c.APIVersion); err != nil {
+		return err
+	}
+
 	if c.Timeout <= 0 {
 		return errors.New("timeout must be greater than 0")
 	}
 
+	if err := validateCardMaskStyle(c.CardMaskStyle); err != nil {
+		return err
+	}
+
+	switch c.DuplicateFactorMode {
+	case "", DuplicateFactorStrict, DuplicateFactorReuse:
+	default:
+		return fmt.Errorf("unsupported duplicate factor mode %q", c.DuplicateFactorMode)
+	}
+
+	if c.ReturnRedirect && (c.ReturnURL == "" || c.ReturnSecret == "") {
+		return errors.New("return url and return secret are required for return redirects")
+	}
+
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		return err
+	}
+
+	if err := validateServerKeys(c.ServerAPIKeys); err != nil {
+		return err
+	}
+
+	if err := validateCorrelationHeaders(c); err != nil {
+		return err
+	}
+
+	if err := validateDescriptionTemplate(c.DefaultDescriptionTemplate); err != nil {
+		return err
+	}
+
+	if c.StrictEnvironment {
+		if err := c.CheckEnvironment(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// parseBaseURL parses an API base URL, which must be an absolute http or https URL
+// without query or fragment; a path prefix is allowed
+func parseBaseURL(raw string) (*url.URL, error) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base url %q: scheme must be http or https", raw)
+	}
+
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("invalid base url %q: host is required", raw)
+	}
+
+	if parsed.RawQuery != "" || parsed.ForceQuery || parsed.Fragment != "" {
+		return nil, fmt.Errorf("invalid base url %q: query and fragment are not allowed", raw)
+	}
+
+	return parsed, nil
+}
+
+// joinURL joins an endpoint path onto a base URL, keeping any path prefix of the
+// base and collapsing duplicate slashes at the boundary
+func joinURL(baseURL, endpoint string) (string, error) {
+	base, err := parseBaseURL(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	return base.JoinPath(endpoint).String(), nil
+}
+
 // configImpl implements the ConfigInterface
 type configImpl struct {
 	config Config
@@ -81,7 +413,7 @@ func NewConfig(config Config) (ConfigInterface, error) {
 	}
 
 	return &configImpl{
-		config: config,
+		config: *cloneConfig(config),
 	}, nil
 }
 
@@ -110,6 +442,22 @@ func (c *configImpl) GetCallbackURL() string {
 	return c.config.CallbackURL
 }
 
+// configValues returns the full Config behind the package's own ConfigInterface
+// implementations, or an empty Config for custom implementations
+func configValues(config ConfigInterface) *Config {
+	switch c := config.(type) {
+	case *configImpl:
+		return &c.config
+	case *ConfigWrapper:
+		return &c.Config
+	case *DynamicConfig:
+		return c.values()
+	default:
+		// Custom implementations only expose the interface methods
+		return &Config{SandboxMode: config.IsSandboxMode()}
+	}
+}
+
 // ConfigWrapper wraps the Config struct to implement ConfigInterface
 type ConfigWrapper struct {
 	Config
@@ -0,0 +1,219 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// config_dynamic.go implements a hot-reloadable configuration
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultWatchInterval is how often a watched config file is checked for changes
+	defaultWatchInterval = 2 * time.Second
+
+	// defaultWatchDebounce is how long a changed file must stay unchanged before it is reloaded
+	defaultWatchDebounce = 500 * time.Millisecond
+)
+
+// DynamicConfig is a ConfigInterface whose values can be replaced at runtime, e.g.
+// to rotate the API key without restarting. Reads are lock-free; every request sees
+// either the old or the new configuration, never a mix of both.
+//
+// The HTTP client timeout and the refresh token provider are set up when the client
+// is created and don't follow later updates.
+type DynamicConfig struct {
+	current atomic.Pointer[Config]
+
+	// updateMutex serializes updates and subscriber notifications
+	updateMutex sync.Mutex
+	subscribers []func(previous, current Config)
+}
+
+// NewDynamicConfig creates a dynamic configuration from a validated initial Config
+func NewDynamicConfig(config Config) (*DynamicConfig, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	d := &DynamicConfig{}
+	d.current.Store(cloneConfig(config))
+	return d, nil
+}
+
+// Update validates a new configuration and swaps it in atomically. The current
+// configuration is kept when validation fails. Subscribers get copies of the
+// replaced and the stored configuration, so the caller may reuse config.
+func (d *DynamicConfig) Update(config Config) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	d.updateMutex.Lock()
+	defer d.updateMutex.Unlock()
+
+	stored := cloneConfig(config)
+	previous := d.current.Swap(stored)
+	for _, subscriber := range d.subscribers {
+		subscriber(*cloneConfig(*previous), *cloneConfig(*stored))
+	}
+
+	return nil
+}
+
+// Subscribe registers a function called after every successful update
+func (d *DynamicConfig) Subscribe(fn func(previous, current Config)) {
+	d.updateMutex.Lock()
+	defer d.updateMutex.Unlock()
+
+	d.subscribers = append(d.subscribers, fn)
+}
+
+// Snapshot returns a copy of the current configuration
+func (d *DynamicConfig) Snapshot() Config {
+	return *cloneConfig(*d.current.Load())
+}
+
+// values returns the current configuration, which must not be modified
+func (d *DynamicConfig) values() *Config {
+	return d.current.Load()
+}
+
+// GetAPIKey returns the current Vandar API key
+func (d *DynamicConfig) GetAPIKey() string {
+	return d.current.Load().APIKey
+}
+
+// GetBaseURL returns the current base URL for the Vandar API
+func (d *DynamicConfig) GetBaseURL() string {
+	return d.current.Load().BaseURL
+}
+
+// IsSandboxMode returns whether the integration is currently in sandbox mode
+func (d *DynamicConfig) IsSandboxMode() bool {
+	return d.current.Load().SandboxMode
+}
+
+// GetTimeout returns the current HTTP client timeout in seconds
+func (d *DynamicConfig) GetTimeout() int {
+	return d.current.Load().Timeout
+}
+
+// GetCallbackURL returns the current URL for payment callbacks
+func (d *DynamicConfig) GetCallbackURL() string {
+	return d.current.Load().CallbackURL
+}
+
+// WatchFile reloads the configuration from a file loaded with LoadConfig whenever it
+// changes, until ctx is cancelled. Changes are detected by polling every interval and
+// applied once the file has been stable for debounce, so editors and secret managers
+// that write in several steps don't trigger partial reloads. Invalid files are logged
+// and the current configuration is kept. Zero durations use the defaults.
+func (d *DynamicConfig) WatchFile(ctx context.Context, path string, interval, debounce time.Duration, logger LoggerInterface) error {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	if debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+	if logger == nil {
+		logger = NewDefaultLogger("WARN")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to watch config file: %w", err)
+	}
+
+	go d.watchFile(ctx, path, interval, debounce, logger, fileVersion(info))
+	return nil
+}
+
+// watchFile polls a config file and reloads it after it settles
+func (d *DynamicConfig) watchFile(ctx context.Context, path string, interval, debounce time.Duration, logger LoggerInterface, applied string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pending string
+	var changedAt time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				// The file may be mid-replacement, try again on the next tick
+				continue
+			}
+
+			// Restart the debounce window on every new change
+			version := fileVersion(info)
+			if version != pending {
+				pending = version
+				changedAt = now
+			}
+			if pending == applied || now.Sub(changedAt) < debounce {
+				continue
+			}
+
+			applied = pending
+			previous := d.Snapshot()
+			config, warnings, err := LoadConfigWithWarnings(path)
+			if err == nil {
+				err = d.Update(config)
+			}
+			if err != nil {
+				logger.Error(ctx, "Failed to reload configuration, keeping the current one", err, map[string]interface{}{
+					"path": path,
+				})
+				continue
+			}
+
+			fields := map[string]interface{}{"path": path}
+			if changes := previous.Diff(config); len(changes) > 0 {
+				changed := make([]string, len(changes))
+				for i, change := range changes {
+					changed[i] = change.String()
+				}
+				fields["changes"] = changed
+			}
+			if len(warnings) > 0 {
+				fields["unknown_keys"] = warnings
+			}
+			logger.Info(ctx, "Configuration reloaded", fields)
+		}
+	}
+}
+
+// fileVersion identifies a file's content version by size and modification time
+func fileVersion(info os.FileInfo) string {
+	return fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+}
+
+// cloneConfig deep-copies a Config so neither the caller nor the holder of the copy
+// can change the other's slices. The callback templates are shared; they are safe
+// for concurrent use once parsed.
+func cloneConfig(config Config) *Config {
+	config.IPAllowList = slices.Clone(config.IPAllowList)
+	config.CallbackHostAllowList = slices.Clone(config.CallbackHostAllowList)
+	config.TrustedProxies = slices.Clone(config.TrustedProxies)
+	if config.ServerAPIKeys != nil {
+		keys := make([]ServerKey, len(config.ServerAPIKeys))
+		for i, key := range config.ServerAPIKeys {
+			key.Scopes = slices.Clone(key.Scopes)
+			keys[i] = key
+		}
+		config.ServerAPIKeys = keys
+	}
+	if config.EnforceHTTPS != nil {
+		enforce := *config.EnforceHTTPS
+		config.EnforceHTTPS = &enforce
+	}
+	return &config
+}
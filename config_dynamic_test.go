@@ -0,0 +1,217 @@
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dynamicTestConfig returns a valid configuration for a DynamicConfig
+func dynamicTestConfig(apiKey string) Config {
+	config := DefaultConfig()
+	config.APIKey = apiKey
+	config.CallbackURL = "https://shop.example.com/payments/callback"
+	config.IPAllowList = []string{"203.0.113.7"}
+	config.TrustedProxies = []string{"10.0.0.0/8"}
+	config.ServerAPIKeys = []ServerKey{{Key: "server-" + apiKey, Scopes: []Scope{ScopeRead}}}
+	return config
+}
+
+func TestDynamicConfigDoesNotShareSlices(t *testing.T) {
+	config := dynamicTestConfig("key-1")
+	dynamic, err := NewDynamicConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the caller's slices doesn't reach the stored configuration
+	config.IPAllowList[0] = "198.51.100.1"
+	config.TrustedProxies[0] = "0.0.0.0/0"
+	config.ServerAPIKeys[0].Key = "changed"
+	config.ServerAPIKeys[0].Scopes[0] = ScopeAdmin
+
+	stored := dynamic.values()
+	if stored.IPAllowList[0] != "203.0.113.7" || stored.TrustedProxies[0] != "10.0.0.0/8" ||
+		stored.ServerAPIKeys[0].Key != "server-key-1" || stored.ServerAPIKeys[0].Scopes[0] != ScopeRead {
+		t.Fatalf("stored configuration changed with the caller's: %+v", stored)
+	}
+
+	// Neither do changes to a snapshot
+	snapshot := dynamic.Snapshot()
+	snapshot.ServerAPIKeys[0].Scopes[0] = ScopeAdmin
+	snapshot.IPAllowList[0] = "198.51.100.1"
+	if stored.ServerAPIKeys[0].Scopes[0] != ScopeRead || stored.IPAllowList[0] != "203.0.113.7" {
+		t.Fatal("stored configuration changed with a snapshot")
+	}
+}
+
+func TestDynamicConfigSubscribersGetStoredSnapshot(t *testing.T) {
+	dynamic, err := NewDynamicConfig(dynamicTestConfig("key-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var previousKey, currentKey string
+	dynamic.Subscribe(func(previous, current Config) {
+		previousKey, currentKey = previous.APIKey, current.APIKey
+
+		// A subscriber changing its copy doesn't change the stored configuration
+		current.ServerAPIKeys[0].Scopes[0] = ScopeAdmin
+		current.TrustedProxies[0] = "0.0.0.0/0"
+	})
+
+	update := dynamicTestConfig("key-2")
+	if err := dynamic.Update(update); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither does the caller reusing its Config afterwards
+	update.IPAllowList[0] = "198.51.100.1"
+
+	if previousKey != "key-1" || currentKey != "key-2" {
+		t.Fatalf("subscriber saw %q -> %q", previousKey, currentKey)
+	}
+	stored := dynamic.values()
+	if stored.ServerAPIKeys[0].Scopes[0] != ScopeRead || stored.TrustedProxies[0] != "10.0.0.0/8" || stored.IPAllowList[0] != "203.0.113.7" {
+		t.Fatalf("stored configuration changed: %+v", stored)
+	}
+
+	// Invalid updates keep the current configuration and notify nobody
+	currentKey = ""
+	if err := dynamic.Update(Config{}); err == nil || currentKey != "" || dynamic.GetAPIKey() != "key-2" {
+		t.Fatalf("invalid update: err %v, key %q", err, dynamic.GetAPIKey())
+	}
+}
+
+func TestDynamicConfigConcurrentReadsDuringUpdate(t *testing.T) {
+	dynamic, err := NewDynamicConfig(dynamicTestConfig("key-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := AuthMiddleware(dynamic)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				// Every read sees one whole configuration
+				values := dynamic.values()
+				if values.ServerAPIKeys[0].Key != "server-"+values.APIKey {
+					t.Errorf("mixed configuration: %q and %q", values.APIKey, values.ServerAPIKeys[0].Key)
+					return
+				}
+				_ = dynamic.GetAPIKey()
+				_ = dynamic.Snapshot()
+
+				req := httptest.NewRequest(http.MethodGet, "/payments/status", nil)
+				req.Header.Set("Authorization", "Bearer wrong")
+				rec := httptest.NewRecorder()
+				auth(rec, req)
+				if rec.Code != http.StatusUnauthorized {
+					t.Errorf("wrong key accepted: %d", rec.Code)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= 200; i++ {
+		if err := dynamic.Update(dynamicTestConfig("key-" + strconv.Itoa(i))); err != nil {
+			t.Error(err)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+func TestDynamicConfigAuthFollowsUpdates(t *testing.T) {
+	config := dynamicTestConfig("key-1")
+	config.ServerAPIKeys = nil
+	dynamic, err := NewDynamicConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := AuthMiddleware(dynamic)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	status := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/payments/status", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		auth(rec, req)
+		return rec.Code
+	}
+
+	if status("key-1") != http.StatusOK {
+		t.Fatal("initial key rejected")
+	}
+
+	config.APIKey = "key-2"
+	if err := dynamic.Update(config); err != nil {
+		t.Fatal(err)
+	}
+	if status("key-1") != http.StatusUnauthorized || status("key-2") != http.StatusOK {
+		t.Fatal("rotated key not applied to the middleware built before the update")
+	}
+}
+
+func TestDynamicConfigWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vandar.json")
+	write := func(apiKey string) {
+		data := `{"api_key": "` + apiKey + `", "callback_url": "https://shop.example.com/payments/callback"}`
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("key-1")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamic, err := NewDynamicConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := &captureLogger{}
+	if err := dynamic.WatchFile(ctx, path, 5*time.Millisecond, 20*time.Millisecond, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor := func(condition func() bool, what string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !condition() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s:\n%s", what, logger.dump())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	write("key-rotated")
+	waitFor(func() bool { return dynamic.GetAPIKey() == "key-rotated" }, "the rotated key")
+
+	// An invalid file is logged and the current configuration kept
+	if err := os.WriteFile(path, []byte(`{"api_key": ""}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(func() bool { _, ok := logger.find("Failed to reload"); return ok }, "the invalid file to be reported")
+	if dynamic.GetAPIKey() != "key-rotated" {
+		t.Fatalf("invalid file replaced the configuration: %q", dynamic.GetAPIKey())
+	}
+}
@@ -0,0 +1,298 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// config_env.go implements loading configuration from environment variables
+package vandargo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEnvPrefix is the environment variable prefix used when none is given
+const DefaultEnvPrefix = "VANDAR"
+
+// envLoader reads prefixed environment variables and collects parse errors
+type envLoader struct {
+	prefix string
+	errs   []error
+}
+
+// NewConfigFromEnv builds a Config from environment variables such as VANDAR_API_KEY,
+// VANDAR_TIMEOUT or VANDAR_IP_ALLOWLIST. Unset variables keep their DefaultConfig
+// values, and the result is validated. prefix replaces "VANDAR" when not empty.
+func NewConfigFromEnv(prefix string) (Config, error) {
+	prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "_")
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+
+	env := &envLoader{prefix: prefix}
+	config := DefaultConfig()
+
+	// Credentials and endpoints
+	env.string("API_KEY", &config.APIKey)
+	env.string("BASE_URL", &config.BaseURL)
+	env.string("CALLBACK_URL", &config.CallbackURL)
+	env.string("ENCRYPTION_KEY", &config.EncryptionKey)
+	env.string("HASH_KEY", &config.HashKey)
+	env.string("ADMIN_KEY", &config.AdminKey)
+	env.serverKeys("SERVER_API_KEYS", &config.ServerAPIKeys)
+	env.string("SENSITIVE_DATA_KEY", &config.SensitiveDataKey)
+	env.string("WEBHOOK_SECRET", &config.WebhookSecret)
+	env.string("CHALLENGE_SECRET", &config.ChallengeSecret)
+	env.string("CHALLENGE_VERIFY_URL", &config.ChallengeVerifyURL)
+	env.bool("REQUIRE_CHALLENGE", &config.RequireChallenge)
+	env.string("BUSINESS", &config.Business)
+	env.string("REFRESH_TOKEN", &config.RefreshToken)
+	env.string("TOKEN_ENDPOINT", &config.TokenEndpoint)
+	env.bool("SANDBOX", &config.SandboxMode)
+	env.bool("DEV_MODE", &config.DevMode)
+	env.optionalBool("ENFORCE_HTTPS", &config.EnforceHTTPS)
+	env.bool("ALLOW_INSECURE_LOCALHOST", &config.AllowInsecureLocalhost)
+	env.bool("STRICT_ENVIRONMENT", &config.StrictEnvironment)
+	env.bool("STRICT_WARMUP", &config.StrictWarmup)
+
+	apiVersion := string(config.APIVersion)
+	env.string("API_VERSION", &apiVersion)
+	config.APIVersion = APIVersion(apiVersion)
+
+	// Timeouts and retries
+	env.seconds("TIMEOUT", &config.Timeout)
+	env.int("MAX_RETRIES", &config.MaxRetries)
+	env.duration("RETRY_WAIT", &config.RetryWaitTime)
+	env.duration("MIN_ATTEMPT_BUDGET", &config.MinAttemptBudget)
+	env.duration("INIT_TIMEOUT", &config.InitTimeout)
+	env.duration("VERIFY_TIMEOUT", &config.VerifyTimeout)
+	env.duration("STATUS_TIMEOUT", &config.StatusTimeout)
+
+	// Caching
+	env.duration("CACHE_TTL", &config.CacheTTL)
+	env.duration("VERIFY_MEMO_TTL", &config.VerifyMemoTTL)
+
+	// Hedging
+	env.duration("HEDGE_DELAY", &config.HedgeDelay)
+	env.int("HEDGE_PERCENT", &config.HedgePercent)
+
+	// Gateway concurrency
+	env.int("GATEWAY_CONCURRENCY", &config.MaxConcurrentGatewayCalls)
+	env.duration("GATEWAY_CALL_WAIT", &config.GatewayCallWait)
+
+	// Correlation
+	env.string("CORRELATION_HEADER", &config.CorrelationHeader)
+	env.string("CORRELATION_OUTBOUND_HEADER", &config.CorrelationOutboundHeader)
+	env.bool("GENERATE_CORRELATION_ID", &config.GenerateCorrelationID)
+
+	// Network access
+	env.list("IP_ALLOWLIST", &config.IPAllowList)
+	env.list("CALLBACK_HOST_ALLOWLIST", &config.CallbackHostAllowList)
+	env.list("TRUSTED_PROXIES", &config.TrustedProxies)
+
+	// Verification and callbacks
+	env.bool("ENRICH_AFTER_VERIFY", &config.EnrichAfterVerify)
+	env.bool("SKIP_ECHO_CHECK", &config.SkipEchoCheck)
+	env.string("RETURN_URL", &config.ReturnURL)
+	env.bool("RETURN_REDIRECT", &config.ReturnRedirect)
+	env.string("RETURN_SECRET", &config.ReturnSecret)
+	env.bool("AUTO_VERIFY_CALLBACK", &config.AutoVerifyCallback)
+	env.bool("CALLBACK_HTML", &config.CallbackHTML)
+	env.bool("LIST_ROUTES_ON_NOT_FOUND", &config.ListRoutesOnNotFound)
+
+	// Payment descriptions
+	env.string("DEFAULT_DESCRIPTION", &config.DefaultDescription)
+	env.string("DEFAULT_DESCRIPTION_TEMPLATE", &config.DefaultDescriptionTemplate)
+
+	// Duplicate suppression
+	env.bool("REJECT_DUPLICATE_FACTOR_NUMBERS", &config.RejectDuplicateFactorNumbers)
+	duplicateMode := string(config.DuplicateFactorMode)
+	env.string("DUPLICATE_FACTOR_MODE", &duplicateMode)
+	config.DuplicateFactorMode = DuplicateFactorMode(duplicateMode)
+
+	cardMaskStyle := string(config.CardMaskStyle)
+	env.string("CARD_MASK_STYLE", &cardMaskStyle)
+	config.CardMaskStyle = CardMaskStyle(cardMaskStyle)
+	env.duration("DUPLICATE_FACTOR_WINDOW", &config.DuplicateFactorWindow)
+	env.duration("TOKEN_LIFETIME", &config.TokenLifetime)
+	env.duration("VERIFY_WARNING_THRESHOLD", &config.VerifyWarningThreshold)
+	env.duration("VERIFY_RETRY_DELAY", &config.VerifyRetryDelay)
+	env.bool("DEFERRED_INIT", &config.DeferredInit)
+	env.duration("DEFERRED_INIT_DEADLINE", &config.DeferredInitDeadline)
+	env.duration("DEFERRED_INIT_RETRY_INTERVAL", &config.DeferredInitRetryInterval)
+
+	// Health reports
+	env.duration("HEALTH_STORAGE_DEGRADED_LATENCY", &config.HealthStorageDegradedLatency)
+	env.duration("HEALTH_STORAGE_TIMEOUT", &config.HealthStorageTimeout)
+	env.int("HEALTH_QUEUE_DEGRADED_DEPTH", &config.HealthQueueDegradedDepth)
+	env.int("HEALTH_GATEWAY_DOWN_FAILURES", &config.HealthGatewayDownFailures)
+
+	// Dispute evidence
+	env.duration("EVIDENCE_RETENTION", &config.EvidenceRetention)
+	env.int("EVIDENCE_MAX_BYTES", &config.EvidenceMaxBytes)
+
+	if len(env.errs) > 0 {
+		return config, fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(env.errs...))
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	return config, nil
+}
+
+// lookup returns the trimmed value of a prefixed variable and whether it is set
+func (e *envLoader) lookup(name string) (string, string, bool) {
+	key := e.prefix + "_" + name
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return key, "", false
+	}
+	value = strings.TrimSpace(value)
+	return key, value, value != ""
+}
+
+// fail records a parse error for a variable
+func (e *envLoader) fail(key, value, expected string) {
+	e.errs = append(e.errs, fmt.Errorf("%s: invalid value %q, expected %s", key, value, expected))
+}
+
+// string reads a string variable
+func (e *envLoader) string(name string, target *string) {
+	if _, value, ok := e.lookup(name); ok {
+		*target = value
+	}
+}
+
+// bool reads a boolean variable such as true, false, 1 or 0
+func (e *envLoader) bool(name string, target *bool) {
+	key, value, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		e.fail(key, value, "a boolean (true or false)")
+		return
+	}
+	*target = parsed
+}
+
+// optionalBool reads a boolean variable into a field where nil means unset
+func (e *envLoader) optionalBool(name string, target **bool) {
+	var value bool
+	if _, _, ok := e.lookup(name); !ok {
+		return
+	}
+
+	failures := len(e.errs)
+	e.bool(name, &value)
+	if len(e.errs) == failures {
+		*target = &value
+	}
+}
+
+// int reads an integer variable
+func (e *envLoader) int(name string, target *int) {
+	key, value, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		e.fail(key, value, "an integer")
+		return
+	}
+	*target = parsed
+}
+
+// seconds reads a whole number of seconds given as an integer or a duration like 30s
+func (e *envLoader) seconds(name string, target *int) {
+	key, value, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+
+	parsed, err := parseSeconds(value)
+	if err != nil {
+		e.fail(key, value, "whole seconds (e.g. 30 or 30s)")
+		return
+	}
+	*target = parsed
+}
+
+// duration reads a duration like 500ms or 2s; plain integers are taken as seconds
+func (e *envLoader) duration(name string, target *time.Duration) {
+	key, value, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+
+	parsed, err := parseDurationValue(value)
+	if err != nil {
+		e.fail(key, value, "a duration (e.g. 500ms or 2s)")
+		return
+	}
+	*target = parsed
+}
+
+// serverKeys reads scoped keys written as key:scope+scope, separated by commas.
+// Errors don't repeat the value, which holds secrets.
+func (e *envLoader) serverKeys(name string, target *[]ServerKey) {
+	key, value, ok := e.lookup(name)
+	if !ok {
+		return
+	}
+
+	keys, err := parseServerKeys(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %w", key, err))
+		return
+	}
+	*target = keys
+}
+
+// list reads a comma-separated list, dropping empty entries
+func (e *envLoader) list(name string, target *[]string) {
+	if _, value, ok := e.lookup(name); ok {
+		*target = splitList(value)
+	}
+}
+
+// parseSeconds parses whole seconds given as an integer or a duration like 30s
+func parseSeconds(value string) (int, error) {
+	if parsed, err := strconv.Atoi(value); err == nil {
+		return parsed, nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if parsed%time.Second != 0 {
+		return 0, fmt.Errorf("%s is not a whole number of seconds", value)
+	}
+	return int(parsed / time.Second), nil
+}
+
+// parseDurationValue parses a duration like 500ms or 2s; plain integers are taken as seconds
+func parseDurationValue(value string) (time.Duration, error) {
+	if parsed, err := strconv.Atoi(value); err == nil {
+		return time.Duration(parsed) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,145 @@
+package vandargo
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setRequiredEnv sets the variables every loaded configuration needs
+func setRequiredEnv(t *testing.T, prefix string) {
+	t.Helper()
+
+	t.Setenv(prefix+"_API_KEY", "env-api-key")
+	t.Setenv(prefix+"_CALLBACK_URL", "https://shop.example.com/callback")
+}
+
+func TestNewConfigFromEnv(t *testing.T) {
+	setRequiredEnv(t, "VANDAR")
+	t.Setenv("VANDAR_TIMEOUT", "45s")
+	t.Setenv("VANDAR_SANDBOX", "1")
+	t.Setenv("VANDAR_MAX_RETRIES", " 5 ")
+	t.Setenv("VANDAR_RETRY_WAIT", "250ms")
+	t.Setenv("VANDAR_CACHE_TTL", "2")
+	t.Setenv("VANDAR_IP_ALLOWLIST", " 10.0.0.1, ,192.168.0.0/24,")
+	t.Setenv("VANDAR_ENFORCE_HTTPS", "false")
+	t.Setenv("VANDAR_ENCRYPTION_KEY", "")
+
+	config, err := NewConfigFromEnv("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.APIKey != "env-api-key" || config.CallbackURL != "https://shop.example.com/callback" {
+		t.Errorf("credentials %q, %q", config.APIKey, config.CallbackURL)
+	}
+	if config.Timeout != 45 || !config.SandboxMode || config.MaxRetries != 5 {
+		t.Errorf("timeout %d, sandbox %v, retries %d", config.Timeout, config.SandboxMode, config.MaxRetries)
+	}
+	if config.RetryWaitTime != 250*time.Millisecond || config.CacheTTL != 2*time.Second {
+		t.Errorf("retry wait %v, cache TTL %v", config.RetryWaitTime, config.CacheTTL)
+	}
+	if !reflect.DeepEqual(config.IPAllowList, []string{"10.0.0.1", "192.168.0.0/24"}) {
+		t.Errorf("IP allowlist %q", config.IPAllowList)
+	}
+	if config.EnforceHTTPS == nil || *config.EnforceHTTPS {
+		t.Errorf("EnforceHTTPS = %v, want explicitly false", config.EnforceHTTPS)
+	}
+
+	// Unset and empty variables keep the defaults
+	defaults := DefaultConfig()
+	if config.BaseURL != defaults.BaseURL || config.EncryptionKey != defaults.EncryptionKey || config.TokenLifetime != defaults.TokenLifetime {
+		t.Errorf("defaults not kept: base URL %q, token lifetime %v", config.BaseURL, config.TokenLifetime)
+	}
+}
+
+func TestNewConfigFromEnvMalformed(t *testing.T) {
+	tests := []struct {
+		name, value string
+		want        string
+	}{
+		{"TIMEOUT", "1.5s", "VANDAR_TIMEOUT: invalid value \"1.5s\", expected whole seconds"},
+		{"TIMEOUT", "thirty", "VANDAR_TIMEOUT: invalid value \"thirty\""},
+		{"RETRY_WAIT", "10 minutes", "VANDAR_RETRY_WAIT: invalid value \"10 minutes\", expected a duration"},
+		{"RETRY_WAIT", "1.5", "VANDAR_RETRY_WAIT: invalid value \"1.5\""},
+		{"CACHE_TTL", "5m30", "VANDAR_CACHE_TTL: invalid value \"5m30\""},
+		{"SANDBOX", "yes", "VANDAR_SANDBOX: invalid value \"yes\", expected a boolean"},
+		{"SANDBOX", "on", "VANDAR_SANDBOX: invalid value \"on\""},
+		{"ENFORCE_HTTPS", "maybe", "VANDAR_ENFORCE_HTTPS: invalid value \"maybe\""},
+		{"MAX_RETRIES", "three", "VANDAR_MAX_RETRIES: invalid value \"three\", expected an integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			setRequiredEnv(t, "VANDAR")
+			t.Setenv("VANDAR_"+tt.name, tt.value)
+
+			_, err := NewConfigFromEnv("")
+			if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("NewConfigFromEnv() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewConfigFromEnvReportsEveryError(t *testing.T) {
+	setRequiredEnv(t, "VANDAR")
+	t.Setenv("VANDAR_TIMEOUT", "soon")
+	t.Setenv("VANDAR_SANDBOX", "perhaps")
+	t.Setenv("VANDAR_HEDGE_DELAY", "-")
+
+	_, err := NewConfigFromEnv("")
+	for _, key := range []string{"VANDAR_TIMEOUT", "VANDAR_SANDBOX", "VANDAR_HEDGE_DELAY"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("error %v does not name %s", err, key)
+		}
+	}
+}
+
+func TestNewConfigFromEnvSecretsNotEchoed(t *testing.T) {
+	setRequiredEnv(t, "VANDAR")
+	t.Setenv("VANDAR_SERVER_API_KEYS", "first-secret:payments.read,second-secret")
+
+	_, err := NewConfigFromEnv("")
+	if err == nil || !strings.Contains(err.Error(), "VANDAR_SERVER_API_KEYS") {
+		t.Fatalf("NewConfigFromEnv() = %v", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("error echoes a key: %v", err)
+	}
+}
+
+func TestNewConfigFromEnvPrefix(t *testing.T) {
+	setRequiredEnv(t, "VANDAR")
+	t.Setenv("VANDAR_TIMEOUT", "not read")
+	t.Setenv("SHOP_PSP_API_KEY", "shop-api-key")
+	t.Setenv("SHOP_PSP_CALLBACK_URL", "https://shop.example.com/psp")
+	t.Setenv("SHOP_PSP_TIMEOUT", "12")
+
+	// A trailing underscore and spaces are tolerated
+	for _, prefix := range []string{"SHOP_PSP", "SHOP_PSP_", " SHOP_PSP "} {
+		config, err := NewConfigFromEnv(prefix)
+		if err != nil {
+			t.Fatalf("prefix %q: %v", prefix, err)
+		}
+		if config.APIKey != "shop-api-key" || config.CallbackURL != "https://shop.example.com/psp" || config.Timeout != 12 {
+			t.Fatalf("prefix %q: key %q, callback %q, timeout %d", prefix, config.APIKey, config.CallbackURL, config.Timeout)
+		}
+	}
+
+	// Without the prefix's variables the configuration is incomplete
+	if _, err := NewConfigFromEnv("OTHER"); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "api key is required") {
+		t.Fatalf("NewConfigFromEnv(OTHER) = %v", err)
+	}
+}
+
+func TestNewConfigFromEnvValidates(t *testing.T) {
+	setRequiredEnv(t, "VANDAR")
+	t.Setenv("VANDAR_BASE_URL", "ftp://ipg.vandar.io")
+
+	if _, err := NewConfigFromEnv(""); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("NewConfigFromEnv() = %v, want the validation error", err)
+	}
+}
@@ -0,0 +1,493 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// config_file.go implements loading configuration from JSON and YAML files
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configFileField applies one file value to a Config
+type configFileField func(config *Config, raw interface{}) error
+
+// configFileFields maps file keys to Config fields
+var configFileFields = map[string]configFileField{
+	"api_key":                  stringField(func(c *Config) *string { return &c.APIKey }),
+	"base_url":                 stringField(func(c *Config) *string { return &c.BaseURL }),
+	"callback_url":             stringField(func(c *Config) *string { return &c.CallbackURL }),
+	"encryption_key":           stringField(func(c *Config) *string { return &c.EncryptionKey }),
+	"hash_key":                 stringField(func(c *Config) *string { return &c.HashKey }),
+	"admin_key":                stringField(func(c *Config) *string { return &c.AdminKey }),
+	"server_api_keys":          serverKeysField,
+	"sensitive_data_key":       stringField(func(c *Config) *string { return &c.SensitiveDataKey }),
+	"webhook_secret":           stringField(func(c *Config) *string { return &c.WebhookSecret }),
+	"challenge_secret":         stringField(func(c *Config) *string { return &c.ChallengeSecret }),
+	"challenge_verify_url":     stringField(func(c *Config) *string { return &c.ChallengeVerifyURL }),
+	"require_challenge":        boolField(func(c *Config) *bool { return &c.RequireChallenge }),
+	"business":                 stringField(func(c *Config) *string { return &c.Business }),
+	"refresh_token":            stringField(func(c *Config) *string { return &c.RefreshToken }),
+	"token_endpoint":           stringField(func(c *Config) *string { return &c.TokenEndpoint }),
+	"sandbox":                  boolField(func(c *Config) *bool { return &c.SandboxMode }),
+	"dev_mode":                 boolField(func(c *Config) *bool { return &c.DevMode }),
+	"enforce_https":            optionalBoolField(func(c *Config) **bool { return &c.EnforceHTTPS }),
+	"allow_insecure_localhost": boolField(func(c *Config) *bool { return &c.AllowInsecureLocalhost }),
+	"strict_environment":       boolField(func(c *Config) *bool { return &c.StrictEnvironment }),
+	"strict_warmup":            boolField(func(c *Config) *bool { return &c.StrictWarmup }),
+	"api_version":              apiVersionField,
+	"timeout":                  secondsField(func(c *Config) *int { return &c.Timeout }),
+	"max_retries":              intField(func(c *Config) *int { return &c.MaxRetries }),
+	"retry_wait":               durationField(func(c *Config) *time.Duration { return &c.RetryWaitTime }),
+	"min_attempt_budget":       durationField(func(c *Config) *time.Duration { return &c.MinAttemptBudget }),
+	"init_timeout":             durationField(func(c *Config) *time.Duration { return &c.InitTimeout }),
+	"verify_timeout":           durationField(func(c *Config) *time.Duration { return &c.VerifyTimeout }),
+	"status_timeout":           durationField(func(c *Config) *time.Duration { return &c.StatusTimeout }),
+	"cache_ttl":                durationField(func(c *Config) *time.Duration { return &c.CacheTTL }),
+	"verify_memo_ttl":          durationField(func(c *Config) *time.Duration { return &c.VerifyMemoTTL }),
+	"hedge_delay":              durationField(func(c *Config) *time.Duration { return &c.HedgeDelay }),
+	"hedge_percent":            intField(func(c *Config) *int { return &c.HedgePercent }),
+	"gateway_concurrency":      intField(func(c *Config) *int { return &c.MaxConcurrentGatewayCalls }),
+	"gateway_call_wait":        durationField(func(c *Config) *time.Duration { return &c.GatewayCallWait }),
+	"ip_allowlist":             listField(func(c *Config) *[]string { return &c.IPAllowList }),
+	"callback_host_allowlist":  listField(func(c *Config) *[]string { return &c.CallbackHostAllowList }),
+	"trusted_proxies":          listField(func(c *Config) *[]string { return &c.TrustedProxies }),
+	"enrich_after_verify":      boolField(func(c *Config) *bool { return &c.EnrichAfterVerify }),
+	"skip_echo_check":          boolField(func(c *Config) *bool { return &c.SkipEchoCheck }),
+	"return_url":               stringField(func(c *Config) *string { return &c.ReturnURL }),
+	"return_redirect":          boolField(func(c *Config) *bool { return &c.ReturnRedirect }),
+	"return_secret":            stringField(func(c *Config) *string { return &c.ReturnSecret }),
+	"auto_verify_callback":     boolField(func(c *Config) *bool { return &c.AutoVerifyCallback }),
+	"callback_html":            boolField(func(c *Config) *bool { return &c.CallbackHTML }),
+	"list_routes_on_not_found": boolField(func(c *Config) *bool { return &c.ListRoutesOnNotFound }),
+
+	"reject_duplicate_factor_numbers": boolField(func(c *Config) *bool { return &c.RejectDuplicateFactorNumbers }),
+	"duplicate_factor_mode":           duplicateFactorModeField,
+	"card_mask_style":                 cardMaskStyleField,
+	"duplicate_factor_window":         durationField(func(c *Config) *time.Duration { return &c.DuplicateFactorWindow }),
+	"token_lifetime":                  durationField(func(c *Config) *time.Duration { return &c.TokenLifetime }),
+	"verify_warning_threshold":        durationField(func(c *Config) *time.Duration { return &c.VerifyWarningThreshold }),
+	"verify_retry_delay":              durationField(func(c *Config) *time.Duration { return &c.VerifyRetryDelay }),
+	"deferred_init":                   boolField(func(c *Config) *bool { return &c.DeferredInit }),
+	"deferred_init_deadline":          durationField(func(c *Config) *time.Duration { return &c.DeferredInitDeadline }),
+	"deferred_init_retry_interval":    durationField(func(c *Config) *time.Duration { return &c.DeferredInitRetryInterval }),
+	"health_storage_degraded_latency": durationField(func(c *Config) *time.Duration { return &c.HealthStorageDegradedLatency }),
+	"health_storage_timeout":          durationField(func(c *Config) *time.Duration { return &c.HealthStorageTimeout }),
+	"health_queue_degraded_depth":     intField(func(c *Config) *int { return &c.HealthQueueDegradedDepth }),
+	"health_gateway_down_failures":    intField(func(c *Config) *int { return &c.HealthGatewayDownFailures }),
+	"evidence_retention":              durationField(func(c *Config) *time.Duration { return &c.EvidenceRetention }),
+	"evidence_max_bytes":              intField(func(c *Config) *int { return &c.EvidenceMaxBytes }),
+	"default_description":             stringField(func(c *Config) *string { return &c.DefaultDescription }),
+	"default_description_template":    stringField(func(c *Config) *string { return &c.DefaultDescriptionTemplate }),
+	"correlation_header":              stringField(func(c *Config) *string { return &c.CorrelationHeader }),
+	"correlation_outbound_header":     stringField(func(c *Config) *string { return &c.CorrelationOutboundHeader }),
+	"generate_correlation_id":         boolField(func(c *Config) *bool { return &c.GenerateCorrelationID }),
+}
+
+// secretFileKeys lists the keys that may be read from a file via "<key>_file"
+var secretFileKeys = []string{"api_key", "encryption_key", "hash_key", "admin_key", "sensitive_data_key", "webhook_secret", "refresh_token", "return_secret"}
+
+// LoadConfig reads a JSON (.json) or YAML (.yaml, .yml) configuration file. Secret
+// values may be read from mounted files with keys like api_key_file. Unset keys keep
+// their DefaultConfig values and the result is validated. Unknown keys are reported
+// as warnings on the default logger.
+func LoadConfig(path string) (Config, error) {
+	config, warnings, err := LoadConfigWithWarnings(path)
+	if len(warnings) > 0 {
+		NewDefaultLogger("WARN").Warn(context.Background(), "Configuration file contains unknown keys", map[string]interface{}{
+			"path": path,
+			"keys": warnings,
+		})
+	}
+	return config, err
+}
+
+// LoadConfigWithWarnings is like LoadConfig but returns the unknown keys instead of logging them
+func LoadConfigWithWarnings(path string) (Config, []string, error) {
+	config := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Detect the format by extension
+	var values map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil {
+			return config, nil, fmt.Errorf("%w: failed to parse %s: %v", ErrInvalidConfig, path, err)
+		}
+	case ".yaml", ".yml":
+		values, err = parseSimpleYAML(data)
+		if err != nil {
+			return config, nil, fmt.Errorf("%w: failed to parse %s: %v", ErrInvalidConfig, path, err)
+		}
+	default:
+		return config, nil, fmt.Errorf("%w: unsupported config file extension %q", ErrInvalidConfig, filepath.Ext(path))
+	}
+
+	// Resolve secret file indirection relative to the config file
+	for _, key := range secretFileKeys {
+		fileKey := key + "_file"
+		raw, ok := values[fileKey]
+		if !ok {
+			continue
+		}
+		delete(values, fileKey)
+
+		secretPath, err := scalarString(raw)
+		if err != nil || secretPath == "" {
+			return config, nil, fmt.Errorf("%w: %s must be a file path", ErrInvalidConfig, fileKey)
+		}
+		if !filepath.IsAbs(secretPath) {
+			secretPath = filepath.Join(filepath.Dir(path), secretPath)
+		}
+
+		secret, err := os.ReadFile(secretPath)
+		if err != nil {
+			return config, nil, fmt.Errorf("%w: failed to read %s: %v", ErrInvalidConfig, fileKey, err)
+		}
+		values[key] = strings.TrimSpace(string(secret))
+	}
+
+	// Apply known keys, collecting unknown ones
+	var unknown []string
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		field, ok := configFileFields[key]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		if err := field(&config, values[key]); err != nil {
+			return config, unknown, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, key, err)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, unknown, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	return config, unknown, nil
+}
+
+// stringField sets a string field
+func stringField(target func(*Config) *string) configFileField {
+	return func(config *Config, raw interface{}) error {
+		value, err := scalarString(raw)
+		if err != nil {
+			return err
+		}
+		*target(config) = value
+		return nil
+	}
+}
+
+// apiVersionField sets the API version
+func apiVersionField(config *Config, raw interface{}) error {
+	value, err := scalarString(raw)
+	if err != nil {
+		return err
+	}
+	config.APIVersion = APIVersion(strings.ToLower(value))
+	return nil
+}
+
+// duplicateFactorModeField sets the duplicate factor number mode
+func duplicateFactorModeField(config *Config, raw interface{}) error {
+	value, err := scalarString(raw)
+	if err != nil {
+		return err
+	}
+	config.DuplicateFactorMode = DuplicateFactorMode(strings.ToLower(value))
+	return nil
+}
+
+// serverKeysField sets the scoped server keys from a list of {key, scopes} objects
+// or a key:scope+scope string
+func serverKeysField(config *Config, raw interface{}) error {
+	items, ok := raw.([]interface{})
+	if !ok {
+		value, err := scalarString(raw)
+		if err != nil {
+			return err
+		}
+		keys, err := parseServerKeys(value)
+		if err != nil {
+			return err
+		}
+		config.ServerAPIKeys = keys
+		return nil
+	}
+
+	keys := make([]ServerKey, 0, len(items))
+	for _, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected objects with key and scopes")
+		}
+
+		key, err := scalarString(entry["key"])
+		if err != nil {
+			return fmt.Errorf("key: %w", err)
+		}
+
+		scopes, err := scalarList(entry["scopes"])
+		if err != nil {
+			return fmt.Errorf("scopes: %w", err)
+		}
+
+		serverKey := ServerKey{Key: key}
+		for _, scope := range scopes {
+			serverKey.Scopes = append(serverKey.Scopes, Scope(scope))
+		}
+		keys = append(keys, serverKey)
+	}
+
+	config.ServerAPIKeys = keys
+	return nil
+}
+
+// cardMaskStyleField sets the card mask style
+func cardMaskStyleField(config *Config, raw interface{}) error {
+	value, err := scalarString(raw)
+	if err != nil {
+		return err
+	}
+	config.CardMaskStyle = CardMaskStyle(strings.ToLower(value))
+	return nil
+}
+
+// boolField sets a boolean field
+func boolField(target func(*Config) *bool) configFileField {
+	return func(config *Config, raw interface{}) error {
+		value, err := scalarString(raw)
+		if err != nil {
+			return err
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q, expected a boolean", value)
+		}
+		*target(config) = parsed
+		return nil
+	}
+}
+
+// optionalBoolField sets a boolean field where nil means unset
+func optionalBoolField(target func(*Config) **bool) configFileField {
+	return func(config *Config, raw interface{}) error {
+		var value bool
+		if err := boolField(func(*Config) *bool { return &value })(config, raw); err != nil {
+			return err
+		}
+		*target(config) = &value
+		return nil
+	}
+}
+
+// intField sets an integer field
+func intField(target func(*Config) *int) configFileField {
+	return func(config *Config, raw interface{}) error {
+		value, err := scalarString(raw)
+		if err != nil {
+			return err
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q, expected an integer", value)
+		}
+		*target(config) = parsed
+		return nil
+	}
+}
+
+// secondsField sets a whole-seconds field from an integer or a duration string
+func secondsField(target func(*Config) *int) configFileField {
+	return func(config *Config, raw interface{}) error {
+		value, err := scalarString(raw)
+		if err != nil {
+			return err
+		}
+		parsed, err := parseSeconds(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q, expected whole seconds (e.g. 30 or 30s)", value)
+		}
+		*target(config) = parsed
+		return nil
+	}
+}
+
+// durationField sets a duration field from a string like "30s" or a number of seconds
+func durationField(target func(*Config) *time.Duration) configFileField {
+	return func(config *Config, raw interface{}) error {
+		value, err := scalarString(raw)
+		if err != nil {
+			return err
+		}
+		parsed, err := parseDurationValue(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q, expected a duration (e.g. 500ms or 2s)", value)
+		}
+		*target(config) = parsed
+		return nil
+	}
+}
+
+// listField sets a list field from an array or a comma-separated string
+func listField(target func(*Config) *[]string) configFileField {
+	return func(config *Config, raw interface{}) error {
+		list, err := scalarList(raw)
+		if err != nil {
+			return err
+		}
+		*target(config) = list
+		return nil
+	}
+}
+
+// scalarList converts an array or a comma-separated string to a list of strings
+func scalarList(raw interface{}) ([]string, error) {
+	items, ok := raw.([]interface{})
+	if !ok {
+		value, err := scalarString(raw)
+		if err != nil {
+			return nil, err
+		}
+		return splitList(value), nil
+	}
+
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		value, err := scalarString(item)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			list = append(list, value)
+		}
+	}
+	return list, nil
+}
+
+// scalarString converts a decoded scalar to its string form
+func scalarString(raw interface{}) (string, error) {
+	switch value := raw.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return strings.TrimSpace(value), nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("expected a single value, got %T", raw)
+	}
+}
+
+// parseSimpleYAML decodes the flat subset of YAML used by configuration files:
+// "key: value" pairs, comments, quoted strings, inline [a, b] lists and block lists
+func parseSimpleYAML(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	var listKey string
+
+	for number, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+
+		// Items of a block list belong to the preceding key
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", number+1)
+			}
+			item := unquoteYAML(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			values[listKey] = append(values[listKey].([]interface{}), item)
+			continue
+		}
+
+		if line != trimmed {
+			return nil, fmt.Errorf("line %d: nested mappings are not supported", number+1)
+		}
+
+		key, value, found := strings.Cut(trimmed, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", number+1)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		listKey = ""
+
+		switch {
+		case value == "":
+			// A block list may follow
+			listKey = key
+			values[key] = []interface{}{}
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			var items []interface{}
+			for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, unquoteYAML(item))
+				}
+			}
+			values[key] = items
+		default:
+			values[key] = unquoteYAML(value)
+		}
+	}
+
+	return values, nil
+}
+
+// stripYAMLComment removes a trailing comment outside of quotes
+func stripYAMLComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquoteYAML removes matching single or double quotes around a scalar
+func unquoteYAML(value string) string {
+	if len(value) >= 2 {
+		if value[0] == '"' && value[len(value)-1] == '"' {
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				return unquoted
+			}
+			return value[1 : len(value)-1]
+		}
+		if value[0] == '\'' && value[len(value)-1] == '\'' {
+			return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+	}
+	return value
+}
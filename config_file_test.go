@@ -0,0 +1,158 @@
+package vandargo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a configuration file into a temporary directory and returns its path
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFixtures(t *testing.T) {
+	var loaded []Config
+	for _, name := range []string{"config.json", "config.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			config, unknown, err := LoadConfigWithWarnings(filepath.Join("testdata", "config", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Secrets come from the files next to the configuration, trimmed
+			if config.APIKey != "file-api-key" || config.WebhookSecret != "file-webhook-secret" {
+				t.Errorf("secrets %q, %q", config.APIKey, config.WebhookSecret)
+			}
+			if config.CallbackURL != "https://shop.example.com/callback" || !config.SandboxMode {
+				t.Errorf("callback %q, sandbox %v", config.CallbackURL, config.SandboxMode)
+			}
+			if config.Timeout != 45 || config.MaxRetries != 5 {
+				t.Errorf("timeout %d, retries %d", config.Timeout, config.MaxRetries)
+			}
+			if config.RetryWaitTime != 250*time.Millisecond || config.CacheTTL != 2*time.Second {
+				t.Errorf("retry wait %v, cache TTL %v", config.RetryWaitTime, config.CacheTTL)
+			}
+			if !reflect.DeepEqual(config.IPAllowList, []string{"10.0.0.1", "192.168.0.0/24"}) {
+				t.Errorf("IP allowlist %q", config.IPAllowList)
+			}
+			if !reflect.DeepEqual(config.TrustedProxies, []string{"10.0.0.2", "10.0.0.3"}) {
+				t.Errorf("trusted proxies %q", config.TrustedProxies)
+			}
+			if config.DefaultDescription != "Order #{{.order}}" {
+				t.Errorf("description %q", config.DefaultDescription)
+			}
+			if config.EnforceHTTPS == nil || *config.EnforceHTTPS {
+				t.Errorf("EnforceHTTPS = %v, want explicitly false", config.EnforceHTTPS)
+			}
+
+			// Unknown keys are reported, sorted, and don't fail the load
+			if !reflect.DeepEqual(unknown, []string{"color", "legacy_mode"}) {
+				t.Errorf("unknown keys %q", unknown)
+			}
+			loaded = append(loaded, config)
+		})
+	}
+
+	// Both formats describe the same configuration
+	if len(loaded) == 2 && !reflect.DeepEqual(loaded[0], loaded[1]) {
+		t.Fatalf("JSON and YAML differ:\n%+v\n%+v", loaded[0], loaded[1])
+	}
+}
+
+func TestLoadConfigSecretFileAbsolutePath(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "api_key")
+	if err := os.WriteFile(secret, []byte("\n absolute-api-key \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfigFile(t, "config.yml", "api_key_file: "+secret+"\ncallback_url: https://shop.example.com/callback\n")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.APIKey != "absolute-api-key" {
+		t.Fatalf("APIKey = %q", config.APIKey)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+		want                string
+	}{
+		{"missing secret file", "config.json", `{"api_key_file": "missing", "callback_url": "https://shop.example.com/callback"}`, "failed to read api_key_file"},
+		{"empty secret path", "config.yaml", "api_key_file: ''\ncallback_url: https://shop.example.com/callback\n", "api_key_file must be a file path"},
+		{"secret path list", "config.yaml", "api_key_file: [a, b]\ncallback_url: https://shop.example.com/callback\n", "api_key_file must be a file path"},
+		{"unsupported extension", "config.toml", "api_key = 'key'\n", "unsupported config file extension \".toml\""},
+		{"malformed JSON", "config.json", `{"api_key": `, "failed to parse"},
+		{"nested mapping", "config.yaml", "server:\n  port: 8080\n", "line 2: nested mappings are not supported"},
+		{"list without key", "config.yaml", "- 10.0.0.1\n", "line 1: list item without a key"},
+		{"no separator", "config.yaml", "api_key\n", "line 1: expected \"key: value\""},
+		{"bad duration", "config.json", `{"api_key": "key", "callback_url": "https://shop.example.com/callback", "retry_wait": "soon"}`, "retry_wait: invalid value \"soon\""},
+		{"bad boolean", "config.yaml", "api_key: key\ncallback_url: https://shop.example.com/callback\nsandbox: perhaps\n", "sandbox:"},
+		{"list for a string", "config.json", `{"api_key": ["a", "b"], "callback_url": "https://shop.example.com/callback"}`, "api_key: expected a single value"},
+		{"fails validation", "config.yaml", "callback_url: https://shop.example.com/callback\n", "api key is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfigFile(t, tt.file, tt.content))
+			if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("LoadConfig() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "absent.json")); err == nil || errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("LoadConfig(absent) = %v, want a read error", err)
+	}
+}
+
+func TestParseSimpleYAML(t *testing.T) {
+	values, err := parseSimpleYAML([]byte(strings.Join([]string{
+		"---",
+		"# a comment line",
+		"plain: value  # trailing comment",
+		`hash: "keep # this"`,
+		`single: 'it''s'`,
+		"url: https://shop.example.com/a#fragment",
+		"empty_list: []",
+		"inline: [a, 'b', \"d\"]",
+		"block:",
+		"  - one",
+		"  - 'two'",
+		"",
+		"after: done",
+	}, "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"plain":  "value",
+		"hash":   "keep # this",
+		"single": "it's",
+		"url":    "https://shop.example.com/a#fragment",
+		"inline": []interface{}{"a", "b", "d"},
+		"block":  []interface{}{"one", "two"},
+		"after":  "done",
+	}
+	if list, ok := values["empty_list"].([]interface{}); !ok || len(list) != 0 {
+		t.Errorf("empty_list = %#v", values["empty_list"])
+	}
+	delete(values, "empty_list")
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("parseSimpleYAML() =\n%#v\nwant\n%#v", values, want)
+	}
+}
@@ -0,0 +1,92 @@
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJoinURL(t *testing.T) {
+	tests := []struct {
+		base, endpoint string
+		want           string
+	}{
+		{"https://ipg.vandar.io", "/api/v4/send", "https://ipg.vandar.io/api/v4/send"},
+		{"https://ipg.vandar.io/", "/api/v4/send", "https://ipg.vandar.io/api/v4/send"},
+		{"https://ipg.vandar.io//", "api/v4/send", "https://ipg.vandar.io/api/v4/send"},
+		{"https://proxy.example.com/vandar", "/api/v4/send", "https://proxy.example.com/vandar/api/v4/send"},
+		{"https://proxy.example.com/vandar/", "/api/v4/send", "https://proxy.example.com/vandar/api/v4/send"},
+		{"http://localhost:8080", "/v4/abc", "http://localhost:8080/v4/abc"},
+		{"http://localhost:8080/psp/", "v4/abc", "http://localhost:8080/psp/v4/abc"},
+		{" https://ipg.vandar.io ", "/api/v4/verify", "https://ipg.vandar.io/api/v4/verify"},
+	}
+
+	for _, tt := range tests {
+		got, err := joinURL(tt.base, tt.endpoint)
+		if err != nil || got != tt.want {
+			t.Errorf("joinURL(%q, %q) = %q, %v, want %q", tt.base, tt.endpoint, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseBaseURLRejects(t *testing.T) {
+	tests := []struct {
+		base string
+		want string
+	}{
+		{"ftp://ipg.vandar.io", "scheme must be http or https"},
+		{"ipg.vandar.io", "scheme must be http or https"},
+		{"https://", "host is required"},
+		{"https://ipg.vandar.io?debug=1", "query and fragment are not allowed"},
+		{"https://ipg.vandar.io/?", "query and fragment are not allowed"},
+		{"https://ipg.vandar.io/#top", "query and fragment are not allowed"},
+		{"https://ipg.vandar.io:port", "invalid base url"},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseBaseURL(tt.base); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseBaseURL(%q) = %v, want %q", tt.base, err, tt.want)
+		}
+
+		// NewConfig rejects the same URLs
+		config := DefaultConfig()
+		config.APIKey = testAPIKey
+		config.CallbackURL = "https://shop.example.com/callback"
+		config.BaseURL = tt.base
+		if _, err := NewConfig(config); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("NewConfig(base URL %q) = %v, want %q", tt.base, err, tt.want)
+		}
+	}
+}
+
+func TestClientBaseURLVariants(t *testing.T) {
+	tests := []struct {
+		base string
+		want string
+	}{
+		{"https://ipg.vandar.io", "https://ipg.vandar.io/v4/" + webhookToken},
+		{"https://ipg.vandar.io/", "https://ipg.vandar.io/v4/" + webhookToken},
+		{"https://proxy.example.com/vandar/", "https://proxy.example.com/vandar/v4/" + webhookToken},
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080/v4/" + webhookToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+				"status": true, "amount": 100000, "transactionStatus": "PAID",
+			}))
+			client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+				c.BaseURL = tt.base
+				c.AllowInsecureLocalhost = true
+			}), transport)
+
+			if _, err := client.GetPaymentStatus(context.Background(), webhookToken); err != nil {
+				t.Fatal(err)
+			}
+			if req, _ := transport.request(0); req.URL.String() != tt.want {
+				t.Fatalf("requested %q, want %q", req.URL, tt.want)
+			}
+		})
+	}
+}
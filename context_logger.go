@@ -0,0 +1,158 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// context_logger.go implements request-scoped loggers carried in the context
+package vandargo
+
+import (
+	"context"
+	"net/http"
+)
+
+// loggerKey stores the request-scoped logger in the context
+const loggerKey contextKey = "logger"
+
+// fieldLogger adds a fixed set of fields to every entry of a wrapped logger
+type fieldLogger struct {
+	logger LoggerInterface
+	fields map[string]interface{}
+}
+
+// WithFields returns a logger that merges fields into every entry; fields passed to a
+// log call take precedence over them. Wrapping a WithFields logger merges both sets.
+func WithFields(logger LoggerInterface, fields map[string]interface{}) LoggerInterface {
+	logger = loggerOrDiscard(logger)
+	if len(fields) == 0 {
+		return logger
+	}
+
+	// Flatten nested wrappers so each entry is merged only once
+	if wrapped, ok := logger.(*fieldLogger); ok {
+		return &fieldLogger{
+			logger: wrapped.logger,
+			fields: mergeFields(wrapped.fields, fields),
+		}
+	}
+
+	return &fieldLogger{
+		logger: logger,
+		fields: mergeFields(nil, fields),
+	}
+}
+
+// mergeFields returns a new map holding base overridden by extra
+func mergeFields(base, extra map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(extra))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range extra {
+		merged[key] = value
+	}
+	return merged
+}
+
+// Debug logs debug level messages with the logger's fields
+func (l *fieldLogger) Debug(ctx context.Context, message string, fields map[string]interface{}) {
+	l.logger.Debug(ctx, message, mergeFields(l.fields, fields))
+}
+
+// Info logs informational messages with the logger's fields
+func (l *fieldLogger) Info(ctx context.Context, message string, fields map[string]interface{}) {
+	l.logger.Info(ctx, message, mergeFields(l.fields, fields))
+}
+
+// Warn logs warning messages with the logger's fields
+func (l *fieldLogger) Warn(ctx context.Context, message string, fields map[string]interface{}) {
+	l.logger.Warn(ctx, message, mergeFields(l.fields, fields))
+}
+
+// Error logs error messages with the logger's fields
+func (l *fieldLogger) Error(ctx context.Context, message string, err error, fields map[string]interface{}) {
+	l.logger.Error(ctx, message, err, mergeFields(l.fields, fields))
+}
+
+// ContextWithLogger returns a context carrying a request-scoped logger
+func ContextWithLogger(ctx context.Context, logger LoggerInterface) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// LoggerFromContext returns the request-scoped logger stored by ContextLoggerMiddleware
+// or ContextWithLogger, or a logger discarding all entries when there is none
+func LoggerFromContext(ctx context.Context) LoggerInterface {
+	if logger := contextLogger(ctx); logger != nil {
+		return logger
+	}
+	return discardLogger{}
+}
+
+// contextLogger returns the request-scoped logger of a context, if any
+func contextLogger(ctx context.Context) LoggerInterface {
+	if ctx == nil {
+		return nil
+	}
+	logger, _ := ctx.Value(loggerKey).(LoggerInterface)
+	return logger
+}
+
+// log returns the request-scoped logger of a context, falling back to the client's
+// logger. Once the caller went away, errors it caused are logged at debug level.
+func (c *Client) log(ctx context.Context) LoggerInterface {
+	logger := contextLogger(ctx)
+	if logger == nil {
+		logger = loggerOrDiscard(c.logger)
+	}
+	if ctx != nil && clientGone(ctx) {
+		return clientGoneLogger{logger}
+	}
+	return logger
+}
+
+// ContextLoggerMiddleware stores a logger enriched with the request ID, route and
+// client IP in the request context. It belongs after RequestIDMiddleware,
+// ClientIPMiddleware and the route pattern so those fields are available.
+func ContextLoggerMiddleware(logger LoggerInterface) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fields := map[string]interface{}{
+				"method":    r.Method,
+				"client_ip": getClientIP(r),
+			}
+			if requestID, ok := r.Context().Value("request_id").(string); ok {
+				fields["request_id"] = requestID
+			}
+			if correlationID, ok := CorrelationIDFromContext(r.Context()); ok {
+				fields["correlation_id"] = correlationID
+			}
+			if route := routePattern(r); route != "" {
+				fields["route"] = route
+			}
+
+			ctx := ContextWithLogger(r.Context(), WithFields(logger, fields))
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// discardLogger drops all entries
+type discardLogger struct{}
+
+// loggerOrDiscard returns the logger, or a logger dropping all entries when it is
+// nil, so a missing logger never panics in an error path
+func loggerOrDiscard(logger LoggerInterface) LoggerInterface {
+	if logger == nil {
+		return discardLogger{}
+	}
+	return logger
+}
+
+// Debug discards the entry
+func (discardLogger) Debug(ctx context.Context, message string, fields map[string]interface{}) {}
+
+// Info discards the entry
+func (discardLogger) Info(ctx context.Context, message string, fields map[string]interface{}) {}
+
+// Warn discards the entry
+func (discardLogger) Warn(ctx context.Context, message string, fields map[string]interface{}) {}
+
+// Error discards the entry
+func (discardLogger) Error(ctx context.Context, message string, err error, fields map[string]interface{}) {
+}
@@ -0,0 +1,115 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWithFieldsMerge(t *testing.T) {
+	base := &captureLogger{}
+	outerFields := map[string]interface{}{"request_id": "req-1", "route": "/payments/init"}
+	outer := WithFields(base, outerFields)
+	inner := WithFields(outer, map[string]interface{}{"token": webhookToken, "route": "/payments/verify"})
+
+	inner.Info(context.Background(), "nested", map[string]interface{}{"amount": 100000, "token": "override"})
+	inner.Error(context.Background(), "failed", errors.New("boom"), nil)
+
+	// Later fields win: the inner wrapper over the outer one, call fields over both
+	entries := base.all()
+	want := map[string]interface{}{"request_id": "req-1", "route": "/payments/verify", "token": "override", "amount": 100000}
+	if len(entries) != 2 || !reflect.DeepEqual(entries[0].fields, want) {
+		t.Fatalf("entries %+v, want fields %v", entries, want)
+	}
+	if entries[1].level != "error" || entries[1].err == nil || entries[1].fields["token"] != webhookToken {
+		t.Fatalf("error entry %+v", entries[1])
+	}
+
+	// Nested wrappers are flattened and the caller's maps are left alone
+	if _, ok := inner.(*fieldLogger).logger.(*captureLogger); !ok {
+		t.Fatal("nested WithFields wraps a wrapper")
+	}
+	if len(outerFields) != 2 || outerFields["route"] != "/payments/init" {
+		t.Fatalf("outer fields changed: %v", outerFields)
+	}
+
+	// Without fields the logger is returned as is; a nil logger discards
+	if WithFields(base, nil) != LoggerInterface(base) {
+		t.Fatal("WithFields without fields wrapped the logger")
+	}
+	WithFields(nil, outerFields).Warn(context.Background(), "dropped", nil)
+	LoggerFromContext(context.Background()).Info(context.Background(), "dropped", nil)
+}
+
+func TestContextLoggerThroughHandler(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+		"status": true, "amount": 100000, "transactionStatus": "PAID",
+	}))
+	client, storage, logger := newTestClient(t, testConfig(t), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	req := httptest.NewRequest(http.MethodGet, "/payments/status?token="+webhookToken, nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	req.Header.Set("X-Request-ID", "req-42")
+	req.RemoteAddr = "203.0.113.7:51234"
+	rec := httptest.NewRecorder()
+	client.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	// The client method called by the handler logs with the request's fields merged in,
+	// keeping its own gateway request ID apart from the caller's
+	entry, ok := logger.find("Making API request")
+	if !ok {
+		t.Fatalf("no gateway request logged:\n%s", logger.dump())
+	}
+	for key, want := range map[string]interface{}{
+		"request_id": "req-42",
+		"route":      "/payments/status",
+		"client_ip":  "203.0.113.7",
+		"method":     http.MethodGet,
+	} {
+		if entry.fields[key] != want {
+			t.Errorf("field %s = %v, want %v", key, entry.fields[key], want)
+		}
+	}
+	if id, _ := entry.fields["gateway_request_id"].(string); id == "" || id == "req-42" || entry.fields["endpoint"] == nil {
+		t.Errorf("call fields lost: %v", entry.fields)
+	}
+	if req, _ := transport.request(0); req.Header.Get("X-Request-ID") != entry.fields["gateway_request_id"] {
+		t.Errorf("gateway X-Request-ID %q, logged %v", req.Header.Get("X-Request-ID"), entry.fields["gateway_request_id"])
+	}
+}
+
+func TestClientLoggerFallback(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+		"status": true, "amount": 100000, "transactionStatus": "PAID",
+	}))
+	client, _, clientLogger := newTestClient(t, testConfig(t), transport)
+
+	// Without a context logger, the client's logger is used
+	if _, err := client.GetPaymentStatus(context.Background(), webhookToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := clientLogger.find("Making API request"); !ok {
+		t.Fatal("client logger not used without a context logger")
+	}
+
+	// A context logger takes over
+	scoped := &captureLogger{}
+	ctx := ContextWithLogger(context.Background(), WithFields(scoped, map[string]interface{}{"job": "reconcile"}))
+	before := len(clientLogger.all())
+	if _, err := client.GetPaymentStatus(ctx, webhookToken); err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := scoped.find("Making API request"); !ok || entry.fields["job"] != "reconcile" {
+		t.Fatalf("context logger entry %+v, %v", entry, ok)
+	}
+	if len(clientLogger.all()) != before {
+		t.Fatal("client logger used despite a context logger")
+	}
+}
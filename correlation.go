@@ -0,0 +1,92 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// correlation.go implements end-to-end correlation IDs, distinct from per-hop request IDs
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// DefaultCorrelationHeader is the header carrying correlation IDs when
+// Config.CorrelationHeader is not set
+const DefaultCorrelationHeader = "X-Correlation-ID"
+
+// headerNameRegex matches valid HTTP header names
+var headerNameRegex = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// correlationIDKey stores the correlation ID in the context
+const correlationIDKey contextKey = "correlation_id"
+
+// ContextWithCorrelationID returns a context carrying a correlation ID
+func ContextWithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, correlationID)
+}
+
+// CorrelationIDFromContext returns the correlation ID of a context, if any
+func CorrelationIDFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	correlationID, ok := ctx.Value(correlationIDKey).(string)
+	return correlationID, ok && correlationID != ""
+}
+
+// correlationHeader returns the header incoming correlation IDs are read from
+func correlationHeader(config ConfigInterface) string {
+	if header := configValues(config).CorrelationHeader; header != "" {
+		return header
+	}
+	return DefaultCorrelationHeader
+}
+
+// outboundCorrelationHeader returns the header correlation IDs are forwarded to
+// Vandar in
+func outboundCorrelationHeader(config ConfigInterface) string {
+	if header := configValues(config).CorrelationOutboundHeader; header != "" {
+		return header
+	}
+	return correlationHeader(config)
+}
+
+// CorrelationMiddleware stores the correlation ID of a request in its context and
+// echoes it on the response. Unlike the request ID it is never replaced: a missing
+// or unsafe ID is only created when Config.GenerateCorrelationID is set.
+func CorrelationMiddleware(config ConfigInterface, generator IDGenerator) Middleware {
+	if generator == nil {
+		generator = defaultIDGenerator
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			header := correlationHeader(config)
+
+			correlationID := r.Header.Get(header)
+			if !isHeaderSafeID(correlationID) {
+				correlationID = ""
+				if configValues(config).GenerateCorrelationID {
+					correlationID = generator.NewRequestID()
+				}
+			}
+
+			if correlationID == "" {
+				next(w, r)
+				return
+			}
+
+			w.Header().Set(header, correlationID)
+			next(w, r.WithContext(ContextWithCorrelationID(r.Context(), correlationID)))
+		}
+	}
+}
+
+// validateCorrelationHeaders checks the configured correlation header names
+func validateCorrelationHeaders(c *Config) error {
+	for _, header := range []string{c.CorrelationHeader, c.CorrelationOutboundHeader} {
+		if header != "" && !headerNameRegex.MatchString(header) {
+			return fmt.Errorf("invalid correlation header name %q", header)
+		}
+	}
+	return nil
+}
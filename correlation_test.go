@@ -0,0 +1,155 @@
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// initWithIDs sends an init request carrying the given ID headers
+func initWithIDs(handler http.Handler, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/payments/init", strings.NewReader(`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCorrelationIDThroughInit(t *testing.T) {
+	var out bytes.Buffer
+	client, err := NewClient(testConfig(t, func(c *Config) {
+		c.CorrelationOutboundHeader = "X-Trace-Id"
+	}), NewMemoryStorage(), NewDefaultLoggerWithOutput("DEBUG", &out, &out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": webhookToken}))
+	client = client.Clone(WithClientHTTPClient(transport))
+
+	rec := initWithIDs(client.Handler(), map[string]string{
+		"X-Request-ID":     "hop-7",
+		"X-Correlation-ID": "order-1042-checkout",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	// Both IDs are echoed, each in its own header
+	requestID := rec.Header().Get("X-Request-ID")
+	if requestID == "" || rec.Header().Get("X-Correlation-ID") != "order-1042-checkout" {
+		t.Fatalf("response headers %v", rec.Header())
+	}
+
+	// The correlation ID is forwarded to the gateway in the configured header
+	gatewayReq, _ := transport.request(0)
+	if gatewayReq.Header.Get("X-Trace-Id") != "order-1042-checkout" {
+		t.Fatalf("gateway request headers %v", gatewayReq.Header)
+	}
+
+	// Every entry of the request carries both IDs
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("only %d log entries", len(lines))
+	}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if entry["correlation_id"] != "order-1042-checkout" || entry["request_id"] != requestID {
+			t.Errorf("entry %q lacks the request or correlation ID", line)
+		}
+	}
+}
+
+func TestCorrelationIDGeneration(t *testing.T) {
+	tests := []struct {
+		name     string
+		generate bool
+		header   string
+		want     string
+	}{
+		{"absent", false, "", ""},
+		{"generated", true, "", "generated"},
+		{"unsafe", false, "bad\x7fid", ""},
+		{"unsafe replaced", true, "bad\x7fid", "generated"},
+		{"kept", true, "order-1042", "order-1042"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": webhookToken}))
+			client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+				c.GenerateCorrelationID = tt.generate
+			}), transport)
+
+			headers := map[string]string{}
+			if tt.header != "" {
+				headers["X-Correlation-ID"] = tt.header
+			}
+			rec := initWithIDs(client.Handler(), headers)
+			echoed := rec.Header().Get("X-Correlation-ID")
+			gatewayReq, _ := transport.request(0)
+			forwarded := gatewayReq.Header.Get("X-Correlation-ID")
+
+			switch tt.want {
+			case "":
+				if echoed != "" || forwarded != "" {
+					t.Fatalf("correlation ID %q echoed, %q forwarded", echoed, forwarded)
+				}
+			case "generated":
+				if echoed == "" || echoed == tt.header || forwarded != echoed || echoed == rec.Header().Get("X-Request-ID") {
+					t.Fatalf("correlation ID %q echoed, %q forwarded", echoed, forwarded)
+				}
+			default:
+				if echoed != tt.want || forwarded != tt.want {
+					t.Fatalf("correlation ID %q echoed, %q forwarded", echoed, forwarded)
+				}
+			}
+		})
+	}
+}
+
+func TestCorrelationIDInAuditRecord(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.AdminKey = "admin-key"
+		c.CorrelationHeader = "X-Org-Correlation"
+	}), nil)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/transactions/"+webhookToken+"/status", strings.NewReader(`{"status":"FAILED","reason":"Cancelled by the payer","actor":"support@shop.example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	req.Header.Set(AdminKeyHeader, "admin-key")
+	req.Header.Set("X-Org-Correlation", "ticket-311")
+	rec := httptest.NewRecorder()
+	client.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Org-Correlation") != "ticket-311" {
+		t.Fatalf("status %d, headers %v: %s", rec.Code, rec.Header(), rec.Body)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if len(transaction.StatusHistory) != 1 || transaction.StatusHistory[0].CorrelationID != "ticket-311" {
+		t.Fatalf("status history %+v", transaction.StatusHistory)
+	}
+}
+
+func TestCorrelationHeaderValidation(t *testing.T) {
+	for _, header := range []string{"X Correlation", "X-Correlation:", "Ünicode"} {
+		config := DefaultConfig()
+		config.APIKey = testAPIKey
+		config.CallbackURL = "https://shop.example.com/payments/callback"
+		config.CorrelationOutboundHeader = header
+		if _, err := NewConfig(config); err == nil {
+			t.Errorf("header %q accepted", header)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// cors.go implements CORS handling for browser-facing payment endpoints
+package vandargo
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig holds the cross-origin policy for browser-facing endpoints
+type CORSConfig struct {
+	// AllowedOrigins lists allowed origins; entries may be exact ("https://shop.example.com"),
+	// wildcard subdomains ("https://*.example.com") or "*" for any origin
+	AllowedOrigins []string
+
+	// AllowedMethods lists methods allowed in cross-origin requests
+	AllowedMethods []string
+
+	// AllowedHeaders lists request headers allowed in cross-origin requests
+	AllowedHeaders []string
+
+	// AllowCredentials allows cookies and authorization headers in cross-origin
+	// requests from the listed origins; origins only matched by "*" are never
+	// allowed credentials
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache preflight results
+	MaxAge time.Duration
+}
+
+// DefaultCORSConfig returns a CORSConfig suitable for the payment endpoints
+func DefaultCORSConfig(allowedOrigins ...string) CORSConfig {
+	return CORSConfig{
+		AllowedOrigins: allowedOrigins,
+		AllowedMethods: []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "X-Request-ID", DefaultCorrelationHeader, ChallengeTokenHeader},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// CORSMiddleware applies a CORS policy. Preflight requests are answered directly so
+// they never reach authentication; disallowed origins receive no CORS headers.
+func CORSMiddleware(config CORSConfig) Middleware {
+	allowedMethods := strings.Join(config.AllowedMethods, ", ")
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			// Not a cross-origin request
+			if origin == "" {
+				next(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+
+			allowOrigin, allowed := config.allowOrigin(origin)
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+
+				// Reflecting any origin with credentials would let every site make
+				// credentialed calls, so a wildcard is sent literally without them
+				if config.AllowCredentials && allowOrigin != "*" {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+
+			if !preflight {
+				next(w, r)
+				return
+			}
+
+			// Answer preflight requests without invoking the handler chain
+			if allowed {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+				w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+
+				if headers := config.allowedRequestHeaders(r.Header.Get("Access-Control-Request-Headers")); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+
+				if config.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+				}
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// allowOrigin checks an origin against the allowed origins and returns the
+// Access-Control-Allow-Origin value: the origin itself when it is listed, "*"
+// when it is only allowed by the "*" entry
+func (config CORSConfig) allowOrigin(origin string) (string, bool) {
+	anyOrigin := false
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" {
+			anyOrigin = true
+			continue
+		}
+
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+
+		// Wildcard subdomain match, e.g. https://*.example.com
+		if scheme, host, ok := strings.Cut(allowed, "://*."); ok {
+			prefix := scheme + "://"
+			suffix := "." + host
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) && len(origin) > len(prefix)+len(suffix) {
+				return origin, true
+			}
+		}
+	}
+
+	if anyOrigin {
+		return "*", true
+	}
+	return "", false
+}
+
+// allowedRequestHeaders filters the requested headers down to the allowed ones
+func (config CORSConfig) allowedRequestHeaders(requested string) string {
+	var allowed []string
+
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+
+		for _, allowedHeader := range config.AllowedHeaders {
+			if strings.EqualFold(header, allowedHeader) {
+				allowed = append(allowed, header)
+				break
+			}
+		}
+	}
+
+	return strings.Join(allowed, ", ")
+}
@@ -0,0 +1,134 @@
+package vandargo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// corsRequest sends a request through CORSMiddleware, reporting whether the handler ran
+func corsRequest(config CORSConfig, method, origin string, header map[string]string) (*httptest.ResponseRecorder, bool) {
+	called := false
+	handler := CORSMiddleware(config)(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(method, "/payments/init", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec, called
+}
+
+func TestCORSSimpleRequests(t *testing.T) {
+	config := DefaultCORSConfig("https://shop.example.com", "https://*.example.org")
+	config.AllowCredentials = true
+
+	tests := []struct {
+		origin      string
+		allowOrigin string
+	}{
+		{"https://shop.example.com", "https://shop.example.com"},
+		{"https://app.example.org", "https://app.example.org"},
+		{"https://example.org", ""},
+		{"http://shop.example.com", ""},
+		{"https://evil.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		rec, called := corsRequest(config, http.MethodPost, tt.origin, nil)
+		if !called {
+			t.Errorf("%s: simple request didn't reach the handler", tt.origin)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.allowOrigin)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Credentials"); (got == "true") != (tt.allowOrigin != "") {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q", tt.origin, got)
+		}
+		if rec.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: Vary = %q, want Origin", tt.origin, rec.Header().Get("Vary"))
+		}
+	}
+
+	// Same-origin requests get no CORS headers
+	rec, called := corsRequest(config, http.MethodPost, "", nil)
+	if !called || rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Vary") != "" {
+		t.Errorf("same-origin request: called %v, headers %v", called, rec.Header())
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	config := DefaultCORSConfig("https://shop.example.com")
+
+	rec, called := corsRequest(config, http.MethodOptions, "https://shop.example.com", map[string]string{
+		"Access-Control-Request-Method":  http.MethodPost,
+		"Access-Control-Request-Headers": "content-type, authorization, x-secret",
+	})
+	if called {
+		t.Fatal("preflight reached the handler")
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", rec.Code)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "https://shop.example.com",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "content-type, authorization",
+		"Access-Control-Max-Age":       "600",
+	}
+	for header, value := range want {
+		if got := rec.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+
+	// A disallowed origin is answered without CORS headers
+	rec, called = corsRequest(config, http.MethodOptions, "https://evil.example.com", map[string]string{
+		"Access-Control-Request-Method": http.MethodPost,
+	})
+	if called || rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("disallowed preflight: called %v, status %d, headers %v", called, rec.Code, rec.Header())
+	}
+}
+
+func TestCORSWildcardNeverAllowsCredentials(t *testing.T) {
+	config := DefaultCORSConfig("*", "https://shop.example.com")
+	config.AllowCredentials = true
+
+	rec, _ := corsRequest(config, http.MethodPost, "https://evil.example.com", nil)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want a literal *", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q for an origin only matched by *", got)
+	}
+
+	// Listed origins keep their credentials
+	rec, _ = corsRequest(config, http.MethodPost, "https://shop.example.com", nil)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://shop.example.com" || rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("listed origin: headers %v", rec.Header())
+	}
+}
+
+func TestCORSPreflightThroughHandler(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), nil)
+	handler := client.Handler(WithCORS(DefaultCORSConfig("https://shop.example.com")))
+
+	req := httptest.NewRequest(http.MethodOptions, "/payments/init", nil)
+	req.Header.Set("Origin", "https://shop.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// Preflight requests carry no credentials and must not be rejected by authentication
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://shop.example.com" {
+		t.Fatalf("preflight: status %d, headers %v", rec.Code, rec.Header())
+	}
+}
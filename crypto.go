@@ -11,6 +11,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -64,7 +65,10 @@ func GenerateNonce() string {
 	return nonce
 }
 
-// HashCardNumber securely hashes a card number using SHA-256
+// HashCardNumber hashes a card number with plain SHA-256, the scheme of the CIDs
+// Vandar returns. Use it only to compare card numbers with Vandar CIDs; the digest
+// of a 16-digit number is easy to reverse, so use HashCardNumberKeyed for hashes
+// you store.
 func HashCardNumber(cardNumber string) string {
 	// Remove spaces and non-digit characters
 	cleanCard := sanitizeCardNumber(cardNumber)
@@ -83,25 +87,12 @@ func MaskCardNumber(cardNumber string) string {
 		return "****"
 	}
 
-	masked := ""
-	for i := 0; i < len(cleanCard)-4; i++ {
-		masked += "*"
-	}
-
-	return masked + cleanCard[len(cleanCard)-4:]
+	return strings.Repeat("*", len(cleanCard)-4) + cleanCard[len(cleanCard)-4:]
 }
 
 // sanitizeCardNumber removes spaces and non-digit characters from a card number
 func sanitizeCardNumber(cardNumber string) string {
-	var clean []rune
-
-	for _, r := range cardNumber {
-		if r >= '0' && r <= '9' {
-			clean = append(clean, r)
-		}
-	}
-
-	return string(clean)
+	return keepDigits(cardNumber)
 }
 
 // VerifyCallbackIP checks if the IP is in the allowed list
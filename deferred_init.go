@@ -0,0 +1,423 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// deferred_init.go implements queueing payment initializations while the gateway is down
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultDeferredInitDeadline is used when Config.DeferredInitDeadline is not set
+	defaultDeferredInitDeadline = 10 * time.Minute
+
+	// defaultDeferredInitRetryInterval is used when Config.DeferredInitRetryInterval is not set
+	defaultDeferredInitRetryInterval = 15 * time.Second
+
+	// queuedTokenPrefix starts the placeholder token of a queued initialization,
+	// followed by the ID of the session the payer polls with
+	queuedTokenPrefix = "queued_"
+
+	// queuedTokenMetadataKey holds the gateway token on the placeholder of a
+	// resolved initialization when the storage can't delete it
+	queuedTokenMetadataKey = "queued_token"
+
+	// QueuedReferenceMetadataKey holds, on the transaction of a queued
+	// initialization that succeeded, the reference the payer was given
+	QueuedReferenceMetadataKey = "queued_reference"
+)
+
+// DeletableStorageInterface is implemented by storages that can delete
+// transactions. Without it the placeholder of a queued initialization is kept
+// after it succeeded, pointing at the transaction that replaced it.
+type DeletableStorageInterface interface {
+	// DeleteTransaction removes a transaction by token
+	DeleteTransaction(ctx context.Context, token string) error
+}
+
+// DeleteTransaction removes a transaction by token
+func (s *MemoryStorage) DeleteTransaction(ctx context.Context, token string) error {
+	if err := s.enter(ctx); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	transaction, exists := s.transactions[token]
+	if !exists {
+		return fmt.Errorf("transaction not found: %s", token)
+	}
+
+	s.reindexCID(token, transaction.CID, "")
+	delete(s.transactions, token)
+
+	return nil
+}
+
+// QueuedInitResponse answers a payment initialization queued because the gateway
+// is unreachable, with HTTP status 202
+type QueuedInitResponse struct {
+	// Status is 0, as no token was issued yet
+	Status int `json:"status"`
+
+	// Queued is always true
+	Queued bool `json:"queued"`
+
+	// SessionID is the reference to pass as ?session= to the status endpoint
+	// until the payment is initialized or fails
+	SessionID string `json:"session_id"`
+
+	// RetryUntil is when the initialization is given up and marked FAILED
+	RetryUntil time.Time `json:"retry_until"`
+
+	// Message describes the queued state
+	Message string `json:"message,omitempty"`
+}
+
+// deferredInitDeadline returns how long a queued initialization is retried
+func (c *Client) deferredInitDeadline() time.Duration {
+	if deadline := configValues(c.config).DeferredInitDeadline; deadline > 0 {
+		return deadline
+	}
+	return defaultDeferredInitDeadline
+}
+
+// deferredInitRetryInterval returns how long to wait between attempts of a
+// queued initialization
+func (c *Client) deferredInitRetryInterval() time.Duration {
+	if interval := configValues(c.config).DeferredInitRetryInterval; interval > 0 {
+		return interval
+	}
+	return defaultDeferredInitRetryInterval
+}
+
+// shouldDeferInit reports whether a failed initialization is queued: DeferredInit
+// is enabled and the gateway seems down rather than refusing the payment
+func (c *Client) shouldDeferInit(ctx context.Context, statusCode int, err error) bool {
+	return configValues(c.config).DeferredInit && c.provider == nil && isRetryable(ctx, statusCode, err)
+}
+
+// queuedSessionID returns the session ID in the placeholder token of a queued initialization
+func queuedSessionID(token string) string {
+	return strings.TrimPrefix(token, queuedTokenPrefix)
+}
+
+// queueInit stores a QUEUED placeholder for a payment the gateway failed to
+// initialize, retries it in the background and answers with the reference to
+// poll. When nothing can be stored the original failure is answered.
+func (c *Client) queueInit(w http.ResponseWriter, r *http.Request, req *PaymentInitRequest, cause error) {
+	ctx := r.Context()
+
+	placeholder, err := c.storeQueuedInit(ctx, req)
+	if err != nil {
+		c.respondWithError(w, upstreamError(cause), "Failed to initialize payment")
+		c.log(ctx).Error(ctx, "Failed to queue payment initialization", err, c.paymentInitLogFields(req))
+		return
+	}
+
+	fields := transactionLogFields(placeholder)
+	fields["error"] = cause.Error()
+	c.log(ctx).Warn(ctx, "Gateway unreachable, payment initialization queued", fields)
+
+	// The retries are their own operations and outlive the request
+	retryCtx := context.WithValue(context.WithoutCancel(ctx), inflightKey, nil)
+	reqCopy := *req
+	untrack := c.health.trackRetry()
+	go func() {
+		defer untrack()
+		c.runQueuedInit(retryCtx, placeholder.Token, &reqCopy)
+	}()
+
+	c.respondQueuedInit(w, placeholder)
+}
+
+// storeQueuedInit stores the placeholder of a queued initialization and the
+// session the payer polls it with
+func (c *Client) storeQueuedInit(ctx context.Context, req *PaymentInitRequest) (*Transaction, error) {
+	sessionID, err := GenerateRandomString(sessionIDLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	now := c.clock.Now()
+	deadline := now.Add(c.deferredInitDeadline())
+
+	placeholder := &Transaction{
+		ID:           c.idGenerator().NewTransactionID(),
+		Token:        queuedTokenPrefix + sessionID,
+		Amount:       req.Amount,
+		Status:       StatusQueued,
+		Type:         TransactionTypePayment,
+		Description:  req.Description,
+		FactorNumber: req.FactorNumber,
+		CallbackURL:  req.CallbackURL,
+		CardHash:     c.cardHash(req.ValidCardNumber),
+		Metadata:     req.Metadata,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		ExpiresAt:    &deadline,
+	}
+	applyClientInfo(placeholder, req.ClientInfo)
+
+	if err := c.storage.StoreTransaction(ctx, placeholder); err != nil {
+		return nil, fmt.Errorf("failed to store queued transaction: %w", err)
+	}
+
+	// The session outlives the deadline so the payer can read a failure
+	session := &PaymentSession{
+		ID:        sessionID,
+		Token:     placeholder.Token,
+		CreatedAt: now,
+		ExpiresAt: deadline.Add(sessionCompletedGrace),
+	}
+	if err := c.sessionStorage().StoreSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+
+	return placeholder, nil
+}
+
+// respondQueuedInit answers with the reference of a queued initialization
+func (c *Client) respondQueuedInit(w http.ResponseWriter, placeholder *Transaction) {
+	resp := QueuedInitResponse{
+		Queued:    true,
+		SessionID: queuedSessionID(placeholder.Token),
+		Message:   "payment gateway is unreachable, the payment will be initialized when it recovers",
+	}
+	if placeholder.ExpiresAt != nil {
+		resp.RetryUntil = *placeholder.ExpiresAt
+	}
+
+	c.respondWithJSON(w, http.StatusAccepted, resp)
+}
+
+// runQueuedInit retries a queued initialization every DeferredInitRetryInterval
+// until it succeeds, the gateway refuses it or its deadline passes
+func (c *Client) runQueuedInit(ctx context.Context, token string, req *PaymentInitRequest) {
+	interval := c.deferredInitRetryInterval()
+
+	var lastErr error
+	for {
+		<-c.clock.After(interval)
+
+		var finished bool
+		finished, lastErr = c.attemptQueuedInit(ctx, token, req, lastErr)
+		if finished {
+			return
+		}
+	}
+}
+
+// attemptQueuedInit makes one attempt of a queued initialization. It returns true
+// once the placeholder is resolved or failed, or the error to retry after. An
+// attempt due after shutdown gives up; the deadline fails the placeholder later.
+func (c *Client) attemptQueuedInit(ctx context.Context, token string, req *PaymentInitRequest, lastErr error) (bool, error) {
+	ctx, done, err := c.beginOperation(ctx)
+	if err != nil {
+		c.log(ctx).Warn(ctx, "Queued payment initialization abandoned", map[string]interface{}{
+			"token": redactToken(token),
+			"error": err.Error(),
+		})
+		return true, nil
+	}
+	defer done()
+
+	placeholder, err := c.storage.GetTransaction(ctx, token)
+	if err != nil || !isPendingQueuedInit(placeholder) {
+		return true, nil
+	}
+
+	if c.queuedInitOverdue(placeholder) {
+		if lastErr == nil {
+			lastErr = ErrGatewayUnavailable
+		}
+		c.failQueuedInit(ctx, placeholder, fmt.Errorf("not initialized before the deadline: %w", lastErr))
+		return true, nil
+	}
+
+	apiCtx, cancel := c.operationContext(ctx, operationInit)
+	defer cancel()
+
+	respBody, statusCode, err := c.makeRequest(apiCtx, http.MethodPost, c.endpoints().Send, paymentInitBody(req))
+	if err != nil {
+		if isRetryable(ctx, statusCode, err) {
+			return false, err
+		}
+		c.failQueuedInit(ctx, placeholder, err)
+		return true, nil
+	}
+
+	var apiResp PaymentInitResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		c.failQueuedInit(ctx, placeholder, fmt.Errorf("failed to parse API response: %w", err))
+		return true, nil
+	}
+	if apiResp.Status != 1 {
+		c.failQueuedInit(ctx, placeholder, fmt.Errorf("%w: %s", ErrPaymentFailed, apiResp.Message))
+		return true, nil
+	}
+
+	c.resolveQueuedInit(ctx, placeholder, req, &apiResp)
+	return true, nil
+}
+
+// isPendingQueuedInit reports whether a placeholder still waits for its initialization
+func isPendingQueuedInit(placeholder *Transaction) bool {
+	return placeholder.Status == StatusQueued && placeholder.Metadata[queuedTokenMetadataKey] == ""
+}
+
+// queuedInitOverdue reports whether a queued initialization's deadline passed
+func (c *Client) queuedInitOverdue(placeholder *Transaction) bool {
+	return placeholder.ExpiresAt != nil && !c.clock.Now().Before(*placeholder.ExpiresAt)
+}
+
+// resolveQueuedInit records the transaction of a queued initialization that
+// succeeded, points the payer's session at it and removes the placeholder
+func (c *Client) resolveQueuedInit(ctx context.Context, placeholder *Transaction, req *PaymentInitRequest, apiResp *PaymentInitResponse) {
+	release := c.tokenLocks.Lock(placeholder.Token)
+	defer release()
+
+	sessionID := queuedSessionID(placeholder.Token)
+	metadata := mergeMetadata(req.Metadata, map[string]string{QueuedReferenceMetadataKey: sessionID})
+	transaction := c.recordPaymentInit(ctx, req, apiResp, metadata)
+
+	// Failed by the deadline while the gateway answered; the token expires unused
+	current, err := c.storage.GetTransaction(ctx, placeholder.Token)
+	if err != nil || !isPendingQueuedInit(current) {
+		c.log(ctx).Warn(ctx, "Queued payment initialized after it was given up", transactionLogFields(transaction))
+		return
+	}
+
+	session := &PaymentSession{
+		ID:        sessionID,
+		Token:     transaction.Token,
+		CreatedAt: current.CreatedAt,
+		ExpiresAt: *transaction.ExpiresAt,
+	}
+	if err := c.sessionStorage().StoreSession(ctx, session); err != nil {
+		c.log(ctx).Error(ctx, "Failed to update payment session", err, transactionLogFields(transaction))
+	}
+
+	if deletable, ok := c.storage.(DeletableStorageInterface); ok {
+		err = deletable.DeleteTransaction(ctx, current.Token)
+	} else {
+		err = c.patchTransaction(ctx, current.Token, TransactionPatch{
+			Metadata: map[string]string{queuedTokenMetadataKey: transaction.Token},
+		})
+	}
+	if err != nil {
+		c.log(ctx).Error(ctx, "Failed to remove queued transaction", err, transactionLogFields(current))
+	}
+
+	c.log(ctx).Info(ctx, "Queued payment initialization succeeded", transactionLogFields(transaction))
+}
+
+// failQueuedInit marks a queued initialization FAILED, unless it was resolved or
+// failed meanwhile
+func (c *Client) failQueuedInit(ctx context.Context, placeholder *Transaction, cause error) {
+	release := c.tokenLocks.Lock(placeholder.Token)
+	defer release()
+
+	current, err := c.storage.GetTransaction(ctx, placeholder.Token)
+	if err != nil || !isPendingQueuedInit(current) {
+		return
+	}
+
+	status := StatusFailed
+	completedAt := c.clock.Now()
+	patch := TransactionPatch{Status: &status, CompletedAt: &completedAt}
+	c.applyPatch(&patch, current)
+
+	if err := c.patchTransaction(ctx, current.Token, patch); err != nil {
+		c.log(ctx).Error(ctx, "Failed to update transaction", err, transactionLogFields(current))
+		return
+	}
+
+	c.log(ctx).Error(ctx, "Queued payment initialization failed", cause, transactionLogFields(current))
+	c.fireStatusChange(ctx, current, StatusQueued)
+}
+
+// queuedStatus answers a status check of a queued initialization locally, or
+// with the status of the payment it was resolved to
+func (c *Client) queuedStatus(ctx context.Context, token string) (*PaymentStatusResponse, error) {
+	placeholder, err := c.storage.GetTransaction(ctx, token)
+	if err != nil {
+		// A resolved placeholder is deleted, its session leads to the payment
+		resolved, sessionErr := c.ResolveSession(ctx, queuedSessionID(token))
+		if sessionErr != nil || strings.HasPrefix(resolved, queuedTokenPrefix) {
+			return nil, fmt.Errorf("%w: queued payment not found", ErrNotFound)
+		}
+		return c.resolvedQueuedStatus(ctx, resolved)
+	}
+	if resolved := placeholder.Metadata[queuedTokenMetadataKey]; resolved != "" {
+		return c.resolvedQueuedStatus(ctx, resolved)
+	}
+
+	// The worker may be gone, e.g. after a restart
+	if isPendingQueuedInit(placeholder) && c.queuedInitOverdue(placeholder) {
+		c.failQueuedInit(ctx, placeholder, fmt.Errorf("not initialized before the deadline: %w", ErrGatewayUnavailable))
+		if current, err := c.storage.GetTransaction(ctx, token); err == nil {
+			placeholder = current
+		}
+	}
+
+	resp := &PaymentStatusResponse{
+		Status:            true,
+		Amount:            placeholder.Amount,
+		TransactionStatus: string(placeholder.Status),
+		Message:           "payment initialization is queued until the gateway is reachable",
+	}
+	if placeholder.Status != StatusQueued {
+		resp.Message = "payment could not be initialized"
+	}
+	return resp, nil
+}
+
+// resolvedQueuedStatus returns the status of the payment a queued initialization
+// was resolved to, with the token and payment page the payer continues with
+func (c *Client) resolvedQueuedStatus(ctx context.Context, token string) (*PaymentStatusResponse, error) {
+	resp, err := c.GetPaymentStatus(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Token = token
+	resp.PaymentURL = c.PaymentURL(token)
+	return resp, nil
+}
+
+// FailOverdueQueuedInits marks queued initializations whose deadline passed as
+// FAILED and returns how many were changed. Their retries stop at shutdown, so
+// run it periodically when DeferredInit is enabled.
+func (c *Client) FailOverdueQueuedInits(ctx context.Context) (int, error) {
+	transactions, err := c.storage.GetTransactionsByStatus(ctx, string(StatusQueued))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list queued transactions: %w", err)
+	}
+
+	failed := 0
+	for _, transaction := range transactions {
+		if err := ctx.Err(); err != nil {
+			return failed, err
+		}
+		if !isPendingQueuedInit(transaction) || !c.queuedInitOverdue(transaction) {
+			continue
+		}
+
+		c.failQueuedInit(ctx, transaction, fmt.Errorf("not initialized before the deadline: %w", ErrGatewayUnavailable))
+		if current, err := c.storage.GetTransaction(ctx, transaction.Token); err == nil && current.Status == StatusFailed {
+			failed++
+		}
+	}
+
+	return failed, nil
+}
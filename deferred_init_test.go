@@ -0,0 +1,188 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// queueingGateway answers initializations with 503 until it is brought up, and
+// status checks of the token it issues
+func queueingGateway() (transportFunc, *atomic.Bool) {
+	var up atomic.Bool
+	return func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, "/send") {
+			if !up.Load() {
+				return stubResponse(req, http.StatusServiceUnavailable, map[string]interface{}{"status": 0, "message": "maintenance"}), nil
+			}
+			return stubResponse(req, http.StatusOK, map[string]interface{}{"status": 1, "token": "gateway-token"}), nil
+		}
+		return stubResponse(req, http.StatusOK, map[string]interface{}{"status": true, "amount": 100000, "transactionStatus": "INIT"}), nil
+	}, &up
+}
+
+// queueInitRequest initializes a payment the gateway fails and returns the queued response
+func queueInitRequest(t *testing.T, client *Client) *QueuedInitResponse {
+	t.Helper()
+
+	rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/init",
+		`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp QueuedInitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Queued || resp.SessionID == "" {
+		t.Fatalf("queued response %+v", resp)
+	}
+	return &resp
+}
+
+// waitForWaiters waits until the fake clock has a pending timer
+func waitForWaiters(t *testing.T, clock *FakeClock) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no retry scheduled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func deferredInitClient(t *testing.T, transport HTTPClientInterface) (*Client, *MemoryStorage, *FakeClock) {
+	t.Helper()
+
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.MaxRetries = 0
+		c.DeferredInit = true
+		c.DeferredInitDeadline = time.Minute
+		c.DeferredInitRetryInterval = 10 * time.Second
+	}), transport, WithClientClock(clock))
+	return client, storage, clock
+}
+
+func TestDeferredInitQueuesAndResolves(t *testing.T) {
+	gateway, up := queueingGateway()
+	client, storage, clock := deferredInitClient(t, gateway)
+
+	queued := queueInitRequest(t, client)
+	if !queued.RetryUntil.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("retry until %v", queued.RetryUntil)
+	}
+
+	// While queued the status is answered locally
+	token := queuedTokenPrefix + queued.SessionID
+	status, err := client.GetPaymentStatus(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.TransactionStatus != string(StatusQueued) || status.Token != "" || status.PaymentURL != "" {
+		t.Fatalf("queued status %+v", status)
+	}
+
+	// The next retry after the gateway recovers initializes the payment
+	up.Store(true)
+	waitForWaiters(t, clock)
+	clock.Advance(10 * time.Second)
+	waitForStatus(t, storage, "gateway-token", StatusInit)
+
+	transaction, _ := storage.GetTransaction(context.Background(), "gateway-token")
+	if transaction.Metadata[QueuedReferenceMetadataKey] != queued.SessionID {
+		t.Fatalf("metadata %v", transaction.Metadata)
+	}
+
+	// The payer polling the reference gets the token and payment page to continue with
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err = client.GetPaymentStatus(context.Background(), token)
+		if err == nil && status.Token != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status %+v, %v", status, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if status.Token != "gateway-token" || status.PaymentURL != client.PaymentURL("gateway-token") {
+		t.Fatalf("resolved status %+v", status)
+	}
+	if status.TransactionStatus != string(StatusInit) {
+		t.Fatalf("resolved transaction status %q", status.TransactionStatus)
+	}
+}
+
+func TestDeferredInitRefusedIsNotQueued(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusUnprocessableEntity, map[string]interface{}{"status": 0, "message": "invalid amount"}))
+	client, storage, _ := deferredInitClient(t, transport)
+
+	rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/init",
+		`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+	if rec.Code == http.StatusAccepted {
+		t.Fatalf("refused initialization queued: %s", rec.Body)
+	}
+
+	queued, _ := storage.GetTransactionsByStatus(context.Background(), string(StatusQueued))
+	if len(queued) != 0 {
+		t.Fatalf("%d queued transactions", len(queued))
+	}
+}
+
+func TestFailOverdueQueuedInits(t *testing.T) {
+	client, storage, clock := deferredInitClient(t, newStubTransport(stubStep{status: http.StatusServiceUnavailable}))
+
+	// A placeholder left without its retries, e.g. after a restart
+	deadline := clock.Now().Add(time.Minute)
+	err := storage.StoreTransaction(context.Background(), &Transaction{
+		ID:        "tx-queued",
+		Token:     queuedTokenPrefix + "session-1",
+		Amount:    100000,
+		Status:    StatusQueued,
+		CreatedAt: clock.Now(),
+		ExpiresAt: &deadline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Before the deadline nothing is failed
+	clock.Advance(time.Minute - time.Second)
+	failed, err := client.FailOverdueQueuedInits(context.Background())
+	if err != nil || failed != 0 {
+		t.Fatalf("failed %d before the deadline: %v", failed, err)
+	}
+
+	clock.Advance(time.Second)
+	failed, err = client.FailOverdueQueuedInits(context.Background())
+	if err != nil || failed != 1 {
+		t.Fatalf("failed %d at the deadline: %v", failed, err)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), queuedTokenPrefix+"session-1")
+	if transaction.Status != StatusFailed || transaction.CompletedAt == nil || !transaction.CompletedAt.Equal(clock.Now()) {
+		t.Fatalf("overdue transaction %+v", transaction)
+	}
+
+	status, err := client.GetPaymentStatus(context.Background(), queuedTokenPrefix+"session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.TransactionStatus != string(StatusFailed) || status.Token != "" {
+		t.Fatalf("failed status %+v", status)
+	}
+
+	// Already failed initializations are not counted again
+	failed, err = client.FailOverdueQueuedInits(context.Background())
+	if err != nil || failed != 0 {
+		t.Fatalf("failed %d again: %v", failed, err)
+	}
+}
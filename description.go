@@ -0,0 +1,101 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// description.go implements default payment descriptions rendered from metadata
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// parseDescriptionTemplate parses a DefaultDescriptionTemplate. Metadata keys the
+// payment lacks fail rendering instead of printing "<no value>".
+func parseDescriptionTemplate(text string) (*template.Template, error) {
+	return template.New("description").Option("missingkey=error").Parse(text)
+}
+
+// renderDescription renders a description template against payment metadata
+func renderDescription(text string, metadata map[string]string) (string, error) {
+	tmpl, err := parseDescriptionTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	var description strings.Builder
+	if err := tmpl.Execute(&description, metadata); err != nil {
+		return "", err
+	}
+	return description.String(), nil
+}
+
+// defaultDescription returns the description of a payment initialized without
+// one: DefaultDescriptionTemplate rendered against its metadata, or
+// DefaultDescription when no template is set or rendering fails. Descriptions
+// longer than MaxDescriptionLength are truncated with a warning.
+func (c *Client) defaultDescription(ctx context.Context, metadata map[string]string) string {
+	values := configValues(c.config)
+
+	description := values.DefaultDescription
+	if values.DefaultDescriptionTemplate != "" {
+		rendered, err := renderDescription(values.DefaultDescriptionTemplate, metadata)
+		if err != nil {
+			c.log(ctx).Warn(ctx, "Failed to render default description, using the plain default", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			description = rendered
+		}
+	}
+
+	// Metadata may come from the payer's request
+	description = SanitizeInput(description)
+	if len(description) > MaxDescriptionLength {
+		c.log(ctx).Warn(ctx, "Default description too long, truncating it", map[string]interface{}{
+			"length":     len(description),
+			"max_length": MaxDescriptionLength,
+		})
+		description = truncateUTF8(description, MaxDescriptionLength)
+	}
+
+	return description
+}
+
+// truncateUTF8 cuts a string to at most max bytes without splitting a character
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// validateDescriptionTemplate checks that a DefaultDescriptionTemplate parses
+func validateDescriptionTemplate(text string) error {
+	if text == "" {
+		return nil
+	}
+	if _, err := parseDescriptionTemplate(text); err != nil {
+		return fmt.Errorf("invalid default description template: %w", err)
+	}
+	return nil
+}
+
+// mergeMetadata returns the union of two metadata maps, extra winning, or nil
+// when both are empty
+func mergeMetadata(base, extra map[string]string) map[string]string {
+	if len(base) == 0 {
+		return extra
+	}
+	merged := make(map[string]string, len(base)+len(extra))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range extra {
+		merged[key] = value
+	}
+	return merged
+}
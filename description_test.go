@@ -0,0 +1,101 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const renewalTemplate = "Subscription renewal {{.plan}} for {{.customer_id}}"
+
+func TestDefaultDescription(t *testing.T) {
+	long := strings.Repeat("تمدید اشتراک ", 40)
+
+	tests := []struct {
+		name        string
+		template    string
+		description string
+		metadata    map[string]string
+		want        string
+		warning     string
+	}{
+		{"rendered", renewalTemplate, "", map[string]string{"plan": "gold", "customer_id": "c-42"}, "Subscription renewal gold for c-42", ""},
+		{"explicit description", renewalTemplate, "Order 1042", map[string]string{"plan": "gold", "customer_id": "c-42"}, "Order 1042", ""},
+		{"missing key", renewalTemplate, "", map[string]string{"plan": "gold"}, "Payment", "Failed to render default description"},
+		{"no metadata", renewalTemplate, "", nil, "Payment", "Failed to render default description"},
+		{"no template", "", "", map[string]string{"plan": "gold"}, "Payment", ""},
+		{"control characters", renewalTemplate, "", map[string]string{"plan": "gold\r\n", "customer_id": "c-42\x00"}, "Subscription renewal gold for c-42", ""},
+		{"too long", "{{.note}}", "", map[string]string{"note": long}, truncateUTF8(strings.TrimSpace(long), MaxDescriptionLength), "Default description too long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": webhookToken}))
+			client, _, logger := newTestClient(t, testConfig(t, func(c *Config) {
+				c.DefaultDescription = "Payment"
+				c.DefaultDescriptionTemplate = tt.template
+			}), transport)
+
+			if _, err := client.InitiatePayment(context.Background(), 100000, tt.description, tt.metadata); err != nil {
+				t.Fatal(err)
+			}
+
+			var sent struct {
+				Description string `json:"description"`
+			}
+			_, body := transport.request(0)
+			json.Unmarshal(body, &sent)
+			if sent.Description != tt.want {
+				t.Fatalf("description %q, want %q", sent.Description, tt.want)
+			}
+			if len(sent.Description) > MaxDescriptionLength || !utf8.ValidString(sent.Description) {
+				t.Fatalf("description of %d bytes, valid UTF-8 %v", len(sent.Description), utf8.ValidString(sent.Description))
+			}
+
+			_, warned := logger.find(tt.warning)
+			if tt.warning != "" && !warned {
+				t.Fatalf("no %q warning:\n%s", tt.warning, logger.dump())
+			}
+			if tt.warning == "" && len(logger.at("warn")) > 0 {
+				t.Fatalf("unexpected warning:\n%s", logger.dump())
+			}
+		})
+	}
+}
+
+func TestDefaultDescriptionFromHandler(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": webhookToken}))
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.DefaultDescriptionTemplate = renewalTemplate
+	}), transport)
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/init", strings.NewReader(`{"amount":100000,"callback_url":"https://shop.example.com/callback","metadata":{"plan":"gold","customer_id":"c-42"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	rec := httptest.NewRecorder()
+	client.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	_, body := transport.request(0)
+	if !strings.Contains(string(body), `"description":"Subscription renewal gold for c-42"`) {
+		t.Fatalf("gateway request %s", body)
+	}
+}
+
+func TestDescriptionTemplateValidation(t *testing.T) {
+	for _, text := range []string{"{{.plan", "{{.plan | shout}}", "{{end}}"} {
+		config := DefaultConfig()
+		config.APIKey = testAPIKey
+		config.CallbackURL = "https://shop.example.com/payments/callback"
+		config.DefaultDescriptionTemplate = text
+		if _, err := NewConfig(config); err == nil || !strings.Contains(err.Error(), "description template") {
+			t.Errorf("template %q: %v", text, err)
+		}
+	}
+}
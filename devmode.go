@@ -0,0 +1,150 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// devmode.go implements answering from the simulator in development without sandbox credentials
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+)
+
+// SimulatedHeader is set to "true" on every route response of a client answering
+// from the simulator in dev mode; JSON objects also get "simulated": true
+const SimulatedHeader = "X-Vandar-Simulated"
+
+// placeholderAPIKeys are API keys copied from examples rather than issued by Vandar
+var placeholderAPIKeys = map[string]bool{
+	"your-api-key":      true,
+	"your_api_key":      true,
+	"your-vandar-key":   true,
+	"vandar_api_key":    true,
+	"api-key":           true,
+	"api_key":           true,
+	"apikey":            true,
+	"changeme":          true,
+	"change-me":         true,
+	"dummy":             true,
+	"fake":              true,
+	"placeholder":       true,
+	"test":              true,
+	"todo":              true,
+	"replace-me":        true,
+	"insert-key-here":   true,
+	"your-api-key-here": true,
+}
+
+// looksLikePlaceholderKey reports whether an API key is missing or clearly not
+// issued by Vandar, such as "your-api-key", "<api key>" or "xxxx"
+func looksLikePlaceholderKey(key string) bool {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if key == "" || placeholderAPIKeys[key] {
+		return true
+	}
+	if strings.HasPrefix(key, "<") && strings.HasSuffix(key, ">") {
+		return true
+	}
+
+	// One repeated character, e.g. "xxxxxxxx" or "00000000"
+	return strings.Count(key, key[:1]) == len(key)
+}
+
+// devModeSimulated reports whether a configuration gets the simulator: dev mode
+// in sandbox mode with a missing or placeholder API key
+func devModeSimulated(config *Config) bool {
+	return config.DevMode && config.SandboxMode && looksLikePlaceholderKey(config.APIKey)
+}
+
+// useDevSimulator swaps in the simulator for the gateway, paying payments
+// immediately so the init, callback and verify loop works locally
+func (c *Client) useDevSimulator() {
+	c.httpClient = NewSimulatorTransport(WithSimulatorPaidAfter(0), WithSimulatorEndpoints(c.endpoints()))
+	c.simulated = true
+
+	ctx := context.Background()
+	c.log(ctx).Warn(ctx, "DEV MODE: no Vandar API key is configured, so payments are answered by the in-process simulator and no real money moves. Set a sandbox API key to use the Vandar sandbox.", map[string]interface{}{
+		"base_url": c.config.GetBaseURL(),
+	})
+}
+
+// Simulated reports whether the client answers from the simulator because dev
+// mode is on without an API key
+func (c *Client) Simulated() bool {
+	return c.simulated
+}
+
+// simulatedMiddleware marks responses as simulated with SimulatedHeader and, for
+// uncompressed JSON objects, a "simulated": true field
+func simulatedMiddleware() Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(SimulatedHeader, "true")
+
+			recorder := &simulatedWriter{ResponseWriter: w}
+			next(recorder, r)
+			recorder.finish()
+		}
+	}
+}
+
+// simulatedWriter holds a response back until it is stamped
+type simulatedWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+// WriteHeader records the status code
+func (w *simulatedWriter) WriteHeader(code int) {
+	if w.statusCode == 0 {
+		w.statusCode = code
+	}
+}
+
+// Write buffers the body
+func (w *simulatedWriter) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *simulatedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the stamped response
+func (w *simulatedWriter) finish() {
+	body := w.body.Bytes()
+	header := w.Header()
+	if strings.HasPrefix(header.Get("Content-Type"), "application/json") && header.Get("Content-Encoding") == "" {
+		body = stampSimulated(body)
+		header.Del("Content-Length")
+	}
+
+	if w.statusCode != 0 {
+		w.ResponseWriter.WriteHeader(w.statusCode)
+	}
+	if len(body) > 0 {
+		w.ResponseWriter.Write(body)
+	}
+}
+
+// stampSimulated adds "simulated": true to a JSON object; other bodies are returned as is
+func stampSimulated(body []byte) []byte {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return body
+	}
+
+	field := `"simulated":true,`
+	if rest := bytes.TrimLeft(trimmed[1:], " \t\r\n"); len(rest) > 0 && rest[0] == '}' {
+		field = `"simulated":true`
+	}
+
+	stamped := make([]byte, 0, len(body)+len(field))
+	stamped = append(stamped, body[:len(body)-len(trimmed)+1]...)
+	stamped = append(stamped, field...)
+	return append(stamped, trimmed[1:]...)
+}
@@ -0,0 +1,158 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// devConfig returns a dev mode configuration accepting testAPIKey on the routes
+func devConfig(key string, sandbox, dev bool) Config {
+	config := DefaultConfig()
+	config.APIKey = key
+	config.BaseURL = SandboxBaseURL
+	config.CallbackURL = "https://shop.example.com/payments/callback"
+	config.SandboxMode = sandbox
+	config.DevMode = dev
+	config.ServerAPIKeys = []ServerKey{{Key: testAPIKey, Scopes: []Scope{ScopeAdmin}}}
+	return config
+}
+
+func TestDevModeSwap(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		dev       bool
+		simulated bool
+	}{
+		{"missing key", "", true, true},
+		{"placeholder key", "your-api-key", true, true},
+		{"bracketed placeholder", "<API KEY>", true, true},
+		{"repeated character", "xxxxxxxx", true, true},
+		{"padded placeholder", "  ChangeMe ", true, true},
+		{"real key", "sandbox-key-4f1c9a", true, false},
+		{"key starting like a placeholder", "your-api-key-4f1c9a", true, false},
+		{"dev mode off", "your-api-key", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := devConfig(tt.key, true, tt.dev)
+			values, err := NewConfig(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger := &captureLogger{}
+			client, err := NewClient(values, NewMemoryStorage(), logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if client.Simulated() != tt.simulated {
+				t.Fatalf("Simulated() = %v, want %v", client.Simulated(), tt.simulated)
+			}
+			if _, isSimulator := client.httpClient.(*SimulatorTransport); isSimulator != tt.simulated {
+				t.Fatalf("gateway %T", client.httpClient)
+			}
+			if entry, found := logger.find("DEV MODE"); found != tt.simulated || (found && entry.level != "warn") {
+				t.Fatalf("startup warning logged %v:\n%s", found, logger.dump())
+			}
+		})
+	}
+}
+
+func TestDevModeKeepsExplicitGateway(t *testing.T) {
+	values, err := NewConfig(devConfig("", true, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A custom ConfigInterface doesn't expose DevMode
+	custom := &ConfigWrapper{Config: devConfig("", true, false)}
+	if client, err := NewClient(custom, NewMemoryStorage(), &captureLogger{}); err != nil || client.Simulated() {
+		t.Fatalf("custom config: %v, simulated %v", err, client != nil && client.Simulated())
+	}
+
+	// Choosing a gateway ends the simulation
+	client, err := NewClient(values, NewMemoryStorage(), &captureLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Clone(WithClientHTTPClient(newStubTransport())).Simulated() || !client.Simulated() {
+		t.Fatal("explicit gateway still simulated")
+	}
+}
+
+func TestDevModeValidation(t *testing.T) {
+	if err := (&Config{}).Validate(); err == nil {
+		t.Fatal("a missing key passes validation outside dev mode")
+	}
+	config := devConfig("", false, true)
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "sandbox") {
+		t.Fatalf("dev mode without sandbox mode: %v", err)
+	}
+	config = devConfig("your-api-key", false, true)
+	if _, err := NewConfig(config); err == nil {
+		t.Fatal("NewConfig accepts dev mode without sandbox mode")
+	}
+}
+
+func TestDevModeLoop(t *testing.T) {
+	values, err := NewConfig(devConfig("", true, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := NewMemoryStorage()
+	client, err := NewClient(values, storage, &captureLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Handler()
+
+	rec := routeRequestBody(handler, http.MethodPost, "/payments/init", `{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+	var initiated struct {
+		Token     string `json:"token"`
+		Simulated bool   `json:"simulated"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &initiated)
+	if rec.Code != http.StatusOK || initiated.Token == "" || !initiated.Simulated || rec.Header().Get(SimulatedHeader) != "true" {
+		t.Fatalf("init: status %d, headers %v: %s", rec.Code, rec.Header(), rec.Body)
+	}
+
+	rec = routeRequestBody(handler, http.MethodPost, "/payments/verify", `{"token":"`+initiated.Token+`"}`)
+	var verified map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &verified)
+	if rec.Code != http.StatusOK || verified["simulated"] != true {
+		t.Fatalf("verify: status %d: %s", rec.Code, rec.Body)
+	}
+	if transaction, _ := storage.GetTransaction(context.Background(), initiated.Token); transaction.Status != StatusPaid {
+		t.Fatalf("transaction %s after the loop", transaction.Status)
+	}
+
+	// Error responses are stamped too
+	rec = routeRequestBody(handler, http.MethodPost, "/payments/verify", `{"token":"unknown"}`)
+	if rec.Code == http.StatusOK || !strings.Contains(rec.Body.String(), `"simulated":true`) {
+		t.Fatalf("error: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestStampSimulated(t *testing.T) {
+	tests := []struct{ body, want string }{
+		{`{"status":true}`, `{"simulated":true,"status":true}`},
+		{`{}`, `{"simulated":true}`},
+		{"  { }\n", "  {\"simulated\":true }\n"},
+		{`[1,2]`, `[1,2]`},
+		{`"text"`, `"text"`},
+		{``, ``},
+	}
+	for _, tt := range tests {
+		if got := string(stampSimulated([]byte(tt.body))); got != tt.want {
+			t.Errorf("stampSimulated(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+		if json.Valid([]byte(tt.body)) && !json.Valid(stampSimulated([]byte(tt.body))) {
+			t.Errorf("stampSimulated(%q) is not JSON", tt.body)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// duplicate.go implements duplicate payment suppression by factor number
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DuplicateFactorMode selects how a repeated factor number is handled
+type DuplicateFactorMode string
+
+const (
+	// DuplicateFactorStrict rejects the new payment with ErrDuplicatePayment (the default)
+	DuplicateFactorStrict DuplicateFactorMode = "strict"
+
+	// DuplicateFactorReuse returns the token of the payment already in progress
+	DuplicateFactorReuse DuplicateFactorMode = "reuse"
+)
+
+// defaultDuplicateFactorWindow is how long an unpaid transaction blocks its factor number
+const defaultDuplicateFactorWindow = 30 * time.Minute
+
+// FactorNumberStorageInterface is implemented by storages that can look up
+// transactions by factor number; other storages are scanned by status
+type FactorNumberStorageInterface interface {
+	// GetTransactionsByFactorNumber retrieves the transactions with a factor number
+	GetTransactionsByFactorNumber(ctx context.Context, factorNumber string) ([]*Transaction, error)
+}
+
+// GetTransactionsByFactorNumber retrieves the transactions with a factor number
+func (s *MemoryStorage) GetTransactionsByFactorNumber(ctx context.Context, factorNumber string) ([]*Transaction, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	var result []*Transaction
+
+	for _, transaction := range s.transactions {
+		if transaction.FactorNumber == factorNumber {
+			// Create a copy to prevent external modifications
+			transactionCopy := *transaction
+			result = append(result, &transactionCopy)
+		}
+	}
+
+	return result, nil
+}
+
+// reserveFactorNumber serializes payment initialization per factor number when
+// duplicate suppression is enabled. It returns the transaction already in progress
+// for the factor number in reuse mode, or ErrDuplicatePayment in strict mode. The
+// returned release function must be called once the new transaction is stored.
+func (c *Client) reserveFactorNumber(ctx context.Context, factorNumber string) (*Transaction, func(), error) {
+	values := configValues(c.config)
+	if !values.RejectDuplicateFactorNumbers || factorNumber == "" {
+		return nil, func() {}, nil
+	}
+
+	release := c.factorLocks.Lock(factorNumber)
+
+	existing, err := c.pendingByFactorNumber(ctx, factorNumber)
+	if err != nil {
+		// Don't block payments because the lookup failed
+		c.log(ctx).Error(ctx, "Failed to look up transactions by factor number", err, map[string]interface{}{
+			"factor_number": factorNumber,
+		})
+		return nil, release, nil
+	}
+	if existing == nil {
+		return nil, release, nil
+	}
+
+	if values.DuplicateFactorMode == DuplicateFactorReuse {
+		c.log(ctx).Info(ctx, "Reusing payment in progress for factor number", map[string]interface{}{
+			"factor_number": factorNumber,
+			"token":         existing.Token,
+		})
+		return existing, release, nil
+	}
+
+	release()
+	return nil, func() {}, fmt.Errorf("%w: factor number %s already has a payment in progress", ErrDuplicatePayment, factorNumber)
+}
+
+// pendingByFactorNumber returns the most recent unexpired, non-terminal transaction with a factor number
+func (c *Client) pendingByFactorNumber(ctx context.Context, factorNumber string) (*Transaction, error) {
+	var transactions []*Transaction
+	var err error
+	if lookup, ok := c.storage.(FactorNumberStorageInterface); ok {
+		transactions, err = lookup.GetTransactionsByFactorNumber(ctx, factorNumber)
+	} else {
+		transactions, err = c.nonTerminalTransactions(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	window := configValues(c.config).DuplicateFactorWindow
+	if window <= 0 {
+		window = defaultDuplicateFactorWindow
+	}
+	now := c.clock.Now()
+	cutoff := now.Add(-window)
+
+	var pending *Transaction
+	for _, transaction := range transactions {
+		if transaction.FactorNumber != factorNumber || transaction.Status.IsTerminal() {
+			continue
+		}
+		// A queued initialization that succeeded is superseded by its payment
+		if transaction.Status == StatusQueued && !isPendingQueuedInit(transaction) {
+			continue
+		}
+		// The gateway token has expired, a new attempt is allowed
+		if transaction.ExpiresAt != nil {
+			if now.After(*transaction.ExpiresAt) {
+				continue
+			}
+		} else if transaction.CreatedAt.Before(cutoff) {
+			continue
+		}
+		if pending == nil || transaction.CreatedAt.After(pending.CreatedAt) {
+			pending = transaction
+		}
+	}
+
+	return pending, nil
+}
+
+// nonTerminalTransactions lists the stored transactions of every status that can
+// still change, e.g. QUEUED and VERIFY_PENDING besides INIT
+func (c *Client) nonTerminalTransactions(ctx context.Context) ([]*Transaction, error) {
+	var transactions []*Transaction
+	for _, status := range transactionStatuses {
+		if status.IsTerminal() {
+			continue
+		}
+		found, err := c.storage.GetTransactionsByStatus(ctx, string(status))
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, found...)
+	}
+	return transactions, nil
+}
+
+// reusedInitResponse builds the initialization response for a reused transaction
+func reusedInitResponse(transaction *Transaction) *PaymentInitResponse {
+	return &PaymentInitResponse{
+		Status:    1,
+		Token:     transaction.Token,
+		ExpiresAt: transaction.ExpiresAt,
+		Message:   "payment already in progress",
+	}
+}
@@ -0,0 +1,116 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// statusScanStorage hides the factor number lookup of a memory storage, so
+// duplicates are found by scanning statuses
+type statusScanStorage struct {
+	StorageInterface
+}
+
+// duplicateClient returns a client rejecting or reusing repeated factor numbers
+func duplicateClient(t *testing.T, mode DuplicateFactorMode, transport HTTPClientInterface, opts ...ClientOption) (*Client, *MemoryStorage) {
+	t.Helper()
+
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.RejectDuplicateFactorNumbers = true
+		c.DuplicateFactorMode = mode
+	}), transport, opts...)
+	return client, storage
+}
+
+func TestDuplicateFactorConcurrentInits(t *testing.T) {
+	for _, mode := range []DuplicateFactorMode{DuplicateFactorStrict, DuplicateFactorReuse} {
+		step := jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": "tok-1042"})
+		step.delay = 20 * time.Millisecond
+		transport := newStubTransport(step, jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": "tok-other"}))
+		client, _ := duplicateClient(t, mode, transport)
+
+		var wg sync.WaitGroup
+		responses := make([]*PaymentInitResponse, 2)
+		errs := make([]error, 2)
+		for i := range responses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				responses[i], errs[i] = client.InitiatePaymentWithRequest(context.Background(), &PaymentInitRequest{
+					Amount:       100000,
+					Description:  "Order 1042",
+					FactorNumber: "1042",
+				}, nil)
+			}(i)
+		}
+		wg.Wait()
+
+		if transport.count() != 1 {
+			t.Fatalf("%s: %d gateway calls for one factor number", mode, transport.count())
+		}
+
+		switch mode {
+		case DuplicateFactorStrict:
+			rejected := 0
+			for i, err := range errs {
+				if errors.Is(err, ErrDuplicatePayment) {
+					rejected++
+				} else if err != nil || responses[i].Token != "tok-1042" {
+					t.Fatalf("%s: response %+v, %v", mode, responses[i], err)
+				}
+			}
+			if rejected != 1 {
+				t.Fatalf("%s: %d inits rejected", mode, rejected)
+			}
+		case DuplicateFactorReuse:
+			for i, err := range errs {
+				if err != nil || responses[i].Token != "tok-1042" {
+					t.Fatalf("%s: response %+v, %v", mode, responses[i], err)
+				}
+			}
+		}
+	}
+}
+
+func TestDuplicateFactorScansNonTerminalStatuses(t *testing.T) {
+	for _, status := range []TransactionStatus{StatusInit, StatusVerifyPending, StatusQueued, StatusPaid} {
+		transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": "tok-new"}))
+		memory := NewMemoryStorage()
+		client, _ := duplicateClient(t, DuplicateFactorStrict, transport, WithClientStorage(statusScanStorage{memory}))
+
+		expiresAt := time.Now().Add(time.Hour)
+		err := memory.StoreTransaction(context.Background(), &Transaction{
+			ID:           "tx-1042",
+			Token:        "tok-1042",
+			Amount:       100000,
+			Status:       status,
+			FactorNumber: "1042",
+			CreatedAt:    time.Now(),
+			ExpiresAt:    &expiresAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.InitiatePaymentWithRequest(context.Background(), &PaymentInitRequest{
+			Amount:       100000,
+			Description:  "Order 1042",
+			FactorNumber: "1042",
+		}, nil)
+
+		// Only a payment that ended frees its factor number
+		if status.IsTerminal() {
+			if err != nil || transport.count() != 1 {
+				t.Fatalf("%s: %d gateway calls, %v", status, transport.count(), err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrDuplicatePayment) || transport.count() != 0 {
+			t.Fatalf("%s: %d gateway calls, %v", status, transport.count(), err)
+		}
+	}
+}
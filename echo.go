@@ -0,0 +1,132 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// echo.go implements checking the description and factor number the gateway echoes back
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// EchoMismatchMetadataKey is the transaction metadata key listing the fields
+// whose echo didn't match; once set the transaction isn't flagged again, so a
+// reviewed transaction keeps the status a person gave it
+const EchoMismatchMetadataKey = "echo_mismatch"
+
+// Echoed fields
+const (
+	EchoFieldDescription  = "description"
+	EchoFieldFactorNumber = "factor_number"
+)
+
+// EchoMismatch is a field the gateway echoed with a different value than was sent at init
+type EchoMismatch struct {
+	// Field is EchoFieldDescription or EchoFieldFactorNumber
+	Field string
+
+	// Sent is the value sent at init
+	Sent string
+
+	// Echoed is the value the gateway returned
+	Echoed string
+}
+
+// normalizeEcho collapses whitespace, which the gateway may change
+func normalizeEcho(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// compareEcho returns the fields echoed with another value than the transaction
+// was initialized with. Values that weren't sent or weren't echoed can't be compared.
+func compareEcho(transaction *Transaction, description, factorNumber string) []EchoMismatch {
+	var mismatches []EchoMismatch
+	compare := func(field, sent, echoed string) {
+		sent, echoed = normalizeEcho(sent), normalizeEcho(echoed)
+		if sent != "" && echoed != "" && sent != echoed {
+			mismatches = append(mismatches, EchoMismatch{Field: field, Sent: sent, Echoed: echoed})
+		}
+	}
+
+	compare(EchoFieldDescription, transaction.Description, description)
+	compare(EchoFieldFactorNumber, transaction.FactorNumber, factorNumber)
+	return mismatches
+}
+
+// echoWarnings describes mismatches for response warnings, without their values
+func echoWarnings(mismatches []EchoMismatch) []string {
+	warnings := make([]string, 0, len(mismatches))
+	for _, mismatch := range mismatches {
+		warnings = append(warnings, "The "+strings.ReplaceAll(mismatch.Field, "_", " ")+" returned by the gateway doesn't match the one sent")
+	}
+	return warnings
+}
+
+// checkEcho compares the description and factor number the gateway returned for
+// a token with those stored at init and returns warnings for the differences.
+// The first mismatch of a settled transaction marks it SUSPECT where the state
+// machine allows it, is logged as an error and fires the OnEchoMismatch hook;
+// the gateway's result is still returned. It takes the token lock, so callers
+// must not hold it.
+func (c *Client) checkEcho(ctx context.Context, token, description, factorNumber, source string) []string {
+	if configValues(c.config).SkipEchoCheck {
+		return nil
+	}
+
+	// A mismatch is recorded even if the caller went away
+	storeCtx := context.WithoutCancel(ctx)
+
+	// Callbacks, verifications and overrides of the token can't change it meanwhile
+	release := c.tokenLocks.Lock(token)
+	defer release()
+
+	transaction, err := c.storage.GetTransaction(storeCtx, token)
+	if err != nil {
+		return nil
+	}
+
+	mismatches := compareEcho(transaction, description, factorNumber)
+	if len(mismatches) == 0 {
+		return nil
+	}
+	warnings := echoWarnings(mismatches)
+	if transaction.Metadata[EchoMismatchMetadataKey] != "" {
+		return warnings
+	}
+
+	// A payment that isn't settled yet is flagged once its verification makes it PAID
+	if !transaction.Status.IsTerminal() {
+		return warnings
+	}
+
+	fields := make([]string, 0, len(mismatches))
+	logFields := transactionLogFields(transaction)
+	logFields["source"] = source
+	for _, mismatch := range mismatches {
+		fields = append(fields, mismatch.Field)
+		logFields["sent_"+mismatch.Field] = mismatch.Sent
+		logFields["echoed_"+mismatch.Field] = mismatch.Echoed
+		c.metrics.IncCounter(MetricEchoMismatches, map[string]string{"field": mismatch.Field})
+	}
+	c.log(ctx).Error(ctx, "Gateway echoed other payment details than were sent, marking the transaction suspect",
+		errors.New("echoed "+strings.Join(fields, " and ")+" doesn't match"), logFields)
+
+	// Payments that moved no money don't need a review
+	previousStatus := transaction.Status
+	patch := TransactionPatch{Metadata: map[string]string{EchoMismatchMetadataKey: strings.Join(fields, ",")}}
+	if transaction.Status.CanTransitionTo(StatusSuspect) {
+		status := StatusSuspect
+		patch.Status = &status
+	}
+	c.applyPatch(&patch, transaction)
+
+	if err := c.patchTransaction(storeCtx, token, patch); err != nil {
+		c.log(ctx).Error(ctx, "Failed to mark transaction suspect", err, transactionLogFields(transaction))
+		return warnings
+	}
+	c.invalidateCache(storeCtx, token)
+
+	c.fireStatusChange(ctx, transaction, previousStatus)
+	c.fireEchoMismatch(ctx, transaction, mismatches)
+
+	return warnings
+}
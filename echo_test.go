@@ -0,0 +1,141 @@
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// verifyEcho answers a verification echoing the given description and factor number
+func verifyEcho(description, factorNumber string) stubStep {
+	return jsonStep(http.StatusOK, map[string]interface{}{
+		"status":       1,
+		"amount":       "100000",
+		"transId":      160000000001,
+		"factorNumber": factorNumber,
+		"description":  description,
+	})
+}
+
+func TestEchoMatches(t *testing.T) {
+	metrics := newRecordingMetrics()
+	client, storage, _ := newTestClient(t, testConfig(t), newStubTransport(verifyEcho("Order  1042 ", "1042")), WithClientMetrics(metrics))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	// Whitespace the gateway changed is not a mismatch
+	resp, err := client.VerifyPayment(context.Background(), webhookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Warnings) != 0 {
+		t.Fatalf("warnings %v", resp.Warnings)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusPaid || transaction.Metadata[EchoMismatchMetadataKey] != "" {
+		t.Fatalf("status %s, metadata %v", transaction.Status, transaction.Metadata)
+	}
+	if metrics.counter(MetricEchoMismatches) != 0 {
+		t.Fatalf("%d mismatches counted", metrics.counter(MetricEchoMismatches))
+	}
+}
+
+func TestEchoMismatchMarksSuspect(t *testing.T) {
+	var hooked []EchoMismatch
+	var changes []TransactionStatus
+	metrics := newRecordingMetrics()
+	client, storage, logger := newTestClient(t, testConfig(t), newStubTransport(verifyEcho("Order 1042", "9999")),
+		WithClientMetrics(metrics), WithClientHooks(Hooks{
+			OnEchoMismatch: func(ctx context.Context, transaction *Transaction, mismatches []EchoMismatch) {
+				hooked = append(hooked, mismatches...)
+			},
+			OnStatusChange: func(ctx context.Context, transaction *Transaction, from, to TransactionStatus) {
+				changes = append(changes, to)
+			},
+		}))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	// The gateway's result is still returned, with a warning
+	resp, err := client.VerifyPayment(context.Background(), webhookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("warnings %v", resp.Warnings)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusSuspect || transaction.Metadata[EchoMismatchMetadataKey] != EchoFieldFactorNumber {
+		t.Fatalf("status %s, metadata %v", transaction.Status, transaction.Metadata)
+	}
+	if len(hooked) != 1 || hooked[0] != (EchoMismatch{Field: EchoFieldFactorNumber, Sent: "1042", Echoed: "9999"}) {
+		t.Fatalf("hook mismatches %+v", hooked)
+	}
+	if len(changes) != 2 || changes[0] != StatusPaid || changes[1] != StatusSuspect {
+		t.Fatalf("status changes %v", changes)
+	}
+	if metrics.counter(MetricEchoMismatches) != 1 {
+		t.Fatalf("%d mismatches counted", metrics.counter(MetricEchoMismatches))
+	}
+	if entry, found := logger.find("Gateway echoed other payment details than were sent, marking the transaction suspect"); !found || entry.level != "error" {
+		t.Fatalf("mismatch not logged:\n%s", logger.dump())
+	}
+
+	// A repeated check warns without flagging the transaction again
+	if warnings := client.checkEcho(context.Background(), webhookToken, "Order 1042", "9999", "verify"); len(warnings) != 1 {
+		t.Fatalf("warnings %v", warnings)
+	}
+	if len(hooked) != 1 || metrics.counter(MetricEchoMismatches) != 1 {
+		t.Fatal("mismatch reported twice")
+	}
+}
+
+func TestEchoMismatchOfUnsettledPayment(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t), nil)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	// The state machine has no way from INIT to SUSPECT; the verification flags it later
+	if warnings := client.checkEcho(context.Background(), webhookToken, "Order 1042", "9999", "transaction_info"); len(warnings) != 1 {
+		t.Fatalf("warnings %v", warnings)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusInit || transaction.Metadata[EchoMismatchMetadataKey] != "" {
+		t.Fatalf("status %s, metadata %v", transaction.Status, transaction.Metadata)
+	}
+}
+
+func TestEchoMismatchRacingStatusChange(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t), nil)
+	storeWebhookPayment(t, storage, StatusPaid)
+
+	// A refund holds the token while the mismatch is found
+	release := client.tokenLocks.Lock(webhookToken)
+	done := make(chan []string)
+	go func() {
+		done <- client.checkEcho(context.Background(), webhookToken, "Order 1042", "9999", "verify")
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("echo check didn't wait for the token lock")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	refunded := StatusRefunded
+	if err := storage.PatchTransaction(context.Background(), webhookToken, TransactionPatch{Status: &refunded}); err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	if warnings := <-done; len(warnings) != 1 {
+		t.Fatalf("warnings %v", warnings)
+	}
+
+	// The refund stands; REFUNDED can't become SUSPECT
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusRefunded || transaction.Metadata[EchoMismatchMetadataKey] != EchoFieldFactorNumber {
+		t.Fatalf("status %s, metadata %v", transaction.Status, transaction.Metadata)
+	}
+}
@@ -0,0 +1,241 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// endpoints.go implements the catalog of Vandar API endpoint paths
+package vandargo
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// APIVersion selects a set of default Vandar endpoint paths
+type APIVersion string
+
+const (
+	// APIVersionV4 uses the v4 IPG endpoints (the default)
+	APIVersionV4 APIVersion = "v4"
+
+	// APIVersionV3 uses the legacy v3 IPG endpoints
+	APIVersionV3 APIVersion = "v3"
+)
+
+// Endpoint path placeholders, replaced with path-escaped values when building URLs
+const (
+	placeholderToken         = "{token}"
+	placeholderBusiness      = "{business}"
+	placeholderTransactionID = "{transaction_id}"
+	placeholderRefundID      = "{refund_id}"
+)
+
+// Endpoints lists the paths of the Vandar API endpoints used by the client. Paths are
+// relative to Config.BaseURL and may contain the {token}, {business},
+// {transaction_id} and {refund_id} placeholders. Empty fields use the defaults of Config.APIVersion.
+type Endpoints struct {
+	// Send initializes a payment and returns its token
+	Send string
+
+	// Verify verifies a payment by token
+	Verify string
+
+	// Transaction returns detailed transaction information by token
+	Transaction string
+
+	// Status returns the payment status; contains {token}
+	Status string
+
+	// Refund refunds a transaction through the business API; contains {business} and {transaction_id}
+	Refund string
+
+	// RefundStatus returns the state of a refund through the business API; contains {business} and {refund_id}
+	RefundStatus string
+
+	// Transfer moves money to another business wallet through the business API; contains {business}
+	Transfer string
+
+	// Balance returns the business wallet balance through the business API; contains {business}
+	Balance string
+
+	// PaymentPage is the absolute URL of the payment page the payer is sent to; contains {token}
+	PaymentPage string
+}
+
+// DefaultEndpoints returns the endpoint catalog for an API version; an empty version means v4
+func DefaultEndpoints(version APIVersion) Endpoints {
+	endpoints := Endpoints{
+		Send:         "/api/v4/send",
+		Verify:       "/api/v4/verify",
+		Transaction:  "/api/v4/transaction",
+		Status:       "/v4/{token}",
+		Refund:       "/v3/business/{business}/transaction/{transaction_id}/refund",
+		RefundStatus: "/v3/business/{business}/refund/{refund_id}",
+		Transfer:     "/v3/business/{business}/p2p",
+		Balance:      "/v2/business/{business}/balance",
+		PaymentPage:  "https://ipg.vandar.io/v3/{token}",
+	}
+
+	if version == APIVersionV3 {
+		endpoints.Send = "/api/v3/send"
+		endpoints.Verify = "/api/v3/verify"
+		endpoints.Transaction = "/api/v3/transaction"
+		endpoints.Status = "/v3/{token}"
+	}
+
+	return endpoints
+}
+
+// merge returns the endpoints with empty fields taken from defaults
+func (e Endpoints) merge(defaults Endpoints) Endpoints {
+	if e.Send == "" {
+		e.Send = defaults.Send
+	}
+	if e.Verify == "" {
+		e.Verify = defaults.Verify
+	}
+	if e.Transaction == "" {
+		e.Transaction = defaults.Transaction
+	}
+	if e.Status == "" {
+		e.Status = defaults.Status
+	}
+	if e.Refund == "" {
+		e.Refund = defaults.Refund
+	}
+	if e.RefundStatus == "" {
+		e.RefundStatus = defaults.RefundStatus
+	}
+	if e.Transfer == "" {
+		e.Transfer = defaults.Transfer
+	}
+	if e.Balance == "" {
+		e.Balance = defaults.Balance
+	}
+	if e.PaymentPage == "" {
+		e.PaymentPage = defaults.PaymentPage
+	}
+	return e
+}
+
+// validateAPIVersion checks that an API version is known
+func validateAPIVersion(version APIVersion) error {
+	switch version {
+	case "", APIVersionV3, APIVersionV4:
+		return nil
+	default:
+		return fmt.Errorf("unsupported api version %q", version)
+	}
+}
+
+// endpoints returns the effective endpoint catalog of the client
+func (c *Client) endpoints() Endpoints {
+	values := configValues(c.config)
+	return values.Endpoints.merge(DefaultEndpoints(values.APIVersion))
+}
+
+// expandEndpoint replaces placeholders in an endpoint with path-escaped values
+func expandEndpoint(endpoint string, replacements ...string) string {
+	for i := 0; i+1 < len(replacements); i += 2 {
+		endpoint = strings.ReplaceAll(endpoint, replacements[i], url.PathEscape(replacements[i+1]))
+	}
+	return endpoint
+}
+
+// statusEndpoint returns the payment status path for a token
+func (c *Client) statusEndpoint(token string) string {
+	return expandEndpoint(c.endpoints().Status, placeholderToken, token)
+}
+
+// refundEndpoint returns the refund path for a transaction
+func (c *Client) refundEndpoint(transactionID string) string {
+	return expandEndpoint(c.endpoints().Refund,
+		placeholderBusiness, c.businessSlug(),
+		placeholderTransactionID, transactionID,
+	)
+}
+
+// refundStatusEndpoint returns the refund status path for a refund
+func (c *Client) refundStatusEndpoint(refundID string) string {
+	return expandEndpoint(c.endpoints().RefundStatus,
+		placeholderBusiness, c.businessSlug(),
+		placeholderRefundID, refundID,
+	)
+}
+
+// transferEndpoint returns the wallet transfer path of the business
+func (c *Client) transferEndpoint() string {
+	return expandEndpoint(c.endpoints().Transfer, placeholderBusiness, c.businessSlug())
+}
+
+// balanceEndpoint returns the wallet balance path of the business
+func (c *Client) balanceEndpoint() string {
+	return expandEndpoint(c.endpoints().Balance, placeholderBusiness, c.businessSlug())
+}
+
+// paymentPageURL returns the payment page URL for a token
+func (c *Client) paymentPageURL(token string) string {
+	return expandEndpoint(c.endpoints().PaymentPage, placeholderToken, token)
+}
+
+// PaymentURL returns the payment page URL the payer should be sent to for a token
+func (c *Client) PaymentURL(token string) string {
+	return c.paymentPageURL(token)
+}
+
+// endpointDefinition is a catalog entry request paths are expanded from
+type endpointDefinition struct {
+	// name labels the endpoint in metrics
+	name string
+
+	// pattern is the endpoint path with its placeholders
+	pattern string
+
+	// business reports that the endpoint belongs to the business API, which
+	// authenticates with an access token instead of the API key
+	business bool
+}
+
+// catalog returns the effective endpoint definitions in matching order, the
+// status path last as its pattern matches any single segment
+func (c *Client) catalog() []endpointDefinition {
+	endpoints := c.endpoints()
+	return []endpointDefinition{
+		{name: "send", pattern: endpoints.Send},
+		{name: "verify", pattern: endpoints.Verify},
+		{name: "transaction", pattern: endpoints.Transaction},
+		{name: "refund", pattern: endpoints.Refund, business: true},
+		{name: "refund_status", pattern: endpoints.RefundStatus, business: true},
+		{name: "transfer", pattern: endpoints.Transfer, business: true},
+		{name: "balance", pattern: endpoints.Balance, business: true},
+		{name: "token", pattern: configValues(c.config).TokenEndpoint},
+		{name: "status", pattern: endpoints.Status},
+	}
+}
+
+// lookupEndpoint returns the catalog entry a request path was expanded from
+func (c *Client) lookupEndpoint(endpoint string) (endpointDefinition, bool) {
+	endpoint, _, _ = strings.Cut(endpoint, "?")
+	for _, definition := range c.catalog() {
+		if definition.pattern == "" {
+			continue
+		}
+		if _, ok := matchPattern(definition.pattern, endpoint); ok {
+			return definition, true
+		}
+	}
+	return endpointDefinition{}, false
+}
+
+// endpointName names the Vandar endpoint a request path was expanded from, for
+// metric labels that must not contain tokens or IDs
+func (c *Client) endpointName(endpoint string) string {
+	if definition, ok := c.lookupEndpoint(endpoint); ok {
+		return definition.name
+	}
+	return "other"
+}
+
+// isBusinessEndpoint reports whether a request path was expanded from a business
+// API entry of the catalog rather than a payment gateway (IPG) one
+func (c *Client) isBusinessEndpoint(endpoint string) bool {
+	definition, ok := c.lookupEndpoint(endpoint)
+	return ok && definition.business
+}
@@ -0,0 +1,137 @@
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// endpointCall invokes one client method reaching a Vandar endpoint
+type endpointCall struct {
+	name   string
+	method string
+	call   func(ctx context.Context, client *Client)
+}
+
+var endpointCalls = []endpointCall{
+	{"Send", http.MethodPost, func(ctx context.Context, client *Client) {
+		client.InitiatePayment(ctx, 100000, "Order 1042", nil)
+	}},
+	{"Verify", http.MethodPost, func(ctx context.Context, client *Client) {
+		client.VerifyPayment(ctx, webhookToken)
+	}},
+	{"Transaction", http.MethodPost, func(ctx context.Context, client *Client) {
+		client.GetTransactionInfo(ctx, webhookToken)
+	}},
+	{"Status", http.MethodGet, func(ctx context.Context, client *Client) {
+		client.GetPaymentStatus(ctx, webhookToken)
+	}},
+	{"Refund", http.MethodPost, func(ctx context.Context, client *Client) {
+		client.RefundPayment(ctx, "160000000001", 0)
+	}},
+	{"RefundStatus", http.MethodGet, func(ctx context.Context, client *Client) {
+		client.GetRefund(ctx, "refund-7")
+	}},
+	{"Transfer", http.MethodPost, func(ctx context.Context, client *Client) {
+		client.TransferToWallet(ctx, TransferRequest{DestinationBusiness: "partner", Amount: 100000})
+	}},
+	{"Balance", http.MethodGet, func(ctx context.Context, client *Client) {
+		client.GetWalletBalance(ctx)
+	}},
+}
+
+func TestClientEndpointPaths(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*Config)
+		want   map[string]string
+	}{
+		{
+			name:   "default v4",
+			mutate: func(c *Config) {},
+			want: map[string]string{
+				"Send":         "/api/v4/send",
+				"Verify":       "/api/v4/verify",
+				"Transaction":  "/api/v4/transaction",
+				"Status":       "/v4/" + webhookToken,
+				"Refund":       "/v3/business/shop/transaction/160000000001/refund",
+				"RefundStatus": "/v3/business/shop/refund/refund-7",
+				"Transfer":     "/v3/business/shop/p2p",
+				"Balance":      "/v2/business/shop/balance",
+				"PaymentPage":  "https://ipg.vandar.io/v3/" + webhookToken,
+			},
+		},
+		{
+			name:   "v3",
+			mutate: func(c *Config) { c.APIVersion = APIVersionV3 },
+			want: map[string]string{
+				"Send":         "/api/v3/send",
+				"Verify":       "/api/v3/verify",
+				"Transaction":  "/api/v3/transaction",
+				"Status":       "/v3/" + webhookToken,
+				"Refund":       "/v3/business/shop/transaction/160000000001/refund",
+				"RefundStatus": "/v3/business/shop/refund/refund-7",
+				"Transfer":     "/v3/business/shop/p2p",
+				"Balance":      "/v2/business/shop/balance",
+				"PaymentPage":  "https://ipg.vandar.io/v3/" + webhookToken,
+			},
+		},
+		{
+			// Overridden paths win; the empty Balance falls back to the default
+			name: "overridden",
+			mutate: func(c *Config) {
+				c.Endpoints = Endpoints{
+					Send:         "/ipg/send",
+					Verify:       "/ipg/verify",
+					Transaction:  "/ipg/transaction",
+					Status:       "/ipg/status/{token}",
+					Refund:       "/biz/{business}/refunds/{transaction_id}",
+					RefundStatus: "/biz/{business}/refunds/status/{refund_id}",
+					Transfer:     "/biz/{business}/transfers",
+					PaymentPage:  "https://pay.example.com/{token}",
+				}
+			},
+			want: map[string]string{
+				"Send":         "/ipg/send",
+				"Verify":       "/ipg/verify",
+				"Transaction":  "/ipg/transaction",
+				"Status":       "/ipg/status/" + webhookToken,
+				"Refund":       "/biz/shop/refunds/160000000001",
+				"RefundStatus": "/biz/shop/refunds/status/refund-7",
+				"Transfer":     "/biz/shop/transfers",
+				"Balance":      "/v2/business/shop/balance",
+				"PaymentPage":  "https://pay.example.com/" + webhookToken,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, func(c *Config) {
+				c.Business = "shop"
+				tt.mutate(c)
+			})
+
+			for _, endpoint := range endpointCalls {
+				transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1}))
+				client, storage, _ := newTestClient(t, config, transport)
+				storeWebhookPayment(t, storage, StatusInit)
+
+				endpoint.call(context.Background(), client)
+				if transport.count() == 0 {
+					t.Errorf("%s: no gateway request", endpoint.name)
+					continue
+				}
+				req, _ := transport.request(0)
+				if req.Method != endpoint.method || req.URL.Path != tt.want[endpoint.name] {
+					t.Errorf("%s: %s %s, want %s %s", endpoint.name, req.Method, req.URL.Path, endpoint.method, tt.want[endpoint.name])
+				}
+			}
+
+			client, _, _ := newTestClient(t, config, nil)
+			if got := client.PaymentURL(webhookToken); got != tt.want["PaymentPage"] {
+				t.Errorf("PaymentURL() = %q, want %q", got, tt.want["PaymentPage"])
+			}
+		})
+	}
+}
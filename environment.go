@@ -0,0 +1,231 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// environment.go implements per-environment presets and sandbox/production mismatch detection
+package vandargo
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+const (
+	// ProductionBaseURL is the base URL of Vandar's production API
+	ProductionBaseURL = "https://api.vandar.io"
+
+	// SandboxBaseURL is the base URL of Vandar's sandbox API
+	SandboxBaseURL = "https://sandbox.vandar.io"
+
+	// sandboxTimeout is the HTTP timeout of SandboxConfig, in seconds; short so
+	// development setups fail fast
+	sandboxTimeout = 15
+)
+
+// productionHosts are the hosts of Vandar's production API and payment pages
+var productionHosts = map[string]bool{
+	"api.vandar.io": true,
+	"ipg.vandar.io": true,
+}
+
+// sandboxKeyPrefixes start API keys issued for testing
+var sandboxKeyPrefixes = []string{"test_", "test-", "sandbox_", "sandbox-", "sk_test_", "pk_test_"}
+
+// ProductionConfig returns a validated Config for Vandar's production API: https
+// enforced, sandbox mode off and StrictEnvironment on, so sandbox-looking keys
+// are rejected
+func ProductionConfig(apiKey, callbackURL string) (Config, error) {
+	enforce := true
+
+	config := DefaultConfig()
+	config.APIKey = apiKey
+	config.CallbackURL = callbackURL
+	config.BaseURL = ProductionBaseURL
+	config.SandboxMode = false
+	config.EnforceHTTPS = &enforce
+	config.StrictEnvironment = true
+
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	return config, nil
+}
+
+// SandboxConfig returns a validated Config for Vandar's sandbox API: sandbox
+// mode on, http callback URLs allowed for localhost, a short timeout and a
+// single retry
+func SandboxConfig(apiKey, callbackURL string) (Config, error) {
+	config := DefaultConfig()
+	config.APIKey = apiKey
+	config.CallbackURL = callbackURL
+	config.BaseURL = SandboxBaseURL
+	config.SandboxMode = true
+	config.AllowInsecureLocalhost = true
+	config.Timeout = sandboxTimeout
+	config.MaxRetries = 1
+
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	return config, nil
+}
+
+// EnvironmentMismatchError reports a configuration mixing sandbox and production settings
+type EnvironmentMismatchError struct {
+	// Problems describes each mismatch
+	Problems []string
+}
+
+// Error implements the error interface
+func (e *EnvironmentMismatchError) Error() string {
+	return "configuration mixes sandbox and production settings: " + strings.Join(e.Problems, "; ")
+}
+
+// Is reports the error as ErrInvalidConfig
+func (e *EnvironmentMismatchError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// CheckEnvironment returns an *EnvironmentMismatchError when the configuration
+// looks like it mixes sandbox and production settings, such as a test key with
+// the production API. It is a heuristic: keys are judged by their prefix and
+// URLs by their host.
+func (c *Config) CheckEnvironment() error {
+	sandboxKey := looksLikeSandboxKey(c.APIKey)
+	production := isProductionURL(c.BaseURL)
+	sandboxURL := isSandboxURL(c.BaseURL)
+
+	var problems []string
+	if sandboxKey && production {
+		problems = append(problems, "a sandbox API key is used with the production base URL")
+	}
+	if sandboxKey && !c.SandboxMode {
+		problems = append(problems, "a sandbox API key is used with sandbox mode off")
+	}
+	if sandboxURL && !c.SandboxMode {
+		problems = append(problems, "the sandbox base URL is used with sandbox mode off")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &EnvironmentMismatchError{Problems: problems}
+}
+
+// looksLikeSandboxKey reports whether an API key starts with a test prefix
+func looksLikeSandboxKey(key string) bool {
+	key = strings.ToLower(strings.TrimSpace(key))
+	for _, prefix := range sandboxKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// baseURLHost returns the lowercased host of a base URL, or "" when it can't be parsed
+func baseURLHost(raw string) string {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// isProductionURL reports whether a base URL points at Vandar's production API
+func isProductionURL(raw string) bool {
+	return productionHosts[baseURLHost(raw)]
+}
+
+// isSandboxURL reports whether a base URL points at a sandbox or test host, such
+// as sandbox.vandar.io or api.test.example.com. Loopback addresses are neither
+// sandbox nor production, since simulators and proxies run there.
+func isSandboxURL(raw string) bool {
+	host := baseURLHost(raw)
+	if host == "" || host == "localhost" || net.ParseIP(host) != nil {
+		return false
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if label == "sandbox" || label == "test" || strings.HasPrefix(label, "sandbox-") || strings.HasSuffix(label, "-sandbox") {
+			return true
+		}
+	}
+	return false
+}
+
+// secretConfigFields are the Config fields whose values are redacted in diffs
+var secretConfigFields = map[string]bool{
+	"APIKey":           true,
+	"EncryptionKey":    true,
+	"HashKey":          true,
+	"ServerAPIKeys":    true,
+	"AdminKey":         true,
+	"SensitiveDataKey": true,
+	"WebhookSecret":    true,
+	"ChallengeSecret":  true,
+	"RefreshToken":     true,
+	"ReturnSecret":     true,
+}
+
+// ConfigChange is a setting that differs between two configurations
+type ConfigChange struct {
+	// Field is the name of the Config field
+	Field string
+
+	// From is the value in the receiver, REDACTED for secrets
+	From string
+
+	// To is the value in the other configuration, REDACTED for secrets
+	To string
+}
+
+// String formats the change for logs
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("%s: %q -> %q", c.Field, c.From, c.To)
+}
+
+// Diff returns the settings that differ from other, in field order. Secrets are
+// compared but their values are redacted, so the result is safe to log.
+func (c *Config) Diff(other Config) []ConfigChange {
+	from := reflect.ValueOf(*c)
+	to := reflect.ValueOf(other)
+	fields := from.Type()
+
+	var changes []ConfigChange
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		if reflect.DeepEqual(from.Field(i).Interface(), to.Field(i).Interface()) {
+			continue
+		}
+		changes = append(changes, ConfigChange{
+			Field: field.Name,
+			From:  formatConfigValue(field.Name, from.Field(i)),
+			To:    formatConfigValue(field.Name, to.Field(i)),
+		})
+	}
+	return changes
+}
+
+// formatConfigValue formats a Config field value for a diff; unset secrets and
+// pointers are empty
+func formatConfigValue(name string, value reflect.Value) string {
+	if secretConfigFields[name] {
+		if value.IsZero() {
+			return ""
+		}
+		return redactedValue
+	}
+
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return ""
+		}
+		// Templates are only reported as set
+		if value.Elem().Kind() == reflect.Struct {
+			return "set"
+		}
+		value = value.Elem()
+	}
+	return fmt.Sprint(value.Interface())
+}
@@ -0,0 +1,163 @@
+package vandargo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEnvironmentPresets(t *testing.T) {
+	production, err := ProductionConfig("live-key-1", "https://shop.example.com/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if production.BaseURL != ProductionBaseURL || production.SandboxMode || !production.StrictEnvironment || production.EnforceHTTPS == nil || !*production.EnforceHTTPS {
+		t.Fatalf("production preset %+v", production)
+	}
+	if err := production.Validate(); err != nil {
+		t.Fatalf("production preset fails Validate: %v", err)
+	}
+
+	sandbox, err := SandboxConfig("test_key_1", "http://localhost:8080/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sandbox.BaseURL != SandboxBaseURL || !sandbox.SandboxMode || sandbox.Timeout != sandboxTimeout || sandbox.MaxRetries != 1 {
+		t.Fatalf("sandbox preset %+v", sandbox)
+	}
+	if err := sandbox.Validate(); err != nil {
+		t.Fatalf("sandbox preset fails Validate: %v", err)
+	}
+	if err := sandbox.CheckEnvironment(); err != nil {
+		t.Fatalf("sandbox preset mismatched: %v", err)
+	}
+
+	// Production refuses test keys and insecure callbacks
+	for _, tt := range []struct{ key, callback string }{
+		{"test_key_1", "https://shop.example.com/callback"},
+		{"sk_test_abc", "https://shop.example.com/callback"},
+		{"live-key-1", "http://shop.example.com/callback"},
+		{"", "https://shop.example.com/callback"},
+	} {
+		if _, err := ProductionConfig(tt.key, tt.callback); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("ProductionConfig(%q, %q) = %v", tt.key, tt.callback, err)
+		}
+	}
+	if _, err := SandboxConfig("test_key_1", ""); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("SandboxConfig without a callback URL: %v", err)
+	}
+}
+
+func TestCheckEnvironment(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		baseURL  string
+		sandbox  bool
+		problems int
+	}{
+		{"production", "live-key-1", ProductionBaseURL, false, 0},
+		{"sandbox", "test_key_1", SandboxBaseURL, true, 0},
+		{"live key in sandbox", "live-key-1", SandboxBaseURL, true, 0},
+		{"simulator", "test_key_1", "http://127.0.0.1:8080", true, 0},
+		{"test key, production URL", "test_key_1", ProductionBaseURL, true, 1},
+		{"test key, sandbox off", "Sandbox-Key-1", "https://proxy.example.com", false, 1},
+		{"sandbox URL, sandbox off", "live-key-1", SandboxBaseURL, false, 1},
+		{"everything mixed", " TEST_key_1", "https://api.vandar.io/", false, 2},
+		{"test host", "live-key-1", "https://api.test.example.com", false, 1},
+		{"sandbox label", "live-key-1", "https://vandar-sandbox.example.com", false, 1},
+		{"contains test", "live-key-1", "https://contest.example.com", false, 0},
+		{"key containing test", "key_test_1", ProductionBaseURL, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{APIKey: tt.key, BaseURL: tt.baseURL, SandboxMode: tt.sandbox}
+			err := config.CheckEnvironment()
+
+			var mismatch *EnvironmentMismatchError
+			if tt.problems == 0 {
+				if err != nil {
+					t.Fatalf("unexpected mismatch: %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &mismatch) || len(mismatch.Problems) != tt.problems || !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("CheckEnvironment() = %v, want %d problems", err, tt.problems)
+			}
+		})
+	}
+}
+
+func TestStrictEnvironment(t *testing.T) {
+	config := DefaultConfig()
+	config.APIKey = "test_key_1"
+	config.CallbackURL = "https://shop.example.com/callback"
+	config.BaseURL = ProductionBaseURL
+
+	// Without StrictEnvironment the client starts and logs the mismatch
+	values, err := NewConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := &captureLogger{}
+	if _, err := NewClient(values, NewMemoryStorage(), logger); err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if entry, found := logger.find("mixes sandbox and production"); !found || entry.level != "error" || strings.Contains(logger.dump(), "test_key_1") {
+		t.Fatalf("mismatch not logged or key revealed:\n%s", logger.dump())
+	}
+
+	config.StrictEnvironment = true
+	var mismatch *EnvironmentMismatchError
+	if err := config.Validate(); !errors.As(err, &mismatch) {
+		t.Fatalf("Validate() = %v", err)
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	from := DefaultConfig()
+	from.APIKey = "key-1"
+	from.BaseURL = SandboxBaseURL
+	from.SandboxMode = true
+
+	to := from
+	if changes := from.Diff(to); len(changes) != 0 {
+		t.Fatalf("identical configs differ: %v", changes)
+	}
+
+	enforce := true
+	to.APIKey = "key-2"
+	to.BaseURL = ProductionBaseURL
+	to.SandboxMode = false
+	to.EnforceHTTPS = &enforce
+	to.WebhookSecret = "webhook-secret"
+
+	changes := from.Diff(to)
+	want := []string{
+		`APIKey: "REDACTED" -> "REDACTED"`,
+		`BaseURL: "` + SandboxBaseURL + `" -> "` + ProductionBaseURL + `"`,
+		`SandboxMode: "true" -> "false"`,
+		`EnforceHTTPS: "" -> "true"`,
+		`WebhookSecret: "" -> "REDACTED"`,
+	}
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.String())
+	}
+	for _, line := range want {
+		found := false
+		for _, change := range got {
+			found = found || change == line
+		}
+		if !found {
+			t.Errorf("diff lacks %s:\n%s", line, strings.Join(got, "\n"))
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("diff has %d changes, want %d:\n%s", len(got), len(want), strings.Join(got, "\n"))
+	}
+	if joined := strings.Join(got, "\n"); strings.Contains(joined, "key-2") || strings.Contains(joined, "webhook-secret") {
+		t.Fatalf("diff reveals a secret:\n%s", joined)
+	}
+}
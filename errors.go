@@ -3,8 +3,10 @@
 package vandargo
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 )
 
 // Common error types for package users to check against
@@ -39,6 +41,43 @@ var (
 	// ErrTimeout is returned when a request times out
 	ErrTimeout = errors.New("request timed out")
 
+	// ErrInvalidSignature is returned when a signature does not match the signed data
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrSignatureExpired is returned when signed data is too old to be trusted
+	ErrSignatureExpired = errors.New("signature expired")
+
+	// ErrDuplicatePayment is returned when a payment with the same factor number is already in progress
+	ErrDuplicatePayment = errors.New("duplicate payment")
+
+	// ErrInvalidTransition is returned when a status change is not allowed by the state machine
+	ErrInvalidTransition = errors.New("invalid status transition")
+
+	// ErrConflict is returned when a request conflicts with the current state of a resource
+	ErrConflict = errors.New("conflict")
+
+	// ErrTransferFailed is returned when a wallet transfer fails
+	ErrTransferFailed = errors.New("transfer failed")
+
+	// ErrInsufficientBalance is returned when the wallet balance doesn't cover an amount
+	ErrInsufficientBalance = errors.New("insufficient balance")
+
+	// ErrAlreadyRefunded is returned when refunding a transaction that was already refunded
+	ErrAlreadyRefunded = errors.New("already refunded")
+
+	// ErrRateLimited is returned when a caller exceeded its request rate
+	ErrRateLimited = errors.New("rate limit exceeded")
+
+	// ErrGatewayUnavailable is returned when the gateway answers with a maintenance page instead of JSON
+	ErrGatewayUnavailable = errors.New("payment gateway unavailable")
+
+	// ErrShuttingDown is returned for operations started after the client began draining
+	ErrShuttingDown = errors.New("server is shutting down")
+
+	// ErrTooManyInFlight is returned when a gateway request found no free slot
+	// under Config.MaxConcurrentGatewayCalls in time
+	ErrTooManyInFlight = errors.New("too many gateway requests in flight")
+
 	// ErrInternalError is returned for unexpected internal errors
 	ErrInternalError = errors.New("internal error")
 )
@@ -86,7 +125,87 @@ func IsDomainError(err error) bool {
 		errors.Is(err, ErrNotFound) ||
 		errors.Is(err, ErrPaymentFailed) ||
 		errors.Is(err, ErrVerificationFailed) ||
-		errors.Is(err, ErrRefundFailed)
+		errors.Is(err, ErrRefundFailed) ||
+		errors.Is(err, ErrTransferFailed) ||
+		errors.Is(err, ErrInsufficientBalance) ||
+		errors.Is(err, ErrDuplicatePayment) ||
+		errors.Is(err, ErrInvalidTransition) ||
+		errors.Is(err, ErrConflict) ||
+		errors.Is(err, ErrAlreadyRefunded) ||
+		errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrShuttingDown) ||
+		errors.Is(err, ErrTooManyInFlight)
+}
+
+// errorToStatus maps an error to the HTTP status code handlers respond with
+func errorToStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case IsValidationError(err):
+		return http.StatusUnprocessableEntity
+	case isGatewayAuthError(err):
+		// The merchant's credentials are wrong, not the caller's
+		return http.StatusBadGateway
+	case errors.Is(err, ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrAuthentication):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrPermission),
+		errors.Is(err, ErrInvalidSignature),
+		errors.Is(err, ErrSignatureExpired):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict),
+		errors.Is(err, ErrAlreadyRefunded),
+		errors.Is(err, ErrDuplicatePayment),
+		errors.Is(err, ErrInvalidTransition):
+		return http.StatusConflict
+	case errors.Is(err, ErrPaymentFailed),
+		errors.Is(err, ErrVerificationFailed),
+		errors.Is(err, ErrRefundFailed),
+		errors.Is(err, ErrTransferFailed),
+		errors.Is(err, ErrInsufficientBalance):
+		// The request was valid but the gateway declined it
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrGatewayUnavailable),
+		errors.Is(err, ErrShuttingDown),
+		errors.Is(err, ErrTooManyInFlight):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled):
+		// The caller went away; the response is dropped
+		return StatusClientClosedRequest
+	case errors.Is(err, ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrNetworkFailure):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// upstreamError classifies a failed gateway call for the response, keeping timeouts
+// and network failures and hiding every other detail behind ErrInternalError
+func upstreamError(err error) error {
+	var unavailable *GatewayUnavailableError
+	var authErr *GatewayAuthError
+	switch {
+	case errors.As(err, &unavailable):
+		return unavailable
+	case errors.As(err, &authErr):
+		return authErr
+	case errors.Is(err, ErrTooManyInFlight):
+		return ErrTooManyInFlight
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(err, ErrNetworkFailure):
+		return ErrNetworkFailure
+	default:
+		return ErrInternalError
+	}
 }
 
 // IsNetworkError checks if an error is network-related
@@ -121,6 +240,20 @@ func ExtractValidationErrors(err error) []ValidationError {
 
 // APIErrorResponse converts an error to a safe API response
 func APIErrorResponse(err error) map[string]interface{} {
+	response := apiErrorResponse(err)
+
+	// Failed verifications say whether to wait for their retry
+	var verifyErr *VerifyError
+	if errors.As(err, &verifyErr) {
+		response["reason"] = string(verifyErr.Reason)
+		response["retry_scheduled"] = verifyErr.RetryScheduled
+	}
+
+	return response
+}
+
+// apiErrorResponse builds the standard error envelope
+func apiErrorResponse(err error) map[string]interface{} {
 	if err == nil {
 		return map[string]interface{}{
 			"status":  false,
@@ -156,6 +289,50 @@ func APIErrorResponse(err error) map[string]interface{} {
 		return response
 	}
 
+	// Handle API keys lacking the scope of a route
+	var scopeErr *ScopeError
+	if errors.As(err, &scopeErr) {
+		response["message"] = scopeErr.Error()
+		response["code"] = InsufficientScopeCode
+		return response
+	}
+
+	// Handle credentials rejected by the gateway
+	if isGatewayAuthError(err) {
+		response["message"] = gatewayAuthMessage
+		response["code"] = GatewayAuthCode
+		return response
+	}
+
+	// Handle rejected challenge tokens without the verifier's details
+	if errors.Is(err, ErrChallengeFailed) {
+		response["message"] = "Challenge verification failed"
+		response["code"] = ChallengeFailedCode
+		return response
+	}
+
+	// Handle velocity rules without revealing their limits
+	var velocityErr *VelocityError
+	if errors.As(err, &velocityErr) {
+		response["message"] = "Too many payment attempts. Please try again later."
+		response["code"] = VelocityLimitCode
+		return response
+	}
+
+	// Handle transactions named by an unknown ID or factor number
+	if errors.Is(err, ErrTransactionNotFound) {
+		response["message"] = "Transaction not found"
+		response["code"] = TransactionNotFoundCode
+		return response
+	}
+
+	// Handle gateway maintenance windows
+	if errors.Is(err, ErrGatewayUnavailable) {
+		response["message"] = "The payment gateway is temporarily unavailable. Please try again later."
+		response["code"] = GatewayMaintenanceCode
+		return response
+	}
+
 	// Handle standard errors with safe messages
 	if IsDomainError(err) {
 		response["message"] = err.Error()
@@ -0,0 +1,93 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestErrorToStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, http.StatusOK},
+		{ErrInvalidRequest, http.StatusBadRequest},
+		{ErrAuthentication, http.StatusUnauthorized},
+		{ErrPermission, http.StatusForbidden},
+		{ErrInvalidSignature, http.StatusForbidden},
+		{ErrSignatureExpired, http.StatusForbidden},
+		{ErrNotFound, http.StatusNotFound},
+		{ErrConflict, http.StatusConflict},
+		{ErrAlreadyRefunded, http.StatusConflict},
+		{ErrDuplicatePayment, http.StatusConflict},
+		{ErrInvalidTransition, http.StatusConflict},
+		{NewValidationError("amount", "must be positive"), http.StatusUnprocessableEntity},
+		{NewValidationErrors([]ValidationError{{Field: "amount", Message: "must be positive"}}), http.StatusUnprocessableEntity},
+		{ErrPaymentFailed, http.StatusUnprocessableEntity},
+		{ErrVerificationFailed, http.StatusUnprocessableEntity},
+		{ErrRefundFailed, http.StatusUnprocessableEntity},
+		{ErrTransferFailed, http.StatusUnprocessableEntity},
+		{ErrInsufficientBalance, http.StatusUnprocessableEntity},
+		{ErrRateLimited, http.StatusTooManyRequests},
+		{ErrGatewayUnavailable, http.StatusServiceUnavailable},
+		{ErrShuttingDown, http.StatusServiceUnavailable},
+		{ErrTooManyInFlight, http.StatusServiceUnavailable},
+		{context.Canceled, StatusClientClosedRequest},
+		{ErrTimeout, http.StatusGatewayTimeout},
+		{ErrNetworkFailure, http.StatusBadGateway},
+		{&GatewayAuthError{StatusCode: http.StatusUnauthorized}, http.StatusBadGateway},
+		{ErrInternalError, http.StatusInternalServerError},
+		{ErrInvalidConfig, http.StatusInternalServerError},
+		{errors.New("storage exploded"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := errorToStatus(tt.err); got != tt.want {
+			t.Errorf("errorToStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+
+		// Wrapping keeps the status
+		if tt.err != nil {
+			if got := errorToStatus(fmt.Errorf("handler: %w", tt.err)); got != tt.want {
+				t.Errorf("errorToStatus(wrapped %v) = %d, want %d", tt.err, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRespondError(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), nil)
+
+	tests := []struct {
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{fmt.Errorf("lookup: %w", ErrNotFound), http.StatusNotFound, ""},
+		{NewValidationError("amount", "must be positive"), http.StatusUnprocessableEntity, ""},
+		{&GatewayUnavailableError{StatusCode: http.StatusServiceUnavailable, ContentType: "text/html", RetryAfter: 1500 * time.Millisecond}, http.StatusServiceUnavailable, "2"},
+		{ErrTooManyInFlight, http.StatusServiceUnavailable, "1"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		client.respondError(rec, tt.err)
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%v: body %q is not JSON", tt.err, rec.Body)
+		}
+		if rec.Code != tt.status || body["status"] != false || body["message"] == "" {
+			t.Errorf("%v: status %d: %v", tt.err, rec.Code, body)
+		}
+		if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("%v: Retry-After %q, want %q", tt.err, got, tt.retryAfter)
+		}
+	}
+}
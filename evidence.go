@@ -0,0 +1,255 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// evidence.go implements retention of raw gateway responses as dispute evidence
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultEvidenceMaxBytes caps each retained response when EvidenceMaxBytes is zero
+const defaultEvidenceMaxBytes = 16 * 1024
+
+// TransactionEvidence holds the raw gateway responses received for a transaction,
+// as requested by Vandar when a customer disputes a charge. Card numbers are masked.
+type TransactionEvidence struct {
+	// Token is the payment token of the transaction
+	Token string `json:"token"`
+
+	// VerifyResponse is the body of the verify response
+	VerifyResponse json.RawMessage `json:"verify_response,omitempty"`
+
+	// TransactionInfoResponse is the body of the latest transaction info response
+	TransactionInfoResponse json.RawMessage `json:"transaction_info_response,omitempty"`
+
+	// Truncated reports whether a response exceeded EvidenceMaxBytes and was
+	// kept as a truncated string
+	Truncated bool `json:"truncated,omitempty"`
+
+	// CapturedAt is when a response was last captured; retention counts from it
+	CapturedAt time.Time `json:"captured_at"`
+}
+
+// EvidenceStorageInterface is implemented by storages that can keep gateway
+// evidence; evidence is only retained when the client's storage implements it
+type EvidenceStorageInterface interface {
+	// SaveEvidence creates or replaces the evidence of a transaction
+	SaveEvidence(ctx context.Context, evidence *TransactionEvidence) error
+
+	// GetEvidence retrieves the evidence of a transaction by token
+	GetEvidence(ctx context.Context, token string) (*TransactionEvidence, error)
+
+	// PurgeEvidence deletes evidence captured before a time and returns how much was deleted
+	PurgeEvidence(ctx context.Context, before time.Time) (int, error)
+}
+
+// evidenceStorage returns the storage keeping evidence, or nil when retention is disabled
+func (c *Client) evidenceStorage() EvidenceStorageInterface {
+	if configValues(c.config).EvidenceRetention <= 0 {
+		return nil
+	}
+
+	storage, _ := c.storage.(EvidenceStorageInterface)
+	return storage
+}
+
+// evidenceBody masks card numbers in a response body and caps its size. Bodies
+// that are no longer valid JSON once masked or truncated are kept as a string.
+func (c *Client) evidenceBody(body []byte) (json.RawMessage, bool) {
+	maxBytes := configValues(c.config).EvidenceMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultEvidenceMaxBytes
+	}
+
+	masked := redactBody(string(body))
+	truncated := len(masked) > maxBytes
+	if truncated {
+		masked = masked[:maxBytes]
+	}
+
+	if !truncated && json.Valid([]byte(masked)) {
+		return json.RawMessage(masked), false
+	}
+
+	encoded, _ := json.Marshal(masked)
+	return encoded, truncated
+}
+
+// captureEvidence records a raw gateway response of a transaction when retention
+// is enabled; failures are logged, since evidence must not break the payment flow
+func (c *Client) captureEvidence(ctx context.Context, token string, body []byte, set func(*TransactionEvidence, json.RawMessage)) {
+	storage := c.evidenceStorage()
+	if storage == nil || token == "" {
+		return
+	}
+
+	// Verify and transaction info captures of a token update the same record. The
+	// key is separate from the token lock, which a verification already holds.
+	release := c.tokenLocks.Lock("evidence:" + token)
+	defer release()
+
+	evidence, err := storage.GetEvidence(ctx, token)
+	if err != nil {
+		evidence = &TransactionEvidence{Token: token}
+	}
+
+	raw, truncated := c.evidenceBody(body)
+	set(evidence, raw)
+	evidence.Truncated = evidence.Truncated || truncated
+	evidence.CapturedAt = c.clock.Now()
+
+	if err := storage.SaveEvidence(ctx, evidence); err != nil {
+		c.log(ctx).Error(ctx, "Failed to store gateway evidence", err, map[string]interface{}{
+			"token": redactToken(token),
+		})
+	}
+}
+
+// captureVerifyEvidence records the body of a verify response
+func (c *Client) captureVerifyEvidence(ctx context.Context, token string, body []byte) {
+	c.captureEvidence(ctx, token, body, func(evidence *TransactionEvidence, raw json.RawMessage) {
+		evidence.VerifyResponse = raw
+	})
+}
+
+// captureTransactionInfoEvidence records the body of a transaction info response
+func (c *Client) captureTransactionInfoEvidence(ctx context.Context, token string, body []byte) {
+	c.captureEvidence(ctx, token, body, func(evidence *TransactionEvidence, raw json.RawMessage) {
+		evidence.TransactionInfoResponse = raw
+	})
+}
+
+// GetTransactionEvidence returns the raw gateway responses retained for a transaction.
+// It fails with ErrNotFound when retention is disabled or nothing was captured.
+func (c *Client) GetTransactionEvidence(ctx context.Context, token string) (*TransactionEvidence, error) {
+	if token == "" {
+		return nil, NewValidationError("token", "token is required")
+	}
+
+	storage := c.evidenceStorage()
+	if storage == nil {
+		return nil, fmt.Errorf("%w: gateway evidence retention is not enabled", ErrNotFound)
+	}
+
+	evidence, err := storage.GetEvidence(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no gateway evidence for transaction: %v", ErrNotFound, err)
+	}
+
+	return evidence, nil
+}
+
+// PurgeExpiredEvidence deletes evidence older than EvidenceRetention and returns
+// how much was deleted
+func (c *Client) PurgeExpiredEvidence(ctx context.Context) (int, error) {
+	storage := c.evidenceStorage()
+	if storage == nil {
+		return 0, nil
+	}
+
+	before := c.clock.Now().Add(-configValues(c.config).EvidenceRetention)
+	purged, err := storage.PurgeEvidence(ctx, before)
+	if err != nil {
+		return purged, fmt.Errorf("failed to purge gateway evidence: %w", err)
+	}
+
+	return purged, nil
+}
+
+// handleTransactionEvidence returns the gateway evidence of a transaction
+func (c *Client) handleTransactionEvidence(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// The transaction is addressed by its token in the path
+	token := pathParam(r, "id")
+	if token == "" {
+		c.respondWithError(w, ErrInvalidRequest, "Transaction token is required")
+		return
+	}
+
+	evidence, err := c.GetTransactionEvidence(ctx, token)
+	switch {
+	case err == nil:
+		c.respondWithJSON(w, http.StatusOK, evidence)
+	case errors.Is(err, ErrNotFound):
+		c.respondWithError(w, ErrNotFound, "No gateway evidence for transaction")
+	default:
+		c.respondWithError(w, ErrInternalError, "Failed to get gateway evidence")
+		c.log(ctx).Error(ctx, "Failed to get gateway evidence", err, map[string]interface{}{
+			"token": redactToken(token),
+		})
+	}
+}
+
+// SaveEvidence creates or replaces the evidence of a transaction
+func (s *MemoryStorage) SaveEvidence(ctx context.Context, evidence *TransactionEvidence) error {
+	if evidence == nil || evidence.Token == "" {
+		return errors.New("evidence token cannot be empty")
+	}
+
+	if err := s.enter(ctx); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	evidenceCopy := *evidence
+	s.evidence[evidence.Token] = &evidenceCopy
+
+	return nil
+}
+
+// GetEvidence retrieves the evidence of a transaction by token
+func (s *MemoryStorage) GetEvidence(ctx context.Context, token string) (*TransactionEvidence, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	evidence, exists := s.evidence[token]
+	if !exists {
+		return nil, fmt.Errorf("evidence not found: %s", token)
+	}
+
+	evidenceCopy := *evidence
+	return &evidenceCopy, nil
+}
+
+// PurgeEvidence deletes evidence captured before a time
+func (s *MemoryStorage) PurgeEvidence(ctx context.Context, before time.Time) (int, error) {
+	if err := s.enter(ctx); err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := contextError(ctx); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for token, evidence := range s.evidence {
+		if evidence.CapturedAt.Before(before) {
+			delete(s.evidence, token)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,183 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// evidenceClient returns a client keeping gateway evidence for a day
+func evidenceClient(t *testing.T, transport HTTPClientInterface, mutate ...func(*Config)) (*Client, *MemoryStorage, *FakeClock) {
+	t.Helper()
+
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t, append([]func(*Config){func(c *Config) {
+		c.EvidenceRetention = 24 * time.Hour
+		c.AdminKey = "admin-key"
+		c.CacheTTL = -1
+	}}, mutate...)...), transport, WithClientClock(clock))
+	return client, storage, clock
+}
+
+// verifyWithCard answers a verification reporting the full card number
+func verifyWithCard() stubStep {
+	return jsonStep(http.StatusOK, map[string]interface{}{
+		"status":       1,
+		"amount":       "100000",
+		"transId":      160000000001,
+		"factorNumber": "1042",
+		"description":  "Order 1042",
+		"cardNumber":   "6037-9912-3456-7890",
+	})
+}
+
+func TestEvidenceCapturedAndMasked(t *testing.T) {
+	info := jsonStep(http.StatusOK, map[string]interface{}{
+		"status":      1,
+		"amount":      "100000",
+		"transId":     160000000001,
+		"cardNumber":  "6037991234567890",
+		"description": "Order 1042",
+	})
+	client, storage, clock := evidenceClient(t, newStubTransport(verifyWithCard(), info))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	if _, err := client.VerifyPayment(context.Background(), webhookToken); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := client.GetTransactionInfo(context.Background(), webhookToken); err != nil {
+		t.Fatal(err)
+	}
+
+	evidence, err := client.GetTransactionEvidence(context.Background(), webhookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evidence.Token != webhookToken || evidence.Truncated || !evidence.CapturedAt.Equal(clock.Now()) {
+		t.Fatalf("evidence %+v", evidence)
+	}
+
+	// Both responses are kept as JSON, with card numbers masked
+	for name, raw := range map[string]json.RawMessage{"verify": evidence.VerifyResponse, "transaction info": evidence.TransactionInfoResponse} {
+		var body map[string]interface{}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			t.Fatalf("%s evidence %s: %v", name, raw, err)
+		}
+		if body["cardNumber"] != "************7890" || body["transId"] != float64(160000000001) {
+			t.Fatalf("%s evidence %s", name, raw)
+		}
+	}
+	if strings.Contains(string(evidence.VerifyResponse), "9912") || strings.Contains(string(evidence.TransactionInfoResponse), "9912") {
+		t.Fatal("card number kept in evidence")
+	}
+}
+
+func TestEvidenceTruncated(t *testing.T) {
+	client, storage, _ := evidenceClient(t, newStubTransport(verifyWithCard()), func(c *Config) {
+		c.EvidenceMaxBytes = 64
+	})
+	storeWebhookPayment(t, storage, StatusInit)
+
+	if _, err := client.VerifyPayment(context.Background(), webhookToken); err != nil {
+		t.Fatal(err)
+	}
+
+	evidence, err := client.GetTransactionEvidence(context.Background(), webhookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cut off response is kept as a string of the masked body
+	var kept string
+	if err := json.Unmarshal(evidence.VerifyResponse, &kept); err != nil {
+		t.Fatalf("truncated evidence %s: %v", evidence.VerifyResponse, err)
+	}
+	if !evidence.Truncated || len(kept) != 64 || !strings.HasPrefix(kept, "{") {
+		t.Fatalf("truncated %v, kept %q", evidence.Truncated, kept)
+	}
+}
+
+func TestEvidenceDisabled(t *testing.T) {
+	client, storage, _ := evidenceClient(t, newStubTransport(verifyWithCard()), func(c *Config) {
+		c.EvidenceRetention = 0
+	})
+	storeWebhookPayment(t, storage, StatusInit)
+
+	if _, err := client.VerifyPayment(context.Background(), webhookToken); err != nil {
+		t.Fatal(err)
+	}
+	if len(storage.evidence) != 0 {
+		t.Fatal("evidence kept while retention is disabled")
+	}
+	if _, err := client.GetTransactionEvidence(context.Background(), webhookToken); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetTransactionEvidence() error = %v", err)
+	}
+	if purged, err := client.PurgeExpiredEvidence(context.Background()); err != nil || purged != 0 {
+		t.Fatalf("purged %d, %v", purged, err)
+	}
+	if _, err := client.GetTransactionEvidence(context.Background(), ""); !IsValidationError(err) {
+		t.Fatalf("without a token: %v", err)
+	}
+}
+
+func TestPurgeExpiredEvidence(t *testing.T) {
+	client, storage, clock := evidenceClient(t, newStubTransport(verifyWithCard()))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	if _, err := client.VerifyPayment(context.Background(), webhookToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retention counts from the capture
+	clock.Advance(23 * time.Hour)
+	if purged, err := client.PurgeExpiredEvidence(context.Background()); err != nil || purged != 0 {
+		t.Fatalf("purged %d within retention, %v", purged, err)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if purged, err := client.PurgeExpiredEvidence(context.Background()); err != nil || purged != 1 {
+		t.Fatalf("purged %d after retention, %v", purged, err)
+	}
+	if _, err := client.GetTransactionEvidence(context.Background(), webhookToken); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("purged evidence: %v", err)
+	}
+}
+
+func TestEvidenceRoute(t *testing.T) {
+	client, storage, _ := evidenceClient(t, newStubTransport(verifyWithCard()))
+	storeWebhookPayment(t, storage, StatusInit)
+	if _, err := client.VerifyPayment(context.Background(), webhookToken); err != nil {
+		t.Fatal(err)
+	}
+
+	evidence := func(token, adminKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/payments/transactions/"+token+"/evidence", nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		req.Header.Set(AdminKeyHeader, adminKey)
+		rec := httptest.NewRecorder()
+		client.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := evidence(webhookToken, "wrong-key"); rec.Code != http.StatusForbidden {
+		t.Fatalf("wrong admin key: status %d", rec.Code)
+	}
+	if rec := evidence("tok-missing", "admin-key"); rec.Code != http.StatusNotFound {
+		t.Fatalf("missing evidence: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := evidence(webhookToken, "admin-key")
+	var got TransactionEvidence
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got.Token != webhookToken || len(got.VerifyResponse) == 0 || strings.Contains(rec.Body.String(), "9912") {
+		t.Fatalf("evidence %s", rec.Body)
+	}
+}
@@ -0,0 +1,113 @@
+package vandargo_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/uussoop/vandargo"
+)
+
+// newExampleClient creates a client answered by the in-process simulator, with
+// memory storage and a logger that stays quiet so example output is stable
+func newExampleClient() *vandargo.Client {
+	config, err := vandargo.NewConfig(vandargo.Config{
+		APIKey:      "simulator",
+		BaseURL:     vandargo.SandboxBaseURL,
+		SandboxMode: true,
+		Timeout:     30,
+		CallbackURL: "https://shop.example.com/payments/callback",
+	})
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	storage := vandargo.NewMemoryStorage()
+	logger := vandargo.NewDefaultLoggerWithOutput("ERROR", io.Discard, io.Discard)
+
+	client, err := vandargo.NewClient(config, storage, logger)
+	if err != nil {
+		log.Fatalf("Failed to create Vandar client: %v", err)
+	}
+	return client.WithHTTPClient(vandargo.NewSimulatorTransport(vandargo.WithSimulatorPaidAfter(2)))
+}
+
+func ExampleNewConfig() {
+	_, err := vandargo.NewConfig(vandargo.Config{
+		BaseURL:     vandargo.SandboxBaseURL,
+		SandboxMode: true,
+		Timeout:     30,
+		CallbackURL: "https://shop.example.com/payments/callback",
+	})
+	fmt.Println(err)
+	// Output: api key is required
+}
+
+func ExampleClient_WaitForPayment() {
+	client := newExampleClient()
+	ctx := context.Background()
+
+	response, err := client.InitiatePayment(ctx, 1000000, "Payment for product", map[string]string{"order_id": "ORD-98765"})
+	if err != nil {
+		log.Fatalf("Failed to initiate payment: %v", err)
+	}
+
+	// Poll until the payer finished on the gateway page
+	result, err := client.WaitForPayment(ctx, response.Token,
+		vandargo.WithWaitInterval(time.Millisecond),
+		vandargo.WithWaitProgress(func(attempt int, result *vandargo.PaymentResult, err error) {
+			if err == nil {
+				fmt.Printf("Poll %d: %s\n", attempt, result.State)
+			}
+		}),
+	)
+	if err != nil {
+		log.Fatalf("Payment did not complete: %v", err)
+	}
+
+	verified, err := client.VerifyPayment(ctx, response.Token)
+	if err != nil {
+		log.Fatalf("Failed to verify payment: %v", err)
+	}
+	fmt.Printf("Payment %s, verified %s Rials\n", result.State, verified.Amount)
+	// Output:
+	// Poll 1: INIT
+	// Poll 2: PAID
+	// Payment PAID, verified 1000000 Rials
+}
+
+func ExampleClient_RegisterRoutes() {
+	client := newExampleClient()
+
+	// Mount the payment routes on a standard library mux next to your own routes
+	mux := http.NewServeMux()
+	client.RegisterRoutes(vandargo.NewServeMuxRouter(mux))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/payments/init", strings.NewReader(
+		`{"amount":1000000,"callback_url":"https://shop.example.com/payments/callback","description":"Payment for product"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer simulator")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Token     string `json:"token"`
+		SessionID string `json:"session_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(resp.StatusCode, body.Token != "", body.SessionID != "")
+	// Output: 200 true true
+}
@@ -0,0 +1,263 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// failover.go implements a PaymentProvider that fails over between gateways
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultFailoverThreshold is the number of consecutive outages that opens a provider's breaker
+	defaultFailoverThreshold = 3
+
+	// defaultFailoverCooldown is how long an open breaker skips its provider
+	defaultFailoverCooldown = 30 * time.Second
+)
+
+// providerState tracks the health of one provider
+type providerState struct {
+	provider  PaymentProvider
+	failures  int
+	openUntil time.Time
+}
+
+// FailoverProvider initializes payments with the first healthy provider and routes
+// follow-up calls for a token to the provider that issued it. A provider whose
+// calls keep failing with outages is skipped for a cooldown period.
+type FailoverProvider struct {
+	providers []*providerState
+	threshold int
+	cooldown  time.Duration
+	metrics   MetricsInterface
+
+	mutex  sync.Mutex
+	owners map[string]PaymentProvider
+
+	// Owner optionally resolves the provider name of a token issued before a restart,
+	// e.g. from the ProviderMetadataKey of the stored transaction
+	Owner func(ctx context.Context, token string) (string, bool)
+}
+
+// NewFailoverProvider creates a failover provider trying providers in the given order
+func NewFailoverProvider(providers ...PaymentProvider) (*FailoverProvider, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("at least one provider is required")
+	}
+
+	states := make([]*providerState, 0, len(providers))
+	for _, provider := range providers {
+		if provider == nil {
+			return nil, fmt.Errorf("provider cannot be nil")
+		}
+		states = append(states, &providerState{provider: provider})
+	}
+
+	return &FailoverProvider{
+		providers: states,
+		threshold: defaultFailoverThreshold,
+		cooldown:  defaultFailoverCooldown,
+		metrics:   noopMetrics{},
+		owners:    make(map[string]PaymentProvider),
+	}, nil
+}
+
+// WithBreaker sets how many consecutive outages open a provider's breaker and for how long
+func (f *FailoverProvider) WithBreaker(threshold int, cooldown time.Duration) *FailoverProvider {
+	if threshold > 0 {
+		f.threshold = threshold
+	}
+	if cooldown > 0 {
+		f.cooldown = cooldown
+	}
+	return f
+}
+
+// WithMetrics reports the state of each provider's breaker in the
+// MetricCircuitBreakerOpen gauge
+func (f *FailoverProvider) WithMetrics(metrics MetricsInterface) *FailoverProvider {
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.metrics = metrics
+	now := time.Now()
+	for _, state := range f.providers {
+		f.reportBreaker(state, now)
+	}
+	return f
+}
+
+// reportBreaker sets the breaker gauge of a provider. The caller holds the mutex.
+func (f *FailoverProvider) reportBreaker(state *providerState, now time.Time) {
+	open := 0.0
+	if now.Before(state.openUntil) {
+		open = 1
+	}
+	f.metrics.SetGauge(MetricCircuitBreakerOpen, open, map[string]string{"provider": state.provider.Name()})
+}
+
+// Name returns the names of the wrapped providers
+func (f *FailoverProvider) Name() string {
+	names := make([]string, 0, len(f.providers))
+	for _, state := range f.providers {
+		names = append(names, state.provider.Name())
+	}
+	return "failover(" + strings.Join(names, ",") + ")"
+}
+
+// Healthy reports whether a provider's breaker is closed
+func (f *FailoverProvider) Healthy(name string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, state := range f.providers {
+		if state.provider.Name() == name {
+			return time.Now().After(state.openUntil)
+		}
+	}
+	return false
+}
+
+// Init initializes the payment with the first healthy provider
+func (f *FailoverProvider) Init(ctx context.Context, req *PaymentInitRequest) (*InitResult, error) {
+	var errs []error
+	for _, state := range f.candidates() {
+		result, err := state.provider.Init(ctx, req)
+		f.record(state, err)
+		if err == nil {
+			f.mutex.Lock()
+			f.owners[result.Token] = state.provider
+			f.mutex.Unlock()
+			return result, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", state.provider.Name(), err))
+
+		// Only outages are worth trying elsewhere
+		if !isProviderOutage(err) {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("all providers failed: %w", errors.Join(errs...))
+}
+
+// Verify verifies the payment with the provider that issued the token
+func (f *FailoverProvider) Verify(ctx context.Context, token string) (*PaymentResult, error) {
+	state, err := f.owner(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := state.provider.Verify(ctx, token)
+	f.record(state, err)
+	return result, err
+}
+
+// Status returns the payment state from the provider that issued the token
+func (f *FailoverProvider) Status(ctx context.Context, token string) (*PaymentResult, error) {
+	state, err := f.owner(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := state.provider.Status(ctx, token)
+	f.record(state, err)
+	return result, err
+}
+
+// Refund refunds the payment with the first provider; refunds are not failed over
+// because the transaction ID only exists at the provider that processed it
+func (f *FailoverProvider) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	state := f.providers[0]
+	result, err := state.provider.Refund(ctx, req)
+	f.record(state, err)
+	return result, err
+}
+
+// candidates returns the providers in order, healthy ones first
+func (f *FailoverProvider) candidates() []*providerState {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	now := time.Now()
+	var healthy, open []*providerState
+	for _, state := range f.providers {
+		if now.Before(state.openUntil) {
+			open = append(open, state)
+		} else {
+			healthy = append(healthy, state)
+		}
+	}
+
+	// Fall back to open breakers rather than failing outright
+	return append(healthy, open...)
+}
+
+// owner returns the provider that issued a token
+func (f *FailoverProvider) owner(ctx context.Context, token string) (*providerState, error) {
+	f.mutex.Lock()
+	provider, found := f.owners[token]
+	f.mutex.Unlock()
+
+	name := ""
+	if found {
+		name = provider.Name()
+	} else if f.Owner != nil {
+		name, found = f.Owner(ctx, token)
+	}
+
+	// Tokens of unknown origin belong to the primary provider
+	if !found {
+		return f.providers[0], nil
+	}
+
+	for _, state := range f.providers {
+		if state.provider.Name() == name {
+			return state, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unknown provider %q for token", ErrNotFound, name)
+}
+
+// record updates a provider's breaker after a call
+func (f *FailoverProvider) record(state *providerState, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer f.reportBreaker(state, time.Now())
+
+	if err == nil || !isProviderOutage(err) {
+		state.failures = 0
+		return
+	}
+
+	state.failures++
+	if state.failures >= f.threshold {
+		state.openUntil = time.Now().Add(f.cooldown)
+		state.failures = 0
+	}
+}
+
+// isProviderOutage reports whether an error means the provider is unavailable
+// rather than that it rejected the request
+func isProviderOutage(err error) bool {
+	if IsNetworkError(err) || errors.Is(err, ErrGatewayUnavailable) {
+		return true
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return strings.HasPrefix(apiErr.Code, "5")
+	}
+
+	return false
+}
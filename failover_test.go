@@ -0,0 +1,239 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubProvider is a PaymentProvider returning a fixed init error and counting its calls
+type stubProvider struct {
+	name string
+
+	mutex   sync.Mutex
+	initErr error
+	inits   int
+	verifys int
+
+	// onInit runs before a successful Init returns, e.g. to store a transaction
+	onInit func(token string)
+}
+
+func (p *stubProvider) Name() string {
+	return p.name
+}
+
+func (p *stubProvider) Init(ctx context.Context, req *PaymentInitRequest) (*InitResult, error) {
+	p.mutex.Lock()
+	p.inits++
+	err := p.initErr
+	p.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := p.name + "-token"
+	if p.onInit != nil {
+		p.onInit(token)
+	}
+	return &InitResult{Provider: p.name, Token: token, PaymentURL: "https://" + p.name + ".example.com/" + token}, nil
+}
+
+func (p *stubProvider) Verify(ctx context.Context, token string) (*PaymentResult, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.verifys++
+	return &PaymentResult{Provider: p.name, Token: token}, nil
+}
+
+func (p *stubProvider) Status(ctx context.Context, token string) (*PaymentResult, error) {
+	return &PaymentResult{Provider: p.name, Token: token}, nil
+}
+
+func (p *stubProvider) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	return &RefundResult{Provider: p.name}, nil
+}
+
+func (p *stubProvider) setInitErr(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.initErr = err
+}
+
+func (p *stubProvider) calls() (inits, verifys int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.inits, p.verifys
+}
+
+func TestFailoverProviderFailsOver(t *testing.T) {
+	primary := &stubProvider{name: "primary", initErr: ErrGatewayUnavailable}
+	backup := &stubProvider{name: "backup"}
+	failover, err := NewFailoverProvider(primary, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := failover.Init(context.Background(), &PaymentInitRequest{Amount: 100000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Provider != "backup" {
+		t.Fatalf("served by %q, want backup", result.Provider)
+	}
+
+	// Follow-up calls go to the provider that issued the token
+	if _, err := failover.Verify(context.Background(), result.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, primaryVerifys := primary.calls(); primaryVerifys != 0 {
+		t.Fatalf("primary verified %d times", primaryVerifys)
+	}
+	if _, backupVerifys := backup.calls(); backupVerifys != 1 {
+		t.Fatalf("backup verified %d times", backupVerifys)
+	}
+
+	// A rejected request is not retried elsewhere
+	primary.setInitErr(&APIError{Code: "422", Message: "invalid amount"})
+	if _, err := failover.Init(context.Background(), &PaymentInitRequest{Amount: 100000}); err == nil {
+		t.Fatal("rejected init succeeded")
+	}
+	if backupInits, _ := backup.calls(); backupInits != 1 {
+		t.Fatalf("backup initialized %d times after a rejection", backupInits)
+	}
+}
+
+func TestFailoverProviderBreaker(t *testing.T) {
+	primary := &stubProvider{name: "primary", initErr: ErrGatewayUnavailable}
+	backup := &stubProvider{name: "backup"}
+	failover, err := NewFailoverProvider(primary, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := newRecordingMetrics()
+	failover.WithBreaker(2, 50*time.Millisecond).WithMetrics(metrics)
+
+	for i := 0; i < 2; i++ {
+		if _, err := failover.Init(context.Background(), &PaymentInitRequest{Amount: 100000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if failover.Healthy("primary") || !failover.Healthy("backup") {
+		t.Fatal("primary breaker did not open")
+	}
+
+	// An open breaker skips the primary
+	if _, err := failover.Init(context.Background(), &PaymentInitRequest{Amount: 100000}); err != nil {
+		t.Fatal(err)
+	}
+	if primaryInits, _ := primary.calls(); primaryInits != 2 {
+		t.Fatalf("primary initialized %d times with an open breaker", primaryInits)
+	}
+
+	// After the cooldown the primary is tried again and a success keeps it closed
+	time.Sleep(60 * time.Millisecond)
+	primary.setInitErr(nil)
+	result, err := failover.Init(context.Background(), &PaymentInitRequest{Amount: 100000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Provider != "primary" || !failover.Healthy("primary") {
+		t.Fatalf("served by %q after the cooldown", result.Provider)
+	}
+	if metrics.gauge(MetricCircuitBreakerOpen) != 0 {
+		t.Fatal("breaker gauge still open")
+	}
+
+	// A half-open primary that fails again falls back to the backup
+	primary.setInitErr(ErrGatewayUnavailable)
+	result, err = failover.Init(context.Background(), &PaymentInitRequest{Amount: 100000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Provider != "backup" {
+		t.Fatalf("served by %q, want backup", result.Provider)
+	}
+}
+
+func TestFailoverProviderAllFail(t *testing.T) {
+	failover, err := NewFailoverProvider(
+		&stubProvider{name: "primary", initErr: ErrGatewayUnavailable},
+		&stubProvider{name: "backup", initErr: ErrGatewayUnavailable},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Open breakers are still tried rather than failing outright
+	_, err = failover.Init(context.Background(), &PaymentInitRequest{Amount: 100000})
+	if !errors.Is(err, ErrGatewayUnavailable) {
+		t.Fatalf("expected ErrGatewayUnavailable, got %v", err)
+	}
+}
+
+func TestFailoverProviderRecordsProvider(t *testing.T) {
+	for _, stored := range []bool{false, true} {
+		primary := &stubProvider{name: "primary", initErr: ErrGatewayUnavailable}
+		backup := &stubProvider{name: "backup"}
+		failover, err := NewFailoverProvider(primary, backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, storage, logger := newTestClient(t, testConfig(t), nil, WithClientProvider(failover))
+
+		// A provider may store its own transaction, which keeps its metadata
+		if stored {
+			backup.onInit = func(token string) {
+				storage.StoreTransaction(context.Background(), &Transaction{
+					ID:       "tx-" + token,
+					Token:    token,
+					Amount:   100000,
+					Status:   StatusInit,
+					Metadata: map[string]string{"order": "1042"},
+				})
+			}
+		}
+
+		rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/init",
+			`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("stored %v: status %d: %s\n%s", stored, rec.Code, rec.Body, logger.dump())
+		}
+
+		transaction, err := storage.GetTransaction(context.Background(), "backup-token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transaction.Metadata[ProviderMetadataKey] != "backup" {
+			t.Fatalf("stored %v: provider metadata %v", stored, transaction.Metadata)
+		}
+		if stored && transaction.Metadata["order"] != "1042" {
+			t.Fatalf("provider record dropped metadata %v", transaction.Metadata)
+		}
+	}
+}
+
+func TestRecordProviderKeepsConcurrentStatus(t *testing.T) {
+	memory := NewMemoryStorage()
+	storeWebhookPayment(t, memory, StatusInit)
+	paid := StatusPaid
+	storage := &interleavingStorage{MemoryStorage: memory, after: func() {
+		// A callback settles the payment while the provider is being recorded
+		memory.PatchTransaction(context.Background(), webhookToken, TransactionPatch{Status: &paid})
+	}}
+
+	client, err := NewClient(testConfig(t), storage, &captureLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.recordProvider(context.Background(), webhookToken, "backup", &PaymentInitRequest{Amount: 100000})
+
+	transaction, _ := memory.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusPaid || transaction.Metadata[ProviderMetadataKey] != "backup" {
+		t.Fatalf("status %s, metadata %v", transaction.Status, transaction.Metadata)
+	}
+}
@@ -0,0 +1,90 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// form.go implements decoding of form-encoded request bodies
+package vandargo
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// formContentType is the media type of form-encoded request bodies
+const formContentType = "application/x-www-form-urlencoded"
+
+// parseRequestBody decodes a JSON or form-encoded request body into the given struct
+func parseRequestBody(r *http.Request, v interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != formContentType {
+		return parseJSONBody(r, v)
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	if len(body) == 0 {
+		return fmt.Errorf("request body is empty")
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return fmt.Errorf("failed to parse form data: %w", err)
+	}
+
+	return decodeForm(values, v)
+}
+
+// decodeForm fills struct fields from form values keyed by the fields' JSON names.
+// Conversion failures are reported together as validation errors.
+func decodeForm(values url.Values, v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form target must be a pointer to a struct")
+	}
+	target = target.Elem()
+
+	var validationErrors []ValidationError
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+
+		raw := strings.TrimSpace(values.Get(name))
+		if raw == "" {
+			continue
+		}
+
+		fieldValue := target.Field(i)
+		switch fieldValue.Kind() {
+		case reflect.String:
+			fieldValue.SetString(raw)
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				validationErrors = append(validationErrors, ValidationError{Field: name, Message: "must be a whole number"})
+				continue
+			}
+			fieldValue.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				validationErrors = append(validationErrors, ValidationError{Field: name, Message: "must be true or false"})
+				continue
+			}
+			fieldValue.SetBool(b)
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		return NewValidationErrors(validationErrors)
+	}
+
+	return nil
+}
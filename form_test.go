@@ -0,0 +1,169 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// postBody sends an authenticated POST with a body of the content type to handler
+func postBody(handler http.Handler, path, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPaymentEndpointsAcceptBothEncodings(t *testing.T) {
+	initForm := url.Values{
+		"amount":       {"100000"},
+		"callback_url": {"https://shop.example.com/callback"},
+		"description":  {"Order 1042"},
+		"mobile":       {"09121234567"},
+		"factorNumber": {"1042"},
+	}
+	initJSON := `{
+		"amount": 100000,
+		"callback_url": "https://shop.example.com/callback",
+		"description": "Order 1042",
+		"mobile": "09121234567",
+		"factorNumber": "1042"
+	}`
+
+	tests := []struct {
+		name        string
+		contentType string
+		initBody    string
+		verifyBody  func(token string) string
+	}{
+		{"JSON", "application/json", initJSON, func(token string) string {
+			return `{"token":"` + token + `"}`
+		}},
+		{"form", formContentType, initForm.Encode(), func(token string) string {
+			return url.Values{"token": {token}}.Encode()
+		}},
+		{"form with charset", formContentType + "; charset=utf-8", initForm.Encode(), func(token string) string {
+			return "token=" + token
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, storage, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(WithSimulatorPaidAfter(0)))
+			handler := client.Handler()
+
+			rec := postBody(handler, "/payments/init", tt.contentType, tt.initBody)
+			var initResp PaymentInitResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &initResp); err != nil || rec.Code != http.StatusOK || initResp.Token == "" {
+				t.Fatalf("init: status %d: %s", rec.Code, rec.Body)
+			}
+
+			transaction, err := storage.GetTransaction(context.Background(), initResp.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if transaction.Amount != 100000 || transaction.Description != "Order 1042" || transaction.FactorNumber != "1042" {
+				t.Fatalf("stored %+v", transaction)
+			}
+
+			postBody(handler, "/payments/callback", formContentType, "token="+initResp.Token+"&status=OK")
+
+			// Responses are JSON whatever the request encoding
+			rec = postBody(handler, "/payments/verify", tt.contentType, tt.verifyBody(initResp.Token))
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("verify: status %d, %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+			}
+		})
+	}
+}
+
+func TestFormFieldErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		form   string
+		errors map[string]interface{}
+	}{
+		{"not a number", "amount=abc&callback_url=https://shop.example.com/callback",
+			map[string]interface{}{"amount": "must be a whole number"}},
+		{"fraction", "amount=1000.5&callback_url=https://shop.example.com/callback",
+			map[string]interface{}{"amount": "must be a whole number"}},
+		{"overflow", "amount=99999999999999999999&callback_url=https://shop.example.com/callback",
+			map[string]interface{}{"amount": "must be a whole number"}},
+		{"bad bool", "amount=100000&callback_url=https://shop.example.com/callback&require_card_owner_match=maybe",
+			map[string]interface{}{"require_card_owner_match": "must be true or false"}},
+		{"conversion failures together", "amount=ten&require_card_owner_match=yes&callback_url=https://shop.example.com/callback",
+			map[string]interface{}{"amount": "must be a whole number", "require_card_owner_match": "must be true or false"}},
+		{"validation after conversion", "amount=10&callback_url=https://shop.example.com/callback",
+			map[string]interface{}{"amount": "amount must be at least 10000 Rials"}},
+		{"missing required field", "amount=100000",
+			map[string]interface{}{"callback_url": "callback URL is required"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _, _ := newTestClient(t, testConfig(t), newStubTransport())
+
+			rec := postBody(client.Handler(), "/payments/init", formContentType, tt.form)
+			var envelope map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil || rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+
+			errs, _ := envelope["errors"].(map[string]interface{})
+			if len(errs) != len(tt.errors) {
+				t.Fatalf("errors %v, want %v", errs, tt.errors)
+			}
+			for field, message := range tt.errors {
+				if errs[field] != message {
+					t.Errorf("errors[%s] = %v, want %v", field, errs[field], message)
+				}
+			}
+		})
+	}
+}
+
+func TestFormRejectedBodies(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), newStubTransport())
+	handler := client.Handler()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"empty form", formContentType, ""},
+		{"malformed escape", formContentType, "amount=%zz"},
+		{"plain text", "text/plain", "amount=100000"},
+		{"JSON sent as a form", formContentType, `{"amount":100000}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postBody(handler, "/payments/init", tt.contentType, tt.body)
+			if rec.Code < 400 || rec.Code >= 500 {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestDecodeFormTarget(t *testing.T) {
+	var notStruct string
+	if err := decodeForm(url.Values{}, &notStruct); err == nil || IsValidationError(err) {
+		t.Fatalf("decodeForm into a string: %v", err)
+	}
+
+	// Fields without a JSON name and fields of other kinds are left alone
+	var req PaymentInitRequest
+	values := url.Values{"metadata": {"x"}, "ClientInfo": {"x"}, "amount": {" 20000 "}}
+	if err := decodeForm(values, &req); err != nil || req.Amount != 20000 || req.Metadata != nil || req.ClientInfo != nil {
+		t.Fatalf("decodeForm() = %+v, %v", req, err)
+	}
+}
@@ -0,0 +1,89 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// gateway_auth.go implements detection of gateway responses rejecting the credentials
+package vandargo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// GatewayAuthCode is the error code of responses sent when the gateway rejected
+// the merchant credentials
+const GatewayAuthCode = "gateway_auth_failed"
+
+// gatewayAuthMessage is the handler response message for rejected credentials
+const gatewayAuthMessage = "The payment gateway rejected the merchant credentials. Check your API key."
+
+// GatewayAuthError reports that the gateway rejected the API key or access token,
+// either with 401/403 or by redirecting to its login page. It matches
+// ErrAuthentication, but handlers answer it with 502: the merchant's configuration
+// is at fault, not the caller.
+type GatewayAuthError struct {
+	// StatusCode is the HTTP status of the gateway response
+	StatusCode int
+
+	// Location is the redirect target, when the gateway redirected
+	Location string
+
+	// Message is the gateway's error message, when it sent JSON
+	Message string
+}
+
+// Error describes the rejected credentials
+func (e *GatewayAuthError) Error() string {
+	message := fmt.Sprintf("%s: payment gateway rejected the credentials (status %d), check your API key", ErrAuthentication, e.StatusCode)
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	return message
+}
+
+// Unwrap returns ErrAuthentication
+func (e *GatewayAuthError) Unwrap() error {
+	return ErrAuthentication
+}
+
+// noRedirects keeps the HTTP client from following gateway redirects, which only
+// happen when the credentials are rejected and lead to an HTML login page
+func noRedirects(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// gatewayAuthFailure classifies a gateway response rejecting the credentials,
+// returning nil for other responses. Custom HTTP clients may follow the redirect
+// themselves, so a response for a different path than requested counts as well.
+func gatewayAuthFailure(req *http.Request, resp *http.Response, body []byte) *GatewayAuthError {
+	redirected := resp.Request != nil && resp.Request.URL != nil && resp.Request.URL.Path != req.URL.Path
+
+	switch {
+	case resp.StatusCode >= 300 && resp.StatusCode < 400,
+		resp.StatusCode == http.StatusUnauthorized,
+		resp.StatusCode == http.StatusForbidden,
+		redirected:
+	default:
+		return nil
+	}
+
+	authErr := &GatewayAuthError{
+		StatusCode: resp.StatusCode,
+		Location:   resp.Header.Get("Location"),
+	}
+	if redirected && authErr.Location == "" {
+		authErr.Location = resp.Request.URL.String()
+	}
+
+	var apiErr APIError
+	if json.Unmarshal(body, &apiErr) == nil {
+		authErr.Message = redactBody(apiErr.Message)
+	}
+
+	return authErr
+}
+
+// isGatewayAuthError reports whether an error is a GatewayAuthError
+func isGatewayAuthError(err error) bool {
+	var authErr *GatewayAuthError
+	return errors.As(err, &authErr)
+}
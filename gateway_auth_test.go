@@ -0,0 +1,126 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// roundTripperFunc is an http.RoundTripper calling a function
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// loginPage is the HTML the gateway serves instead of JSON for a rejected key
+const loginPage = "<!DOCTYPE html><html><head><title>Login</title></head><body>Sign in</body></html>"
+
+func TestGatewayRedirectNotFollowed(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), nil)
+
+	// The default HTTP client keeps the redirect instead of fetching the login page
+	var loginFetched bool
+	client.httpClient.(*http.Client).Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/login" {
+			loginFetched = true
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/html"}},
+				Body:       io.NopCloser(strings.NewReader(loginPage)),
+				Request:    req,
+			}, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusFound,
+			Header:     http.Header{"Location": []string{"https://ipg.vandar.io/login"}},
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	})
+
+	_, err := client.InitiatePayment(context.Background(), 100000, "Order 1042", nil)
+	var authErr *GatewayAuthError
+	if !errors.As(err, &authErr) || !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("InitiatePayment() = %v, want a GatewayAuthError", err)
+	}
+	if loginFetched {
+		t.Fatal("client followed the redirect to the login page")
+	}
+	if authErr.StatusCode != http.StatusFound || authErr.Location != "https://ipg.vandar.io/login" {
+		t.Fatalf("auth error %+v", authErr)
+	}
+	if !strings.Contains(err.Error(), "check your API key") {
+		t.Fatalf("error %q doesn't point at the API key", err)
+	}
+}
+
+func TestGatewayAuthFailures(t *testing.T) {
+	html := http.Header{"Content-Type": []string{"text/html; charset=utf-8"}}
+	tests := []struct {
+		name   string
+		step   stubStep
+		status int
+	}{
+		{"redirect", stubStep{status: http.StatusFound, header: http.Header{"Location": []string{"https://ipg.vandar.io/login"}}}, http.StatusFound},
+		{"moved", stubStep{status: http.StatusMovedPermanently, header: http.Header{"Location": []string{"https://vandar.io/"}}}, http.StatusMovedPermanently},
+		{"html 401", stubStep{status: http.StatusUnauthorized, body: loginPage, header: html}, http.StatusUnauthorized},
+		{"json 403", jsonStep(http.StatusForbidden, map[string]interface{}{"status": 0, "message": "Access denied"}), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newStubTransport(tt.step)
+			client, _, _ := newTestClient(t, testConfig(t), transport)
+
+			_, err := client.InitiatePayment(context.Background(), 100000, "Order 1042", nil)
+			var authErr *GatewayAuthError
+			if !errors.As(err, &authErr) || authErr.StatusCode != tt.status || !errors.Is(err, ErrAuthentication) {
+				t.Fatalf("InitiatePayment() = %v, want a GatewayAuthError with status %d", err, tt.status)
+			}
+			if strings.Contains(err.Error(), "<html") {
+				t.Fatalf("error %q includes the HTML page", err)
+			}
+			if transport.count() != 1 {
+				t.Fatalf("%d requests, rejected credentials must not be retried", transport.count())
+			}
+
+			// Handlers blame the gateway configuration, not the caller
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/payments/init", strings.NewReader(`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+testAPIKey)
+			client.Handler().ServeHTTP(rec, req)
+
+			body := rec.Body.String()
+			if rec.Code != http.StatusBadGateway || !strings.Contains(body, GatewayAuthCode) || !strings.Contains(body, "Check your API key") {
+				t.Fatalf("handler: status %d: %s", rec.Code, body)
+			}
+		})
+	}
+}
+
+func TestGatewayAuthFollowedRedirect(t *testing.T) {
+	// A custom HTTP client that follows redirects lands on the login page
+	transport := transportFunc(func(req *http.Request) (*http.Response, error) {
+		login := req.Clone(req.Context())
+		login.URL.Path = "/login"
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/html"}},
+			Body:       io.NopCloser(strings.NewReader(loginPage)),
+			Request:    login,
+		}, nil
+	})
+	client, _, _ := newTestClient(t, testConfig(t), transport)
+
+	_, err := client.InitiatePayment(context.Background(), 100000, "Order 1042", nil)
+	var authErr *GatewayAuthError
+	if !errors.As(err, &authErr) || !strings.HasSuffix(authErr.Location, "/login") {
+		t.Fatalf("InitiatePayment() = %v, want a GatewayAuthError for the login page", err)
+	}
+}
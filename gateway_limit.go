@@ -0,0 +1,88 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// gateway_limit.go implements capping the number of concurrent requests to the gateway
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultGatewayCallWait is how long a request waits for a free slot when
+	// Config.GatewayCallWait is not set
+	defaultGatewayCallWait = 5 * time.Second
+
+	// gatewayBusyRetryAfter is the Retry-After sent with ErrTooManyInFlight
+	gatewayBusyRetryAfter = time.Second
+)
+
+// gatewayLimiter counts the requests in flight to the gateway and, with a
+// limit, makes further requests wait for a free slot. Clones share it.
+type gatewayLimiter struct {
+	// slots holds a token per request in flight, nil without a limit
+	slots chan struct{}
+
+	// wait is how long a request waits for a slot
+	wait time.Duration
+
+	// inFlight is the number of requests in flight
+	inFlight atomic.Int64
+}
+
+// newGatewayLimiter creates a limiter allowing limit concurrent requests, any
+// number when zero or less
+func newGatewayLimiter(limit int, wait time.Duration) *gatewayLimiter {
+	if wait <= 0 {
+		wait = defaultGatewayCallWait
+	}
+
+	limiter := &gatewayLimiter{wait: wait}
+	if limit > 0 {
+		limiter.slots = make(chan struct{}, limit)
+	}
+	return limiter
+}
+
+// acquire takes a slot, waiting until one is free, the wait has passed or ctx
+// is done, and returns the function releasing it
+func (l *gatewayLimiter) acquire(ctx context.Context, clock Clock, metrics MetricsInterface) (func(), error) {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			timer := clock.NewTimer(l.wait)
+			select {
+			case l.slots <- struct{}{}:
+				timer.Stop()
+			case <-timer.C():
+				metrics.IncCounter(MetricGatewayConcurrencyRejections, nil)
+				return nil, fmt.Errorf("%w: no free slot within %s", ErrTooManyInFlight, l.wait)
+			case <-ctx.Done():
+				timer.Stop()
+				metrics.IncCounter(MetricGatewayConcurrencyRejections, nil)
+				return nil, fmt.Errorf("%w: %w", ErrTooManyInFlight, ctx.Err())
+			}
+		}
+	}
+
+	metrics.SetGauge(MetricGatewayRequestsInFlight, float64(l.inFlight.Add(1)), nil)
+
+	var released atomic.Bool
+	return func() {
+		if !released.CompareAndSwap(false, true) {
+			return
+		}
+		metrics.SetGauge(MetricGatewayRequestsInFlight, float64(l.inFlight.Add(-1)), nil)
+		if l.slots != nil {
+			<-l.slots
+		}
+	}, nil
+}
+
+// GatewayRequestsInFlight returns the number of requests to the gateway in
+// flight, shared by the client and its clones
+func (c *Client) GatewayRequestsInFlight() int64 {
+	return c.gatewayCalls.inFlight.Load()
+}
@@ -0,0 +1,163 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// heldGateway answers every request once released, signalling started when
+// a request arrives
+type heldGateway struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newHeldGateway() *heldGateway {
+	return &heldGateway{started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (g *heldGateway) Do(req *http.Request) (*http.Response, error) {
+	g.started <- struct{}{}
+	select {
+	case <-g.release:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	return stubResponse(req, http.StatusOK, map[string]interface{}{"status": true}), nil
+}
+
+// holdSlots starts count status lookups on the slow gateway and waits until
+// each reached it; the returned function waits for them to finish
+func holdSlots(t *testing.T, client *Client, gateway *heldGateway, count int) func() {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Distinct tokens, as lookups of one token are coalesced
+			client.GetPaymentStatus(context.Background(), fmt.Sprintf("held%016d", i))
+		}(i)
+		select {
+		case <-gateway.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("request did not reach the gateway")
+		}
+	}
+	return wg.Wait
+}
+
+func TestGatewayConcurrencyRejection(t *testing.T) {
+	gateway := newHeldGateway()
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	metrics := newRecordingMetrics()
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.MaxConcurrentGatewayCalls = 2
+		c.GatewayCallWait = time.Second
+		c.MaxRetries = 3
+	}), gateway, WithClientClock(clock), WithClientMetrics(metrics))
+
+	wait := holdSlots(t, client, gateway, 2)
+	if client.GatewayRequestsInFlight() != 2 || metrics.gauge(MetricGatewayRequestsInFlight) != 2 {
+		t.Fatalf("in flight %d, gauge %v", client.GatewayRequestsInFlight(), metrics.gauge(MetricGatewayRequestsInFlight))
+	}
+
+	// A third request waits for a slot and gives up after the wait
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Clone().GetPaymentStatus(context.Background(), webhookToken)
+		errs <- err
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	err := <-errs
+	if !errors.Is(err, ErrTooManyInFlight) || errorToStatus(err) != http.StatusServiceUnavailable {
+		t.Fatalf("GetPaymentStatus() error = %v", err)
+	}
+	if len(gateway.started) != 0 {
+		t.Fatal("rejected request reached the gateway")
+	}
+	if metrics.counter(MetricGatewayConcurrencyRejections) != 1 {
+		t.Fatalf("%d rejections counted", metrics.counter(MetricGatewayConcurrencyRejections))
+	}
+
+	// Slots free up once the slow responses arrive
+	close(gateway.release)
+	wait()
+	if client.GatewayRequestsInFlight() != 0 || metrics.gauge(MetricGatewayRequestsInFlight) != 0 {
+		t.Fatalf("in flight %d after the responses", client.GatewayRequestsInFlight())
+	}
+	if _, err := client.GetPaymentStatus(context.Background(), webhookToken); err != nil {
+		t.Fatalf("GetPaymentStatus() after the slots freed: %v", err)
+	}
+}
+
+func TestGatewayConcurrencyContextDeadline(t *testing.T) {
+	gateway := newHeldGateway()
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.MaxConcurrentGatewayCalls = 1
+		c.GatewayCallWait = time.Minute
+	}), gateway)
+	wait := holdSlots(t, client, gateway, 1)
+	defer func() {
+		close(gateway.release)
+		wait()
+	}()
+
+	// The caller's deadline ends the wait before GatewayCallWait
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.GetPaymentStatus(ctx, webhookToken); !errors.Is(err, ErrTooManyInFlight) {
+		t.Fatalf("GetPaymentStatus() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("waited %v for a slot", elapsed)
+	}
+}
+
+func TestGatewayConcurrencyHandler(t *testing.T) {
+	gateway := newHeldGateway()
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.MaxConcurrentGatewayCalls = 1
+		c.GatewayCallWait = 10 * time.Millisecond
+	}), gateway)
+	wait := holdSlots(t, client, gateway, 1)
+	defer func() {
+		close(gateway.release)
+		wait()
+	}()
+
+	req := httptest.NewRequest(http.MethodGet, "/payments/status?token="+webhookToken, nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	rec := httptest.NewRecorder()
+	client.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("status %d, Retry-After %q: %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+}
+
+func TestGatewayConcurrencyUnlimited(t *testing.T) {
+	gateway := newHeldGateway()
+	client, _, _ := newTestClient(t, testConfig(t), gateway)
+
+	// Without a limit requests are only counted
+	wait := holdSlots(t, client, gateway, 10)
+	if client.GatewayRequestsInFlight() != 10 {
+		t.Fatalf("in flight %d", client.GatewayRequestsInFlight())
+	}
+	close(gateway.release)
+	wait()
+	if client.GatewayRequestsInFlight() != 0 {
+		t.Fatalf("in flight %d after the responses", client.GatewayRequestsInFlight())
+	}
+}
@@ -0,0 +1,140 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// gateway_status.go implements normalization of the gateway's transaction statuses
+package vandargo
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+// gatewayStatuses maps the transaction statuses the gateway has reported over its
+// versions, folded by foldGatewayStatus, onto TransactionStatus
+var gatewayStatuses = map[string]TransactionStatus{
+	// Pending
+	"INIT":             StatusInit,
+	"NEW":              StatusInit,
+	"CREATED":          StatusInit,
+	"PENDING":          StatusInit,
+	"WAITING":          StatusInit,
+	"IN_PROGRESS":      StatusInit,
+	"PROCESSING":       StatusInit,
+	"در_انتظار":        StatusInit,
+	"در_انتظار_پرداخت": StatusInit,
+	"در_حال_پرداخت":    StatusInit,
+	"پرداخت_نشده":      StatusInit,
+
+	// Queued locally while the gateway is unreachable
+	"QUEUED": StatusQueued,
+
+	// Paid
+	"PAID":        StatusPaid,
+	"SUCCEED":     StatusPaid,
+	"SUCCEEDED":   StatusPaid,
+	"SUCCESS":     StatusPaid,
+	"SUCCESSFUL":  StatusPaid,
+	"VERIFIED":    StatusPaid,
+	"COMPLETED":   StatusPaid,
+	"موفق":        StatusPaid,
+	"پرداخت_شده":  StatusPaid,
+	"پرداخت_موفق": StatusPaid,
+	"تایید_شده":   StatusPaid,
+
+	// Failed
+	"FAILED":        StatusFailed,
+	"FAIL":          StatusFailed,
+	"FAILURE":       StatusFailed,
+	"UNSUCCESSFUL":  StatusFailed,
+	"CANCELED":      StatusFailed,
+	"CANCELLED":     StatusFailed,
+	"REJECTED":      StatusFailed,
+	"DECLINED":      StatusFailed,
+	"REVERSED":      StatusFailed,
+	"ناموفق":        StatusFailed,
+	"پرداخت_ناموفق": StatusFailed,
+	"لغو_شده":       StatusFailed,
+	"برگشت_خورده":   StatusFailed,
+
+	// Expired
+	"EXPIRED":   StatusExpired,
+	"TIMEOUT":   StatusExpired,
+	"منقضی_شده": StatusExpired,
+
+	// Refunded
+	"REFUNDED":   StatusRefunded,
+	"مسترد_شده":  StatusRefunded,
+	"بازگشت_وجه": StatusRefunded,
+}
+
+// persianLetters maps Arabic letter forms the gateway sometimes sends to their
+// Persian equivalents
+var persianLetters = strings.NewReplacer("ي", "ی", "ى", "ی", "ك", "ک")
+
+// foldGatewayStatus normalizes case, Arabic letter forms and separators so
+// variants like "Succeed", "in-progress" and "پرداخت‌شده" match the same key
+func foldGatewayStatus(status string) string {
+	status = persianLetters.Replace(strings.ToUpper(strings.TrimSpace(status)))
+	fields := strings.FieldsFunc(status, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '_' || r == '\u200c'
+	})
+	return strings.Join(fields, "_")
+}
+
+// NormalizeTransactionStatus maps a gateway transaction status onto
+// TransactionStatus, ignoring case, separators and Arabic letter forms. Unknown
+// statuses are reported as INIT with ok false, as the payment may still complete.
+func NormalizeTransactionStatus(status string) (normalized TransactionStatus, ok bool) {
+	if normalized, ok := gatewayStatuses[foldGatewayStatus(status)]; ok {
+		return normalized, true
+	}
+	return StatusInit, false
+}
+
+// NormalizedStatus returns TransactionStatus normalized onto the package's statuses
+func (r *PaymentStatusResponse) NormalizedStatus() TransactionStatus {
+	status, _ := NormalizeTransactionStatus(r.TransactionStatus)
+	return status
+}
+
+// normalizeStatusResponse normalizes the status of a status check, logging
+// unrecognized values so the table can be extended
+func (c *Client) normalizeStatusResponse(ctx context.Context, token string, resp *PaymentStatusResponse) TransactionStatus {
+	status, ok := NormalizeTransactionStatus(resp.TransactionStatus)
+	if !ok && resp.TransactionStatus != "" {
+		c.log(ctx).Warn(ctx, "Unrecognized gateway transaction status", map[string]interface{}{
+			"token":      redactToken(token),
+			"raw_status": resp.TransactionStatus,
+		})
+	}
+	return status
+}
+
+// syncStatusCheck records a failed or expired status check result on the stored
+// transaction. Paid payments are left to verification, which records their
+// details. The transaction is read again under the token lock so concurrent
+// verifications and callbacks win.
+func (c *Client) syncStatusCheck(ctx context.Context, token string, status TransactionStatus) {
+	if status != StatusFailed && status != StatusExpired {
+		return
+	}
+
+	release := c.tokenLocks.Lock(token)
+	defer release()
+
+	transaction, err := c.storage.GetTransaction(ctx, token)
+	if err != nil || !transaction.Status.CanTransitionTo(status) || transaction.Status.IsTerminal() {
+		return
+	}
+
+	previousStatus := transaction.Status
+	patch := TransactionPatch{Status: &status}
+	c.applyPatch(&patch, transaction)
+
+	if err := c.patchTransaction(ctx, token, patch); err != nil {
+		c.log(ctx).Error(ctx, "Failed to update transaction from status check", err, transactionLogFields(transaction))
+		return
+	}
+	c.invalidateCache(ctx, token)
+
+	c.fireStatusChange(ctx, transaction, previousStatus)
+}
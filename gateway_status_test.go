@@ -0,0 +1,99 @@
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeTransactionStatus(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want TransactionStatus
+		ok   bool
+	}{
+		{"SUCCEED", StatusPaid, true},
+		{"Succeed", StatusPaid, true},
+		{" paid ", StatusPaid, true},
+		{"verified", StatusPaid, true},
+		{"FAILED", StatusFailed, true},
+		{"Cancelled", StatusFailed, true},
+		{"in-progress", StatusInit, true},
+		{"In Progress", StatusInit, true},
+		{"pending", StatusInit, true},
+		{"EXPIRED", StatusExpired, true},
+		{"refunded", StatusRefunded, true},
+		{"موفق", StatusPaid, true},
+		{"پرداخت شده", StatusPaid, true},
+		{"پرداخت‌شده", StatusPaid, true},
+		{"پرداخت_ناموفق", StatusFailed, true},
+		{"ناموفق", StatusFailed, true},
+		{"لغو شده", StatusFailed, true},
+		{"تاييد شده", StatusPaid, true},
+		{"منقضي شده", StatusExpired, true},
+		{"در انتظار پرداخت", StatusInit, true},
+		{"مسترد شده", StatusRefunded, true},
+		{"", StatusInit, false},
+		{"SOMETHING_NEW", StatusInit, false},
+		{"PAIDX", StatusInit, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := NormalizeTransactionStatus(tt.raw)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("NormalizeTransactionStatus(%q) = %s, %v; want %s, %v", tt.raw, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// Every table key is already folded, so it can be matched at all
+	for key, status := range gatewayStatuses {
+		if folded := foldGatewayStatus(key); folded != key {
+			t.Errorf("table key %q folds to %q", key, folded)
+		}
+		if !status.IsValid() {
+			t.Errorf("table key %q maps to unknown status %q", key, status)
+		}
+	}
+
+	response := PaymentStatusResponse{TransactionStatus: "Succeeded"}
+	if response.NormalizedStatus() != StatusPaid {
+		t.Fatalf("NormalizedStatus() = %s", response.NormalizedStatus())
+	}
+}
+
+func TestStatusCheckNormalizesGatewayStatus(t *testing.T) {
+	tests := []struct {
+		raw    string
+		stored TransactionStatus
+		warned bool
+	}{
+		{"ناموفق", StatusFailed, false},
+		{"Expired", StatusExpired, false},
+		{"in progress", StatusInit, false},
+		{"ON_HOLD", StatusInit, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+				"status":            true,
+				"transactionStatus": tt.raw,
+			}))
+			client, storage, logger := newTestClient(t, testConfig(t), transport)
+			storeWebhookPayment(t, storage, StatusInit)
+
+			if _, err := client.GetPaymentStatus(context.Background(), webhookToken); err != nil {
+				t.Fatal(err)
+			}
+			if transaction, _ := storage.GetTransaction(context.Background(), webhookToken); transaction.Status != tt.stored {
+				t.Fatalf("stored status %s, want %s", transaction.Status, tt.stored)
+			}
+
+			// Unrecognized values are logged raw so the table can be extended
+			entry, warned := logger.find("Unrecognized gateway transaction status")
+			if warned != tt.warned || (warned && (entry.level != "warn" || entry.fields["raw_status"] != tt.raw)) {
+				t.Fatalf("warning %v: %+v", warned, entry)
+			}
+		})
+	}
+}
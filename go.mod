@@ -1,3 +1,14 @@
 module github.com/uussoop/vandargo
 
 go 1.23.3
+
+require (
+	github.com/alicebob/miniredis/v2 v2.37.0
+	github.com/makiuchi-d/gozxing v0.1.1
+)
+
+require (
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	golang.org/x/text v0.3.7 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+)
@@ -5,242 +5,304 @@ package vandargo
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"time"
 )
 
 // RegisterRoutes registers all the handlers with the provided router
-func (c *Client) RegisterRoutes(router RouterInterface) {
-	// Payment initialization
-	router.POST("/payments/init", Chain(
-		c.handlePaymentInit,
-		RequestIDMiddleware(),
-		LoggingMiddleware(c.logger),
-		SecurityHeadersMiddleware(),
-		RateLimitMiddleware(10, 60),
-		AuthMiddleware(c.config),
-	))
-
-	// Payment verification
-	router.POST("/payments/verify", Chain(
-		c.handlePaymentVerify,
-		RequestIDMiddleware(),
-		LoggingMiddleware(c.logger),
-		SecurityHeadersMiddleware(),
-		RateLimitMiddleware(10, 60),
-		AuthMiddleware(c.config),
-	))
-
-	// Payment status check
-	router.GET("/payments/status", Chain(
-		c.handlePaymentStatus,
-		RequestIDMiddleware(),
-		LoggingMiddleware(c.logger),
-		SecurityHeadersMiddleware(),
-		RateLimitMiddleware(20, 60),
-		AuthMiddleware(c.config),
-	))
-
-	// Refund
-	router.POST("/payments/refund", Chain(
-		c.handleRefund,
-		RequestIDMiddleware(),
-		LoggingMiddleware(c.logger),
-		SecurityHeadersMiddleware(),
-		RateLimitMiddleware(5, 60),
-		AuthMiddleware(c.config),
-	))
-
-	// Callback
-	router.POST("/payments/callback", Chain(
-		c.handleCallback,
-		RequestIDMiddleware(),
-		LoggingMiddleware(c.logger),
-		SecurityHeadersMiddleware(),
-		IPFilterMiddleware(c.config),
-	))
-
-	// Transaction info
-	router.GET("/payments/transaction-info", Chain(
-		c.handleTransactionInfo,
-		RequestIDMiddleware(),
-		LoggingMiddleware(c.logger),
-		SecurityHeadersMiddleware(),
-		RateLimitMiddleware(20, 60),
-		AuthMiddleware(c.config),
-	))
+func (c *Client) RegisterRoutes(router RouterInterface, opts ...RouteOption) {
+	c.RegisterRoutesWithPrefix(router, "", opts...)
+}
+
+// RegisterRoutesWithPrefix registers all the handlers under a path prefix such as
+// "/api/v1/psp/vandar". Route options keep referring to the unprefixed paths.
+// Path parameters use the {name} syntax of http.ServeMux; routers with another
+// syntax still work because handlers match the request path against the pattern.
+func (c *Client) RegisterRoutesWithPrefix(router RouterInterface, prefix string, opts ...RouteOption) {
+	options := newRouteOptions(opts)
+	prefix = normalizePrefix(prefix)
+
+	var browserPaths []string
+	for _, route := range c.routeTable() {
+		if !options.includes(route) {
+			continue
+		}
+
+		fullPath := prefix + route.path
+		handler := Chain(route.handler, c.routeChain(route, fullPath, options)...)
+
+		switch route.method {
+		case http.MethodGet:
+			router.GET(fullPath, handler)
+		default:
+			router.POST(fullPath, handler)
+		}
+
+		if route.policy == policyAuthenticated {
+			browserPaths = append(browserPaths, fullPath)
+		}
+	}
+
+	// OpenAPI document
+	if options.openAPI {
+		router.GET(prefix+openAPIPath, Chain(
+			c.handleOpenAPI(prefix, options),
+			routeMiddleware(prefix+openAPIPath),
+			RequestIDMiddlewareWithGenerator(c.idGenerator()),
+			CorrelationMiddleware(c.config, c.idGenerator()),
+			LoggingMiddleware(c.logger, options.loggingFor(openAPIPath)...),
+			SecurityHeadersMiddleware(),
+		))
+	}
+
+	// CORS preflight for browser-facing routes
+	options.registerPreflight(router, browserPaths...)
 }
 
 // handlePaymentInit handles payment initialization requests
 func (c *Client) handlePaymentInit(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// Parse request body
+	// Parse request body, accepting JSON or form-encoded data
 	var req PaymentInitRequest
-	if err := parseJSONBody(r, &req); err != nil {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+	if err := parseRequestBody(r, &req); err != nil {
+		c.respondInvalid(w, err)
 		return
 	}
 
 	// Validate request
-	if err := ValidatePaymentInitRequest(&req); err != nil {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+	if err := c.validator().ValidatePaymentInitRequest(&req); err != nil {
+		c.respondInvalid(w, err)
 		return
 	}
 
-	// Set callback URL from config if not provided
-	if req.CallbackURL == "" {
-		req.CallbackURL = c.config.GetCallbackURL()
+	// Stop bots before they reach the gateway
+	if err := c.checkChallenge(r, &req); err != nil {
+		c.respondWithError(w, err, "")
+		return
 	}
 
-	// Prepare API request body
-	apiReq := map[string]interface{}{
-		"amount":       req.Amount,
-		"callback_url": req.CallbackURL,
+	// Record the payer's device for fraud analysis
+	req.ClientInfo = clientInfoFromRequest(r)
+
+	// Refuse payers initiating too many or too large payments
+	if err := c.checkVelocity(ctx, &req); err != nil {
+		c.respondWithError(w, err, "")
+		return
 	}
 
-	if req.Description != "" {
-		apiReq["description"] = req.Description
+	// Fill in the configured description
+	if req.Description == "" {
+		req.Description = c.defaultDescription(ctx, req.Metadata)
 	}
 
-	if req.Mobile != "" {
-		apiReq["mobile"] = req.Mobile
+	// Delegate to the configured payment provider
+	if c.provider != nil {
+		c.initWithProvider(w, r, &req)
+		return
 	}
 
-	if req.FactorNumber != "" {
-		apiReq["factorNumber"] = req.FactorNumber
+	// Set callback URL from config if not provided, otherwise check it is allowed
+	callbackURL, err := c.resolveCallbackURL(req.CallbackURL)
+	if err != nil {
+		c.respondInvalid(w, err)
+		return
 	}
+	req.CallbackURL = callbackURL
 
-	if req.ValidCardNumber != "" {
-		apiReq["valid_card_number"] = req.ValidCardNumber
+	// Suppress duplicate payments for the same factor number
+	existing, release, err := c.reserveFactorNumber(ctx, req.FactorNumber)
+	if err != nil {
+		c.respondWithError(w, ErrDuplicatePayment, "A payment for this factor number is already in progress")
+		return
 	}
+	defer release()
+	if existing != nil {
+		if existing.Status == StatusQueued {
+			c.respondQueuedInit(w, existing)
+			return
+		}
+		reused := reusedInitResponse(existing)
+		reused.SessionID = c.issueSessionID(ctx, existing.Token, existing.ExpiresAt)
+		c.respondWithJSON(w, http.StatusOK, reused)
+		return
+	}
+
+	// Don't create a token nobody will use
+	if c.abandoned(ctx, "init") {
+		return
+	}
+
+	// Bound the gateway call by the operation timeout
+	apiCtx, cancel := c.operationContext(ctx, operationInit)
+	defer cancel()
 
 	// Make API request
-	respBody, statusCode, err := c.makeRequest(ctx, http.MethodPost, "/api/v4/send", apiReq)
+	respBody, statusCode, err := c.makeRequest(apiCtx, http.MethodPost, c.endpoints().Send, paymentInitBody(&req))
 	if err != nil {
-		c.respondWithError(w, http.StatusInternalServerError, ErrInternalError, "Failed to initialize payment")
-		c.logger.Error(ctx, "Failed to initialize payment", err, map[string]interface{}{
-			"request": req,
-		})
+		// Payers are answered with a reference to poll while the gateway is down
+		if c.shouldDeferInit(ctx, statusCode, err) {
+			c.queueInit(w, r, &req, err)
+			return
+		}
+		c.respondWithError(w, upstreamError(err), "Failed to initialize payment")
+		c.log(ctx).Error(ctx, "Failed to initialize payment", err, c.paymentInitLogFields(&req))
 		return
 	}
 
 	// Parse API response
 	var apiResp PaymentInitResponse
 	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		c.respondWithError(w, http.StatusInternalServerError, ErrInternalError, "Failed to parse API response")
-		c.logger.Error(ctx, "Failed to parse API response", err, map[string]interface{}{
-			"response_body": string(respBody),
+		c.respondWithError(w, ErrInternalError, "Failed to parse API response")
+		c.log(ctx).Error(ctx, "Failed to parse API response", err, map[string]interface{}{
+			"response_body": redactBody(string(respBody)),
 		})
 		return
 	}
 
 	// Check if payment initialization was successful
 	if apiResp.Status != 1 {
-		c.respondWithError(w, statusCode, ErrPaymentFailed, apiResp.Message)
+		c.respondWithError(w, ErrPaymentFailed, apiResp.Message)
 		return
 	}
 
-	// Create transaction record
-	transaction := &Transaction{
-		ID:          generateRequestID(),
-		Token:       apiResp.Token,
-		Amount:      req.Amount,
-		Status:      "INIT",
-		Description: req.Description,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+	// Record the transaction; the token expiry lets frontends show a countdown
+	c.recordPaymentInit(ctx, &req, &apiResp, req.Metadata)
+
+	// Issue a session the frontend can poll with instead of the token
+	apiResp.SessionID = c.issueSessionID(ctx, apiResp.Token, apiResp.ExpiresAt)
+
+	// Respond with success
+	c.respondWithJSON(w, http.StatusOK, apiResp)
+}
+
+// paymentInitBody builds the gateway request body of a payment initialization
+func paymentInitBody(req *PaymentInitRequest) map[string]interface{} {
+	apiReq := map[string]interface{}{
+		"amount":       req.Amount,
+		"callback_url": req.CallbackURL,
 	}
 
-	// Store transaction
-	err = c.storage.StoreTransaction(ctx, transaction)
-	if err != nil {
-		c.logger.Error(ctx, "Failed to store transaction", err, map[string]interface{}{
-			"transaction": transaction,
-		})
+	if req.Description != "" {
+		apiReq["description"] = req.Description
+	}
+
+	if req.Mobile != "" {
+		apiReq["mobile"] = req.Mobile
+	}
+
+	if req.FactorNumber != "" {
+		apiReq["factorNumber"] = req.FactorNumber
+	}
+
+	if req.ValidCardNumber != "" {
+		apiReq["valid_card_number"] = req.ValidCardNumber
+	}
+
+	if req.NationalCode != "" {
+		apiReq["national_code"] = req.NationalCode
+	}
+
+	if req.RequireCardOwnerMatch {
+		apiReq[cardOwnerCheckField] = true
+	}
+
+	return apiReq
+}
+
+// recordPaymentInit stores the INIT transaction of a successful initialization,
+// setting the response's token expiry, and fires the OnPaymentInitiated hook
+func (c *Client) recordPaymentInit(ctx context.Context, req *PaymentInitRequest, apiResp *PaymentInitResponse, metadata map[string]string) *Transaction {
+	// Record when the token expires so it can be expired locally
+	expiresAt := c.tokenExpiry(apiResp, c.clock.Now())
+	apiResp.ExpiresAt = &expiresAt
+
+	// Create transaction record
+	transaction := &Transaction{
+		ID:           c.idGenerator().NewTransactionID(),
+		Token:        apiResp.Token,
+		Amount:       req.Amount,
+		Status:       StatusInit,
+		Type:         TransactionTypePayment,
+		Description:  req.Description,
+		FactorNumber: req.FactorNumber,
+		CallbackURL:  req.CallbackURL,
+		CardHash:     c.cardHash(req.ValidCardNumber),
+		Metadata:     metadata,
+		CreatedAt:    c.clock.Now(),
+		UpdatedAt:    c.clock.Now(),
+		ExpiresAt:    &expiresAt,
+	}
+	applyClientInfo(transaction, req.ClientInfo)
+
+	// Store transaction; the gateway issued the token even if the caller went away
+	if err := c.storage.StoreTransaction(context.WithoutCancel(ctx), transaction); err != nil {
+		c.log(ctx).Error(ctx, "Failed to store transaction", err, transactionLogFields(transaction))
 		// Continue with the response even if storage fails
 	}
 
-	// Respond with success
-	c.respondWithJSON(w, http.StatusOK, apiResp)
+	// The initiating device is part of the audit trail
+	c.log(ctx).Info(ctx, "Payment initialized", clientInfoLogFields(transaction, transactionLogFields(transaction)))
+
+	c.firePaymentInitiated(ctx, transaction)
+
+	return transaction
 }
 
 // handlePaymentVerify handles payment verification requests
 func (c *Client) handlePaymentVerify(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// Parse request body
+	// Parse request body, accepting JSON or form-encoded data
 	var req PaymentVerifyRequest
-	if err := parseJSONBody(r, &req); err != nil {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+	if err := parseRequestBody(r, &req); err != nil {
+		c.respondInvalid(w, err)
 		return
 	}
 
-	// Validate request
-	if err := ValidatePaymentVerifyRequest(&req); err != nil {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+	// Resolve a transaction ID, factor number or payment session given instead of the token
+	token, ok := c.requestTokenBy(w, r, VerifyBy{
+		Token:         req.Token,
+		TransactionID: req.TransactionID,
+		FactorNumber:  req.FactorNumber,
+	})
+	if !ok {
 		return
 	}
+	req.Token = token
+	r = c.traceRequest(r, token)
+	ctx = r.Context()
 
-	// Prepare API request body
-	apiReq := map[string]interface{}{
-		"token": req.Token,
-	}
-
-	// Make API request
-	respBody, statusCode, err := c.makeRequest(ctx, http.MethodPost, "/api/v4/verify", apiReq)
-	if err != nil {
-		c.respondWithError(w, http.StatusInternalServerError, ErrInternalError, "Failed to verify payment")
-		c.logger.Error(ctx, "Failed to verify payment", err, map[string]interface{}{
-			"token": req.Token,
-		})
+	// Validate request
+	if err := c.validator().ValidatePaymentVerifyRequest(&req); err != nil {
+		c.respondInvalid(w, err)
 		return
 	}
 
-	// Parse API response
-	var apiResp PaymentVerifyResponse
-	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		c.respondWithError(w, http.StatusInternalServerError, ErrInternalError, "Failed to parse API response")
-		c.logger.Error(ctx, "Failed to parse API response", err, map[string]interface{}{
-			"response_body": string(respBody),
-		})
+	// Delegate to the configured payment provider
+	if c.provider != nil {
+		c.verifyWithProvider(w, r, req.Token)
 		return
 	}
 
-	// Check if payment verification was successful
-	if apiResp.Status != 1 {
-		c.respondWithError(w, statusCode, ErrVerificationFailed, apiResp.Message)
+	// A verification that started is finished even if the caller goes away,
+	// so only one that hasn't is skipped
+	if c.abandoned(ctx, "verify") {
 		return
 	}
 
-	// Get transaction from storage
-	transaction, err := c.storage.GetTransaction(ctx, req.Token)
-	if err == nil {
-		// Update transaction status
-		transaction.Status = "PAID"
-		transaction.TransactionID = apiResp.TransID
-		transaction.CardNumber = apiResp.CardNumber
-		transaction.CID = apiResp.CID
-		transaction.UpdatedAt = time.Now()
-
-		completedAt := time.Now()
-		transaction.CompletedAt = &completedAt
-
-		// Store updated transaction
-		err = c.storage.UpdateTransaction(ctx, transaction)
-		if err != nil {
-			c.logger.Error(ctx, "Failed to update transaction", err, map[string]interface{}{
-				"transaction": transaction,
-			})
-			// Continue with the response even if storage fails
+	// Verify payment, sharing the result with concurrent verifications of the same token
+	apiResp, err := c.VerifyPaymentDetailed(ctx, req.Token)
+	if err != nil {
+		// A response carries the gateway's reason for declining
+		message := ""
+		if apiResp != nil {
+			message = apiResp.Message
 		}
-	} else {
-		c.logger.Warn(ctx, "Transaction not found in storage", map[string]interface{}{
-			"token": req.Token,
-		})
-		// Continue with the response even if transaction is not found
+		c.respondVerifyError(w, r, req.Token, err, message)
+		return
 	}
 
 	// Respond with success
@@ -251,46 +313,52 @@ func (c *Client) handlePaymentVerify(w http.ResponseWriter, r *http.Request) {
 func (c *Client) handlePaymentStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// Get token from query parameter
-	token := r.URL.Query().Get("token")
-	if token == "" {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, "Token is required")
+	// Get token from query parameter, or resolve it from a transaction ID,
+	// factor number or payment session
+	query := r.URL.Query()
+	token, ok := c.requestTokenBy(w, r, VerifyBy{
+		Token:         query.Get("token"),
+		TransactionID: query.Get("transaction_id"),
+		FactorNumber:  query.Get("factor_number"),
+	})
+	if !ok {
 		return
 	}
-
-	// Create request
-	req := PaymentStatusRequest{
-		Token: token,
+	if token == "" {
+		c.respondWithError(w, ErrInvalidRequest, "Token is required")
+		return
 	}
+	r = c.traceRequest(r, token)
+	ctx = r.Context()
 
-	// Validate request
-	if err := ValidatePaymentStatusRequest(&req); err != nil {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+	// Delegate to the configured payment provider
+	if c.provider != nil {
+		c.statusWithProvider(w, r, token)
 		return
 	}
 
-	// Make API request
-	respBody, statusCode, err := c.makeRequest(ctx, http.MethodGet, fmt.Sprintf("/v4/%s", token), nil)
+	// Check payment status; expired tokens are answered locally
+	apiResp, err := c.GetPaymentStatus(ctx, token)
 	if err != nil {
-		c.respondWithError(w, http.StatusInternalServerError, ErrInternalError, "Failed to check payment status")
-		c.logger.Error(ctx, "Failed to check payment status", err, map[string]interface{}{
-			"token": token,
+		if IsValidationError(err) {
+			c.respondInvalid(w, err)
+			return
+		}
+		c.respondWithError(w, upstreamError(err), "Failed to check payment status")
+		c.log(ctx).Error(ctx, "Failed to check payment status", err, map[string]interface{}{
+			"token": redactToken(token),
 		})
 		return
 	}
 
-	// Parse API response
-	var apiResp PaymentStatusResponse
-	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		c.respondWithError(w, http.StatusInternalServerError, ErrInternalError, "Failed to parse API response")
-		c.logger.Error(ctx, "Failed to parse API response", err, map[string]interface{}{
-			"response_body": string(respBody),
-		})
+	// Respond with the normalized shape when requested
+	if r.URL.Query().Get("format") == "normalized" {
+		c.respondWithJSON(w, http.StatusOK, PaymentResultFromStatus(token, apiResp))
 		return
 	}
 
 	// Respond with the status
-	c.respondWithJSON(w, statusCode, apiResp)
+	c.respondWithJSON(w, http.StatusOK, apiResp)
 }
 
 // handleRefund handles refund requests
@@ -300,16 +368,43 @@ func (c *Client) handleRefund(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var req RefundRequest
 	if err := parseJSONBody(r, &req); err != nil {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		c.respondInvalid(w, err)
+		return
+	}
+
+	// Resolve a payment named by token or factor number to its transaction ID
+	if err := c.resolveRefundRequest(ctx, &req); err != nil {
+		c.respondLookupError(w, r, err)
 		return
 	}
 
 	// Validate request
-	if err := ValidateRefundRequest(&req); err != nil {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+	if err := c.validator().ValidateRefundRequest(&req); err != nil {
+		c.respondInvalid(w, err)
 		return
 	}
 
+	// Delegate to the configured payment provider
+	if c.provider != nil {
+		c.refundWithProvider(w, r, &req)
+		return
+	}
+
+	// Refuse refunding more than the recorded refunds left
+	transaction, release, err := c.beginRefund(ctx, req.TransactionID, req.Amount)
+	if err != nil {
+		if IsDomainError(err) {
+			c.respondError(w, err)
+			return
+		}
+		c.respondWithError(w, ErrInternalError, "Failed to check earlier refunds")
+		c.log(ctx).Error(ctx, "Failed to check earlier refunds", err, map[string]interface{}{
+			"transaction_id": req.TransactionID,
+		})
+		return
+	}
+	defer release()
+
 	// Prepare API request body
 	apiReq := map[string]interface{}{
 		"transaction_id": req.TransactionID,
@@ -319,16 +414,25 @@ func (c *Client) handleRefund(w http.ResponseWriter, r *http.Request) {
 		apiReq["amount"] = req.Amount
 	}
 
+	// Don't refund for a caller that gave up
+	if c.abandoned(ctx, "refund") {
+		return
+	}
+
+	// Bound the gateway call by the operation timeout
+	apiCtx, cancel := c.operationContext(ctx, operationInit)
+	defer cancel()
+
 	// Make API request
-	respBody, statusCode, err := c.makeRequest(
-		ctx,
+	respBody, _, err := c.makeRequest(
+		apiCtx,
 		http.MethodPost,
-		fmt.Sprintf("/v3/business/%s/transaction/%s/refund", "business", req.TransactionID),
+		c.refundEndpoint(req.TransactionID),
 		apiReq,
 	)
 	if err != nil {
-		c.respondWithError(w, http.StatusInternalServerError, ErrInternalError, "Failed to refund payment")
-		c.logger.Error(ctx, "Failed to refund payment", err, map[string]interface{}{
+		c.respondWithError(w, upstreamError(err), "Failed to refund payment")
+		c.log(ctx).Error(ctx, "Failed to refund payment", err, map[string]interface{}{
 			"transaction_id": req.TransactionID,
 			"amount":         req.Amount,
 		})
@@ -338,19 +442,23 @@ func (c *Client) handleRefund(w http.ResponseWriter, r *http.Request) {
 	// Parse API response
 	var apiResp RefundResponse
 	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		c.respondWithError(w, http.StatusInternalServerError, ErrInternalError, "Failed to parse API response")
-		c.logger.Error(ctx, "Failed to parse API response", err, map[string]interface{}{
-			"response_body": string(respBody),
+		c.respondWithError(w, ErrInternalError, "Failed to parse API response")
+		c.log(ctx).Error(ctx, "Failed to parse API response", err, map[string]interface{}{
+			"response_body": redactBody(string(respBody)),
 		})
 		return
 	}
 
 	// Check if refund was successful
 	if !apiResp.Status {
-		c.respondWithError(w, statusCode, ErrRefundFailed, apiResp.Message)
+		c.respondWithError(w, ErrRefundFailed, apiResp.Message)
 		return
 	}
 
+	// Refunds settle later; record this one for the refund tracker even if the
+	// caller went away, since the gateway accepted it
+	c.trackRefund(context.WithoutCancel(ctx), transaction, req.TransactionID, req.Amount, &apiResp)
+
 	// Respond with success
 	c.respondWithJSON(w, http.StatusOK, apiResp)
 }
@@ -359,19 +467,22 @@ func (c *Client) handleRefund(w http.ResponseWriter, r *http.Request) {
 func (c *Client) handleCallback(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// Parse callback data
+	// Parse callback data from the start of the body, even if something read it already
+	rewindBody(r)
 	err := r.ParseForm()
 	if err != nil {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid form data")
-		c.logger.Error(ctx, "Failed to parse callback form data", err, nil)
+		c.respondWithError(w, ErrInvalidRequest, "Invalid form data")
+		c.log(ctx).Error(ctx, "Failed to parse callback form data", err, nil)
 		return
 	}
 
 	token := r.FormValue("token")
 	if token == "" {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, "Token is required")
+		c.respondWithError(w, ErrInvalidRequest, "Token is required")
 		return
 	}
+	r = c.traceRequest(r, token)
+	ctx = r.Context()
 
 	// Create callback data
 	callbackData := &CallbackData{
@@ -380,37 +491,104 @@ func (c *Client) handleCallback(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate callback data
-	if err := ValidateCallbackData(callbackData); err != nil {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+	if err := c.validator().ValidateCallbackData(callbackData); err != nil {
+		c.respondInvalid(w, err)
 		return
 	}
 
 	// Log callback details
-	c.logger.Info(ctx, "Received payment callback", map[string]interface{}{
-		"token":  token,
+	c.log(ctx).Info(ctx, "Received payment callback", map[string]interface{}{
+		"token":  redactToken(token),
 		"status": callbackData.Status,
 	})
 
 	// Get transaction from storage
-	transaction, err := c.storage.GetTransaction(ctx, token)
+	pageData := &CallbackPageData{
+		Success:   callbackSucceeded(callbackData.Status),
+		Status:    callbackData.Status,
+		ReturnURL: c.callbackReturnURL(token),
+	}
+	// Hold the token while reading and updating the transaction; the lock is
+	// released before auto-verification, which takes it again
+	release := c.tokenLocks.Lock(token)
+	defer release()
+
+	// The payer's outcome is recorded even if the browser went away
+	storeCtx := context.WithoutCancel(ctx)
+
+	transaction, err := c.storage.GetTransaction(storeCtx, token)
 	if err != nil {
-		c.logger.Warn(ctx, "Transaction not found for callback", map[string]interface{}{
-			"token": token,
+		c.log(ctx).Warn(ctx, "Transaction not found for callback", map[string]interface{}{
+			"token": redactToken(token),
 		})
 		// Continue with the response even if transaction is not found
+	} else if transaction.Status.IsTerminal() {
+		// Callbacks are delivered at least once; repeats must not change anything
+		c.log(ctx).Info(ctx, "Ignoring duplicate callback for terminal transaction", map[string]interface{}{
+			"token":  redactToken(token),
+			"status": string(transaction.Status),
+		})
+		release()
+		c.respondToDuplicateCallback(w, r, transaction, pageData)
+		return
 	} else {
+		c.checkCallbackURL(ctx, r, transaction)
+
 		// Update transaction status based on callback status
-		transaction.Status = callbackData.Status
-		transaction.UpdatedAt = time.Now()
+		previousStatus := transaction.Status
+		status := callbackTransactionStatus(callbackData.Status, transaction.Status)
+		patch := TransactionPatch{CallbackCountDelta: 1}
+		if status != previousStatus {
+			patch.Status = &status
+		}
+		if pageData.Success && !awaitsVerification(transaction) {
+			// Start the verification deadline watched by CheckVerificationDeadlines
+			patch.Metadata = map[string]string{
+				CallbackSucceededMetadataKey: c.clock.Now().UTC().Format(time.RFC3339Nano),
+			}
+		}
+		c.applyPatch(&patch, transaction)
 
 		// Store updated transaction
-		err = c.storage.UpdateTransaction(ctx, transaction)
+		err = c.patchTransaction(storeCtx, token, patch)
 		if err != nil {
-			c.logger.Error(ctx, "Failed to update transaction from callback", err, map[string]interface{}{
-				"transaction": transaction,
-			})
+			c.log(ctx).Error(ctx, "Failed to update transaction from callback", err, transactionLogFields(transaction))
 			// Continue with the response even if storage fails
 		}
+		c.invalidateCache(storeCtx, token)
+
+		c.fireStatusChange(ctx, transaction, previousStatus)
+		c.fireCallback(ctx, transaction, callbackData)
+
+		pageData.Amount = transaction.Amount
+		pageData.CardMask = transaction.CardNumber
+		pageData.RefNumber = transaction.RefNumber
+	}
+	release()
+
+	// Optionally confirm the payment with the gateway before reporting it
+	verified := false
+	if pageData.Success && configValues(c.config).AutoVerifyCallback {
+		var declined bool
+		verified, declined = c.autoVerifyCallback(ctx, token)
+		switch {
+		case verified:
+			pageData.Status = string(StatusPaid)
+		case declined:
+			pageData.Success = false
+			pageData.Status = string(StatusFailed)
+		}
+	}
+
+	// Send the payer back to the merchant with a tamper-proof result
+	if c.returnRedirectEnabled() {
+		c.redirectToReturnURL(w, r, pageData, token, verified)
+		return
+	}
+
+	// Browsers get a result page, falling back to JSON if rendering fails
+	if c.wantsHTML(r) && c.renderCallbackPage(w, r, pageData) {
+		return
 	}
 
 	// Respond with success
@@ -420,6 +598,40 @@ func (c *Client) handleCallback(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// respondToDuplicateCallback answers a repeated callback from the stored transaction
+// without touching storage or firing hooks
+func (c *Client) respondToDuplicateCallback(w http.ResponseWriter, r *http.Request, transaction *Transaction, pageData *CallbackPageData) {
+	pageData.Success = transaction.Status == StatusPaid
+	pageData.Status = string(transaction.Status)
+	pageData.Amount = transaction.Amount
+	pageData.CardMask = transaction.CardNumber
+	pageData.RefNumber = transaction.RefNumber
+
+	if c.returnRedirectEnabled() {
+		c.redirectToReturnURL(w, r, pageData, transaction.Token, pageData.Success)
+		return
+	}
+
+	if c.wantsHTML(r) && c.renderCallbackPage(w, r, pageData) {
+		return
+	}
+
+	c.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    true,
+		"duplicate": true,
+		"message":   "Callback already processed",
+	})
+}
+
+// callbackTransactionStatus returns the stored status after a callback. A successful
+// callback still needs verification, so only failures change the status.
+func callbackTransactionStatus(callbackStatus string, current TransactionStatus) TransactionStatus {
+	if callbackStatus == "" || callbackSucceeded(callbackStatus) {
+		return current
+	}
+	return StatusFailed
+}
+
 // handleTransactionInfo handles transaction information requests
 func (c *Client) handleTransactionInfo(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -427,16 +639,18 @@ func (c *Client) handleTransactionInfo(w http.ResponseWriter, r *http.Request) {
 	// Get token from query parameter
 	token := r.URL.Query().Get("token")
 	if token == "" {
-		c.respondWithError(w, http.StatusBadRequest, ErrInvalidRequest, "Token is required")
+		c.respondWithError(w, ErrInvalidRequest, "Token is required")
 		return
 	}
+	r = c.traceRequest(r, token)
+	ctx = r.Context()
 
 	// Get transaction info
 	resp, err := c.GetTransactionInfo(ctx, token)
 	if err != nil {
-		c.respondWithError(w, http.StatusInternalServerError, ErrInternalError, "Failed to get transaction info")
-		c.logger.Error(ctx, "Failed to get transaction info", err, map[string]interface{}{
-			"token": token,
+		c.respondWithError(w, upstreamError(err), "Failed to get transaction info")
+		c.log(ctx).Error(ctx, "Failed to get transaction info", err, map[string]interface{}{
+			"token": redactToken(token),
 		})
 		return
 	}
@@ -453,12 +667,11 @@ func parseJSONBody(r *http.Request, v interface{}) error {
 		return fmt.Errorf("Content-Type must be application/json")
 	}
 
-	// Read body
-	body, err := io.ReadAll(r.Body)
+	// Read body, reusing the buffered copy when BufferBodyMiddleware ran
+	body, err := readBody(r)
 	if err != nil {
 		return fmt.Errorf("failed to read request body: %w", err)
 	}
-	defer r.Body.Close()
 
 	// Parse JSON
 	if len(body) == 0 {
@@ -472,35 +685,59 @@ func parseJSONBody(r *http.Request, v interface{}) error {
 	return nil
 }
 
+// respondInvalid responds to a request that could not be decoded or failed validation
+func (c *Client) respondInvalid(w http.ResponseWriter, err error) {
+	// Validation and field conversion failures list the offending fields
+	if IsValidationError(err) {
+		c.respondError(w, err)
+		return
+	}
+
+	// A request type with broken validate tags is a bug, not a bad request
+	if errors.Is(err, ErrInternalError) {
+		c.log(context.Background()).Error(context.Background(), "Failed to validate request", err, nil)
+		c.respondWithError(w, ErrInternalError, "")
+		return
+	}
+
+	c.respondWithError(w, ErrInvalidRequest, err.Error())
+}
+
 // respondWithJSON responds with a JSON payload
 func (c *Client) respondWithJSON(w http.ResponseWriter, statusCode int, payload interface{}) {
-	// Set content type
-	w.Header().Set("Content-Type", "application/json")
-
-	// Marshal payload to JSON
-	response, err := json.Marshal(payload)
-	if err != nil {
-		c.logger.Error(context.Background(), "Failed to marshal JSON response", err, map[string]interface{}{
-			"payload": payload,
+	// Apply the configured encoding, then write the payload as JSON
+	encoded, err := c.encoder().EncodePayload(payload)
+	if err == nil {
+		err = writeJSON(w, statusCode, encoded)
+	}
+
+	var writeErr *responseWriteError
+	switch {
+	case err == nil:
+	case errors.As(err, &writeErr):
+		c.log(context.Background()).Error(context.Background(), "Failed to write response", writeErr.err, nil)
+	default:
+		// Nothing was written yet, so the failure can still be reported
+		c.log(context.Background()).Error(context.Background(), "Failed to marshal JSON response", err, map[string]interface{}{
+			"payload_type": fmt.Sprintf("%T", payload),
 		})
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
 	}
+}
 
-	// Set status code and write response
-	w.WriteHeader(statusCode)
-	_, err = w.Write(response)
-	if err != nil {
-		c.logger.Error(context.Background(), "Failed to write response", err, nil)
-	}
+// respondError responds with the error envelope of an error and the status code
+// errorToStatus maps it to
+func (c *Client) respondError(w http.ResponseWriter, err error) {
+	c.respondWithError(w, err, "")
 }
 
-// respondWithError responds with an error message
-func (c *Client) respondWithError(w http.ResponseWriter, statusCode int, err error, message string) {
-	errorResponse := APIErrorResponse(err)
-	if message != "" {
-		errorResponse["message"] = message
+// respondWithError responds like respondError with an optional message override
+func (c *Client) respondWithError(w http.ResponseWriter, err error, message string) {
+	// Rejected credentials keep their own message so operators see what to fix
+	if isGatewayAuthError(err) {
+		message = ""
 	}
 
-	c.respondWithJSON(w, statusCode, errorResponse)
+	setRetryAfter(w, err)
+	c.respondWithJSON(w, errorToStatus(err), c.encoder().ErrorEnvelope(err, message))
 }
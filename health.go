@@ -0,0 +1,376 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// health.go implements component health reporting and the health probe route
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// healthPath is the path of the optional health probe route
+	healthPath = "/payments/health"
+
+	// defaultHealthStorageDegradedLatency is used when Config.HealthStorageDegradedLatency is not set
+	defaultHealthStorageDegradedLatency = 250 * time.Millisecond
+
+	// defaultHealthStorageTimeout is used when Config.HealthStorageTimeout is not set
+	defaultHealthStorageTimeout = 2 * time.Second
+
+	// defaultHealthQueueDegradedDepth is used when Config.HealthQueueDegradedDepth is not set
+	defaultHealthQueueDegradedDepth = 100
+
+	// defaultHealthGatewayDownFailures is used when Config.HealthGatewayDownFailures is not set
+	defaultHealthGatewayDownFailures = 5
+)
+
+// HealthState is the state of a component or of the whole client
+type HealthState string
+
+const (
+	// HealthOK means the component works normally
+	HealthOK HealthState = "ok"
+
+	// HealthDegraded means the component works but is slow, backed up or failing
+	// intermittently; the client keeps serving requests
+	HealthDegraded HealthState = "degraded"
+
+	// HealthDown means the component doesn't work; the client should not receive traffic
+	HealthDown HealthState = "down"
+)
+
+// severity orders states from best to worst
+func (s HealthState) severity() int {
+	switch s {
+	case HealthOK:
+		return 0
+	case HealthDegraded:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// HealthProbe selects what a health check covers
+type HealthProbe string
+
+const (
+	// HealthProbeLive only reports that the process serves requests, for
+	// Kubernetes liveness probes, which restart the pod when they fail
+	HealthProbeLive HealthProbe = "live"
+
+	// HealthProbeReady checks every component, for Kubernetes readiness probes,
+	// which take the pod out of rotation when they fail
+	HealthProbeReady HealthProbe = "ready"
+)
+
+// Health report component names
+const (
+	HealthComponentGateway           = "gateway"
+	HealthComponentStorage           = "storage"
+	HealthComponentWebhookDispatcher = "webhook_dispatcher"
+	HealthComponentRetryQueue        = "retry_queue"
+)
+
+// ComponentHealth is the health of one component
+type ComponentHealth struct {
+	// State is the component's state
+	State HealthState `json:"state"`
+
+	// LatencyMS is the latency of the last probe or request, in milliseconds
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+
+	// CheckedAt is when the component was last probed or used
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+
+	// QueueDepth is how many items wait for processing, for queues
+	QueueDepth *int64 `json:"queue_depth,omitempty"`
+
+	// Message explains the state
+	Message string `json:"message,omitempty"`
+}
+
+// HealthReport is the health of the client and its components
+type HealthReport struct {
+	// Status is the worst state of the components
+	Status HealthState `json:"status"`
+
+	// Probe is what the report covers
+	Probe HealthProbe `json:"probe"`
+
+	// CheckedAt is when the report was made
+	CheckedAt time.Time `json:"checked_at"`
+
+	// Message explains a status other than ok that no component accounts for
+	Message string `json:"message,omitempty"`
+
+	// Components are the reports of each component, empty for liveness probes
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+}
+
+// StatusCode returns the HTTP status code answering the report: 200 for ok and
+// degraded, 503 for down
+func (r *HealthReport) StatusCode() int {
+	if r.Status == HealthDown {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+// PingableStorageInterface is implemented by storages with a cheap connectivity
+// check, which health probes use instead of a query
+type PingableStorageInterface interface {
+	// Ping checks that the storage can serve requests
+	Ping(ctx context.Context) error
+}
+
+// Ping checks that the storage can serve requests
+func (s *MemoryStorage) Ping(ctx context.Context) error {
+	return s.enter(ctx)
+}
+
+// healthTracker keeps what health reports derive from requests and background
+// work, shared by clones
+type healthTracker struct {
+	mutex sync.Mutex
+
+	// Outcome of the last gateway request
+	gatewayCheckedAt time.Time
+	gatewayLatency   time.Duration
+	gatewayFailures  int
+
+	// webhooks counts webhook events being processed
+	webhooks atomic.Int64
+
+	// retries counts scheduled verification retries and queued initializations
+	retries atomic.Int64
+}
+
+// newHealthTracker creates an empty tracker
+func newHealthTracker() *healthTracker {
+	return &healthTracker{}
+}
+
+// recordGateway records the outcome of a gateway request. Only outages and 5xx
+// responses count as failures; a request the gateway rejected shows it is up.
+func (t *healthTracker) recordGateway(now time.Time, latency time.Duration, statusCode int, err error) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.gatewayCheckedAt = now
+	t.gatewayLatency = latency
+	if err != nil && (isProviderOutage(err) || statusCode >= http.StatusInternalServerError) {
+		t.gatewayFailures++
+		return
+	}
+	t.gatewayFailures = 0
+}
+
+// trackWebhook counts a webhook event being processed until the returned function is called
+func (t *healthTracker) trackWebhook() func() {
+	return track(t, func(t *healthTracker) *atomic.Int64 { return &t.webhooks })
+}
+
+// trackRetry counts a retry waiting or running until the returned function is called
+func (t *healthTracker) trackRetry() func() {
+	return track(t, func(t *healthTracker) *atomic.Int64 { return &t.retries })
+}
+
+// track increments a counter of a tracker and returns the function decrementing it once
+func track(t *healthTracker, counter func(*healthTracker) *atomic.Int64) func() {
+	if t == nil {
+		return func() {}
+	}
+
+	value := counter(t)
+	value.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { value.Add(-1) }) }
+}
+
+// healthThresholds returns the thresholds of health reports with defaults applied
+func (c *Client) healthThresholds() (storageDegraded, storageTimeout time.Duration, queueDegraded int64, gatewayDown int) {
+	values := configValues(c.config)
+
+	storageDegraded = defaultHealthStorageDegradedLatency
+	if values.HealthStorageDegradedLatency > 0 {
+		storageDegraded = values.HealthStorageDegradedLatency
+	}
+	storageTimeout = defaultHealthStorageTimeout
+	if values.HealthStorageTimeout > 0 {
+		storageTimeout = values.HealthStorageTimeout
+	}
+	queueDegraded = defaultHealthQueueDegradedDepth
+	if values.HealthQueueDegradedDepth > 0 {
+		queueDegraded = int64(values.HealthQueueDegradedDepth)
+	}
+	gatewayDown = defaultHealthGatewayDownFailures
+	if values.HealthGatewayDownFailures > 0 {
+		gatewayDown = values.HealthGatewayDownFailures
+	}
+
+	return storageDegraded, storageTimeout, queueDegraded, gatewayDown
+}
+
+// Health checks every component and returns the readiness report. The storage is
+// probed; the gateway's state comes from the outcome of recent requests, so
+// reports don't spend gateway quota. A draining client is down.
+func (c *Client) Health(ctx context.Context) *HealthReport {
+	storageDegraded, storageTimeout, queueDegraded, gatewayDown := c.healthThresholds()
+
+	report := &HealthReport{
+		Probe:     HealthProbeReady,
+		CheckedAt: c.clock.Now(),
+		Components: map[string]ComponentHealth{
+			HealthComponentGateway:           c.gatewayHealth(gatewayDown),
+			HealthComponentStorage:           c.storageHealth(ctx, storageDegraded, storageTimeout),
+			HealthComponentWebhookDispatcher: c.webhookHealth(queueDegraded),
+			HealthComponentRetryQueue:        queueHealth(c.health.retries.Load(), queueDegraded),
+		},
+	}
+
+	report.Status = HealthOK
+	for name, component := range report.Components {
+		if component.State.severity() > report.Status.severity() {
+			report.Status = component.State
+		}
+		c.metrics.SetGauge(MetricComponentHealth, float64(component.State.severity()), map[string]string{"component": name})
+	}
+
+	if c.inflight.isDraining() {
+		report.Status = HealthDown
+		report.Message = "Shutting down"
+	}
+
+	return report
+}
+
+// gatewayHealth reports the gateway from the outcome of recent requests: degraded
+// after an outage, down after gatewayDown outages in a row. With DeferredInit the
+// client keeps accepting payments during outages, so it is at worst degraded.
+func (c *Client) gatewayHealth(gatewayDown int) ComponentHealth {
+	t := c.health
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.gatewayCheckedAt.IsZero() {
+		return ComponentHealth{State: HealthOK, Message: "No requests yet"}
+	}
+
+	checkedAt := t.gatewayCheckedAt
+	health := ComponentHealth{
+		State:     HealthOK,
+		LatencyMS: milliseconds(t.gatewayLatency),
+		CheckedAt: &checkedAt,
+	}
+	if t.gatewayFailures == 0 {
+		return health
+	}
+
+	health.State = HealthDegraded
+	if t.gatewayFailures >= gatewayDown && !configValues(c.config).DeferredInit {
+		health.State = HealthDown
+	}
+	// Errors aren't reported since probes are unauthenticated
+	health.Message = fmt.Sprintf("%d gateway outages in a row", t.gatewayFailures)
+	return health
+}
+
+// storageHealth probes the storage with Ping when it has one, a query for
+// queued transactions otherwise. Probes slower than degraded are degraded, and
+// probes that fail or don't finish within timeout are down.
+func (c *Client) storageHealth(ctx context.Context, degraded, timeout time.Duration) ComponentHealth {
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := c.clock.Now()
+	var err error
+	if pinger, ok := c.storage.(PingableStorageInterface); ok {
+		err = pinger.Ping(probeCtx)
+	} else {
+		_, err = c.storage.GetTransactionsByStatus(probeCtx, string(StatusQueued))
+	}
+	checkedAt := c.clock.Now()
+	latency := checkedAt.Sub(start)
+
+	health := ComponentHealth{
+		State:     HealthOK,
+		LatencyMS: milliseconds(latency),
+		CheckedAt: &checkedAt,
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || latency >= timeout:
+		health.State = HealthDown
+		health.Message = "Storage probe timed out after " + timeout.String()
+	case err != nil:
+		health.State = HealthDown
+		health.Message = "Storage probe failed"
+		c.log(ctx).Error(ctx, "Storage health probe failed", err, nil)
+	case latency >= degraded:
+		health.State = HealthDegraded
+		health.Message = "Storage probe took " + latency.String()
+	}
+
+	return health
+}
+
+// webhookHealth reports the webhook events being processed
+func (c *Client) webhookHealth(queueDegraded int64) ComponentHealth {
+	if configValues(c.config).WebhookSecret == "" {
+		return ComponentHealth{State: HealthOK, Message: "Webhooks are disabled"}
+	}
+	return queueHealth(c.health.webhooks.Load(), queueDegraded)
+}
+
+// queueHealth reports a queue, degraded once degraded items wait
+func queueHealth(depth, degraded int64) ComponentHealth {
+	health := ComponentHealth{State: HealthOK, QueueDepth: &depth}
+	if depth >= degraded {
+		health.State = HealthDegraded
+		health.Message = "Queue is backed up"
+	}
+	return health
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WithHealthRoute registers GET /payments/health, which answers Kubernetes
+// probes without credentials: ?probe=live reports that the process serves
+// requests, ?probe=ready or no probe reports every component. Ok and degraded
+// are answered with 200, down with 503.
+func WithHealthRoute() RouteOption {
+	return func(o *routeOptions) {
+		o.enable(healthPath)
+	}
+}
+
+// handleHealth answers health probes
+func (c *Client) handleHealth(w http.ResponseWriter, r *http.Request) {
+	var report *HealthReport
+
+	switch HealthProbe(r.URL.Query().Get("probe")) {
+	case HealthProbeLive:
+		report = &HealthReport{Status: HealthOK, Probe: HealthProbeLive, CheckedAt: c.clock.Now()}
+	case HealthProbeReady, "":
+		report = c.Health(r.Context())
+	default:
+		c.respondWithError(w, ErrInvalidRequest, "probe must be live or ready")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	c.respondWithJSON(w, report.StatusCode(), report)
+}
@@ -0,0 +1,168 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowStorage is a memory storage whose Ping takes delay on a fake clock, or
+// blocks until its context ends when delay is negative
+type slowStorage struct {
+	*MemoryStorage
+	clock *FakeClock
+	delay time.Duration
+	err   error
+}
+
+func (s *slowStorage) Ping(ctx context.Context) error {
+	if s.delay < 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	s.clock.Advance(s.delay)
+	return s.err
+}
+
+// healthClient creates a client probing a slow storage
+func healthClient(t *testing.T, storage *slowStorage, transport HTTPClientInterface, mutate ...func(*Config)) *Client {
+	t.Helper()
+
+	client, err := NewClient(testConfig(t, mutate...), storage, &captureLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := []ClientOption{WithClientClock(storage.clock)}
+	if transport != nil {
+		opts = append(opts, WithClientHTTPClient(transport))
+	}
+	return client.Clone(opts...)
+}
+
+// probeHealth requests the health route with the given probe
+func probeHealth(handler http.Handler, probe string) (*httptest.ResponseRecorder, HealthReport) {
+	path := "/payments/health"
+	if probe != "" {
+		path += "?probe=" + probe
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var report HealthReport
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	return rec, report
+}
+
+func TestHealthSlowStorage(t *testing.T) {
+	tests := []struct {
+		name   string
+		delay  time.Duration
+		err    error
+		state  HealthState
+		status int
+	}{
+		{"fast", 10 * time.Millisecond, nil, HealthOK, http.StatusOK},
+		{"slow", 300 * time.Millisecond, nil, HealthDegraded, http.StatusOK},
+		{"over the timeout", 2 * time.Second, nil, HealthDown, http.StatusServiceUnavailable},
+		{"hanging", -1, nil, HealthDown, http.StatusServiceUnavailable},
+		{"failing", 0, errors.New("connection refused"), HealthDown, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &slowStorage{MemoryStorage: NewMemoryStorage(), clock: NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)), delay: tt.delay, err: tt.err}
+			client := healthClient(t, storage, nil, func(c *Config) {
+				c.HealthStorageDegradedLatency = 200 * time.Millisecond
+				c.HealthStorageTimeout = time.Second
+			})
+			if tt.delay < 0 {
+				// A hanging probe is bounded by the real timeout
+				client = healthClient(t, storage, nil, func(c *Config) { c.HealthStorageTimeout = 20 * time.Millisecond })
+			}
+
+			rec, report := probeHealth(client.Handler(WithHealthRoute()), "ready")
+			if rec.Code != tt.status || report.Status != tt.state {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			component := report.Components[HealthComponentStorage]
+			if component.State != tt.state || component.CheckedAt == nil {
+				t.Fatalf("storage %+v", component)
+			}
+			if tt.delay > 0 && component.LatencyMS != milliseconds(tt.delay) {
+				t.Fatalf("latency %vms, want %v", component.LatencyMS, milliseconds(tt.delay))
+			}
+			if tt.state != HealthOK && component.Message == "" {
+				t.Fatal("no message for a storage that isn't ok")
+			}
+
+			// Liveness doesn't probe the storage
+			if rec, report := probeHealth(client.Handler(WithHealthRoute()), "live"); rec.Code != http.StatusOK || report.Status != HealthOK || len(report.Components) != 0 {
+				t.Fatalf("live: status %d: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestHealthComponents(t *testing.T) {
+	storage := &slowStorage{MemoryStorage: NewMemoryStorage(), clock: NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))}
+	client := healthClient(t, storage, newStubTransport(stubStep{err: errors.New("connection refused")}), func(c *Config) {
+		c.MaxRetries = 0
+		c.WebhookSecret = "webhook-secret"
+		c.HealthQueueDegradedDepth = 2
+		c.HealthGatewayDownFailures = 2
+	})
+	ctx := context.Background()
+
+	report := client.Health(ctx)
+	if report.Status != HealthOK || report.Components[HealthComponentGateway].Message != "No requests yet" {
+		t.Fatalf("idle report %+v", report)
+	}
+	for _, name := range []string{HealthComponentWebhookDispatcher, HealthComponentRetryQueue} {
+		if depth := report.Components[name].QueueDepth; depth == nil || *depth != 0 {
+			t.Fatalf("%s depth %v", name, depth)
+		}
+	}
+
+	// Queues are degraded once backed up
+	untrack := []func(){client.health.trackRetry(), client.health.trackRetry(), client.health.trackWebhook()}
+	report = client.Health(ctx)
+	if report.Status != HealthDegraded || report.Components[HealthComponentRetryQueue].State != HealthDegraded || *report.Components[HealthComponentWebhookDispatcher].QueueDepth != 1 {
+		t.Fatalf("backed up report %+v", report)
+	}
+	for _, fn := range untrack {
+		fn()
+		fn()
+	}
+	if report := client.Health(ctx); report.Status != HealthOK {
+		t.Fatalf("report after the queues emptied %+v", report)
+	}
+
+	// The gateway is degraded after an outage and down after several
+	client.GetPaymentStatus(ctx, webhookToken)
+	if gateway := client.Health(ctx).Components[HealthComponentGateway]; gateway.State != HealthDegraded {
+		t.Fatalf("gateway after one outage %+v", gateway)
+	}
+	client.GetPaymentStatus(ctx, webhookToken)
+	if report := client.Health(ctx); report.Status != HealthDown || report.StatusCode() != http.StatusServiceUnavailable {
+		t.Fatalf("report after two outages %+v", report)
+	}
+
+	// A successful request clears it
+	client = client.Clone(WithClientHTTPClient(newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": true}))))
+	client.GetPaymentStatus(ctx, webhookToken)
+	if gateway := client.Health(ctx).Components[HealthComponentGateway]; gateway.State != HealthOK {
+		t.Fatalf("gateway after a success %+v", gateway)
+	}
+}
+
+func TestHealthRouteInvalidProbe(t *testing.T) {
+	storage := &slowStorage{MemoryStorage: NewMemoryStorage(), clock: NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))}
+	client := healthClient(t, storage, nil)
+	if rec, _ := probeHealth(client.Handler(WithHealthRoute()), "startup"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
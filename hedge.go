@@ -0,0 +1,130 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// hedge.go implements hedged requests for idempotent gateway lookups
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHedgePercent is the share of lookups that may be hedged when Config.HedgePercent is not set
+	defaultHedgePercent = 10
+
+	// hedgeBudgetBurst is the number of hedges that may be saved up during quiet periods
+	hedgeBudgetBurst = 10
+)
+
+// hedgeBudget limits hedged requests to a share of eligible requests. Every
+// eligible request earns a fraction of a hedge and every hedge spends a whole one.
+// The budget is kept in hundredths of a hedge so that fractions add up exactly.
+type hedgeBudget struct {
+	mutex  sync.Mutex
+	tokens int
+}
+
+// earn credits the budget for an eligible request
+func (b *hedgeBudget) earn(percent int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.tokens += percent
+	if b.tokens > hedgeBudgetBurst*100 {
+		b.tokens = hedgeBudgetBurst * 100
+	}
+}
+
+// spend takes one hedge from the budget, reporting whether one was available
+func (b *hedgeBudget) spend() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.tokens < 100 {
+		return false
+	}
+	b.tokens -= 100
+	return true
+}
+
+// attemptResult is the outcome of one request attempt
+type attemptResult struct {
+	body       []byte
+	statusCode int
+	err        error
+	hedge      bool
+}
+
+// hedgeDelay returns how long a lookup may take before it is hedged, or zero
+// when the request must not be hedged. Only GET requests are idempotent.
+func (c *Client) hedgeDelay(method string) time.Duration {
+	if method != http.MethodGet || c.hedges == nil {
+		return 0
+	}
+	return configValues(c.config).HedgeDelay
+}
+
+// hedgePercent returns the share of lookups that may be hedged
+func (c *Client) hedgePercent() int {
+	if percent := configValues(c.config).HedgePercent; percent > 0 {
+		return percent
+	}
+	return defaultHedgePercent
+}
+
+// hedgedAttempt performs an attempt and, when it hasn't completed within the hedge
+// delay and the budget allows, a second identical one. The first success wins and
+// the other attempt is cancelled; when both fail, the last error is returned.
+func (c *Client) hedgedAttempt(ctx context.Context, method, endpoint string, jsonData []byte) ([]byte, int, error) {
+	delay := c.hedgeDelay(method)
+	if delay <= 0 {
+		return c.doAttempt(ctx, method, endpoint, jsonData)
+	}
+	c.hedges.earn(c.hedgePercent())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan attemptResult, 2)
+	launch := func(hedge bool) {
+		go func() {
+			body, statusCode, err := c.doAttempt(ctx, method, endpoint, jsonData)
+			results <- attemptResult{body: body, statusCode: statusCode, err: err, hedge: hedge}
+		}()
+	}
+
+	launch(false)
+	pending := 1
+
+	timer := c.clock.NewTimer(delay)
+	defer timer.Stop()
+
+	var result attemptResult
+	for pending > 0 {
+		select {
+		case result = <-results:
+			pending--
+			if result.err == nil {
+				if result.hedge {
+					c.metrics.IncCounter(MetricHedgeWins, nil)
+				}
+				return result.body, result.statusCode, nil
+			}
+		case <-timer.C():
+			if !c.hedges.spend() {
+				continue
+			}
+			c.metrics.IncCounter(MetricHedgedRequests, nil)
+			c.log(ctx).Debug(ctx, "Hedging slow API request", map[string]interface{}{
+				"method":   method,
+				"endpoint": redactEndpoint(endpoint),
+				"delay_ms": delay.Milliseconds(),
+			})
+			launch(true)
+			pending++
+		}
+	}
+
+	return result.body, result.statusCode, result.err
+}
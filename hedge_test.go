@@ -0,0 +1,108 @@
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// hedgeConfig enables hedging after a short delay with room to hedge every lookup
+func hedgeConfig(c *Config) {
+	c.HedgeDelay = 20 * time.Millisecond
+	c.HedgePercent = 100
+}
+
+func TestHedgedLookup(t *testing.T) {
+	slow := jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "source": "first"})
+	slow.delay = 3 * time.Second
+	transport := newStubTransport(slow, jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "source": "hedge"}))
+	metrics := newRecordingMetrics()
+	client, _, _ := newTestClient(t, testConfig(t, hedgeConfig), transport, WithClientMetrics(metrics))
+
+	start := time.Now()
+	body, statusCode, err := client.makeRequest(context.Background(), http.MethodGet, client.statusEndpoint("tok"), nil)
+	if err != nil || statusCode != http.StatusOK {
+		t.Fatalf("makeRequest() = %d, %v", statusCode, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("hedged lookup took %v", elapsed)
+	}
+	if string(body) != `{"source":"hedge","status":1}` || transport.count() != 2 {
+		t.Fatalf("%d requests answered with %s", transport.count(), body)
+	}
+
+	// The slow first request is cancelled once the hedge answered
+	if first, _ := transport.request(0); first.Context().Err() == nil {
+		t.Fatal("losing request was not cancelled")
+	}
+	if metrics.counter(MetricHedgedRequests) != 1 || metrics.counter(MetricHedgeWins) != 1 {
+		t.Fatalf("%d hedged requests, %d hedge wins", metrics.counter(MetricHedgedRequests), metrics.counter(MetricHedgeWins))
+	}
+}
+
+func TestHedgingSkipped(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		delay  time.Duration
+		config func(c *Config)
+	}{
+		{"fast lookup", http.MethodGet, 0, hedgeConfig},
+		{"post", http.MethodPost, 100 * time.Millisecond, hedgeConfig},
+		{"hedging disabled", http.MethodGet, 100 * time.Millisecond, func(c *Config) {}},
+		{"budget spent", http.MethodGet, 100 * time.Millisecond, func(c *Config) {
+			hedgeConfig(c)
+			c.HedgePercent = 10
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := jsonStep(http.StatusOK, map[string]interface{}{"status": 1})
+			step.delay = tt.delay
+			transport := newStubTransport(step)
+			metrics := newRecordingMetrics()
+			client, _, _ := newTestClient(t, testConfig(t, tt.config), transport, WithClientMetrics(metrics))
+
+			endpoint := client.statusEndpoint("tok")
+			if tt.method == http.MethodPost {
+				endpoint = client.endpoints().Verify
+			}
+			if _, _, err := client.makeRequest(context.Background(), tt.method, endpoint, nil); err != nil {
+				t.Fatal(err)
+			}
+			if transport.count() != 1 || metrics.counter(MetricHedgedRequests) != 0 {
+				t.Fatalf("%d requests, %d hedged", transport.count(), metrics.counter(MetricHedgedRequests))
+			}
+		})
+	}
+}
+
+func TestHedgeBudget(t *testing.T) {
+	var budget hedgeBudget
+
+	// At 10% every tenth lookup earns a hedge
+	for i := 0; i < 9; i++ {
+		budget.earn(10)
+		if budget.spend() {
+			t.Fatalf("hedge available after %d lookups", i+1)
+		}
+	}
+	budget.earn(10)
+	if !budget.spend() || budget.spend() {
+		t.Fatal("ten lookups should earn exactly one hedge")
+	}
+
+	// Quiet periods save up only a limited burst
+	for i := 0; i < 1000; i++ {
+		budget.earn(100)
+	}
+	spent := 0
+	for budget.spend() {
+		spent++
+	}
+	if spent != hedgeBudgetBurst {
+		t.Fatalf("%d hedges saved up, want %d", spent, hedgeBudgetBurst)
+	}
+}
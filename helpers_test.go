@@ -0,0 +1,298 @@
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAPIKey is the API key of testConfig
+const testAPIKey = "test-api-key"
+
+// testConfig returns a valid sandbox configuration with fast retries, changed by mutate
+func testConfig(t testing.TB, mutate ...func(*Config)) ConfigInterface {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.APIKey = testAPIKey
+	config.BaseURL = SandboxBaseURL
+	config.CallbackURL = "https://shop.example.com/payments/callback"
+	config.Timeout = 5
+	config.RetryWaitTime = time.Millisecond
+	for _, fn := range mutate {
+		fn(&config)
+	}
+
+	values, err := NewConfig(config)
+	if err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+	return values
+}
+
+// newTestClient creates a client with memory storage and a capturing logger
+// sending gateway requests to transport
+func newTestClient(t testing.TB, config ConfigInterface, transport HTTPClientInterface, opts ...ClientOption) (*Client, *MemoryStorage, *captureLogger) {
+	t.Helper()
+
+	storage := NewMemoryStorage()
+	logger := &captureLogger{}
+	client, err := NewClient(config, storage, logger)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	if transport != nil {
+		opts = append([]ClientOption{WithClientHTTPClient(transport)}, opts...)
+	}
+	return client.Clone(opts...), storage, logger
+}
+
+// logEntry is an entry captured by captureLogger
+type logEntry struct {
+	level   string
+	message string
+	err     error
+	fields  map[string]interface{}
+}
+
+// captureLogger records log entries for assertions
+type captureLogger struct {
+	mutex   sync.Mutex
+	entries []logEntry
+}
+
+func (l *captureLogger) Debug(ctx context.Context, message string, fields map[string]interface{}) {
+	l.add(logEntry{level: "debug", message: message, fields: fields})
+}
+
+func (l *captureLogger) Info(ctx context.Context, message string, fields map[string]interface{}) {
+	l.add(logEntry{level: "info", message: message, fields: fields})
+}
+
+func (l *captureLogger) Warn(ctx context.Context, message string, fields map[string]interface{}) {
+	l.add(logEntry{level: "warn", message: message, fields: fields})
+}
+
+func (l *captureLogger) Error(ctx context.Context, message string, err error, fields map[string]interface{}) {
+	l.add(logEntry{level: "error", message: message, err: err, fields: fields})
+}
+
+func (l *captureLogger) add(entry logEntry) {
+	fields := make(map[string]interface{}, len(entry.fields))
+	for key, value := range entry.fields {
+		fields[key] = value
+	}
+	entry.fields = fields
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = append(l.entries, entry)
+}
+
+// all returns the captured entries in order
+func (l *captureLogger) all() []logEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]logEntry(nil), l.entries...)
+}
+
+// at returns the captured entries of a level
+func (l *captureLogger) at(level string) []logEntry {
+	var result []logEntry
+	for _, entry := range l.all() {
+		if entry.level == level {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// find returns the first entry whose message contains substr
+func (l *captureLogger) find(substr string) (logEntry, bool) {
+	for _, entry := range l.all() {
+		if strings.Contains(entry.message, substr) {
+			return entry, true
+		}
+	}
+	return logEntry{}, false
+}
+
+// dump renders every entry with its fields, for searching the whole output
+func (l *captureLogger) dump() string {
+	var buf strings.Builder
+	for _, entry := range l.all() {
+		fields, _ := json.Marshal(entry.fields)
+		fmt.Fprintf(&buf, "%s %s %v %s\n", entry.level, entry.message, entry.err, fields)
+	}
+	return buf.String()
+}
+
+// stubStep is one answer of a stubTransport
+type stubStep struct {
+	delay  time.Duration
+	status int
+	body   string
+	header http.Header
+	err    error
+}
+
+// stubTransport answers requests with its steps in order, repeating the last one,
+// and records the requests. Delays end early when the request is cancelled.
+type stubTransport struct {
+	mutex    sync.Mutex
+	steps    []stubStep
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newStubTransport(steps ...stubStep) *stubTransport {
+	return &stubTransport{steps: steps}
+}
+
+// jsonStep returns a step answering with a JSON body
+func jsonStep(status int, body interface{}) stubStep {
+	data, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+	return stubStep{status: status, body: string(data)}
+}
+
+func (t *stubTransport) Do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req.Body.Close()
+	}
+
+	t.mutex.Lock()
+	index := len(t.requests)
+	t.requests = append(t.requests, req)
+	t.bodies = append(t.bodies, body)
+	step := t.steps[len(t.steps)-1]
+	if index < len(t.steps) {
+		step = t.steps[index]
+	}
+	t.mutex.Unlock()
+
+	if step.delay > 0 {
+		timer := time.NewTimer(step.delay)
+		defer timer.Stop()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	if step.err != nil {
+		return nil, step.err
+	}
+
+	status := step.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := step.header.Clone()
+	if header == nil {
+		header = http.Header{"Content-Type": []string{"application/json"}}
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader([]byte(step.body))),
+		Request:    req,
+	}, nil
+}
+
+// count returns how many requests were received
+func (t *stubTransport) count() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.requests)
+}
+
+// request returns the i-th received request and its body
+func (t *stubTransport) request(i int) (*http.Request, []byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.requests[i], t.bodies[i]
+}
+
+// transportFunc is an HTTPClientInterface calling a function
+type transportFunc func(req *http.Request) (*http.Response, error)
+
+func (f transportFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// stubResponse builds a response with a JSON body
+func stubResponse(req *http.Request, status int, body interface{}) *http.Response {
+	data, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}
+}
+
+// recordingMetrics counts the metrics recorded by name, ignoring labels
+type recordingMetrics struct {
+	mutex     sync.Mutex
+	counters  map[string]int
+	durations map[string]int
+	gauges    map[string]float64
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		counters:  make(map[string]int),
+		durations: make(map[string]int),
+		gauges:    make(map[string]float64),
+	}
+}
+
+func (m *recordingMetrics) IncCounter(name string, labels map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.counters[name]++
+}
+
+func (m *recordingMetrics) ObserveDuration(name string, duration time.Duration, labels map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.durations[name]++
+}
+
+func (m *recordingMetrics) SetGauge(name string, value float64, labels map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.gauges[name] = value
+}
+
+// counter returns how often a counter was incremented
+func (m *recordingMetrics) counter(name string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.counters[name]
+}
+
+// gauge returns the last value a gauge was set to
+func (m *recordingMetrics) gauge(name string) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.gauges[name]
+}
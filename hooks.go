@@ -0,0 +1,215 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// hooks.go implements optional callbacks for payment lifecycle events
+package vandargo
+
+import (
+	"context"
+	"fmt"
+)
+
+// Hooks are optional functions called on payment lifecycle events. Hooks run
+// synchronously and receive a copy of the transaction; a panicking hook is
+// recovered and logged.
+type Hooks struct {
+	// OnPaymentInitiated is called after a payment token is issued and stored
+	OnPaymentInitiated func(ctx context.Context, transaction *Transaction)
+
+	// OnPaymentVerified is called after a payment is verified successfully
+	OnPaymentVerified func(ctx context.Context, transaction *Transaction)
+
+	// OnCallback is called after a callback updated a transaction; duplicate
+	// callbacks for terminal transactions don't fire it
+	OnCallback func(ctx context.Context, transaction *Transaction, data *CallbackData)
+
+	// OnStatusChange is called whenever a stored transaction changes status
+	OnStatusChange func(ctx context.Context, transaction *Transaction, from, to TransactionStatus)
+
+	// OnRefundCompleted is called when a RefundTracker sees a refund settle
+	OnRefundCompleted func(ctx context.Context, refund *Refund)
+
+	// OnRefundFailed is called when a RefundTracker sees the gateway reject a refund
+	OnRefundFailed func(ctx context.Context, refund *Refund)
+
+	// OnWebhookEvent is called after a business webhook event was applied, including
+	// events of unknown types
+	OnWebhookEvent func(ctx context.Context, event *WebhookEvent)
+
+	// OnVerificationAtRisk is called once for a payment whose callback succeeded, or
+	// which is VERIFY_PENDING, and which is still unverified
+	// Config.VerifyWarningThreshold later, e.g. to verify it
+	OnVerificationAtRisk func(ctx context.Context, transaction *Transaction)
+
+	// OnVerificationIndeterminate is called when a verification's outcome stays
+	// unknown after its retry, leaving the payment VERIFY_PENDING for a person to
+	// reconcile
+	OnVerificationIndeterminate func(ctx context.Context, transaction *Transaction, err error)
+
+	// OnVelocityViolation is called when a velocity rule refuses a payment
+	// initialization, e.g. to queue the payer for fraud review
+	OnVelocityViolation func(ctx context.Context, violation *VelocityViolation)
+
+	// OnEchoMismatch is called once for a payment whose description or factor
+	// number echoed by the gateway doesn't match what was sent, after it was
+	// marked SUSPECT; it may mean tokens were swapped
+	OnEchoMismatch func(ctx context.Context, transaction *Transaction, mismatches []EchoMismatch)
+}
+
+// WithHooks returns a copy of the client calling the lifecycle hooks
+func (c *Client) WithHooks(hooks Hooks) *Client {
+	return c.Clone(WithClientHooks(hooks))
+}
+
+// runHook calls a hook, recovering and logging panics so they can't break request handling
+func (c *Client) runHook(ctx context.Context, name string, fn func()) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			c.log(ctx).Error(ctx, "Hook panicked", fmt.Errorf("%v", recovered), map[string]interface{}{
+				"hook": name,
+			})
+		}
+	}()
+
+	fn()
+}
+
+// firePaymentInitiated calls the OnPaymentInitiated hook
+func (c *Client) firePaymentInitiated(ctx context.Context, transaction *Transaction) {
+	c.metrics.IncCounter(MetricTransactions, map[string]string{"status": string(transaction.Status)})
+
+	if c.hooks.OnPaymentInitiated == nil {
+		return
+	}
+
+	txCopy := *transaction
+	c.runHook(ctx, "OnPaymentInitiated", func() {
+		c.hooks.OnPaymentInitiated(ctx, &txCopy)
+	})
+}
+
+// firePaymentVerified calls the OnPaymentVerified hook
+func (c *Client) firePaymentVerified(ctx context.Context, transaction *Transaction) {
+	if c.hooks.OnPaymentVerified == nil {
+		return
+	}
+
+	txCopy := *transaction
+	c.runHook(ctx, "OnPaymentVerified", func() {
+		c.hooks.OnPaymentVerified(ctx, &txCopy)
+	})
+}
+
+// fireCallback calls the OnCallback hook
+func (c *Client) fireCallback(ctx context.Context, transaction *Transaction, data *CallbackData) {
+	if c.hooks.OnCallback == nil {
+		return
+	}
+
+	txCopy := *transaction
+	dataCopy := *data
+	c.runHook(ctx, "OnCallback", func() {
+		c.hooks.OnCallback(ctx, &txCopy, &dataCopy)
+	})
+}
+
+// fireStatusChange counts the new status and calls the OnStatusChange hook when
+// the status actually changed
+func (c *Client) fireStatusChange(ctx context.Context, transaction *Transaction, from TransactionStatus) {
+	if from == transaction.Status {
+		return
+	}
+	c.metrics.IncCounter(MetricTransactions, map[string]string{"status": string(transaction.Status)})
+
+	if c.hooks.OnStatusChange == nil {
+		return
+	}
+
+	txCopy := *transaction
+	c.runHook(ctx, "OnStatusChange", func() {
+		c.hooks.OnStatusChange(ctx, &txCopy, from, txCopy.Status)
+	})
+}
+
+// fireRefundCompleted calls the OnRefundCompleted hook
+func (c *Client) fireRefundCompleted(ctx context.Context, refund *Refund) {
+	if c.hooks.OnRefundCompleted == nil {
+		return
+	}
+
+	refundCopy := *refund
+	c.runHook(ctx, "OnRefundCompleted", func() {
+		c.hooks.OnRefundCompleted(ctx, &refundCopy)
+	})
+}
+
+// fireRefundFailed calls the OnRefundFailed hook
+func (c *Client) fireRefundFailed(ctx context.Context, refund *Refund) {
+	if c.hooks.OnRefundFailed == nil {
+		return
+	}
+
+	refundCopy := *refund
+	c.runHook(ctx, "OnRefundFailed", func() {
+		c.hooks.OnRefundFailed(ctx, &refundCopy)
+	})
+}
+
+// fireWebhookEvent calls the OnWebhookEvent hook
+func (c *Client) fireWebhookEvent(ctx context.Context, event *WebhookEvent) {
+	if c.hooks.OnWebhookEvent == nil {
+		return
+	}
+
+	eventCopy := *event
+	c.runHook(ctx, "OnWebhookEvent", func() {
+		c.hooks.OnWebhookEvent(ctx, &eventCopy)
+	})
+}
+
+// fireVerificationAtRisk calls the OnVerificationAtRisk hook
+func (c *Client) fireVerificationAtRisk(ctx context.Context, transaction *Transaction) {
+	if c.hooks.OnVerificationAtRisk == nil {
+		return
+	}
+
+	txCopy := *transaction
+	c.runHook(ctx, "OnVerificationAtRisk", func() {
+		c.hooks.OnVerificationAtRisk(ctx, &txCopy)
+	})
+}
+
+// fireVerificationIndeterminate calls the OnVerificationIndeterminate hook
+func (c *Client) fireVerificationIndeterminate(ctx context.Context, transaction *Transaction, err error) {
+	if c.hooks.OnVerificationIndeterminate == nil {
+		return
+	}
+
+	txCopy := *transaction
+	c.runHook(ctx, "OnVerificationIndeterminate", func() {
+		c.hooks.OnVerificationIndeterminate(ctx, &txCopy, err)
+	})
+}
+
+// fireVelocityViolation calls the OnVelocityViolation hook
+func (c *Client) fireVelocityViolation(ctx context.Context, violation *VelocityViolation) {
+	if c.hooks.OnVelocityViolation == nil {
+		return
+	}
+
+	violationCopy := *violation
+	c.runHook(ctx, "OnVelocityViolation", func() {
+		c.hooks.OnVelocityViolation(ctx, &violationCopy)
+	})
+}
+
+// fireEchoMismatch calls the OnEchoMismatch hook
+func (c *Client) fireEchoMismatch(ctx context.Context, transaction *Transaction, mismatches []EchoMismatch) {
+	if c.hooks.OnEchoMismatch == nil {
+		return
+	}
+
+	txCopy := *transaction
+	mismatchesCopy := append([]EchoMismatch(nil), mismatches...)
+	c.runHook(ctx, "OnEchoMismatch", func() {
+		c.hooks.OnEchoMismatch(ctx, &txCopy, mismatchesCopy)
+	})
+}
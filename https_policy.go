@@ -0,0 +1,70 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// https_policy.go implements the https requirement for configured and requested URLs
+package vandargo
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// HTTPSEnforced reports whether http URLs are rejected. Unless EnforceHTTPS says
+// otherwise, https is enforced outside sandbox mode.
+func (c *Config) HTTPSEnforced() bool {
+	if c.EnforceHTTPS != nil {
+		return *c.EnforceHTTPS
+	}
+	return !c.SandboxMode
+}
+
+// validateURLSchemes checks the configured URLs against the https requirement
+func (c *Config) validateURLSchemes() error {
+	var errs ValidationErrors
+
+	for _, u := range []struct{ field, raw string }{
+		{"base_url", c.BaseURL},
+		{"callback_url", c.CallbackURL},
+		{"return_url", c.ReturnURL},
+	} {
+		if err := c.checkURLScheme(u.field, u.raw); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkURLScheme returns a validation error when a URL uses http while https is
+// enforced, unless it points at localhost and AllowInsecureLocalhost is set
+func (c *Config) checkURLScheme(field, raw string) *ValidationError {
+	if raw == "" || !c.HTTPSEnforced() {
+		return nil
+	}
+
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || !strings.EqualFold(parsed.Scheme, "http") {
+		return nil
+	}
+
+	if c.AllowInsecureLocalhost && isLocalhost(parsed.Hostname()) {
+		return nil
+	}
+
+	return &ValidationError{
+		Field:   field,
+		Message: strings.ReplaceAll(strings.TrimSuffix(field, "_url"), "_", " ") + " URL must use https",
+	}
+}
+
+// isLocalhost reports whether a host names the local machine
+func isLocalhost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
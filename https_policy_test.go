@@ -0,0 +1,101 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPSPolicyMatrix(t *testing.T) {
+	enforce, relax := true, false
+	tests := []struct {
+		name      string
+		sandbox   bool
+		enforce   *bool
+		localhost bool
+		baseURL   string
+		callback  string
+		rejected  []string
+	}{
+		{"sandbox allows http", true, nil, false, "http://sandbox.example.com", "http://shop.example.com/callback", nil},
+		{"production requires https", false, nil, false, "http://gateway.example.com", "http://shop.example.com/callback", []string{"base_url", "callback_url"}},
+		{"production with https", false, nil, false, ProductionBaseURL, "https://shop.example.com/callback", nil},
+		{"production callback only", false, nil, false, ProductionBaseURL, "http://shop.example.com/callback", []string{"callback_url"}},
+		{"sandbox enforced", true, &enforce, false, SandboxBaseURL, "http://shop.example.com/callback", []string{"callback_url"}},
+		{"production relaxed", false, &relax, false, "http://gateway.example.com", "http://shop.example.com/callback", nil},
+		{"localhost without allowance", false, nil, false, "http://127.0.0.1:8080", "http://localhost:3000/callback", []string{"base_url", "callback_url"}},
+		{"localhost allowed", false, nil, true, "http://127.0.0.1:8080", "http://localhost:3000/callback", nil},
+		{"IPv6 loopback allowed", false, nil, true, "http://[::1]:8080", "https://shop.example.com/callback", nil},
+		{"allowance is localhost only", false, nil, true, "http://gateway.example.com", "http://localhost:3000/callback", []string{"base_url"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.APIKey = testAPIKey
+			config.SandboxMode = tt.sandbox
+			config.EnforceHTTPS = tt.enforce
+			config.AllowInsecureLocalhost = tt.localhost
+			config.BaseURL = tt.baseURL
+			config.CallbackURL = tt.callback
+
+			err := config.Validate()
+			if len(tt.rejected) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+
+			// Every offending field is named
+			var errs ValidationErrors
+			if !errors.As(err, &errs) || len(errs) != len(tt.rejected) {
+				t.Fatalf("Validate() = %#v, want errors for %v", err, tt.rejected)
+			}
+			for i, field := range tt.rejected {
+				if errs[i].Field != field {
+					t.Errorf("error %d names %q, want %q", i, errs[i].Field, field)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPSPolicyPerPaymentCallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		sandbox  bool
+		callback string
+		rejected bool
+	}{
+		{"sandbox http", true, "http://shop.example.com/orders/1042", false},
+		{"production http", false, "http://shop.example.com/orders/1042", true},
+		{"production https", false, "https://shop.example.com/orders/1042", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": webhookToken}))
+			config := testConfig(t, func(c *Config) { c.SandboxMode = tt.sandbox })
+			client, _, _ := newTestClient(t, config, transport)
+
+			_, err := client.InitiatePaymentWithRequest(context.Background(), &PaymentInitRequest{
+				Amount:      100000,
+				CallbackURL: tt.callback,
+				Description: "Order 1042",
+			}, nil)
+
+			var validationErr *ValidationError
+			if tt.rejected {
+				if !errors.As(err, &validationErr) || validationErr.Field != "callback_url" || transport.count() != 0 {
+					t.Fatalf("InitiatePaymentWithRequest() = %v after %d gateway requests", err, transport.count())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
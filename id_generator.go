@@ -0,0 +1,142 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// id_generator.go implements pluggable transaction and request ID generation
+package vandargo
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// maxRequestIDLength is the longest incoming X-Request-ID that is echoed back
+const maxRequestIDLength = 128
+
+// headerSafeIDRegex matches IDs that can be echoed into response headers and logs
+var headerSafeIDRegex = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// IDGenerator creates the IDs of stored transactions and of requests
+type IDGenerator interface {
+	// NewTransactionID returns a new transaction ID
+	NewTransactionID() string
+
+	// NewRequestID returns a new request ID for the X-Request-ID header
+	NewRequestID() string
+}
+
+// defaultIDGenerator is used by clients and middlewares without a configured generator
+var defaultIDGenerator IDGenerator = RandomIDGenerator{}
+
+// RandomIDGenerator creates 128-bit crypto-random IDs encoded as 32 hex characters
+type RandomIDGenerator struct{}
+
+// NewTransactionID returns a new random transaction ID
+func (RandomIDGenerator) NewTransactionID() string {
+	return randomHexID()
+}
+
+// NewRequestID returns a new random request ID
+func (RandomIDGenerator) NewRequestID() string {
+	return randomHexID()
+}
+
+// randomHexID returns 16 crypto-random bytes as hex
+func randomHexID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand doesn't fail on supported platforms; stay unique regardless
+		binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// crockfordAlphabet is the Crockford base32 alphabet used by ULIDs
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator creates ULIDs: 26 character IDs that sort by creation time. IDs
+// created in the same millisecond increment the random part, so they stay sorted
+// within one generator.
+type ULIDGenerator struct {
+	mutex    sync.Mutex
+	lastTime uint64
+	lastRand [10]byte
+}
+
+// NewULIDGenerator creates a ULID generator
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{}
+}
+
+// NewTransactionID returns a new ULID
+func (g *ULIDGenerator) NewTransactionID() string {
+	return g.next(time.Now())
+}
+
+// NewRequestID returns a new ULID
+func (g *ULIDGenerator) NewRequestID() string {
+	return g.next(time.Now())
+}
+
+// next returns the ULID following the previous one for a time
+func (g *ULIDGenerator) next(now time.Time) string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	ms := uint64(now.UnixMilli())
+	if ms > g.lastTime {
+		g.lastTime = ms
+		if _, err := rand.Read(g.lastRand[:]); err != nil {
+			g.lastRand = [10]byte{}
+		}
+	} else {
+		// Same millisecond or a clock step back: keep the order by incrementing
+		for i := len(g.lastRand) - 1; i >= 0; i-- {
+			g.lastRand[i]++
+			if g.lastRand[i] != 0 {
+				break
+			}
+		}
+	}
+
+	var id [16]byte
+	id[0] = byte(g.lastTime >> 40)
+	id[1] = byte(g.lastTime >> 32)
+	id[2] = byte(g.lastTime >> 24)
+	id[3] = byte(g.lastTime >> 16)
+	id[4] = byte(g.lastTime >> 8)
+	id[5] = byte(g.lastTime)
+	copy(id[6:], g.lastRand[:])
+
+	return encodeULID(id)
+}
+
+// encodeULID encodes 128 bits as 26 Crockford base32 characters
+func encodeULID(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	// 26 characters of 5 bits hold 130 bits; the first character holds the top 3 bits
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(out)
+}
+
+// isHeaderSafeID reports whether an ID can be echoed into the X-Request-ID header
+func isHeaderSafeID(id string) bool {
+	return id != "" && len(id) <= maxRequestIDLength && headerSafeIDRegex.MatchString(id)
+}
+
+// idGenerator returns the ID generator of the client
+func (c *Client) idGenerator() IDGenerator {
+	if c.ids != nil {
+		return c.ids
+	}
+	return defaultIDGenerator
+}
@@ -0,0 +1,171 @@
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sequentialIDs is an IDGenerator numbering its IDs
+type sequentialIDs struct {
+	next atomic.Int32
+}
+
+func (g *sequentialIDs) NewTransactionID() string {
+	return fmt.Sprintf("tx-%d", g.next.Add(1))
+}
+
+func (g *sequentialIDs) NewRequestID() string {
+	return fmt.Sprintf("req-%d", g.next.Add(1))
+}
+
+func TestRandomIDGenerator(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		for _, id := range []string{RandomIDGenerator{}.NewTransactionID(), RandomIDGenerator{}.NewRequestID()} {
+			if len(id) != 32 || strings.Trim(id, "0123456789abcdef") != "" || !isHeaderSafeID(id) {
+				t.Fatalf("malformed ID %q", id)
+			}
+			if seen[id] {
+				t.Fatalf("duplicate ID %q", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestULIDGenerator(t *testing.T) {
+	generator := NewULIDGenerator()
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// IDs of one millisecond, and of a clock stepping back, keep increasing
+	var ids []string
+	for i := 0; i < 1000; i++ {
+		ids = append(ids, generator.next(at))
+	}
+	ids = append(ids, generator.next(at.Add(-time.Second)), generator.next(at.Add(time.Millisecond)))
+
+	for i, id := range ids {
+		if len(id) != 26 || strings.Trim(id, crockfordAlphabet) != "" {
+			t.Fatalf("malformed ULID %q", id)
+		}
+		if i > 0 && id <= ids[i-1] {
+			t.Fatalf("ULID %d %q doesn't sort after %q", i, id, ids[i-1])
+		}
+	}
+
+	// The first 10 characters encode the millisecond timestamp
+	timestamp := func(id string) int64 {
+		var ms int64
+		for _, c := range id[:10] {
+			ms = ms<<5 | int64(strings.IndexRune(crockfordAlphabet, c))
+		}
+		return ms
+	}
+	if got := timestamp(ids[0]); got != at.UnixMilli() {
+		t.Fatalf("timestamp %d, want %d", got, at.UnixMilli())
+	}
+	if got := timestamp(ids[len(ids)-1]); got != at.UnixMilli()+1 {
+		t.Fatalf("later timestamp %d, want %d", got, at.UnixMilli()+1)
+	}
+
+	// IDs created by the interface sort by creation
+	first := generator.NewTransactionID()
+	time.Sleep(2 * time.Millisecond)
+	second := generator.NewRequestID()
+	if !sort.StringsAreSorted([]string{first, second}) || first == second {
+		t.Fatalf("%q then %q", first, second)
+	}
+}
+
+func TestIsHeaderSafeID(t *testing.T) {
+	tests := []struct {
+		id   string
+		safe bool
+	}{
+		{"01HQ3Z5N8K2M4P6R8T0V2X4Z6B", true},
+		{"req-1.2:3_4", true},
+		{"", false},
+		{"req 1", false},
+		{"req\r\nX-Injected: 1", false},
+		{"req<script>", false},
+		{strings.Repeat("a", maxRequestIDLength), true},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		if got := isHeaderSafeID(tt.id); got != tt.safe {
+			t.Errorf("isHeaderSafeID(%q) = %v", tt.id, got)
+		}
+	}
+}
+
+func TestRequestIDMiddlewareWithGenerator(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", "req-1"},
+		{"incoming-1", "incoming-1"},
+		{"bad id", "req-1"},
+		{strings.Repeat("a", maxRequestIDLength+1), "req-1"},
+	}
+
+	for _, tt := range tests {
+		generator := &sequentialIDs{}
+		var seen interface{}
+		handler := Chain(func(w http.ResponseWriter, r *http.Request) {
+			seen = r.Context().Value("request_id")
+		}, RequestIDMiddlewareWithGenerator(generator))
+
+		req := httptest.NewRequest(http.MethodGet, "/payments/status", nil)
+		if tt.header != "" {
+			req.Header.Set("X-Request-ID", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Header().Get("X-Request-ID") != tt.want || seen != tt.want {
+			t.Fatalf("header %q: echoed %q, context %v, want %q", tt.header, rec.Header().Get("X-Request-ID"), seen, tt.want)
+		}
+	}
+
+	// Without a generator the default one is used
+	rec := httptest.NewRecorder()
+	Chain(func(w http.ResponseWriter, r *http.Request) {}, RequestIDMiddlewareWithGenerator(nil))(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if id := rec.Header().Get("X-Request-ID"); len(id) != 32 {
+		t.Fatalf("default request ID %q", id)
+	}
+}
+
+func TestClientIDGenerator(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": "tok-ids"}))
+	client, storage, _ := newTestClient(t, testConfig(t), transport, WithClientIDGenerator(&sequentialIDs{}))
+
+	rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/init",
+		`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	// The route, the gateway request and the stored transaction take their IDs in turn
+	if rec.Header().Get("X-Request-ID") != "req-1" {
+		t.Fatalf("response request ID %q", rec.Header().Get("X-Request-ID"))
+	}
+	req, _ := transport.request(0)
+	if !strings.HasPrefix(req.Header.Get("X-Request-ID"), "req-") || req.Header.Get("X-Request-ID") == "req-1" {
+		t.Fatalf("gateway request ID %q", req.Header.Get("X-Request-ID"))
+	}
+	transaction, err := storage.GetTransaction(context.Background(), "tok-ids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(transaction.ID, "tx-") {
+		t.Fatalf("transaction ID %q", transaction.ID)
+	}
+}
@@ -0,0 +1,223 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// idempotency.go implements replaying the response of a request repeated with the same idempotency key
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader carries the key a client sends to make a POST safe to retry
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set to "true" on responses replayed for a repeated key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// DefaultIdempotencyTTL is how long idempotency keys and their responses are kept
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	// maxIdempotencyKeyLength bounds the keys clients may send
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotentResponse is the stored response of a request with an idempotency key
+type IdempotentResponse struct {
+	// StatusCode is the HTTP status code
+	StatusCode int `json:"status_code"`
+
+	// ContentType is the Content-Type header
+	ContentType string `json:"content_type,omitempty"`
+
+	// Body is the response body
+	Body []byte `json:"body"`
+}
+
+// IdempotencyStore remembers requests by idempotency key in a KVStore, so a
+// request repeated with the same key gets the first response instead of being
+// processed twice, also by another instance sharing the store
+type IdempotencyStore struct {
+	kv  KVStore
+	ttl time.Duration
+}
+
+// NewIdempotencyStore creates a store keeping keys for ttl, DefaultIdempotencyTTL when zero or less
+func NewIdempotencyStore(kv KVStore, ttl time.Duration) *IdempotencyStore {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return &IdempotencyStore{kv: kv, ttl: ttl}
+}
+
+// claimKey returns the KV key of a claimed idempotency key
+func (s *IdempotencyStore) claimKey(key string) string {
+	return "vandar:idempotency:" + key
+}
+
+// responseKey returns the KV key of the response of an idempotency key
+func (s *IdempotencyStore) responseKey(key string) string {
+	return "vandar:idempotency:" + key + ":response"
+}
+
+// Claim reserves a key for a request identified by fingerprint and reports
+// whether it did. For a key claimed before it returns the fingerprint it was
+// claimed with and the stored response, nil while that request still runs.
+func (s *IdempotencyStore) Claim(ctx context.Context, key, fingerprint string) (bool, string, *IdempotentResponse, error) {
+	claimed, err := s.kv.SetNX(ctx, s.claimKey(key), []byte(fingerprint), s.ttl)
+	if err != nil || claimed {
+		return claimed, "", nil, err
+	}
+
+	claimedWith, _, err := s.kv.Get(ctx, s.claimKey(key))
+	if err != nil {
+		return false, "", nil, err
+	}
+
+	data, found, err := s.kv.Get(ctx, s.responseKey(key))
+	if err != nil || !found {
+		return false, string(claimedWith), nil, err
+	}
+
+	var resp IdempotentResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return false, string(claimedWith), nil, fmt.Errorf("failed to parse stored response: %w", err)
+	}
+	return false, string(claimedWith), &resp, nil
+}
+
+// Complete stores the response of a claimed key for repeats
+func (s *IdempotencyStore) Complete(ctx context.Context, key string, resp IdempotentResponse) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	_, err = s.kv.SetNX(ctx, s.responseKey(key), data, s.ttl)
+	return err
+}
+
+// Release gives up a claimed key without a response, so the request can be retried
+func (s *IdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.kv.Delete(ctx, s.claimKey(key))
+}
+
+// idempotencyFingerprint identifies a request by method, path and body, so a
+// key reused for another request is rejected
+func idempotencyFingerprint(r *http.Request) string {
+	digest := sha256.New()
+	digest.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	if body, ok := bufferedBody(r); ok {
+		digest.Write(body)
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// IdempotencyMiddleware makes POST requests carrying an Idempotency-Key header
+// safe to retry: the first request with a key is processed and its response
+// stored, repeats get that response with Idempotent-Replayed set, repeats
+// while it runs get 409 and a key reused with another body gets 422. Responses
+// with a 5xx status aren't stored, so those requests can be retried. Requests
+// without the header pass through. The body is fingerprinted when buffered by
+// BufferBodyMiddleware, so place this after it.
+func IdempotencyMiddleware(store *IdempotencyStore, logger LoggerInterface) Middleware {
+	logger = loggerOrDiscard(logger)
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || r.Method != http.MethodPost {
+				next(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				writeJSONError(w, r, http.StatusBadRequest, NewValidationError("Idempotency-Key", "must be at most 255 characters"), "Invalid idempotency key")
+				return
+			}
+
+			// Keys are scoped to the route
+			ctx := r.Context()
+			key = r.URL.Path + ":" + key
+			fingerprint := idempotencyFingerprint(r)
+
+			claimed, claimedWith, stored, err := store.Claim(ctx, key, fingerprint)
+			if err != nil {
+				logger.Error(ctx, "Failed to claim idempotency key", err, nil)
+				writeJSONError(w, r, http.StatusServiceUnavailable, ErrInternalError, "Idempotency keys are unavailable, retry later")
+				return
+			}
+
+			if !claimed {
+				switch {
+				case claimedWith != "" && claimedWith != fingerprint:
+					writeJSONError(w, r, http.StatusUnprocessableEntity, ErrInvalidRequest, "Idempotency key was used for another request")
+				case stored == nil:
+					writeJSONError(w, r, http.StatusConflict, ErrConflict, "A request with this idempotency key is in progress")
+				default:
+					if stored.ContentType != "" {
+						w.Header().Set("Content-Type", stored.ContentType)
+					}
+					w.Header().Set(IdempotentReplayedHeader, "true")
+					w.WriteHeader(stored.StatusCode)
+					w.Write(stored.Body)
+				}
+				return
+			}
+
+			recorder := &idempotencyRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next(recorder, r)
+
+			// The outcome is stored even if the caller went away
+			storeCtx := context.WithoutCancel(ctx)
+			if recorder.statusCode >= http.StatusInternalServerError {
+				if err := store.Release(storeCtx, key); err != nil {
+					logger.Error(ctx, "Failed to release idempotency key", err, nil)
+				}
+				return
+			}
+
+			resp := IdempotentResponse{
+				StatusCode:  recorder.statusCode,
+				ContentType: recorder.Header().Get("Content-Type"),
+				Body:        recorder.body.Bytes(),
+			}
+			if err := store.Complete(storeCtx, key, resp); err != nil {
+				logger.Error(ctx, "Failed to store idempotent response", err, nil)
+			}
+		}
+	}
+}
+
+// idempotencyRecorder passes a response through while keeping a copy
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+// WriteHeader records the status code
+func (r *idempotencyRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.statusCode = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write records the body
+func (r *idempotencyRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (r *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
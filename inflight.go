@@ -0,0 +1,114 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// inflight.go implements draining in-flight operations before shutdown
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// drainRetryAfterSeconds is the Retry-After sent to requests rejected while draining
+const drainRetryAfterSeconds = 5
+
+// inflightKey marks a context whose operation is already tracked
+const inflightKey contextKey = "inflight"
+
+// inflightTracker counts critical sections, such as a verification and the
+// storage update recording it, so shutdown can wait for them as one unit
+type inflightTracker struct {
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+	draining bool
+}
+
+// newInflightTracker creates an empty tracker
+func newInflightTracker() *inflightTracker {
+	return &inflightTracker{}
+}
+
+// begin registers a critical section and returns its context and done function.
+// Nested sections are part of the outer one, so they aren't refused mid-flight.
+func (t *inflightTracker) begin(ctx context.Context) (context.Context, func(), error) {
+	if t == nil || ctx.Value(inflightKey) != nil {
+		return ctx, func() {}, nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.draining {
+		return ctx, func() {}, ErrShuttingDown
+	}
+
+	t.wg.Add(1)
+	var once sync.Once
+	return context.WithValue(ctx, inflightKey, true), func() { once.Do(t.wg.Done) }, nil
+}
+
+// isDraining reports whether the tracker refuses new critical sections
+func (t *inflightTracker) isDraining() bool {
+	if t == nil {
+		return false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.draining
+}
+
+// drain refuses new critical sections and waits for the running ones
+func (t *inflightTracker) drain(ctx context.Context) error {
+	t.mutex.Lock()
+	t.draining = true
+	t.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: in-flight operations did not finish: %w", ErrShutdownFailed, ctx.Err())
+	}
+}
+
+// beginOperation registers a critical section of the client
+func (c *Client) beginOperation(ctx context.Context) (context.Context, func(), error) {
+	return c.inflight.begin(ctx)
+}
+
+// DrainAndWait stops the client from starting new operations, which then fail with
+// ErrShuttingDown, and waits until running operations, including their storage
+// updates, have finished or ctx is done. Call it before shutting down a server
+// that embeds the payment handlers; Serve calls it itself.
+func (c *Client) DrainAndWait(ctx context.Context) error {
+	if c.inflight == nil {
+		return nil
+	}
+	return c.inflight.drain(ctx)
+}
+
+// InflightMiddleware tracks each request as one in-flight operation and answers
+// 503 with Retry-After once the client is draining
+func (c *Client) InflightMiddleware() Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx, done, err := c.beginOperation(r.Context())
+			if err != nil {
+				w.Header().Set("Retry-After", strconv.Itoa(drainRetryAfterSeconds))
+				writeJSONError(w, r, http.StatusServiceUnavailable, ErrShuttingDown, "Server is shutting down, please retry")
+				return
+			}
+			defer done()
+
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postVerify sends a verify request for the token through handler
+func postVerify(handler http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/payments/verify", strings.NewReader(`{"token":"`+token+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDrainAndWaitFinishesSlowVerify(t *testing.T) {
+	step := verifySuccess()
+	step.delay = 200 * time.Millisecond
+	transport := newStubTransport(step)
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+	handler := client.Handler()
+
+	verified := make(chan *httptest.ResponseRecorder, 1)
+	go func() { verified <- postVerify(handler, webhookToken) }()
+	for transport.count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.DrainAndWait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The storage update landed before DrainAndWait returned
+	transaction, err := storage.GetTransaction(context.Background(), webhookToken)
+	if err != nil || transaction.Status != StatusPaid {
+		t.Fatalf("after draining: %+v, %v", transaction, err)
+	}
+	if rec := <-verified; rec.Code != http.StatusOK {
+		t.Fatalf("in-flight verify answered %d: %s", rec.Code, rec.Body)
+	}
+
+	// New requests are turned away with a retry hint
+	rec := postVerify(handler, webhookToken)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "5" {
+		t.Fatalf("request while draining: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// and so are direct client calls
+	if _, err := client.VerifyPayment(context.Background(), webhookToken); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("VerifyPayment() while draining = %v", err)
+	}
+	if transport.count() != 1 {
+		t.Fatalf("%d gateway requests, want 1", transport.count())
+	}
+}
+
+func TestDrainAndWaitDeadline(t *testing.T) {
+	step := verifySuccess()
+	step.delay = time.Second
+	transport := newStubTransport(step)
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	verified := make(chan error, 1)
+	go func() {
+		_, err := client.VerifyPayment(context.Background(), webhookToken)
+		verified <- err
+	}()
+	for transport.count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.DrainAndWait(ctx); !errors.Is(err, ErrShutdownFailed) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DrainAndWait() = %v", err)
+	}
+
+	// The operation is left to finish on its own
+	if err := <-verified; err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DrainAndWait(context.Background()); err != nil {
+		t.Fatalf("second DrainAndWait() = %v", err)
+	}
+}
+
+func TestInflightNestedSections(t *testing.T) {
+	tracker := newInflightTracker()
+
+	ctx, done, err := tracker.begin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- tracker.drain(context.Background()) }()
+	for !tracker.isDraining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A section inside a running one is part of it and isn't refused
+	_, nestedDone, err := tracker.begin(ctx)
+	if err != nil {
+		t.Fatalf("nested begin while draining = %v", err)
+	}
+	nestedDone()
+	if _, _, err := tracker.begin(context.Background()); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("new begin while draining = %v", err)
+	}
+
+	select {
+	case <-drained:
+		t.Fatal("drain returned with a section running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	done()
+	done()
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,6 +5,7 @@ package vandargo
 import (
 	"context"
 	"net/http"
+	"time"
 )
 
 // StorageInterface defines methods for data persistence operations
@@ -22,6 +23,13 @@ type StorageInterface interface {
 	GetTransactionsByStatus(ctx context.Context, status string) ([]*Transaction, error)
 }
 
+// PatchableStorageInterface is implemented by storages that can update individual
+// transaction fields atomically
+type PatchableStorageInterface interface {
+	// PatchTransaction applies a patch to the transaction with the given token
+	PatchTransaction(ctx context.Context, token string, patch TransactionPatch) error
+}
+
 // LoggerInterface defines methods for logging operations
 type LoggerInterface interface {
 	// Debug logs debug level messages
@@ -84,3 +92,54 @@ type PaymentServiceInterface interface {
 	// RefundPayment initiates a refund for a transaction
 	RefundPayment(ctx context.Context, transactionID string, amount int) (*RefundResponse, error)
 }
+
+// PaymentProvider is a payment gateway behind normalized request and result types
+type PaymentProvider interface {
+	// Name identifies the provider, e.g. in transaction metadata
+	Name() string
+
+	// Init initializes a payment and returns the token and payment page
+	Init(ctx context.Context, req *PaymentInitRequest) (*InitResult, error)
+
+	// Verify verifies a payment; a declined payment returns both a result and an error
+	Verify(ctx context.Context, token string) (*PaymentResult, error)
+
+	// Status returns the current state of a payment
+	Status(ctx context.Context, token string) (*PaymentResult, error)
+
+	// Refund refunds a payment
+	Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error)
+}
+
+// TokenProvider defines methods for obtaining access tokens for the business API
+type TokenProvider interface {
+	// Token returns a valid access token, refreshing it when necessary
+	Token(ctx context.Context) (string, error)
+
+	// Invalidate discards the cached access token so the next call refreshes it
+	Invalidate()
+}
+
+// CacheInterface defines methods for caching gateway responses
+type CacheInterface interface {
+	// Get returns the cached value for a key and whether it was found
+	Get(ctx context.Context, key string) ([]byte, bool)
+
+	// Set stores a value for a key; a ttl of zero or less keeps it until deleted
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+
+	// Delete removes a key from the cache
+	Delete(ctx context.Context, key string)
+}
+
+// MetricsInterface defines methods for recording operational metrics
+type MetricsInterface interface {
+	// IncCounter increments a counter metric
+	IncCounter(name string, labels map[string]string)
+
+	// ObserveDuration records a duration sample
+	ObserveDuration(name string, duration time.Duration, labels map[string]string)
+
+	// SetGauge sets a gauge metric to the given value
+	SetGauge(name string, value float64, labels map[string]string)
+}
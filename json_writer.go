@@ -0,0 +1,102 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// json_writer.go implements pooled JSON response writing
+package vandargo
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	// maxBufferedResponse is the largest body sent with a Content-Length header;
+	// larger bodies are streamed in chunks
+	maxBufferedResponse = 64 * 1024
+
+	// responseChunkSize is the write size used when streaming large bodies
+	responseChunkSize = 32 * 1024
+)
+
+// jsonBuffer is a pooled buffer with a JSON encoder bound to it
+type jsonBuffer struct {
+	buf     bytes.Buffer
+	encoder *json.Encoder
+}
+
+// jsonBufferPool reuses response encoding buffers across requests
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} {
+		jb := &jsonBuffer{}
+		jb.encoder = json.NewEncoder(&jb.buf)
+		return jb
+	},
+}
+
+// writeJSON encodes a payload into a pooled buffer and writes it with the status code.
+// Encoding completes before anything is written, so an encoding error leaves the
+// response untouched for the caller to report.
+func writeJSON(w http.ResponseWriter, statusCode int, payload interface{}) error {
+	jb := jsonBufferPool.Get().(*jsonBuffer)
+	jb.buf.Reset()
+
+	if err := jb.encoder.Encode(payload); err != nil {
+		releaseJSONBuffer(jb)
+		return err
+	}
+
+	// Drop the newline the encoder appends, matching json.Marshal output
+	body := jb.buf.Bytes()
+	body = body[:len(body)-1]
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(body) <= maxBufferedResponse {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(statusCode)
+		_, err := w.Write(body)
+		releaseJSONBuffer(jb)
+		return writeError(err)
+	}
+
+	// Large bodies go out in chunks without a Content-Length
+	w.WriteHeader(statusCode)
+	var err error
+	for start := 0; start < len(body) && err == nil; start += responseChunkSize {
+		end := min(start+responseChunkSize, len(body))
+		_, err = w.Write(body[start:end])
+	}
+	releaseJSONBuffer(jb)
+	return writeError(err)
+}
+
+// releaseJSONBuffer returns a buffer to the pool unless it grew unusually large
+func releaseJSONBuffer(jb *jsonBuffer) {
+	if jb.buf.Cap() > maxBufferedResponse {
+		return
+	}
+	jsonBufferPool.Put(jb)
+}
+
+// responseWriteError reports a failure writing an already started response
+type responseWriteError struct {
+	err error
+}
+
+// Error returns the underlying write error message
+func (e *responseWriteError) Error() string {
+	return "failed to write response: " + e.err.Error()
+}
+
+// Unwrap returns the underlying write error
+func (e *responseWriteError) Unwrap() error {
+	return e.err
+}
+
+// writeError wraps a write failure so callers can tell it from an encoding failure
+func writeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &responseWriteError{err: err}
+}
@@ -0,0 +1,164 @@
+package vandargo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyPayload is a typical verify response
+var verifyPayload = &PaymentVerifyResponse{
+	Status:       1,
+	Amount:       "100000",
+	RealAmount:   99000,
+	TransID:      160000000001,
+	FactorNumber: "1042",
+	Mobile:       "09120000000",
+	Description:  "Order 1042",
+	CardNumber:   "603799******1234",
+	PaymentDate:  "2026-10-16 12:00:00",
+}
+
+// transactionListPayload returns n transactions, like a list or export response
+func transactionListPayload(n int) []*Transaction {
+	created := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	transactions := make([]*Transaction, n)
+	for i := range transactions {
+		transactions[i] = &Transaction{
+			Token:         fmt.Sprintf("sim%017d", i),
+			Amount:        100000,
+			Status:        StatusPaid,
+			Description:   "Order " + strconv.Itoa(i),
+			CardNumber:    "603799******1234",
+			RefNumber:     "212475",
+			TransactionID: 160000000000 + int64(i),
+			Metadata:      map[string]string{"order": strconv.Itoa(i)},
+			CreatedAt:     created,
+			UpdatedAt:     created,
+		}
+	}
+	return transactions
+}
+
+func TestWriteJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  interface{}
+		buffered bool
+	}{
+		{"verify", verifyPayload, true},
+		{"large list", transactionListPayload(500), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := json.Marshal(tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			if err := writeJSON(rec, http.StatusCreated, tt.payload); err != nil {
+				t.Fatal(err)
+			}
+
+			// The body matches json.Marshal, without the encoder's trailing newline
+			if rec.Code != http.StatusCreated || rec.Body.String() != string(want) {
+				t.Fatalf("status %d, body %.200s", rec.Code, rec.Body)
+			}
+			if rec.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("Content-Type %q", rec.Header().Get("Content-Type"))
+			}
+
+			// Small bodies carry their length; large ones are streamed without it
+			length := rec.Header().Get("Content-Length")
+			if tt.buffered && length != strconv.Itoa(len(want)) || !tt.buffered && length != "" {
+				t.Fatalf("Content-Length %q for %d bytes", length, len(want))
+			}
+		})
+	}
+}
+
+func TestWriteJSONEncodingFailure(t *testing.T) {
+	rec := httptest.NewRecorder()
+	err := writeJSON(rec, http.StatusOK, map[string]interface{}{"broken": make(chan int)})
+
+	// Nothing was written, so the caller can still answer with an error
+	var writeErr *responseWriteError
+	if err == nil || errors.As(err, &writeErr) {
+		t.Fatalf("writeJSON() = %v, want an encoding error", err)
+	}
+	if rec.Body.Len() != 0 || len(rec.Header()) != 0 {
+		t.Fatalf("response touched: %v %q", rec.Header(), rec.Body)
+	}
+
+	// The pooled buffer is reused cleanly afterwards
+	rec = httptest.NewRecorder()
+	if err := writeJSON(rec, http.StatusOK, verifyPayload); err != nil || !strings.HasPrefix(rec.Body.String(), `{"status":1,`) {
+		t.Fatalf("after a failure: %v, %q", err, rec.Body)
+	}
+}
+
+func TestRespondWithJSONEncodingFailure(t *testing.T) {
+	client, _, logger := newTestClient(t, testConfig(t), nil)
+
+	rec := httptest.NewRecorder()
+	client.respondWithJSON(rec, http.StatusOK, map[string]interface{}{"broken": func() {}})
+
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "{") {
+		t.Fatalf("status %d, body %q", rec.Code, rec.Body)
+	}
+	if _, ok := logger.find("Failed to marshal JSON response"); !ok {
+		t.Fatalf("encoding failure not logged:\n%s", logger.dump())
+	}
+}
+
+// marshalJSON is the unpooled way of writing a response: marshal, then write
+func marshalJSON(w http.ResponseWriter, statusCode int, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, err = w.Write(body)
+	return err
+}
+
+// discardResponseWriter is a ResponseWriter that drops the body, so benchmarks
+// measure encoding rather than the recorder's buffer
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardResponseWriter) WriteHeader(statusCode int)  {}
+
+func benchmarkJSONWriter(b *testing.B, write func(http.ResponseWriter, int, interface{}) error, payload interface{}) {
+	w := &discardResponseWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := write(w, http.StatusOK, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteJSONVerify(b *testing.B) {
+	b.Run("marshal", func(b *testing.B) { benchmarkJSONWriter(b, marshalJSON, verifyPayload) })
+	b.Run("pooled", func(b *testing.B) { benchmarkJSONWriter(b, writeJSON, verifyPayload) })
+}
+
+func BenchmarkWriteJSONTransactionList(b *testing.B) {
+	payload := transactionListPayload(100)
+	b.Run("marshal", func(b *testing.B) { benchmarkJSONWriter(b, marshalJSON, payload) })
+	b.Run("pooled", func(b *testing.B) { benchmarkJSONWriter(b, writeJSON, payload) })
+}
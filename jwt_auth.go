@@ -0,0 +1,343 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// jwt_auth.go implements JWT bearer authentication of the payment endpoints
+package vandargo
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// subjectKey stores the authenticated subject in the request context
+const subjectKey contextKey = "subject"
+
+// JWT signing algorithms accepted by JWTVerifier
+const (
+	jwtAlgHS256 = "HS256"
+	jwtAlgRS256 = "RS256"
+)
+
+// JWTConfig configures the verification of JWT bearer tokens
+type JWTConfig struct {
+	// HMACSecret verifies HS256 tokens; exactly one of HMACSecret and
+	// RSAPublicKeyPEM must be set
+	HMACSecret []byte
+
+	// RSAPublicKeyPEM verifies RS256 tokens, as a PEM public key or certificate
+	RSAPublicKeyPEM []byte
+
+	// Issuer is the required iss claim (optional)
+	Issuer string
+
+	// Audience must be one of the token's aud claims (optional)
+	Audience string
+
+	// Leeway tolerates clock skew when checking exp and nbf
+	Leeway time.Duration
+
+	// Clock is the source of time for exp and nbf (RealClock when nil)
+	Clock Clock
+}
+
+// JWTClaims are the registered claims of a verified token and its scopes
+type JWTClaims struct {
+	Subject   string       `json:"sub,omitempty"`
+	Issuer    string       `json:"iss,omitempty"`
+	Audience  JWTAudience  `json:"aud,omitempty"`
+	ExpiresAt *NumericDate `json:"exp,omitempty"`
+	NotBefore *NumericDate `json:"nbf,omitempty"`
+	IssuedAt  *NumericDate `json:"iat,omitempty"`
+	ID        string       `json:"jti,omitempty"`
+
+	// Scope is the space-separated list of granted scopes, as in OAuth 2
+	Scope string `json:"scope,omitempty"`
+}
+
+// Scopes returns the scopes granted by the token
+func (c *JWTClaims) Scopes() []Scope {
+	var scopes []Scope
+	for _, scope := range strings.Fields(c.Scope) {
+		scopes = append(scopes, Scope(scope))
+	}
+	return scopes
+}
+
+// JWTAudience is the aud claim, which may be a single string or a list
+type JWTAudience []string
+
+// UnmarshalJSON accepts a string or an array of strings
+func (a *JWTAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = JWTAudience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("aud must be a string or an array of strings")
+	}
+	*a = list
+	return nil
+}
+
+// Contains reports whether the audience includes a value
+func (a JWTAudience) Contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// NumericDate is a JWT timestamp in seconds since the epoch, possibly fractional
+type NumericDate struct {
+	time.Time
+}
+
+// UnmarshalJSON parses seconds since the epoch
+func (d *NumericDate) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return fmt.Errorf("date claims must be numbers")
+	}
+	whole, fraction := math.Modf(seconds)
+	d.Time = time.Unix(int64(whole), int64(fraction*1e9))
+	return nil
+}
+
+// MarshalJSON formats the date as whole seconds since the epoch
+func (d NumericDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Unix())
+}
+
+// JWTVerifier verifies JWT bearer tokens with a single algorithm and key
+type JWTVerifier struct {
+	alg       string
+	secret    []byte
+	publicKey *rsa.PublicKey
+	issuer    string
+	audience  string
+	leeway    time.Duration
+	clock     Clock
+}
+
+// NewJWTVerifier creates a verifier from its configuration
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	verifier := &JWTVerifier{
+		issuer:   config.Issuer,
+		audience: config.Audience,
+		leeway:   config.Leeway,
+		clock:    config.Clock,
+	}
+	if verifier.clock == nil {
+		verifier.clock = RealClock()
+	}
+
+	switch {
+	case len(config.HMACSecret) > 0 && len(config.RSAPublicKeyPEM) > 0:
+		return nil, fmt.Errorf("%w: set either an HMAC secret or an RSA public key, not both", ErrInvalidConfig)
+	case len(config.HMACSecret) > 0:
+		verifier.alg = jwtAlgHS256
+		verifier.secret = config.HMACSecret
+	case len(config.RSAPublicKeyPEM) > 0:
+		publicKey, err := parseRSAPublicKey(config.RSAPublicKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+		verifier.alg = jwtAlgRS256
+		verifier.publicKey = publicKey
+	default:
+		return nil, fmt.Errorf("%w: an HMAC secret or an RSA public key is required", ErrInvalidConfig)
+	}
+
+	return verifier, nil
+}
+
+// parseRSAPublicKey parses a PEM encoded RSA public key or certificate
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("rsa public key is not PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var certificate *x509.Certificate
+		if certificate, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = certificate.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid rsa public key: %w", err)
+	}
+
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an RSA key")
+	}
+	return publicKey, nil
+}
+
+// Verify checks a token's signature and claims and returns the claims. Only the
+// configured algorithm is accepted, so unsigned tokens and tokens signed with
+// another algorithm, e.g. HS256 with the RSA public key, are rejected.
+func (v *JWTVerifier) Verify(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrAuthentication)
+	}
+
+	// Header
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token header", ErrAuthentication)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed token header", ErrAuthentication)
+	}
+	if header.Alg != v.alg {
+		return nil, fmt.Errorf("%w: unexpected token algorithm %q", ErrAuthentication, header.Alg)
+	}
+
+	// Signature
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) == 0 {
+		return nil, fmt.Errorf("%w: malformed token signature", ErrAuthentication)
+	}
+	if !v.validSignature(parts[0]+"."+parts[1], signature) {
+		return nil, fmt.Errorf("%w: invalid token signature", ErrAuthentication)
+	}
+
+	// Claims
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token claims", ErrAuthentication)
+	}
+	var claims JWTClaims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed token claims: %v", ErrAuthentication, err)
+	}
+	if err := v.validateClaims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+// validSignature checks the signature of the signed part of a token
+func (v *JWTVerifier) validSignature(signed string, signature []byte) bool {
+	switch v.alg {
+	case jwtAlgHS256:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signed))
+		return hmac.Equal(mac.Sum(nil), signature)
+	case jwtAlgRS256:
+		digest := sha256.Sum256([]byte(signed))
+		return rsa.VerifyPKCS1v15(v.publicKey, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// validateClaims checks the time window, issuer and audience of a token. Tokens
+// must expire, so a leaked token stays usable only briefly.
+func (v *JWTVerifier) validateClaims(claims *JWTClaims) error {
+	now := v.clock.Now()
+
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: token has no expiry", ErrAuthentication)
+	}
+	if now.After(claims.ExpiresAt.Add(v.leeway)) {
+		return fmt.Errorf("%w: token expired", ErrAuthentication)
+	}
+	if claims.NotBefore != nil && now.Before(claims.NotBefore.Add(-v.leeway)) {
+		return fmt.Errorf("%w: token not valid yet", ErrAuthentication)
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return fmt.Errorf("%w: unexpected token issuer", ErrAuthentication)
+	}
+	if v.audience != "" && !claims.Audience.Contains(v.audience) {
+		return fmt.Errorf("%w: token not issued for this audience", ErrAuthentication)
+	}
+
+	return nil
+}
+
+// JWTAuthMiddleware authenticates requests with a JWT bearer token in place of
+// AuthMiddleware. The token's scope claim must grant every given scope. The
+// token subject is stored in the request context and added to its logger.
+func JWTAuthMiddleware(verifier *JWTVerifier, scopes ...Scope) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Unauthorized")
+				return
+			}
+
+			claims, err := verifier.Verify(token)
+			if err != nil {
+				LoggerFromContext(r.Context()).Info(r.Context(), "Rejected bearer token", map[string]interface{}{
+					"reason": err.Error(),
+				})
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Invalid bearer token")
+				return
+			}
+
+			// Check if the token may use the route
+			granted := ServerKey{Scopes: claims.Scopes()}
+			for _, scope := range scopes {
+				if !granted.HasScope(scope) {
+					writeJSONError(w, r, http.StatusForbidden, &ScopeError{Required: scope}, "Token lacks the "+string(scope)+" scope")
+					return
+				}
+			}
+
+			ctx := r.Context()
+			if claims.Subject != "" {
+				ctx = ContextWithSubject(ctx, claims.Subject)
+				if logger := contextLogger(ctx); logger != nil {
+					ctx = ContextWithLogger(ctx, WithFields(logger, map[string]interface{}{
+						"subject": claims.Subject,
+					}))
+				}
+			}
+
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// ContextWithSubject returns a context carrying the authenticated subject
+func ContextWithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey, subject)
+}
+
+// SubjectFromContext returns the authenticated subject stored by JWTAuthMiddleware
+func SubjectFromContext(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(subjectKey).(string)
+	return subject, ok && subject != ""
+}
@@ -0,0 +1,256 @@
+package vandargo
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	jwtTestSecret   = "jwt-test-secret"
+	jwtTestIssuer   = "https://id.shop.example.com"
+	jwtTestAudience = "vandargo"
+)
+
+// jwtTestNow is the time of the fake clock the test verifiers use
+var jwtTestNow = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+// encodeJWTPart encodes a token header or claims
+func encodeJWTPart(t *testing.T, part map[string]interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// hs256Token signs claims with HMAC-SHA256 under the given alg header
+func hs256Token(t *testing.T, alg string, secret []byte, claims map[string]interface{}) string {
+	t.Helper()
+
+	signed := encodeJWTPart(t, map[string]interface{}{"alg": alg, "typ": "JWT"}) + "." + encodeJWTPart(t, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// rs256Token signs claims with an RSA key
+func rs256Token(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+
+	signed := encodeJWTPart(t, map[string]interface{}{"alg": "RS256", "typ": "JWT"}) + "." + encodeJWTPart(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims returns claims the test verifiers accept, with changes applied
+func validClaims(changes map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub":   "billing-service",
+		"iss":   jwtTestIssuer,
+		"aud":   jwtTestAudience,
+		"exp":   jwtTestNow.Add(5 * time.Minute).Unix(),
+		"iat":   jwtTestNow.Unix(),
+		"scope": "read write",
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+// newTestVerifier creates a verifier with the test issuer, audience and clock
+func newTestVerifier(t *testing.T, config JWTConfig) *JWTVerifier {
+	t.Helper()
+
+	config.Issuer = jwtTestIssuer
+	config.Audience = jwtTestAudience
+	config.Leeway = 30 * time.Second
+	config.Clock = NewFakeClock(jwtTestNow)
+	verifier, err := NewJWTVerifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier
+}
+
+func TestJWTVerifierHS256(t *testing.T) {
+	verifier := newTestVerifier(t, JWTConfig{HMACSecret: []byte(jwtTestSecret)})
+	secret := []byte(jwtTestSecret)
+	unsigned := encodeJWTPart(t, map[string]interface{}{"alg": "none", "typ": "JWT"}) + "." + encodeJWTPart(t, validClaims(nil))
+
+	// The signature of a token moved onto claims granting more
+	valid := strings.Split(hs256Token(t, "HS256", secret, validClaims(nil)), ".")
+	tampered := valid[0] + "." + encodeJWTPart(t, validClaims(map[string]interface{}{"scope": "admin"})) + "." + valid[2]
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", hs256Token(t, "HS256", secret, validClaims(nil)), true},
+		{"audience list", hs256Token(t, "HS256", secret, validClaims(map[string]interface{}{"aud": []string{"reports", jwtTestAudience}})), true},
+		{"expired within leeway", hs256Token(t, "HS256", secret, validClaims(map[string]interface{}{"exp": jwtTestNow.Add(-10 * time.Second).Unix()})), true},
+		{"expired", hs256Token(t, "HS256", secret, validClaims(map[string]interface{}{"exp": jwtTestNow.Add(-time.Minute).Unix()})), false},
+		{"no expiry", hs256Token(t, "HS256", secret, validClaims(map[string]interface{}{"exp": nil})), false},
+		{"not valid yet", hs256Token(t, "HS256", secret, validClaims(map[string]interface{}{"nbf": jwtTestNow.Add(time.Minute).Unix()})), false},
+		{"wrong audience", hs256Token(t, "HS256", secret, validClaims(map[string]interface{}{"aud": "reports"})), false},
+		{"no audience", hs256Token(t, "HS256", secret, validClaims(map[string]interface{}{"aud": nil})), false},
+		{"wrong issuer", hs256Token(t, "HS256", secret, validClaims(map[string]interface{}{"iss": "https://evil.example.com"})), false},
+		{"wrong secret", hs256Token(t, "HS256", []byte("other-secret"), validClaims(nil)), false},
+		{"tampered claims", tampered, false},
+		{"alg none", unsigned + ".", false},
+		{"alg none with signature", unsigned + "." + base64.RawURLEncoding.EncodeToString([]byte("sig")), false},
+		{"alg None", hs256Token(t, "None", secret, validClaims(nil)), false},
+		{"alg HS512", hs256Token(t, "HS512", secret, validClaims(nil)), false},
+		{"alg RS256", hs256Token(t, "RS256", secret, validClaims(nil)), false},
+		{"two parts", "a.b", false},
+		{"garbage", "not-a-token", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(tt.token)
+			if tt.ok {
+				if err != nil || claims.Subject != "billing-service" {
+					t.Fatalf("Verify() = %+v, %v", claims, err)
+				}
+				return
+			}
+			if err == nil || !errors.Is(err, ErrAuthentication) {
+				t.Fatalf("Verify() = %+v, %v; want an authentication error", claims, err)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	verifier := newTestVerifier(t, JWTConfig{RSAPublicKeyPEM: publicPEM})
+
+	if claims, err := verifier.Verify(rs256Token(t, key, validClaims(nil))); err != nil || claims.Subject != "billing-service" {
+		t.Fatalf("Verify() = %+v, %v", claims, err)
+	}
+
+	// A token signed with HS256 using the public key as the secret is rejected
+	if _, err := verifier.Verify(hs256Token(t, "HS256", publicPEM, validClaims(nil))); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("alg confusion: %v", err)
+	}
+
+	// So are tokens of another key and expired ones
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := verifier.Verify(rs256Token(t, other, validClaims(nil))); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("other key: %v", err)
+	}
+	if _, err := verifier.Verify(rs256Token(t, key, validClaims(map[string]interface{}{"exp": jwtTestNow.Add(-time.Hour).Unix()}))); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("expired: %v", err)
+	}
+
+	// PKCS #1 keys are accepted as well
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	if _, err := newTestVerifier(t, JWTConfig{RSAPublicKeyPEM: pkcs1}).Verify(rs256Token(t, key, validClaims(nil))); err != nil {
+		t.Fatalf("PKCS #1 key: %v", err)
+	}
+}
+
+func TestNewJWTVerifierConfig(t *testing.T) {
+	for name, config := range map[string]JWTConfig{
+		"no key":  {},
+		"both":    {HMACSecret: []byte(jwtTestSecret), RSAPublicKeyPEM: []byte("-----BEGIN PUBLIC KEY-----")},
+		"not PEM": {RSAPublicKeyPEM: []byte("not a key")},
+		"bad PEM": {RSAPublicKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("junk")})},
+	} {
+		if _, err := NewJWTVerifier(config); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestJWTAuthMiddleware(t *testing.T) {
+	verifier := newTestVerifier(t, JWTConfig{HMACSecret: []byte(jwtTestSecret)})
+	var subject string
+	handler := JWTAuthMiddleware(verifier, ScopeWrite)(func(w http.ResponseWriter, r *http.Request) {
+		subject, _ = SubjectFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"valid", "Bearer " + hs256Token(t, "HS256", []byte(jwtTestSecret), validClaims(nil)), http.StatusNoContent},
+		{"missing", "", http.StatusUnauthorized},
+		{"basic", "Basic Zm9vOmJhcg==", http.StatusUnauthorized},
+		{"expired", "Bearer " + hs256Token(t, "HS256", []byte(jwtTestSecret), validClaims(map[string]interface{}{"exp": jwtTestNow.Add(-time.Hour).Unix()})), http.StatusUnauthorized},
+		{"missing scope", "Bearer " + hs256Token(t, "HS256", []byte(jwtTestSecret), validClaims(map[string]interface{}{"scope": "read"})), http.StatusForbidden},
+		{"static key", "Bearer " + testAPIKey, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject = ""
+			req := httptest.NewRequest(http.MethodPost, "/payments/init", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusNoContent && subject != "billing-service" {
+				t.Fatalf("subject %q in the request context", subject)
+			}
+		})
+	}
+}
+
+func TestJWTAuthRouteOption(t *testing.T) {
+	verifier := newTestVerifier(t, JWTConfig{HMACSecret: []byte(jwtTestSecret)})
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport())
+	handler := client.Handler(WithJWTAuth(verifier))
+
+	// Routes take JWTs in place of the static key
+	token := hs256Token(t, "HS256", []byte(jwtTestSecret), validClaims(map[string]interface{}{"scope": "read"}))
+	if rec := routeRequest(handler, http.MethodGet, "/payments/status?token="+webhookToken, "Bearer "+token); rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+		t.Fatalf("status with a read token: %d: %s", rec.Code, rec.Body)
+	}
+	if rec := routeRequest(handler, http.MethodGet, "/payments/status?token="+webhookToken, "Bearer "+testAPIKey); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status with the static key: %d", rec.Code)
+	}
+	if rec := routeRequest(handler, http.MethodPost, "/payments/init", "Bearer "+token); rec.Code != http.StatusForbidden {
+		t.Fatalf("init with a read token: %d", rec.Code)
+	}
+}
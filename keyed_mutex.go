@@ -0,0 +1,86 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// keyed_mutex.go implements per-key locking
+package vandargo
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// keyedMutexShards is the number of shards the keys of a keyedMutex are spread
+// over, so unrelated keys don't contend on one map lock
+const keyedMutexShards = 32
+
+// keyedMutex provides a mutex per key; entries are removed once no caller holds
+// or waits for them, so the set of keys does not grow without bound.
+//
+// The client holds two keyed mutexes, always acquired in this order:
+//
+//  1. factorLocks, by factor number, while initializing a payment
+//  2. tokenLocks, by payment token, while reading and changing a transaction
+//     (verification, callbacks, expiry and status overrides)
+//
+// Token locks are not reentrant: code holding one must not call a method that
+// acquires it, e.g. the callback handler releases its lock before auto-verifying.
+// Storage locks are taken inside either and never the other way around.
+type keyedMutex struct {
+	shards [keyedMutexShards]keyedShard
+}
+
+// keyedShard holds the locks of the keys hashing to it
+type keyedShard struct {
+	mutex sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is the mutex of one key and the number of callers using it
+type keyedLock struct {
+	mutex sync.Mutex
+	refs  int
+}
+
+// newKeyedMutex creates an empty keyed mutex
+func newKeyedMutex() *keyedMutex {
+	m := &keyedMutex{}
+	for i := range m.shards {
+		m.shards[i].locks = make(map[string]*keyedLock)
+	}
+	return m
+}
+
+// shard returns the shard of a key
+func (m *keyedMutex) shard(key string) *keyedShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &m.shards[h.Sum32()%keyedMutexShards]
+}
+
+// Lock locks the mutex of a key and returns the function that unlocks it
+func (m *keyedMutex) Lock(key string) func() {
+	shard := m.shard(key)
+
+	shard.mutex.Lock()
+	lock, exists := shard.locks[key]
+	if !exists {
+		lock = &keyedLock{}
+		shard.locks[key] = lock
+	}
+	lock.refs++
+	shard.mutex.Unlock()
+
+	lock.mutex.Lock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			lock.mutex.Unlock()
+
+			shard.mutex.Lock()
+			lock.refs--
+			if lock.refs == 0 {
+				delete(shard.locks, key)
+			}
+			shard.mutex.Unlock()
+		})
+	}
+}
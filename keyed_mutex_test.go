@@ -0,0 +1,139 @@
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lockCount returns the number of keys a keyed mutex keeps
+func (m *keyedMutex) lockCount() int {
+	n := 0
+	for i := range m.shards {
+		m.shards[i].mutex.Lock()
+		n += len(m.shards[i].locks)
+		m.shards[i].mutex.Unlock()
+	}
+	return n
+}
+
+func TestKeyedMutex(t *testing.T) {
+	locks := newKeyedMutex()
+
+	// Holders of one key take turns
+	var holders, maxHolders atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := locks.Lock("token")
+			defer release()
+
+			if n := holders.Add(1); n > maxHolders.Load() {
+				maxHolders.Store(n)
+			}
+			time.Sleep(10 * time.Microsecond)
+			holders.Add(-1)
+		}()
+	}
+	wg.Wait()
+	if maxHolders.Load() != 1 {
+		t.Fatalf("%d callers held one key at once", maxHolders.Load())
+	}
+
+	// Other keys aren't blocked by a held one
+	release := locks.Lock("held")
+	acquired := make(chan struct{})
+	go func() {
+		locks.Lock("other")()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("an unrelated key waited for a held one")
+	}
+
+	// Releasing twice is harmless, and released keys are forgotten
+	release()
+	release()
+	for i := 0; i < 1000; i++ {
+		locks.Lock(fmt.Sprintf("key-%d", i))()
+	}
+	if n := locks.lockCount(); n != 0 {
+		t.Fatalf("%d keys kept after release", n)
+	}
+}
+
+func TestOneTokenStress(t *testing.T) {
+	const goroutines = 300
+
+	// The gateway reports how many verifications of the token overlap
+	var inFlight, maxInFlight, verifies atomic.Int32
+	transport := transportFunc(func(req *http.Request) (*http.Response, error) {
+		if !strings.HasSuffix(req.URL.Path, "/verify") {
+			return stubResponse(req, http.StatusOK, map[string]interface{}{"status": 1}), nil
+		}
+		verifies.Add(1)
+		if n := inFlight.Add(1); n > maxInFlight.Load() {
+			maxInFlight.Store(n)
+		}
+		defer inFlight.Add(-1)
+		time.Sleep(time.Millisecond)
+		return stubResponse(req, http.StatusOK, map[string]interface{}{
+			"status":       1,
+			"amount":       "100000",
+			"transId":      160000000001,
+			"factorNumber": "1042",
+		}), nil
+	})
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) { c.VerifyMemoTTL = -1 }), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+	handler := client.Handler()
+
+	// Verifications, callbacks and the expiry janitor hammer one token
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+
+			switch i % 3 {
+			case 0:
+				if _, err := client.VerifyPayment(context.Background(), webhookToken); err != nil {
+					t.Error(err)
+				}
+			case 1:
+				req := httptest.NewRequest(http.MethodPost, "/payments/callback", strings.NewReader("token="+webhookToken+"&status=OK"))
+				req.Header.Set("Content-Type", formContentType)
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			case 2:
+				if _, err := client.ExpireTransactions(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if maxInFlight.Load() != 1 {
+		t.Fatalf("%d verifications of one token in flight at once", maxInFlight.Load())
+	}
+	transaction, err := storage.GetTransaction(context.Background(), webhookToken)
+	if err != nil || transaction.Status != StatusPaid || transaction.TransactionID != 160000000001 {
+		t.Fatalf("final transaction %+v, %v after %d verifications", transaction, err, verifies.Load())
+	}
+	if n := client.tokenLocks.lockCount(); n != 0 {
+		t.Fatalf("%d token locks kept", n)
+	}
+}
@@ -0,0 +1,199 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// kv.go implements the key-value store shared by idempotency keys, nonces, velocity counters and rate limits
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// KVStore is the small key-value store behind the state that instances of a
+// deployment must share: idempotency keys, signature nonces, velocity counters
+// and rate limits. A single store, e.g. a RedisKVStore, powers all of them
+// when given to a client with WithClientKVStore.
+type KVStore interface {
+	// Get returns the value of a key and whether it exists and hasn't expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// SetNX sets a key that doesn't exist yet and reports whether it did; a ttl
+	// of zero or less keeps it until deleted
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+
+	// Incr adds delta to the integer value of a key, creating it with the ttl,
+	// and returns the new value; the ttl of an existing key is kept
+	Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+
+	// Delete removes a key; missing keys are not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// kvEntry is a value of a MemoryKVStore
+type kvEntry struct {
+	value []byte
+
+	// expiresAt is when the entry expires, zero for never
+	expiresAt time.Time
+}
+
+// expired reports whether the entry has expired at now
+func (e *kvEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// kvPruneInterval is how often a MemoryKVStore drops expired entries
+const kvPruneInterval = time.Minute
+
+// MemoryKVStore is an in-memory KVStore for a single instance
+type MemoryKVStore struct {
+	entries    map[string]*kvEntry
+	mutex      sync.Mutex
+	clock      Clock
+	lastPruned time.Time
+}
+
+// NewMemoryKVStore creates an in-memory KV store expiring keys by clock, the
+// real clock when nil
+func NewMemoryKVStore(clock Clock) *MemoryKVStore {
+	if clock == nil {
+		clock = RealClock()
+	}
+	return &MemoryKVStore{
+		entries: make(map[string]*kvEntry),
+		clock:   clock,
+	}
+}
+
+// Get returns the value of a key and whether it exists and hasn't expired
+func (s *MemoryKVStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.entry(key)
+	if entry == nil {
+		return nil, false, nil
+	}
+	return append([]byte(nil), entry.value...), true, nil
+}
+
+// SetNX sets a key that doesn't exist yet and reports whether it did
+func (s *MemoryKVStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.entry(key) != nil {
+		return false, nil
+	}
+	s.entries[key] = &kvEntry{value: append([]byte(nil), value...), expiresAt: s.expiry(ttl)}
+	return true, nil
+}
+
+// Incr adds delta to the integer value of a key, creating it with the ttl, and
+// returns the new value
+func (s *MemoryKVStore) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.entry(key)
+	if entry == nil {
+		entry = &kvEntry{value: []byte("0"), expiresAt: s.expiry(ttl)}
+		s.entries[key] = entry
+	}
+
+	current, err := strconv.ParseInt(string(entry.value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value of %q is not an integer", key)
+	}
+	current += delta
+	entry.value = []byte(strconv.FormatInt(current, 10))
+
+	return current, nil
+}
+
+// Delete removes a key
+func (s *MemoryKVStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// entry returns the live entry of a key, dropping expired entries now and then.
+// The mutex must be held.
+func (s *MemoryKVStore) entry(key string) *kvEntry {
+	now := s.clock.Now()
+	if now.Sub(s.lastPruned) >= kvPruneInterval {
+		for k, entry := range s.entries {
+			if entry.expired(now) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastPruned = now
+	}
+
+	entry, exists := s.entries[key]
+	if !exists {
+		return nil
+	}
+	if entry.expired(now) {
+		delete(s.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// expiry returns when an entry set now with a ttl expires, zero for never
+func (s *MemoryKVStore) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return s.clock.Now().Add(ttl)
+}
+
+// kvVelocityStore keeps velocity counters in a KVStore
+type kvVelocityStore struct {
+	kv KVStore
+}
+
+// NewKVVelocityStore returns a VelocityStore keeping its counters in kv
+func NewKVVelocityStore(kv KVStore) VelocityStore {
+	return &kvVelocityStore{kv: kv}
+}
+
+// IncrBy adds delta to a counter, creating it with the TTL, and returns its new value
+func (s *kvVelocityStore) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return s.kv.Incr(ctx, key, delta, ttl)
+}
+
+// Get returns a counter, or 0 when it doesn't exist or expired
+func (s *kvVelocityStore) Get(ctx context.Context, key string) (int64, error) {
+	value, found, err := s.kv.Get(ctx, key)
+	if err != nil || !found {
+		return 0, err
+	}
+
+	counter, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("velocity counter %q is not an integer", key)
+	}
+	return counter, nil
+}
@@ -0,0 +1,415 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// kv_redis.go implements a KVStore on Redis speaking RESP over a small connection pool
+package vandargo
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultRedisTimeout bounds dialing and each command when the context has no deadline
+	defaultRedisTimeout = 3 * time.Second
+
+	// defaultRedisPoolSize is how many idle connections a RedisKVStore keeps
+	defaultRedisPoolSize = 8
+)
+
+// redisIncrScript adds to a counter and sets the TTL of counters that have none,
+// so a counter never outlives its window even if it expired between commands
+const redisIncrScript = `local value = redis.call('INCRBY', KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call('PTTL', KEYS[1]) == -1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return value`
+
+// RedisError is an error reply from Redis
+type RedisError struct {
+	// Message is the error message, e.g. "WRONGTYPE Operation against a key..."
+	Message string
+}
+
+// Error implements the error interface
+func (e *RedisError) Error() string {
+	return "redis: " + e.Message
+}
+
+// RedisOption configures a RedisKVStore
+type RedisOption func(*redisOptions)
+
+// redisOptions are the settings of a RedisKVStore
+type redisOptions struct {
+	username  string
+	password  string
+	db        int
+	keyPrefix string
+	timeout   time.Duration
+	poolSize  int
+	tlsConfig *tls.Config
+}
+
+// WithRedisAuth authenticates connections; username may be empty for the default user
+func WithRedisAuth(username, password string) RedisOption {
+	return func(o *redisOptions) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithRedisDB selects a database other than 0
+func WithRedisDB(db int) RedisOption {
+	return func(o *redisOptions) {
+		o.db = db
+	}
+}
+
+// WithRedisKeyPrefix prefixes every key, e.g. to share a database between deployments
+func WithRedisKeyPrefix(prefix string) RedisOption {
+	return func(o *redisOptions) {
+		o.keyPrefix = prefix
+	}
+}
+
+// WithRedisTimeout bounds dialing and each command when the context has no
+// earlier deadline (3 seconds by default)
+func WithRedisTimeout(timeout time.Duration) RedisOption {
+	return func(o *redisOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithRedisPoolSize sets how many idle connections are kept (8 by default)
+func WithRedisPoolSize(size int) RedisOption {
+	return func(o *redisOptions) {
+		o.poolSize = size
+	}
+}
+
+// WithRedisTLS connects over TLS
+func WithRedisTLS(config *tls.Config) RedisOption {
+	return func(o *redisOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// RedisKVStore is a KVStore on Redis, so instances of a deployment share
+// idempotency keys, nonces, velocity counters and rate limits. It speaks the
+// Redis protocol itself and needs no client library. Connections are opened on
+// demand and up to the pool size are kept idle; it is safe for concurrent use.
+type RedisKVStore struct {
+	addr    string
+	options redisOptions
+	idle    chan *redisConn
+}
+
+// NewRedisKVStore creates a store on the Redis server at addr, e.g.
+// "localhost:6379". No connection is opened until the first command; use Ping
+// to check the server at startup.
+func NewRedisKVStore(addr string, opts ...RedisOption) (*RedisKVStore, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("%w: redis address is required", ErrInvalidConfig)
+	}
+
+	options := redisOptions{
+		timeout:  defaultRedisTimeout,
+		poolSize: defaultRedisPoolSize,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.timeout <= 0 {
+		options.timeout = defaultRedisTimeout
+	}
+	if options.poolSize <= 0 {
+		options.poolSize = defaultRedisPoolSize
+	}
+
+	return &RedisKVStore{
+		addr:    addr,
+		options: options,
+		idle:    make(chan *redisConn, options.poolSize),
+	}, nil
+}
+
+// Get returns the value of a key and whether it exists
+func (s *RedisKVStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := s.do(ctx, "GET", s.key(key))
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %T", reply)
+	}
+	return value, true, nil
+}
+
+// SetNX sets a key that doesn't exist yet and reports whether it did
+func (s *RedisKVStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	args := []string{"SET", s.key(key), string(value), "NX"}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+
+	reply, err := s.do(ctx, args...)
+	if err != nil {
+		return false, err
+	}
+	// A key that exists answers with a null reply
+	return reply != nil, nil
+}
+
+// Incr adds delta to the integer value of a key, creating it with the ttl, and
+// returns the new value
+func (s *RedisKVStore) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	var ttlMillis int64
+	if ttl > 0 {
+		ttlMillis = max(ttl.Milliseconds(), 1)
+	}
+
+	reply, err := s.do(ctx, "EVAL", redisIncrScript, "1", s.key(key),
+		strconv.FormatInt(delta, 10), strconv.FormatInt(ttlMillis, 10))
+	if err != nil {
+		return 0, err
+	}
+
+	value, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected INCRBY reply %T", reply)
+	}
+	return value, nil
+}
+
+// Delete removes a key
+func (s *RedisKVStore) Delete(ctx context.Context, key string) error {
+	_, err := s.do(ctx, "DEL", s.key(key))
+	return err
+}
+
+// Ping checks that the server answers
+func (s *RedisKVStore) Ping(ctx context.Context) error {
+	_, err := s.do(ctx, "PING")
+	return err
+}
+
+// Close closes the idle connections; connections in use are closed when returned
+func (s *RedisKVStore) Close() error {
+	for {
+		select {
+		case conn := <-s.idle:
+			conn.close()
+		default:
+			return nil
+		}
+	}
+}
+
+// key applies the key prefix
+func (s *RedisKVStore) key(key string) string {
+	return s.options.keyPrefix + key
+}
+
+// do runs a command on a pooled connection and returns its reply. Error replies
+// are returned as *RedisError and leave the connection usable.
+func (s *RedisKVStore) do(ctx context.Context, args ...string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	conn, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.command(ctx, s.options.timeout, args...)
+	var redisErr *RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		conn.close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("redis %s failed: %w", args[0], err)
+	}
+
+	s.put(conn)
+	return reply, err
+}
+
+// get returns an idle connection or dials a new one
+func (s *RedisKVStore) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-s.idle:
+		return conn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: s.options.timeout}
+	var netConn net.Conn
+	var err error
+	if s.options.tlsConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: s.options.tlsConfig}
+		netConn, err = tlsDialer.DialContext(ctx, "tcp", s.addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+	if err := s.setup(ctx, conn); err != nil {
+		conn.close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// setup authenticates a new connection and selects the database
+func (s *RedisKVStore) setup(ctx context.Context, conn *redisConn) error {
+	if s.options.password != "" {
+		args := []string{"AUTH", s.options.password}
+		if s.options.username != "" {
+			args = []string{"AUTH", s.options.username, s.options.password}
+		}
+		if _, err := conn.command(ctx, s.options.timeout, args...); err != nil {
+			return fmt.Errorf("failed to authenticate to redis: %w", err)
+		}
+	}
+
+	if s.options.db != 0 {
+		if _, err := conn.command(ctx, s.options.timeout, "SELECT", strconv.Itoa(s.options.db)); err != nil {
+			return fmt.Errorf("failed to select redis database %d: %w", s.options.db, err)
+		}
+	}
+	return nil
+}
+
+// put returns a connection to the pool, closing it when the pool is full
+func (s *RedisKVStore) put(conn *redisConn) {
+	select {
+	case s.idle <- conn:
+	default:
+		conn.close()
+	}
+}
+
+// redisConn is a connection to Redis
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// close closes the connection
+func (c *redisConn) close() {
+	c.conn.Close()
+}
+
+// command sends a command and reads its reply, giving up at the context's
+// deadline or after timeout, whichever comes first, or once the context is canceled
+func (c *redisConn) command(ctx context.Context, timeout time.Duration, args ...string) (interface{}, error) {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// Canceling the context interrupts blocked reads and writes
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if _, err := c.conn.Write(encodeRedisCommand(args)); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.reader)
+}
+
+// encodeRedisCommand encodes a command as an array of bulk strings
+func encodeRedisCommand(args []string) []byte {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// readRedisReply reads a reply: simple strings as string, integers as int64,
+// bulk strings as []byte, arrays as []interface{} and null replies as nil.
+// Error replies return a *RedisError.
+func readRedisReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed redis reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, &RedisError{Message: payload}
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis bulk length %q", payload)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis array length %q", payload)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			// Error items don't end the array
+			item, err := readRedisReply(reader)
+			var redisErr *RedisError
+			if err != nil && !errors.As(err, &redisErr) {
+				return nil, err
+			}
+			if redisErr != nil {
+				item = redisErr
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type %q", kind)
+	}
+}
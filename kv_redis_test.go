@@ -0,0 +1,126 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newMiniredisStore starts an in-process Redis server and returns a store on it
+func newMiniredisStore(t *testing.T, opts ...RedisOption) (*RedisKVStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	store, err := NewRedisKVStore(server.Addr(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, server
+}
+
+func TestRedisKVStoreConformance(t *testing.T) {
+	testKVStoreConformance(t, func(t *testing.T) kvTestStore {
+		store, server := newMiniredisStore(t)
+		return kvTestStore{store: store, advance: server.FastForward}
+	})
+}
+
+// TestRedisKVStoreConformanceLive runs the conformance suite against the Redis
+// server at VANDARGO_TEST_REDIS_ADDR, flushing the database it selects
+func TestRedisKVStoreConformanceLive(t *testing.T) {
+	addr := os.Getenv("VANDARGO_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("VANDARGO_TEST_REDIS_ADDR is not set")
+	}
+
+	run := 0
+	testKVStoreConformance(t, func(t *testing.T) kvTestStore {
+		run++
+		store, err := NewRedisKVStore(addr, WithRedisKeyPrefix("vandargo-test-"+strconv.FormatInt(time.Now().UnixNano(), 36)+"-"+strconv.Itoa(run)+":"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return kvTestStore{store: store, advance: time.Sleep}
+	})
+}
+
+func TestRedisKVStoreKeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+
+	shop, _ := NewRedisKVStore(server.Addr(), WithRedisKeyPrefix("shop:"))
+	other, _ := NewRedisKVStore(server.Addr(), WithRedisKeyPrefix("other:"))
+
+	if ok, err := shop.SetNX(ctx, "nonce", []byte("1"), 0); err != nil || !ok {
+		t.Fatalf("SetNX: %v, %v", ok, err)
+	}
+	if _, found, _ := other.Get(ctx, "nonce"); found {
+		t.Fatal("a store saw a key of another prefix")
+	}
+	if !server.Exists("shop:nonce") {
+		t.Fatalf("keys stored: %v", server.Keys())
+	}
+}
+
+func TestRedisKVStoreAuthAndDB(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	server.RequireUserAuth("vandar", "secret")
+
+	store, _ := NewRedisKVStore(server.Addr(), WithRedisAuth("vandar", "secret"), WithRedisDB(3))
+	if err := store.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Incr(ctx, "counter", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !server.DB(3).Exists("counter") || server.DB(0).Exists("counter") {
+		t.Fatal("counter not stored in the selected database")
+	}
+
+	wrong, _ := NewRedisKVStore(server.Addr(), WithRedisAuth("vandar", "wrong"))
+	if err := wrong.Ping(ctx); err == nil {
+		t.Fatal("ping with a wrong password succeeded")
+	}
+}
+
+func TestRedisKVStoreReconnects(t *testing.T) {
+	ctx := context.Background()
+	store, server := newMiniredisStore(t, WithRedisTimeout(time.Second))
+
+	if err := store.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pooled connections broken by a restart are dropped, not reused forever
+	addr := server.Addr()
+	server.Close()
+	if _, _, err := store.Get(ctx, "key"); err == nil {
+		t.Fatal("Get succeeded with the server down")
+	}
+	if err := server.StartAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Get(ctx, "key"); err != nil {
+		t.Fatalf("Get after the restart: %v", err)
+	}
+}
+
+func TestRedisKVStoreErrorReply(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newMiniredisStore(t)
+
+	store.SetNX(ctx, "text", []byte("abc"), 0)
+	_, err := store.Incr(ctx, "text", 1, 0)
+	var redisErr *RedisError
+	if !errors.As(err, &redisErr) {
+		t.Fatalf("got %v, want a RedisError", err)
+	}
+}
@@ -0,0 +1,238 @@
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// kvTestStore is a store under test and a function moving its time forward
+type kvTestStore struct {
+	store   KVStore
+	advance func(time.Duration)
+}
+
+// testKVStoreConformance checks that a KVStore implements the contract of the
+// interface; every implementation must pass it
+func testKVStoreConformance(t *testing.T, newStore func(t *testing.T) kvTestStore) {
+	ctx := context.Background()
+
+	t.Run("Get", func(t *testing.T) {
+		s := newStore(t).store
+
+		if value, found, err := s.Get(ctx, "missing"); err != nil || found || value != nil {
+			t.Fatalf("missing key: %q, %v, %v", value, found, err)
+		}
+
+		// Values are binary safe
+		value := []byte("a\r\nb\x00c$3\r\n")
+		if ok, err := s.SetNX(ctx, "binary", value, 0); err != nil || !ok {
+			t.Fatalf("SetNX: %v, %v", ok, err)
+		}
+		got, found, err := s.Get(ctx, "binary")
+		if err != nil || !found || !bytes.Equal(got, value) {
+			t.Fatalf("binary value: %q, %v, %v", got, found, err)
+		}
+
+		// Changing a returned value doesn't change the stored one
+		got[0] = 'x'
+		if again, _, _ := s.Get(ctx, "binary"); !bytes.Equal(again, value) {
+			t.Fatalf("stored value changed to %q", again)
+		}
+	})
+
+	t.Run("SetNX", func(t *testing.T) {
+		s := newStore(t).store
+
+		if ok, err := s.SetNX(ctx, "key", []byte("first"), 0); err != nil || !ok {
+			t.Fatalf("first SetNX: %v, %v", ok, err)
+		}
+		if ok, err := s.SetNX(ctx, "key", []byte("second"), 0); err != nil || ok {
+			t.Fatalf("second SetNX: %v, %v", ok, err)
+		}
+		if value, _, _ := s.Get(ctx, "key"); string(value) != "first" {
+			t.Fatalf("value %q, want the first one", value)
+		}
+
+		// Empty values still count as set
+		if ok, _ := s.SetNX(ctx, "empty", nil, 0); !ok {
+			t.Fatal("SetNX of an empty value failed")
+		}
+		if value, found, err := s.Get(ctx, "empty"); err != nil || !found || len(value) != 0 {
+			t.Fatalf("empty value: %q, %v, %v", value, found, err)
+		}
+	})
+
+	t.Run("SetNXExpiry", func(t *testing.T) {
+		ts := newStore(t)
+		s := ts.store
+
+		if ok, err := s.SetNX(ctx, "nonce", []byte("1"), 2*time.Second); err != nil || !ok {
+			t.Fatalf("SetNX: %v, %v", ok, err)
+		}
+		ts.advance(time.Second)
+		if _, found, _ := s.Get(ctx, "nonce"); !found {
+			t.Fatal("key expired early")
+		}
+		if ok, _ := s.SetNX(ctx, "nonce", []byte("2"), 2*time.Second); ok {
+			t.Fatal("SetNX replaced a live key")
+		}
+
+		ts.advance(1500 * time.Millisecond)
+		if _, found, _ := s.Get(ctx, "nonce"); found {
+			t.Fatal("key outlived its ttl")
+		}
+		if ok, _ := s.SetNX(ctx, "nonce", []byte("3"), 0); !ok {
+			t.Fatal("SetNX failed on an expired key")
+		}
+	})
+
+	t.Run("Incr", func(t *testing.T) {
+		s := newStore(t).store
+
+		steps := []struct{ delta, want int64 }{{5, 5}, {1, 6}, {-10, -4}, {0, -4}}
+		for _, step := range steps {
+			if got, err := s.Incr(ctx, "counter", step.delta, 0); err != nil || got != step.want {
+				t.Fatalf("Incr(%d) = %d, %v; want %d", step.delta, got, err, step.want)
+			}
+		}
+		if value, found, _ := s.Get(ctx, "counter"); !found || string(value) != "-4" {
+			t.Fatalf("counter value %q, %v", value, found)
+		}
+
+		// Counters can start from a value set with SetNX
+		s.SetNX(ctx, "preset", []byte("40"), 0)
+		if got, err := s.Incr(ctx, "preset", 2, 0); err != nil || got != 42 {
+			t.Fatalf("Incr on a preset value: %d, %v", got, err)
+		}
+
+		// Values that aren't integers can't be incremented, and the store stays usable
+		s.SetNX(ctx, "text", []byte("abc"), 0)
+		if _, err := s.Incr(ctx, "text", 1, 0); err == nil {
+			t.Fatal("Incr on a non-integer value succeeded")
+		}
+		if value, _, err := s.Get(ctx, "text"); err != nil || string(value) != "abc" {
+			t.Fatalf("after the failed Incr: %q, %v", value, err)
+		}
+	})
+
+	t.Run("IncrExpiry", func(t *testing.T) {
+		ts := newStore(t)
+		s := ts.store
+
+		// The ttl is set when the counter is created and kept by later increments
+		s.Incr(ctx, "window", 1, 2*time.Second)
+		ts.advance(1500 * time.Millisecond)
+		if got, _ := s.Incr(ctx, "window", 1, 2*time.Second); got != 2 {
+			t.Fatalf("second increment = %d", got)
+		}
+		ts.advance(time.Second)
+		if _, found, _ := s.Get(ctx, "window"); found {
+			t.Fatal("increment extended the counter's ttl")
+		}
+
+		// An expired counter starts over
+		if got, _ := s.Incr(ctx, "window", 1, 2*time.Second); got != 1 {
+			t.Fatalf("increment after expiry = %d, want 1", got)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStore(t).store
+
+		s.SetNX(ctx, "key", []byte("value"), 0)
+		if err := s.Delete(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+		if _, found, _ := s.Get(ctx, "key"); found {
+			t.Fatal("deleted key still found")
+		}
+		if err := s.Delete(ctx, "key"); err != nil {
+			t.Fatalf("deleting a missing key: %v", err)
+		}
+		if ok, _ := s.SetNX(ctx, "key", []byte("again"), 0); !ok {
+			t.Fatal("SetNX failed after Delete")
+		}
+	})
+
+	t.Run("CanceledContext", func(t *testing.T) {
+		s := newStore(t).store
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, _, err := s.Get(canceled, "key"); err == nil {
+			t.Error("Get succeeded with a canceled context")
+		}
+		if _, err := s.SetNX(canceled, "key", []byte("v"), 0); err == nil {
+			t.Error("SetNX succeeded with a canceled context")
+		}
+		if _, err := s.Incr(canceled, "key", 1, 0); err == nil {
+			t.Error("Incr succeeded with a canceled context")
+		}
+		if err := s.Delete(canceled, "key"); err == nil {
+			t.Error("Delete succeeded with a canceled context")
+		}
+		if _, found, _ := s.Get(ctx, "key"); found {
+			t.Error("a canceled SetNX stored its key")
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		s := newStore(t).store
+
+		var wg sync.WaitGroup
+		var winners atomic.Int32
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if ok, err := s.SetNX(ctx, "lock", []byte("held"), time.Minute); err != nil {
+					t.Error(err)
+				} else if ok {
+					winners.Add(1)
+				}
+				if _, err := s.Incr(ctx, "hits", 1, time.Minute); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if winners.Load() != 1 {
+			t.Errorf("%d SetNX calls won, want 1", winners.Load())
+		}
+		if value, _, _ := s.Get(ctx, "hits"); string(value) != "50" {
+			t.Errorf("hits = %q, want 50", value)
+		}
+	})
+}
+
+func TestMemoryKVStoreConformance(t *testing.T) {
+	testKVStoreConformance(t, func(t *testing.T) kvTestStore {
+		clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		return kvTestStore{store: NewMemoryKVStore(clock), advance: clock.Advance}
+	})
+}
+
+func TestMemoryKVStorePrunesExpiredKeys(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewMemoryKVStore(clock)
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c"} {
+		s.SetNX(ctx, key, []byte("1"), time.Second)
+	}
+	s.SetNX(ctx, "kept", []byte("1"), 0)
+
+	clock.Advance(kvPruneInterval)
+	s.Get(ctx, "other")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.entries) != 1 || s.entries["kept"] == nil {
+		t.Fatalf("entries after pruning: %v", s.entries)
+	}
+}
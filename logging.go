@@ -3,17 +3,51 @@
 package vandargo
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // defaultLogger is a simple implementation of LoggerInterface
 type defaultLogger struct {
 	// logLevel defines the minimum level of logs to output
 	logLevel string
+
+	// out receives debug and info entries, errOut receives warnings and errors
+	out    io.Writer
+	errOut io.Writer
+
+	// writeMutex serializes writes so entries never interleave
+	writeMutex sync.Mutex
+}
+
+// maxPooledLogBuffer is the largest buffer kept for reuse
+const maxPooledLogBuffer = 64 * 1024
+
+// logBuffer is a pooled buffer with a JSON encoder bound to it and room for
+// the keys of an entry
+type logBuffer struct {
+	buf     bytes.Buffer
+	encoder *json.Encoder
+	keys    []string
+}
+
+// logBufferPool reuses encoding buffers across log entries
+var logBufferPool = sync.Pool{
+	New: func() interface{} {
+		lb := &logBuffer{}
+		lb.encoder = json.NewEncoder(&lb.buf)
+		return lb
+	},
 }
 
 // LogLevel represents log severity levels
@@ -37,8 +71,16 @@ func (l LogLevel) String() string {
 
 // NewDefaultLogger creates a new default logger with the specified log level
 func NewDefaultLogger(level string) LoggerInterface {
+	return NewDefaultLoggerWithOutput(level, os.Stdout, os.Stderr)
+}
+
+// NewDefaultLoggerWithOutput creates a default logger writing debug and info entries
+// to out and warnings and errors to errOut
+func NewDefaultLoggerWithOutput(level string, out, errOut io.Writer) LoggerInterface {
 	return &defaultLogger{
 		logLevel: level,
+		out:      out,
+		errOut:   errOut,
 	}
 }
 
@@ -58,48 +100,198 @@ func (l *defaultLogger) shouldLog(level LogLevel) bool {
 	}
 }
 
-// formatLog formats a log entry as JSON
-func (l *defaultLogger) formatLog(ctx context.Context, level LogLevel, message string, err error, fields map[string]interface{}) string {
-	// Create log entry
-	entry := map[string]interface{}{
-		"timestamp": time.Now().Format(time.RFC3339),
-		"level":     level.String(),
-		"message":   message,
-	}
+// logEntryField is a string field every entry may carry
+type logEntryField struct {
+	key   string
+	value string
+}
+
+// writeLog encodes a log entry as a JSON line and writes it to w. The entry is
+// encoded field by field in sorted key order, which produces the same bytes as
+// marshaling it as a map without building one.
+func (l *defaultLogger) writeLog(ctx context.Context, w io.Writer, level LogLevel, message string, err error, fields map[string]interface{}) {
+	var fixed [6]logEntryField
+	fixed[0] = logEntryField{"timestamp", time.Now().Format(time.RFC3339)}
+	fixed[1] = logEntryField{"level", level.String()}
+	fixed[2] = logEntryField{"message", message}
+	n := 3
 
-	// Add request ID if available
+	// Add request and correlation IDs if available
 	if ctx != nil {
 		if requestID, ok := ctx.Value("request_id").(string); ok {
-			entry["request_id"] = requestID
+			fixed[n] = logEntryField{"request_id", requestID}
+			n++
+		}
+		if correlationID, ok := CorrelationIDFromContext(ctx); ok {
+			fixed[n] = logEntryField{"correlation_id", correlationID}
+			n++
 		}
 	}
 
 	// Add error if available
 	if err != nil {
-		entry["error"] = err.Error()
+		fixed[n] = logEntryField{"error", err.Error()}
+		n++
 	}
 
-	// Add fields if available
+	// Sanitize sensitive data; fields override the entry fields of the same key
+	var sanitized map[string]interface{}
 	if fields != nil {
-		// Sanitize sensitive data
-		sanitizedFields := l.sanitizeSensitiveData(fields)
-		for k, v := range sanitizedFields {
-			entry[k] = v
+		sanitized = l.sanitizeSensitiveData(fields)
+	}
+
+	lb := logBufferPool.Get().(*logBuffer)
+	defer releaseLogBuffer(lb)
+	lb.buf.Reset()
+
+	lb.keys = lb.keys[:0]
+	for _, field := range fixed[:n] {
+		if _, overridden := sanitized[field.key]; !overridden {
+			lb.keys = append(lb.keys, field.key)
 		}
 	}
+	for key := range sanitized {
+		lb.keys = append(lb.keys, key)
+	}
+	slices.Sort(lb.keys)
 
-	// Marshal to JSON
-	jsonEntry, err := json.Marshal(entry)
-	if err != nil {
-		return fmt.Sprintf("Failed to marshal log entry: %v", err)
+	if encodeErr := lb.encodeEntry(fixed[:n], sanitized); encodeErr != nil {
+		lb.buf.Reset()
+		fmt.Fprintf(&lb.buf, "Failed to marshal log entry: %v\n", encodeErr)
+	}
+
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+
+	_, _ = w.Write(lb.buf.Bytes())
+}
+
+// encodeEntry writes the entry of the collected keys as a JSON object line
+func (lb *logBuffer) encodeEntry(fixed []logEntryField, fields map[string]interface{}) error {
+	lb.buf.WriteByte('{')
+	for i, key := range lb.keys {
+		if i > 0 {
+			lb.buf.WriteByte(',')
+		}
+		if err := lb.encodeValue(key); err != nil {
+			return err
+		}
+		lb.buf.WriteByte(':')
+
+		var err error
+		if value, ok := fields[key]; ok {
+			err = lb.encodeValue(value)
+		} else {
+			for _, field := range fixed {
+				if field.key == key {
+					err = lb.encodeValue(field.value)
+					break
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
 	}
+	lb.buf.WriteString("}\n")
+	return nil
+}
+
+// encodeValue writes a JSON value. Strings, integers and booleans, which make up
+// most fields, are written directly; other values go through the encoder.
+func (lb *logBuffer) encodeValue(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		lb.buf.WriteString("null")
+	case string:
+		lb.writeString(v)
+	case bool:
+		lb.buf.Write(strconv.AppendBool(lb.buf.AvailableBuffer(), v))
+	case int:
+		lb.buf.Write(strconv.AppendInt(lb.buf.AvailableBuffer(), int64(v), 10))
+	case int64:
+		lb.buf.Write(strconv.AppendInt(lb.buf.AvailableBuffer(), v, 10))
+	default:
+		if err := lb.encoder.Encode(value); err != nil {
+			return err
+		}
+		// Drop the newline the encoder appends
+		lb.buf.Truncate(lb.buf.Len() - 1)
+	}
+	return nil
+}
+
+// writeString writes a JSON string escaped the way encoding/json escapes it,
+// including HTML characters
+func (lb *logBuffer) writeString(s string) {
+	const hex = "0123456789abcdef"
 
-	return string(jsonEntry)
+	lb.buf.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			lb.buf.WriteString(s[start:i])
+			switch b {
+			case '"', '\\':
+				lb.buf.WriteByte('\\')
+				lb.buf.WriteByte(b)
+			case '\b':
+				lb.buf.WriteString(`\b`)
+			case '\f':
+				lb.buf.WriteString(`\f`)
+			case '\n':
+				lb.buf.WriteString(`\n`)
+			case '\r':
+				lb.buf.WriteString(`\r`)
+			case '\t':
+				lb.buf.WriteString(`\t`)
+			default:
+				lb.buf.WriteString(`\u00`)
+				lb.buf.WriteByte(hex[b>>4])
+				lb.buf.WriteByte(hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			lb.buf.WriteString(s[start:i])
+			lb.buf.WriteRune(utf8.RuneError)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			lb.buf.WriteString(s[start:i])
+			lb.buf.WriteString(`\u202`)
+			lb.buf.WriteByte(hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	lb.buf.WriteString(s[start:])
+	lb.buf.WriteByte('"')
+}
+
+// releaseLogBuffer returns a buffer to the pool unless it grew unusually large
+func releaseLogBuffer(lb *logBuffer) {
+	if lb.buf.Cap() > maxPooledLogBuffer {
+		return
+	}
+	logBufferPool.Put(lb)
 }
 
 // sanitizeSensitiveData masks sensitive information in log fields
 func (l *defaultLogger) sanitizeSensitiveData(fields map[string]interface{}) map[string]interface{} {
-	sanitized := make(map[string]interface{})
+	sanitized := make(map[string]interface{}, len(fields))
 
 	sensitiveKeys := []string{
 		"card_number", "cardNumber", "card",
@@ -122,7 +314,13 @@ func (l *defaultLogger) sanitizeSensitiveData(fields map[string]interface{}) map
 		if isSensitive {
 			switch value := v.(type) {
 			case string:
-				if len(value) > 4 {
+				if k == "token" {
+					// Tokens are not card numbers, keep a recognizable hint
+					sanitized[k] = redactToken(value)
+				} else if strings.Contains(value, "*") {
+					// Already masked by the caller in its configured style
+					sanitized[k] = value
+				} else if len(value) > 4 {
 					sanitized[k] = MaskCardNumber(value)
 				} else {
 					sanitized[k] = "****"
@@ -130,6 +328,9 @@ func (l *defaultLogger) sanitizeSensitiveData(fields map[string]interface{}) map
 			default:
 				sanitized[k] = "****"
 			}
+		} else if redacted, ok := redactLogValue(v); ok {
+			// Transactions carry card data in their fields
+			sanitized[k] = redacted
 		} else {
 			// For non-sensitive fields, check if it's a nested map
 			if nestedMap, ok := v.(map[string]interface{}); ok {
@@ -143,13 +344,13 @@ func (l *defaultLogger) sanitizeSensitiveData(fields map[string]interface{}) map
 	return sanitized
 }
 
-// Debug logs debug level messages
+// Debug logs debug level messages, and those of traced contexts at any level
 func (l *defaultLogger) Debug(ctx context.Context, message string, fields map[string]interface{}) {
-	if !l.shouldLog(Debug) {
+	if !l.shouldLog(Debug) && !IsTraced(ctx) {
 		return
 	}
 
-	fmt.Fprintln(os.Stdout, l.formatLog(ctx, Debug, message, nil, fields))
+	l.writeLog(ctx, l.out, Debug, message, nil, fields)
 }
 
 // Info logs informational messages
@@ -158,7 +359,7 @@ func (l *defaultLogger) Info(ctx context.Context, message string, fields map[str
 		return
 	}
 
-	fmt.Fprintln(os.Stdout, l.formatLog(ctx, Info, message, nil, fields))
+	l.writeLog(ctx, l.out, Info, message, nil, fields)
 }
 
 // Warn logs warning messages
@@ -167,7 +368,7 @@ func (l *defaultLogger) Warn(ctx context.Context, message string, fields map[str
 		return
 	}
 
-	fmt.Fprintln(os.Stderr, l.formatLog(ctx, Warn, message, nil, fields))
+	l.writeLog(ctx, l.errOut, Warn, message, nil, fields)
 }
 
 // Error logs error messages
@@ -176,5 +377,5 @@ func (l *defaultLogger) Error(ctx context.Context, message string, err error, fi
 		return
 	}
 
-	fmt.Fprintln(os.Stderr, l.formatLog(ctx, Error, message, err, fields))
+	l.writeLog(ctx, l.errOut, Error, message, err, fields)
 }
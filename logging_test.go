@@ -0,0 +1,181 @@
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// referenceFormatLog is the defaultLogger encoding before pooled buffers: a
+// fresh map marshaled with json.Marshal and printed with Fprintln
+func referenceFormatLog(l *defaultLogger, ctx context.Context, level LogLevel, message string, err error, fields map[string]interface{}) string {
+	entry := map[string]interface{}{
+		"timestamp": time.Now().Format(time.RFC3339),
+		"level":     level.String(),
+		"message":   message,
+	}
+
+	if ctx != nil {
+		if requestID, ok := ctx.Value("request_id").(string); ok {
+			entry["request_id"] = requestID
+		}
+		if correlationID, ok := CorrelationIDFromContext(ctx); ok {
+			entry["correlation_id"] = correlationID
+		}
+	}
+
+	if err != nil {
+		entry["error"] = err.Error()
+	}
+
+	if fields != nil {
+		for k, v := range l.sanitizeSensitiveData(fields) {
+			entry[k] = v
+		}
+	}
+
+	jsonEntry, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		return fmt.Sprintf("Failed to marshal log entry: %v", marshalErr) + "\n"
+	}
+	return string(jsonEntry) + "\n"
+}
+
+// logTimestamp matches the timestamp of an entry, which may tick between encodings
+var logTimestamp = regexp.MustCompile(`"timestamp":"[^"]*"`)
+
+func TestDefaultLoggerMatchesReference(t *testing.T) {
+	requestCtx := context.WithValue(context.Background(), "request_id", "req-1")
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		err     error
+		fields  map[string]interface{}
+		message string
+	}{
+		{"no fields", context.Background(), nil, nil, "Payment initialized"},
+		{"request ID", requestCtx, nil, map[string]interface{}{"amount": 100000}, "Payment initialized"},
+		{"error", requestCtx, errors.New("gateway <timeout> & retry"), nil, "Verify failed"},
+		{"escaping", nil, nil, map[string]interface{}{
+			"description": `Order "7" <b>&</b>`,
+			"unicode":     "سفارش ۱۰۴۲",
+			"control":     "line\nbreak\t \r\b\f\x00\x1f\x7f",
+			"separators":  "a\u2028b\u2029c",
+			"invalid":     "bad\xffutf8\xc3",
+			"quotes":      `back\slash "quoted"`,
+		}, "Callback <received>"},
+		{"overridden entry fields", requestCtx, errors.New("boom"), map[string]interface{}{
+			"level":   "custom",
+			"message": 42,
+			"error":   false,
+		}, "Overridden"},
+		{"sensitive fields", requestCtx, nil, map[string]interface{}{
+			"card_number": "6037991234567890",
+			"token":       "sim00000000000000001",
+			"cvv":         123,
+			"nested":      map[string]interface{}{"password": "hunter2", "ok": true},
+		}, "Payment verified"},
+		{"numbers", nil, nil, map[string]interface{}{
+			"int":   int64(9007199254740993),
+			"float": 0.1,
+			"small": int32(-7),
+			"big":   uint64(18446744073709551615),
+			"bool":  true,
+			"slice": []int{1, 2, 3},
+			"nil":   nil,
+		}, "Numbers"},
+		{"unencodable", nil, nil, map[string]interface{}{"channel": make(chan int)}, "Broken"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger := NewDefaultLoggerWithOutput("DEBUG", &out, &out).(*defaultLogger)
+
+			logger.writeLog(tt.ctx, &out, Info, tt.message, tt.err, tt.fields)
+			want := referenceFormatLog(logger, tt.ctx, Info, tt.message, tt.err, tt.fields)
+
+			got := logTimestamp.ReplaceAllString(out.String(), `"timestamp":""`)
+			want = logTimestamp.ReplaceAllString(want, `"timestamp":""`)
+			if got != want {
+				t.Fatalf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDefaultLoggerOutputs(t *testing.T) {
+	var out, errOut bytes.Buffer
+	logger := NewDefaultLoggerWithOutput("INFO", &out, &errOut)
+
+	logger.Debug(context.Background(), "debug", nil)
+	logger.Info(context.Background(), "info", nil)
+	logger.Warn(context.Background(), "warn", nil)
+	logger.Error(context.Background(), "error", errors.New("boom"), nil)
+
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != 1 || !bytes.Contains(out.Bytes(), []byte(`"message":"info"`)) {
+		t.Errorf("out = %q, want the info entry only", out.String())
+	}
+	if lines := bytes.Count(errOut.Bytes(), []byte("\n")); lines != 2 {
+		t.Errorf("errOut = %q, want the warning and the error", errOut.String())
+	}
+
+	// A large entry doesn't leave an oversized buffer in the pool
+	logger.Info(context.Background(), "large", map[string]interface{}{"body": string(make([]byte, 2*maxPooledLogBuffer))})
+	lb := logBufferPool.Get().(*logBuffer)
+	if lb.buf.Cap() > maxPooledLogBuffer {
+		t.Errorf("pooled buffer of %d bytes", lb.buf.Cap())
+	}
+	logBufferPool.Put(lb)
+}
+
+func BenchmarkFormatLog(b *testing.B) {
+	ctx := context.WithValue(context.Background(), "request_id", "req-1")
+	fields := map[string]interface{}{
+		"token":  "sim00000000000000001",
+		"amount": 100000,
+		"status": "INIT",
+		"route":  "/payments/init",
+	}
+	logger := NewDefaultLoggerWithOutput("INFO", io.Discard, io.Discard).(*defaultLogger)
+
+	b.Run("reference", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fmt.Fprint(io.Discard, referenceFormatLog(logger, ctx, Info, "Payment initialized", nil, fields))
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.writeLog(ctx, io.Discard, Info, "Payment initialized", nil, fields)
+		}
+	})
+}
+
+func FuzzLogString(f *testing.F) {
+	for _, seed := range []string{"", "plain", `"quoted" \ <html> & co`, "line\nbreak\x00\x7f", "a\u2028b", "bad\xff\xc3", "سفارش"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		want, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lb := &logBuffer{}
+		lb.writeString(s)
+		if got := lb.buf.String(); got != string(want) {
+			t.Fatalf("writeString(%q) = %s, want %s", s, got, want)
+		}
+	})
+}
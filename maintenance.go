@@ -0,0 +1,105 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// maintenance.go implements detection of gateway maintenance pages
+package vandargo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GatewayMaintenanceCode is the error code of responses sent while the gateway is unavailable
+const GatewayMaintenanceCode = "gateway_maintenance"
+
+// GatewayUnavailableError reports a non-JSON gateway response, such as the HTML page
+// served during nightly Shaparak maintenance windows. It matches ErrGatewayUnavailable.
+type GatewayUnavailableError struct {
+	// StatusCode is the HTTP status of the gateway response
+	StatusCode int
+
+	// ContentType is the content type of the gateway response
+	ContentType string
+
+	// RetryAfter is how long the gateway asked callers to wait, zero when unknown
+	RetryAfter time.Duration
+}
+
+// Error describes the unavailable gateway response
+func (e *GatewayUnavailableError) Error() string {
+	message := fmt.Sprintf("%s: non-JSON response (status %d, content type %q)", ErrGatewayUnavailable, e.StatusCode, e.ContentType)
+	if e.RetryAfter > 0 {
+		message += fmt.Sprintf(", retry after %s", e.RetryAfter)
+	}
+	return message
+}
+
+// Unwrap returns ErrGatewayUnavailable
+func (e *GatewayUnavailableError) Unwrap() error {
+	return ErrGatewayUnavailable
+}
+
+// gatewayUnavailable classifies a gateway response that is not JSON, returning nil
+// for JSON and empty bodies
+func gatewayUnavailable(resp *http.Response, body []byte) *GatewayUnavailableError {
+	contentType := resp.Header.Get("Content-Type")
+	trimmed := bytes.TrimSpace(body)
+
+	isHTML := strings.Contains(strings.ToLower(contentType), "text/html")
+	isJSON := len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+	if !isHTML && (len(trimmed) == 0 || isJSON) {
+		return nil
+	}
+
+	return &GatewayUnavailableError{
+		StatusCode:  resp.StatusCode,
+		ContentType: contentType,
+		RetryAfter:  parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
+
+// setRetryAfter sets the Retry-After response header for errors that carry a wait time
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var retryAfter time.Duration
+	var unavailable *GatewayUnavailableError
+	var velocityErr *VelocityError
+	switch {
+	case errors.As(err, &unavailable):
+		retryAfter = unavailable.RetryAfter
+	case errors.As(err, &velocityErr) && !velocityErr.Violation.Rule.Block:
+		retryAfter = velocityErr.Violation.RetryAfter
+	case errors.Is(err, ErrTooManyInFlight):
+		retryAfter = gatewayBusyRetryAfter
+	}
+	if retryAfter <= 0 {
+		return
+	}
+
+	// Round up so callers never retry too early
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,135 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// maintenancePage returns the captured Shaparak maintenance page
+func maintenancePage(t *testing.T) string {
+	t.Helper()
+
+	page, err := os.ReadFile(filepath.Join("testdata", "gateway", "maintenance.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(page)
+}
+
+func TestGatewayUnavailableClassification(t *testing.T) {
+	page := maintenancePage(t)
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		retryAfter  string
+		body        string
+		unavailable bool
+		wait        time.Duration
+	}{
+		{"maintenance page", http.StatusServiceUnavailable, "text/html; charset=utf-8", "120", page, true, 2 * time.Minute},
+		{"maintenance page with 200", http.StatusOK, "text/html", "", page, true, 0},
+		{"HTML labelled as JSON", http.StatusOK, "application/json", "", page, true, 0},
+		{"plain text", http.StatusBadGateway, "text/plain", "", "Bad Gateway", true, 0},
+		{"HTML type with JSON body", http.StatusOK, "TEXT/HTML", "", `{"status":1}`, true, 0},
+		{"JSON object", http.StatusOK, "application/json", "", ` {"status":1}`, false, 0},
+		{"JSON array", http.StatusOK, "", "", `[1,2]`, false, 0},
+		{"JSON error", http.StatusServiceUnavailable, "application/json", "30", `{"status":0,"errors":["busy"]}`, false, 0},
+		{"empty body", http.StatusNoContent, "", "", "", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.contentType != "" {
+				resp.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+
+			err := gatewayUnavailable(resp, []byte(tt.body))
+			if (err != nil) != tt.unavailable {
+				t.Fatalf("gatewayUnavailable() = %v, want unavailable %v", err, tt.unavailable)
+			}
+			if err == nil {
+				return
+			}
+			if !errors.Is(err, ErrGatewayUnavailable) || err.StatusCode != tt.status || err.RetryAfter != tt.wait {
+				t.Fatalf("gatewayUnavailable() = %+v", err)
+			}
+
+			// The outage is retried and trips the failover circuit breaker
+			retryable := isRetryable(context.Background(), tt.status, err)
+			if !retryable || !isProviderOutage(err) {
+				t.Fatalf("%v: retryable %v, outage %v", err, retryable, isProviderOutage(err))
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 30, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestHandlerDuringMaintenance(t *testing.T) {
+	transport := newStubTransport(stubStep{
+		status: http.StatusServiceUnavailable,
+		body:   maintenancePage(t),
+		header: http.Header{"Content-Type": {"text/html; charset=utf-8"}, "Retry-After": {"90"}},
+	})
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) { c.MaxRetries = 2 }), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	req := httptest.NewRequest(http.MethodGet, "/payments/status?token="+webhookToken, nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	rec := httptest.NewRecorder()
+	client.Handler().ServeHTTP(rec, req)
+
+	// Callers get a friendly 503 instead of a parse error
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q is not JSON", rec.Body)
+	}
+	if rec.Code != http.StatusServiceUnavailable || body["code"] != GatewayMaintenanceCode || rec.Header().Get("Retry-After") != "90" {
+		t.Fatalf("status %d, Retry-After %q: %v", rec.Code, rec.Header().Get("Retry-After"), body)
+	}
+
+	// The page was retried before giving up
+	if transport.count() != 3 {
+		t.Fatalf("%d gateway requests, want 3", transport.count())
+	}
+
+	// Client callers can tell the outage apart
+	_, err := client.GetPaymentStatus(context.Background(), webhookToken)
+	var unavailable *GatewayUnavailableError
+	if !errors.As(err, &unavailable) || unavailable.RetryAfter != 90*time.Second {
+		t.Fatalf("GetPaymentStatus() = %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// metrics.go implements metric names and the default no-op metrics recorder
+package vandargo
+
+import "time"
+
+// Metric names recorded by the package
+const (
+	// MetricHTTPRequestDuration is a histogram of payment endpoint requests,
+	// labeled by method, route and status code
+	MetricHTTPRequestDuration = "vandar_http_request_duration_seconds"
+
+	// MetricGatewayRequestDuration is a histogram of requests to the Vandar API,
+	// labeled by method, endpoint and status code ("0" when no response arrived)
+	MetricGatewayRequestDuration = "vandar_gateway_request_duration_seconds"
+
+	// MetricTransactions counts transactions entering a status, labeled by status
+	MetricTransactions = "vandar_transactions_total"
+
+	// MetricRateLimitRejections counts requests rejected by the rate limiter, labeled by route
+	MetricRateLimitRejections = "vandar_rate_limit_rejections_total"
+
+	// MetricCircuitBreakerOpen is 1 while a FailoverProvider's breaker skips a
+	// provider and 0 otherwise, labeled by provider
+	MetricCircuitBreakerOpen = "vandar_circuit_breaker_open"
+
+	// MetricCacheHits counts gateway responses served from the cache
+	MetricCacheHits = "vandar_cache_hits_total"
+
+	// MetricCacheMisses counts gateway lookups not found in the cache
+	MetricCacheMisses = "vandar_cache_misses_total"
+
+	// MetricHedgedRequests counts second requests sent for slow gateway lookups
+	MetricHedgedRequests = "vandar_hedged_requests_total"
+
+	// MetricHedgeWins counts hedged lookups answered by the second request first
+	MetricHedgeWins = "vandar_hedge_wins_total"
+
+	// MetricLogEntriesDropped counts log entries dropped by AsyncLogger
+	MetricLogEntriesDropped = "vandar_log_entries_dropped_total"
+
+	// MetricVerifyFailures counts failed verifications, labeled by reason
+	MetricVerifyFailures = "vandar_verify_failures_total"
+
+	// MetricChallengeFailures counts payment initializations rejected for their
+	// challenge token, labeled by reason
+	MetricChallengeFailures = "vandar_challenge_failures_total"
+
+	// MetricVelocityViolations counts payment initializations refused by a
+	// velocity rule, labeled by rule
+	MetricVelocityViolations = "vandar_velocity_violations_total"
+
+	// MetricVerificationAtRisk counts paid payments still unverified past the warning threshold
+	MetricVerificationAtRisk = "vandar_verification_at_risk_total"
+
+	// MetricComponentHealth is the state of each component at the last health
+	// report, labeled by component: 0 ok, 1 degraded, 2 down
+	MetricComponentHealth = "vandar_component_health"
+
+	// MetricEchoMismatches counts payments whose echoed description or factor
+	// number didn't match what was sent, labeled by field
+	MetricEchoMismatches = "vandar_echo_mismatches_total"
+
+	// MetricRefundBatchItems counts the refunds of refund batches, labeled by outcome
+	MetricRefundBatchItems = "vandar_refund_batch_items_total"
+
+	// MetricGatewayRequestsInFlight is the number of requests to the gateway in flight
+	MetricGatewayRequestsInFlight = "vandar_gateway_requests_in_flight"
+
+	// MetricGatewayConcurrencyRejections counts gateway requests rejected with
+	// ErrTooManyInFlight
+	MetricGatewayConcurrencyRejections = "vandar_gateway_concurrency_rejections_total"
+)
+
+// noopMetrics is a MetricsInterface implementation that discards all metrics
+type noopMetrics struct{}
+
+// IncCounter discards the counter increment
+func (noopMetrics) IncCounter(name string, labels map[string]string) {}
+
+// ObserveDuration discards the duration sample
+func (noopMetrics) ObserveDuration(name string, duration time.Duration, labels map[string]string) {}
+
+// SetGauge discards the gauge value
+func (noopMetrics) SetGauge(name string, value float64, labels map[string]string) {}
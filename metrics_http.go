@@ -0,0 +1,108 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// metrics_http.go implements request metrics and the Prometheus scrape endpoint
+package vandargo
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// metricsPath is the path of the optional Prometheus scrape route
+	metricsPath = "/payments/metrics"
+
+	// prometheusContentType is the content type of the text exposition format
+	prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// MetricsMiddleware records the duration of each request in
+// MetricHTTPRequestDuration, labeled by method, route pattern and status code
+func MetricsMiddleware(metrics MetricsInterface) Middleware {
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := newResponseWriter(w)
+
+			next(rw, r)
+
+			route := routePattern(r)
+			if route == "" {
+				route = "unmatched"
+			}
+			status := rw.status
+			if clientGone(r.Context()) {
+				status = StatusClientClosedRequest
+			}
+			metrics.ObserveDuration(MetricHTTPRequestDuration, time.Since(start), map[string]string{
+				"method": r.Method,
+				"route":  route,
+				"status": strconv.Itoa(status),
+			})
+		}
+	}
+}
+
+// WithMetricsRoute registers GET /payments/metrics, which serves the client's
+// metrics in the Prometheus text exposition format. The client must record them
+// in a PrometheusExposer such as NewMetricsRegistry, set with WithMetrics. Scrapers
+// authenticate with token as a bearer token or, when it is empty, like the admin
+// routes with an API key with the admin scope and the admin key.
+func WithMetricsRoute(token string) RouteOption {
+	return func(o *routeOptions) {
+		o.enable(metricsPath)
+		o.metricsToken = token
+	}
+}
+
+// MetricsTokenMiddleware requires token as the bearer token of the request
+func MetricsTokenMiddleware(token string) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Invalid metrics token")
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// metricsAuth returns the authentication of the metrics route
+func (o *routeOptions) metricsAuth(config ConfigInterface) []Middleware {
+	if o.metricsToken != "" {
+		return []Middleware{MetricsTokenMiddleware(o.metricsToken)}
+	}
+	return []Middleware{o.authMiddleware(config, ScopeAdmin), AdminKeyMiddleware(config)}
+}
+
+// handleMetrics serves the client's metrics in the Prometheus text exposition format
+func (c *Client) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	exposer, ok := c.metrics.(PrometheusExposer)
+	if !ok {
+		c.respondWithError(w, ErrNotFound, "Metrics are not recorded in a registry that can be scraped")
+		return
+	}
+
+	// Render first so a failure can still be answered with an error
+	var body bytes.Buffer
+	if err := exposer.WritePrometheus(&body); err != nil {
+		c.respondWithError(w, ErrInternalError, "Failed to render metrics")
+		c.log(r.Context()).Error(r.Context(), "Failed to render metrics", err, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
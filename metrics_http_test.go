@@ -0,0 +1,126 @@
+package vandargo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics requests the metrics route with the given bearer token
+func scrapeMetrics(handler http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/payments/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMetricsRouteScrape(t *testing.T) {
+	registry := NewMetricsRegistry()
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(), WithClientMetrics(registry))
+	handler := client.Handler(WithMetricsRoute("metrics-token"))
+
+	// A few payments, the last of them over the init rate limit
+	for i := 0; i < 11; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/payments/init", strings.NewReader(fmt.Sprintf(`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order %d"}`, 1040+i)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		req.RemoteAddr = "203.0.113.7:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	failover, err := NewFailoverProvider(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failover.WithMetrics(registry)
+
+	rec := scrapeMetrics(handler, "metrics-token")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != prometheusContentType {
+		t.Fatalf("status %d, content type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+
+	scraped := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE vandar_http_request_duration_seconds histogram\n",
+		`vandar_http_request_duration_seconds_count{method="POST",route="/payments/init",status="200"} 10` + "\n",
+		`vandar_http_request_duration_seconds_count{method="POST",route="/payments/init",status="429"} 1` + "\n",
+		`vandar_http_request_duration_seconds_bucket{method="POST",route="/payments/init",status="200",le="+Inf"} 10` + "\n",
+		"# TYPE vandar_gateway_request_duration_seconds histogram\n",
+		`vandar_gateway_request_duration_seconds_count{endpoint="send",method="POST",status_code="200"} 10` + "\n",
+		"# TYPE vandar_transactions_total counter\n",
+		`vandar_transactions_total{status="INIT"} 10` + "\n",
+		"# TYPE vandar_rate_limit_rejections_total counter\n",
+		`vandar_rate_limit_rejections_total{route="/payments/init"} 1` + "\n",
+		"# TYPE vandar_circuit_breaker_open gauge\n",
+		`vandar_circuit_breaker_open{provider="vandar"} 0` + "\n",
+	} {
+		if !strings.Contains(scraped, want) {
+			t.Errorf("scrape lacks %q", want)
+		}
+	}
+	if t.Failed() {
+		t.Logf("scraped:\n%s", scraped)
+	}
+}
+
+func TestMetricsRouteAuth(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.AdminKey = "admin-key"
+	}), nil, WithClientMetrics(NewMetricsRegistry()))
+
+	// A separate token replaces the API key
+	handler := client.Handler(WithMetricsRoute("metrics-token"))
+	for token, want := range map[string]int{"metrics-token": http.StatusOK, testAPIKey: http.StatusUnauthorized, "": http.StatusUnauthorized} {
+		if rec := scrapeMetrics(handler, token); rec.Code != want {
+			t.Errorf("token %q: status %d, want %d", token, rec.Code, want)
+		}
+	}
+
+	// Without one the admin key is required as well
+	handler = client.Handler(WithMetricsRoute(""))
+	if rec := scrapeMetrics(handler, testAPIKey); rec.Code == http.StatusOK {
+		t.Fatal("scraped without the admin key")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/payments/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	req.Header.Set(AdminKeyHeader, "admin-key")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admin scrape: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// The route isn't registered unless asked for
+	if rec := scrapeMetrics(client.Handler(), "metrics-token"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unregistered route: status %d", rec.Code)
+	}
+}
+
+func TestMetricsRouteWithoutRegistry(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), nil, WithClientMetrics(newRecordingMetrics()))
+	if rec := scrapeMetrics(client.Handler(WithMetricsRoute("metrics-token")), "metrics-token"); rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestMetricsRegistryEscapesLabels(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.IncCounter("vandar-test.total", map[string]string{"route": "a\"b\\c\nd", "bad-name": "x"})
+	registry.SetGauge("vandar_gauge", 1.5, nil)
+
+	var out strings.Builder
+	if err := registry.WritePrometheus(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`vandar_test_total{bad_name="x",route="a\"b\\c\nd"} 1`,
+		"vandar_gauge 1.5",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %s:\n%s", want, out.String())
+		}
+	}
+}
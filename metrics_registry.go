@@ -0,0 +1,242 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// metrics_registry.go implements an in-memory metrics registry with Prometheus text exposition
+package vandargo
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHistogramBuckets are the upper bounds, in seconds, of the duration
+// histograms of a MetricsRegistry
+var DefaultHistogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// PrometheusExposer is implemented by metrics recorders that can write their
+// metrics in the Prometheus text exposition format, such as MetricsRegistry
+type PrometheusExposer interface {
+	// WritePrometheus writes every metric in the text exposition format
+	WritePrometheus(w io.Writer) error
+}
+
+// metricKind is the Prometheus type of a metric family
+type metricKind string
+
+const (
+	metricCounter   metricKind = "counter"
+	metricGauge     metricKind = "gauge"
+	metricHistogram metricKind = "histogram"
+)
+
+// metricSeries is one label set of a metric family
+type metricSeries struct {
+	labels map[string]string
+	value  float64
+
+	// Histogram state; buckets count samples per bound, not cumulatively
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// metricFamily holds the series of one metric name
+type metricFamily struct {
+	kind   metricKind
+	series map[string]*metricSeries
+}
+
+// MetricsRegistry is a MetricsInterface that keeps metrics in memory for
+// applications without their own Prometheus registry. Counters and gauges keep
+// their last value, durations are recorded as histograms in seconds. A name
+// first used as one type ignores samples of another.
+type MetricsRegistry struct {
+	mutex    sync.Mutex
+	families map[string]*metricFamily
+	buckets  []float64
+}
+
+// NewMetricsRegistry creates an empty registry using DefaultHistogramBuckets
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		families: make(map[string]*metricFamily),
+		buckets:  DefaultHistogramBuckets,
+	}
+}
+
+// IncCounter increments a counter
+func (m *MetricsRegistry) IncCounter(name string, labels map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if series := m.series(name, metricCounter, labels); series != nil {
+		series.value++
+	}
+}
+
+// ObserveDuration records a duration sample in a histogram, in seconds
+func (m *MetricsRegistry) ObserveDuration(name string, duration time.Duration, labels map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	series := m.series(name, metricHistogram, labels)
+	if series == nil {
+		return
+	}
+
+	seconds := duration.Seconds()
+	if series.buckets == nil {
+		series.buckets = make([]uint64, len(m.buckets))
+	}
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			series.buckets[i]++
+			break
+		}
+	}
+	series.sum += seconds
+	series.count++
+}
+
+// SetGauge sets a gauge
+func (m *MetricsRegistry) SetGauge(name string, value float64, labels map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if series := m.series(name, metricGauge, labels); series != nil {
+		series.value = value
+	}
+}
+
+// series returns the series of a name and label set, creating it, or nil when
+// the name is already used by another kind of metric. The caller holds the mutex.
+func (m *MetricsRegistry) series(name string, kind metricKind, labels map[string]string) *metricSeries {
+	name = sanitizeMetricName(name)
+
+	family, exists := m.families[name]
+	if !exists {
+		family = &metricFamily{kind: kind, series: make(map[string]*metricSeries)}
+		m.families[name] = family
+	}
+	if family.kind != kind {
+		return nil
+	}
+
+	key := formatLabels(labels)
+	series, exists := family.series[key]
+	if !exists {
+		copied := make(map[string]string, len(labels))
+		for label, value := range labels {
+			copied[label] = value
+		}
+		series = &metricSeries{labels: copied}
+		family.series[key] = series
+	}
+	return series
+}
+
+// WritePrometheus writes every metric in the Prometheus text exposition format,
+// families and series sorted by name and labels
+func (m *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	out := bufio.NewWriter(w)
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := m.families[name]
+		out.WriteString("# TYPE " + name + " " + string(family.kind) + "\n")
+
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := family.series[key]
+			if family.kind != metricHistogram {
+				out.WriteString(name + key + " " + formatMetricValue(series.value) + "\n")
+				continue
+			}
+
+			var cumulative uint64
+			for i, bound := range m.buckets {
+				if series.buckets != nil {
+					cumulative += series.buckets[i]
+				}
+				out.WriteString(name + "_bucket" + formatLabels(series.labels, "le", formatMetricValue(bound)) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+			}
+			out.WriteString(name + "_bucket" + formatLabels(series.labels, "le", "+Inf") + " " + strconv.FormatUint(series.count, 10) + "\n")
+			out.WriteString(name + "_sum" + key + " " + formatMetricValue(series.sum) + "\n")
+			out.WriteString(name + "_count" + key + " " + strconv.FormatUint(series.count, 10) + "\n")
+		}
+	}
+
+	return out.Flush()
+}
+
+// formatLabels formats a label set as {a="1",b="2"} sorted by name, with extra
+// name/value pairs appended, or "" when there are none
+func formatLabels(labels map[string]string, extra ...string) string {
+	if len(labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for _, name := range names {
+		pairs = append(pairs, sanitizeMetricName(name)+`="`+escapeLabelValue(labels[name])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelValueEscaper escapes label values as the exposition format requires
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes a label value
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// sanitizeMetricName replaces characters not allowed in metric and label names
+func sanitizeMetricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// formatMetricValue formats a sample value
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
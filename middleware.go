@@ -5,10 +5,13 @@ package vandargo
 import (
 	"context"
 	"fmt"
-	"net"
+	"hash/fnv"
+	"io"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,13 +26,70 @@ func Chain(handler http.HandlerFunc, middlewares ...Middleware) http.HandlerFunc
 	return handler
 }
 
-// LoggingMiddleware logs request information
-func LoggingMiddleware(logger LoggerInterface) Middleware {
+// defaultSlowRequestThreshold is the duration above which requests are logged as slow
+const defaultSlowRequestThreshold = 5 * time.Second
+
+// LoggingOption configures LoggingMiddleware
+type LoggingOption func(*loggingOptions)
+
+// loggingOptions holds the settings collected from LoggingOption values
+type loggingOptions struct {
+	slowThreshold time.Duration
+	sampleRate    float64
+	successLevel  LogLevel
+	logBody       bool
+}
+
+// WithSlowRequestThreshold sets the duration above which a request is additionally
+// logged as a warning; zero or less disables slow request warnings
+func WithSlowRequestThreshold(threshold time.Duration) LoggingOption {
+	return func(o *loggingOptions) {
+		o.slowThreshold = threshold
+	}
+}
+
+// WithSampleRate logs only a fraction (0 to 1) of successful requests. Sampling is
+// deterministic by request ID, so retries carrying the same ID are sampled alike.
+// Responses with status 400 or above are always logged.
+func WithSampleRate(rate float64) LoggingOption {
+	return func(o *loggingOptions) {
+		o.sampleRate = rate
+	}
+}
+
+// WithSuccessLogLevel sets the level at which successful requests are logged
+func WithSuccessLogLevel(level LogLevel) LoggingOption {
+	return func(o *loggingOptions) {
+		o.successLevel = level
+	}
+}
+
+// WithClientErrorBodies includes the redacted request body in logs of 4xx responses.
+// Only bodies buffered by BufferBodyMiddleware are logged.
+func WithClientErrorBodies() LoggingOption {
+	return func(o *loggingOptions) {
+		o.logBody = true
+	}
+}
+
+// LoggingMiddleware logs request information; with a nil logger it only passes
+// requests on
+func LoggingMiddleware(logger LoggerInterface, opts ...LoggingOption) Middleware {
+	logger = loggerOrDiscard(logger)
+	options := &loggingOptions{
+		slowThreshold: defaultSlowRequestThreshold,
+		sampleRate:    1,
+		successLevel:  Info,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
-			// Create a response wrapper to capture status code
+			// Create a response wrapper to capture status code and size
 			rw := newResponseWriter(w)
 
 			// Process request
@@ -37,18 +97,86 @@ func LoggingMiddleware(logger LoggerInterface) Middleware {
 
 			// Log request details
 			duration := time.Since(start)
-			logger.Info(r.Context(), "HTTP Request", map[string]interface{}{
-				"method":     r.Method,
-				"path":       r.URL.Path,
-				"status":     rw.status,
-				"duration":   duration.Milliseconds(),
-				"user_agent": r.UserAgent(),
-				"remote_ip":  getClientIP(r),
-			})
+			fields := map[string]interface{}{
+				"method":         r.Method,
+				"path":           r.URL.Path,
+				"status":         rw.status,
+				"duration":       duration.Milliseconds(),
+				"response_bytes": rw.bytes,
+				"user_agent":     r.UserAgent(),
+				"remote_ip":      getClientIP(r),
+			}
+
+			requestID, _ := r.Context().Value("request_id").(string)
+			if requestID != "" {
+				fields["request_id"] = requestID
+			}
+
+			if route := routePattern(r); route != "" {
+				fields["route"] = route
+			}
+
+			if options.logBody && rw.status >= http.StatusBadRequest && rw.status < http.StatusInternalServerError {
+				if body, ok := loggableBody(r); ok {
+					fields["request_body"] = body
+				}
+			}
+
+			// Callers that went away got no response and need no attention
+			if clientGone(r.Context()) {
+				fields["status"] = StatusClientClosedRequest
+				fields["client_gone"] = true
+				logger.Debug(r.Context(), "HTTP Request", fields)
+				return
+			}
+
+			if rw.status >= http.StatusBadRequest {
+				logger.Info(r.Context(), "HTTP Request", fields)
+			} else if sampled(requestID, options.sampleRate) {
+				logAtLevel(r.Context(), logger, options.successLevel, "HTTP Request", fields)
+			}
+
+			if options.slowThreshold > 0 && duration > options.slowThreshold {
+				fields["threshold"] = options.slowThreshold.Milliseconds()
+				logger.Warn(r.Context(), "Slow request", fields)
+			}
 		}
 	}
 }
 
+// sampled decides whether a request is included in a sample of the given rate
+func sampled(requestID string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+
+	// Requests without an ID can't be sampled consistently, pick them at random
+	if requestID == "" {
+		return rand.Float64() < rate
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(requestID))
+	return float64(hash.Sum32()%10000) < rate*10000
+}
+
+// logAtLevel logs a message at the given level
+func logAtLevel(ctx context.Context, logger LoggerInterface, level LogLevel, message string, fields map[string]interface{}) {
+	switch level {
+	case Debug:
+		logger.Debug(ctx, message, fields)
+	case Warn:
+		logger.Warn(ctx, message, fields)
+	case Error:
+		logger.Error(ctx, message, nil, fields)
+	default:
+		logger.Info(ctx, message, fields)
+	}
+}
+
 // SecurityHeadersMiddleware adds security headers to responses
 func SecurityHeadersMiddleware() Middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
@@ -68,38 +196,118 @@ func SecurityHeadersMiddleware() Middleware {
 
 // RateLimitMiddleware implements rate limiting
 func RateLimitMiddleware(limit int, window time.Duration) Middleware {
-	// A simple in-memory rate limiter
-	type client struct {
-		count    int
-		lastSeen time.Time
+	return RateLimitMiddlewareWithClock(limit, window, RealClock())
+}
+
+// RateLimitMiddlewareWithClock implements rate limiting with windows measured by clock
+func RateLimitMiddlewareWithClock(limit int, window time.Duration, clock Clock) Middleware {
+	return RateLimitMiddlewareWithMetrics(limit, window, clock, nil)
+}
+
+// RateLimitMiddlewareWithMetrics implements rate limiting with windows measured by
+// clock, counting rejections in MetricRateLimitRejections by route
+func RateLimitMiddlewareWithMetrics(limit int, window time.Duration, clock Clock, metrics MetricsInterface) Middleware {
+	if metrics == nil {
+		metrics = noopMetrics{}
 	}
 
-	clients := make(map[string]*client)
+	limiter := newIPRateLimiter(limit, window)
 
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			ip := getClientIP(r)
-			now := time.Now()
-
-			// Get or create client record
-			c, exists := clients[ip]
-			if !exists || now.Sub(c.lastSeen) > window {
-				// Reset count if window has passed
-				clients[ip] = &client{
-					count:    1,
-					lastSeen: now,
-				}
-				next(w, r)
+			if !limiter.allow(getClientIP(r), clock.Now()) {
+				metrics.IncCounter(MetricRateLimitRejections, map[string]string{"route": routePattern(r)})
+				writeJSONError(w, r, http.StatusTooManyRequests, ErrRateLimited, "Rate limit exceeded")
 				return
 			}
 
-			// Update client record
-			c.lastSeen = now
-			c.count++
+			next(w, r)
+		}
+	}
+}
+
+// ipRateLimiter is a simple in-memory rate limiter counting requests per client
+// IP. A client's count resets once it has been idle for a window.
+type ipRateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mutex     sync.Mutex
+	clients   map[string]*ipRateWindow
+	lastSweep time.Time
+}
+
+// ipRateWindow is the request count of one client IP
+type ipRateWindow struct {
+	count    int
+	lastSeen time.Time
+}
+
+// newIPRateLimiter creates a limiter allowing limit requests per window
+func newIPRateLimiter(limit int, window time.Duration) *ipRateLimiter {
+	return &ipRateLimiter{
+		limit:   limit,
+		window:  window,
+		clients: make(map[string]*ipRateWindow),
+	}
+}
+
+// allow counts a request from ip and reports whether it is within the limit
+func (l *ipRateLimiter) allow(ip string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Drop clients idle for a whole window, at most once per window, so the map
+	// doesn't grow with every IP ever seen
+	if l.lastSweep.IsZero() {
+		l.lastSweep = now
+	} else if now.Sub(l.lastSweep) > l.window {
+		for key, client := range l.clients {
+			if now.Sub(client.lastSeen) > l.window {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	// Get or create client record, resetting the count if the window has passed
+	client, exists := l.clients[ip]
+	if !exists || now.Sub(client.lastSeen) > l.window {
+		l.clients[ip] = &ipRateWindow{count: 1, lastSeen: now}
+		return true
+	}
+
+	// Update client record
+	client.lastSeen = now
+	client.count++
 
-			// Check if rate limit is exceeded
-			if c.count > limit {
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	return client.count <= l.limit
+}
+
+// RateLimitMiddlewareWithStore implements rate limiting with counters in a
+// KVStore, so instances sharing the store share the limit. Each client IP may
+// make limit requests to a route per fixed window. When the store fails the
+// request is let through rather than refusing payments.
+func RateLimitMiddlewareWithStore(limit int, window time.Duration, clock Clock, metrics MetricsInterface, kv KVStore) Middleware {
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			now := clock.Now()
+			windowIndex := now.UnixNano() / int64(window)
+			remaining := window - time.Duration(now.UnixNano()%int64(window))
+			route := routePattern(r)
+			if route == "" {
+				route = r.URL.Path
+			}
+			key := fmt.Sprintf("vandar:ratelimit:%s:%s:%d", route, getClientIP(r), windowIndex)
+
+			count, err := kv.Incr(r.Context(), key, 1, remaining)
+			if err == nil && count > int64(limit) {
+				metrics.IncCounter(MetricRateLimitRejections, map[string]string{"route": routePattern(r)})
+				writeJSONError(w, r, http.StatusTooManyRequests, ErrRateLimited, "Rate limit exceeded")
 				return
 			}
 
@@ -112,8 +320,10 @@ func RateLimitMiddleware(limit int, window time.Duration) Middleware {
 func IPFilterMiddleware(config ConfigInterface) Middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
+			allowList := configValues(config).IPAllowList
+
 			// If allowlist is empty, allow all IPs
-			if len(config.(*configImpl).config.IPAllowList) == 0 {
+			if len(allowList) == 0 {
 				next(w, r)
 				return
 			}
@@ -123,7 +333,7 @@ func IPFilterMiddleware(config ConfigInterface) Middleware {
 
 			// Check if IP is allowed
 			allowed := false
-			for _, allowedIP := range config.(*configImpl).config.IPAllowList {
+			for _, allowedIP := range allowList {
 				if ip == allowedIP {
 					allowed = true
 					break
@@ -131,7 +341,7 @@ func IPFilterMiddleware(config ConfigInterface) Middleware {
 			}
 
 			if !allowed {
-				http.Error(w, "Access denied", http.StatusForbidden)
+				writeJSONError(w, r, http.StatusForbidden, ErrPermission, "Access denied")
 				return
 			}
 
@@ -140,31 +350,42 @@ func IPFilterMiddleware(config ConfigInterface) Middleware {
 	}
 }
 
-// AuthMiddleware validates API key
-func AuthMiddleware(config ConfigInterface) Middleware {
+// AuthMiddleware validates the API key against the configured server keys and
+// requires it to grant every given scope. A valid key lacking a scope gets 403
+// with the insufficient_scope code.
+func AuthMiddleware(config ConfigInterface, scopes ...Scope) Middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
 
 			// Check if Authorization header exists
 			if authHeader == "" {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Unauthorized")
 				return
 			}
 
 			// Check if Authorization header format is valid
 			parts := strings.Split(authHeader, " ")
 			if len(parts) != 2 || parts[0] != "Bearer" {
-				http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Invalid authorization format")
 				return
 			}
 
 			// Check if API key is valid
-			if parts[1] != config.GetAPIKey() {
-				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			key, ok := matchServerKey(serverKeys(config), parts[1])
+			if !ok {
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Invalid API key")
 				return
 			}
 
+			// Check if the key may use the route
+			for _, scope := range scopes {
+				if !key.HasScope(scope) {
+					writeJSONError(w, r, http.StatusForbidden, &ScopeError{Required: scope}, "API key lacks the "+string(scope)+" scope")
+					return
+				}
+			}
+
 			next(w, r)
 		}
 	}
@@ -172,6 +393,13 @@ func AuthMiddleware(config ConfigInterface) Middleware {
 
 // ValidateSignatureMiddleware validates request signature
 func ValidateSignatureMiddleware(config ConfigInterface) Middleware {
+	return ValidateSignatureMiddlewareWithNonces(config, nil)
+}
+
+// ValidateSignatureMiddlewareWithNonces validates request signatures and, with a
+// nonce store, rejects replays: requests must then carry an X-Nonce header, which
+// is signed as "path:timestamp:nonce:key" and accepted only once
+func ValidateSignatureMiddlewareWithNonces(config ConfigInterface, nonces *NonceStore) Middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			// Only validate POST and PUT requests
@@ -183,39 +411,66 @@ func ValidateSignatureMiddleware(config ConfigInterface) Middleware {
 			// Get signature from header
 			signature := r.Header.Get("X-Signature")
 			if signature == "" {
-				http.Error(w, "Missing signature", http.StatusUnauthorized)
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Missing signature")
 				return
 			}
 
 			// Get timestamp from header
 			timestamp := r.Header.Get("X-Timestamp")
 			if timestamp == "" {
-				http.Error(w, "Missing timestamp", http.StatusUnauthorized)
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Missing timestamp")
 				return
 			}
 
 			// Verify timestamp is recent (within 5 minutes)
 			timestampInt, err := strconv.ParseInt(timestamp, 10, 64)
 			if err != nil {
-				http.Error(w, "Invalid timestamp", http.StatusUnauthorized)
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Invalid timestamp")
 				return
 			}
 
 			now := time.Now().Unix()
 			if now-timestampInt > 300 || timestampInt-now > 300 {
-				http.Error(w, "Timestamp expired", http.StatusUnauthorized)
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Timestamp expired")
 				return
 			}
 
 			// Create signature string
 			signatureData := fmt.Sprintf("%s:%s:%s", r.URL.Path, timestamp, config.GetAPIKey())
 
+			// Replay protection signs a nonce as well
+			nonce := r.Header.Get(NonceHeader)
+			if nonces != nil {
+				if nonce == "" {
+					writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Missing nonce")
+					return
+				}
+				if len(nonce) > maxNonceLength {
+					writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Invalid nonce")
+					return
+				}
+				signatureData = fmt.Sprintf("%s:%s:%s:%s", r.URL.Path, timestamp, nonce, config.GetAPIKey())
+			}
+
 			// Verify signature
 			if !VerifySignature(signature, signatureData, config.GetAPIKey()) {
-				http.Error(w, "Invalid signature", http.StatusUnauthorized)
+				writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Invalid signature")
 				return
 			}
 
+			// A valid signature is only accepted once
+			if nonces != nil {
+				fresh, err := nonces.Use(r.Context(), nonce)
+				if err != nil {
+					writeJSONError(w, r, http.StatusServiceUnavailable, ErrInternalError, "Nonces are unavailable, retry later")
+					return
+				}
+				if !fresh {
+					writeJSONError(w, r, http.StatusUnauthorized, ErrAuthentication, "Nonce already used")
+					return
+				}
+			}
+
 			next(w, r)
 		}
 	}
@@ -223,12 +478,22 @@ func ValidateSignatureMiddleware(config ConfigInterface) Middleware {
 
 // RequestIDMiddleware adds a request ID to each request context
 func RequestIDMiddleware() Middleware {
+	return RequestIDMiddlewareWithGenerator(defaultIDGenerator)
+}
+
+// RequestIDMiddlewareWithGenerator adds a request ID to the context, creating
+// missing or unsafe ones with the generator
+func RequestIDMiddlewareWithGenerator(generator IDGenerator) Middleware {
+	if generator == nil {
+		generator = defaultIDGenerator
+	}
+
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			// Get request ID from header or generate a new one
+			// Get request ID from header, replacing ones unsafe to echo back
 			requestID := r.Header.Get("X-Request-ID")
-			if requestID == "" {
-				requestID = generateRequestID()
+			if !isHeaderSafeID(requestID) {
+				requestID = generator.NewRequestID()
 			}
 
 			// Add request ID to response header
@@ -243,10 +508,13 @@ func RequestIDMiddleware() Middleware {
 	}
 }
 
-// responseWriter is a wrapper for http.ResponseWriter that captures the status code
+// responseWriter is a wrapper for http.ResponseWriter that captures the status
+// code and the number of body bytes written
 type responseWriter struct {
 	http.ResponseWriter
-	status int
+	status      int
+	bytes       int64
+	wroteHeader bool
 }
 
 // newResponseWriter creates a new response writer
@@ -259,31 +527,52 @@ func newResponseWriter(w http.ResponseWriter) *responseWriter {
 
 // WriteHeader captures the status code before writing it
 func (rw *responseWriter) WriteHeader(code int) {
-	rw.status = code
+	// Only the first call reaches the client
+	if !rw.wroteHeader {
+		rw.status = code
+		rw.wroteHeader = true
+	}
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// getClientIP gets the client IP from the request
-func getClientIP(r *http.Request) string {
-	// Try X-Forwarded-For header first (for clients behind proxies)
-	forwardedFor := r.Header.Get("X-Forwarded-For")
-	if forwardedFor != "" {
-		// Get the first IP in the list
-		ips := strings.Split(forwardedFor, ",")
-		return strings.TrimSpace(ips[0])
+// Write counts the bytes written; an implicit header means status 200 was sent
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(p)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom passes through to the underlying writer's ReadFrom when available
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	rw.wroteHeader = true
+
+	var n int64
+	var err error
+	if readerFrom, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = readerFrom.ReadFrom(src)
+	} else {
+		n, err = io.Copy(rw.ResponseWriter, src)
 	}
 
-	// Try X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+	rw.bytes += n
+	return n, err
+}
+
+// Flush passes through to the underlying writer when it supports flushing
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		rw.wroteHeader = true
+		flusher.Flush()
 	}
+}
 
-	// Finally, use RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+// getClientIP gets the client IP resolved by ClientIPMiddleware, falling back to the
+// direct peer address; forwarding headers are never trusted without that middleware
+func getClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok && ip != "" {
+		return ip
 	}
 
-	return ip
+	return remoteAddrIP(r)
 }
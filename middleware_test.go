@@ -0,0 +1,289 @@
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitMiddlewareWindows(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	metrics := newRecordingMetrics()
+	handler := RateLimitMiddlewareWithMetrics(2, time.Minute, clock, metrics)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := func(ip string) int {
+		req := httptest.NewRequest(http.MethodPost, "/payments/init", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := request("203.0.113.1"); got != want {
+			t.Fatalf("request %d: status %d, want %d", i+1, got, want)
+		}
+	}
+
+	// Other clients have their own count
+	if got := request("203.0.113.2"); got != http.StatusOK {
+		t.Fatalf("other client: status %d", got)
+	}
+
+	// The count resets after a quiet window
+	clock.Advance(time.Minute + time.Second)
+	if got := request("203.0.113.1"); got != http.StatusOK {
+		t.Fatalf("after the window: status %d", got)
+	}
+
+	if got := metrics.counter(MetricRateLimitRejections); got != 1 {
+		t.Fatalf("rejections = %d, want 1", got)
+	}
+}
+
+func TestIPRateLimiterEvictsIdleClients(t *testing.T) {
+	limiter := newIPRateLimiter(10, time.Minute)
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 1000; i++ {
+		limiter.allow(fmt.Sprintf("198.51.100.%d", i), start)
+	}
+	limiter.allow("203.0.113.1", start.Add(90*time.Second))
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if len(limiter.clients) != 1 {
+		t.Fatalf("%d clients tracked, want idle ones evicted", len(limiter.clients))
+	}
+}
+
+func TestRateLimitMiddlewareConcurrent(t *testing.T) {
+	const limit = 50
+	var passed sync.Map
+	handler := RateLimitMiddleware(limit, time.Minute)(func(w http.ResponseWriter, r *http.Request) {
+		passed.Store(r.Header.Get("X-Request-ID"), true)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/payments/init", nil)
+			req.RemoteAddr = fmt.Sprintf("203.0.113.%d:1234", i%2)
+			req.Header.Set("X-Request-ID", fmt.Sprint(i))
+			handler(httptest.NewRecorder(), req)
+		}(i)
+	}
+	wg.Wait()
+
+	count := 0
+	passed.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	if count != 2*limit {
+		t.Fatalf("%d requests passed, want %d per client", count, limit)
+	}
+}
+
+// loggedRequest runs a request through LoggingMiddleware and returns the
+// access log entry
+func loggedRequest(t *testing.T, handler http.HandlerFunc, opts ...LoggingOption) (logEntry, *captureLogger) {
+	t.Helper()
+
+	logger := &captureLogger{}
+	req := httptest.NewRequest(http.MethodGet, "/payments/status", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	Chain(handler, RequestIDMiddleware(), routeMiddleware("/payments/status"), LoggingMiddleware(logger, opts...))(httptest.NewRecorder(), req)
+
+	entry, ok := logger.find("HTTP Request")
+	if !ok {
+		t.Fatalf("no access log entry in %+v", logger.all())
+	}
+	return entry, logger
+}
+
+func TestLoggingMiddlewareResponseFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		bytes   int64
+	}{
+		{"implicit header", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello ")
+			io.WriteString(w, "world")
+		}, http.StatusOK, 11},
+		{"explicit header", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, "upstream failed")
+		}, http.StatusBadGateway, 15},
+		{"header after body", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "streamed")
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusOK, 8},
+		{"repeated header", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusCreated, 0},
+		{"ReadFrom", func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(w, strings.NewReader(strings.Repeat("x", 5000)))
+		}, http.StatusOK, 5000},
+		{"no response", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, _ := loggedRequest(t, tt.handler)
+
+			if entry.fields["status"] != tt.status || entry.fields["response_bytes"] != tt.bytes {
+				t.Fatalf("logged status %v with %v bytes, want %d with %d", entry.fields["status"], entry.fields["response_bytes"], tt.status, tt.bytes)
+			}
+			if entry.fields["request_id"] != "req-1" || entry.fields["route"] != "/payments/status" {
+				t.Fatalf("request_id %v, route %v", entry.fields["request_id"], entry.fields["route"])
+			}
+		})
+	}
+}
+
+func TestLoggingMiddlewareFlushPassthrough(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handler := LoggingMiddleware(&captureLogger{})(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("wrapped writer is not a Flusher")
+		}
+		io.WriteString(w, "event")
+		flusher.Flush()
+	})
+	handler(rec, httptest.NewRequest(http.MethodGet, "/payments/status", nil))
+
+	if !rec.Flushed {
+		t.Fatal("flush did not reach the underlying writer")
+	}
+}
+
+func TestLoggingMiddlewareSlowRequests(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	_, logger := loggedRequest(t, slow, WithSlowRequestThreshold(5*time.Millisecond))
+	warnings := logger.at("warn")
+	if len(warnings) != 1 || warnings[0].message != "Slow request" {
+		t.Fatalf("warnings %+v, want one slow request", warnings)
+	}
+	if warnings[0].fields["threshold"] != int64(5) || warnings[0].fields["duration"].(int64) < 20 {
+		t.Errorf("slow request fields %+v", warnings[0].fields)
+	}
+	if warnings[0].fields["request_id"] != "req-1" || warnings[0].fields["route"] != "/payments/status" {
+		t.Errorf("slow request fields %+v", warnings[0].fields)
+	}
+
+	// Fast requests and a disabled threshold stay quiet
+	if _, logger := loggedRequest(t, func(w http.ResponseWriter, r *http.Request) {}, WithSlowRequestThreshold(time.Second)); len(logger.at("warn")) != 0 {
+		t.Errorf("fast request warned: %+v", logger.at("warn"))
+	}
+	if _, logger := loggedRequest(t, slow, WithSlowRequestThreshold(0)); len(logger.at("warn")) != 0 {
+		t.Errorf("disabled threshold warned: %+v", logger.at("warn"))
+	}
+}
+
+func TestLoggingMiddlewareSampling(t *testing.T) {
+	const requests = 10000
+	status := http.StatusOK
+	logger := &captureLogger{}
+	handler := Chain(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}, RequestIDMiddleware(), LoggingMiddleware(logger, WithSampleRate(0.1)))
+
+	send := func(requestID string) {
+		req := httptest.NewRequest(http.MethodGet, "/payments/status", nil)
+		req.Header.Set("X-Request-ID", requestID)
+		handler(httptest.NewRecorder(), req)
+	}
+
+	logged := make(map[string]bool)
+	for i := 0; i < requests; i++ {
+		send(fmt.Sprintf("req-%d", i))
+	}
+	for _, entry := range logger.all() {
+		logged[entry.fields["request_id"].(string)] = true
+	}
+
+	// 10% of 10k within four standard deviations (sigma = 30)
+	if n := len(logged); n < 880 || n > 1120 {
+		t.Fatalf("%d of %d successful requests logged, want about 1000", n, requests)
+	}
+
+	// Retries carrying the same ID are sampled alike
+	for i := 0; i < 200; i++ {
+		id := fmt.Sprintf("req-%d", i)
+		before := len(logger.all())
+		send(id)
+		if got := len(logger.all()) > before; got != logged[id] {
+			t.Fatalf("retry of %s logged = %v, first attempt %v", id, got, logged[id])
+		}
+	}
+
+	// Failures are never sampled away
+	status = http.StatusBadRequest
+	before := len(logger.all())
+	for i := 0; i < 1000; i++ {
+		send(fmt.Sprintf("failed-%d", i))
+	}
+	if n := len(logger.all()) - before; n != 1000 {
+		t.Fatalf("%d of 1000 failed requests logged", n)
+	}
+}
+
+func TestSampledRates(t *testing.T) {
+	for _, rate := range []float64{0, 0.01, 0.25, 0.5, 1} {
+		count := 0
+		for i := 0; i < 10000; i++ {
+			if sampled(fmt.Sprintf("%x", i*7919), rate) {
+				count++
+			}
+		}
+		if want := rate * 10000; float64(count) < want-400 || float64(count) > want+400 {
+			t.Errorf("rate %v: %d of 10000 sampled", rate, count)
+		}
+	}
+}
+
+func TestRouteLogLevel(t *testing.T) {
+	client, _, logger := newTestClient(t, testConfig(t), NewSimulatorTransport())
+	handler := client.Handler(WithRouteLogLevel("/payments/status", Debug))
+
+	resp, err := client.InitiatePayment(context.Background(), 100000, "Order 7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routeRequest(handler, http.MethodGet, "/payments/status?token="+resp.Token, "Bearer "+testAPIKey)
+	routeRequest(handler, http.MethodGet, "/payments/status?token=unknown", "Bearer "+testAPIKey)
+
+	levels := make(map[string][]string)
+	for _, entry := range logger.all() {
+		if entry.message == "HTTP Request" {
+			key := fmt.Sprintf("%v %v", entry.fields["route"], entry.fields["status"])
+			levels[key] = append(levels[key], entry.level)
+		}
+	}
+
+	// Successful status polls drop to Debug, failures keep Info
+	if got := levels["/payments/status 200"]; len(got) != 1 || got[0] != "debug" {
+		t.Errorf("successful status levels %v", got)
+	}
+	if got := levels["/payments/status 500"]; len(got) != 1 || got[0] != "info" {
+		t.Errorf("failed status levels %v (all %v)", got, levels)
+	}
+}
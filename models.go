@@ -3,10 +3,95 @@
 package vandargo
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// TransactionStatus represents the lifecycle state of a transaction
+type TransactionStatus string
+
+const (
+	// StatusInit is the state of a transaction after a token has been issued
+	StatusInit TransactionStatus = "INIT"
+
+	// StatusPaid is the state of a verified, successful transaction
+	StatusPaid TransactionStatus = "PAID"
+
+	// StatusFailed is the state of a transaction that failed or was cancelled
+	StatusFailed TransactionStatus = "FAILED"
+
+	// StatusExpired is the state of a transaction whose token expired unused
+	StatusExpired TransactionStatus = "EXPIRED"
+
+	// StatusRefunded is the state of a transaction that was refunded
+	StatusRefunded TransactionStatus = "REFUNDED"
+
+	// StatusVerifyPending is the state of a transaction whose verification had an
+	// unknown outcome, e.g. after a timeout; the money may have moved
+	StatusVerifyPending TransactionStatus = "VERIFY_PENDING"
+
+	// StatusQueued is the state of a payment whose initialization is queued
+	// until the gateway is reachable again; it has no token yet
+	StatusQueued TransactionStatus = "QUEUED"
+
+	// StatusSuspect is the state of a transaction whose description or factor
+	// number echoed by the gateway doesn't match what was sent, e.g. because
+	// tokens were swapped; it waits for a person to review it
+	StatusSuspect TransactionStatus = "SUSPECT"
+)
+
+// transactionStatuses lists every transaction status
+var transactionStatuses = []TransactionStatus{StatusQueued, StatusInit, StatusVerifyPending, StatusPaid, StatusFailed, StatusExpired, StatusRefunded, StatusSuspect}
+
+// IsTerminal reports whether no further state changes are expected
+func (s TransactionStatus) IsTerminal() bool {
+	switch s {
+	case StatusPaid, StatusFailed, StatusExpired, StatusRefunded, StatusSuspect:
+		return true
+	default:
+		return false
+	}
+}
+
+// TransactionType distinguishes the kinds of money movement a transaction records
+type TransactionType string
+
+const (
+	// TransactionTypePayment is an IPG payment made by a payer
+	TransactionTypePayment TransactionType = "payment"
+
+	// TransactionTypeRefund is money returned to a payer
+	TransactionTypeRefund TransactionType = "refund"
+
+	// TransactionTypeSettlement is money moved from the wallet to a bank account
+	TransactionTypeSettlement TransactionType = "settlement"
+
+	// TransactionTypeTransfer is money moved to another business wallet
+	TransactionTypeTransfer TransactionType = "transfer"
+)
+
+// IsValid reports whether the type is one of the known transaction types
+func (t TransactionType) IsValid() bool {
+	switch t {
+	case TransactionTypePayment, TransactionTypeRefund, TransactionTypeSettlement, TransactionTypeTransfer:
+		return true
+	default:
+		return false
+	}
+}
+
+// OrDefault returns the type, or payment for records stored before types existed
+func (t TransactionType) OrDefault() TransactionType {
+	if t == "" {
+		return TransactionTypePayment
+	}
+	return t
+}
+
 // Transaction represents a payment transaction in the system
 type Transaction struct {
 	// ID is the unique identifier for the transaction
@@ -19,11 +104,20 @@ type Transaction struct {
 	Amount int64 `json:"amount"`
 
 	// Status represents the current status of the transaction
-	Status string `json:"status"`
+	Status TransactionStatus `json:"status"`
+
+	// Type is the kind of transaction; empty means payment
+	Type TransactionType `json:"type,omitempty"`
 
 	// Description is a description of what the payment is for
 	Description string `json:"description"`
 
+	// FactorNumber is the merchant invoice/factor number of the payment
+	FactorNumber string `json:"factor_number,omitempty"`
+
+	// CallbackURL is the callback URL the payment was initialized with
+	CallbackURL string `json:"callback_url,omitempty"`
+
 	// Metadata contains additional data about the transaction
 	Metadata map[string]string `json:"metadata,omitempty"`
 
@@ -36,40 +130,124 @@ type Transaction struct {
 	// CardNumber is the masked card number used for payment (last 4 digits)
 	CardNumber string `json:"card_number,omitempty"`
 
-	// CardHash is the hashed card number
+	// CardHash is the keyed hash of the card number the payment was restricted to
 	CardHash string `json:"card_hash,omitempty"`
 
+	// Wage is the fee charged by Vandar in Rials
+	Wage int64 `json:"wage,omitempty"`
+
+	// ShaparakWage is the fee charged by Shaparak in Rials
+	ShaparakWage int64 `json:"shaparak_wage,omitempty"`
+
+	// NetAmount is the amount after deducting all wages
+	NetAmount int64 `json:"net_amount,omitempty"`
+
+	// RefNumber is the bank reference number of the payment
+	RefNumber string `json:"ref_number,omitempty"`
+
+	// TrackingCode is the Shaparak tracking code of the payment
+	TrackingCode string `json:"tracking_code,omitempty"`
+
+	// CallbackCount is the number of gateway callbacks processed for the transaction
+	CallbackCount int `json:"callback_count,omitempty"`
+
+	// ClientIP is the IP address of the request that initiated the payment
+	ClientIP string `json:"client_ip,omitempty"`
+
+	// UserAgent is the User-Agent of the request that initiated the payment
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// StatusHistory records manual status changes
+	StatusHistory []StatusChange `json:"status_history,omitempty"`
+
 	// CreatedAt is when the transaction was created
 	CreatedAt time.Time `json:"created_at"`
 
 	// UpdatedAt is when the transaction was last updated
 	UpdatedAt time.Time `json:"updated_at"`
 
+	// ExpiresAt is when the payment token expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
 	// CompletedAt is when the transaction was completed
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 }
 
+// StatusChange is an audit entry for a manual transaction status change
+type StatusChange struct {
+	// From is the status before the change
+	From TransactionStatus `json:"from"`
+
+	// To is the status after the change
+	To TransactionStatus `json:"to"`
+
+	// Actor identifies who made the change
+	Actor string `json:"actor"`
+
+	// Reason explains why the change was made
+	Reason string `json:"reason"`
+
+	// Forced reports whether the state machine was bypassed
+	Forced bool `json:"forced,omitempty"`
+
+	// CorrelationID is the correlation ID of the request that made the change
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// At is when the change was made
+	At time.Time `json:"at"`
+}
+
 // PaymentInitRequest represents a request to initialize a payment
 type PaymentInitRequest struct {
 	// Amount is the payment amount in Rials
-	Amount int64 `json:"amount"`
+	Amount int64 `json:"amount" validate:"min=MinAmount,max=MaxAmount" unit:"Rials"`
 
 	// CallbackURL is where the user will be redirected after payment
-	CallbackURL string `json:"callback_url"`
+	CallbackURL string `json:"callback_url" validate:"required,http_url" label:"callback URL"`
 
 	// Description is a description of what the payment is for
-	Description string `json:"description,omitempty"`
+	Description string `json:"description,omitempty" validate:"max=MaxDescriptionLength"`
 
 	// Mobile is the customer's mobile number (optional)
-	Mobile string `json:"mobile,omitempty"`
+	Mobile string `json:"mobile,omitempty" validate:"iran_mobile,required_if=RequireCardOwnerMatch"`
 
 	// FactorNumber is an optional invoice/factor number
 	FactorNumber string `json:"factorNumber,omitempty"`
 
 	// ValidCardNumber is an optional allowed card number
-	ValidCardNumber string `json:"valid_card_number,omitempty"`
+	ValidCardNumber string `json:"valid_card_number,omitempty" validate:"card" label:"valid card number"`
+
+	// NationalCode is the customer's national code (optional)
+	NationalCode string `json:"national_code,omitempty" validate:"national_code,required_if=RequireCardOwnerMatch" label:"national code"`
+
+	// RequireCardOwnerMatch restricts the payment to cards registered under the
+	// customer's mobile number and national code, which are then both required
+	RequireCardOwnerMatch bool `json:"require_card_owner_match,omitempty" label:"card owner match"`
+
+	// Metadata is stored with the transaction; DefaultDescriptionTemplate is
+	// rendered against it when Description is empty (optional)
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// ChallengeToken is the CAPTCHA or Turnstile token proving the payer is human;
+	// the ChallengeTokenHeader header may carry it instead (optional)
+	ChallengeToken string `json:"challenge_token,omitempty"`
+
+	// ClientInfo is the device that initiated the payment, stored on the
+	// transaction for fraud analysis. The HTTP handler sets it from the request;
+	// it can't be sent in the body. (optional)
+	ClientInfo *ClientInfo `json:"-"`
 }
 
+const (
+	// CardOwnerMatchMetadataKey is the transaction metadata key recording whether
+	// the paying card belonged to the customer
+	CardOwnerMatchMetadataKey = "card_owner_match"
+
+	// cardOwnerCheckField is the gateway flag restricting a payment to the cards
+	// registered under the sent mobile number and national code
+	cardOwnerCheckField = "check_national_code"
+)
+
 // PaymentInitResponse represents a response to a payment initialization
 type PaymentInitResponse struct {
 	// Status indicates if the request was successful
@@ -78,6 +256,16 @@ type PaymentInitResponse struct {
 	// Token is the payment token
 	Token string `json:"token"`
 
+	// ExpiresIn is the token lifetime in seconds, when the gateway reports it
+	ExpiresIn FlexibleAmount `json:"expires_in,omitempty"`
+
+	// ExpiresAt is when the token expires; set by the client, not the gateway
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// SessionID can be passed as ?session= to the status and verify endpoints
+	// instead of the token; set by the client, not the gateway
+	SessionID string `json:"session_id,omitempty"`
+
 	// Message contains any message from the API
 	Message string `json:"message,omitempty"`
 
@@ -88,7 +276,13 @@ type PaymentInitResponse struct {
 // PaymentVerifyRequest represents a request to verify a payment
 type PaymentVerifyRequest struct {
 	// Token is the payment token received during initialization
-	Token string `json:"token"`
+	Token string `json:"token" validate:"required"`
+
+	// TransactionID names the payment by its stored transaction ID instead (optional)
+	TransactionID string `json:"transaction_id,omitempty"`
+
+	// FactorNumber names the most recent payment with the factor number instead (optional)
+	FactorNumber string `json:"factor_number,omitempty"`
 }
 
 // PaymentVerifyResponse represents a response to a payment verification
@@ -123,17 +317,49 @@ type PaymentVerifyResponse struct {
 	// CID is the SHA256 hash of the card number
 	CID string `json:"cid,omitempty"`
 
+	// CardOwnerMatch reports whether the card belongs to the customer, when the
+	// payment was restricted to the customer's cards
+	CardOwnerMatch *bool `json:"cardOwnerMatch,omitempty"`
+
 	// Message contains the transaction status
 	Message string `json:"message,omitempty"`
 
 	// Errors contains any error messages
 	Errors map[string]string `json:"errors,omitempty"`
+
+	// AlreadyVerified reports that the gateway had verified the payment before, so
+	// the result was built from its transaction info
+	AlreadyVerified bool `json:"alreadyVerified,omitempty"`
+
+	// Warnings lists problems found in the gateway's answer, such as an echoed
+	// description that doesn't match the one sent
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// VerifyResult is a verification result optionally enriched with transaction info
+type VerifyResult struct {
+	PaymentVerifyResponse
+
+	// TrackingCode is the Shaparak tracking code
+	TrackingCode string `json:"trackingCode,omitempty"`
+
+	// RefNumber is the bank reference number
+	RefNumber string `json:"refnumber,omitempty"`
+
+	// Wage is the fee charged by Vandar in Rials
+	Wage int64 `json:"wage,omitempty"`
+
+	// ShaparakWage is the fee charged by Shaparak in Rials
+	ShaparakWage int64 `json:"shaparakWage,omitempty"`
+
+	// Enriched reports whether transaction info was merged into the result
+	Enriched bool `json:"enriched,omitempty"`
 }
 
 // PaymentStatusRequest represents a request to check payment status
 type PaymentStatusRequest struct {
 	// Token is the payment token
-	Token string `json:"token"`
+	Token string `json:"token" validate:"required"`
 }
 
 // PaymentStatusResponse represents a response to a payment status check
@@ -150,6 +376,12 @@ type PaymentStatusResponse struct {
 	// RefID is the payment reference ID
 	RefID string `json:"refId,omitempty"`
 
+	// Token is the gateway token a queued initialization was resolved to
+	Token string `json:"token,omitempty"`
+
+	// PaymentURL is the payment page of a resolved queued initialization
+	PaymentURL string `json:"paymentUrl,omitempty"`
+
 	// Message contains any message from the API
 	Message string `json:"message,omitempty"`
 
@@ -159,11 +391,17 @@ type PaymentStatusResponse struct {
 
 // RefundRequest represents a request to refund a payment
 type RefundRequest struct {
-	// TransactionID is the ID of the transaction to refund
-	TransactionID string `json:"transaction_id"`
+	// TransactionID is the gateway ID of the transaction to refund
+	TransactionID string `json:"transaction_id" validate:"required" label:"transaction ID"`
+
+	// Token names the payment to refund by its token instead (optional)
+	Token string `json:"token,omitempty"`
+
+	// FactorNumber names the payment to refund by its factor number instead (optional)
+	FactorNumber string `json:"factor_number,omitempty"`
 
 	// Amount is the amount to refund (optional, defaults to full amount)
-	Amount int64 `json:"amount,omitempty"`
+	Amount int64 `json:"amount,omitempty" validate:"nonnegative"`
 }
 
 // RefundResponse represents a response to a refund request
@@ -187,7 +425,7 @@ type RefundResponse struct {
 // CallbackData represents the data received in a payment callback
 type CallbackData struct {
 	// Token is the payment token
-	Token string `json:"token"`
+	Token string `json:"token" validate:"required"`
 
 	// Status indicates the status of the payment
 	Status string `json:"status"`
@@ -212,20 +450,78 @@ func (e *APIError) Error() string {
 
 // TransactionInfoResponse represents the response from the transaction information endpoint
 type TransactionInfoResponse struct {
-	Status       int    `json:"status"`
-	Amount       string `json:"amount"`
-	Wage         string `json:"wage"`
-	ShaparakWage string `json:"shaparakWage"`
-	TransID      int64  `json:"transId"`
-	RefNumber    string `json:"refnumber"`
-	TrackingCode string `json:"trackingCode"`
-	FactorNumber string `json:"factorNumber"`
-	Mobile       string `json:"mobile"`
-	Description  string `json:"description"`
-	CardNumber   string `json:"cardNumber"`
-	CID          string `json:"CID"`
-	CreatedAt    string `json:"createdAt"`
-	PaymentDate  string `json:"paymentDate"`
-	Code         int    `json:"code"`
-	Message      string `json:"message"`
+	Status       int            `json:"status"`
+	Amount       FlexibleAmount `json:"amount"`
+	Wage         FlexibleAmount `json:"wage"`
+	ShaparakWage FlexibleAmount `json:"shaparakWage"`
+	TransID      int64          `json:"transId"`
+	RefNumber    string         `json:"refnumber"`
+	TrackingCode string         `json:"trackingCode"`
+	FactorNumber string         `json:"factorNumber"`
+	Mobile       string         `json:"mobile"`
+	Description  string         `json:"description"`
+	CardNumber   string         `json:"cardNumber"`
+	CID          string         `json:"CID"`
+	CreatedAt    string         `json:"createdAt"`
+	PaymentDate  string         `json:"paymentDate"`
+	Code         int            `json:"code"`
+	Message      string         `json:"message"`
+
+	// Warnings lists problems found in the answer, such as an echoed
+	// description that doesn't match the one sent
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Net returns the amount after deducting the Vandar and Shaparak wages
+func (r *TransactionInfoResponse) Net() int64 {
+	return r.Amount.Int64() - r.Wage.Int64() - r.ShaparakWage.Int64()
+}
+
+// FlexibleAmount is an amount in Rials that the gateway may encode either as a
+// JSON number or as a string; missing, null and empty values decode as zero
+type FlexibleAmount int64
+
+// UnmarshalJSON decodes a number, a numeric string or null
+func (a *FlexibleAmount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		*a = 0
+		return nil
+	}
+
+	// Unwrap quoted amounts and drop thousands separators
+	raw := string(data)
+	if data[0] == '"' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("invalid amount %s: %w", data, err)
+		}
+		raw = strings.ReplaceAll(strings.TrimSpace(raw), ",", "")
+		if raw == "" {
+			*a = 0
+			return nil
+		}
+	}
+
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		// Accept integral values written in float notation, e.g. 1000.0
+		floatValue, floatErr := strconv.ParseFloat(raw, 64)
+		if floatErr != nil || floatValue != float64(int64(floatValue)) {
+			return fmt.Errorf("invalid amount %s", data)
+		}
+		value = int64(floatValue)
+	}
+
+	*a = FlexibleAmount(value)
+	return nil
+}
+
+// Int64 returns the amount as an int64
+func (a FlexibleAmount) Int64() int64 {
+	return int64(a)
+}
+
+// String returns the amount as a decimal string
+func (a FlexibleAmount) String() string {
+	return strconv.FormatInt(int64(a), 10)
 }
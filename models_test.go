@@ -0,0 +1,46 @@
+package vandargo
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTransactionInfoWages(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wage         int64
+		shaparakWage int64
+		net          int64
+	}{
+		{"numbers", `{"amount":100000,"wage":1000,"shaparakWage":120}`, 1000, 120, 98880},
+		{"strings", `{"amount":"100000","wage":"1000","shaparakWage":"120"}`, 1000, 120, 98880},
+		{"thousands separators", `{"amount":"100,000","wage":"1,000","shaparakWage":"120"}`, 1000, 120, 98880},
+		{"float notation", `{"amount":100000.0,"wage":"1000.0","shaparakWage":120.0}`, 1000, 120, 98880},
+		{"missing wages", `{"amount":"100000"}`, 0, 0, 100000},
+		{"null wages", `{"amount":"100000","wage":null,"shaparakWage":null}`, 0, 0, 100000},
+		{"zero strings", `{"amount":"100000","wage":"0","shaparakWage":"0"}`, 0, 0, 100000},
+		{"empty strings", `{"amount":"100000","wage":"","shaparakWage":" "}`, 0, 0, 100000},
+		{"one wage", `{"amount":"100000","wage":"0","shaparakWage":"350"}`, 0, 350, 99650},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info TransactionInfoResponse
+			if err := json.Unmarshal([]byte(tt.body), &info); err != nil {
+				t.Fatal(err)
+			}
+			if info.Wage.Int64() != tt.wage || info.ShaparakWage.Int64() != tt.shaparakWage || info.Net() != tt.net {
+				t.Fatalf("wage %d, shaparak wage %d, net %d; want %d, %d, %d",
+					info.Wage, info.ShaparakWage, info.Net(), tt.wage, tt.shaparakWage, tt.net)
+			}
+		})
+	}
+
+	for _, body := range []string{`{"wage":"free"}`, `{"wage":"10.5"}`, `{"wage":true}`} {
+		var info TransactionInfoResponse
+		if err := json.Unmarshal([]byte(body), &info); err == nil {
+			t.Errorf("%s decoded without an error", body)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// mtls.go implements client certificate verification for gateway-facing routes
+package vandargo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+)
+
+// MTLSConfig configures the client certificates accepted by MTLSMiddleware
+type MTLSConfig struct {
+	// ClientCAs verifies the client certificate chain. When nil, the chains
+	// verified during the TLS handshake are required instead, which needs a
+	// server with ClientAuth set to verify certificates.
+	ClientCAs *x509.CertPool
+
+	// AllowedSubjects lists accepted certificate subjects, matched against the
+	// common name or the full distinguished name (optional)
+	AllowedSubjects []string
+
+	// AllowedSANs lists accepted subject alternative names: DNS names, email
+	// addresses, IP addresses or URIs (optional)
+	AllowedSANs []string
+
+	// RequireTLS rejects requests not received over TLS; otherwise they pass
+	// unchecked, e.g. behind a proxy terminating TLS
+	RequireTLS bool
+
+	// Clock is the source of time for certificate validity (RealClock when nil)
+	Clock Clock
+}
+
+// MTLSMiddleware accepts only requests presenting a client certificate that chains
+// to the configured CAs and, when allow lists are set, matches one of their
+// subjects or SANs. Other requests are rejected with 403.
+func MTLSMiddleware(config MTLSConfig) Middleware {
+	clock := config.Clock
+	if clock == nil {
+		clock = RealClock()
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			reject := func(reason, message string) {
+				LoggerFromContext(r.Context()).Warn(r.Context(), "Rejected client certificate", map[string]interface{}{
+					"reason":    reason,
+					"remote_ip": getClientIP(r),
+				})
+				writeJSONError(w, r, http.StatusForbidden, ErrPermission, message)
+			}
+
+			if r.TLS == nil {
+				if config.RequireTLS {
+					reject("not a TLS connection", "TLS is required")
+					return
+				}
+				next(w, r)
+				return
+			}
+
+			if len(r.TLS.PeerCertificates) == 0 {
+				reject("no client certificate", "Client certificate required")
+				return
+			}
+			leaf := r.TLS.PeerCertificates[0]
+
+			if err := verifyClientCertificate(config, r.TLS, clock); err != nil {
+				reject(err.Error(), "Client certificate not trusted")
+				return
+			}
+
+			if !clientCertificateAllowed(config, leaf) {
+				reject("subject not allowed: "+leaf.Subject.String(), "Client certificate not allowed")
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// verifyClientCertificate verifies the peer's chain against the configured CAs or
+// requires the chains verified during the handshake
+func verifyClientCertificate(config MTLSConfig, state *tls.ConnectionState, clock Clock) error {
+	if config.ClientCAs == nil {
+		if len(state.VerifiedChains) == 0 {
+			return errMTLSUnverified
+		}
+		return nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range state.PeerCertificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         config.ClientCAs,
+		Intermediates: intermediates,
+		CurrentTime:   clock.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// errMTLSUnverified is reported when no CA pool is configured and the handshake
+// verified no chain
+var errMTLSUnverified = errors.New("client certificate chain was not verified")
+
+// clientCertificateAllowed matches a certificate against the allow lists; any
+// certificate is allowed when both are empty
+func clientCertificateAllowed(config MTLSConfig, leaf *x509.Certificate) bool {
+	if len(config.AllowedSubjects) == 0 && len(config.AllowedSANs) == 0 {
+		return true
+	}
+
+	for _, subject := range config.AllowedSubjects {
+		if subject == leaf.Subject.CommonName || subject == leaf.Subject.String() {
+			return true
+		}
+	}
+
+	var names []string
+	names = append(names, leaf.DNSNames...)
+	names = append(names, leaf.EmailAddresses...)
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range leaf.URIs {
+		names = append(names, uri.String())
+	}
+
+	for _, allowed := range config.AllowedSANs {
+		for _, name := range names {
+			if allowed == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,230 @@
+package vandargo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA issues client certificates for mTLS tests
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pool        *x509.CertPool
+	serial      int64
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return &testCA{certificate: certificate, key: key, pool: pool, serial: 1}
+}
+
+// issue returns a client certificate with a common name and DNS names, adjusted by mutate
+func (ca *testCA) issue(t *testing.T, commonName string, dnsNames []string, mutate ...func(*x509.Certificate)) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Vandar"}},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, m := range mutate {
+		m(template)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newMTLSServer serves a handler behind MTLSMiddleware over TLS, asking clients
+// for certificates without verifying them in the handshake
+func newMTLSServer(t *testing.T, config MTLSConfig) (*httptest.Server, *x509.CertPool) {
+	t.Helper()
+
+	serverCertificate, serverPool := newTestCertificate(t)
+	handler := MTLSMiddleware(config)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		ClientAuth:   tls.RequestClientCert,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, serverPool
+}
+
+// mtlsRequest calls a TLS server presenting the given client certificates
+func mtlsRequest(t *testing.T, url string, roots *x509.CertPool, certificates ...tls.Certificate) int {
+	t.Helper()
+
+	transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates}}
+	defer transport.CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: transport}).Post(url, "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestMTLSMiddleware(t *testing.T) {
+	ca := newTestCA(t, "Vandar callbacks CA")
+	other := newTestCA(t, "Someone else's CA")
+
+	tests := []struct {
+		name        string
+		config      MTLSConfig
+		certificate *tls.Certificate
+		status      int
+	}{
+		{"trusted", MTLSConfig{}, ptr(ca.issue(t, "vandar-callbacks", nil)), http.StatusNoContent},
+		{"allowed subject", MTLSConfig{AllowedSubjects: []string{"vandar-callbacks"}}, ptr(ca.issue(t, "vandar-callbacks", nil)), http.StatusNoContent},
+		{"allowed distinguished name", MTLSConfig{AllowedSubjects: []string{"CN=vandar-callbacks,O=Vandar"}}, ptr(ca.issue(t, "vandar-callbacks", nil)), http.StatusNoContent},
+		{"allowed SAN", MTLSConfig{AllowedSANs: []string{"callbacks.vandar.io"}}, ptr(ca.issue(t, "edge-7", []string{"callbacks.vandar.io"})), http.StatusNoContent},
+		{"subject not allowed", MTLSConfig{AllowedSubjects: []string{"vandar-callbacks"}}, ptr(ca.issue(t, "vandar-reports", nil)), http.StatusForbidden},
+		{"SAN not allowed", MTLSConfig{AllowedSANs: []string{"callbacks.vandar.io"}}, ptr(ca.issue(t, "edge-7", []string{"reports.vandar.io"})), http.StatusForbidden},
+		{"untrusted CA", MTLSConfig{}, ptr(other.issue(t, "vandar-callbacks", nil)), http.StatusForbidden},
+		{"server certificate", MTLSConfig{}, ptr(ca.issue(t, "vandar-callbacks", nil, func(c *x509.Certificate) {
+			c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		})), http.StatusForbidden},
+		{"expired", MTLSConfig{}, ptr(ca.issue(t, "vandar-callbacks", nil, func(c *x509.Certificate) {
+			c.NotBefore, c.NotAfter = time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour)
+		})), http.StatusForbidden},
+		{"no certificate", MTLSConfig{}, nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.ClientCAs = ca.pool
+			server, roots := newMTLSServer(t, tt.config)
+
+			var certificates []tls.Certificate
+			if tt.certificate != nil {
+				certificates = append(certificates, *tt.certificate)
+			}
+			if status := mtlsRequest(t, server.URL, roots, certificates...); status != tt.status {
+				t.Fatalf("status %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	// Validity is checked against the configured clock
+	certificate := ca.issue(t, "vandar-callbacks", nil)
+	server, roots := newMTLSServer(t, MTLSConfig{ClientCAs: ca.pool, Clock: NewFakeClock(time.Now().Add(2 * time.Hour))})
+	if status := mtlsRequest(t, server.URL, roots, certificate); status != http.StatusForbidden {
+		t.Fatalf("certificate expired on the configured clock: status %d", status)
+	}
+}
+
+// ptr returns a pointer to a copy of v
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestMTLSMiddlewareWithoutTLS(t *testing.T) {
+	for _, requireTLS := range []bool{false, true} {
+		handler := MTLSMiddleware(MTLSConfig{ClientCAs: x509.NewCertPool(), RequireTLS: requireTLS})(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/payments/callback", nil))
+
+		want := http.StatusNoContent
+		if requireTLS {
+			want = http.StatusForbidden
+		}
+		if rec.Code != want {
+			t.Errorf("RequireTLS %v: status %d, want %d", requireTLS, rec.Code, want)
+		}
+	}
+}
+
+func TestServeGatewayMTLS(t *testing.T) {
+	ca := newTestCA(t, "Vandar callbacks CA")
+	serverCertificate, roots := newTestCertificate(t)
+	client, storage, _ := newTestClient(t, testConfig(t), slowGateway(0, nil))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	// The handshake verifies certificates when given; only gateway routes require one
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, result := startServe(t, client, ctx,
+		WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCertificate}}),
+		WithClientAuth(ca.pool, tls.VerifyClientCertIfGiven),
+		WithServeRouteOptions(WithGatewayMTLS(MTLSConfig{RequireTLS: true})),
+	)
+	url := "https://" + addr
+
+	httpsClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	defer httpsClient.CloseIdleConnections()
+	var status int
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if status, err = statusRequest(httpsClient, url); err == nil {
+			break
+		}
+	}
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("status without a client certificate: %d, %v", status, err)
+	}
+
+	if status := mtlsRequest(t, url+"/payments/callback", roots); status != http.StatusForbidden {
+		t.Fatalf("callback without a client certificate: status %d", status)
+	}
+	if status := mtlsRequest(t, url+"/payments/callback", roots, ca.issue(t, "vandar-callbacks", nil)); status == http.StatusForbidden {
+		t.Fatal("callback with a trusted client certificate was rejected")
+	}
+
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// mux.go implements a built-in method-aware router for mounting the package directly
+package vandargo
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// methodMux is a minimal router implementing RouterInterface; paths match exactly
+// or by pattern, with {name} segments matching any single path segment
+type methodMux struct {
+	mutex  sync.RWMutex
+	routes map[string]map[string]http.HandlerFunc
+
+	// encoder shapes the 404 and 405 error responses
+	encoder ResponseEncoder
+
+	// described documents the routes in the 404 and 405 error responses
+	described []RouteDescriptor
+
+	// listRoutes lists the described routes in 404 responses
+	listRoutes bool
+
+	// openAPIURL is the path of the served OpenAPI document, empty when not served
+	openAPIURL string
+}
+
+// newMethodMux creates an empty router
+func newMethodMux(encoder ResponseEncoder) *methodMux {
+	return &methodMux{
+		routes:  make(map[string]map[string]http.HandlerFunc),
+		encoder: encoder,
+	}
+}
+
+// POST registers a POST route with a handler
+func (m *methodMux) POST(path string, handler http.HandlerFunc) {
+	m.handle(http.MethodPost, path, handler)
+}
+
+// GET registers a GET route with a handler
+func (m *methodMux) GET(path string, handler http.HandlerFunc) {
+	m.handle(http.MethodGet, path, handler)
+}
+
+// OPTIONS registers an OPTIONS route with a handler
+func (m *methodMux) OPTIONS(path string, handler http.HandlerFunc) {
+	m.handle(http.MethodOptions, path, handler)
+}
+
+// handle registers a handler for a method and path
+func (m *methodMux) handle(method, path string, handler http.HandlerFunc) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.routes[path] == nil {
+		m.routes[path] = make(map[string]http.HandlerFunc)
+	}
+	m.routes[path][method] = handler
+}
+
+// describe documents the registered routes for the 404 and 405 error responses
+func (m *methodMux) describe(routes []RouteDescriptor, openAPIURL string, listRoutes bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.described = routes
+	m.openAPIURL = openAPIURL
+	m.listRoutes = listRoutes
+}
+
+// ServeHTTP dispatches a request by path and method
+func (m *methodMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.RLock()
+	pattern := r.URL.Path
+	methods, pathExists := m.routes[pattern]
+	if !pathExists {
+		pattern, methods, pathExists = m.matchLocked(r.URL.Path)
+	}
+	handler := methods[r.Method]
+	m.mutex.RUnlock()
+
+	if handler != nil {
+		handler(w, r)
+		return
+	}
+
+	// Error responses use the same JSON envelope as the handlers
+	respond := Chain(func(w http.ResponseWriter, r *http.Request) {
+		if !pathExists {
+			m.notFound(w, r)
+			return
+		}
+		m.methodNotAllowed(w, r, pattern, methods)
+	}, ResponseEncoderMiddleware(m.encoder), SecurityHeadersMiddleware())
+
+	respond(w, r)
+}
+
+// notFound answers an unknown path, listing the endpoints when enabled
+func (m *methodMux) notFound(w http.ResponseWriter, r *http.Request) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if !m.listRoutes {
+		writeJSONError(w, r, http.StatusNotFound, ErrNotFound, "Route not found")
+		return
+	}
+
+	routes := make([]map[string]interface{}, 0, len(m.described))
+	for _, descriptor := range m.described {
+		routes = append(routes, map[string]interface{}{
+			"method":      descriptor.Method,
+			"path":        descriptor.Path,
+			"description": descriptor.Description,
+		})
+	}
+
+	details := map[string]interface{}{"routes": routes}
+	if m.openAPIURL != "" {
+		details["openapi_url"] = m.openAPIURL
+	}
+	writeJSONErrorWithDetails(w, r, http.StatusNotFound, ErrNotFound, "Route not found, see routes for the available endpoints", details)
+}
+
+// methodNotAllowed answers a known path requested with another method, pointing
+// at the method to use with an example body and the route's OpenAPI path
+func (m *methodMux) methodNotAllowed(w http.ResponseWriter, r *http.Request, pattern string, methods map[string]http.HandlerFunc) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	allowed := methodList(methods)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+
+	details := map[string]interface{}{
+		"allowed_methods": allowed,
+		"openapi_path":    pattern,
+	}
+	if m.openAPIURL != "" {
+		details["openapi_url"] = m.openAPIURL
+	}
+
+	message := "Method not allowed"
+	for _, descriptor := range m.described {
+		if descriptor.Path != pattern {
+			continue
+		}
+
+		message = "Method " + r.Method + " not allowed, use " + descriptor.Method + " " + descriptor.Path
+		route := map[string]interface{}{
+			"method":      descriptor.Method,
+			"path":        descriptor.Path,
+			"description": descriptor.Description,
+		}
+		if len(descriptor.Query) > 0 {
+			route["query"] = descriptor.Query
+		}
+		if descriptor.Example != nil {
+			route["example"] = descriptor.Example
+		}
+		details["route"] = route
+		break
+	}
+
+	writeJSONErrorWithDetails(w, r, http.StatusMethodNotAllowed, ErrInvalidRequest, message, details)
+}
+
+// matchLocked returns the pattern and handlers of the first pattern route matching a path
+func (m *methodMux) matchLocked(path string) (string, map[string]http.HandlerFunc, bool) {
+	for pattern, methods := range m.routes {
+		if !strings.Contains(pattern, "{") {
+			continue
+		}
+		if _, ok := matchPattern(pattern, path); ok {
+			return pattern, methods, true
+		}
+	}
+	return path, nil, false
+}
+
+// methodList returns the sorted methods of a route for the Allow header
+func methodList(methods map[string]http.HandlerFunc) []string {
+	allowed := make([]string, 0, len(methods))
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+// Handler returns an http.Handler serving the payment routes with the same
+// middleware chains as RegisterRoutes, for use without an external router:
+//
+//	http.ListenAndServe(":8080", client.Handler())
+//
+// A known path requested with the wrong method gets a 405 naming the method to
+// use with an example body; unknown paths get a 404 listing the endpoints when
+// Config.ListRoutesOnNotFound is set.
+func (c *Client) Handler(opts ...RouteOption) http.Handler {
+	mux := newMethodMux(c.responseEncoder)
+	c.RegisterRoutes(mux, opts...)
+
+	var openAPIURL string
+	if newRouteOptions(opts).openAPI {
+		openAPIURL = openAPIPath
+	}
+	mux.describe(c.Routes(opts...), openAPIURL, configValues(c.config).ListRoutesOnNotFound)
+	return mux
+}
+
+// ServeMuxRouter adapts an http.ServeMux to RouterInterface, registering routes
+// with method patterns such as "POST /payments/init"
+type ServeMuxRouter struct {
+	mux *http.ServeMux
+}
+
+// NewServeMuxRouter creates a router registering routes on mux:
+//
+//	mux := http.NewServeMux()
+//	client.RegisterRoutes(vandargo.NewServeMuxRouter(mux))
+func NewServeMuxRouter(mux *http.ServeMux) *ServeMuxRouter {
+	return &ServeMuxRouter{mux: mux}
+}
+
+// POST registers a POST route with a handler
+func (s *ServeMuxRouter) POST(path string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(http.MethodPost+" "+path, handler)
+}
+
+// GET registers a GET route with a handler
+func (s *ServeMuxRouter) GET(path string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(http.MethodGet+" "+path, handler)
+}
+
+// OPTIONS registers an OPTIONS route with a handler
+func (s *ServeMuxRouter) OPTIONS(path string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(http.MethodOptions+" "+path, handler)
+}
@@ -0,0 +1,180 @@
+package vandargo
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// serverClient sends requests to an httptest server with the API key
+type serverClient struct {
+	t      *testing.T
+	server *httptest.Server
+}
+
+// do sends a request and decodes the JSON response body into a map
+func (c serverClient) do(method, path, contentType, body string) (*http.Response, map[string]interface{}) {
+	c.t.Helper()
+
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+
+	resp, err := c.server.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	var decoded map[string]interface{}
+	if len(data) > 0 && json.Unmarshal(data, &decoded) != nil {
+		c.t.Fatalf("%s %s: status %d, body is not JSON: %.200s", method, path, resp.StatusCode, data)
+	}
+	return resp, decoded
+}
+
+func TestHandlerEndToEnd(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(WithSimulatorPaidAfter(0)))
+	server := httptest.NewServer(client.Handler())
+	defer server.Close()
+	api := serverClient{t, server}
+
+	resp, body := api.do(http.MethodPost, "/payments/init", "application/json",
+		`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+	token, _ := body["token"].(string)
+	if resp.StatusCode != http.StatusOK || token == "" {
+		t.Fatalf("init: status %d: %v", resp.StatusCode, body)
+	}
+
+	// The payer's browser returns through the callback, without credentials
+	callback, err := server.Client().PostForm(server.URL+"/payments/callback", url.Values{"token": {token}, "status": {"OK"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback.Body.Close()
+	if callback.StatusCode != http.StatusOK {
+		t.Fatalf("callback: status %d", callback.StatusCode)
+	}
+
+	if resp, body := api.do(http.MethodPost, "/payments/verify", "application/json", `{"token":"`+token+`"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("verify: status %d: %v", resp.StatusCode, body)
+	}
+
+	resp, body = api.do(http.MethodGet, "/payments/status?token="+url.QueryEscape(token)+"&format=normalized", "", "")
+	if resp.StatusCode != http.StatusOK || body["state"] != string(StatusPaid) {
+		t.Fatalf("status: %d: %v", resp.StatusCode, body)
+	}
+
+	// Middleware ran: security headers and a request ID on every response
+	if resp.Header.Get("X-Content-Type-Options") != "nosniff" || resp.Header.Get("X-Request-ID") == "" {
+		t.Fatalf("response headers %v", resp.Header)
+	}
+}
+
+func TestHandlerRoutingErrors(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), newStubTransport())
+	server := httptest.NewServer(client.Handler())
+	defer server.Close()
+	api := serverClient{t, server}
+
+	// A known path with the wrong method gets a 405 envelope naming the method
+	resp, body := api.do(http.MethodGet, "/payments/verify", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+		t.Fatalf("GET verify: status %d, Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	if body["status"] != false || !strings.Contains(body["message"].(string), "use POST /payments/verify") {
+		t.Fatalf("405 envelope %v", body)
+	}
+
+	// Pattern routes are matched by their path parameters too
+	resp, _ = api.do(http.MethodPost, "/payments/transactions/"+webhookToken, "application/json", `{}`)
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodGet {
+		t.Fatalf("POST transaction: status %d, Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+
+	for _, path := range []string{"/payments/unknown", "/payments/transactions/a/b/c/d", "/"} {
+		resp, body := api.do(http.MethodGet, path, "", "")
+		if resp.StatusCode != http.StatusNotFound || body["status"] != false || resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("GET %s: status %d: %v", path, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestHandlerMethodNotAllowedDetails(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), newStubTransport())
+	server := httptest.NewServer(client.Handler(WithOpenAPIRoute()))
+	defer server.Close()
+	api := serverClient{t, server}
+
+	// POSTing to the status route points at GET with its query parameters
+	resp, body := api.do(http.MethodPost, "/payments/status", "application/json", `{"token":"tok"}`)
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodGet {
+		t.Fatalf("POST status: status %d, Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	route, _ := body["route"].(map[string]interface{})
+	if route["method"] != http.MethodGet || route["example"] != nil || !strings.Contains(body["message"].(string), "use GET /payments/status") {
+		t.Fatalf("405 body %v", body)
+	}
+	if query, _ := route["query"].([]interface{}); len(query) == 0 || query[0] != "token" {
+		t.Fatalf("query parameters %v", route["query"])
+	}
+	if body["openapi_path"] != "/payments/status" || body["openapi_url"] != openAPIPath {
+		t.Fatalf("OpenAPI pointers %v, %v", body["openapi_path"], body["openapi_url"])
+	}
+
+	// GETting the verify route gets an example body to send
+	_, body = api.do(http.MethodGet, "/payments/verify", "", "")
+	route, _ = body["route"].(map[string]interface{})
+	example, _ := route["example"].(map[string]interface{})
+	if example["token"] == nil {
+		t.Fatalf("verify route %v", route)
+	}
+	if allowed, _ := body["allowed_methods"].([]interface{}); len(allowed) != 1 || allowed[0] != http.MethodPost {
+		t.Fatalf("allowed methods %v", body["allowed_methods"])
+	}
+}
+
+func TestHandlerNotFoundListing(t *testing.T) {
+	for _, list := range []bool{false, true} {
+		client, _, _ := newTestClient(t, testConfig(t, func(c *Config) { c.ListRoutesOnNotFound = list }), newStubTransport())
+		server := httptest.NewServer(client.Handler())
+		api := serverClient{t, server}
+
+		resp, body := api.do(http.MethodGet, "/payments/verfiy", "", "")
+		server.Close()
+		if resp.StatusCode != http.StatusNotFound || body["status"] != false {
+			t.Fatalf("list %v: status %d: %v", list, resp.StatusCode, body)
+		}
+
+		routes, _ := body["routes"].([]interface{})
+		if !list {
+			// Production keeps the 404 terse
+			if routes != nil || body["message"] != "Route not found" {
+				t.Fatalf("terse 404 %v", body)
+			}
+			continue
+		}
+		if len(routes) != len(client.Routes()) {
+			t.Fatalf("%d routes listed, want %d", len(routes), len(client.Routes()))
+		}
+		listed := false
+		for _, route := range routes {
+			fields := route.(map[string]interface{})
+			listed = listed || (fields["path"] == "/payments/verify" && fields["method"] == http.MethodPost)
+		}
+		if !listed || body["openapi_url"] != nil {
+			t.Fatalf("listing %v", body)
+		}
+	}
+}
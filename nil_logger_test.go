@@ -0,0 +1,91 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveWithoutPanic serves a request, reporting a panic as an error
+func serveWithoutPanic(handler http.Handler, req *http.Request) (rec *httptest.ResponseRecorder, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, nil
+}
+
+func TestNilLoggerNeverPanics(t *testing.T) {
+	failures := []stubStep{
+		{err: errors.New("connection reset by peer")},
+		{status: http.StatusInternalServerError, body: `{"message":"internal error"}`},
+		{status: http.StatusOK, body: `not json`},
+	}
+
+	for _, failure := range failures {
+		t.Run(fmt.Sprintf("gateway %d %v", failure.status, failure.err), func(t *testing.T) {
+			storage := NewMemoryStorage()
+			client, err := NewClient(testConfig(t, func(c *Config) {
+				c.AdminKey = "admin-key"
+				c.WebhookSecret = "webhook-secret"
+			}), storage, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client = client.Clone(WithClientHTTPClient(newStubTransport(failure)))
+			storeWebhookPayment(t, storage, StatusInit)
+
+			// A client whose logger field was left unset behaves the same
+			unset := client.Clone()
+			unset.logger = nil
+
+			for _, c := range []*Client{client, unset} {
+				handler := c.Handler(allRouteOptions()...)
+				for _, rt := range c.Routes(allRouteOptions()...) {
+					for _, body := range []string{`{}`, `{"token":"` + webhookToken + `","amount":100000}`, `{`} {
+						req := httptest.NewRequest(rt.Method, strings.ReplaceAll(rt.Path, "{id}", webhookToken)+"?token="+webhookToken, strings.NewReader(body))
+						req.Header.Set("Content-Type", "application/json")
+						req.Header.Set("Authorization", "Bearer "+testAPIKey)
+						req.Header.Set(AdminKeyHeader, "admin-key")
+						if _, err := serveWithoutPanic(handler, req); err != nil {
+							t.Fatalf("%s %s with %s: %v", rt.Method, rt.Path, body, err)
+						}
+					}
+				}
+
+				// Client calls that log their failures
+				ctx := context.Background()
+				c.VerifyPayment(ctx, webhookToken)
+				c.GetPaymentStatus(ctx, webhookToken)
+				c.InitiatePayment(ctx, 100000, "Order 1042", nil)
+				c.ExpireTransactions(ctx)
+			}
+		})
+	}
+}
+
+func TestLoggingMiddlewareNilLogger(t *testing.T) {
+	handler := LoggingMiddleware(nil)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if rec, err := serveWithoutPanic(handler, httptest.NewRequest(http.MethodGet, "/payments/status", nil)); err != nil || rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, %v", rec.Code, err)
+	}
+
+	// Entries of a nil logger, plain or with fields, are dropped
+	for _, logger := range []LoggerInterface{loggerOrDiscard(nil), WithFields(nil, map[string]interface{}{"job": "reconcile"})} {
+		ctx := context.Background()
+		logger.Debug(ctx, "debug", nil)
+		logger.Info(ctx, "info", nil)
+		logger.Warn(ctx, "warn", nil)
+		logger.Error(ctx, "error", errors.New("failed"), nil)
+	}
+}
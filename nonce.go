@@ -0,0 +1,45 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// nonce.go implements remembering used signature nonces so signed requests can't be replayed
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// NonceHeader carries the nonce of a signed request
+	NonceHeader = "X-Nonce"
+
+	// defaultNonceTTL covers the 5 minutes a signature timestamp may be off either way
+	defaultNonceTTL = 10 * time.Minute
+
+	// maxNonceLength bounds the nonces clients may send
+	maxNonceLength = 128
+)
+
+// NonceStore remembers used nonces in a KVStore, so a signed request is
+// accepted once, also across instances sharing the store
+type NonceStore struct {
+	kv  KVStore
+	ttl time.Duration
+}
+
+// NewNonceStore creates a store remembering nonces for ttl, 10 minutes when zero
+// or less; the ttl must cover how long a signature stays valid
+func NewNonceStore(kv KVStore, ttl time.Duration) *NonceStore {
+	if ttl <= 0 {
+		ttl = defaultNonceTTL
+	}
+	return &NonceStore{kv: kv, ttl: ttl}
+}
+
+// Use records a nonce and reports whether it wasn't used before
+func (s *NonceStore) Use(ctx context.Context, nonce string) (bool, error) {
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return false, errors.New("nonce must be 1 to 128 characters")
+	}
+
+	return s.kv.SetNX(ctx, "vandar:nonce:"+nonce, []byte("1"), s.ttl)
+}
@@ -0,0 +1,470 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// openapi.go generates an OpenAPI 3 document for the payment endpoints
+package vandargo
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	// openAPIPath is the path of the optional OpenAPI document route
+	openAPIPath = "/payments/openapi.json"
+
+	// openAPIVersion is the version of the OpenAPI specification emitted
+	openAPIVersion = "3.0.3"
+
+	// apiDocumentVersion is the version of the documented payment API
+	apiDocumentVersion = "1.0.0"
+)
+
+// Types with a fixed schema instead of a reflected one
+var (
+	timeType           = reflect.TypeOf(time.Time{})
+	rawMessageType     = reflect.TypeOf(json.RawMessage{})
+	flexibleAmountType = reflect.TypeOf(FlexibleAmount(0))
+	statusType         = reflect.TypeOf(TransactionStatus(""))
+)
+
+// queryParameterDocs describes the query parameters used by the endpoints
+var queryParameterDocs = map[string]map[string]interface{}{
+	"token": {
+		"name":        "token",
+		"in":          "query",
+		"required":    true,
+		"description": "Payment token",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"transaction_id": {
+		"name":        "transaction_id",
+		"in":          "query",
+		"required":    false,
+		"description": "Stored transaction ID, used instead of the token",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"factor_number": {
+		"name":        "factor_number",
+		"in":          "query",
+		"required":    false,
+		"description": "Factor number of the most recent payment with it, used instead of the token",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"session": {
+		"name":        "session",
+		"in":          "query",
+		"required":    false,
+		"description": "Payment session ID from the init response, used instead of the token",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"format": {
+		"name":        "format",
+		"in":          "query",
+		"required":    false,
+		"description": "Set to normalized to receive a PaymentResult",
+		"schema":      map[string]interface{}{"type": "string", "enum": []string{"normalized"}},
+	},
+	"probe": {
+		"name":        "probe",
+		"in":          "query",
+		"required":    false,
+		"description": "live for liveness probes, ready (the default) for readiness probes",
+		"schema":      map[string]interface{}{"type": "string", "enum": []string{"live", "ready"}},
+	},
+	"include_sensitive": {
+		"name":        "include_sensitive",
+		"in":          "query",
+		"required":    false,
+		"description": "Return unmasked card data; requires the X-Sensitive-Data-Key header",
+		"schema":      map[string]interface{}{"type": "boolean"},
+	},
+}
+
+// GenerateOpenAPI returns an OpenAPI 3 JSON document describing the payment endpoints
+// registered by RegisterRoutes with the same options
+func (c *Client) GenerateOpenAPI(opts ...RouteOption) ([]byte, error) {
+	return c.generateOpenAPI("", newRouteOptions(opts))
+}
+
+// generateOpenAPI builds the OpenAPI document for routes mounted under prefix
+func (c *Client) generateOpenAPI(prefix string, options *routeOptions) ([]byte, error) {
+	schemas := newSchemaRegistry()
+	errorRef := schemas.ref(reflect.TypeOf(errorEnvelope{}))
+
+	paths := make(map[string]interface{})
+	for _, rt := range c.routeTable() {
+		if !options.includes(rt) {
+			continue
+		}
+
+		operation := map[string]interface{}{
+			"summary":     rt.description,
+			"operationId": operationID(rt.method, rt.path),
+			"responses": map[string]interface{}{
+				"400": jsonResponse("Invalid request", errorRef),
+				"422": jsonResponse("Validation failed or declined by the gateway", errorRef),
+				"429": jsonResponse("Rate limit exceeded", errorRef),
+				"500": jsonResponse("Internal error", errorRef),
+			},
+		}
+		responses := operation["responses"].(map[string]interface{})
+
+		// Success response
+		if rt.response != nil {
+			responses["200"] = jsonResponse("Successful response", schemas.ref(reflect.TypeOf(rt.response)))
+		} else if rt.path == metricsPath {
+			responses["200"] = map[string]interface{}{
+				"description": "Metrics in the Prometheus text exposition format",
+				"content": map[string]interface{}{
+					"text/plain": map[string]interface{}{
+						"schema": map[string]interface{}{"type": "string"},
+					},
+				},
+			}
+			responses["404"] = jsonResponse("Metrics are not recorded in a registry that can be scraped", errorRef)
+		} else if rt.path == qrCodePath {
+			responses["200"] = map[string]interface{}{
+				"description": "QR code image",
+				"content": map[string]interface{}{
+					"image/png": map[string]interface{}{
+						"schema": map[string]interface{}{"type": "string", "format": "binary"},
+					},
+				},
+			}
+			responses["304"] = map[string]interface{}{"description": "Not modified"}
+			responses["404"] = jsonResponse("Payment not found, expired or completed", errorRef)
+		} else {
+			responses["200"] = map[string]interface{}{"description": "Successful response"}
+		}
+
+		// Health reports of a down component come with 503
+		if rt.path == healthPath {
+			responses["503"] = jsonResponse("A component is down or the client is shutting down", schemas.ref(reflect.TypeOf(HealthReport{})))
+		}
+
+		// Initializations queued while the gateway is down, with DeferredInit
+		if rt.path == "/payments/init" {
+			responses["202"] = jsonResponse("Gateway unreachable, initialization queued", schemas.ref(reflect.TypeOf(QueuedInitResponse{})))
+		}
+
+		// Request body, form-encoded for the gateway callback
+		if rt.request != nil {
+			requestRef := schemas.ref(reflect.TypeOf(rt.request))
+			content := map[string]interface{}{}
+			if rt.policy == policyCallback {
+				content[formContentType] = map[string]interface{}{"schema": requestRef}
+			} else {
+				jsonContent := map[string]interface{}{"schema": requestRef}
+				if rt.example != nil {
+					jsonContent["example"] = rt.example
+				}
+				content["application/json"] = jsonContent
+				if rt.path == "/payments/init" || rt.path == "/payments/verify" {
+					content[formContentType] = map[string]interface{}{"schema": requestRef}
+				}
+			}
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  content,
+			}
+		}
+
+		// Query parameters
+		if len(rt.query) > 0 {
+			var parameters []interface{}
+			for _, name := range rt.query {
+				if doc, ok := queryParameterDocs[name]; ok {
+					parameters = append(parameters, doc)
+				}
+			}
+			operation["parameters"] = parameters
+		}
+
+		// Path parameters
+		for _, name := range pathParams(rt.path) {
+			parameters, _ := operation["parameters"].([]interface{})
+			operation["parameters"] = append(parameters, map[string]interface{}{
+				"name":        name,
+				"in":          "path",
+				"required":    true,
+				"description": "Payment token",
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+
+		// Authentication
+		switch rt.policy {
+		case policyAuthenticated:
+			operation["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+			operation["x-required-scope"] = rt.scope
+			responses["401"] = jsonResponse("Missing or invalid API key", errorRef)
+			responses["403"] = jsonResponse("API key lacks the "+string(rt.scope)+" scope", errorRef)
+		case policyAdmin:
+			operation["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}, "adminKey": []string{}}}
+			operation["x-required-scope"] = rt.scope
+			responses["401"] = jsonResponse("Missing or invalid API key", errorRef)
+			responses["403"] = jsonResponse("Missing or invalid admin key, or API key lacks the "+string(rt.scope)+" scope", errorRef)
+			responses["404"] = jsonResponse("Transaction not found", errorRef)
+			responses["409"] = jsonResponse("Status change not allowed", errorRef)
+		case policyMetrics:
+			if options.metricsToken != "" {
+				operation["security"] = []interface{}{map[string]interface{}{"metricsToken": []string{}}}
+				responses["401"] = jsonResponse("Missing or invalid metrics token", errorRef)
+			} else {
+				operation["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}, "adminKey": []string{}}}
+				operation["x-required-scope"] = rt.scope
+				responses["401"] = jsonResponse("Missing or invalid API key", errorRef)
+				responses["403"] = jsonResponse("Missing or invalid admin key, or API key lacks the "+string(rt.scope)+" scope", errorRef)
+			}
+		case policyWebhook:
+			operation["security"] = []interface{}{}
+			responses["401"] = jsonResponse("Missing or invalid "+WebhookSignatureHeader+" signature", errorRef)
+			responses["403"] = jsonResponse("Webhooks are disabled or caller not allowed", errorRef)
+		case policyHealth:
+			operation["security"] = []interface{}{}
+		default:
+			operation["security"] = []interface{}{}
+			responses["403"] = jsonResponse("Caller not allowed", errorRef)
+		}
+
+		paths[rt.path] = mergeOperation(paths[rt.path], strings.ToLower(rt.method), operation)
+	}
+
+	document := map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "Vandar payment endpoints",
+			"description": "Payment endpoints backed by the Vandar payment gateway",
+			"version":     apiDocumentVersion,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "The merchant API key",
+				},
+				"adminKey": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        AdminKeyHeader,
+					"description": "The admin key of administrative endpoints",
+				},
+			},
+		},
+	}
+
+	if options.metricsToken != "" {
+		document["components"].(map[string]interface{})["securitySchemes"].(map[string]interface{})["metricsToken"] = map[string]interface{}{
+			"type":        "http",
+			"scheme":      "bearer",
+			"description": "The metrics token of the scrape endpoint",
+		}
+	}
+
+	if options.jwt != nil {
+		document["components"].(map[string]interface{})["securitySchemes"].(map[string]interface{})["bearerAuth"] = map[string]interface{}{
+			"type":         "http",
+			"scheme":       "bearer",
+			"bearerFormat": "JWT",
+			"description":  "A JWT whose scope claim grants the route's scope",
+		}
+	}
+
+	if prefix != "" {
+		document["servers"] = []interface{}{map[string]interface{}{"url": prefix}}
+	}
+
+	return json.MarshalIndent(document, "", "  ")
+}
+
+// handleOpenAPI serves the OpenAPI document
+func (c *Client) handleOpenAPI(prefix string, options *routeOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		document, err := c.generateOpenAPI(prefix, options)
+		if err != nil {
+			c.respondWithError(w, ErrInternalError, "Failed to generate OpenAPI document")
+			c.log(r.Context()).Error(r.Context(), "Failed to generate OpenAPI document", err, nil)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(document)
+	}
+}
+
+// errorEnvelope documents the default error response body
+type errorEnvelope struct {
+	Status  bool              `json:"status"`
+	Message string            `json:"message"`
+	Code    string            `json:"code,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// schemaRegistry collects component schemas for named struct types
+type schemaRegistry struct {
+	schemas map[string]interface{}
+}
+
+// newSchemaRegistry creates an empty schema registry
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{schemas: make(map[string]interface{})}
+}
+
+// ref registers a struct type as a component schema and returns a reference to it
+func (s *schemaRegistry) ref(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	name := schemaName(t)
+	if _, exists := s.schemas[name]; !exists {
+		// Reserve the name first so recursive types terminate
+		s.schemas[name] = map[string]interface{}{}
+		s.schemas[name] = s.structSchema(t)
+	}
+
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// schema returns the schema of any Go type
+func (s *schemaRegistry) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	case flexibleAmountType:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case statusType:
+		return map[string]interface{}{
+			"type": "string",
+			"enum": transactionStatuses,
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		return s.ref(t)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema returns the object schema of a struct, flattening embedded structs
+func (s *schemaRegistry) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	s.collectFields(t, properties, &required)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// collectFields adds the JSON fields of a struct to properties
+func (s *schemaRegistry) collectFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		// Embedded structs without a name contribute their fields directly
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			s.collectFields(field.Type, properties, required)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = s.schema(field.Type)
+
+		omitEmpty := false
+		for _, option := range parts[1:] {
+			if option == "omitempty" {
+				omitEmpty = true
+			}
+		}
+		if !omitEmpty && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// schemaName returns the component name of a type
+func schemaName(t reflect.Type) string {
+	if t == reflect.TypeOf(errorEnvelope{}) {
+		return "ErrorResponse"
+	}
+	return t.Name()
+}
+
+// jsonResponse describes a JSON response with a schema
+func jsonResponse(description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// mergeOperation adds an operation to a path item
+func mergeOperation(item interface{}, method string, operation map[string]interface{}) map[string]interface{} {
+	pathItem, _ := item.(map[string]interface{})
+	if pathItem == nil {
+		pathItem = make(map[string]interface{})
+	}
+	pathItem[method] = operation
+	return pathItem
+}
+
+// operationID derives a stable operation ID from a method and path
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '-' || r == '.' || r == '{' || r == '}'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package vandargo
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// updateGolden rewrites golden files with the current output: go test -run OpenAPI -update
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// assertGolden compares output with a file in testdata/openapi
+func assertGolden(t *testing.T, name string, output []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "openapi", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, output, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test -run OpenAPI -update to create it", err)
+	}
+	if !bytes.Equal(output, golden) {
+		t.Fatalf("%s is out of date; review the change and run go test -run OpenAPI -update", path)
+	}
+}
+
+func TestGenerateOpenAPIGolden(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), nil)
+
+	tests := []struct {
+		golden string
+		opts   []RouteOption
+	}{
+		{"default.json", nil},
+		{"all_routes.json", append(allRouteOptions(), WithMetricsRoute("metrics-token"), WithOpenAPIRoute())},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			document, err := client.GenerateOpenAPI(tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !json.Valid(document) {
+				t.Fatal("document is not valid JSON")
+			}
+
+			// Generation is deterministic
+			again, _ := client.GenerateOpenAPI(tt.opts...)
+			if !bytes.Equal(document, again) {
+				t.Fatal("two generations differ")
+			}
+
+			assertGolden(t, tt.golden, document)
+		})
+	}
+}
+
+func TestOpenAPIRouteServesDocument(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), nil)
+
+	mux := http.NewServeMux()
+	client.RegisterRoutesWithPrefix(NewServeMuxRouter(mux), "/api/v1", WithOpenAPIRoute())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/api/v1/payments/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	var document struct {
+		OpenAPI string                            `json:"openapi"`
+		Servers []map[string]string               `json:"servers"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %.200s", resp.StatusCode, body)
+	}
+	if document.OpenAPI != openAPIVersion || len(document.Servers) != 1 || document.Servers[0]["url"] != "/api/v1" {
+		t.Fatalf("document header %q, servers %v", document.OpenAPI, document.Servers)
+	}
+
+	// Every registered route is documented with its method
+	for _, descriptor := range client.Routes(WithOpenAPIRoute()) {
+		if _, ok := document.Paths[descriptor.Path][strings.ToLower(descriptor.Method)]; !ok {
+			t.Errorf("%s %s is not documented", descriptor.Method, descriptor.Path)
+		}
+	}
+}
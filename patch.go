@@ -0,0 +1,158 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// patch.go implements field-level transaction updates
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TransactionPatch lists the transaction fields to change; nil fields are left untouched
+type TransactionPatch struct {
+	Status        *TransactionStatus
+	TransactionID *int64
+	CardNumber    *string
+	CardHash      *string
+	CID           *string
+	RefNumber     *string
+	TrackingCode  *string
+	Wage          *int64
+	ShaparakWage  *int64
+	NetAmount     *int64
+	CompletedAt   *time.Time
+
+	// CallbackCountDelta is added to the callback count
+	CallbackCountDelta int
+
+	// StatusChange is appended to the status history
+	StatusChange *StatusChange
+
+	// Metadata is merged into the transaction metadata
+	Metadata map[string]string
+
+	// UpdatedAt is the time a storage stamps when it applies the patch; the
+	// client sets it from its clock, and zero means the storage's own time
+	UpdatedAt time.Time
+}
+
+// Apply changes the patched fields of a transaction and sets UpdatedAt to now
+func (p TransactionPatch) Apply(transaction *Transaction, now time.Time) {
+	if p.Status != nil {
+		transaction.Status = *p.Status
+	}
+	if p.TransactionID != nil {
+		transaction.TransactionID = *p.TransactionID
+	}
+	if p.CardNumber != nil {
+		transaction.CardNumber = *p.CardNumber
+	}
+	if p.CardHash != nil {
+		transaction.CardHash = *p.CardHash
+	}
+	if p.CID != nil {
+		transaction.CID = *p.CID
+	}
+	if p.RefNumber != nil {
+		transaction.RefNumber = *p.RefNumber
+	}
+	if p.TrackingCode != nil {
+		transaction.TrackingCode = *p.TrackingCode
+	}
+	if p.Wage != nil {
+		transaction.Wage = *p.Wage
+	}
+	if p.ShaparakWage != nil {
+		transaction.ShaparakWage = *p.ShaparakWage
+	}
+	if p.NetAmount != nil {
+		transaction.NetAmount = *p.NetAmount
+	}
+	if p.CompletedAt != nil {
+		completedAt := *p.CompletedAt
+		transaction.CompletedAt = &completedAt
+	}
+	transaction.CallbackCount += p.CallbackCountDelta
+	if p.StatusChange != nil {
+		// Copy the history so stored and returned transactions don't share it
+		history := make([]StatusChange, len(transaction.StatusHistory), len(transaction.StatusHistory)+1)
+		copy(history, transaction.StatusHistory)
+		transaction.StatusHistory = append(history, *p.StatusChange)
+	}
+	if len(p.Metadata) > 0 {
+		// Copy the metadata for the same reason
+		metadata := make(map[string]string, len(transaction.Metadata)+len(p.Metadata))
+		for key, value := range transaction.Metadata {
+			metadata[key] = value
+		}
+		for key, value := range p.Metadata {
+			metadata[key] = value
+		}
+		transaction.Metadata = metadata
+	}
+	transaction.UpdatedAt = now
+}
+
+// PatchTransaction atomically applies a patch to a stored transaction
+func (s *MemoryStorage) PatchTransaction(ctx context.Context, token string, patch TransactionPatch) error {
+	if token == "" {
+		return fmt.Errorf("token cannot be empty")
+	}
+
+	if err := s.enter(ctx); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	transaction, exists := s.transactions[token]
+	if !exists {
+		return fmt.Errorf("transaction not found: %s", token)
+	}
+
+	now := patch.UpdatedAt
+	if now.IsZero() {
+		now = s.clock.Now()
+	}
+
+	previousCID := transaction.CID
+	patch.Apply(transaction, now)
+	s.reindexCID(token, previousCID, transaction.CID)
+
+	return nil
+}
+
+// patchTransaction applies a patch through the storage's PatchTransaction when
+// available, falling back to a read-modify-write otherwise
+func (c *Client) patchTransaction(ctx context.Context, token string, patch TransactionPatch) error {
+	if patch.UpdatedAt.IsZero() {
+		patch.UpdatedAt = c.clock.Now()
+	}
+
+	if patcher, ok := c.storage.(PatchableStorageInterface); ok {
+		return patcher.PatchTransaction(ctx, token, patch)
+	}
+
+	transaction, err := c.storage.GetTransaction(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	patch.Apply(transaction, patch.UpdatedAt)
+
+	return c.storage.UpdateTransaction(ctx, transaction)
+}
+
+// applyPatch applies a patch to a transaction the client read, stamping the patch
+// with the client clock so the stored transaction gets the same UpdatedAt
+func (c *Client) applyPatch(patch *TransactionPatch, transaction *Transaction) {
+	if patch.UpdatedAt.IsZero() {
+		patch.UpdatedAt = c.clock.Now()
+	}
+	patch.Apply(transaction, patch.UpdatedAt)
+}
@@ -0,0 +1,195 @@
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unpatchableStorage hides MemoryStorage.PatchTransaction to exercise the fallback
+type unpatchableStorage struct {
+	StorageInterface
+}
+
+func TestTransactionPatchApply(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 5, 0, 0, time.UTC)
+	transaction := &Transaction{
+		Token:         webhookToken,
+		Amount:        100000,
+		Status:        StatusInit,
+		CardNumber:    "603799******1234",
+		RefNumber:     "212475",
+		Metadata:      map[string]string{"order": "1042"},
+		StatusHistory: []StatusChange{{From: "", To: StatusInit}},
+	}
+	history := transaction.StatusHistory
+	metadata := transaction.Metadata
+
+	paid, empty, completedAt := StatusPaid, "", time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	TransactionPatch{
+		Status:             &paid,
+		CardNumber:         &empty,
+		CompletedAt:        &completedAt,
+		CallbackCountDelta: 2,
+		StatusChange:       &StatusChange{From: StatusInit, To: StatusPaid},
+		Metadata:           map[string]string{"verified_by": "callback"},
+	}.Apply(transaction, now)
+
+	// Set fields change, even to zero values; the rest are untouched
+	if transaction.Status != StatusPaid || transaction.CardNumber != "" || transaction.RefNumber != "212475" || transaction.Amount != 100000 {
+		t.Fatalf("patched %+v", transaction)
+	}
+	if transaction.CompletedAt == nil || !transaction.CompletedAt.Equal(completedAt) || transaction.CallbackCount != 2 {
+		t.Fatalf("completed at %v, callback count %d", transaction.CompletedAt, transaction.CallbackCount)
+	}
+	if !transaction.UpdatedAt.Equal(now) {
+		t.Fatalf("UpdatedAt = %v", transaction.UpdatedAt)
+	}
+
+	// History and metadata grow without touching what earlier readers hold
+	if len(transaction.StatusHistory) != 2 || len(history) != 1 {
+		t.Fatalf("history %v, previously %v", transaction.StatusHistory, history)
+	}
+	if transaction.Metadata["order"] != "1042" || transaction.Metadata["verified_by"] != "callback" || len(metadata) != 1 {
+		t.Fatalf("metadata %v, previously %v", transaction.Metadata, metadata)
+	}
+
+	// An empty patch only bumps UpdatedAt
+	patched := *transaction
+	TransactionPatch{}.Apply(&patched, now.Add(time.Minute))
+	if !patched.UpdatedAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("UpdatedAt = %v", patched.UpdatedAt)
+	}
+	patched.UpdatedAt = transaction.UpdatedAt
+	if fmt.Sprint(patched) != fmt.Sprint(*transaction) {
+		t.Fatalf("empty patch changed %+v", patched)
+	}
+}
+
+func TestPatchTransaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage func(*MemoryStorage) StorageInterface
+	}{
+		{"patchable", func(s *MemoryStorage) StorageInterface { return s }},
+		{"fallback", func(s *MemoryStorage) StorageInterface { return unpatchableStorage{s} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The fallback's UpdateTransaction stamps the storage clock
+			clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+			memory := NewMemoryStorage(WithStorageClock(clock))
+			client, _, _ := newTestClient(t, testConfig(t), nil, WithClientClock(clock))
+			client.storage = tt.storage(memory)
+			ctx := context.Background()
+
+			storeWebhookPayment(t, memory, StatusInit)
+			read, _ := memory.GetTransaction(ctx, webhookToken)
+
+			cid, transactionID := "cid-1", int64(160000000001)
+			err := client.patchTransaction(ctx, webhookToken, TransactionPatch{
+				CID:           &cid,
+				TransactionID: &transactionID,
+				Metadata:      map[string]string{"channel": "web"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			transaction, _ := memory.GetTransaction(ctx, webhookToken)
+			if transaction.CID != cid || transaction.TransactionID != transactionID || transaction.Metadata["channel"] != "web" {
+				t.Fatalf("stored %+v", transaction)
+			}
+			if !transaction.UpdatedAt.Equal(clock.Now()) {
+				t.Fatalf("UpdatedAt %v, want the client clock's %v", transaction.UpdatedAt, clock.Now())
+			}
+			if transaction.Amount != read.Amount || transaction.Status != read.Status {
+				t.Fatalf("unpatched fields changed: %+v", transaction)
+			}
+			if read.Metadata["channel"] != "" {
+				t.Fatal("patch changed a transaction read earlier")
+			}
+
+			// The CID index follows the patch
+			byCID, err := memory.GetTransactionsByCID(ctx, cid)
+			if err != nil || len(byCID) != 1 || byCID[0].Token != webhookToken {
+				t.Fatalf("by CID: %v, %v", byCID, err)
+			}
+
+			if err := client.patchTransaction(ctx, "missing", TransactionPatch{}); err == nil {
+				t.Fatal("patching a missing transaction succeeded")
+			}
+		})
+	}
+}
+
+func TestMemoryStoragePatchTransactionConcurrent(t *testing.T) {
+	storage := NewMemoryStorage()
+	storeWebhookPayment(t, storage, StatusInit)
+
+	// Writers of different fields never blank each other's changes
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			patch := TransactionPatch{
+				CallbackCountDelta: 1,
+				Metadata:           map[string]string{fmt.Sprintf("writer-%d", i): "done"},
+			}
+			if i == 0 {
+				refNumber := "212475"
+				patch.RefNumber = &refNumber
+			}
+			if err := storage.PatchTransaction(context.Background(), webhookToken, patch); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.CallbackCount != writers || len(transaction.Metadata) != writers || transaction.RefNumber != "212475" {
+		t.Fatalf("callback count %d, %d metadata keys, ref %q", transaction.CallbackCount, len(transaction.Metadata), transaction.RefNumber)
+	}
+}
+
+func TestMemoryStoragePatchTransactionErrors(t *testing.T) {
+	storage := NewMemoryStorage()
+	storeWebhookPayment(t, storage, StatusInit)
+
+	if err := storage.PatchTransaction(context.Background(), "", TransactionPatch{}); err == nil {
+		t.Error("empty token accepted")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	paid := StatusPaid
+	if err := storage.PatchTransaction(ctx, webhookToken, TransactionPatch{Status: &paid}); err == nil {
+		t.Error("canceled patch succeeded")
+	}
+	if transaction, _ := storage.GetTransaction(context.Background(), webhookToken); transaction.Status != StatusInit {
+		t.Errorf("canceled patch applied: %s", transaction.Status)
+	}
+}
+
+func TestApplyPatchMatchesStoredTransaction(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t), nil, WithClientClock(clock))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	// The override's returned transaction and the stored one carry the clock's time
+	clock.Advance(time.Hour)
+	transaction, err := client.OverrideTransactionStatus(context.Background(), webhookToken, StatusPaid, "confirmed", "support@shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if !transaction.UpdatedAt.Equal(clock.Now()) || !stored.UpdatedAt.Equal(clock.Now()) {
+		t.Fatalf("returned UpdatedAt %v, stored %v, want %v", transaction.UpdatedAt, stored.UpdatedAt, clock.Now())
+	}
+}
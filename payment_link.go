@@ -0,0 +1,123 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// payment_link.go implements shareable payment links with QR codes
+package vandargo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// qrCodePath is the path of the optional payment QR code route
+	qrCodePath = "/payments/qr"
+
+	// qrModulePixels is the width of a QR code module in pixels
+	qrModulePixels = 8
+)
+
+// PaymentLink is a payment that can be shared with the payer, e.g. over SMS
+type PaymentLink struct {
+	// URL is the payment page the payer opens
+	URL string `json:"url"`
+
+	// Token is the payment token
+	Token string `json:"token"`
+
+	// ExpiresAt is when the link stops working
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// QRCodePNG is a PNG image of a QR code encoding URL
+	QRCodePNG []byte `json:"qr_code_png,omitempty"`
+}
+
+// CreatePaymentLink initializes a payment and returns its payment page URL with
+// a QR code encoding it
+func (c *Client) CreatePaymentLink(ctx context.Context, req *PaymentInitRequest) (*PaymentLink, error) {
+	resp, err := c.InitiatePaymentWithRequest(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &PaymentLink{
+		URL:   c.PaymentURL(resp.Token),
+		Token: resp.Token,
+	}
+	if resp.ExpiresAt != nil {
+		link.ExpiresAt = *resp.ExpiresAt
+	} else {
+		link.ExpiresAt = c.tokenExpiry(resp, c.clock.Now())
+	}
+
+	link.QRCodePNG, err = qrCodePNG(link.URL, qrModulePixels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate payment QR code: %w", err)
+	}
+
+	return link, nil
+}
+
+// handlePaymentQRCode serves the QR code of a stored payment that can still be
+// paid. Expired, completed and unknown tokens get 404.
+func (c *Client) handlePaymentQRCode(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		c.respondWithError(w, ErrInvalidRequest, "Token is required")
+		return
+	}
+
+	// Storages report missing tokens with their own errors, so every failed
+	// lookup is answered as an unknown payment
+	transaction, err := c.storage.GetTransaction(ctx, token)
+	if err != nil {
+		c.log(ctx).Debug(ctx, "Transaction not found for QR code", map[string]interface{}{
+			"token": redactToken(token),
+			"error": err.Error(),
+		})
+		c.respondWithError(w, ErrNotFound, "Payment not found")
+		return
+	}
+
+	now := c.clock.Now()
+	if transaction.Status.IsTerminal() || c.isExpired(transaction, now) {
+		c.respondWithError(w, ErrNotFound, "Payment link expired")
+		return
+	}
+
+	// The image never changes, so it may be cached until the token expires
+	expiresAt := c.expiresAt(transaction)
+	sum := sha256.Sum256([]byte(token))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(expiresAt.Sub(now).Seconds())))
+	w.Header().Set("Expires", expiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	image, err := qrCodePNG(c.PaymentURL(token), qrModulePixels)
+	if err != nil {
+		c.respondWithError(w, ErrInternalError, "Failed to generate QR code")
+		c.log(ctx).Error(ctx, "Failed to generate payment QR code", err, map[string]interface{}{
+			"token": redactToken(token),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(image); err != nil {
+		c.log(ctx).Debug(ctx, "Failed to write QR code", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// payment_result.go implements a normalized view over the gateway's payment responses
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paymentDateLayouts lists the timestamp formats the gateway uses for payment dates
+var paymentDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// PaymentResult is a normalized payment view built from any of the gateway's
+// verify, status or transaction info responses
+type PaymentResult struct {
+	// Token is the payment token
+	Token string `json:"token"`
+
+	// State is the lifecycle state of the payment
+	State TransactionStatus `json:"state"`
+
+	// AmountRials is the payment amount in Rials
+	AmountRials int64 `json:"amountRials,omitempty"`
+
+	// RefNumber is the bank reference number
+	RefNumber string `json:"refNumber,omitempty"`
+
+	// TrackingCode is the Shaparak tracking code
+	TrackingCode string `json:"trackingCode,omitempty"`
+
+	// TransID is the gateway transaction ID
+	TransID int64 `json:"transId,omitempty"`
+
+	// CardMask is the masked card number used for the payment
+	CardMask string `json:"cardMask,omitempty"`
+
+	// CardOwnerMatch reports whether the card belongs to the customer, if the gateway said so
+	CardOwnerMatch *bool `json:"cardOwnerMatch,omitempty"`
+
+	// PreviousPayments is the number of earlier paid transactions made with the same card
+	PreviousPayments int `json:"previousPayments,omitempty"`
+
+	// ReturningCustomer reports whether the card was used for an earlier paid transaction
+	ReturningCustomer bool `json:"returningCustomer,omitempty"`
+
+	// PaidAt is when the payment was completed
+	PaidAt *time.Time `json:"paidAt,omitempty"`
+
+	// Provider is the name of the provider that served the payment
+	Provider string `json:"provider,omitempty"`
+
+	// Raw is the response the result was built from
+	Raw json.RawMessage `json:"raw,omitempty"`
+}
+
+// PaymentResultFromVerify normalizes a verification response
+func PaymentResultFromVerify(token string, resp *PaymentVerifyResponse) *PaymentResult {
+	if resp == nil {
+		return &PaymentResult{Token: token, State: StatusInit}
+	}
+
+	result := &PaymentResult{
+		Token:          token,
+		State:          StatusFailed,
+		TransID:        resp.TransID,
+		CardMask:       resp.CardNumber,
+		CardOwnerMatch: resp.CardOwnerMatch,
+		PaidAt:         parsePaymentDate(resp.PaymentDate),
+		Raw:            rawResponse(resp),
+	}
+
+	if resp.Status == 1 {
+		result.State = StatusPaid
+	}
+
+	// The verified amount may be missing or malformed. It is quoted so the
+	// string forms FlexibleAmount accepts, like "100,000", parse as in JSON.
+	var amount FlexibleAmount
+	if err := amount.UnmarshalJSON([]byte(strconv.Quote(resp.Amount))); err == nil {
+		result.AmountRials = amount.Int64()
+	}
+
+	return result
+}
+
+// PaymentResultFromStatus normalizes a payment status response
+func PaymentResultFromStatus(token string, resp *PaymentStatusResponse) *PaymentResult {
+	if resp == nil {
+		return &PaymentResult{Token: token, State: StatusInit}
+	}
+
+	return &PaymentResult{
+		Token:       token,
+		State:       resp.NormalizedStatus(),
+		AmountRials: resp.Amount,
+		RefNumber:   resp.RefID,
+		Raw:         rawResponse(resp),
+	}
+}
+
+// PaymentResultFromInfo normalizes a transaction info response
+func PaymentResultFromInfo(token string, resp *TransactionInfoResponse) *PaymentResult {
+	if resp == nil {
+		return &PaymentResult{Token: token, State: StatusInit}
+	}
+
+	result := &PaymentResult{
+		Token:        token,
+		State:        StatusInit,
+		AmountRials:  resp.Amount.Int64(),
+		RefNumber:    resp.RefNumber,
+		TrackingCode: resp.TrackingCode,
+		TransID:      resp.TransID,
+		CardMask:     resp.CardNumber,
+		PaidAt:       parsePaymentDate(resp.PaymentDate),
+		Raw:          rawResponse(resp),
+	}
+
+	// A successful lookup with a payment date describes a completed payment
+	if resp.Status == 1 && result.PaidAt != nil {
+		result.State = StatusPaid
+	}
+
+	return result
+}
+
+// GetPayment returns a normalized view of a payment, preferring the detailed
+// transaction info and falling back to the status endpoint
+func (c *Client) GetPayment(ctx context.Context, token string) (*PaymentResult, error) {
+	if token == "" {
+		return nil, fmt.Errorf("token is required")
+	}
+
+	info, infoErr := c.GetTransactionInfo(ctx, token)
+	if infoErr == nil && info.Status == 1 {
+		result := PaymentResultFromInfo(token, info)
+		c.markReturningCustomer(ctx, result, info.CID)
+		return result, nil
+	}
+
+	status, err := c.GetPaymentStatus(ctx, token)
+	if err != nil {
+		if infoErr != nil {
+			return nil, fmt.Errorf("failed to get payment: %w", infoErr)
+		}
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	return PaymentResultFromStatus(token, status), nil
+}
+
+// parsePaymentDate parses a gateway payment date, returning nil when absent or unrecognized
+func parsePaymentDate(value string) *time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+
+	for _, layout := range paymentDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+
+	return nil
+}
+
+// rawResponse encodes a response for PaymentResult.Raw
+func rawResponse(resp interface{}) json.RawMessage {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,228 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// decodeResponse decodes a gateway JSON body into a response type
+func decodeResponse[T any](t *testing.T, body string) *T {
+	t.Helper()
+
+	var resp T
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("invalid fixture %s: %v", body, err)
+	}
+	return &resp
+}
+
+func TestPaymentResultFromVerify(t *testing.T) {
+	paidAt := time.Date(2026, 10, 16, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		body string
+		want PaymentResult
+	}{
+		{"complete", `{"status":1,"amount":"100000","transId":160000000001,"cardNumber":"603799******1234","paymentDate":"2026-10-16 12:30:00","cardOwnerMatch":true}`,
+			PaymentResult{State: StatusPaid, AmountRials: 100000, TransID: 160000000001, CardMask: "603799******1234", PaidAt: &paidAt}},
+		{"RFC 3339 date", `{"status":1,"amount":"100000","paymentDate":"2026-10-16T12:30:00Z"}`,
+			PaymentResult{State: StatusPaid, AmountRials: 100000, PaidAt: &paidAt}},
+		{"status only", `{"status":1}`,
+			PaymentResult{State: StatusPaid}},
+		{"failed", `{"status":0,"amount":"100000","message":"payment is not completed"}`,
+			PaymentResult{State: StatusFailed, AmountRials: 100000}},
+		{"thousands separators", `{"status":1,"amount":"100,000"}`,
+			PaymentResult{State: StatusPaid, AmountRials: 100000}},
+		{"malformed amount and date", `{"status":1,"amount":"about 10 toman","paymentDate":"yesterday"}`,
+			PaymentResult{State: StatusPaid}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PaymentResultFromVerify(webhookToken, decodeResponse[PaymentVerifyResponse](t, tt.body))
+			assertPaymentResult(t, got, tt.want)
+
+			if tt.name == "complete" && (got.CardOwnerMatch == nil || !*got.CardOwnerMatch) {
+				t.Errorf("CardOwnerMatch = %v", got.CardOwnerMatch)
+			}
+		})
+	}
+}
+
+func TestPaymentResultFromStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want PaymentResult
+	}{
+		{"paid", `{"status":true,"amount":100000,"transactionStatus":"PAID","refId":"212475"}`,
+			PaymentResult{State: StatusPaid, AmountRials: 100000, RefNumber: "212475"}},
+		{"pending", `{"status":true,"amount":100000,"transactionStatus":"pending"}`,
+			PaymentResult{State: StatusInit, AmountRials: 100000}},
+		{"Persian status", `{"status":true,"transactionStatus":"منقضی شده"}`,
+			PaymentResult{State: StatusExpired}},
+		{"missing status", `{"status":true}`,
+			PaymentResult{State: StatusInit}},
+		{"unrecognized status", `{"status":true,"transactionStatus":"SOMETHING_NEW"}`,
+			PaymentResult{State: StatusInit}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PaymentResultFromStatus(webhookToken, decodeResponse[PaymentStatusResponse](t, tt.body))
+			assertPaymentResult(t, got, tt.want)
+		})
+	}
+}
+
+func TestPaymentResultFromInfo(t *testing.T) {
+	paidAt := time.Date(2026, 10, 16, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		body string
+		want PaymentResult
+	}{
+		{"complete", `{"status":1,"amount":"100000","transId":160000000001,"refnumber":"Gmsh","trackingCode":"212475","cardNumber":"603799******1234","paymentDate":"2026-10-16 12:30:00"}`,
+			PaymentResult{State: StatusPaid, AmountRials: 100000, TransID: 160000000001, RefNumber: "Gmsh", TrackingCode: "212475", CardMask: "603799******1234", PaidAt: &paidAt}},
+		{"unpaid", `{"status":1,"amount":100000}`,
+			PaymentResult{State: StatusInit, AmountRials: 100000}},
+		{"failed lookup with a date", `{"status":0,"paymentDate":"2026-10-16 12:30:00"}`,
+			PaymentResult{State: StatusInit, PaidAt: &paidAt}},
+		{"null amount", `{"status":1,"amount":null,"paymentDate":"2026-10-16T12:30:00"}`,
+			PaymentResult{State: StatusPaid, PaidAt: &paidAt}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PaymentResultFromInfo(webhookToken, decodeResponse[TransactionInfoResponse](t, tt.body))
+			assertPaymentResult(t, got, tt.want)
+		})
+	}
+}
+
+func TestPaymentResultFromNil(t *testing.T) {
+	results := []*PaymentResult{
+		PaymentResultFromVerify(webhookToken, nil),
+		PaymentResultFromStatus(webhookToken, nil),
+		PaymentResultFromInfo(webhookToken, nil),
+	}
+	for i, result := range results {
+		if result.Token != webhookToken || result.State != StatusInit || result.Raw != nil {
+			t.Errorf("conversion %d of nil: %+v", i, result)
+		}
+	}
+}
+
+// assertPaymentResult compares a result with the expected fields, checking that
+// Raw holds the response it was built from
+func assertPaymentResult(t *testing.T, got *PaymentResult, want PaymentResult) {
+	t.Helper()
+
+	if got.Token != webhookToken {
+		t.Errorf("Token = %q", got.Token)
+	}
+	if got.State != want.State || got.AmountRials != want.AmountRials || got.TransID != want.TransID {
+		t.Errorf("state %s, amount %d, trans ID %d; want %s, %d, %d", got.State, got.AmountRials, got.TransID, want.State, want.AmountRials, want.TransID)
+	}
+	if got.RefNumber != want.RefNumber || got.TrackingCode != want.TrackingCode || got.CardMask != want.CardMask {
+		t.Errorf("ref %q, tracking %q, card %q; want %q, %q, %q", got.RefNumber, got.TrackingCode, got.CardMask, want.RefNumber, want.TrackingCode, want.CardMask)
+	}
+	if (got.PaidAt == nil) != (want.PaidAt == nil) || (got.PaidAt != nil && !got.PaidAt.Equal(*want.PaidAt)) {
+		t.Errorf("PaidAt = %v, want %v", got.PaidAt, want.PaidAt)
+	}
+	if !json.Valid(got.Raw) {
+		t.Errorf("Raw = %s", got.Raw)
+	}
+}
+
+func TestGetPayment(t *testing.T) {
+	info := jsonStep(http.StatusOK, map[string]interface{}{
+		"status":       1,
+		"amount":       "100000",
+		"transId":      160000000001,
+		"trackingCode": "212475",
+		"paymentDate":  "2026-10-16 12:30:00",
+	})
+	status := jsonStep(http.StatusOK, map[string]interface{}{
+		"status":            true,
+		"amount":            100000,
+		"transactionStatus": "PENDING",
+	})
+	notFound := stubStep{status: http.StatusNotFound, body: `{"message":"not found"}`}
+	noCache := func(c *Config) {
+		c.CacheTTL = -1
+		c.MaxRetries = 0
+	}
+
+	// Transaction info is preferred
+	transport := newStubTransport(info)
+	client, _, _ := newTestClient(t, testConfig(t, noCache), transport)
+	result, err := client.GetPayment(context.Background(), webhookToken)
+	if err != nil || result.State != StatusPaid || result.TrackingCode != "212475" || transport.count() != 1 {
+		t.Fatalf("from info: %+v, %v after %d requests", result, err, transport.count())
+	}
+
+	// The status endpoint answers when transaction info can't
+	transport = newStubTransport(notFound, status)
+	client, _, _ = newTestClient(t, testConfig(t, noCache), transport)
+	result, err = client.GetPayment(context.Background(), webhookToken)
+	if err != nil || result.State != StatusInit || result.AmountRials != 100000 {
+		t.Fatalf("from status: %+v, %v", result, err)
+	}
+
+	// With neither, the transaction info error is reported
+	transport = newStubTransport(notFound)
+	client, _, _ = newTestClient(t, testConfig(t, noCache), transport)
+	var apiErr *APIError
+	if _, err := client.GetPayment(context.Background(), webhookToken); !errors.As(err, &apiErr) {
+		t.Fatalf("GetPayment() error = %v, want the gateway error", err)
+	}
+
+	if _, err := client.GetPayment(context.Background(), ""); err == nil {
+		t.Fatal("GetPayment without a token succeeded")
+	}
+}
+
+func TestPaymentStatusNormalizedFormat(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+		"status":            true,
+		"amount":            100000,
+		"transactionStatus": "PAID",
+		"refId":             "212475",
+	}))
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+	handler := client.Handler()
+
+	get := func(query string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, "/payments/status?token="+webhookToken+query, nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		return body
+	}
+
+	normalized := get("&format=normalized")
+	if normalized["state"] != string(StatusPaid) || normalized["amountRials"] != float64(100000) || normalized["refNumber"] != "212475" {
+		t.Fatalf("normalized body %v", normalized)
+	}
+	if _, ok := normalized["raw"].(map[string]interface{}); !ok {
+		t.Fatalf("normalized body without the raw response: %v", normalized)
+	}
+
+	if plain := get(""); plain["transactionStatus"] != "PAID" || plain["state"] != nil {
+		t.Fatalf("default body %v", plain)
+	}
+}
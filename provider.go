@@ -0,0 +1,230 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// provider.go implements the gateway-agnostic PaymentProvider abstraction for Vandar
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// VandarProviderName identifies Vandar in InitResult.Provider and transaction metadata
+	VandarProviderName = "vandar"
+
+	// ProviderMetadataKey is the transaction metadata key recording the serving provider
+	ProviderMetadataKey = "provider"
+)
+
+// InitResult is the normalized result of initializing a payment
+type InitResult struct {
+	// Provider is the name of the provider that issued the token
+	Provider string `json:"provider"`
+
+	// Token is the payment token
+	Token string `json:"token"`
+
+	// PaymentURL is the page the payer should be redirected to
+	PaymentURL string `json:"paymentUrl"`
+
+	// ExpiresAt is when the token expires, if known
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// SessionID can be passed as ?session= to the status and verify endpoints
+	// instead of the token
+	SessionID string `json:"sessionId,omitempty"`
+}
+
+// RefundResult is the normalized result of a refund
+type RefundResult struct {
+	// Provider is the name of the provider that processed the refund
+	Provider string `json:"provider"`
+
+	// RefundID is the provider's refund identifier
+	RefundID string `json:"refundId,omitempty"`
+
+	// Amount is the refunded amount in Rials
+	Amount int64 `json:"amount,omitempty"`
+}
+
+// Name returns the provider name of the Vandar client
+func (c *Client) Name() string {
+	return VandarProviderName
+}
+
+// Init initializes a payment through Vandar
+func (c *Client) Init(ctx context.Context, req *PaymentInitRequest) (*InitResult, error) {
+	resp, err := c.InitiatePaymentWithRequest(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c.recordProvider(ctx, resp.Token, VandarProviderName, req)
+
+	return &InitResult{
+		Provider:   VandarProviderName,
+		Token:      resp.Token,
+		PaymentURL: c.paymentPageURL(resp.Token),
+		ExpiresAt:  resp.ExpiresAt,
+	}, nil
+}
+
+// Verify verifies a payment through Vandar; a declined payment returns both a result and an error
+func (c *Client) Verify(ctx context.Context, token string) (*PaymentResult, error) {
+	resp, err := c.VerifyPayment(ctx, token)
+	if resp == nil {
+		return nil, err
+	}
+
+	result := PaymentResultFromVerify(token, resp)
+	result.Provider = VandarProviderName
+	c.markReturningCustomer(ctx, result, resp.CID)
+	return result, err
+}
+
+// Status returns the normalized state of a payment from Vandar
+func (c *Client) Status(ctx context.Context, token string) (*PaymentResult, error) {
+	result, err := c.GetPayment(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Provider = VandarProviderName
+	return result, nil
+}
+
+// Refund refunds a payment through Vandar
+func (c *Client) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	if req == nil {
+		return nil, fmt.Errorf("request cannot be nil")
+	}
+
+	resp, err := c.RefundPayment(ctx, req.TransactionID, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefundResult{
+		Provider: VandarProviderName,
+		RefundID: resp.RefundID,
+		Amount:   resp.Amount,
+	}, nil
+}
+
+// WithProvider returns a copy of the client whose HTTP handlers use another payment
+// provider, such as a FailoverProvider, instead of calling Vandar directly
+func (c *Client) WithProvider(provider PaymentProvider) *Client {
+	return c.Clone(WithClientProvider(provider))
+}
+
+// recordProvider stores which provider served a transaction, creating the
+// transaction record when the provider didn't store one itself
+func (c *Client) recordProvider(ctx context.Context, token, provider string, req *PaymentInitRequest) {
+	transaction, err := c.storage.GetTransaction(ctx, token)
+	if err != nil {
+		transaction = &Transaction{
+			ID:          c.idGenerator().NewTransactionID(),
+			Token:       token,
+			Amount:      req.Amount,
+			Status:      StatusInit,
+			Type:        TransactionTypePayment,
+			Description: req.Description,
+			CreatedAt:   c.clock.Now(),
+			UpdatedAt:   c.clock.Now(),
+			Metadata:    map[string]string{ProviderMetadataKey: provider},
+		}
+		expiresAt := transaction.CreatedAt.Add(c.tokenLifetime())
+		transaction.ExpiresAt = &expiresAt
+		if err := c.storage.StoreTransaction(ctx, transaction); err != nil {
+			c.log(ctx).Error(ctx, "Failed to store transaction", err, transactionLogFields(transaction))
+			return
+		}
+		c.firePaymentInitiated(ctx, transaction)
+		return
+	}
+
+	if transaction.Metadata[ProviderMetadataKey] == provider {
+		return
+	}
+
+	// A patch leaves the status to concurrent callbacks and verifications
+	patch := TransactionPatch{Metadata: map[string]string{ProviderMetadataKey: provider}}
+	if err := c.patchTransaction(ctx, token, patch); err != nil {
+		c.log(ctx).Error(ctx, "Failed to record transaction provider", err, transactionLogFields(transaction))
+	}
+}
+
+// initWithProvider handles a payment initialization through the configured provider
+func (c *Client) initWithProvider(w http.ResponseWriter, r *http.Request, req *PaymentInitRequest) {
+	ctx := r.Context()
+
+	result, err := c.provider.Init(ctx, req)
+	if errors.Is(err, ErrDuplicatePayment) {
+		c.respondWithError(w, ErrDuplicatePayment, "A payment for this factor number is already in progress")
+		return
+	}
+	if err != nil {
+		c.respondWithError(w, upstreamError(err), "Failed to initialize payment")
+		c.log(ctx).Error(ctx, "Failed to initialize payment", err, c.paymentInitLogFields(req))
+		return
+	}
+
+	c.recordProvider(ctx, result.Token, result.Provider, req)
+	result.SessionID = c.issueSessionID(ctx, result.Token, result.ExpiresAt)
+	c.respondWithJSON(w, http.StatusOK, result)
+}
+
+// verifyWithProvider handles a payment verification through the configured provider
+func (c *Client) verifyWithProvider(w http.ResponseWriter, r *http.Request, token string) {
+	ctx := r.Context()
+
+	result, err := c.provider.Verify(ctx, token)
+	if err != nil {
+		// A result means the provider declined the verification
+		if result != nil && VerifyFailureReasonOf(err) == "" {
+			err = ErrVerificationFailed
+		}
+		c.respondVerifyError(w, r, token, err, "")
+		return
+	}
+
+	c.respondWithJSON(w, http.StatusOK, result)
+}
+
+// statusWithProvider handles a payment status check through the configured provider
+func (c *Client) statusWithProvider(w http.ResponseWriter, r *http.Request, token string) {
+	ctx := r.Context()
+
+	result, err := c.provider.Status(ctx, token)
+	if err != nil {
+		c.respondWithError(w, upstreamError(err), "Failed to check payment status")
+		c.log(ctx).Error(ctx, "Failed to check payment status", err, map[string]interface{}{
+			"token": redactToken(token),
+		})
+		return
+	}
+
+	c.respondWithJSON(w, http.StatusOK, result)
+}
+
+// refundWithProvider handles a refund through the configured provider
+func (c *Client) refundWithProvider(w http.ResponseWriter, r *http.Request, req *RefundRequest) {
+	ctx := r.Context()
+
+	result, err := c.provider.Refund(ctx, req)
+	if err != nil {
+		if errors.Is(err, ErrAlreadyRefunded) {
+			c.respondError(w, err)
+			return
+		}
+		c.respondWithError(w, upstreamError(err), "Failed to refund payment")
+		c.log(ctx).Error(ctx, "Failed to refund payment", err, map[string]interface{}{
+			"transaction_id": req.TransactionID,
+		})
+		return
+	}
+
+	c.respondWithJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,227 @@
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// decodeQRPNG decodes the content of a QR code PNG with an independent decoder
+func decodeQRPNG(t *testing.T, data []byte) string {
+	t.Helper()
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid PNG: %v", err)
+	}
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := qrcode.NewQRCodeReader().Decode(bitmap, map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_PURE_BARCODE: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to decode QR code: %v", err)
+	}
+	return result.GetText()
+}
+
+// qrVersionSize returns the side of a symbol in modules
+func qrVersionSize(version int) int {
+	return 17 + 4*version
+}
+
+func TestQRCodeRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-._~:/?#[]@!$&'()*+,;=%"
+
+	inputs := []string{
+		"a",
+		"https://ipg.vandar.io/v4/sim00000000000000001",
+		SandboxBaseURL + "/v4/" + strings.Repeat("x", 40),
+	}
+	for _, length := range []int{13, 14, 15, 100, 200, 331, 400, 500, 600, 666} {
+		b := make([]byte, length)
+		for i := range b {
+			b[i] = alphabet[random.Intn(len(alphabet))]
+		}
+		inputs = append(inputs, string(b))
+	}
+
+	for _, input := range inputs {
+		data, err := qrCodePNG(input, 4)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", len(input), err)
+		}
+		if got := decodeQRPNG(t, data); got != input {
+			t.Errorf("%d bytes: decoded %q, want %q", len(input), got, input)
+		}
+	}
+}
+
+func TestQRCodeVersions(t *testing.T) {
+	for version := 1; version <= qrMaxVersion; version++ {
+		// The largest input of each version must select it
+		capacity := (8*qrLevelM[version].dataCodewords() - 4 - qrCountBits(version)) / 8
+		input := strings.Repeat("7", capacity)
+
+		code, err := encodeQR([]byte(input))
+		if err != nil {
+			t.Fatalf("version %d, %d bytes: %v", version, capacity, err)
+		}
+		if code.size != qrVersionSize(version) {
+			t.Errorf("%d bytes: %d modules, want version %d", capacity, code.size, version)
+		}
+
+		data, err := qrCodePNG(input, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decodeQRPNG(t, data); got != input {
+			t.Errorf("version %d: decoded %d bytes", version, len(got))
+		}
+	}
+
+	if _, err := encodeQR(make([]byte, 667)); !errors.Is(err, errQRTooLong) {
+		t.Fatalf("667 bytes: got %v, want errQRTooLong", err)
+	}
+}
+
+func TestQRCodePNGDimensions(t *testing.T) {
+	url := "https://ipg.vandar.io/v4/sim00000000000000001"
+	code, err := encodeQR([]byte(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scale := range []int{1, 4, qrModulePixels} {
+		data, err := qrCodePNG(url, scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := (code.size + 2*qrQuietZone) * scale
+		if config.Width != want || config.Height != want {
+			t.Errorf("scale %d: %dx%d, want %dx%d", scale, config.Width, config.Height, want, want)
+		}
+	}
+}
+
+func TestCreatePaymentLink(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(), WithClientClock(clock))
+
+	link, err := client.CreatePaymentLink(context.Background(), &PaymentInitRequest{
+		Amount:      250000,
+		CallbackURL: "https://shop.example.com/payments/callback",
+		Description: "Order 7",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if link.URL != client.PaymentURL(link.Token) || !link.ExpiresAt.After(clock.Now()) {
+		t.Fatalf("link %+v", link)
+	}
+	if got := decodeQRPNG(t, link.QRCodePNG); got != link.URL {
+		t.Fatalf("QR code encodes %q, want %q", got, link.URL)
+	}
+	if _, err := storage.GetTransaction(context.Background(), link.Token); err != nil {
+		t.Fatalf("payment not stored: %v", err)
+	}
+}
+
+func TestPaymentQRCodeRoute(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(), WithClientClock(clock))
+	handler := client.Handler(WithQRCodeRoute())
+
+	storeAged(t, storage, clock, "pending", StatusInit, nil)
+	storeAged(t, storage, clock, "paid", StatusPaid, nil)
+	storeAged(t, storage, clock, "failed", StatusFailed, nil)
+
+	get := func(token string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, qrCodePath+"?token="+token, nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("pending", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("status %d, content type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	if rec.Header().Get("Content-Length") != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("Content-Length %q for %d bytes", rec.Header().Get("Content-Length"), rec.Body.Len())
+	}
+	if got := decodeQRPNG(t, rec.Body.Bytes()); got != client.PaymentURL("pending") {
+		t.Errorf("QR code encodes %q", got)
+	}
+
+	// Cached until the token expires
+	lifetime := client.tokenLifetime()
+	if got, want := rec.Header().Get("Cache-Control"), "private, max-age="+strconv.Itoa(int(lifetime.Seconds())); got != want {
+		t.Errorf("Cache-Control = %q, want %q", got, want)
+	}
+	if got, want := rec.Header().Get("Expires"), clock.Now().Add(lifetime).Format(http.TimeFormat); got != want {
+		t.Errorf("Expires = %q, want %q", got, want)
+	}
+
+	// Revalidation with the ETag answers without a body
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	rec = get("pending", map[string]string{"If-None-Match": etag})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("revalidation: status %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec = get("pending", map[string]string{"If-None-Match": `"other"`}); rec.Code != http.StatusOK {
+		t.Errorf("stale ETag: status %d", rec.Code)
+	}
+
+	// Completed, unknown and expired payments have no QR code
+	for _, token := range []string{"paid", "failed", "unknown"} {
+		if rec := get(token, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", token, rec.Code)
+		}
+	}
+	clock.Advance(lifetime + time.Second)
+	if rec := get("pending", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expired: status %d, want 404", rec.Code)
+	}
+
+	if rec := get("", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing token: status %d, want 400", rec.Code)
+	}
+}
+
+func BenchmarkQRCodePNG(b *testing.B) {
+	url := "https://ipg.vandar.io/v4/sim00000000000000001"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := qrCodePNG(url, qrModulePixels); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// recording.go implements recording and replaying gateway traffic as JSON fixtures
+package vandargo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RecordEnvVar must be set to "1" for RecordingEnabled to report true
+const RecordEnvVar = "VANDARGO_RECORD"
+
+// redactedValue replaces secret values in recorded fixtures
+const redactedValue = "REDACTED"
+
+// ErrRecordingDisabled is returned when creating a recording transport without explicit permission
+var ErrRecordingDisabled = errors.New("recording is disabled")
+
+// ErrNoFixture is returned by ReplayTransport for requests without a recorded response
+var ErrNoFixture = errors.New("no recorded fixture matches the request")
+
+// fixtureSecretKeys are JSON keys whose values never reach fixtures
+var fixtureSecretKeys = map[string]bool{
+	"api_key":       true,
+	"refreshtoken":  true,
+	"refresh_token": true,
+	"access_token":  true,
+	"accessToken":   true,
+	"password":      true,
+	"secret":        true,
+}
+
+// fixtureIdentifierKeys are JSON keys whose values are kept verbatim: replaying a
+// flow needs the recorded token even when it contains a card-number-like digit run
+var fixtureIdentifierKeys = map[string]bool{
+	"token": true,
+}
+
+// Fixture is one recorded gateway request and its response
+type Fixture struct {
+	// Sequence is the order in which the request was recorded
+	Sequence int `json:"sequence"`
+
+	// Request identifies the recorded request
+	Request FixtureRequest `json:"request"`
+
+	// Response is the recorded response
+	Response FixtureResponse `json:"response"`
+}
+
+// FixtureRequest is the redacted form of a recorded request
+type FixtureRequest struct {
+	Method   string          `json:"method"`
+	Path     string          `json:"path"`
+	BodyHash string          `json:"body_hash"`
+	Body     json.RawMessage `json:"body,omitempty"`
+}
+
+// FixtureResponse is the redacted form of a recorded response
+type FixtureResponse struct {
+	StatusCode  int             `json:"status_code"`
+	ContentType string          `json:"content_type,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// RecordingEnabled reports whether recording was requested with VANDARGO_RECORD=1
+func RecordingEnabled() bool {
+	return os.Getenv(RecordEnvVar) == "1"
+}
+
+// RecordingTransport passes requests to another HTTP client and writes each redacted
+// request and response pair to a directory as numbered JSON fixtures
+type RecordingTransport struct {
+	next HTTPClientInterface
+	dir  string
+
+	mutex    sync.Mutex
+	sequence int
+}
+
+// NewRecordingTransport creates a recording transport. allow must be true, typically
+// from RecordingEnabled, so recording against a real gateway is always a deliberate choice.
+func NewRecordingTransport(next HTTPClientInterface, dir string, allow bool) (*RecordingTransport, error) {
+	if !allow {
+		return nil, ErrRecordingDisabled
+	}
+
+	if next == nil {
+		return nil, fmt.Errorf("http client cannot be nil")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+
+	// Continue numbering after fixtures already in the directory
+	existing, err := fixtureFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordingTransport{
+		next:     next,
+		dir:      dir,
+		sequence: len(existing),
+	}, nil
+}
+
+// Do executes the request and records it with its response
+func (t *RecordingTransport) Do(req *http.Request) (*http.Response, error) {
+	requestBody, err := drainRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	responseBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.sequence++
+	redactedRequest := redactFixtureBody(requestBody)
+	fixture := Fixture{
+		Sequence: t.sequence,
+		Request: FixtureRequest{
+			Method:   req.Method,
+			Path:     req.URL.Path,
+			BodyHash: fixtureBodyHash(redactedRequest),
+			Body:     redactedRequest,
+		},
+		Response: FixtureResponse{
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        redactFixtureBody(responseBody),
+		},
+	}
+
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode fixture: %w", err)
+	}
+
+	name := fmt.Sprintf("%04d_%s_%s.json", fixture.Sequence, strings.ToLower(req.Method), fixtureName(req.URL.Path))
+	if err := os.WriteFile(filepath.Join(t.dir, name), data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write fixture: %w", err)
+	}
+
+	return resp, nil
+}
+
+// ReplayTransport serves recorded fixtures instead of calling the gateway. Requests
+// match fixtures by method, path and the hash of their redacted body; fixtures with
+// the same key are served in recorded order, the last one repeating.
+type ReplayTransport struct {
+	mutex    sync.Mutex
+	fixtures map[string][]Fixture
+	served   map[string]int
+}
+
+// NewReplayTransport loads the fixtures of a directory written by RecordingTransport
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	files, err := fixtureFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no fixtures found in %s", dir)
+	}
+
+	transport := &ReplayTransport{
+		fixtures: make(map[string][]Fixture),
+		served:   make(map[string]int),
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture: %w", err)
+		}
+
+		var fixture Fixture
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return nil, fmt.Errorf("invalid fixture %s: %w", filepath.Base(file), err)
+		}
+
+		key := fixtureKey(fixture.Request.Method, fixture.Request.Path, fixture.Request.BodyHash)
+		transport.fixtures[key] = append(transport.fixtures[key], fixture)
+	}
+
+	return transport, nil
+}
+
+// Do serves the recorded response of a matching fixture
+func (t *ReplayTransport) Do(req *http.Request) (*http.Response, error) {
+	requestBody, err := drainRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyHash := fixtureBodyHash(redactFixtureBody(requestBody))
+	key := fixtureKey(req.Method, req.URL.Path, bodyHash)
+
+	t.mutex.Lock()
+	candidates := t.fixtures[key]
+	index := min(t.served[key], len(candidates)-1)
+	t.served[key]++
+	t.mutex.Unlock()
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: %s %s (body hash %s)", ErrNoFixture, req.Method, req.URL.Path, bodyHash)
+	}
+
+	fixture := candidates[index]
+	body := []byte(fixture.Response.Body)
+
+	// Non-JSON bodies, such as maintenance pages, are recorded as JSON strings
+	var text string
+	if len(body) > 0 && body[0] == '"' && json.Unmarshal(body, &text) == nil {
+		body = []byte(text)
+	}
+
+	header := make(http.Header)
+	if fixture.Response.ContentType != "" {
+		header.Set("Content-Type", fixture.Response.ContentType)
+	}
+
+	return &http.Response{
+		StatusCode:    fixture.Response.StatusCode,
+		Status:        fmt.Sprintf("%d %s", fixture.Response.StatusCode, http.StatusText(fixture.Response.StatusCode)),
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// NewReplayClientFromDir creates a client with in-memory storage whose gateway calls
+// are served from the fixtures in dir, for deterministic integration tests
+func NewReplayClientFromDir(dir string, config ConfigInterface) (*Client, error) {
+	transport, err := NewReplayTransport(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := NewClient(config, NewMemoryStorage(), discardLogger{})
+	if err != nil {
+		return nil, err
+	}
+
+	return client.WithHTTPClient(transport), nil
+}
+
+// drainRequestBody reads a request body and restores it for the next reader
+func drainRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// redactFixtureBody removes secrets and card numbers from a JSON body; non-JSON
+// bodies are stored as a JSON string
+func redactFixtureBody(body []byte) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		encoded, _ := json.Marshal(redactBody(string(body)))
+		return encoded
+	}
+
+	// Re-encoding also sorts object keys, keeping body hashes stable
+	encoded, err := json.Marshal(redactFixtureValue(value))
+	if err != nil {
+		return nil
+	}
+	return encoded
+}
+
+// redactFixtureValue redacts secret keys and card numbers in a decoded JSON value
+func redactFixtureValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if fixtureSecretKeys[key] {
+				v[key] = redactedValue
+				continue
+			}
+			if fixtureIdentifierKeys[key] {
+				continue
+			}
+			v[key] = redactFixtureValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactFixtureValue(item)
+		}
+		return v
+	case string:
+		return redactBody(v)
+	default:
+		return v
+	}
+}
+
+// fixtureBodyHash returns the hex SHA-256 of a redacted body
+func fixtureBodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// fixtureKey identifies the fixtures a request can be served from
+func fixtureKey(method, path, bodyHash string) string {
+	return method + " " + path + " " + bodyHash
+}
+
+// fixtureName turns a request path into a file name fragment
+func fixtureName(path string) string {
+	name := strings.Trim(strings.ReplaceAll(path, "/", "_"), "_")
+	if name == "" {
+		return "root"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// fixtureFiles lists the fixture files of a directory in recorded order
+func fixtureFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fixtures: %w", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,226 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// replayFixtureDir holds the recorded gateway traffic of a full payment.
+// Re-record it from the simulator with VANDARGO_RECORD=1 go test -run TestHandlerReplay.
+var replayFixtureDir = filepath.Join("testdata", "replay", "payment_flow")
+
+// runPaymentFlow takes a payment through init, callback, verify and status on the
+// handler of a client whose gateway is transport, returning the final state
+func runPaymentFlow(t *testing.T, transport HTTPClientInterface) string {
+	t.Helper()
+
+	client, _, logger := newTestClient(t, testConfig(t), transport)
+	server := httptest.NewServer(client.Handler())
+	defer server.Close()
+	api := serverClient{t, server}
+
+	resp, body := api.do(http.MethodPost, "/payments/init", "application/json",
+		`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+	token, _ := body["token"].(string)
+	if resp.StatusCode != http.StatusOK || token == "" {
+		t.Fatalf("init: status %d: %v", resp.StatusCode, body)
+	}
+
+	callback, err := server.Client().PostForm(server.URL+"/payments/callback", url.Values{"token": {token}, "status": {"OK"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback.Body.Close()
+
+	if resp, body := api.do(http.MethodPost, "/payments/verify", "application/json", `{"token":"`+token+`"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("verify: status %d: %v", resp.StatusCode, body)
+	}
+
+	resp, body = api.do(http.MethodGet, "/payments/status?token="+url.QueryEscape(token)+"&format=normalized", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d: %v\n%s", resp.StatusCode, body, logger.dump())
+	}
+	state, _ := body["state"].(string)
+	return state
+}
+
+func TestHandlerReplay(t *testing.T) {
+	if RecordingEnabled() {
+		if err := os.RemoveAll(replayFixtureDir); err != nil {
+			t.Fatal(err)
+		}
+		recorder, err := NewRecordingTransport(NewSimulatorTransport(WithSimulatorPaidAfter(0)), replayFixtureDir, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state := runPaymentFlow(t, recorder); state != string(StatusPaid) {
+			t.Fatalf("recorded flow ended %q", state)
+		}
+	}
+
+	replay, err := NewReplayTransport(replayFixtureDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := runPaymentFlow(t, replay); state != string(StatusPaid) {
+		t.Fatalf("replayed flow ended %q, want %q", state, StatusPaid)
+	}
+}
+
+func TestRecordingTransportRequiresPermission(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "fixtures")
+	if _, err := NewRecordingTransport(NewSimulatorTransport(), dir, false); !errors.Is(err, ErrRecordingDisabled) {
+		t.Fatalf("NewRecordingTransport(allow=false) = %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatal("fixture directory created without permission")
+	}
+
+	t.Setenv(RecordEnvVar, "true")
+	if RecordingEnabled() {
+		t.Fatal("recording enabled by a value other than 1")
+	}
+	t.Setenv(RecordEnvVar, "1")
+	if !RecordingEnabled() {
+		t.Fatal("recording not enabled by 1")
+	}
+}
+
+func TestRecordingRedactsFixtures(t *testing.T) {
+	dir := t.TempDir()
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+		"status":       1,
+		"cardNumber":   fullCardNumber,
+		"access_token": "live-access-token",
+	}))
+	recorder, err := NewRecordingTransport(transport, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodPost, SandboxBaseURL+"/api/v4/verify",
+			strings.NewReader(`{"api_key":"live-api-key","token":"`+webhookToken+`"}`))
+		resp, err := recorder.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The caller still gets the unredacted response
+		body, err := io.ReadAll(resp.Body)
+		if err != nil || !strings.Contains(string(body), fullCardNumber) {
+			t.Fatalf("response body %q, %v", body, err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 || filepath.Base(files[0]) != "0001_post_api_v4_verify.json" {
+		t.Fatalf("fixtures %v", files)
+	}
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		for _, secret := range []string{"live-api-key", "live-access-token"} {
+			if strings.Contains(string(data), secret) {
+				t.Fatalf("%s contains %q", filepath.Base(file), secret)
+			}
+		}
+		if leaksCardNumber(string(data)) {
+			t.Fatalf("%s contains the card number", filepath.Base(file))
+		}
+	}
+
+	// A second recorder continues the numbering
+	recorder, err = NewRecordingTransport(transport, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, SandboxBaseURL+"/v4/"+webhookToken, nil)
+	if _, err := recorder.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "0003_get_v4_"+webhookToken+".json")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayTransportMatching(t *testing.T) {
+	dir := t.TempDir()
+	transport := newStubTransport(
+		jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "attempt": 1}),
+		jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "attempt": 2}),
+	)
+	recorder, err := NewRecordingTransport(transport, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(doer HTTPClientInterface, apiKey, token string) (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodPost, SandboxBaseURL+"/api/v4/verify",
+			strings.NewReader(`{"api_key":"`+apiKey+`","token":"`+token+`"}`))
+		return doer.Do(req)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := send(recorder, "recorded-key", webhookToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replay, err := NewReplayTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Identical requests get the recorded responses in order, the last one repeating;
+	// the API key is redacted before hashing, so another key still matches
+	for _, want := range []float64{1, 2, 2} {
+		resp, err := send(replay, "other-key", webhookToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK || body["attempt"] != want {
+			t.Fatalf("replayed %d %v, %v, want attempt %v", resp.StatusCode, body, err, want)
+		}
+	}
+
+	// Anything else fails loudly
+	if _, err := send(replay, "recorded-key", "sim99999999999999999"); !errors.Is(err, ErrNoFixture) {
+		t.Fatalf("unmatched body: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, SandboxBaseURL+"/api/v4/send", nil)
+	if _, err := replay.Do(req); !errors.Is(err, ErrNoFixture) || !strings.Contains(err.Error(), "/api/v4/send") {
+		t.Fatalf("unmatched path: %v", err)
+	}
+
+	if _, err := NewReplayTransport(t.TempDir()); err == nil {
+		t.Fatal("replay from an empty directory succeeded")
+	}
+}
+
+func TestNewReplayClientFromDir(t *testing.T) {
+	client, err := NewReplayClientFromDir(replayFixtureDir, testConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fixtures of the handler flow answer client calls too
+	status, err := client.GetPaymentStatus(context.Background(), webhookToken)
+	if err != nil || status.NormalizedStatus() != StatusPaid {
+		t.Fatalf("GetPaymentStatus() = %+v, %v", status, err)
+	}
+	if _, err := client.GetPaymentStatus(context.Background(), "sim99999999999999999"); !errors.Is(err, ErrNoFixture) {
+		t.Fatalf("unrecorded status: %v", err)
+	}
+
+	if _, err := NewReplayClientFromDir(t.TempDir(), testConfig(t)); err == nil {
+		t.Fatal("replay client without fixtures created")
+	}
+}
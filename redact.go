@@ -0,0 +1,152 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// redact.go implements redaction of sensitive values before they reach logs
+package vandargo
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// cardSequenceRegex matches 16-digit sequences, optionally grouped by spaces or dashes
+var cardSequenceRegex = regexp.MustCompile(`\d(?:[ -]?\d){15}`)
+
+// redactToken keeps only the first and last four characters of a token
+func redactToken(token string) string {
+	if len(token) <= 8 {
+		return "****"
+	}
+
+	return token[:4] + "..." + token[len(token)-4:]
+}
+
+// redactBody masks anything that looks like a card number in a response body
+func redactBody(body string) string {
+	return cardSequenceRegex.ReplaceAllStringFunc(body, MaskCardNumber)
+}
+
+// redactEndpoint redacts long path segments, such as tokens, in an API endpoint
+func redactEndpoint(endpoint string) string {
+	segments := strings.Split(endpoint, "/")
+	for i, segment := range segments {
+		if len(segment) > 16 {
+			segments[i] = redactToken(segment)
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// paymentInitLogFields returns the log-safe fields of a payment initialization request
+func (c *Client) paymentInitLogFields(req *PaymentInitRequest) map[string]interface{} {
+	fields := map[string]interface{}{
+		"amount":        req.Amount,
+		"callback_url":  req.CallbackURL,
+		"factor_number": req.FactorNumber,
+	}
+
+	if req.Mobile != "" {
+		fields["mobile"] = maskMobile(req.Mobile)
+	}
+
+	if req.ValidCardNumber != "" {
+		fields["valid_card_number"] = c.maskCard(req.ValidCardNumber)
+	}
+
+	if req.NationalCode != "" {
+		fields["national_code"] = maskMobile(req.NationalCode)
+	}
+
+	if req.RequireCardOwnerMatch {
+		fields["require_card_owner_match"] = true
+	}
+
+	return fields
+}
+
+// transactionLogFields returns the log-safe fields of a transaction
+func transactionLogFields(transaction *Transaction) map[string]interface{} {
+	return map[string]interface{}{
+		"id":     transaction.ID,
+		"token":  redactToken(transaction.Token),
+		"status": transaction.Status,
+		"type":   transaction.Type.OrDefault(),
+		"amount": transaction.Amount,
+	}
+}
+
+// maskMobile keeps only the last four digits of a mobile number
+func maskMobile(mobile string) string {
+	if len(mobile) <= 4 {
+		return "****"
+	}
+
+	return strings.Repeat("*", len(mobile)-4) + mobile[len(mobile)-4:]
+}
+
+// sensitiveMetadataKeys lists transaction metadata entries masked by Redacted
+var sensitiveMetadataKeys = map[string]bool{
+	"mobile":        true,
+	"national_code": true,
+	"card_number":   true,
+}
+
+// Redacted returns a copy of the transaction that is safe to log or show to staff:
+// the card number keeps its last four digits, the card fingerprint and hash are
+// shortened and sensitive metadata such as mobile numbers is masked
+func (t Transaction) Redacted() Transaction {
+	redacted := t
+
+	if t.CardNumber != "" {
+		redacted.CardNumber = MaskCardNumber(t.CardNumber)
+	}
+	if t.CID != "" {
+		redacted.CID = redactToken(t.CID)
+	}
+	if t.CardHash != "" {
+		redacted.CardHash = redactToken(t.CardHash)
+	}
+
+	if len(t.Metadata) > 0 {
+		redacted.Metadata = make(map[string]string, len(t.Metadata))
+		for key, value := range t.Metadata {
+			if sensitiveMetadataKeys[key] {
+				value = maskMobile(value)
+			}
+			redacted.Metadata[key] = value
+		}
+	}
+
+	// The history is shared with the original, copy it so callers can't alias it
+	redacted.StatusHistory = append([]StatusChange(nil), t.StatusHistory...)
+
+	return redacted
+}
+
+// String describes the transaction without sensitive fields, so printing it with
+// fmt never reveals a card number
+func (t Transaction) String() string {
+	redacted := t.Redacted()
+	return fmt.Sprintf("Transaction{ID: %s, Token: %s, Type: %s, Status: %s, Amount: %d, Card: %s}",
+		redacted.ID, redactToken(redacted.Token), redacted.Type.OrDefault(), redacted.Status, redacted.Amount, redacted.CardNumber)
+}
+
+// GoString is used by the %#v verb and is as safe as String
+func (t Transaction) GoString() string {
+	return t.String()
+}
+
+// redactLogValue replaces transactions in log fields with their redacted form
+func redactLogValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case *Transaction:
+		if v == nil {
+			return value, true
+		}
+		return v.Redacted(), true
+	case Transaction:
+		return v.Redacted(), true
+	default:
+		return value, false
+	}
+}
@@ -0,0 +1,281 @@
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fullCardNumber is a card number that must never appear in logs
+const fullCardNumber = "6037991234567890"
+
+// leaksCardNumber reports whether s holds the unmasked card number. Any run of
+// 16 digits would also match random hex request IDs now and then.
+func leaksCardNumber(s string) bool {
+	return strings.Contains(s, fullCardNumber)
+}
+
+// leakyGatewayTransport answers like the simulator, but with the full card
+// number in verify responses. The first init and verify calls fail with the
+// card number in the error message.
+type leakyGatewayTransport struct {
+	simulator *SimulatorTransport
+	failed    map[string]bool
+}
+
+func (t *leakyGatewayTransport) Do(req *http.Request) (*http.Response, error) {
+	endpoint := req.URL.Path[strings.LastIndex(req.URL.Path, "/"):]
+	if endpoint != "/send" && endpoint != "/verify" {
+		return t.simulator.Do(req)
+	}
+
+	if !t.failed[endpoint] {
+		t.failed[endpoint] = true
+		return stubResponse(req, http.StatusUnprocessableEntity, map[string]interface{}{
+			"status":  0,
+			"message": "card " + fullCardNumber + " was declined",
+		}), nil
+	}
+
+	resp, err := t.simulator.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body = io.NopCloser(bytes.NewReader(bytes.ReplaceAll(body, []byte("603799******1234"), []byte(fullCardNumber))))
+	resp.ContentLength = -1
+	return resp, nil
+}
+
+// teeLogger passes entries to two loggers
+type teeLogger struct {
+	first, second LoggerInterface
+}
+
+func (l teeLogger) Debug(ctx context.Context, message string, fields map[string]interface{}) {
+	l.first.Debug(ctx, message, fields)
+	l.second.Debug(ctx, message, fields)
+}
+
+func (l teeLogger) Info(ctx context.Context, message string, fields map[string]interface{}) {
+	l.first.Info(ctx, message, fields)
+	l.second.Info(ctx, message, fields)
+}
+
+func (l teeLogger) Warn(ctx context.Context, message string, fields map[string]interface{}) {
+	l.first.Warn(ctx, message, fields)
+	l.second.Warn(ctx, message, fields)
+}
+
+func (l teeLogger) Error(ctx context.Context, message string, err error, fields map[string]interface{}) {
+	l.first.Error(ctx, message, err, fields)
+	l.second.Error(ctx, message, err, fields)
+}
+
+func TestPaymentFlowLogsNoSecrets(t *testing.T) {
+	var output bytes.Buffer
+	captured := &captureLogger{}
+	logger := teeLogger{captured, NewDefaultLoggerWithOutput("DEBUG", &output, &output)}
+
+	client, err := NewClient(testConfig(t), NewMemoryStorage(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = client.Clone(WithClientHTTPClient(&leakyGatewayTransport{
+		simulator: NewSimulatorTransport(WithSimulatorPaidAfter(0)),
+		failed:    make(map[string]bool),
+	}))
+	handler := client.Handler()
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if strings.HasPrefix(body, "token=") {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	const initBody = `{
+		"amount": 100000,
+		"callback_url": "https://shop.example.com/callback",
+		"description": "Order 1042",
+		"mobile": "09121234567",
+		"valid_card_number": "` + fullCardNumber + `"
+	}`
+
+	// A rejected init whose error message carries the card number
+	if rec := send(http.MethodPost, "/payments/init", initBody); rec.Code == http.StatusOK {
+		t.Fatalf("rejected init succeeded: %s", rec.Body)
+	}
+
+	initiate := func() string {
+		rec := send(http.MethodPost, "/payments/init", initBody)
+		var resp PaymentInitResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK || resp.Token == "" {
+			t.Fatalf("init: status %d: %s", rec.Code, rec.Body)
+		}
+		return resp.Token
+	}
+
+	// A declined payment whose verify error body carries the card number
+	declined := initiate()
+	if rec := send(http.MethodPost, "/payments/verify", `{"token":"`+declined+`"}`); rec.Code == http.StatusOK {
+		t.Fatalf("declined verify succeeded: %s", rec.Body)
+	}
+
+	// A paid payment whose verify response carries the card number
+	token := initiate()
+	if rec := send(http.MethodPost, "/payments/callback", "token="+token+"&status=OK"); rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := send(http.MethodPost, "/payments/verify", `{"token":"`+token+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("verify: status %d: %s", rec.Code, rec.Body)
+	}
+	send(http.MethodGet, "/payments/status?token="+token, "")
+
+	transaction, err := client.storage.GetTransaction(context.Background(), token)
+	if err != nil || transaction.Status != StatusPaid {
+		t.Fatalf("flow did not complete: %+v, %v", transaction, err)
+	}
+
+	// What the handlers pass to the logger is already redacted
+	for _, entry := range captured.all() {
+		line := fmt.Sprintf("%s %v %+v", entry.message, entry.err, entry.fields)
+		if strings.Contains(line, token) || strings.Contains(line, declined) || leaksCardNumber(line) {
+			t.Errorf("%s entry leaks a secret: %s", entry.level, line)
+		}
+	}
+
+	// And so is what reaches the output
+	if strings.Contains(output.String(), token) || strings.Contains(output.String(), declined) || leaksCardNumber(output.String()) {
+		t.Errorf("log output leaks a secret:\n%s", output.String())
+	}
+	if len(captured.all()) < 10 {
+		t.Fatalf("only %d entries logged, the flow was not exercised", len(captured.all()))
+	}
+}
+
+// sensitiveTransaction is a transaction carrying every sensitive field
+func sensitiveTransaction() *Transaction {
+	return &Transaction{
+		ID:          "tx-sensitive",
+		Token:       webhookToken,
+		Amount:      100000,
+		Status:      StatusPaid,
+		CardNumber:  fullCardNumber,
+		CID:         "cid-0123456789abcdef0123456789abcdef",
+		CardHash:    HashCardNumberKeyed(fullCardNumber, "hash-key"),
+		Description: "Order 1042",
+		Metadata:    map[string]string{"mobile": "09121234567", "order": "1042"},
+	}
+}
+
+func TestTransactionFormattingNeverPrintsCard(t *testing.T) {
+	transaction := sensitiveTransaction()
+	values := map[string]interface{}{
+		"pointer": transaction,
+		"value":   *transaction,
+		"slice":   []*Transaction{transaction},
+		"map":     map[string]interface{}{"transaction": transaction},
+		"struct":  struct{ Transaction *Transaction }{transaction},
+	}
+
+	for name, value := range values {
+		for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+			out := fmt.Sprintf(verb, value)
+			if leaksCardNumber(out) || strings.Contains(out, "09121234567") || strings.Contains(out, transaction.CID) {
+				t.Errorf("%s printed with %s leaks sensitive data: %s", name, verb, out)
+			}
+		}
+	}
+	if out := transaction.String(); !strings.Contains(out, "************7890") {
+		t.Fatalf("String() = %s, want the masked card", out)
+	}
+}
+
+func TestTransactionRedacted(t *testing.T) {
+	transaction := sensitiveTransaction()
+	redacted := transaction.Redacted()
+
+	if redacted.CardNumber != "************7890" || redacted.Metadata["mobile"] != "*******4567" || redacted.Metadata["order"] != "1042" {
+		t.Fatalf("redacted %+v", redacted.Metadata)
+	}
+	if redacted.CID == transaction.CID || redacted.CardHash == transaction.CardHash {
+		t.Fatal("card fingerprint or hash kept")
+	}
+
+	// The original is untouched
+	if transaction.CardNumber != fullCardNumber || transaction.Metadata["mobile"] != "09121234567" {
+		t.Fatalf("Redacted() changed the original: %s", transaction.CardNumber)
+	}
+}
+
+func TestLoggerRedactsTransactionFields(t *testing.T) {
+	var out bytes.Buffer
+	logger := NewDefaultLoggerWithOutput("DEBUG", &out, &out)
+	transaction := sensitiveTransaction()
+
+	logger.Info(context.Background(), "Transaction updated", map[string]interface{}{"transaction": transaction, "value": *transaction})
+	logger.Error(context.Background(), "Transaction failed", fmt.Errorf("failed: %v", transaction), map[string]interface{}{"tx": transaction})
+
+	if leaksCardNumber(out.String()) || strings.Contains(out.String(), "09121234567") {
+		t.Fatalf("log output leaks sensitive data:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "7890") {
+		t.Fatalf("log output lost the transaction:\n%s", out.String())
+	}
+}
+
+func TestAdminIncludeSensitive(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.AdminKey = "admin-key"
+		c.SensitiveDataKey = "sensitive-key"
+	}), nil)
+	if err := storage.StoreTransaction(context.Background(), sensitiveTransaction()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		key    string
+		status int
+		card   string
+	}{
+		{"masked by default", "", "", http.StatusOK, "************7890"},
+		{"masked with the key alone", "", "sensitive-key", http.StatusOK, "************7890"},
+		{"flag without the key", "?include_sensitive=true", "", http.StatusForbidden, ""},
+		{"flag with a wrong key", "?include_sensitive=true", "admin-key", http.StatusForbidden, ""},
+		{"flag with the key", "?include_sensitive=true", "sensitive-key", http.StatusOK, fullCardNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/payments/transactions/"+webhookToken+tt.query, nil)
+			req.Header.Set("Authorization", "Bearer "+testAPIKey)
+			req.Header.Set(AdminKeyHeader, "admin-key")
+			if tt.key != "" {
+				req.Header.Set(SensitiveDataKeyHeader, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			client.Handler().ServeHTTP(rec, req)
+
+			var detail TransactionDetail
+			json.Unmarshal(rec.Body.Bytes(), &detail)
+			if rec.Code != tt.status || detail.CardNumber != tt.card {
+				t.Fatalf("status %d, card %q: %s", rec.Code, detail.CardNumber, rec.Body)
+			}
+		})
+	}
+}
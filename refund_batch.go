@@ -0,0 +1,340 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// refund_batch.go implements refunding many transactions at once with per-item results
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	// refundBatchPath is the path of the optional batch refund route
+	refundBatchPath = "/payments/refund/batch"
+
+	// MaxRefundBatchSize is the most refunds a request to the batch refund route may carry
+	MaxRefundBatchSize = 100
+
+	// defaultRefundBatchConcurrency is used when BatchOptions.Concurrency is not set
+	defaultRefundBatchConcurrency = 4
+
+	// maxRefundBatchConcurrency caps BatchOptions.Concurrency so a batch can't
+	// exhaust the gateway's rate limit on its own
+	maxRefundBatchConcurrency = 16
+)
+
+// BatchOptions configures a refund batch
+type BatchOptions struct {
+	// Concurrency is how many refunds run at once (4 by default, at most 16)
+	Concurrency int
+
+	// ContinueOnInsufficientFunds refunds even when the batch total exceeds the
+	// wallet balance; the refunds the balance doesn't cover fail with
+	// ErrInsufficientBalance. The balance isn't checked then.
+	ContinueOnInsufficientFunds bool
+}
+
+// concurrency returns the refunds to run at once with the default and cap applied
+func (o BatchOptions) concurrency() int {
+	switch {
+	case o.Concurrency <= 0:
+		return defaultRefundBatchConcurrency
+	case o.Concurrency > maxRefundBatchConcurrency:
+		return maxRefundBatchConcurrency
+	default:
+		return o.Concurrency
+	}
+}
+
+// RefundBatchItem is the outcome of one refund of a batch
+type RefundBatchItem struct {
+	// Request is the refund as requested, with a payment named by token or
+	// factor number resolved to its transaction ID
+	Request RefundRequest
+
+	// Response is the gateway's answer, nil when the refund wasn't sent or failed in transit
+	Response *RefundResponse
+
+	// Refunded is the refunded amount in Rials, 0 on failure
+	Refunded int64
+
+	// Err is why the refund failed, nil on success. Errors are classified like
+	// those of RefundPayment, e.g. ErrAlreadyRefunded, ErrInsufficientBalance
+	// or a validation error; refunds not started before ctx was done fail with
+	// its error.
+	Err error
+}
+
+// StatusCode returns the HTTP status code classifying the outcome, 200 on success
+func (i *RefundBatchItem) StatusCode() int {
+	return errorToStatus(i.Err)
+}
+
+// RefundBatchSummary totals the outcomes of a refund batch
+type RefundBatchSummary struct {
+	// Succeeded is the number of accepted refunds
+	Succeeded int `json:"succeeded"`
+
+	// Failed is the number of failed refunds
+	Failed int `json:"failed"`
+
+	// TotalRefunded is the sum of the accepted refunds in Rials
+	TotalRefunded int64 `json:"total_refunded"`
+}
+
+// RefundBatchResult is the outcome of a refund batch
+type RefundBatchResult struct {
+	// Items are the outcomes in the order of the requests
+	Items []RefundBatchItem
+
+	// Summary totals the outcomes
+	Summary RefundBatchSummary
+}
+
+// RefundPayments refunds many transactions, running opts.Concurrency refunds at
+// once, and returns the outcome of each in request order. A failed refund doesn't
+// stop the others; each goes through the same guard as RefundPayment, so a
+// transaction listed twice isn't refunded beyond its amount.
+//
+// Unless opts.ContinueOnInsufficientFunds is set, the wallet balance is checked
+// first and a batch totaling more than the available balance fails with
+// ErrInsufficientBalance before any refund is sent. Full refunds count with the
+// amount of their stored transaction, and not at all when none is stored.
+func (c *Client) RefundPayments(ctx context.Context, reqs []RefundRequest, opts BatchOptions) (*RefundBatchResult, error) {
+	result := &RefundBatchResult{Items: make([]RefundBatchItem, len(reqs))}
+	if len(reqs) == 0 {
+		return result, nil
+	}
+
+	// Invalid or unknown items fail on their own without being sent
+	valid := make([]bool, len(reqs))
+	for i, req := range reqs {
+		result.Items[i].Request = req
+		if err := c.resolveRefundRequest(ctx, &req); err != nil {
+			result.Items[i].Err = err
+			continue
+		}
+		result.Items[i].Request = req
+		if err := c.validator().ValidateRefundRequest(&req); err != nil {
+			result.Items[i].Err = err
+			continue
+		}
+		valid[i] = true
+	}
+
+	if !opts.ContinueOnInsufficientFunds {
+		if err := c.checkRefundBatchBalance(ctx, result.Items, valid); err != nil {
+			return nil, err
+		}
+	}
+
+	// Hand out the items in order until ctx is done
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < min(opts.concurrency(), len(reqs)); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				item := &result.Items[i]
+				item.Response, item.Refunded, item.Err = c.refund(ctx, item.Request.TransactionID, item.Request.Amount)
+			}
+		}()
+	}
+
+	for i := range reqs {
+		if !valid[i] {
+			continue
+		}
+		if ctx.Err() != nil {
+			result.Items[i].Err = fmt.Errorf("refund not started: %w", ctx.Err())
+			continue
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			result.Items[i].Err = fmt.Errorf("refund not started: %w", ctx.Err())
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, item := range result.Items {
+		outcome := "succeeded"
+		if item.Err != nil {
+			outcome = "failed"
+			result.Summary.Failed++
+		} else {
+			result.Summary.Succeeded++
+			result.Summary.TotalRefunded += item.Refunded
+		}
+		c.metrics.IncCounter(MetricRefundBatchItems, map[string]string{"outcome": outcome})
+	}
+
+	return result, nil
+}
+
+// checkRefundBatchBalance fails with ErrInsufficientBalance when the valid
+// refunds of a batch total more than the available wallet balance
+func (c *Client) checkRefundBatchBalance(ctx context.Context, items []RefundBatchItem, valid []bool) error {
+	var total int64
+	for i, item := range items {
+		if valid[i] {
+			total += c.expectedRefundAmount(ctx, item.Request)
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	balance, err := c.GetWalletBalance(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check the wallet balance: %w", err)
+	}
+
+	if available := balance.Available(); total > available {
+		return fmt.Errorf("%w: the refunds total %d Rials but only %d are available", ErrInsufficientBalance, total, available)
+	}
+	return nil
+}
+
+// expectedRefundAmount returns the amount a refund will take from the wallet:
+// its amount, or for full refunds the amount of the stored transaction
+func (c *Client) expectedRefundAmount(ctx context.Context, req RefundRequest) int64 {
+	if req.Amount > 0 {
+		return req.Amount
+	}
+
+	transID, err := strconv.ParseInt(req.TransactionID, 10, 64)
+	if err != nil {
+		return 0
+	}
+	transaction, err := c.findTransactionByTransID(ctx, transID)
+	if err != nil || transaction == nil {
+		return 0
+	}
+	return transaction.Amount
+}
+
+// RefundBatchRequest is the body of the batch refund route
+type RefundBatchRequest struct {
+	// Refunds are the refunds to make, at most MaxRefundBatchSize
+	Refunds []RefundRequest `json:"refunds"`
+
+	// ContinueOnInsufficientFunds refunds even when the total exceeds the wallet balance
+	ContinueOnInsufficientFunds bool `json:"continue_on_insufficient_funds,omitempty"`
+}
+
+// RefundBatchItemResponse is the outcome of one refund of the batch refund route
+type RefundBatchItemResponse struct {
+	// TransactionID is the ID of the refunded transaction
+	TransactionID string `json:"transaction_id"`
+
+	// Amount is the requested amount, 0 for a full refund
+	Amount int64 `json:"amount,omitempty"`
+
+	// StatusCode is the HTTP status code a single refund would have been answered with
+	StatusCode int `json:"status_code"`
+
+	// Refund is the gateway's answer to an accepted refund
+	Refund *RefundResponse `json:"refund,omitempty"`
+
+	// Error is the error envelope of a failed refund
+	Error interface{} `json:"error,omitempty"`
+}
+
+// RefundBatchResponse is the response of the batch refund route
+type RefundBatchResponse struct {
+	// Status is true when every refund was accepted
+	Status bool `json:"status"`
+
+	// Results are the outcomes in the order of the requests
+	Results []RefundBatchItemResponse `json:"results"`
+
+	// Summary totals the outcomes
+	Summary RefundBatchSummary `json:"summary"`
+}
+
+// WithRefundBatchRoute registers POST /payments/refund/batch, which refunds up to
+// MaxRefundBatchSize transactions with RefundPayments. It requires the refund
+// scope and is answered with 200 and per-refund results even when some fail.
+func WithRefundBatchRoute() RouteOption {
+	return func(o *routeOptions) {
+		o.enable(refundBatchPath)
+	}
+}
+
+// handleRefundBatch handles batch refund requests
+func (c *Client) handleRefundBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Parse request body
+	var req RefundBatchRequest
+	if err := parseJSONBody(r, &req); err != nil {
+		c.respondInvalid(w, err)
+		return
+	}
+
+	switch {
+	case len(req.Refunds) == 0:
+		c.respondError(w, NewValidationError("refunds", "at least one refund is required"))
+		return
+	case len(req.Refunds) > MaxRefundBatchSize:
+		c.respondError(w, NewValidationError("refunds", fmt.Sprintf("at most %d refunds are allowed per batch", MaxRefundBatchSize)))
+		return
+	}
+
+	result, err := c.RefundPayments(ctx, req.Refunds, BatchOptions{
+		ContinueOnInsufficientFunds: req.ContinueOnInsufficientFunds,
+	})
+	if err != nil {
+		if IsDomainError(err) {
+			c.respondError(w, err)
+			return
+		}
+		c.respondWithError(w, upstreamError(err), "Failed to check the wallet balance")
+		c.log(ctx).Error(ctx, "Failed to check the wallet balance for a refund batch", err, nil)
+		return
+	}
+
+	resp := RefundBatchResponse{
+		Status:  result.Summary.Failed == 0,
+		Results: make([]RefundBatchItemResponse, len(result.Items)),
+		Summary: result.Summary,
+	}
+	for i, item := range result.Items {
+		itemResp := RefundBatchItemResponse{
+			TransactionID: item.Request.TransactionID,
+			Amount:        item.Request.Amount,
+			StatusCode:    item.StatusCode(),
+		}
+		if item.Err == nil {
+			itemResp.Refund = item.Response
+			resp.Results[i] = itemResp
+			continue
+		}
+
+		// Only classified errors are shown, like single refunds do
+		publicErr := item.Err
+		if !IsDomainError(publicErr) && !IsValidationError(publicErr) {
+			publicErr = upstreamError(publicErr)
+			itemResp.StatusCode = errorToStatus(publicErr)
+			c.log(ctx).Error(ctx, "Failed to refund payment", item.Err, map[string]interface{}{
+				"transaction_id": item.Request.TransactionID,
+				"amount":         item.Request.Amount,
+			})
+		}
+		itemResp.Error = c.encoder().ErrorEnvelope(publicErr, "")
+		resp.Results[i] = itemResp
+	}
+
+	c.log(ctx).Info(ctx, "Refund batch finished", map[string]interface{}{
+		"succeeded":      result.Summary.Succeeded,
+		"failed":         result.Summary.Failed,
+		"total_refunded": result.Summary.TotalRefunded,
+	})
+
+	c.respondWithJSON(w, http.StatusOK, resp)
+}
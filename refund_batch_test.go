@@ -0,0 +1,215 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// paidSimulatedPayments initiates and verifies count payments of 100,000 Rials
+// on the client's simulator and returns their transaction IDs
+func paidSimulatedPayments(t *testing.T, client *Client, count int) []string {
+	t.Helper()
+
+	ids := make([]string, count)
+	for i := range ids {
+		token := initSimulatedPayment(t, client)
+		resp, err := client.VerifyPayment(context.Background(), token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = strconv.FormatInt(resp.TransID, 10)
+	}
+	return ids
+}
+
+func TestRefundPaymentsMixedOutcomes(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(WithSimulatorPaidAfter(0), WithSimulatorRefundSettledAfter(0)))
+	ids := paidSimulatedPayments(t, client, 3)
+
+	result, err := client.RefundPayments(context.Background(), []RefundRequest{
+		{TransactionID: ids[0]},
+		{TransactionID: ids[1], Amount: 40000},
+		{TransactionID: ids[1], Amount: 70000},
+		{TransactionID: ids[2]},
+		{TransactionID: ids[2]},
+		{TransactionID: ""},
+		{TransactionID: "999999999"},
+	}, BatchOptions{Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	succeeded := func(err error) bool { return err == nil }
+	failedWith := func(target error) func(error) bool {
+		return func(err error) bool { return errors.Is(err, target) }
+	}
+	failed := func(err error) bool { return err != nil }
+
+	wants := []struct {
+		refunded int64
+		outcome  func(error) bool
+	}{
+		{100000, succeeded},
+		{40000, succeeded},
+		{0, failedWith(ErrAlreadyRefunded)},
+		{100000, succeeded},
+		{0, failedWith(ErrAlreadyRefunded)},
+		{0, IsValidationError},
+		{0, failed},
+	}
+	if len(result.Items) != len(wants) {
+		t.Fatalf("%d items, want %d", len(result.Items), len(wants))
+	}
+	for i, want := range wants {
+		item := result.Items[i]
+		if !want.outcome(item.Err) || item.Refunded != want.refunded || (item.Err == nil) != (item.Response != nil) {
+			t.Errorf("item %d: refunded %d, %v", i, item.Refunded, item.Err)
+		}
+	}
+
+	if summary := result.Summary; summary.Succeeded != 3 || summary.Failed != 4 || summary.TotalRefunded != 240000 {
+		t.Fatalf("summary %+v", summary)
+	}
+}
+
+func TestRefundPaymentsConcurrentGuard(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(WithSimulatorPaidAfter(0)))
+	ids := paidSimulatedPayments(t, client, 1)
+
+	// The same transaction listed many times is refunded once
+	reqs := make([]RefundRequest, 10)
+	for i := range reqs {
+		reqs[i] = RefundRequest{TransactionID: ids[0]}
+	}
+	result, err := client.RefundPayments(context.Background(), reqs, BatchOptions{Concurrency: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Summary.Succeeded != 1 || result.Summary.TotalRefunded != 100000 {
+		t.Fatalf("summary %+v", result.Summary)
+	}
+}
+
+func TestRefundPaymentsBalance(t *testing.T) {
+	transport := NewSimulatorTransport(WithSimulatorPaidAfter(0), WithSimulatorBalance(150000))
+	client, _, _ := newTestClient(t, testConfig(t), transport)
+	ids := paidSimulatedPayments(t, client, 2)
+	reqs := []RefundRequest{{TransactionID: ids[0]}, {TransactionID: ids[1]}}
+
+	// The total is checked before any refund is sent
+	if _, err := client.RefundPayments(context.Background(), reqs, BatchOptions{}); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("RefundPayments() error = %v", err)
+	}
+
+	// Unless asked to refund what the balance covers
+	result, err := client.RefundPayments(context.Background(), reqs, BatchOptions{Concurrency: 1, ContinueOnInsufficientFunds: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Items[0].Err != nil || !errors.Is(result.Items[1].Err, ErrInsufficientBalance) {
+		t.Fatalf("items %v, %v", result.Items[0].Err, result.Items[1].Err)
+	}
+	if result.Summary.Succeeded != 1 || result.Summary.TotalRefunded != 100000 {
+		t.Fatalf("summary %+v", result.Summary)
+	}
+}
+
+func TestRefundPaymentsCancelledMidBatch(t *testing.T) {
+	sim := NewSimulatorTransport(WithSimulatorPaidAfter(0))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	refunds := 0
+	transport := transportFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.Contains(req.URL.Path, "/refund") {
+			refunds++
+			// The operator gives up during the second refund
+			if refunds == 2 {
+				cancel()
+			}
+		}
+		return sim.Do(req)
+	})
+	client, _, _ := newTestClient(t, testConfig(t), transport)
+	ids := paidSimulatedPayments(t, client, 4)
+
+	reqs := make([]RefundRequest, len(ids))
+	for i, id := range ids {
+		reqs[i] = RefundRequest{TransactionID: id}
+	}
+	result, err := client.RefundPayments(ctx, reqs, BatchOptions{Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Items[0].Err != nil {
+		t.Fatalf("refund before the cancellation: %v", result.Items[0].Err)
+	}
+	for i, item := range result.Items[1:] {
+		if !errors.Is(item.Err, context.Canceled) || item.Refunded != 0 {
+			t.Errorf("item %d: %v, refunded %d", i+1, item.Err, item.Refunded)
+		}
+	}
+	if refunds != 2 {
+		t.Fatalf("%d refunds sent after cancelling during the second", refunds)
+	}
+	if result.Summary.Succeeded != 1 || result.Summary.Failed != 3 {
+		t.Fatalf("summary %+v", result.Summary)
+	}
+}
+
+func TestRefundBatchRoute(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(WithSimulatorPaidAfter(0)))
+	ids := paidSimulatedPayments(t, client, 1)
+	handler := client.Handler(WithRefundBatchRoute())
+
+	// The route allows few requests per minute, so each comes from its own address
+	batch := func(body, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/refund/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := batch(`{"refunds":[{"transaction_id":"`+ids[0]+`","amount":30000},{"transaction_id":"`+ids[0]+`","amount":90000}]}`, "203.0.113.41:1234")
+	var resp RefundBatchResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp.Status || len(resp.Results) != 2 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if resp.Results[0].StatusCode != http.StatusOK || resp.Results[0].Refund == nil || resp.Results[1].StatusCode == http.StatusOK || resp.Results[1].Error == nil {
+		t.Fatalf("results %s", rec.Body)
+	}
+	if resp.Summary.Succeeded != 1 || resp.Summary.TotalRefunded != 30000 {
+		t.Fatalf("summary %+v", resp.Summary)
+	}
+
+	// Batches are capped
+	var refunds []string
+	for i := 0; i <= MaxRefundBatchSize; i++ {
+		refunds = append(refunds, fmt.Sprintf(`{"transaction_id":"%d"}`, i+1))
+	}
+	for i, body := range []string{`{"refunds":[]}`, `{"refunds":[` + strings.Join(refunds, ",") + `]}`} {
+		if rec := batch(body, fmt.Sprintf("203.0.113.%d:1234", 42+i)); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	// The route needs the refund scope
+	scoped, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.ServerAPIKeys = []ServerKey{{Key: "key-reports", Scopes: []Scope{ScopeRead, ScopeWrite}}}
+	}), NewSimulatorTransport())
+	if rec := scopedRequest(scoped.Handler(WithRefundBatchRoute()), http.MethodPost, "/payments/refund/batch", "key-reports", "203.0.113.44:1234"); rec.Code != http.StatusForbidden {
+		t.Fatalf("key without the refund scope: status %d: %s", rec.Code, rec.Body)
+	}
+}
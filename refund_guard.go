@@ -0,0 +1,110 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// refund_guard.go implements the guard against refunding a transaction twice
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// refundedAmount returns how much of a transaction has been refunded, counting
+// every recorded refund the gateway hasn't rejected
+func (c *Client) refundedAmount(ctx context.Context, token string) (int64, error) {
+	storage, ok := c.storage.(RefundStorageInterface)
+	if !ok {
+		return 0, nil
+	}
+
+	refunds, err := storage.GetRefundsByTransaction(ctx, token)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list refunds: %w", err)
+	}
+
+	var refunded int64
+	for _, refund := range refunds {
+		if refund.Status != RefundStatusFailed {
+			refunded += refund.Amount
+		}
+	}
+	return refunded, nil
+}
+
+// findTransactionByTransID returns the stored transaction with a gateway
+// transaction ID, or nil when none is stored. Only paid, suspect and refunded
+// transactions are searched, as no other can be refunded.
+func (c *Client) findTransactionByTransID(ctx context.Context, transID int64) (*Transaction, error) {
+	for _, status := range []TransactionStatus{StatusPaid, StatusSuspect, StatusRefunded} {
+		transactions, err := c.storage.GetTransactionsByStatus(ctx, string(status))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s transactions: %w", status, err)
+		}
+
+		for _, transaction := range transactions {
+			if transaction.TransactionID == transID {
+				return transaction, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// beginRefund checks a refund against the recorded refunds of its transaction and
+// holds the transaction's token lock until release is called, so concurrent
+// refunds can't both pass. The transaction is nil when it isn't stored, e.g. when
+// it was paid through another integration; the gateway then decides alone.
+func (c *Client) beginRefund(ctx context.Context, transactionID string, amount int64) (*Transaction, func(), error) {
+	noop := func() {}
+
+	transID, err := strconv.ParseInt(transactionID, 10, 64)
+	if err != nil {
+		return nil, noop, nil
+	}
+
+	found, err := c.findTransactionByTransID(ctx, transID)
+	if err != nil {
+		return nil, noop, err
+	}
+	if found == nil {
+		return nil, noop, nil
+	}
+
+	// Read the transaction again under the lock so the check sees settled state
+	release := c.tokenLocks.Lock(found.Token)
+	transaction, err := c.storage.GetTransaction(ctx, found.Token)
+	if err != nil {
+		release()
+		return nil, noop, err
+	}
+
+	if err := c.checkRefundable(ctx, transaction, amount); err != nil {
+		release()
+		return nil, noop, err
+	}
+
+	return transaction, release, nil
+}
+
+// checkRefundable returns ErrAlreadyRefunded when a refund of amount (0 for the
+// full amount) would refund more than the transaction's amount
+func (c *Client) checkRefundable(ctx context.Context, transaction *Transaction, amount int64) error {
+	if transaction.Status == StatusRefunded {
+		return fmt.Errorf("%w: the transaction is marked refunded", ErrAlreadyRefunded)
+	}
+
+	refunded, err := c.refundedAmount(ctx, transaction.Token)
+	if err != nil {
+		return err
+	}
+
+	remaining := transaction.Amount - refunded
+	switch {
+	case remaining <= 0:
+		return fmt.Errorf("%w: the transaction was refunded in full", ErrAlreadyRefunded)
+	case amount == 0 && refunded > 0:
+		return fmt.Errorf("%w: %d Rials were refunded already, refund the remaining %d explicitly", ErrAlreadyRefunded, refunded, remaining)
+	case amount > remaining:
+		return fmt.Errorf("%w: only %d Rials remain refundable", ErrAlreadyRefunded, remaining)
+	}
+	return nil
+}
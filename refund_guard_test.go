@@ -0,0 +1,239 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storePaidTransaction stores the paid webhook payment with its gateway ID
+func storePaidTransaction(t *testing.T, storage *MemoryStorage, status TransactionStatus) {
+	t.Helper()
+
+	err := storage.StoreTransaction(context.Background(), &Transaction{
+		ID:            "tx-webhook",
+		Token:         webhookToken,
+		TransactionID: 160000000001,
+		Amount:        100000,
+		Status:        status,
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// refundAccepted answers a refund request as accepted
+func refundAccepted(refundID string) stubStep {
+	return jsonStep(http.StatusOK, map[string]interface{}{"status": true, "refund_id": refundID})
+}
+
+func TestRefundGuardPartialRefunds(t *testing.T) {
+	transport := newStubTransport(refundAccepted("refund-1"), refundAccepted("refund-2"))
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storePaidTransaction(t, storage, StatusPaid)
+	ctx := context.Background()
+
+	if _, err := client.RefundPayment(ctx, "160000000001", 40000); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither a full refund nor more than what is left can follow a partial one
+	for _, amount := range []int64{0, 60001} {
+		if _, err := client.RefundPayment(ctx, "160000000001", amount); !errors.Is(err, ErrAlreadyRefunded) {
+			t.Fatalf("refund of %d: %v", amount, err)
+		}
+	}
+
+	if _, err := client.RefundPayment(ctx, "160000000001", 60000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RefundPayment(ctx, "160000000001", 10000); !errors.Is(err, ErrAlreadyRefunded) {
+		t.Fatalf("refund after a full refund: %v", err)
+	}
+	if transport.count() != 2 {
+		t.Fatalf("%d gateway calls", transport.count())
+	}
+
+	refunds, err := storage.GetRefundsByTransaction(ctx, webhookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 2 {
+		t.Fatalf("%d refunds recorded", len(refunds))
+	}
+	for i, want := range []struct {
+		amount   int64
+		refundID string
+	}{{40000, "refund-1"}, {60000, "refund-2"}} {
+		refund := refunds[i]
+		if refund.Amount != want.amount || refund.RefundID != want.refundID || refund.TransID != 160000000001 || refund.Status != RefundStatusPending {
+			t.Fatalf("refund %d: %+v", i, refund)
+		}
+	}
+}
+
+func TestRefundGuardFullRefund(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t), newStubTransport(refundAccepted("")))
+	storePaidTransaction(t, storage, StatusPaid)
+	ctx := context.Background()
+
+	// A rejected earlier refund doesn't count
+	err := storage.StoreRefund(ctx, &Refund{
+		ID:               "refund-rejected",
+		TransactionToken: webhookToken,
+		Amount:           100000,
+		Status:           RefundStatusFailed,
+		CreatedAt:        time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A full refund accepted without a refund ID is recorded with the whole amount
+	if _, err := client.RefundPayment(ctx, "160000000001", 0); err != nil {
+		t.Fatal(err)
+	}
+	refunds, _ := storage.GetRefundsByStatus(ctx, RefundStatusPending)
+	if len(refunds) != 1 || refunds[0].Amount != 100000 || refunds[0].TransactionToken != webhookToken || refunds[0].RefundID != "" {
+		t.Fatalf("pending refunds %+v", refunds)
+	}
+
+	if _, err := client.RefundPayment(ctx, "160000000001", 0); !errors.Is(err, ErrAlreadyRefunded) {
+		t.Fatalf("second full refund: %v", err)
+	}
+}
+
+func TestRefundGuardRefundedTransaction(t *testing.T) {
+	transport := newStubTransport()
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storePaidTransaction(t, storage, StatusRefunded)
+
+	if _, err := client.RefundPayment(context.Background(), "160000000001", 10000); !errors.Is(err, ErrAlreadyRefunded) {
+		t.Fatalf("RefundPayment() error = %v", err)
+	}
+	if transport.count() != 0 {
+		t.Fatal("refunded transaction sent to the gateway")
+	}
+}
+
+func TestRefundGuardUnstoredTransaction(t *testing.T) {
+	transport := newStubTransport(refundAccepted("refund-1"))
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+
+	// The gateway decides on transactions paid elsewhere
+	if _, err := client.RefundPayment(context.Background(), "160000000099", 50000); err != nil {
+		t.Fatal(err)
+	}
+	refunds, _ := storage.GetRefundsByStatus(context.Background(), RefundStatusPending)
+	if transport.count() != 1 || len(refunds) != 1 || refunds[0].TransactionToken != "" || refunds[0].TransID != 160000000099 {
+		t.Fatalf("%d gateway calls, refunds %+v", transport.count(), refunds)
+	}
+}
+
+func TestRefundGuardConcurrentRefunds(t *testing.T) {
+	step := refundAccepted("refund-1")
+	step.delay = 20 * time.Millisecond
+	transport := newStubTransport(step, refundAccepted("refund-2"))
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storePaidTransaction(t, storage, StatusPaid)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = client.RefundPayment(context.Background(), "160000000001", 0)
+		}(i)
+	}
+	wg.Wait()
+
+	rejected := 0
+	for _, err := range errs {
+		if errors.Is(err, ErrAlreadyRefunded) {
+			rejected++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rejected != 1 || transport.count() != 1 {
+		t.Fatalf("%d refunds rejected after %d gateway calls", rejected, transport.count())
+	}
+}
+
+func TestRefundRouteGuard(t *testing.T) {
+	transport := newStubTransport(refundAccepted("refund-1"))
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storePaidTransaction(t, storage, StatusPaid)
+
+	body := `{"transaction_id":"160000000001","amount":100000}`
+	if rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/refund", body); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/refund", body); rec.Code != http.StatusConflict {
+		t.Fatalf("repeated refund: status %d: %s", rec.Code, rec.Body)
+	}
+	if transport.count() != 1 {
+		t.Fatalf("%d gateway calls", transport.count())
+	}
+}
+
+func TestTransactionDetail(t *testing.T) {
+	transport := newStubTransport(refundAccepted("refund-1"), refundAccepted("refund-2"))
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.AdminKey = "admin-key"
+	}), transport, WithClientClock(clock))
+	storePaidTransaction(t, storage, StatusPaid)
+
+	detail := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/payments/transactions/"+token, nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		req.Header.Set(AdminKeyHeader, "admin-key")
+		rec := httptest.NewRecorder()
+		client.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Without refunds the array is empty rather than absent
+	rec := detail(webhookToken)
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if string(got["refunds"]) != "[]" {
+		t.Fatalf("refunds %s", got["refunds"])
+	}
+
+	if _, err := client.RefundPayment(context.Background(), "160000000001", 30000); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := client.RefundPayment(context.Background(), "160000000001", 20000); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refunds are listed oldest first
+	rec = detail(webhookToken)
+	var transaction TransactionDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &transaction); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if transaction.Token != webhookToken || len(transaction.Refunds) != 2 ||
+		transaction.Refunds[0].RefundID != "refund-1" || transaction.Refunds[1].RefundID != "refund-2" {
+		t.Fatalf("detail %+v", transaction)
+	}
+
+	if rec := detail("tok-missing"); rec.Code != http.StatusNotFound {
+		t.Fatalf("missing transaction: status %d", rec.Code)
+	}
+	if _, err := client.GetTransactionDetail(context.Background(), ""); !IsValidationError(err) {
+		t.Fatalf("without a token: %v", err)
+	}
+}
@@ -0,0 +1,528 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// refund_tracker.go implements tracking refunds until the gateway settles them
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RefundStatus represents the settlement state of a refund
+type RefundStatus string
+
+const (
+	// RefundStatusPending is the state of an accepted refund that hasn't settled yet
+	RefundStatusPending RefundStatus = "PENDING"
+
+	// RefundStatusCompleted is the state of a settled refund
+	RefundStatusCompleted RefundStatus = "COMPLETED"
+
+	// RefundStatusFailed is the state of a refund the gateway rejected
+	RefundStatusFailed RefundStatus = "FAILED"
+
+	// RefundStatusAbandoned is the state of a refund that stayed pending past the
+	// tracker's maximum age; it is no longer polled
+	RefundStatusAbandoned RefundStatus = "ABANDONED"
+)
+
+const (
+	// defaultRefundPollInterval is how often pending refunds are polled
+	defaultRefundPollInterval = time.Minute
+
+	// defaultRefundMaxAge is how long a refund is polled before giving up
+	defaultRefundMaxAge = 72 * time.Hour
+)
+
+// Refund is the record of a refund of a stored transaction
+type Refund struct {
+	// ID is the unique identifier of the record
+	ID string `json:"id"`
+
+	// TransactionToken is the payment token of the refunded transaction, empty
+	// when the transaction isn't stored
+	TransactionToken string `json:"transaction_token,omitempty"`
+
+	// TransID is the gateway's ID of the refunded transaction
+	TransID int64 `json:"trans_id,omitempty"`
+
+	// Amount is the refunded amount in Rials
+	Amount int64 `json:"amount,omitempty"`
+
+	// Status is the settlement state of the refund
+	Status RefundStatus `json:"status"`
+
+	// RefundID is the gateway's refund identifier, empty when the gateway
+	// accepted the refund without one
+	RefundID string `json:"refund_id,omitempty"`
+
+	// GatewayStatus is the last refund status reported by the gateway
+	GatewayStatus string `json:"gateway_status,omitempty"`
+
+	// Polls is the number of status checks made so far
+	Polls int `json:"polls"`
+
+	// CreatedAt is when the refund was accepted
+	CreatedAt time.Time `json:"created_at"`
+
+	// UpdatedAt is when the record was last changed
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// CompletedAt is when the refund reached a terminal state
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// RefundStorageInterface is implemented by storages that can keep refund records;
+// refunds are only tracked when the client's storage implements it
+type RefundStorageInterface interface {
+	// StoreRefund saves a new refund
+	StoreRefund(ctx context.Context, refund *Refund) error
+
+	// GetRefund retrieves a refund by ID
+	GetRefund(ctx context.Context, id string) (*Refund, error)
+
+	// UpdateRefund updates an existing refund
+	UpdateRefund(ctx context.Context, refund *Refund) error
+
+	// GetRefundsByStatus retrieves refunds by their status
+	GetRefundsByStatus(ctx context.Context, status RefundStatus) ([]*Refund, error)
+
+	// GetRefundsByTransaction retrieves the refunds of the transaction with the
+	// given token, oldest first
+	GetRefundsByTransaction(ctx context.Context, token string) ([]*Refund, error)
+}
+
+// RefundStatusResponse represents a response to a refund status request
+type RefundStatusResponse struct {
+	// Status indicates if the request was successful
+	Status bool `json:"status"`
+
+	// RefundID is the ID of the refund
+	RefundID string `json:"refund_id,omitempty"`
+
+	// RefundStatus is the gateway's refund state, such as PENDING, DONE or FAILED
+	RefundStatus string `json:"refund_status,omitempty"`
+
+	// Amount is the refunded amount
+	Amount int64 `json:"amount,omitempty"`
+
+	// Message contains any message from the API
+	Message string `json:"message,omitempty"`
+
+	// Errors contains any error messages
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// State returns the normalized refund status of the response
+func (r *RefundStatusResponse) State() RefundStatus {
+	switch strings.ToUpper(r.RefundStatus) {
+	case "DONE", "SUCCEED", "SUCCESS", "COMPLETED", "SETTLED":
+		return RefundStatusCompleted
+	case "FAILED", "REJECTED", "CANCELED", "CANCELLED":
+		return RefundStatusFailed
+	default:
+		return RefundStatusPending
+	}
+}
+
+// GetRefund returns the current state of a refund from the gateway
+func (c *Client) GetRefund(ctx context.Context, refundID string) (*RefundStatusResponse, error) {
+	if refundID == "" {
+		return nil, NewValidationError("refund_id", "refund ID is required")
+	}
+
+	ctx, cancel := c.operationContext(ctx, operationStatus)
+	defer cancel()
+
+	// Make API request
+	respBody, _, err := c.makeRequest(ctx, http.MethodGet, c.refundStatusEndpoint(refundID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refund status: %w", err)
+	}
+
+	// Parse API response
+	var apiResp RefundStatusResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	if !apiResp.Status {
+		return &apiResp, fmt.Errorf("refund status check failed: %s", apiResp.Message)
+	}
+
+	return &apiResp, nil
+}
+
+// trackRefund stores a pending record for an accepted refund, linked to its
+// transaction when stored, for the double-refund guard and the RefundTracker.
+// Refunds accepted without a gateway refund ID are recorded but can't be polled.
+func (c *Client) trackRefund(ctx context.Context, transaction *Transaction, transactionID string, amount int64, resp *RefundResponse) {
+	storage, ok := c.storage.(RefundStorageInterface)
+	if !ok {
+		return
+	}
+
+	if resp.RefundID == "" {
+		c.log(ctx).Warn(ctx, "Refund accepted without a refund ID, it can't be tracked", map[string]interface{}{
+			"transaction_id": transactionID,
+		})
+	}
+
+	now := c.clock.Now()
+	refund := &Refund{
+		ID:        c.idGenerator().NewTransactionID(),
+		Amount:    resp.Amount,
+		Status:    RefundStatusPending,
+		RefundID:  resp.RefundID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	refund.TransID, _ = strconv.ParseInt(transactionID, 10, 64)
+	if refund.Amount == 0 {
+		refund.Amount = amount
+	}
+	if transaction != nil {
+		refund.TransactionToken = transaction.Token
+		if refund.Amount == 0 {
+			// A full refund returns whatever earlier refunds left
+			refunded, err := c.refundedAmount(ctx, transaction.Token)
+			if err == nil {
+				refund.Amount = transaction.Amount - refunded
+			}
+		}
+	}
+
+	if err := storage.StoreRefund(ctx, refund); err != nil {
+		c.log(ctx).Error(ctx, "Failed to store refund", err, refundLogFields(refund))
+	}
+}
+
+// RefundTrackerOption configures a RefundTracker
+type RefundTrackerOption func(*RefundTracker)
+
+// WithRefundPollInterval sets how often pending refunds are polled
+func WithRefundPollInterval(interval time.Duration) RefundTrackerOption {
+	return func(t *RefundTracker) {
+		if interval > 0 {
+			t.interval = interval
+		}
+	}
+}
+
+// WithRefundMaxAge sets how long a refund is polled before it is abandoned
+func WithRefundMaxAge(maxAge time.Duration) RefundTrackerOption {
+	return func(t *RefundTracker) {
+		if maxAge > 0 {
+			t.maxAge = maxAge
+		}
+	}
+}
+
+// WithRefundBackoff sets the strategy deciding how long a refund waits between
+// polls, counted from its last poll; refunds are still only polled on the tracker's
+// interval. A strategy reporting done abandons the refund. By default every
+// pending refund is polled on every interval.
+func WithRefundBackoff(strategy BackoffStrategy) RefundTrackerOption {
+	return func(t *RefundTracker) {
+		if strategy != nil {
+			t.backoff = strategy
+		}
+	}
+}
+
+// RefundTracker polls pending refunds until the gateway settles them, updating
+// the stored records and firing the OnRefundCompleted and OnRefundFailed hooks.
+// All state lives in storage, so a restarted tracker resumes where it left off.
+type RefundTracker struct {
+	client   *Client
+	storage  RefundStorageInterface
+	interval time.Duration
+	maxAge   time.Duration
+	backoff  BackoffStrategy
+}
+
+// NewRefundTracker creates a tracker for the refunds in the client's storage
+func NewRefundTracker(client *Client, opts ...RefundTrackerOption) (*RefundTracker, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client cannot be nil")
+	}
+
+	storage, ok := client.storage.(RefundStorageInterface)
+	if !ok {
+		return nil, fmt.Errorf("%w: storage does not implement RefundStorageInterface", ErrInvalidConfig)
+	}
+
+	t := &RefundTracker{
+		client:   client,
+		storage:  storage,
+		interval: defaultRefundPollInterval,
+		maxAge:   defaultRefundMaxAge,
+		backoff:  &FixedBackoff{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t, nil
+}
+
+// Run polls pending refunds immediately and then every poll interval until the
+// context is cancelled
+func (t *RefundTracker) Run(ctx context.Context) error {
+	ticker := t.client.clock.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := t.Poll(ctx); err != nil && ctx.Err() == nil {
+			t.client.log(ctx).Error(ctx, "Failed to poll pending refunds", err, nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+	}
+}
+
+// Poll checks every pending refund once
+func (t *RefundTracker) Poll(ctx context.Context) error {
+	refunds, err := t.storage.GetRefundsByStatus(ctx, RefundStatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to list pending refunds: %w", err)
+	}
+
+	for _, refund := range refunds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t.pollRefund(ctx, refund)
+	}
+
+	return nil
+}
+
+// pollRefund checks one pending refund and records the outcome
+func (t *RefundTracker) pollRefund(ctx context.Context, refund *Refund) {
+	c := t.client
+
+	// Give up on refunds that never settle
+	if c.clock.Now().Sub(refund.CreatedAt) > t.maxAge {
+		t.finish(ctx, refund, RefundStatusAbandoned)
+		c.log(ctx).Error(ctx, "Refund did not settle in time, giving up", nil, refundLogFields(refund))
+		return
+	}
+
+	// Refunds without a gateway ID can't be polled; they are abandoned at max age
+	if refund.RefundID == "" {
+		return
+	}
+
+	// Wait out the backoff since the last poll
+	if refund.Polls > 0 {
+		delay, done := backoffSequence(t.backoff).NextDelay(refund.Polls, nil)
+		if done {
+			t.finish(ctx, refund, RefundStatusAbandoned)
+			c.log(ctx).Error(ctx, "Refund did not settle within the backoff's retries, giving up", nil, refundLogFields(refund))
+			return
+		}
+		if c.clock.Now().Before(refund.UpdatedAt.Add(delay)) {
+			return
+		}
+	}
+
+	resp, err := c.GetRefund(ctx, refund.RefundID)
+	refund.Polls++
+	refund.UpdatedAt = c.clock.Now()
+	if err != nil {
+		c.log(ctx).Warn(ctx, "Failed to check refund status", mergeFields(refundLogFields(refund), map[string]interface{}{
+			"error": err.Error(),
+		}))
+		t.update(ctx, refund)
+		return
+	}
+
+	refund.GatewayStatus = resp.RefundStatus
+	status := resp.State()
+	if status == RefundStatusPending {
+		t.update(ctx, refund)
+		return
+	}
+
+	t.finish(ctx, refund, status)
+	switch status {
+	case RefundStatusCompleted:
+		c.fireRefundCompleted(ctx, refund)
+	case RefundStatusFailed:
+		c.fireRefundFailed(ctx, refund)
+	}
+}
+
+// finish moves a refund to a terminal status
+func (t *RefundTracker) finish(ctx context.Context, refund *Refund, status RefundStatus) {
+	now := t.client.clock.Now()
+	refund.Status = status
+	refund.UpdatedAt = now
+	refund.CompletedAt = &now
+	t.update(ctx, refund)
+}
+
+// update stores a changed refund record
+func (t *RefundTracker) update(ctx context.Context, refund *Refund) {
+	if err := t.storage.UpdateRefund(ctx, refund); err != nil {
+		t.client.log(ctx).Error(ctx, "Failed to update refund", err, refundLogFields(refund))
+	}
+}
+
+// refundLogFields returns the log fields of a refund
+func refundLogFields(refund *Refund) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        refund.ID,
+		"refund_id": refund.RefundID,
+		"trans_id":  refund.TransID,
+		"token":     redactToken(refund.TransactionToken),
+		"status":    refund.Status,
+		"polls":     refund.Polls,
+	}
+}
+
+// StoreRefund saves a new refund
+func (s *MemoryStorage) StoreRefund(ctx context.Context, refund *Refund) error {
+	if refund == nil {
+		return fmt.Errorf("refund cannot be nil")
+	}
+
+	if refund.ID == "" {
+		return fmt.Errorf("refund ID cannot be empty")
+	}
+
+	if err := s.enter(ctx); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.refunds[refund.ID]; exists {
+		return fmt.Errorf("refund already exists: %s", refund.ID)
+	}
+
+	refundCopy := *refund
+	s.refunds[refund.ID] = &refundCopy
+
+	return nil
+}
+
+// GetRefund retrieves a refund by ID
+func (s *MemoryStorage) GetRefund(ctx context.Context, id string) (*Refund, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	refund, exists := s.refunds[id]
+	if !exists {
+		return nil, fmt.Errorf("refund not found: %s", id)
+	}
+
+	refundCopy := *refund
+	return &refundCopy, nil
+}
+
+// UpdateRefund updates an existing refund
+func (s *MemoryStorage) UpdateRefund(ctx context.Context, refund *Refund) error {
+	if refund == nil {
+		return fmt.Errorf("refund cannot be nil")
+	}
+
+	if refund.ID == "" {
+		return fmt.Errorf("refund ID cannot be empty")
+	}
+
+	if err := s.enter(ctx); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.refunds[refund.ID]; !exists {
+		return fmt.Errorf("refund not found: %s", refund.ID)
+	}
+
+	refundCopy := *refund
+	s.refunds[refund.ID] = &refundCopy
+
+	return nil
+}
+
+// GetRefundsByStatus retrieves refunds by their status
+func (s *MemoryStorage) GetRefundsByStatus(ctx context.Context, status RefundStatus) ([]*Refund, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	var result []*Refund
+	for _, refund := range s.refunds {
+		if refund.Status == status {
+			refundCopy := *refund
+			result = append(result, &refundCopy)
+		}
+	}
+
+	return result, nil
+}
+
+// GetRefundsByTransaction retrieves the refunds of the transaction with the given
+// token, oldest first
+func (s *MemoryStorage) GetRefundsByTransaction(ctx context.Context, token string) ([]*Refund, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	var result []*Refund
+	for _, refund := range s.refunds {
+		if refund.TransactionToken == token {
+			refundCopy := *refund
+			result = append(result, &refundCopy)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+
+	return result, nil
+}
@@ -0,0 +1,240 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// refundCounts counts the refund hooks fired by a RefundTracker
+type refundCounts struct {
+	completed atomic.Int32
+	failed    atomic.Int32
+}
+
+func (c *refundCounts) hooks() Hooks {
+	return Hooks{
+		OnRefundCompleted: func(ctx context.Context, refund *Refund) { c.completed.Add(1) },
+		OnRefundFailed:    func(ctx context.Context, refund *Refund) { c.failed.Add(1) },
+	}
+}
+
+// pendingRefund pays and refunds a payment through the client, returning the
+// pending refund record
+func pendingRefund(t *testing.T, client *Client, storage *MemoryStorage) *Refund {
+	t.Helper()
+
+	ctx := context.Background()
+	init, err := client.InitiatePayment(ctx, 100000, "Order 1042", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := client.VerifyPayment(ctx, init.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RefundPayment(ctx, strconv.FormatInt(verified.TransID, 10), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	refunds, err := storage.GetRefundsByStatus(ctx, RefundStatusPending)
+	if err != nil || len(refunds) != 1 {
+		t.Fatalf("pending refunds %v, %v", refunds, err)
+	}
+	return refunds[0]
+}
+
+func TestRefundTrackerSettlesAfterPolls(t *testing.T) {
+	counts := &refundCounts{}
+	simulator := NewSimulatorTransport(WithSimulatorPaidAfter(0), WithSimulatorRefundSettledAfter(2))
+	client, storage, _ := newTestClient(t, testConfig(t), simulator, WithClientHooks(counts.hooks()))
+	refund := pendingRefund(t, client, storage)
+	ctx := context.Background()
+
+	if refund.RefundID == "" || refund.Amount != 100000 || refund.TransactionToken == "" {
+		t.Fatalf("tracked refund %+v", refund)
+	}
+
+	tracker, err := NewRefundTracker(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The refund stays pending through the simulator's first lookups
+	for poll := 1; poll <= 2; poll++ {
+		if err := tracker.Poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+		stored, _ := storage.GetRefund(ctx, refund.ID)
+		if stored.Status != RefundStatusPending || stored.Polls != poll || stored.GatewayStatus != "PENDING" {
+			t.Fatalf("after poll %d: %+v", poll, stored)
+		}
+	}
+
+	// A restarted tracker picks the pending refund up from storage
+	restarted, err := NewRefundTracker(client.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := storage.GetRefund(ctx, refund.ID)
+	if stored.Status != RefundStatusCompleted || stored.Polls != 3 || stored.CompletedAt == nil {
+		t.Fatalf("after settling: %+v", stored)
+	}
+	if counts.completed.Load() != 1 || counts.failed.Load() != 0 {
+		t.Fatalf("%d completed and %d failed hooks", counts.completed.Load(), counts.failed.Load())
+	}
+
+	// Settled refunds aren't polled again
+	if err := restarted.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := storage.GetRefund(ctx, refund.ID); stored.Polls != 3 || counts.completed.Load() != 1 {
+		t.Fatalf("settled refund polled again: %+v", stored)
+	}
+}
+
+func TestRefundTrackerFailedRefund(t *testing.T) {
+	counts := &refundCounts{}
+	transport := newStubTransport(
+		jsonStep(http.StatusOK, map[string]interface{}{"status": true, "refund_status": "PENDING"}),
+		jsonStep(http.StatusInternalServerError, map[string]interface{}{"status": false}),
+		jsonStep(http.StatusOK, map[string]interface{}{"status": true, "refund_status": "REJECTED"}),
+	)
+	client, storage, logger := newTestClient(t, testConfig(t, func(c *Config) { c.MaxRetries = 0 }), transport, WithClientHooks(counts.hooks()))
+	ctx := context.Background()
+
+	now := time.Now()
+	refund := &Refund{ID: "refund-1", RefundID: "gw-refund-1", Amount: 50000, Status: RefundStatusPending, CreatedAt: now, UpdatedAt: now}
+	if err := storage.StoreRefund(ctx, refund); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker, err := NewRefundTracker(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := tracker.Poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failed lookup is logged and counted, and the next poll carries on
+	if _, ok := logger.find("Failed to check refund status"); !ok {
+		t.Fatalf("lookup failure not logged:\n%s", logger.dump())
+	}
+	stored, _ := storage.GetRefund(ctx, refund.ID)
+	if stored.Status != RefundStatusFailed || stored.GatewayStatus != "REJECTED" || stored.Polls != 3 {
+		t.Fatalf("stored %+v", stored)
+	}
+	if counts.failed.Load() != 1 || counts.completed.Load() != 0 {
+		t.Fatalf("%d completed and %d failed hooks", counts.completed.Load(), counts.failed.Load())
+	}
+}
+
+func TestRefundTrackerGivesUpAtMaxAge(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": true, "refund_status": "PENDING"}))
+	client, storage, logger := newTestClient(t, testConfig(t), transport, WithClientClock(clock))
+	ctx := context.Background()
+
+	for _, refund := range []*Refund{
+		{ID: "polled", RefundID: "gw-refund-1"},
+		{ID: "untrackable"},
+	} {
+		refund.Status, refund.CreatedAt, refund.UpdatedAt = RefundStatusPending, clock.Now(), clock.Now()
+		if err := storage.StoreRefund(ctx, refund); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker, err := NewRefundTracker(client, WithRefundMaxAge(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if transport.count() != 1 {
+		t.Fatalf("%d gateway lookups, want only the refund with an ID", transport.count())
+	}
+
+	clock.Advance(time.Hour + time.Second)
+	if err := tracker.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"polled", "untrackable"} {
+		if stored, _ := storage.GetRefund(ctx, id); stored.Status != RefundStatusAbandoned || stored.CompletedAt == nil {
+			t.Fatalf("%s: %+v", id, stored)
+		}
+	}
+	if logged := logger.at("error"); len(logged) != 2 || logged[0].message != "Refund did not settle in time, giving up" {
+		t.Fatalf("error logs:\n%s", logger.dump())
+	}
+	if transport.count() != 1 {
+		t.Fatal("abandoned refund was looked up")
+	}
+}
+
+func TestRefundTrackerRun(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	simulator := NewSimulatorTransport(WithSimulatorPaidAfter(0), WithSimulatorRefundSettledAfter(1))
+	client, storage, _ := newTestClient(t, testConfig(t), simulator, WithClientClock(clock))
+	refund := pendingRefund(t, client, storage)
+
+	tracker, err := NewRefundTracker(client, WithRefundPollInterval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- tracker.Run(ctx) }()
+
+	// Run polls at once and then on every tick
+	waitForRefund := func(status RefundStatus, polls int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if stored, _ := storage.GetRefund(context.Background(), refund.ID); stored.Status == status && stored.Polls == polls {
+				return
+			}
+		}
+		stored, _ := storage.GetRefund(context.Background(), refund.ID)
+		t.Fatalf("refund %+v, want %s after %d polls", stored, status, polls)
+	}
+	waitForRefund(RefundStatusPending, 1)
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	waitForRefund(RefundStatusCompleted, 2)
+
+	cancel()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop after cancellation")
+	}
+}
+
+func TestNewRefundTrackerRequiresRefundStorage(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), nil)
+	client.storage = unpatchableStorage{NewMemoryStorage()}
+
+	if _, err := NewRefundTracker(client); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("NewRefundTracker() = %v", err)
+	}
+	if _, err := NewRefundTracker(nil); err == nil {
+		t.Fatal("tracker without a client created")
+	}
+}
@@ -0,0 +1,194 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// response_encoder.go implements customizable JSON response encoding
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// responseEncoderKey is the context key for the response encoder used by middleware
+const responseEncoderKey contextKey = "response_encoder"
+
+// ResponseEncoder controls the JSON written by handlers and middleware
+type ResponseEncoder interface {
+	// ErrorEnvelope builds the error body for an error and an optional message override
+	ErrorEnvelope(err error, message string) interface{}
+
+	// EncodePayload transforms a payload, including error envelopes, before it is marshaled
+	EncodePayload(payload interface{}) (interface{}, error)
+}
+
+// defaultResponseEncoder writes payloads unchanged with the standard error envelope
+type defaultResponseEncoder struct{}
+
+// DefaultResponseEncoder returns the encoder used when none is configured
+func DefaultResponseEncoder() ResponseEncoder {
+	return defaultResponseEncoder{}
+}
+
+// ErrorEnvelope returns the standard status/message/errors envelope
+func (defaultResponseEncoder) ErrorEnvelope(err error, message string) interface{} {
+	envelope := APIErrorResponse(err)
+	if message != "" {
+		envelope["message"] = message
+	}
+	return envelope
+}
+
+// EncodePayload returns the payload unchanged
+func (defaultResponseEncoder) EncodePayload(payload interface{}) (interface{}, error) {
+	return payload, nil
+}
+
+// keyCaseEncoder rewrites every JSON object key with a naming function
+type keyCaseEncoder struct {
+	defaultResponseEncoder
+	rename func(string) string
+}
+
+// SnakeCaseResponseEncoder returns an encoder that writes all object keys in snake_case
+func SnakeCaseResponseEncoder() ResponseEncoder {
+	return keyCaseEncoder{rename: toSnakeCase}
+}
+
+// CamelCaseResponseEncoder returns an encoder that writes all object keys in camelCase
+func CamelCaseResponseEncoder() ResponseEncoder {
+	return keyCaseEncoder{rename: toCamelCase}
+}
+
+// EncodePayload round-trips the payload through JSON and renames its keys
+func (e keyCaseEncoder) EncodePayload(payload interface{}) (interface{}, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep numbers exact rather than converting them to float64
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	return e.renameKeys(generic), nil
+}
+
+// renameKeys applies the naming function to keys of nested objects
+func (e keyCaseEncoder) renameKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			renamed[e.rename(key)] = e.renameKeys(item)
+		}
+		return renamed
+	case []interface{}:
+		for i, item := range v {
+			v[i] = e.renameKeys(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// toSnakeCase converts camelCase and PascalCase keys to snake_case
+func toSnakeCase(key string) string {
+	var b strings.Builder
+	runes := []rune(key)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a new word unless this continues an acronym
+			if i > 0 && runes[i-1] != '_' &&
+				(unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// toCamelCase converts snake_case keys to camelCase
+func toCamelCase(key string) string {
+	parts := strings.Split(key, "_")
+	var b strings.Builder
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString(part)
+			continue
+		}
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	if b.Len() == 0 {
+		return key
+	}
+	return b.String()
+}
+
+// ResponseEncoderMiddleware makes an encoder available to middleware that writes JSON errors
+func ResponseEncoderMiddleware(encoder ResponseEncoder) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if encoder == nil {
+				next(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), responseEncoderKey, encoder)
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// responseEncoderFromRequest returns the encoder stored in the request context or the default
+func responseEncoderFromRequest(r *http.Request) ResponseEncoder {
+	if encoder, ok := r.Context().Value(responseEncoderKey).(ResponseEncoder); ok {
+		return encoder
+	}
+	return DefaultResponseEncoder()
+}
+
+// writeJSONError writes an error envelope from middleware using the request's encoder
+func writeJSONError(w http.ResponseWriter, r *http.Request, statusCode int, err error, message string) {
+	writeJSONErrorWithDetails(w, r, statusCode, err, message, nil)
+}
+
+// writeJSONErrorWithDetails writes an error envelope with extra top-level fields,
+// which are dropped when the encoder's envelope isn't a JSON object
+func writeJSONErrorWithDetails(w http.ResponseWriter, r *http.Request, statusCode int, err error, message string, details map[string]interface{}) {
+	encoder := responseEncoderFromRequest(r)
+
+	envelope := encoder.ErrorEnvelope(err, message)
+	if fields, ok := envelope.(map[string]interface{}); ok {
+		for key, value := range details {
+			fields[key] = value
+		}
+	}
+
+	payload, encodeErr := encoder.EncodePayload(envelope)
+	if encodeErr != nil {
+		http.Error(w, message, statusCode)
+		return
+	}
+
+	var writeErr *responseWriteError
+	if encodeErr := writeJSON(w, statusCode, payload); encodeErr != nil && !errors.As(encodeErr, &writeErr) {
+		http.Error(w, message, statusCode)
+	}
+}
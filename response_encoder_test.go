@@ -0,0 +1,206 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseEncoders(t *testing.T) {
+	createdAt := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		encoder     ResponseEncoder
+		envelope    map[string]interface{}
+		transaction map[string]interface{}
+		notAllowed  []string
+	}{
+		{
+			name:    "default",
+			encoder: nil,
+			envelope: map[string]interface{}{
+				"status":  false,
+				"message": "Validation failed",
+				"errors": map[string]interface{}{
+					"amount":       "amount must be at least 10000 Rials",
+					"callback_url": "callback URL must be a valid HTTP(S) URL",
+				},
+			},
+			transaction: map[string]interface{}{
+				"id":             "tx-1042",
+				"token":          webhookToken,
+				"amount":         float64(100000),
+				"status":         "PAID",
+				"type":           "payment",
+				"description":    "Order 1042",
+				"factor_number":  "1042",
+				"callback_url":   "https://shop.example.com/callback",
+				"metadata":       map[string]interface{}{"channel": "web"},
+				"transaction_id": float64(160000000001),
+				"card_number":    "************1234",
+				"created_at":     "2026-10-16T12:00:00Z",
+				"updated_at":     "2026-10-16T12:05:00Z",
+				"refunds":        []interface{}{},
+			},
+			notAllowed: []string{"allowed_methods", "message", "openapi_path", "route", "status"},
+		},
+		{
+			name:    "snake_case",
+			encoder: SnakeCaseResponseEncoder(),
+			envelope: map[string]interface{}{
+				"status":  false,
+				"message": "Validation failed",
+				"errors": map[string]interface{}{
+					"amount":       "amount must be at least 10000 Rials",
+					"callback_url": "callback URL must be a valid HTTP(S) URL",
+				},
+			},
+			transaction: map[string]interface{}{
+				"id":             "tx-1042",
+				"token":          webhookToken,
+				"amount":         float64(100000),
+				"status":         "PAID",
+				"type":           "payment",
+				"description":    "Order 1042",
+				"factor_number":  "1042",
+				"callback_url":   "https://shop.example.com/callback",
+				"metadata":       map[string]interface{}{"channel": "web"},
+				"transaction_id": float64(160000000001),
+				"card_number":    "************1234",
+				"created_at":     "2026-10-16T12:00:00Z",
+				"updated_at":     "2026-10-16T12:05:00Z",
+				"refunds":        []interface{}{},
+			},
+			notAllowed: []string{"allowed_methods", "message", "openapi_path", "route", "status"},
+		},
+		{
+			name:    "camelCase",
+			encoder: CamelCaseResponseEncoder(),
+			envelope: map[string]interface{}{
+				"status":  false,
+				"message": "Validation failed",
+				"errors": map[string]interface{}{
+					"amount":      "amount must be at least 10000 Rials",
+					"callbackUrl": "callback URL must be a valid HTTP(S) URL",
+				},
+			},
+			transaction: map[string]interface{}{
+				"id":            "tx-1042",
+				"token":         webhookToken,
+				"amount":        float64(100000),
+				"status":        "PAID",
+				"type":          "payment",
+				"description":   "Order 1042",
+				"factorNumber":  "1042",
+				"callbackUrl":   "https://shop.example.com/callback",
+				"metadata":      map[string]interface{}{"channel": "web"},
+				"transactionId": float64(160000000001),
+				"cardNumber":    "************1234",
+				"createdAt":     "2026-10-16T12:00:00Z",
+				"updatedAt":     "2026-10-16T12:05:00Z",
+				"refunds":       []interface{}{},
+			},
+			notAllowed: []string{"allowedMethods", "message", "openapiPath", "route", "status"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, func(c *Config) {
+				c.AdminKey = "admin-key"
+			})
+			client, storage, _ := newTestClient(t, config, nil, WithClientResponseEncoder(tt.encoder))
+			err := storage.StoreTransaction(context.Background(), &Transaction{
+				ID:            "tx-1042",
+				Token:         webhookToken,
+				Amount:        100000,
+				Status:        StatusPaid,
+				Type:          TransactionTypePayment,
+				Description:   "Order 1042",
+				FactorNumber:  "1042",
+				CallbackURL:   "https://shop.example.com/callback",
+				Metadata:      map[string]string{"channel": "web"},
+				TransactionID: 160000000001,
+				CardNumber:    "603799******1234",
+				CreatedAt:     createdAt,
+				UpdatedAt:     createdAt.Add(5 * time.Minute),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := client.Handler()
+
+			send := func(method, path, body string, wantStatus int) map[string]interface{} {
+				t.Helper()
+
+				req := httptest.NewRequest(method, path, strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer "+testAPIKey)
+				req.Header.Set(AdminKeyHeader, "admin-key")
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				var decoded map[string]interface{}
+				if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil || rec.Code != wantStatus {
+					t.Fatalf("%s %s: status %d: %s", method, path, rec.Code, rec.Body)
+				}
+				return decoded
+			}
+
+			// An error envelope written by a handler
+			envelope := send(http.MethodPost, "/payments/init", `{"amount":10,"callback_url":"not a url"}`, http.StatusUnprocessableEntity)
+			if !reflect.DeepEqual(envelope, tt.envelope) {
+				t.Errorf("error envelope\n got %v\nwant %v", envelope, tt.envelope)
+			}
+
+			// A transaction payload with multi-word field names
+			transaction := send(http.MethodGet, "/payments/transactions/"+webhookToken, "", http.StatusOK)
+			if !reflect.DeepEqual(transaction, tt.transaction) {
+				t.Errorf("transaction\n got %v\nwant %v", transaction, tt.transaction)
+			}
+
+			// An error envelope written by the router rather than a handler
+			notAllowed := send(http.MethodGet, "/payments/init", "", http.StatusMethodNotAllowed)
+			keys := make([]string, 0, len(notAllowed))
+			for key := range notAllowed {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.notAllowed) {
+				t.Errorf("405 envelope keys %v, want %v", keys, tt.notAllowed)
+			}
+		})
+	}
+}
+
+func TestResponseKeyCase(t *testing.T) {
+	tests := []struct {
+		key, snake, camel string
+	}{
+		{"amount", "amount", "amount"},
+		{"callback_url", "callback_url", "callbackUrl"},
+		{"factorNumber", "factor_number", "factorNumber"},
+		{"TransID", "trans_id", "TransID"},
+		{"requestID", "request_id", "requestID"},
+		{"HTTPStatus", "http_status", "HTTPStatus"},
+		{"_private", "_private", "private"},
+		{"retry__after_", "retry__after_", "retryAfter"},
+		{"_", "_", "_"},
+	}
+
+	for _, tt := range tests {
+		if got := toSnakeCase(tt.key); got != tt.snake {
+			t.Errorf("toSnakeCase(%q) = %q, want %q", tt.key, got, tt.snake)
+		}
+		if got := toCamelCase(tt.key); got != tt.camel {
+			t.Errorf("toCamelCase(%q) = %q, want %q", tt.key, got, tt.camel)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// retry.go implements deadline-aware retries for Vandar API requests
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultMinAttemptBudget is used when Config.MinAttemptBudget is not set
+const defaultMinAttemptBudget = 500 * time.Millisecond
+
+// doWithRetry performs a request, retrying transient failures with the waits of
+// the call's backoff strategy. Retries that cannot complete within the caller's
+// deadline are skipped, and each attempt's timeout is capped to the remaining budget.
+// Requests that aren't idempotent, such as refunds and transfers, are only
+// repeated when the failed attempt never reached the gateway.
+func (c *Client) doWithRetry(ctx context.Context, method, endpoint string, jsonData []byte) ([]byte, int, error) {
+	values := configValues(c.config)
+	idempotent := c.idempotentRequest(method, endpoint)
+
+	maxAttempts := values.MaxRetries + 1
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	minBudget := values.MinAttemptBudget
+	if minBudget <= 0 {
+		minBudget = defaultMinAttemptBudget
+	}
+
+	backoff := c.retryStrategy(ctx)
+
+	var (
+		respBody   []byte
+		statusCode int
+		err        error
+	)
+
+	for attempt := 1; ; attempt++ {
+		respBody, statusCode, err = c.hedgedAttempt(ctx, method, endpoint, jsonData)
+		if err == nil || !isRetryable(ctx, statusCode, err) {
+			break
+		}
+
+		// Money may already have moved if the request was sent
+		if !idempotent && !requestNotSent(err) {
+			break
+		}
+
+		// The caller's deadline ran out during the attempt
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, statusCode, fmt.Errorf("%w: deadline exceeded after %d of %d attempts: %v", ErrTimeout, attempt, maxAttempts, err)
+		}
+
+		if attempt >= maxAttempts {
+			break
+		}
+
+		wait, done := backoff.NextDelay(attempt, err)
+		if done {
+			break
+		}
+
+		// Skip retries that can't plausibly complete before the deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait+minBudget {
+			return nil, statusCode, fmt.Errorf("%w: no budget left for retry after %d of %d attempts: %v", ErrTimeout, attempt, maxAttempts, err)
+		}
+
+		c.log(ctx).Warn(ctx, "Retrying API request", map[string]interface{}{
+			"method":      method,
+			"endpoint":    redactEndpoint(endpoint),
+			"attempt":     attempt,
+			"status_code": statusCode,
+			"wait_ms":     wait.Milliseconds(),
+			"error":       err.Error(),
+		})
+
+		timer := c.clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil, statusCode, fmt.Errorf("cancelled while waiting to retry after %d of %d attempts: %w", attempt, maxAttempts, ctx.Err())
+			}
+			return nil, statusCode, fmt.Errorf("%w: deadline exceeded while waiting to retry after %d of %d attempts: %v", ErrTimeout, attempt, maxAttempts, err)
+		case <-timer.C():
+		}
+	}
+
+	return respBody, statusCode, err
+}
+
+// doAttempt performs a single attempt bounded by the client timeout and the remaining budget
+func (c *Client) doAttempt(ctx context.Context, method, endpoint string, jsonData []byte) ([]byte, int, error) {
+	timeout := c.requestTimeout
+	if timeout <= 0 {
+		timeout = time.Duration(c.config.GetTimeout()) * time.Second
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); timeout <= 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+
+	// Wait for a free slot before dialing; the wait doesn't count against the attempt
+	release, err := c.gatewayCalls.acquire(ctx, c.clock, c.metrics)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	respBody, statusCode, err := c.doRequest(ctx, method, endpoint, jsonData)
+	c.metrics.ObserveDuration(MetricGatewayRequestDuration, time.Since(start), map[string]string{
+		"method":      method,
+		"endpoint":    c.endpointName(endpoint),
+		"status_code": strconv.Itoa(statusCode),
+	})
+
+	// Requests given up on say nothing about the gateway's health
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		c.health.recordGateway(c.clock.Now(), time.Since(start), statusCode, err)
+	}
+
+	// A timed out attempt is reported as a timeout rather than a generic failure
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(err, ErrTimeout) {
+		err = fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+
+	return respBody, statusCode, err
+}
+
+// isRetryable reports whether a failed attempt is worth repeating
+func isRetryable(ctx context.Context, statusCode int, err error) bool {
+	// The caller gave up, retrying would be pointless
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return false
+	}
+
+	// A missing replay fixture won't appear on a retry
+	if errors.Is(err, ErrNoFixture) {
+		return false
+	}
+
+	// The request already waited for a free slot
+	if errors.Is(err, ErrTooManyInFlight) {
+		return false
+	}
+
+	// Maintenance pages may come with any status code
+	if errors.Is(err, ErrGatewayUnavailable) {
+		return true
+	}
+
+	switch statusCode {
+	case 0:
+		// No response was received
+		return IsNetworkError(err)
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// idempotentRequest reports whether sending a request twice has the same effect
+// as sending it once: lookups and payment verification
+func (c *Client) idempotentRequest(method, endpoint string) bool {
+	if method == http.MethodGet || method == http.MethodHead {
+		return true
+	}
+
+	endpoints := c.endpoints()
+	return endpoint == endpoints.Verify || endpoint == endpoints.Transaction
+}
+
+// requestNotSent reports whether an attempt failed before the request could be
+// written, so the gateway can't have acted on it
+func requestNotSent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// timeoutHint formats the remaining budget for the X-Timeout request header
+func timeoutHint(ctx context.Context) (string, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return "", false
+	}
+
+	remaining := time.Until(deadline).Milliseconds()
+	if remaining < 1 {
+		remaining = 1
+	}
+
+	return strconv.FormatInt(remaining, 10), true
+}
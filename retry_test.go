@@ -0,0 +1,259 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryIdempotentRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   func(c *Client) string
+	}{
+		{"status lookup", http.MethodGet, func(c *Client) string { return c.statusEndpoint("tok") }},
+		{"verify", http.MethodPost, func(c *Client) string { return c.endpoints().Verify }},
+		{"transaction info", http.MethodPost, func(c *Client) string { return c.endpoints().Transaction }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newStubTransport(
+				stubStep{status: http.StatusBadGateway, body: `{"message":"bad gateway"}`},
+				jsonStep(http.StatusOK, map[string]interface{}{"status": 1}),
+			)
+			client, _, _ := newTestClient(t, testConfig(t), transport)
+
+			_, statusCode, err := client.makeRequest(context.Background(), tt.method, tt.path(client), map[string]string{"token": "tok"})
+			if err != nil {
+				t.Fatalf("makeRequest() error = %v", err)
+			}
+			if statusCode != http.StatusOK || transport.count() != 2 {
+				t.Fatalf("got status %d after %d requests, want 200 after 2", statusCode, transport.count())
+			}
+		})
+	}
+}
+
+func TestRetrySkipsNonIdempotentPosts(t *testing.T) {
+	failures := []struct {
+		name string
+		step stubStep
+	}{
+		{"bad gateway", stubStep{status: http.StatusBadGateway, body: `{"message":"bad gateway"}`}},
+		{"service unavailable", stubStep{status: http.StatusServiceUnavailable, body: `{"message":"unavailable"}`}},
+		{"timeout", stubStep{err: context.DeadlineExceeded}},
+		{"connection reset", stubStep{err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}},
+	}
+
+	for _, failure := range failures {
+		t.Run(failure.name, func(t *testing.T) {
+			transport := newStubTransport(failure.step, jsonStep(http.StatusOK, map[string]interface{}{"status": true}))
+			client, _, _ := newTestClient(t, testConfig(t), transport)
+
+			for _, endpoint := range []string{client.refundEndpoint("tx1"), client.transferEndpoint(), client.endpoints().Send} {
+				before := transport.count()
+				if _, _, err := client.makeRequest(context.Background(), http.MethodPost, endpoint, map[string]interface{}{"amount": 1000}); err == nil {
+					t.Fatalf("POST %s succeeded, want the first failure", endpoint)
+				}
+				if sent := transport.count() - before; sent != 1 {
+					t.Fatalf("POST %s was sent %d times, want 1", endpoint, sent)
+				}
+				transport = newStubTransport(failure.step, jsonStep(http.StatusOK, map[string]interface{}{"status": true}))
+				client = client.WithHTTPClient(transport)
+			}
+		})
+	}
+}
+
+func TestRetryNonIdempotentPostWhenNeverSent(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	transport := newStubTransport(
+		stubStep{err: dialErr},
+		jsonStep(http.StatusOK, map[string]interface{}{"status": true}),
+	)
+	client, _, _ := newTestClient(t, testConfig(t), transport)
+
+	if _, _, err := client.makeRequest(context.Background(), http.MethodPost, client.refundEndpoint("tx1"), map[string]int{"amount": 1000}); err != nil {
+		t.Fatalf("makeRequest() error = %v", err)
+	}
+	if transport.count() != 2 {
+		t.Fatalf("sent %d requests, want a retry after the failed dial", transport.count())
+	}
+}
+
+func TestRetrySkippedWithoutBudget(t *testing.T) {
+	transport := newStubTransport(
+		stubStep{delay: 50 * time.Millisecond, status: http.StatusServiceUnavailable, body: `{"message":"unavailable"}`},
+		jsonStep(http.StatusOK, map[string]interface{}{"status": 1}),
+	)
+	client, _, _ := newTestClient(t, testConfig(t), transport, WithClientBackoff(NewFixedBackoff(100*time.Millisecond)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := client.makeRequest(ctx, http.MethodGet, client.statusEndpoint("tok"), nil)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("makeRequest() error = %v, want ErrTimeout", err)
+	}
+	if transport.count() != 1 {
+		t.Fatalf("sent %d requests, want no retry without budget", transport.count())
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Fatalf("gave up after %v, want right after the failed attempt", elapsed)
+	}
+}
+
+func TestRetryAttemptCappedByDeadline(t *testing.T) {
+	transport := newStubTransport(stubStep{delay: time.Second, status: http.StatusOK, body: `{}`})
+	client, _, _ := newTestClient(t, testConfig(t), transport)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := client.makeRequest(ctx, http.MethodGet, client.statusEndpoint("tok"), nil)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("makeRequest() error = %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("attempt ran %v past a 100ms deadline", elapsed)
+	}
+}
+
+func TestTimeoutHintHeader(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1}))
+	client, _, _ := newTestClient(t, testConfig(t), transport)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, _, err := client.makeRequest(ctx, http.MethodGet, client.statusEndpoint("tok"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := transport.request(0)
+	hint, err := strconv.Atoi(req.Header.Get("X-Timeout"))
+	if err != nil || hint <= 0 || hint > 2000 {
+		t.Fatalf("X-Timeout = %q, want the remaining milliseconds", req.Header.Get("X-Timeout"))
+	}
+}
+
+func TestRetryDeadlineBudget(t *testing.T) {
+	unavailable := func(delay time.Duration) stubStep {
+		return stubStep{delay: delay, status: http.StatusServiceUnavailable, body: `{"message":"unavailable"}`}
+	}
+	ok := jsonStep(http.StatusOK, map[string]interface{}{"status": 1})
+
+	tests := []struct {
+		name      string
+		first     stubStep
+		minBudget time.Duration
+		requests  int
+		detail    string
+	}{
+		// 300ms of budget with a 250ms first attempt, as 3s with a 2.5s attempt
+		{"slow attempt leaves no budget", unavailable(250 * time.Millisecond), 100 * time.Millisecond, 1, "no budget left for retry after 1 of 4 attempts"},
+		{"fast attempt leaves budget", unavailable(10 * time.Millisecond), 100 * time.Millisecond, 2, ""},
+		{"minimum budget is configurable", unavailable(10 * time.Millisecond), 400 * time.Millisecond, 1, "no budget left for retry after 1 of 4 attempts"},
+		{"deadline during the attempt", unavailable(time.Second), 10 * time.Millisecond, 1, "deadline exceeded after 1 of 4 attempts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newStubTransport(tt.first, ok)
+			client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+				c.MaxRetries = 3
+				c.MinAttemptBudget = tt.minBudget
+			}), transport, WithClientBackoff(NewFixedBackoff(10*time.Millisecond)))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			_, _, err := client.makeRequest(ctx, http.MethodGet, client.statusEndpoint("tok"), nil)
+			elapsed := time.Since(start)
+
+			if transport.count() != tt.requests {
+				t.Fatalf("sent %d requests, want %d", transport.count(), tt.requests)
+			}
+			if elapsed > 400*time.Millisecond {
+				t.Fatalf("returned after %v with a 300ms deadline", elapsed)
+			}
+
+			if tt.detail == "" {
+				if err != nil {
+					t.Fatalf("makeRequest() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrTimeout) || !IsNetworkError(err) {
+				t.Fatalf("makeRequest() error = %v, want a network timeout", err)
+			}
+			if !strings.Contains(err.Error(), tt.detail) {
+				t.Fatalf("error %q lacks %q", err, tt.detail)
+			}
+		})
+	}
+}
+
+func TestRetryAttemptsUseRemainingBudget(t *testing.T) {
+	var deadlines []time.Time
+	transport := transportFunc(func(req *http.Request) (*http.Response, error) {
+		deadline, _ := req.Context().Deadline()
+		deadlines = append(deadlines, deadline)
+		time.Sleep(20 * time.Millisecond)
+		if len(deadlines) == 1 {
+			return stubResponse(req, http.StatusBadGateway, map[string]interface{}{"message": "bad gateway"}), nil
+		}
+		return stubResponse(req, http.StatusOK, map[string]interface{}{"status": 1}), nil
+	})
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.Timeout = 30
+		c.MinAttemptBudget = 50 * time.Millisecond
+	}), transport, WithClientBackoff(NewFixedBackoff(time.Millisecond)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctxDeadline, _ := ctx.Deadline()
+
+	if _, _, err := client.makeRequest(ctx, http.MethodGet, client.statusEndpoint("tok"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A 30s per-attempt timeout never outlasts the caller's deadline
+	if len(deadlines) != 2 {
+		t.Fatalf("%d attempts, want 2", len(deadlines))
+	}
+	for i, deadline := range deadlines {
+		if deadline.After(ctxDeadline) {
+			t.Errorf("attempt %d deadline %v after the caller's %v", i+1, deadline, ctxDeadline)
+		}
+	}
+}
+
+func TestRetryDeadlineWhileWaiting(t *testing.T) {
+	transport := newStubTransport(stubStep{status: http.StatusServiceUnavailable, body: `{}`})
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.MinAttemptBudget = time.Millisecond
+	}), transport, WithClientBackoff(NewFixedBackoff(100*time.Millisecond)))
+
+	// Cancelled by the caller rather than timed out: not a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	_, _, err := client.makeRequest(ctx, http.MethodGet, client.statusEndpoint("tok"), nil)
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Fatalf("makeRequest() error = %v, want cancellation", err)
+	}
+	if transport.count() != 1 {
+		t.Fatalf("sent %d requests after cancellation", transport.count())
+	}
+}
@@ -0,0 +1,136 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// return_redirect.go implements signed redirects back to the merchant site after callbacks
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// ReturnParamsMaxAge is how long signed return parameters stay valid
+	ReturnParamsMaxAge = 10 * time.Minute
+
+	// returnParamsClockSkew tolerates timestamps slightly ahead of the verifier's clock
+	returnParamsClockSkew = time.Minute
+)
+
+// Query parameter names of signed return redirects
+const (
+	ReturnParamToken     = "token"
+	ReturnParamStatus    = "status"
+	ReturnParamAmount    = "amount"
+	ReturnParamVerified  = "verified"
+	ReturnParamTimestamp = "ts"
+	ReturnParamSignature = "signature"
+)
+
+// SignReturnParams adds a timestamp and an HMAC signature over the sorted parameters
+func SignReturnParams(values url.Values, secret string, now time.Time) url.Values {
+	signed := url.Values{}
+	for key, vals := range values {
+		if key == ReturnParamSignature {
+			continue
+		}
+		signed[key] = append([]string(nil), vals...)
+	}
+
+	signed.Set(ReturnParamTimestamp, strconv.FormatInt(now.Unix(), 10))
+	// Encode sorts by key, giving a canonical form to sign
+	signed.Set(ReturnParamSignature, SignData(signed.Encode(), secret))
+
+	return signed
+}
+
+// VerifyReturnParams checks the signature and age of return redirect parameters
+// against the current time. It is meant for the storefront receiving the redirect.
+func VerifyReturnParams(values url.Values, secret string) error {
+	return VerifyReturnParamsAt(values, secret, RealClock().Now())
+}
+
+// VerifyReturnParamsAt checks the signature of return redirect parameters and
+// their age at now, the counterpart of SignReturnParams
+func VerifyReturnParamsAt(values url.Values, secret string, now time.Time) error {
+	signature := values.Get(ReturnParamSignature)
+	if signature == "" {
+		return fmt.Errorf("%w: missing signature", ErrInvalidSignature)
+	}
+
+	unsigned := url.Values{}
+	for key, vals := range values {
+		if key != ReturnParamSignature {
+			unsigned[key] = vals
+		}
+	}
+
+	if !VerifySignature(signature, unsigned.Encode(), secret) {
+		return ErrInvalidSignature
+	}
+
+	timestamp, err := strconv.ParseInt(values.Get(ReturnParamTimestamp), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrInvalidSignature)
+	}
+
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > ReturnParamsMaxAge || age < -returnParamsClockSkew {
+		return ErrSignatureExpired
+	}
+
+	return nil
+}
+
+// VerifyReturnParams checks return redirect parameters with the configured
+// ReturnSecret on the client's clock, the one they were signed on
+func (c *Client) VerifyReturnParams(values url.Values) error {
+	return VerifyReturnParamsAt(values, configValues(c.config).ReturnSecret, c.clock.Now())
+}
+
+// returnRedirectEnabled reports whether callbacks should redirect to the merchant site
+func (c *Client) returnRedirectEnabled() bool {
+	values := configValues(c.config)
+	return values.ReturnRedirect && values.ReturnURL != "" && values.ReturnSecret != ""
+}
+
+// autoVerifyCallback verifies a successful callback's payment. It reports whether the
+// payment was verified and whether the gateway declined it; errors reaching the
+// gateway leave both false.
+func (c *Client) autoVerifyCallback(ctx context.Context, token string) (verified, declined bool) {
+	resp, err := c.VerifyPayment(ctx, token)
+	if err != nil {
+		c.log(ctx).Warn(ctx, "Automatic verification after callback failed", map[string]interface{}{
+			"token": redactToken(token),
+			"error": err.Error(),
+		})
+		return false, resp != nil || errors.Is(err, ErrVerificationFailed)
+	}
+	return true, false
+}
+
+// redirectToReturnURL sends the payer back to the merchant with signed result parameters
+func (c *Client) redirectToReturnURL(w http.ResponseWriter, r *http.Request, data *CallbackPageData, token string, verified bool) {
+	values := configValues(c.config)
+
+	target, err := url.Parse(values.ReturnURL)
+	if err != nil {
+		c.respondWithError(w, ErrInternalError, "Invalid return URL")
+		c.log(r.Context()).Error(r.Context(), "Failed to parse return URL", err, nil)
+		return
+	}
+
+	// Keep any query the merchant configured on the return URL. It is signed with
+	// the result, as the storefront verifies every parameter it receives.
+	params := target.Query()
+	params.Set(ReturnParamToken, token)
+	params.Set(ReturnParamStatus, data.Status)
+	params.Set(ReturnParamAmount, strconv.FormatInt(data.Amount, 10))
+	params.Set(ReturnParamVerified, strconv.FormatBool(verified))
+	target.RawQuery = SignReturnParams(params, values.ReturnSecret, c.clock.Now()).Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
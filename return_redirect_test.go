@@ -0,0 +1,172 @@
+package vandargo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testReturnSecret = "return-secret"
+
+func TestVerifyReturnParams(t *testing.T) {
+	params := url.Values{
+		ReturnParamToken:    {webhookToken},
+		ReturnParamStatus:   {string(StatusPaid)},
+		ReturnParamAmount:   {"100000"},
+		ReturnParamVerified: {"true"},
+	}
+
+	tests := []struct {
+		name   string
+		signed func() url.Values
+		secret string
+		want   error
+	}{
+		{"valid", func() url.Values {
+			return SignReturnParams(params, testReturnSecret, time.Now())
+		}, testReturnSecret, nil},
+		{"tampered amount", func() url.Values {
+			signed := SignReturnParams(params, testReturnSecret, time.Now())
+			signed.Set(ReturnParamAmount, "1000")
+			return signed
+		}, testReturnSecret, ErrInvalidSignature},
+		{"tampered status", func() url.Values {
+			signed := SignReturnParams(url.Values{ReturnParamStatus: {string(StatusFailed)}}, testReturnSecret, time.Now())
+			signed.Set(ReturnParamStatus, string(StatusPaid))
+			return signed
+		}, testReturnSecret, ErrInvalidSignature},
+		{"added parameter", func() url.Values {
+			signed := SignReturnParams(params, testReturnSecret, time.Now())
+			signed.Add(ReturnParamAmount, "1000")
+			return signed
+		}, testReturnSecret, ErrInvalidSignature},
+		{"removed parameter", func() url.Values {
+			signed := SignReturnParams(params, testReturnSecret, time.Now())
+			signed.Del(ReturnParamVerified)
+			return signed
+		}, testReturnSecret, ErrInvalidSignature},
+		{"wrong secret", func() url.Values {
+			return SignReturnParams(params, "other-secret", time.Now())
+		}, testReturnSecret, ErrInvalidSignature},
+		{"missing signature", func() url.Values {
+			signed := SignReturnParams(params, testReturnSecret, time.Now())
+			signed.Del(ReturnParamSignature)
+			return signed
+		}, testReturnSecret, ErrInvalidSignature},
+		{"restamped", func() url.Values {
+			signed := SignReturnParams(params, testReturnSecret, time.Now().Add(-time.Hour))
+			signed.Set(ReturnParamTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+			return signed
+		}, testReturnSecret, ErrInvalidSignature},
+		{"signed non-numeric timestamp", func() url.Values {
+			signed := SignReturnParams(params, testReturnSecret, time.Now())
+			signed.Set(ReturnParamTimestamp, "yesterday")
+			signed.Del(ReturnParamSignature)
+			signed.Set(ReturnParamSignature, SignData(signed.Encode(), testReturnSecret))
+			return signed
+		}, testReturnSecret, ErrInvalidSignature},
+		{"just valid", func() url.Values {
+			return SignReturnParams(params, testReturnSecret, time.Now().Add(-ReturnParamsMaxAge+time.Minute))
+		}, testReturnSecret, nil},
+		{"expired", func() url.Values {
+			return SignReturnParams(params, testReturnSecret, time.Now().Add(-ReturnParamsMaxAge-time.Second))
+		}, testReturnSecret, ErrSignatureExpired},
+		{"slightly ahead", func() url.Values {
+			return SignReturnParams(params, testReturnSecret, time.Now().Add(30*time.Second))
+		}, testReturnSecret, nil},
+		{"far ahead", func() url.Values {
+			return SignReturnParams(params, testReturnSecret, time.Now().Add(time.Hour))
+		}, testReturnSecret, ErrSignatureExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyReturnParams(tt.signed(), tt.secret)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyReturnParams() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Signing leaves the caller's values alone and replaces a stale signature
+	signed := SignReturnParams(url.Values{ReturnParamSignature: {"stale"}}, testReturnSecret, time.Now())
+	if len(signed[ReturnParamSignature]) != 1 || signed.Get(ReturnParamSignature) == "stale" || params.Has(ReturnParamTimestamp) {
+		t.Fatalf("signed %v from %v", signed, params)
+	}
+}
+
+func TestCallbackReturnRedirect(t *testing.T) {
+	config := testConfig(t, func(c *Config) {
+		c.ReturnRedirect = true
+		c.ReturnURL = "https://shop.example.com/return?shop=main"
+		c.ReturnSecret = testReturnSecret
+	})
+	client, storage, _ := newTestClient(t, config, newStubTransport())
+	storeWebhookPayment(t, storage, StatusInit)
+	handler := client.Handler()
+
+	callback := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/callback", strings.NewReader("token="+webhookToken+"&status=OK"))
+		req.Header.Set("Content-Type", formContentType)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The first callback and its duplicate both redirect with verifiable parameters
+	for i := 0; i < 2; i++ {
+		rec := callback()
+		if rec.Code != http.StatusFound {
+			t.Fatalf("callback %d: status %d: %s", i, rec.Code, rec.Body)
+		}
+
+		location, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil || location.Host != "shop.example.com" || location.Path != "/return" {
+			t.Fatalf("callback %d: Location %q", i, rec.Header().Get("Location"))
+		}
+		query := location.Query()
+		if err := VerifyReturnParams(query, testReturnSecret); err != nil {
+			t.Fatalf("callback %d: %v in %v", i, err, query)
+		}
+		if query.Get("shop") != "main" || query.Get(ReturnParamToken) != webhookToken || query.Get(ReturnParamAmount) != "100000" {
+			t.Fatalf("callback %d: query %v", i, query)
+		}
+	}
+}
+
+func TestReturnRedirectFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.ReturnRedirect = true
+		c.ReturnURL = "https://shop.example.com/return"
+		c.ReturnSecret = testReturnSecret
+	}), newStubTransport(), WithClientClock(clock))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/callback", strings.NewReader("token="+webhookToken+"&status=OK"))
+	req.Header.Set("Content-Type", formContentType)
+	rec := httptest.NewRecorder()
+	client.Handler().ServeHTTP(rec, req)
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	query := location.Query()
+
+	// Signed and checked on the same fake clock, far from the wall clock
+	if query.Get(ReturnParamTimestamp) != strconv.FormatInt(clock.Now().Unix(), 10) {
+		t.Fatalf("timestamp %s, want the client clock", query.Get(ReturnParamTimestamp))
+	}
+	if err := client.VerifyReturnParams(query); err != nil {
+		t.Fatalf("VerifyReturnParams() on the signing clock = %v", err)
+	}
+	clock.Advance(ReturnParamsMaxAge + time.Second)
+	if err := client.VerifyReturnParams(query); !errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("VerifyReturnParams() after the max age = %v", err)
+	}
+	if err := VerifyReturnParamsAt(query, testReturnSecret, clock.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("VerifyReturnParamsAt() = %v", err)
+	}
+}
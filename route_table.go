@@ -0,0 +1,462 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// route_table.go describes the payment endpoints and their default middleware chains
+package vandargo
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// transferPath is the path of the optional wallet transfer route
+	transferPath = "/payments/transfer"
+
+	// callbackMaxBodyBytes caps callback bodies, which only carry a few form fields
+	callbackMaxBodyBytes = 64 * 1024
+
+	// callbackRateLimit is the per-IP limit of callback requests per minute
+	callbackRateLimit = 20
+)
+
+// routePolicy selects the default middleware chain of a route
+type routePolicy int
+
+const (
+	// policyAuthenticated is used by merchant-facing API routes requiring the API key
+	policyAuthenticated routePolicy = iota
+
+	// policyCallback is used by the gateway callback, which carries no credentials
+	policyCallback
+
+	// policyAdmin is used by support endpoints requiring both the API key and the admin key
+	policyAdmin
+
+	// policyWebhook is used by business webhooks, authenticated by their signature
+	policyWebhook
+
+	// policyMetrics is used by the metrics scrape route, authenticated by the
+	// metrics token or like admin routes
+	policyMetrics
+
+	// policyHealth is used by the health probe route, which orchestrators call
+	// without credentials
+	policyHealth
+)
+
+// RouteDescriptor describes a registered payment endpoint
+type RouteDescriptor struct {
+	// Method is the HTTP method of the route
+	Method string
+
+	// Path is the route path relative to the mount prefix
+	Path string
+
+	// Description summarizes what the endpoint does
+	Description string
+
+	// Authenticated reports whether the route requires the API key
+	Authenticated bool
+
+	// Scope is the scope the API key needs for the route, empty when unauthenticated
+	Scope Scope
+
+	// Query lists the query parameters the route reads
+	Query []string
+
+	// Example is an example JSON request body, nil for routes without a body
+	Example interface{}
+}
+
+// route describes one payment endpoint
+type route struct {
+	method      string
+	path        string
+	description string
+	handler     http.HandlerFunc
+	policy      routePolicy
+	scope       Scope
+	rateLimit   int
+
+	// optional routes are only registered when enabled by a RouteOption
+	optional bool
+
+	// Documentation of the request and response shapes
+	request  interface{}
+	response interface{}
+	query    []string
+	example  interface{}
+}
+
+// routeTable lists the payment endpoints registered by RegisterRoutes
+func (c *Client) routeTable() []route {
+	return []route{
+		{
+			method:      http.MethodPost,
+			path:        "/payments/init",
+			description: "Initialize a payment and obtain a payment token",
+			handler:     c.handlePaymentInit,
+			policy:      policyAuthenticated,
+			scope:       ScopeWrite,
+			rateLimit:   10,
+			request:     PaymentInitRequest{},
+			response:    PaymentInitResponse{},
+			example: PaymentInitRequest{
+				Amount:       100000,
+				CallbackURL:  "https://shop.example.com/payments/callback",
+				Description:  "Order 1042",
+				FactorNumber: "1042",
+			},
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/payments/verify",
+			description: "Verify a payment after the payer returns",
+			handler:     c.handlePaymentVerify,
+			policy:      policyAuthenticated,
+			scope:       ScopeWrite,
+			rateLimit:   10,
+			request:     PaymentVerifyRequest{},
+			response:    VerifyResult{},
+			query:       []string{"session"},
+			example:     PaymentVerifyRequest{Token: "1a2b3c4d5e6f7g8h9i0j"},
+		},
+		{
+			method:      http.MethodGet,
+			path:        "/payments/status",
+			description: "Get the status of a payment",
+			handler:     c.handlePaymentStatus,
+			policy:      policyAuthenticated,
+			scope:       ScopeRead,
+			rateLimit:   20,
+			response:    PaymentStatusResponse{},
+			query:       []string{"token", "transaction_id", "factor_number", "session", "format"},
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/payments/refund",
+			description: "Refund a verified payment",
+			handler:     c.handleRefund,
+			policy:      policyAuthenticated,
+			scope:       ScopeRefund,
+			rateLimit:   5,
+			request:     RefundRequest{},
+			response:    RefundResponse{},
+			example:     RefundRequest{TransactionID: "160000000001", Amount: 50000},
+		},
+		{
+			method:      http.MethodPost,
+			path:        refundBatchPath,
+			description: "Refund up to 100 verified payments with per-refund results",
+			handler:     c.handleRefundBatch,
+			policy:      policyAuthenticated,
+			scope:       ScopeRefund,
+			rateLimit:   2,
+			optional:    true,
+			request:     RefundBatchRequest{},
+			response:    RefundBatchResponse{},
+			example: RefundBatchRequest{
+				Refunds: []RefundRequest{
+					{TransactionID: "160000000001", Amount: 50000},
+					{TransactionID: "160000000002"},
+				},
+			},
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/payments/callback",
+			description: "Receive the gateway callback after payment",
+			handler:     c.handleCallback,
+			policy:      policyCallback,
+			rateLimit:   callbackRateLimit,
+			request:     CallbackData{},
+			example:     CallbackData{Token: "1a2b3c4d5e6f7g8h9i0j"},
+		},
+		{
+			method:      http.MethodPost,
+			path:        webhookPath,
+			description: "Receive Vandar business webhooks about transactions and settlements",
+			handler:     c.handleWebhook,
+			policy:      policyWebhook,
+			rateLimit:   webhookRateLimit,
+			request:     WebhookEvent{},
+			example: map[string]interface{}{
+				"id":   "evt_01J9Z8T6QK",
+				"type": WebhookSettlementDone,
+				"data": WebhookSettlement{
+					SettlementID: "st_4821",
+					Amount:       12500000,
+					Status:       "DONE",
+					TrackingCode: "140510160001",
+				},
+			},
+		},
+		{
+			method:      http.MethodGet,
+			path:        "/payments/transaction-info",
+			description: "Get detailed information about a transaction",
+			handler:     c.handleTransactionInfo,
+			policy:      policyAuthenticated,
+			scope:       ScopeRead,
+			rateLimit:   20,
+			response:    TransactionInfoResponse{},
+			query:       []string{"token"},
+		},
+		{
+			method:      http.MethodGet,
+			path:        qrCodePath,
+			description: "Get a PNG QR code of the payment page of a pending payment",
+			handler:     c.handlePaymentQRCode,
+			policy:      policyAuthenticated,
+			scope:       ScopeRead,
+			rateLimit:   20,
+			optional:    true,
+			query:       []string{"token"},
+		},
+		{
+			method:      http.MethodGet,
+			path:        "/payments/transactions/{id}",
+			description: "Get a stored transaction with its refunds",
+			handler:     c.handleTransactionDetail,
+			policy:      policyAdmin,
+			scope:       ScopeAdmin,
+			rateLimit:   10,
+			response:    TransactionDetail{},
+			query:       []string{"include_sensitive"},
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/payments/transactions/{id}/status",
+			description: "Manually change the status of a stored transaction",
+			handler:     c.handleStatusOverride,
+			policy:      policyAdmin,
+			scope:       ScopeAdmin,
+			rateLimit:   5,
+			request:     StatusOverrideRequest{},
+			response:    Transaction{},
+			query:       []string{"include_sensitive"},
+			example: StatusOverrideRequest{
+				Status: StatusPaid,
+				Reason: "Confirmed in the Vandar dashboard",
+				Actor:  "support@shop.example.com",
+			},
+		},
+		{
+			method:      http.MethodGet,
+			path:        "/payments/transactions/{id}/evidence",
+			description: "Get the raw gateway responses retained for a transaction as dispute evidence",
+			handler:     c.handleTransactionEvidence,
+			policy:      policyAdmin,
+			scope:       ScopeAdmin,
+			rateLimit:   10,
+			response:    TransactionEvidence{},
+		},
+		{
+			method:      http.MethodPost,
+			path:        "/payments/transactions/{id}/trace",
+			description: "Start or stop logging everything touching a token at debug level",
+			handler:     c.handleTraceToken,
+			policy:      policyAdmin,
+			scope:       ScopeAdmin,
+			rateLimit:   5,
+			request:     TraceTokenRequest{},
+			response:    TraceTokenResponse{},
+			example:     TraceTokenRequest{TTL: "15m"},
+		},
+		{
+			method:      http.MethodGet,
+			path:        metricsPath,
+			description: "Scrape the client's metrics in the Prometheus text exposition format",
+			handler:     c.handleMetrics,
+			policy:      policyMetrics,
+			scope:       ScopeAdmin,
+			rateLimit:   60,
+			optional:    true,
+		},
+		{
+			method:      http.MethodGet,
+			path:        healthPath,
+			description: "Report the health of the gateway, storage and background queues",
+			handler:     c.handleHealth,
+			policy:      policyHealth,
+			rateLimit:   120,
+			optional:    true,
+			response:    HealthReport{},
+			query:       []string{"probe"},
+		},
+		{
+			method:      http.MethodPost,
+			path:        transferPath,
+			description: "Transfer money from the business wallet to another business",
+			handler:     c.handleTransfer,
+			policy:      policyAdmin,
+			scope:       ScopeAdmin,
+			rateLimit:   5,
+			optional:    true,
+			request:     TransferRequest{},
+			response:    TransferResponse{},
+			example: TransferRequest{
+				DestinationBusiness: "seller-shop",
+				Amount:              2500000,
+				PaymentNumber:       "payout-1042",
+			},
+		},
+	}
+}
+
+// Routes describes the endpoints registered by RegisterRoutes with the same
+// options, e.g. for generating documentation or reverse proxy configuration
+func (c *Client) Routes(opts ...RouteOption) []RouteDescriptor {
+	options := newRouteOptions(opts)
+	table := c.routeTable()
+	descriptors := make([]RouteDescriptor, 0, len(table))
+	for _, rt := range table {
+		if !options.includes(rt) {
+			continue
+		}
+		descriptors = append(descriptors, RouteDescriptor{
+			Method:        rt.method,
+			Path:          rt.path,
+			Description:   rt.description,
+			Authenticated: rt.policy != policyCallback && rt.policy != policyHealth,
+			Scope:         rt.scope,
+			Query:         rt.query,
+			Example:       rt.example,
+		})
+	}
+	return descriptors
+}
+
+// normalizePrefix cleans a mount prefix to "" or a path with a leading and no trailing slash
+func normalizePrefix(prefix string) string {
+	var parts []string
+	for _, part := range strings.Split(strings.TrimSpace(prefix), "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return "/" + strings.Join(parts, "/")
+}
+
+// routeChain returns the middleware chain of a route with the user's overrides applied
+func (c *Client) routeChain(rt route, fullPath string, options *routeOptions) []Middleware {
+	override := options.overrides[rt.path]
+
+	defaults := override.replace
+	if defaults == nil {
+		defaults = c.defaultChain(rt, options)
+	}
+
+	// The route pattern and response encoder are always available to the chain,
+	// every request is measured, its response is dropped once the caller went
+	// away and it counts as an in-flight operation
+	chain := []Middleware{
+		ResponseEncoderMiddleware(c.responseEncoder),
+		routeMiddleware(fullPath),
+		MetricsMiddleware(c.metrics),
+		clientGoneMiddleware(),
+		c.InflightMiddleware(),
+	}
+	if c.simulated {
+		chain = append(chain, simulatedMiddleware())
+	}
+	chain = append(chain, override.prepend...)
+	chain = append(chain, defaults...)
+	return append(chain, override.append...)
+}
+
+// defaultChain returns the default middlewares of a route for its policy: the
+// shared prefix of baseChain followed by the checks of the policy
+func (c *Client) defaultChain(rt route, options *routeOptions) []Middleware {
+	switch rt.policy {
+	case policyCallback:
+		// Hit by the gateway and by browsers: no credentials, strict anti-abuse
+		chain := append(c.baseChain(rt, options, callbackMaxBodyBytes), IPFilterMiddleware(c.config))
+		if options.gatewayMTLS != nil {
+			chain = append(chain, MTLSMiddleware(*options.gatewayMTLS))
+		}
+		if options.callbackSignature {
+			chain = append(chain, ValidateSignatureMiddlewareWithNonces(c.config, c.nonceStore()))
+		}
+		return chain
+
+	case policyWebhook:
+		// Hit by the gateway only, which signs the body
+		chain := append(c.baseChain(rt, options, DefaultMaxBodyBytes), IPFilterMiddleware(c.config))
+		if options.gatewayMTLS != nil {
+			chain = append(chain, MTLSMiddleware(*options.gatewayMTLS))
+		}
+		return chain
+
+	case policyMetrics:
+		// Polled by a scraper, which sends no body
+		return append(c.baseChain(rt, options, 0), options.metricsAuth(c.config)...)
+
+	case policyHealth:
+		// Polled by orchestrators, which send no credentials or body
+		return c.baseChain(rt, options, 0)
+
+	case policyAdmin:
+		// Used by support tooling, not browsers. Transaction details and
+		// evidence bundles can be large, so they are always compressed.
+		return append(c.baseChain(rt, options, DefaultMaxBodyBytes),
+			options.authMiddleware(c.config, rt.scope),
+			AdminKeyMiddleware(c.config),
+			GzipMiddleware(),
+		)
+	}
+
+	chain := options.browser(append(c.baseChain(rt, options, DefaultMaxBodyBytes),
+		options.authMiddleware(c.config, rt.scope),
+	)...)
+
+	// Authenticated POSTs can be retried safely once keys are shared
+	if c.kv != nil && rt.method == http.MethodPost {
+		chain = append(chain, IdempotencyMiddleware(NewIdempotencyStore(c.kv, 0), c.logger))
+	}
+	return chain
+}
+
+// baseChain returns the middlewares every route starts with: request and
+// correlation IDs, the client IP, the request logger, security headers and the
+// rate limit. The body is buffered up to maxBodyBytes; zero is for routes
+// reading no body.
+func (c *Client) baseChain(rt route, options *routeOptions, maxBodyBytes int64) []Middleware {
+	chain := []Middleware{
+		RequestIDMiddlewareWithGenerator(c.idGenerator()),
+		CorrelationMiddleware(c.config, c.idGenerator()),
+		ClientIPMiddlewareWithLogger(c.config, c.logger),
+		ContextLoggerMiddleware(c.logger),
+	}
+	if maxBodyBytes > 0 {
+		chain = append(chain, BufferBodyMiddleware(maxBodyBytes))
+	}
+
+	return append(chain,
+		LoggingMiddleware(c.logger, options.loggingFor(rt.path)...),
+		SecurityHeadersMiddleware(),
+		c.rateLimitMiddleware(rt.rateLimit),
+	)
+}
+
+// rateLimitMiddleware limits requests to a route per client IP, sharing the
+// counters through the KV store when the client has one
+func (c *Client) rateLimitMiddleware(limit int) Middleware {
+	if c.kv != nil {
+		return RateLimitMiddlewareWithStore(limit, time.Minute, c.clock, c.metrics, c.kv)
+	}
+	return RateLimitMiddlewareWithMetrics(limit, time.Minute, c.clock, c.metrics)
+}
+
+// nonceStore returns the store of signature nonces, nil without a KV store
+func (c *Client) nonceStore() *NonceStore {
+	if c.kv == nil {
+		return nil
+	}
+	return NewNonceStore(c.kv, 0)
+}
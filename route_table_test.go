@@ -0,0 +1,224 @@
+package vandargo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// allRouteOptions registers every optional route
+func allRouteOptions() []RouteOption {
+	return []RouteOption{
+		WithQRCodeRoute(),
+		WithTransferRoute(),
+		WithRefundBatchRoute(),
+		WithMetricsRoute(""),
+		WithHealthRoute(),
+	}
+}
+
+// routeRequest sends a request to a route of handler, filling in path parameters
+func routeRequest(handler http.Handler, method, path, authorization string) *httptest.ResponseRecorder {
+	path = strings.ReplaceAll(path, "{id}", "tx1")
+	var body *strings.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(`{}`)
+	} else {
+		body = strings.NewReader("")
+	}
+
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRouteAuthenticationByPolicy(t *testing.T) {
+	config := testConfig(t, func(c *Config) {
+		c.AdminKey = "admin-key"
+		c.WebhookSecret = "webhook-secret"
+	})
+	client, _, _ := newTestClient(t, config, NewSimulatorTransport())
+	handler := client.Handler(allRouteOptions()...)
+
+	for _, rt := range client.Routes(allRouteOptions()...) {
+		rec := routeRequest(handler, rt.Method, rt.Path, "")
+
+		if rt.Authenticated && rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without credentials: status %d, want 401", rt.Method, rt.Path, rec.Code)
+		}
+		if !rt.Authenticated && (rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden) {
+			t.Errorf("%s %s requires credentials: status %d", rt.Method, rt.Path, rec.Code)
+		}
+
+		// Every policy starts with the shared prefix
+		if rec.Header().Get("X-Request-ID") == "" || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s %s: missing request ID or security headers: %v", rt.Method, rt.Path, rec.Header())
+		}
+	}
+}
+
+func TestCallbackNeverRequiresAuthorization(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t), NewSimulatorTransport())
+	handler := client.Handler()
+	storeWebhookPayment(t, storage, StatusInit)
+
+	for _, authorization := range []string{"", "Bearer wrong-key", "Basic Zm9vOmJhcg=="} {
+		req := httptest.NewRequest(http.MethodPost, "/payments/callback", strings.NewReader(`{"token":"`+webhookToken+`","payment_status":"OK"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+			t.Errorf("callback with Authorization %q: status %d", authorization, rec.Code)
+		}
+	}
+}
+
+func TestRefundAlwaysRequiresAuthorization(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport())
+
+	// Relaxing the callback route must not affect the others
+	handler := client.Handler(WithRouteMiddleware("/payments/callback"))
+
+	for _, authorization := range []string{"", "Bearer wrong-key", "Basic " + testAPIKey, testAPIKey} {
+		rec := routeRequest(handler, http.MethodPost, "/payments/refund", authorization)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("refund with Authorization %q: status %d, want 401", authorization, rec.Code)
+		}
+	}
+
+	rec := routeRequest(handler, http.MethodPost, "/payments/refund", "Bearer "+testAPIKey)
+	if rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+		t.Errorf("refund with the API key: status %d", rec.Code)
+	}
+}
+
+func TestNormalizePrefix(t *testing.T) {
+	tests := map[string]string{
+		"":                      "",
+		"/":                     "",
+		"  ":                    "",
+		"api":                   "/api",
+		"/api/v1/psp/vandar":    "/api/v1/psp/vandar",
+		"api/v1/psp/vandar/":    "/api/v1/psp/vandar",
+		"//api//v1/psp/vandar/": "/api/v1/psp/vandar",
+		" /api/v1 ":             "/api/v1",
+	}
+	for prefix, want := range tests {
+		if got := normalizePrefix(prefix); got != want {
+			t.Errorf("normalizePrefix(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
+
+func TestRoutesWithPrefixEndToEnd(t *testing.T) {
+	for _, prefix := range []string{"/api/v1/psp/vandar", "api/v1/psp/vandar/", "//api//v1/psp/vandar//"} {
+		t.Run(prefix, func(t *testing.T) {
+			config := testConfig(t, func(c *Config) {
+				c.AdminKey = "admin-key"
+			})
+			client, storage, _ := newTestClient(t, config, newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+				"status":            true,
+				"amount":            100000,
+				"transactionStatus": "PAID",
+			})))
+			storeWebhookPayment(t, storage, StatusInit)
+
+			mux := http.NewServeMux()
+			client.RegisterRoutesWithPrefix(NewServeMuxRouter(mux), prefix, WithHealthRoute())
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			get := func(path string) *http.Response {
+				t.Helper()
+
+				req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+				req.Header.Set("Authorization", "Bearer "+testAPIKey)
+				req.Header.Set(AdminKeyHeader, "admin-key")
+				resp, err := server.Client().Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				return resp
+			}
+
+			const base = "/api/v1/psp/vandar"
+			for _, path := range []string{
+				base + "/payments/health",
+				base + "/payments/status?token=" + webhookToken,
+				base + "/payments/transactions/" + webhookToken,
+			} {
+				if resp := get(path); resp.StatusCode != http.StatusOK {
+					t.Errorf("GET %s: status %d", path, resp.StatusCode)
+				}
+			}
+
+			// The callback is routed under the prefix without credentials
+			resp, err := server.Client().Post(server.URL+base+"/payments/callback", formContentType, strings.NewReader("token="+webhookToken+"&status=OK"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("POST callback: status %d", resp.StatusCode)
+			}
+
+			// Nothing is served outside the prefix
+			for _, path := range []string{"/payments/health", base + "/health", "/api/v1/payments/health"} {
+				if resp := get(path); resp.StatusCode != http.StatusNotFound {
+					t.Errorf("GET %s: status %d, want 404", path, resp.StatusCode)
+				}
+			}
+		})
+	}
+}
+
+func TestRoutesDescribeRegisteredPaths(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), nil)
+
+	recorder := &recordingRouter{}
+	client.RegisterRoutesWithPrefix(recorder, "/api/v1", allRouteOptions()...)
+
+	// Routes are relative to the prefix and match what was registered, in order
+	descriptors := client.Routes(allRouteOptions()...)
+	if len(descriptors) != len(recorder.routes) {
+		t.Fatalf("%d descriptors for %d registered routes", len(descriptors), len(recorder.routes))
+	}
+	for i, descriptor := range descriptors {
+		if want := descriptor.Method + " /api/v1" + descriptor.Path; recorder.routes[i] != want {
+			t.Errorf("route %d registered as %q, described as %q", i, recorder.routes[i], want)
+		}
+		if descriptor.Description == "" {
+			t.Errorf("%s %s has no description", descriptor.Method, descriptor.Path)
+		}
+	}
+
+	// Optional routes are only described when enabled
+	if len(client.Routes()) >= len(descriptors) {
+		t.Fatalf("%d routes without options, %d with all", len(client.Routes()), len(descriptors))
+	}
+}
+
+// recordingRouter records the method and path of registered routes
+type recordingRouter struct {
+	routes []string
+}
+
+func (r *recordingRouter) POST(path string, handler http.HandlerFunc) {
+	r.routes = append(r.routes, http.MethodPost+" "+path)
+}
+
+func (r *recordingRouter) GET(path string, handler http.HandlerFunc) {
+	r.routes = append(r.routes, http.MethodGet+" "+path)
+}
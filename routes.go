@@ -0,0 +1,305 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// routes.go implements options for mounting the payment endpoints on a router
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// routePatternKey stores the matched route pattern in the request context
+const routePatternKey contextKey = "route"
+
+// OptionsRouterInterface is implemented by routers that can register OPTIONS
+// routes, which is required to answer CORS preflight requests
+type OptionsRouterInterface interface {
+	// OPTIONS registers an OPTIONS route with a handler
+	OPTIONS(path string, handler http.HandlerFunc)
+}
+
+// RouteOption configures how RegisterRoutes mounts the payment endpoints
+type RouteOption func(*routeOptions)
+
+// routeOptions holds the settings collected from RouteOption values
+type routeOptions struct {
+	cors              *CORSConfig
+	gzip              bool
+	logging           []LoggingOption
+	routeLevels       map[string]LogLevel
+	overrides         map[string]routeOverride
+	callbackSignature bool
+	openAPI           bool
+	optionalRoutes    map[string]bool
+	jwt               *JWTVerifier
+	gatewayMTLS       *MTLSConfig
+	metricsToken      string
+}
+
+// routeOverride holds user changes to the middleware chain of one route
+type routeOverride struct {
+	replace []Middleware
+	prepend []Middleware
+	append  []Middleware
+}
+
+// WithCORS enables CORS handling on browser-facing routes
+func WithCORS(config CORSConfig) RouteOption {
+	return func(o *routeOptions) {
+		o.cors = &config
+	}
+}
+
+// WithGzip enables gzip compression of responses on the API routes
+func WithGzip() RouteOption {
+	return func(o *routeOptions) {
+		o.gzip = true
+	}
+}
+
+// WithLoggingOptions configures the request logging of every route
+func WithLoggingOptions(opts ...LoggingOption) RouteOption {
+	return func(o *routeOptions) {
+		o.logging = append(o.logging, opts...)
+	}
+}
+
+// WithRouteLogLevel sets the level at which successful requests to one route are
+// logged, e.g. Debug for a frequently polled status endpoint
+func WithRouteLogLevel(path string, level LogLevel) RouteOption {
+	return func(o *routeOptions) {
+		if o.routeLevels == nil {
+			o.routeLevels = make(map[string]LogLevel)
+		}
+		o.routeLevels[path] = level
+	}
+}
+
+// WithRouteMiddleware replaces the default middleware chain of a route
+func WithRouteMiddleware(path string, middlewares ...Middleware) RouteOption {
+	return func(o *routeOptions) {
+		override := o.override(path)
+		override.replace = append([]Middleware{}, middlewares...)
+		o.overrides[path] = override
+	}
+}
+
+// WithRoutePrepend adds middlewares in front of the chain of a route
+func WithRoutePrepend(path string, middlewares ...Middleware) RouteOption {
+	return func(o *routeOptions) {
+		override := o.override(path)
+		override.prepend = append(override.prepend, middlewares...)
+		o.overrides[path] = override
+	}
+}
+
+// WithRouteAppend adds middlewares at the end of the chain of a route, right before the handler
+func WithRouteAppend(path string, middlewares ...Middleware) RouteOption {
+	return func(o *routeOptions) {
+		override := o.override(path)
+		override.append = append(override.append, middlewares...)
+		o.overrides[path] = override
+	}
+}
+
+// WithCallbackSignature requires a valid request signature on the callback route
+func WithCallbackSignature() RouteOption {
+	return func(o *routeOptions) {
+		o.callbackSignature = true
+	}
+}
+
+// WithGatewayMTLS requires a client certificate accepted by MTLSMiddleware on the
+// callback and webhook routes. Serve the routes over TLS with WithClientAuth so
+// the server asks for client certificates.
+func WithGatewayMTLS(config MTLSConfig) RouteOption {
+	return func(o *routeOptions) {
+		o.gatewayMTLS = &config
+	}
+}
+
+// WithJWTAuth authenticates the API and admin routes with JWT bearer tokens
+// verified by verifier instead of the static server keys. The admin key is still
+// required on admin routes.
+func WithJWTAuth(verifier *JWTVerifier) RouteOption {
+	return func(o *routeOptions) {
+		o.jwt = verifier
+	}
+}
+
+// authMiddleware returns the bearer authentication of a route requiring a scope
+func (o *routeOptions) authMiddleware(config ConfigInterface, scope Scope) Middleware {
+	if o.jwt != nil {
+		return JWTAuthMiddleware(o.jwt, scope)
+	}
+	return AuthMiddleware(config, scope)
+}
+
+// WithOpenAPIRoute serves the OpenAPI document at GET /payments/openapi.json
+func WithOpenAPIRoute() RouteOption {
+	return func(o *routeOptions) {
+		o.openAPI = true
+	}
+}
+
+// WithQRCodeRoute registers GET /payments/qr, which serves the payment page QR
+// code of a pending payment as image/png
+func WithQRCodeRoute() RouteOption {
+	return func(o *routeOptions) {
+		o.enable(qrCodePath)
+	}
+}
+
+// WithTransferRoute registers POST /payments/transfer, which moves money from the
+// business wallet and therefore requires both the API key and the admin key
+func WithTransferRoute() RouteOption {
+	return func(o *routeOptions) {
+		o.enable(transferPath)
+	}
+}
+
+// enable turns on an optional route
+func (o *routeOptions) enable(path string) {
+	if o.optionalRoutes == nil {
+		o.optionalRoutes = make(map[string]bool)
+	}
+	o.optionalRoutes[path] = true
+}
+
+// includes reports whether a route is registered with these options
+func (o *routeOptions) includes(rt route) bool {
+	return !rt.optional || o.optionalRoutes[rt.path]
+}
+
+// override returns the current override of a route, initializing the map
+func (o *routeOptions) override(path string) routeOverride {
+	if o.overrides == nil {
+		o.overrides = make(map[string]routeOverride)
+	}
+	return o.overrides[path]
+}
+
+// newRouteOptions applies route options over the defaults
+func newRouteOptions(opts []RouteOption) *routeOptions {
+	options := &routeOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// loggingFor returns the logging options of a route
+func (o *routeOptions) loggingFor(path string) []LoggingOption {
+	level, exists := o.routeLevels[path]
+	if !exists {
+		return o.logging
+	}
+
+	opts := make([]LoggingOption, 0, len(o.logging)+1)
+	opts = append(opts, o.logging...)
+	return append(opts, WithSuccessLogLevel(level))
+}
+
+// browser adds the optional middlewares of browser-facing routes around the given ones
+func (o *routeOptions) browser(middlewares ...Middleware) []Middleware {
+	var chain []Middleware
+
+	if o.cors != nil {
+		chain = append(chain, CORSMiddleware(*o.cors))
+	}
+
+	chain = append(chain, middlewares...)
+
+	if o.gzip {
+		chain = append(chain, GzipMiddleware())
+	}
+
+	return chain
+}
+
+// registerPreflight registers OPTIONS handlers for browser-facing routes when CORS is
+// enabled and the router supports them
+func (o *routeOptions) registerPreflight(router RouterInterface, paths ...string) {
+	optionsRouter, ok := router.(OptionsRouterInterface)
+	if o.cors == nil || !ok {
+		return
+	}
+
+	// The CORS middleware answers preflight requests itself
+	handler := Chain(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}, CORSMiddleware(*o.cors))
+
+	for _, path := range paths {
+		optionsRouter.OPTIONS(path, handler)
+	}
+}
+
+// routeMiddleware records the route pattern a handler was registered under
+func routeMiddleware(pattern string) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), routePatternKey, pattern)
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// routePattern returns the route pattern recorded for the request, if any
+func routePattern(r *http.Request) string {
+	pattern, _ := r.Context().Value(routePatternKey).(string)
+	return pattern
+}
+
+// pathParams returns the names of the {name} segments of a route pattern
+func pathParams(pattern string) []string {
+	var names []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if isParamSegment(segment) {
+			names = append(names, segment[1:len(segment)-1])
+		}
+	}
+	return names
+}
+
+// isParamSegment reports whether a pattern segment is a {name} placeholder
+func isParamSegment(segment string) bool {
+	return len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// matchPattern matches a path against a route pattern and returns its parameters
+func matchPattern(pattern, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(pattern, "/")
+	pathSegments := strings.Split(path, "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, segment := range patternSegments {
+		if isParamSegment(segment) {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = pathSegments[i]
+			continue
+		}
+		if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// pathParam returns a path parameter of the request. It uses the value set by
+// routers that support Request.PathValue, such as http.ServeMux, and otherwise
+// matches the path against the recorded route pattern.
+func pathParam(r *http.Request, name string) string {
+	if value := r.PathValue(name); value != "" {
+		return value
+	}
+
+	params, _ := matchPattern(routePattern(r), r.URL.Path)
+	return params[name]
+}
@@ -0,0 +1,243 @@
+package vandargo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closingLogger records whether the client closed it
+type closingLogger struct {
+	captureLogger
+	closed atomic.Bool
+}
+
+func (l *closingLogger) Close(ctx context.Context) error {
+	l.closed.Store(true)
+	return nil
+}
+
+// slowGateway answers status checks after delay, signalling started when one begins.
+// Other requests, like the warm-up, are answered at once.
+func slowGateway(delay time.Duration, started chan<- struct{}) transportFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodHead {
+			return stubResponse(req, http.StatusOK, map[string]interface{}{}), nil
+		}
+
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		return stubResponse(req, http.StatusOK, map[string]interface{}{
+			"status":            true,
+			"amount":            100000,
+			"transactionStatus": "PAID",
+		}), nil
+	}
+}
+
+// startServe runs client.Serve on a random local port and returns its URL and result
+func startServe(t *testing.T, client *Client, ctx context.Context, opts ...ServeOption) (string, <-chan error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- client.Serve(ctx, listener.Addr().String(), append(opts, WithListener(listener))...)
+	}()
+	return listener.Addr().String(), result
+}
+
+// statusRequest asks the served routes for the status of the test payment
+func statusRequest(httpClient *http.Client, url string) (int, error) {
+	req, _ := http.NewRequest(http.MethodGet, url+"/payments/status?token="+webhookToken, nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	logger := &closingLogger{}
+	storage := NewMemoryStorage()
+	storeWebhookPayment(t, storage, StatusInit)
+	client, err := NewClient(testConfig(t), storage, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = client.Clone(WithClientHTTPClient(slowGateway(300*time.Millisecond, started)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, result := startServe(t, client, ctx, WithShutdownGracePeriod(5*time.Second))
+	url := "http://" + addr
+
+	// A request in flight when the context is canceled still completes
+	inFlight := make(chan int, 1)
+	go func() {
+		status, err := statusRequest(http.DefaultClient, url)
+		if err != nil {
+			t.Error(err)
+		}
+		inFlight <- status
+	}()
+
+	select {
+	case <-started:
+	case err := <-result:
+		t.Fatalf("Serve returned before the request: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the gateway")
+	}
+	cancel()
+
+	if status := <-inFlight; status != http.StatusOK {
+		t.Fatalf("in-flight request: status %d", status)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("Serve() = %v, want a clean shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after shutdown")
+	}
+
+	// The listener is closed and the client released its resources
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("server still accepting connections")
+	}
+	if !logger.closed.Load() {
+		t.Fatal("client logger was not closed")
+	}
+}
+
+func TestServeGracePeriodExceeded(t *testing.T) {
+	started := make(chan struct{}, 1)
+	client, storage, _ := newTestClient(t, testConfig(t), slowGateway(time.Minute, started))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, result := startServe(t, client, ctx, WithShutdownGracePeriod(50*time.Millisecond))
+
+	go statusRequest(http.DefaultClient, "http://"+addr)
+	<-started
+	cancel()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrShutdownFailed) || errors.Is(err, ErrServerFailed) {
+			t.Fatalf("Serve() = %v, want ErrShutdownFailed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not give up after the grace period")
+	}
+}
+
+func TestServeStartupFailure(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), slowGateway(0, nil))
+
+	// The address is taken, so the server can't start
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.Serve(ctx, taken.Addr().String())
+	if !errors.Is(err, ErrServerFailed) || errors.Is(err, ErrShutdownFailed) || ctx.Err() != nil {
+		t.Fatalf("Serve() = %v, want ErrServerFailed at once", err)
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	certificate, pool := newTestCertificate(t)
+	client, storage, _ := newTestClient(t, testConfig(t), slowGateway(0, nil))
+	storeWebhookPayment(t, storage, StatusInit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, result := startServe(t, client, ctx, WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{certificate}}))
+
+	httpsClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	defer httpsClient.CloseIdleConnections()
+
+	var status int
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if status, err = statusRequest(httpsClient, "https://"+addr); err == nil {
+			break
+		}
+	}
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("HTTPS request: status %d, %v", status, err)
+	}
+
+	// Plain HTTP is not served on the TLS port
+	if status, err := statusRequest(http.DefaultClient, "http://"+addr); err == nil && status == http.StatusOK {
+		t.Fatal("plain HTTP request succeeded")
+	}
+
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("Serve() = %v", err)
+	}
+}
+
+// newTestCertificate returns a self-signed certificate for 127.0.0.1 and a pool trusting it
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vandargo test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
@@ -0,0 +1,210 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// server.go implements running the payment routes as a standalone HTTP server
+package vandargo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultShutdownGracePeriod is how long in-flight requests may finish on shutdown
+	defaultShutdownGracePeriod = 15 * time.Second
+
+	// Server timeouts guarding against slow clients
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+)
+
+var (
+	// ErrServerFailed is returned by Serve when the server could not start or stopped unexpectedly
+	ErrServerFailed = errors.New("server failed")
+
+	// ErrShutdownFailed is returned by Serve when in-flight requests did not drain in time
+	ErrShutdownFailed = errors.New("server shutdown failed")
+)
+
+// serveOptions holds the settings collected from ServeOption values
+type serveOptions struct {
+	certFile     string
+	keyFile      string
+	tlsConfig    *tls.Config
+	gracePeriod  time.Duration
+	listener     net.Listener
+	routeOptions []RouteOption
+}
+
+// ServeOption configures Client.Serve
+type ServeOption func(*serveOptions)
+
+// WithTLS serves HTTPS using the given certificate and key files
+func WithTLS(certFile, keyFile string) ServeOption {
+	return func(o *serveOptions) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}
+
+// WithTLSConfig sets the TLS configuration of the server, e.g. with in-memory
+// certificates; it is combined with the files of WithTLS when both are given
+func WithTLSConfig(config *tls.Config) ServeOption {
+	return func(o *serveOptions) {
+		o.tlsConfig = config.Clone()
+	}
+}
+
+// WithClientAuth asks clients for certificates signed by clientCAs, e.g.
+// tls.VerifyClientCertIfGiven so only routes with MTLSMiddleware require them
+func WithClientAuth(clientCAs *x509.CertPool, clientAuth tls.ClientAuthType) ServeOption {
+	return func(o *serveOptions) {
+		if o.tlsConfig == nil {
+			o.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		o.tlsConfig.ClientCAs = clientCAs
+		o.tlsConfig.ClientAuth = clientAuth
+	}
+}
+
+// WithShutdownGracePeriod sets how long in-flight requests may finish after the context is canceled
+func WithShutdownGracePeriod(period time.Duration) ServeOption {
+	return func(o *serveOptions) {
+		o.gracePeriod = period
+	}
+}
+
+// WithListener serves on an existing listener instead of listening on addr
+func WithListener(listener net.Listener) ServeOption {
+	return func(o *serveOptions) {
+		o.listener = listener
+	}
+}
+
+// WithServeRouteOptions passes route options to the served Handler
+func WithServeRouteOptions(opts ...RouteOption) ServeOption {
+	return func(o *serveOptions) {
+		o.routeOptions = append(o.routeOptions, opts...)
+	}
+}
+
+// Serve runs the payment routes on addr until ctx is canceled, then drains in-flight
+// requests within the grace period and closes the client. Startup and runtime
+// failures wrap ErrServerFailed, drain timeouts wrap ErrShutdownFailed. The client
+// is warmed up before requests are accepted; a failed warm-up is only logged
+// unless Config.StrictWarmup is set, then it is returned wrapping ErrWarmupFailed.
+func (c *Client) Serve(ctx context.Context, addr string, opts ...ServeOption) error {
+	options := &serveOptions{gracePeriod: defaultShutdownGracePeriod}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	// Connect to the gateway before the first payment has to
+	if err := c.warmupBeforeServe(ctx); err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           c.Handler(options.routeOptions...),
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		ReadTimeout:       defaultReadTimeout,
+		WriteTimeout:      defaultWriteTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+		TLSConfig:         options.tlsConfig,
+	}
+
+	// Run the server until it fails or is shut down
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- c.listenAndServe(server, options)
+	}()
+
+	c.log(ctx).Info(ctx, "Payment server started", map[string]interface{}{
+		"addr": addr,
+		"tls":  options.usesTLS(),
+	})
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("%w: %w", ErrServerFailed, err)
+	case <-ctx.Done():
+	}
+
+	// Drain in-flight requests, detached from the canceled context
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), options.gracePeriod)
+	defer cancel()
+
+	c.log(shutdownCtx).Info(shutdownCtx, "Shutting down payment server", map[string]interface{}{
+		"grace_period_ms": options.gracePeriod.Milliseconds(),
+	})
+
+	// Refuse new operations and let running ones reach storage
+	if err := c.DrainAndWait(shutdownCtx); err != nil {
+		_ = server.Close()
+		return err
+	}
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		_ = server.Close()
+		return fmt.Errorf("%w: %w", ErrShutdownFailed, err)
+	}
+
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%w: %w", ErrServerFailed, err)
+	}
+
+	return c.Close(shutdownCtx)
+}
+
+// listenAndServe starts the server on the configured listener or address
+func (c *Client) listenAndServe(server *http.Server, options *serveOptions) error {
+	useTLS := options.usesTLS()
+
+	if options.listener != nil {
+		if useTLS {
+			return server.ServeTLS(options.listener, options.certFile, options.keyFile)
+		}
+		return server.Serve(options.listener)
+	}
+
+	if useTLS {
+		return server.ListenAndServeTLS(options.certFile, options.keyFile)
+	}
+	return server.ListenAndServe()
+}
+
+// usesTLS reports whether the server serves HTTPS
+func (o *serveOptions) usesTLS() bool {
+	if o.certFile != "" || o.keyFile != "" {
+		return true
+	}
+	return o.tlsConfig != nil && (len(o.tlsConfig.Certificates) > 0 || o.tlsConfig.GetCertificate != nil)
+}
+
+// Close releases resources held by the client, flushing a logger and closing a
+// storage that support it. It is safe to call more than once.
+func (c *Client) Close(ctx context.Context) error {
+	var errs []error
+
+	if closer, ok := c.logger.(interface{ Close(context.Context) error }); ok {
+		if err := closer.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close logger: %w", err))
+		}
+	}
+
+	if closer, ok := c.storage.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close storage: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
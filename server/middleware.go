@@ -0,0 +1,173 @@
+// Package server is a facade over the HTTP side of vandargo: routes, middleware and the server
+// middleware.go forwards the middleware constructors of the payment routes
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/uussoop/vandargo"
+)
+
+type (
+	// Middleware wraps an HTTP handler
+	Middleware = vandargo.Middleware
+
+	// LoggingOption configures LoggingMiddleware
+	LoggingOption = vandargo.LoggingOption
+)
+
+// Chain applies middlewares to a handler, the first one outermost
+func Chain(handler http.HandlerFunc, middlewares ...Middleware) http.HandlerFunc {
+	return vandargo.Chain(handler, middlewares...)
+}
+
+// WithSlowRequestThreshold logs requests slower than threshold as warnings
+func WithSlowRequestThreshold(threshold time.Duration) LoggingOption {
+	return vandargo.WithSlowRequestThreshold(threshold)
+}
+
+// WithSampleRate logs only this fraction of successful requests
+func WithSampleRate(rate float64) LoggingOption {
+	return vandargo.WithSampleRate(rate)
+}
+
+// WithSuccessLogLevel sets the level successful requests are logged at
+func WithSuccessLogLevel(level vandargo.LogLevel) LoggingOption {
+	return vandargo.WithSuccessLogLevel(level)
+}
+
+// WithClientErrorBodies logs the response bodies of 4xx responses
+func WithClientErrorBodies() LoggingOption {
+	return vandargo.WithClientErrorBodies()
+}
+
+// LoggingMiddleware logs each request
+func LoggingMiddleware(logger vandargo.LoggerInterface, opts ...LoggingOption) Middleware {
+	return vandargo.LoggingMiddleware(logger, opts...)
+}
+
+// RequestIDMiddleware adds a request ID to each request context
+func RequestIDMiddleware() Middleware {
+	return vandargo.RequestIDMiddleware()
+}
+
+// RequestIDMiddlewareWithGenerator adds a request ID created by generator to each request context
+func RequestIDMiddlewareWithGenerator(generator vandargo.IDGenerator) Middleware {
+	return vandargo.RequestIDMiddlewareWithGenerator(generator)
+}
+
+// CorrelationMiddleware stores the correlation ID of a request in its context and echoes it
+func CorrelationMiddleware(config vandargo.ConfigInterface, generator vandargo.IDGenerator) Middleware {
+	return vandargo.CorrelationMiddleware(config, generator)
+}
+
+// ClientIPMiddleware resolves the real client IP once per request
+func ClientIPMiddleware(config vandargo.ConfigInterface) Middleware {
+	return vandargo.ClientIPMiddleware(config)
+}
+
+// ClientIPMiddlewareWithLogger resolves the real client IP, logging trusted proxies that don't parse
+func ClientIPMiddlewareWithLogger(config vandargo.ConfigInterface, logger vandargo.LoggerInterface) Middleware {
+	return vandargo.ClientIPMiddlewareWithLogger(config, logger)
+}
+
+// ContextLoggerMiddleware stores a logger enriched with request fields in the request context
+func ContextLoggerMiddleware(logger vandargo.LoggerInterface) Middleware {
+	return vandargo.ContextLoggerMiddleware(logger)
+}
+
+// BufferBodyMiddleware reads the request body once, up to maxBytes, so several components can consume it
+func BufferBodyMiddleware(maxBytes int64) Middleware {
+	return vandargo.BufferBodyMiddleware(maxBytes)
+}
+
+// SecurityHeadersMiddleware sets security headers on each response
+func SecurityHeadersMiddleware() Middleware {
+	return vandargo.SecurityHeadersMiddleware()
+}
+
+// ResponseEncoderMiddleware makes an encoder available to middleware that writes JSON errors
+func ResponseEncoderMiddleware(encoder vandargo.ResponseEncoder) Middleware {
+	return vandargo.ResponseEncoderMiddleware(encoder)
+}
+
+// MetricsMiddleware records the duration of each request
+func MetricsMiddleware(metrics vandargo.MetricsInterface) Middleware {
+	return vandargo.MetricsMiddleware(metrics)
+}
+
+// RateLimitMiddleware limits requests per client IP
+func RateLimitMiddleware(limit int, window time.Duration) Middleware {
+	return vandargo.RateLimitMiddleware(limit, window)
+}
+
+// RateLimitMiddlewareWithClock limits requests per client IP with windows measured by clock
+func RateLimitMiddlewareWithClock(limit int, window time.Duration, clock vandargo.Clock) Middleware {
+	return vandargo.RateLimitMiddlewareWithClock(limit, window, clock)
+}
+
+// RateLimitMiddlewareWithMetrics limits requests per client IP, counting rejections
+func RateLimitMiddlewareWithMetrics(limit int, window time.Duration, clock vandargo.Clock, metrics vandargo.MetricsInterface) Middleware {
+	return vandargo.RateLimitMiddlewareWithMetrics(limit, window, clock, metrics)
+}
+
+// RateLimitMiddlewareWithStore limits requests per client IP with counters shared through kv
+func RateLimitMiddlewareWithStore(limit int, window time.Duration, clock vandargo.Clock, metrics vandargo.MetricsInterface, kv vandargo.KVStore) Middleware {
+	return vandargo.RateLimitMiddlewareWithStore(limit, window, clock, metrics, kv)
+}
+
+// IPFilterMiddleware filters requests by the configured IP allowlist
+func IPFilterMiddleware(config vandargo.ConfigInterface) Middleware {
+	return vandargo.IPFilterMiddleware(config)
+}
+
+// AuthMiddleware validates the API key and requires it to grant every given scope
+func AuthMiddleware(config vandargo.ConfigInterface, scopes ...vandargo.Scope) Middleware {
+	return vandargo.AuthMiddleware(config, scopes...)
+}
+
+// JWTAuthMiddleware authenticates requests with a JWT bearer token granting every given scope
+func JWTAuthMiddleware(verifier *vandargo.JWTVerifier, scopes ...vandargo.Scope) Middleware {
+	return vandargo.JWTAuthMiddleware(verifier, scopes...)
+}
+
+// AdminKeyMiddleware requires the configured admin key in the X-Admin-Key header
+func AdminKeyMiddleware(config vandargo.ConfigInterface) Middleware {
+	return vandargo.AdminKeyMiddleware(config)
+}
+
+// MetricsTokenMiddleware requires token as the bearer token of the request
+func MetricsTokenMiddleware(token string) Middleware {
+	return vandargo.MetricsTokenMiddleware(token)
+}
+
+// ValidateSignatureMiddleware validates request signatures
+func ValidateSignatureMiddleware(config vandargo.ConfigInterface) Middleware {
+	return vandargo.ValidateSignatureMiddleware(config)
+}
+
+// ValidateSignatureMiddlewareWithNonces validates request signatures and rejects replayed nonces
+func ValidateSignatureMiddlewareWithNonces(config vandargo.ConfigInterface, nonces *vandargo.NonceStore) Middleware {
+	return vandargo.ValidateSignatureMiddlewareWithNonces(config, nonces)
+}
+
+// IdempotencyMiddleware replays the response of POST requests repeated with the same Idempotency-Key
+func IdempotencyMiddleware(store *vandargo.IdempotencyStore, logger vandargo.LoggerInterface) Middleware {
+	return vandargo.IdempotencyMiddleware(store, logger)
+}
+
+// MTLSMiddleware accepts only requests presenting an accepted client certificate
+func MTLSMiddleware(config MTLSConfig) Middleware {
+	return vandargo.MTLSMiddleware(config)
+}
+
+// CORSMiddleware answers cross-origin requests of browsers
+func CORSMiddleware(config CORSConfig) Middleware {
+	return vandargo.CORSMiddleware(config)
+}
+
+// GzipMiddleware compresses responses for clients accepting gzip
+func GzipMiddleware() Middleware {
+	return vandargo.GzipMiddleware()
+}
@@ -0,0 +1,135 @@
+// Package server is a facade over the HTTP side of vandargo: routes, middleware and the server
+// options.go forwards the route and serve options
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+
+	"github.com/uussoop/vandargo"
+)
+
+type (
+	// CORSConfig configures cross-origin requests from browsers
+	CORSConfig = vandargo.CORSConfig
+
+	// MTLSConfig configures the client certificates accepted from the gateway
+	MTLSConfig = vandargo.MTLSConfig
+)
+
+// DefaultCORSConfig returns a CORS configuration allowing the given origins
+func DefaultCORSConfig(allowedOrigins ...string) CORSConfig {
+	return vandargo.DefaultCORSConfig(allowedOrigins...)
+}
+
+// WithCORS answers cross-origin requests of browsers to the browser-facing routes
+func WithCORS(config CORSConfig) RouteOption {
+	return vandargo.WithCORS(config)
+}
+
+// WithGzip compresses responses of the browser-facing routes
+func WithGzip() RouteOption {
+	return vandargo.WithGzip()
+}
+
+// WithLoggingOptions configures the request logging of every route
+func WithLoggingOptions(opts ...LoggingOption) RouteOption {
+	return vandargo.WithLoggingOptions(opts...)
+}
+
+// WithRouteLogLevel sets the level successful requests to a route are logged at
+func WithRouteLogLevel(path string, level vandargo.LogLevel) RouteOption {
+	return vandargo.WithRouteLogLevel(path, level)
+}
+
+// WithRouteMiddleware replaces the default middlewares of a route
+func WithRouteMiddleware(path string, middlewares ...Middleware) RouteOption {
+	return vandargo.WithRouteMiddleware(path, middlewares...)
+}
+
+// WithRoutePrepend adds middlewares at the start of the chain of a route
+func WithRoutePrepend(path string, middlewares ...Middleware) RouteOption {
+	return vandargo.WithRoutePrepend(path, middlewares...)
+}
+
+// WithRouteAppend adds middlewares at the end of the chain of a route, right before the handler
+func WithRouteAppend(path string, middlewares ...Middleware) RouteOption {
+	return vandargo.WithRouteAppend(path, middlewares...)
+}
+
+// WithCallbackSignature requires a valid request signature on the callback route
+func WithCallbackSignature() RouteOption {
+	return vandargo.WithCallbackSignature()
+}
+
+// WithGatewayMTLS requires a client certificate on the callback and webhook routes
+func WithGatewayMTLS(config MTLSConfig) RouteOption {
+	return vandargo.WithGatewayMTLS(config)
+}
+
+// WithJWTAuth authenticates the API and admin routes with JWT bearer tokens
+func WithJWTAuth(verifier *vandargo.JWTVerifier) RouteOption {
+	return vandargo.WithJWTAuth(verifier)
+}
+
+// WithOpenAPIRoute serves the OpenAPI document at GET /payments/openapi.json
+func WithOpenAPIRoute() RouteOption {
+	return vandargo.WithOpenAPIRoute()
+}
+
+// WithQRCodeRoute registers GET /payments/qr
+func WithQRCodeRoute() RouteOption {
+	return vandargo.WithQRCodeRoute()
+}
+
+// WithTransferRoute registers POST /payments/transfer
+func WithTransferRoute() RouteOption {
+	return vandargo.WithTransferRoute()
+}
+
+// WithHealthRoute registers GET /payments/health
+func WithHealthRoute() RouteOption {
+	return vandargo.WithHealthRoute()
+}
+
+// WithRefundBatchRoute registers POST /payments/refund/batch
+func WithRefundBatchRoute() RouteOption {
+	return vandargo.WithRefundBatchRoute()
+}
+
+// WithMetricsRoute registers GET /payments/metrics, authenticated with token when not empty
+func WithMetricsRoute(token string) RouteOption {
+	return vandargo.WithMetricsRoute(token)
+}
+
+// WithTLS serves HTTPS using the given certificate and key files
+func WithTLS(certFile, keyFile string) ServeOption {
+	return vandargo.WithTLS(certFile, keyFile)
+}
+
+// WithTLSConfig sets the TLS configuration of the server
+func WithTLSConfig(config *tls.Config) ServeOption {
+	return vandargo.WithTLSConfig(config)
+}
+
+// WithClientAuth asks clients for certificates signed by clientCAs
+func WithClientAuth(clientCAs *x509.CertPool, clientAuth tls.ClientAuthType) ServeOption {
+	return vandargo.WithClientAuth(clientCAs, clientAuth)
+}
+
+// WithShutdownGracePeriod sets how long in-flight requests may finish after the context is canceled
+func WithShutdownGracePeriod(period time.Duration) ServeOption {
+	return vandargo.WithShutdownGracePeriod(period)
+}
+
+// WithListener serves on an existing listener instead of listening on addr
+func WithListener(listener net.Listener) ServeOption {
+	return vandargo.WithListener(listener)
+}
+
+// WithServeRouteOptions passes route options to the served handler
+func WithServeRouteOptions(opts ...RouteOption) ServeOption {
+	return vandargo.WithServeRouteOptions(opts...)
+}
@@ -0,0 +1,79 @@
+// Package server is a facade over the HTTP side of vandargo: routes, middleware and the server
+// server.go forwards registering and serving the payment routes of a client
+//
+// Import this package for the HTTP pieces and vandargo for the API client:
+//
+//	client, err := vandargo.NewClient(config, storage, logger)
+//	...
+//	mux := http.NewServeMux()
+//	server.RegisterRoutes(client, server.NewServeMuxRouter(mux), server.WithHealthRoute())
+//
+// The package is a facade: its types are aliases of the vandargo types and its
+// functions forward to vandargo, where the handlers live because they use the
+// client's internals. Both import paths can be mixed freely, and importing the
+// core alone still links the HTTP code. The package depends on vandargo only,
+// never the other way around.
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/uussoop/vandargo"
+)
+
+type (
+	// RouterInterface registers HTTP routes
+	RouterInterface = vandargo.RouterInterface
+
+	// OptionsRouterInterface is a router that can also register OPTIONS routes
+	OptionsRouterInterface = vandargo.OptionsRouterInterface
+
+	// ServeMuxRouter registers routes on a standard library ServeMux
+	ServeMuxRouter = vandargo.ServeMuxRouter
+
+	// RouteOption configures the registered routes
+	RouteOption = vandargo.RouteOption
+
+	// RouteDescriptor describes a registered route
+	RouteDescriptor = vandargo.RouteDescriptor
+
+	// ServeOption configures Serve
+	ServeOption = vandargo.ServeOption
+)
+
+// NewServeMuxRouter returns a router registering routes on mux
+func NewServeMuxRouter(mux *http.ServeMux) *ServeMuxRouter {
+	return vandargo.NewServeMuxRouter(mux)
+}
+
+// RegisterRoutes registers the payment routes of a client
+func RegisterRoutes(client *vandargo.Client, router RouterInterface, opts ...RouteOption) {
+	client.RegisterRoutes(router, opts...)
+}
+
+// RegisterRoutesWithPrefix registers the payment routes of a client under a path prefix
+func RegisterRoutesWithPrefix(client *vandargo.Client, router RouterInterface, prefix string, opts ...RouteOption) {
+	client.RegisterRoutesWithPrefix(router, prefix, opts...)
+}
+
+// Handler returns an http.Handler serving the payment routes of a client
+func Handler(client *vandargo.Client, opts ...RouteOption) http.Handler {
+	return client.Handler(opts...)
+}
+
+// Routes describes the routes RegisterRoutes registers with the options
+func Routes(client *vandargo.Client, opts ...RouteOption) []RouteDescriptor {
+	return client.Routes(opts...)
+}
+
+// GenerateOpenAPI returns the OpenAPI document of the routes registered with the options
+func GenerateOpenAPI(client *vandargo.Client, opts ...RouteOption) ([]byte, error) {
+	return client.GenerateOpenAPI(opts...)
+}
+
+// Serve serves the payment routes of a client on addr until ctx is done, then
+// shuts down gracefully
+func Serve(ctx context.Context, client *vandargo.Client, addr string, opts ...ServeOption) error {
+	return client.Serve(ctx, addr, opts...)
+}
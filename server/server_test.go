@@ -0,0 +1,142 @@
+package server_test
+
+import (
+	"go/build"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uussoop/vandargo"
+	"github.com/uussoop/vandargo/server"
+	"github.com/uussoop/vandargo/vandargotest"
+)
+
+const modulePath = "github.com/uussoop/vandargo"
+
+// packageImports returns the imports of the non-test files of the package in dir
+func packageImports(t *testing.T, dir string) []string {
+	t.Helper()
+
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		t.Fatalf("failed to read package %s: %v", dir, err)
+	}
+	return pkg.Imports
+}
+
+func TestImportGraph(t *testing.T) {
+	// The server package depends on the core and the standard library only
+	for _, path := range packageImports(t, ".") {
+		if path != modulePath && strings.Contains(strings.Split(path, "/")[0], ".") {
+			t.Errorf("server imports %s", path)
+		}
+	}
+
+	// The core never depends on the server package or any other package of the module
+	for _, path := range packageImports(t, "..") {
+		if strings.HasPrefix(path, modulePath+"/") {
+			t.Errorf("vandargo imports %s", path)
+		}
+	}
+}
+
+// newClient creates a client answering from a scripted gateway
+func newClient(t *testing.T) *vandargo.Client {
+	t.Helper()
+
+	client, err := vandargo.NewClient(vandargotest.NewStaticConfig(), vandargotest.NewFakeStorage(), vandargotest.NewFakeLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.WithHTTPClient(vandargotest.NewScriptedHTTPClient())
+}
+
+func TestRoutesMatchClient(t *testing.T) {
+	client := newClient(t)
+	opts := []server.RouteOption{server.WithHealthRoute(), server.WithOpenAPIRoute(), server.WithMetricsRoute("")}
+
+	if got, want := server.Routes(client, opts...), client.Routes(opts...); !reflect.DeepEqual(got, want) {
+		t.Fatalf("server.Routes = %v, want %v", got, want)
+	}
+
+	got, err := server.GenerateOpenAPI(client, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := client.GenerateOpenAPI(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Fatal("server.GenerateOpenAPI differs from the client's document")
+	}
+}
+
+func TestRegisterRoutes(t *testing.T) {
+	client := newClient(t)
+	mux := http.NewServeMux()
+	server.RegisterRoutes(client, server.NewServeMuxRouter(mux), server.WithHealthRoute())
+
+	tests := []struct {
+		method, path, authorization string
+		want                        int
+	}{
+		{http.MethodGet, "/payments/health", "", http.StatusOK},
+		{http.MethodPost, "/payments/verify", "", http.StatusUnauthorized},
+		{http.MethodPost, "/payments/verify", "Bearer wrong", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestHandlerWithPrefix(t *testing.T) {
+	client := newClient(t)
+	mux := http.NewServeMux()
+	server.RegisterRoutesWithPrefix(client, server.NewServeMuxRouter(mux), "/shop", server.WithHealthRoute())
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/shop/payments/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("prefixed health route: status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	server.Handler(client, server.WithHealthRoute()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/payments/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("handler health route: status %d", rec.Code)
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	var order []string
+	record := func(name string) server.Middleware {
+		return func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next(w, r)
+			}
+		}
+	}
+
+	handler := server.Chain(func(w http.ResponseWriter, r *http.Request) {}, record("outer"), server.SecurityHeadersMiddleware(), record("inner"))
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if strings.Join(order, ",") != "outer,inner" || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("order %v, headers %v", order, rec.Header())
+	}
+}
@@ -0,0 +1,139 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// server_keys.go implements scoped API keys for the payment endpoints
+package vandargo
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+// InsufficientScopeCode is the error code of responses sent when the API key is
+// valid but lacks the scope of the route
+const InsufficientScopeCode = "insufficient_scope"
+
+// Scope is a permission granted to a server API key
+type Scope string
+
+const (
+	// ScopeRead allows status and transaction lookups
+	ScopeRead Scope = "read"
+
+	// ScopeWrite allows initializing and verifying payments
+	ScopeWrite Scope = "write"
+
+	// ScopeRefund allows refunds
+	ScopeRefund Scope = "refund"
+
+	// ScopeAdmin allows administrative endpoints and implies every other scope
+	ScopeAdmin Scope = "admin"
+)
+
+// IsKnown reports whether the scope is one of the defined scopes
+func (s Scope) IsKnown() bool {
+	switch s {
+	case ScopeRead, ScopeWrite, ScopeRefund, ScopeAdmin:
+		return true
+	default:
+		return false
+	}
+}
+
+// ServerKey is an API key accepted by the payment endpoints and the scopes it grants
+type ServerKey struct {
+	// Key is the bearer token callers send in the Authorization header
+	Key string `json:"key" yaml:"key"`
+
+	// Scopes are the permissions of the key
+	Scopes []Scope `json:"scopes" yaml:"scopes"`
+}
+
+// HasScope reports whether the key grants a scope
+func (k ServerKey) HasScope(scope Scope) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeError reports a valid API key lacking the scope of a route
+type ScopeError struct {
+	// Required is the scope the route requires
+	Required Scope
+}
+
+// Error describes the missing scope
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("%s: API key lacks the %q scope", ErrPermission, e.Required)
+}
+
+// Unwrap returns ErrPermission
+func (e *ScopeError) Unwrap() error {
+	return ErrPermission
+}
+
+// serverKeys returns the keys accepted by the payment endpoints. Without
+// configured ServerAPIKeys the merchant API key is accepted with every scope.
+func serverKeys(config ConfigInterface) []ServerKey {
+	if keys := configValues(config).ServerAPIKeys; len(keys) > 0 {
+		return keys
+	}
+	return []ServerKey{{Key: config.GetAPIKey(), Scopes: []Scope{ScopeAdmin}}}
+}
+
+// matchServerKey returns the key matching a presented token. Every key is compared
+// in constant time so the response time doesn't reveal which keys exist.
+func matchServerKey(keys []ServerKey, presented string) (ServerKey, bool) {
+	var match ServerKey
+	found := false
+	for _, key := range keys {
+		if key.Key == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(presented)) == 1 && !found {
+			match = key
+			found = true
+		}
+	}
+	return match, found
+}
+
+// parseServerKeys parses keys written as key:scope+scope, separated by commas
+func parseServerKeys(value string) ([]ServerKey, error) {
+	var keys []ServerKey
+	for _, item := range splitList(value) {
+		key, scopes, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("server api key %q has no scopes, expected key:scope+scope", redactToken(key))
+		}
+
+		serverKey := ServerKey{Key: strings.TrimSpace(key)}
+		for _, scope := range strings.Split(scopes, "+") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				serverKey.Scopes = append(serverKey.Scopes, Scope(scope))
+			}
+		}
+		keys = append(keys, serverKey)
+	}
+	return keys, nil
+}
+
+// validateServerKeys checks that every server key is set and has known scopes
+func validateServerKeys(keys []ServerKey) error {
+	for i, key := range keys {
+		if key.Key == "" {
+			return fmt.Errorf("server api key %d is empty", i+1)
+		}
+		if len(key.Scopes) == 0 {
+			return fmt.Errorf("server api key %d has no scopes", i+1)
+		}
+		for _, scope := range key.Scopes {
+			if !scope.IsKnown() {
+				return fmt.Errorf("server api key %d has unknown scope %q", i+1, scope)
+			}
+		}
+	}
+	return nil
+}
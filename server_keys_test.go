@@ -0,0 +1,133 @@
+package vandargo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scopedKeys are server keys granting one scope each, used from their own
+// addresses so that rate limits apply to each separately
+var scopedKeys = []struct {
+	scope Scope
+	key   string
+	addr  string
+}{
+	{ScopeRead, "key-read", "198.51.100.1:40000"},
+	{ScopeWrite, "key-write", "198.51.100.2:40000"},
+	{ScopeRefund, "key-refund", "198.51.100.3:40000"},
+	{ScopeAdmin, "key-admin", "198.51.100.4:40000"},
+}
+
+func TestRouteScopes(t *testing.T) {
+	config := testConfig(t, func(c *Config) {
+		c.AdminKey = "admin-key"
+		for _, scoped := range scopedKeys {
+			c.ServerAPIKeys = append(c.ServerAPIKeys, ServerKey{Key: scoped.key, Scopes: []Scope{scoped.scope}})
+		}
+	})
+	client, _, _ := newTestClient(t, config, NewSimulatorTransport())
+	handler := client.Handler(allRouteOptions()...)
+
+	for _, rt := range client.Routes(allRouteOptions()...) {
+		// Webhooks authenticate with their signature instead of a key
+		if rt.Scope == "" {
+			continue
+		}
+
+		for _, scoped := range scopedKeys {
+			granted := ServerKey{Scopes: []Scope{scoped.scope}}.HasScope(rt.Scope)
+			rec := scopedRequest(handler, rt.Method, rt.Path, scoped.key, scoped.addr)
+
+			var body map[string]interface{}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			denied := rec.Code == http.StatusForbidden && body["code"] == InsufficientScopeCode
+			if denied == granted || rec.Code == http.StatusUnauthorized {
+				t.Errorf("%s %s with a %s key: status %d: %s", rt.Method, rt.Path, scoped.scope, rec.Code, rec.Body)
+			}
+		}
+	}
+
+	// Unknown keys are unauthenticated, not unscoped
+	if rec := scopedRequest(handler, http.MethodGet, "/payments/status", "key-unknown", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown key: status %d", rec.Code)
+	}
+
+	// The merchant API key is only accepted when no server keys are configured
+	if rec := scopedRequest(handler, http.MethodGet, "/payments/status", testAPIKey, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("merchant key next to server keys: status %d", rec.Code)
+	}
+}
+
+// scopedRequest sends a request with a bearer key and the admin key
+func scopedRequest(handler http.Handler, method, path, key, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, strings.ReplaceAll(path, "{id}", "tx1"), strings.NewReader(`{}`))
+	if remoteAddr != "" {
+		req.RemoteAddr = remoteAddr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set(AdminKeyHeader, "admin-key")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMultipleScopesAndKeys(t *testing.T) {
+	// A reporting key reads, a backend key reads and writes; both stay active
+	config := testConfig(t, func(c *Config) {
+		c.ServerAPIKeys = []ServerKey{
+			{Key: "key-reports", Scopes: []Scope{ScopeRead}},
+			{Key: "key-backend", Scopes: []Scope{ScopeRead, ScopeWrite}},
+		}
+	})
+	handler := AuthMiddleware(config, ScopeRead, ScopeWrite)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for key, want := range map[string]int{
+		"key-reports":  http.StatusForbidden,
+		"key-backend":  http.StatusNoContent,
+		"key-back":     http.StatusUnauthorized,
+		"key-backendx": http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", key, rec.Code, want)
+		}
+	}
+}
+
+func TestParseServerKeys(t *testing.T) {
+	keys, err := parseServerKeys("key-reports:read, key-backend:read+write ,key-ops:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ServerKey{
+		{Key: "key-reports", Scopes: []Scope{ScopeRead}},
+		{Key: "key-backend", Scopes: []Scope{ScopeRead, ScopeWrite}},
+		{Key: "key-ops", Scopes: []Scope{ScopeAdmin}},
+	}
+	if !reflect.DeepEqual(keys, want) || validateServerKeys(keys) != nil {
+		t.Fatalf("parsed %+v", keys)
+	}
+
+	if _, err := parseServerKeys("key-without-scopes"); err == nil || strings.Contains(err.Error(), "key-without-scopes") {
+		t.Fatalf("key without scopes: %v", err)
+	}
+	for _, invalid := range [][]ServerKey{
+		{{Key: "", Scopes: []Scope{ScopeRead}}},
+		{{Key: "key-none"}},
+		{{Key: "key-bi", Scopes: []Scope{"reports"}}},
+	} {
+		if validateServerKeys(invalid) == nil {
+			t.Errorf("%+v accepted", invalid)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// session.go implements short-lived payment sessions that stand in for payment tokens
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionIDLength is the length of generated payment session IDs
+	sessionIDLength = 32
+
+	// sessionCompletedGrace is how long a session keeps resolving after its
+	// transaction completed, so frontends can read the final status
+	sessionCompletedGrace = 5 * time.Minute
+)
+
+// ErrSessionNotFound is returned for unknown and expired payment sessions
+var ErrSessionNotFound = fmt.Errorf("%w: payment session not found or expired", ErrNotFound)
+
+// PaymentSession maps an opaque session ID handed to a frontend to a payment token
+type PaymentSession struct {
+	// ID is the random session identifier
+	ID string `json:"id"`
+
+	// Token is the payment token the session stands in for
+	Token string `json:"token"`
+
+	// CreatedAt is when the session was issued
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is when the session stops resolving
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SessionStorageInterface is implemented by storages that can keep payment sessions.
+// Without it sessions are kept in memory and only resolve on the issuing instance.
+type SessionStorageInterface interface {
+	// StoreSession saves a payment session
+	StoreSession(ctx context.Context, session *PaymentSession) error
+
+	// GetSession retrieves a payment session by ID
+	GetSession(ctx context.Context, id string) (*PaymentSession, error)
+
+	// DeleteSession removes a payment session
+	DeleteSession(ctx context.Context, id string) error
+}
+
+// MemorySessionStore is an in-memory implementation of SessionStorageInterface
+type MemorySessionStore struct {
+	sessions map[string]*PaymentSession
+	mutex    sync.Mutex
+}
+
+// NewMemorySessionStore creates a new in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*PaymentSession),
+	}
+}
+
+// StoreSession saves a payment session, dropping expired ones on the way
+func (s *MemorySessionStore) StoreSession(ctx context.Context, session *PaymentSession) error {
+	if session == nil || session.ID == "" {
+		return errors.New("session ID cannot be empty")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for id, existing := range s.sessions {
+		if now.After(existing.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+
+	sessionCopy := *session
+	s.sessions[session.ID] = &sessionCopy
+	return nil
+}
+
+// GetSession retrieves a payment session by ID
+func (s *MemorySessionStore) GetSession(ctx context.Context, id string) (*PaymentSession, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, exists := s.sessions[id]
+	if !exists {
+		return nil, fmt.Errorf("session not found: %s", id)
+	}
+
+	sessionCopy := *session
+	return &sessionCopy, nil
+}
+
+// DeleteSession removes a payment session
+func (s *MemorySessionStore) DeleteSession(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// sessionStorage returns the storage itself when it can keep sessions, or the
+// client's in-memory session store
+func (c *Client) sessionStorage() SessionStorageInterface {
+	if sessions, ok := c.storage.(SessionStorageInterface); ok {
+		return sessions
+	}
+	return c.sessions
+}
+
+// IssueSession creates a payment session for a token that expires with the token
+func (c *Client) IssueSession(ctx context.Context, token string, expiresAt time.Time) (*PaymentSession, error) {
+	if token == "" {
+		return nil, fmt.Errorf("%w: token is required", ErrInvalidRequest)
+	}
+
+	id, err := GenerateRandomString(sessionIDLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	now := c.clock.Now()
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(c.tokenLifetime())
+	}
+
+	session := &PaymentSession{
+		ID:        id,
+		Token:     token,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if err := c.sessionStorage().StoreSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+
+	return session, nil
+}
+
+// ResolveSession returns the payment token behind a session. Unknown and expired
+// sessions return ErrSessionNotFound, as do sessions whose transaction completed
+// more than a few minutes ago.
+func (c *Client) ResolveSession(ctx context.Context, id string) (string, error) {
+	if id == "" {
+		return "", ErrSessionNotFound
+	}
+
+	sessions := c.sessionStorage()
+	session, err := sessions.GetSession(ctx, id)
+	if err != nil {
+		return "", ErrSessionNotFound
+	}
+
+	now := c.clock.Now()
+	expired := now.After(session.ExpiresAt)
+
+	// Completed payments only need the session long enough to show the result
+	if transaction, err := c.storage.GetTransaction(ctx, session.Token); err == nil {
+		if transaction.Status.IsTerminal() && transaction.CompletedAt != nil &&
+			now.After(transaction.CompletedAt.Add(sessionCompletedGrace)) {
+			expired = true
+		}
+	}
+
+	if expired {
+		if err := sessions.DeleteSession(ctx, id); err != nil {
+			c.log(ctx).Warn(ctx, "Failed to delete expired payment session", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		return "", ErrSessionNotFound
+	}
+
+	return session.Token, nil
+}
+
+// issueSessionID issues a session for a token in an init response, logging rather
+// than failing when no session can be issued
+func (c *Client) issueSessionID(ctx context.Context, token string, expiresAt *time.Time) string {
+	var expiry time.Time
+	if expiresAt != nil {
+		expiry = *expiresAt
+	}
+
+	session, err := c.IssueSession(ctx, token, expiry)
+	if err != nil {
+		c.log(ctx).Error(ctx, "Failed to issue payment session", err, map[string]interface{}{
+			"token": redactToken(token),
+		})
+		return ""
+	}
+
+	return session.ID
+}
+
+// requestToken returns the token of a request, resolving the session query
+// parameter when no token was given. It writes the error response and returns
+// false when the session cannot be resolved.
+func (c *Client) requestToken(w http.ResponseWriter, r *http.Request, token string) (string, bool) {
+	sessionID := r.URL.Query().Get("session")
+	if token != "" || sessionID == "" {
+		return token, true
+	}
+
+	resolved, err := c.ResolveSession(r.Context(), sessionID)
+	if err != nil {
+		c.respondWithError(w, ErrNotFound, "Payment session not found or expired")
+		return "", false
+	}
+
+	return resolved, true
+}
@@ -0,0 +1,100 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPaymentSessionExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) { c.TokenLifetime = 10 * time.Minute }), nil, WithClientClock(clock))
+	ctx := context.Background()
+
+	session, err := client.IssueSession(ctx, webhookToken, clock.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.ID) != sessionIDLength || session.ID == webhookToken {
+		t.Fatalf("session ID %q", session.ID)
+	}
+	if token, err := client.ResolveSession(ctx, session.ID); err != nil || token != webhookToken {
+		t.Fatalf("ResolveSession() = %q, %v", token, err)
+	}
+
+	// Without an expiry the session lives as long as the token
+	lasting, err := client.IssueSession(ctx, webhookToken, time.Time{})
+	if err != nil || !lasting.ExpiresAt.Equal(clock.Now().Add(10*time.Minute)) {
+		t.Fatalf("IssueSession() = %+v, %v", lasting, err)
+	}
+
+	clock.Advance(time.Minute + time.Second)
+	if _, err := client.ResolveSession(ctx, session.ID); !errors.Is(err, ErrSessionNotFound) || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired session resolved: %v", err)
+	}
+	if _, err := client.sessions.GetSession(ctx, session.ID); err == nil {
+		t.Fatal("expired session kept in the store")
+	}
+	if _, err := client.ResolveSession(ctx, lasting.ID); err != nil {
+		t.Fatalf("unexpired session: %v", err)
+	}
+
+	for _, id := range []string{"", "unknown-session"} {
+		if _, err := client.ResolveSession(ctx, id); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("ResolveSession(%q) = %v", id, err)
+		}
+	}
+	if _, err := client.IssueSession(ctx, "", time.Time{}); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("session without a token issued: %v", err)
+	}
+}
+
+func TestPaymentSessionThroughHandlers(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	simulator := NewSimulatorTransport(WithSimulatorPaidAfter(0))
+	client, storage, _ := newTestClient(t, testConfig(t), simulator, WithClientClock(clock))
+	server := httptest.NewServer(client.Handler())
+	defer server.Close()
+	api := serverClient{t, server}
+
+	resp, body := api.do(http.MethodPost, "/payments/init", "application/json", `{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+	session, _ := body["session_id"].(string)
+	token, _ := body["token"].(string)
+	if resp.StatusCode != http.StatusOK || session == "" || session == token {
+		t.Fatalf("init: status %d: %v", resp.StatusCode, body)
+	}
+	bySession := "?session=" + url.QueryEscape(session)
+
+	// The session stands in for the token on status and verify
+	if resp, body := api.do(http.MethodGet, "/payments/status"+bySession, "", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status by session: %d: %v", resp.StatusCode, body)
+	}
+	if resp, body := api.do(http.MethodPost, "/payments/verify"+bySession, "application/json", `{}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("verify by session: %d: %v", resp.StatusCode, body)
+	}
+	transaction, err := storage.GetTransaction(context.Background(), token)
+	if err != nil || transaction.Status != StatusPaid || transaction.CompletedAt == nil {
+		t.Fatalf("after verify: %+v, %v", transaction, err)
+	}
+
+	// A completed payment's session keeps resolving briefly to show the result
+	clock.Advance(sessionCompletedGrace - time.Second)
+	if resp, body := api.do(http.MethodGet, "/payments/status"+bySession, "", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status within the grace period: %d: %v", resp.StatusCode, body)
+	}
+
+	// and then stops
+	clock.Advance(2 * time.Second)
+	for _, path := range []string{"/payments/status" + bySession, "/payments/status?session=unknown-session"} {
+		if resp, body := api.do(http.MethodGet, path, "", ""); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s: %d: %v", path, resp.StatusCode, body)
+		}
+	}
+	if resp, body := api.do(http.MethodPost, "/payments/verify"+bySession, "application/json", `{}`); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("verify after completion: %d: %v", resp.StatusCode, body)
+	}
+}
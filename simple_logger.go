@@ -45,9 +45,9 @@ func (l *SimpleLogger) shouldLog(level LogLevel) bool {
 	}
 }
 
-// Debug logs debug level messages
+// Debug logs debug level messages, and those of traced contexts at any level
 func (l *SimpleLogger) Debug(ctx context.Context, message string, fields map[string]interface{}) {
-	if !l.shouldLog(Debug) {
+	if !l.shouldLog(Debug) && !IsTraced(ctx) {
 		return
 	}
 
@@ -0,0 +1,434 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// simulator.go implements an in-process stand-in for the Vandar payment gateway
+package vandargo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// simulatedPayment is the state of one payment known to the simulator
+type simulatedPayment struct {
+	amount       int64
+	description  string
+	factorNumber string
+	mobile       string
+	transID      int64
+	polls        int
+	refunded     int64
+	createdAt    time.Time
+	paidAt       time.Time
+}
+
+// simulatedRefund is the state of one refund known to the simulator
+type simulatedRefund struct {
+	amount int64
+	polls  int
+}
+
+// defaultSimulatorBalance is the simulated wallet balance, in Rials
+const defaultSimulatorBalance = 1_000_000_000
+
+// SimulatorTransport is an HTTPClientInterface answering payment gateway requests
+// in-process, for examples, demos and tests without network access. Payments stay
+// INIT for a number of status or transaction info lookups and then become PAID.
+// Refunds of paid payments are paid from a simulated wallet and stay PENDING for a
+// number of refund status lookups before they settle.
+type SimulatorTransport struct {
+	endpoints     Endpoints
+	paidAfter     int
+	refundedAfter int
+	balance       int64
+
+	mutex    sync.Mutex
+	payments map[string]*simulatedPayment
+	refunds  map[string]*simulatedRefund
+	sequence int64
+}
+
+// SimulatorOption configures a SimulatorTransport
+type SimulatorOption func(*SimulatorTransport)
+
+// WithSimulatorPaidAfter sets how many status or transaction info lookups a payment
+// stays INIT before it becomes PAID (1 by default; 0 pays immediately)
+func WithSimulatorPaidAfter(polls int) SimulatorOption {
+	return func(t *SimulatorTransport) {
+		if polls >= 0 {
+			t.paidAfter = polls
+		}
+	}
+}
+
+// WithSimulatorRefundSettledAfter sets how many refund status lookups a refund stays
+// PENDING before it is DONE (1 by default; 0 settles immediately)
+func WithSimulatorRefundSettledAfter(polls int) SimulatorOption {
+	return func(t *SimulatorTransport) {
+		if polls >= 0 {
+			t.refundedAfter = polls
+		}
+	}
+}
+
+// WithSimulatorBalance sets the wallet balance refunds are paid from, in Rials
+// (1,000,000,000 by default)
+func WithSimulatorBalance(balance int64) SimulatorOption {
+	return func(t *SimulatorTransport) {
+		if balance >= 0 {
+			t.balance = balance
+		}
+	}
+}
+
+// WithSimulatorEndpoints sets the endpoint paths the simulator answers, which must
+// match the client's Config.Endpoints when those are customized
+func WithSimulatorEndpoints(endpoints Endpoints) SimulatorOption {
+	return func(t *SimulatorTransport) {
+		t.endpoints = endpoints.merge(DefaultEndpoints(APIVersionV4))
+	}
+}
+
+// NewSimulatorTransport creates a simulator answering the default v4 endpoints
+func NewSimulatorTransport(opts ...SimulatorOption) *SimulatorTransport {
+	t := &SimulatorTransport{
+		endpoints:     DefaultEndpoints(APIVersionV4),
+		paidAfter:     1,
+		refundedAfter: 1,
+		balance:       defaultSimulatorBalance,
+		payments:      make(map[string]*simulatedPayment),
+		refunds:       make(map[string]*simulatedRefund),
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// Do answers a gateway request
+func (t *SimulatorTransport) Do(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	var body map[string]interface{}
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &body); err != nil {
+				return simulatorResponse(http.StatusBadRequest, map[string]interface{}{
+					"status":  0,
+					"message": "invalid JSON body",
+				})
+			}
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	path := req.URL.Path
+	switch {
+	case req.Method == http.MethodPost && strings.HasSuffix(path, t.endpoints.Send):
+		return t.send(body)
+	case req.Method == http.MethodPost && strings.HasSuffix(path, t.endpoints.Verify):
+		return t.verify(simulatorString(body["token"]))
+	case req.Method == http.MethodPost && strings.HasSuffix(path, t.endpoints.Transaction):
+		return t.transaction(simulatorString(body["token"]))
+	case req.Method == http.MethodPost:
+		if params, ok := matchEndpointPath(t.endpoints.Refund, path); ok {
+			return t.refund(params["transaction_id"], body)
+		}
+	case req.Method == http.MethodGet:
+		if token, ok := matchTokenPath(t.endpoints.Status, path); ok {
+			return t.status(token)
+		}
+		if params, ok := matchEndpointPath(t.endpoints.RefundStatus, path); ok {
+			return t.refundStatus(params["refund_id"])
+		}
+		if _, ok := matchEndpointPath(t.endpoints.Balance, path); ok {
+			return simulatorResponse(http.StatusOK, map[string]interface{}{
+				"status":          true,
+				"balance":         t.balance,
+				"blocked_balance": 0,
+			})
+		}
+	}
+
+	return simulatorResponse(http.StatusNotFound, map[string]interface{}{
+		"status":  0,
+		"message": "simulator does not handle " + req.Method + " " + path,
+	})
+}
+
+// send creates a payment and returns its token
+func (t *SimulatorTransport) send(body map[string]interface{}) (*http.Response, error) {
+	var amount FlexibleAmount
+	if raw, err := json.Marshal(body["amount"]); err == nil {
+		_ = json.Unmarshal(raw, &amount)
+	}
+	if amount <= 0 {
+		return simulatorResponse(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status": 0,
+			"errors": map[string]string{"amount": "amount is required"},
+		})
+	}
+
+	t.sequence++
+	token := fmt.Sprintf("sim%017d", t.sequence)
+	t.payments[token] = &simulatedPayment{
+		amount:       amount.Int64(),
+		description:  simulatorString(body["description"]),
+		factorNumber: simulatorString(body["factorNumber"]),
+		mobile:       simulatorString(body["mobile"]),
+		transID:      160000000000 + t.sequence,
+		createdAt:    time.Now(),
+	}
+	if t.paidAfter == 0 {
+		t.payments[token].paidAt = time.Now()
+	}
+
+	return simulatorResponse(http.StatusOK, map[string]interface{}{
+		"status": 1,
+		"token":  token,
+	})
+}
+
+// poll counts a lookup of a payment, marking it paid once enough lookups happened
+func (t *SimulatorTransport) poll(payment *simulatedPayment) {
+	payment.polls++
+	if payment.paidAt.IsZero() && payment.polls >= t.paidAfter {
+		payment.paidAt = time.Now()
+	}
+}
+
+// status answers a payment status lookup
+func (t *SimulatorTransport) status(token string) (*http.Response, error) {
+	payment, ok := t.payments[token]
+	if !ok {
+		return simulatorNotFound()
+	}
+	t.poll(payment)
+
+	resp := map[string]interface{}{
+		"status":            true,
+		"amount":            payment.amount,
+		"transactionStatus": string(StatusInit),
+	}
+	if !payment.paidAt.IsZero() {
+		resp["transactionStatus"] = string(StatusPaid)
+		resp["refId"] = fmt.Sprintf("%d", payment.transID)
+	}
+
+	return simulatorResponse(http.StatusOK, resp)
+}
+
+// transaction answers a transaction info lookup
+func (t *SimulatorTransport) transaction(token string) (*http.Response, error) {
+	payment, ok := t.payments[token]
+	if !ok {
+		return simulatorNotFound()
+	}
+	t.poll(payment)
+
+	resp := map[string]interface{}{
+		"status":       1,
+		"amount":       fmt.Sprintf("%d", payment.amount),
+		"factorNumber": payment.factorNumber,
+		"mobile":       payment.mobile,
+		"description":  payment.description,
+		"createdAt":    payment.createdAt.Format("2006-01-02 15:04:05"),
+	}
+	if !payment.paidAt.IsZero() {
+		resp["transId"] = payment.transID
+		resp["refnumber"] = fmt.Sprintf("%d", payment.transID)
+		resp["trackingCode"] = fmt.Sprintf("%d", payment.transID%1000000)
+		resp["cardNumber"] = "603799******1234"
+		resp["paymentDate"] = payment.paidAt.Format("2006-01-02 15:04:05")
+	}
+
+	return simulatorResponse(http.StatusOK, resp)
+}
+
+// verify answers a verification, which succeeds once the payment is paid
+func (t *SimulatorTransport) verify(token string) (*http.Response, error) {
+	payment, ok := t.payments[token]
+	if !ok {
+		return simulatorNotFound()
+	}
+
+	if payment.paidAt.IsZero() {
+		return simulatorResponse(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status":  0,
+			"message": "payment is not completed",
+		})
+	}
+
+	return simulatorResponse(http.StatusOK, map[string]interface{}{
+		"status":       1,
+		"amount":       fmt.Sprintf("%d", payment.amount),
+		"realAmount":   payment.amount,
+		"transId":      payment.transID,
+		"factorNumber": payment.factorNumber,
+		"mobile":       payment.mobile,
+		"description":  payment.description,
+		"cardNumber":   "603799******1234",
+		"paymentDate":  payment.paidAt.Format("2006-01-02 15:04:05"),
+		"message":      "ok",
+	})
+}
+
+// refund refunds a paid payment from the wallet; an amount of 0 refunds what is left
+func (t *SimulatorTransport) refund(transactionID string, body map[string]interface{}) (*http.Response, error) {
+	var payment *simulatedPayment
+	for _, candidate := range t.payments {
+		if fmt.Sprintf("%d", candidate.transID) == transactionID {
+			payment = candidate
+			break
+		}
+	}
+	if payment == nil {
+		return simulatorResponse(http.StatusNotFound, map[string]interface{}{
+			"status":  0,
+			"message": "transaction not found",
+		})
+	}
+	if payment.paidAt.IsZero() {
+		return simulatorResponse(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status":  0,
+			"message": "transaction is not paid",
+		})
+	}
+
+	var amount FlexibleAmount
+	if raw, err := json.Marshal(body["amount"]); err == nil {
+		_ = json.Unmarshal(raw, &amount)
+	}
+	remaining := payment.amount - payment.refunded
+	if amount == 0 {
+		amount = FlexibleAmount(remaining)
+	}
+
+	switch {
+	case amount.Int64() <= 0 || amount.Int64() > remaining:
+		return simulatorResponse(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status":  0,
+			"message": "amount exceeds the refundable amount",
+		})
+	case amount.Int64() > t.balance:
+		return simulatorResponse(http.StatusUnprocessableEntity, map[string]interface{}{
+			"status":  0,
+			"message": "insufficient wallet balance",
+		})
+	}
+
+	t.balance -= amount.Int64()
+	payment.refunded += amount.Int64()
+	t.sequence++
+	refundID := fmt.Sprintf("simrefund%d", t.sequence)
+	t.refunds[refundID] = &simulatedRefund{amount: amount.Int64()}
+
+	return simulatorResponse(http.StatusOK, map[string]interface{}{
+		"status":    true,
+		"refund_id": refundID,
+		"amount":    amount.Int64(),
+		"message":   "ok",
+	})
+}
+
+// refundStatus answers a refund status lookup, settling the refund once enough
+// lookups happened
+func (t *SimulatorTransport) refundStatus(refundID string) (*http.Response, error) {
+	refund, ok := t.refunds[refundID]
+	if !ok {
+		return simulatorResponse(http.StatusNotFound, map[string]interface{}{
+			"status":  false,
+			"message": "refund not found",
+		})
+	}
+
+	refund.polls++
+	state := "PENDING"
+	if refund.polls > t.refundedAfter {
+		state = "DONE"
+	}
+
+	return simulatorResponse(http.StatusOK, map[string]interface{}{
+		"status":        true,
+		"refund_id":     refundID,
+		"refund_status": state,
+		"amount":        refund.amount,
+	})
+}
+
+// matchEndpointPath matches the end of a path against an endpoint with
+// placeholders, since the base URL may add a prefix, and returns the values
+func matchEndpointPath(endpoint, path string) (map[string]string, bool) {
+	segments := strings.Split(path, "/")
+	count := strings.Count(endpoint, "/")
+	if !strings.HasPrefix(endpoint, "/") || len(segments) <= count {
+		return nil, false
+	}
+	return matchPattern(endpoint, "/"+strings.Join(segments[len(segments)-count:], "/"))
+}
+
+// matchTokenPath extracts the token from a path matching an endpoint containing {token}
+func matchTokenPath(endpoint, path string) (string, bool) {
+	prefix, suffix, found := strings.Cut(endpoint, placeholderToken)
+	if !found {
+		return "", false
+	}
+
+	index := strings.Index(path, prefix)
+	if index < 0 {
+		return "", false
+	}
+
+	token := strings.TrimSuffix(path[index+len(prefix):], suffix)
+	if token == "" || strings.Contains(token, "/") {
+		return "", false
+	}
+
+	return token, true
+}
+
+// simulatorString returns a decoded JSON value as a string
+func simulatorString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return ""
+}
+
+// simulatorNotFound answers a request for an unknown token
+func simulatorNotFound() (*http.Response, error) {
+	return simulatorResponse(http.StatusNotFound, map[string]interface{}{
+		"status":  0,
+		"message": "token not found",
+	})
+}
+
+// simulatorResponse builds a JSON response
+func simulatorResponse(statusCode int, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal simulator response: %w", err)
+	}
+
+	return &http.Response{
+		StatusCode: statusCode,
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+	}, nil
+}
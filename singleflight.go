@@ -0,0 +1,53 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// singleflight.go implements call deduplication for concurrent identical requests
+package vandargo
+
+import "sync"
+
+// flightCall represents an in-flight or completed call shared by concurrent callers
+type flightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// flightGroup collapses concurrent calls with the same key into a single execution
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+// newFlightGroup creates an empty flight group
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		calls: make(map[string]*flightCall),
+	}
+}
+
+// Do executes fn once for all concurrent callers using the same key and returns
+// its result to each of them; shared reports whether the result was reused
+func (g *flightGroup) Do(key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
+	g.mutex.Lock()
+	if call, exists := g.calls[key]; exists {
+		g.mutex.Unlock()
+		call.wg.Wait()
+		return call.val, call.err, true
+	}
+
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mutex.Unlock()
+
+	// Release waiters and forget the call even if fn panics
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		call.wg.Done()
+	}()
+
+	call.val, call.err = fn()
+
+	return call.val, call.err, false
+}
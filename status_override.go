@@ -0,0 +1,260 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// status_override.go implements manual transaction status overrides for support staff
+package vandargo
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// AdminKeyHeader carries the admin key required by administrative endpoints
+const AdminKeyHeader = "X-Admin-Key"
+
+// SensitiveDataKeyHeader carries the key allowing admin responses to include
+// unmasked card data
+const SensitiveDataKeyHeader = "X-Sensitive-Data-Key"
+
+// statusTransitions lists the status changes allowed without forcing
+var statusTransitions = map[TransactionStatus][]TransactionStatus{
+	StatusQueued:        {StatusFailed},
+	StatusInit:          {StatusPaid, StatusFailed, StatusExpired, StatusVerifyPending},
+	StatusVerifyPending: {StatusPaid, StatusFailed},
+	StatusFailed:        {StatusPaid},
+	StatusExpired:       {StatusPaid},
+	StatusPaid:          {StatusRefunded, StatusSuspect},
+	StatusSuspect:       {StatusPaid, StatusRefunded, StatusFailed},
+}
+
+// IsValid reports whether the status is one of the known transaction statuses
+func (s TransactionStatus) IsValid() bool {
+	switch s {
+	case StatusQueued, StatusInit, StatusVerifyPending, StatusPaid, StatusFailed, StatusExpired, StatusRefunded, StatusSuspect:
+		return true
+	default:
+		return false
+	}
+}
+
+// CanTransitionTo reports whether the state machine allows changing to the next status.
+// Failed and expired payments may still become paid when the gateway confirms them late.
+func (s TransactionStatus) CanTransitionTo(next TransactionStatus) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// OverrideOption configures OverrideTransactionStatus
+type OverrideOption func(*overrideOptions)
+
+// overrideOptions holds the settings of a status override
+type overrideOptions struct {
+	force bool
+}
+
+// WithForce allows a status change the state machine would reject
+func WithForce(force bool) OverrideOption {
+	return func(o *overrideOptions) {
+		o.force = force
+	}
+}
+
+// OverrideTransactionStatus changes the stored status of a transaction without calling
+// the gateway, e.g. after support staff confirmed a payment in the Vandar dashboard.
+// The change must be allowed by the state machine unless forced; it is recorded in
+// the transaction's StatusHistory with the actor and reason and logged as a warning.
+func (c *Client) OverrideTransactionStatus(ctx context.Context, token string, newStatus TransactionStatus, reason, actor string, opts ...OverrideOption) (*Transaction, error) {
+	options := overrideOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	ctx, done, err := c.beginOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	// Validate the request
+	if token == "" {
+		return nil, fmt.Errorf("%w: token is required", ErrInvalidRequest)
+	}
+	if !newStatus.IsValid() {
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidRequest, newStatus)
+	}
+	if reason == "" || actor == "" {
+		return nil, fmt.Errorf("%w: reason and actor are required", ErrInvalidRequest)
+	}
+
+	// Keep verification, callbacks and expiry of the token out while changing it
+	release := c.tokenLocks.Lock(token)
+	defer release()
+
+	transaction, err := c.storage.GetTransaction(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	// Check the transition against the state machine
+	previousStatus := transaction.Status
+	if previousStatus == newStatus {
+		return nil, fmt.Errorf("%w: transaction is already %s", ErrInvalidTransition, newStatus)
+	}
+	if !options.force && !previousStatus.CanTransitionTo(newStatus) {
+		return nil, fmt.Errorf("%w: cannot change status from %s to %s", ErrInvalidTransition, previousStatus, newStatus)
+	}
+
+	// Update the status and record the audit entry
+	now := c.clock.Now()
+	correlationID, _ := CorrelationIDFromContext(ctx)
+	patch := TransactionPatch{
+		Status: &newStatus,
+		StatusChange: &StatusChange{
+			From:          previousStatus,
+			To:            newStatus,
+			Actor:         actor,
+			Reason:        reason,
+			Forced:        options.force,
+			CorrelationID: correlationID,
+			At:            now,
+		},
+		UpdatedAt: now,
+	}
+	if newStatus.IsTerminal() && transaction.CompletedAt == nil {
+		patch.CompletedAt = &now
+	}
+	if err := c.patchTransaction(ctx, token, patch); err != nil {
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
+	c.applyPatch(&patch, transaction)
+
+	// Cached lookups no longer reflect the transaction state
+	c.invalidateCache(ctx, token)
+
+	fields := transactionLogFields(transaction)
+	fields["previous_status"] = previousStatus
+	fields["actor"] = actor
+	fields["reason"] = reason
+	fields["forced"] = options.force
+	c.log(ctx).Warn(ctx, "Transaction status overridden manually", fields)
+
+	c.fireStatusChange(ctx, transaction, previousStatus)
+
+	return transaction, nil
+}
+
+// StatusOverrideRequest is the body of a manual status override request
+type StatusOverrideRequest struct {
+	// Status is the new transaction status
+	Status TransactionStatus `json:"status"`
+
+	// Reason explains why the status is changed
+	Reason string `json:"reason"`
+
+	// Actor identifies the staff member making the change
+	Actor string `json:"actor"`
+
+	// Force allows changes the state machine would reject
+	Force bool `json:"force,omitempty"`
+}
+
+// handleStatusOverride handles manual transaction status overrides
+func (c *Client) handleStatusOverride(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// The transaction is addressed by its token in the path
+	token := pathParam(r, "id")
+	if token == "" {
+		c.respondWithError(w, ErrInvalidRequest, "Transaction token is required")
+		return
+	}
+	r = c.traceRequest(r, token)
+	ctx = r.Context()
+
+	// Unmasked data needs its own key on top of the admin key
+	includeSensitive, ok := c.includeSensitive(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request body
+	var req StatusOverrideRequest
+	if err := parseJSONBody(r, &req); err != nil {
+		c.respondInvalid(w, err)
+		return
+	}
+
+	// A JWT subject is a verified identity, unlike the actor in the body
+	actor := req.Actor
+	if subject, ok := SubjectFromContext(ctx); ok {
+		actor = subject
+	}
+
+	transaction, err := c.OverrideTransactionStatus(ctx, token, req.Status, req.Reason, actor, WithForce(req.Force))
+	switch {
+	case err == nil:
+		if includeSensitive {
+			c.respondWithJSON(w, http.StatusOK, transaction)
+		} else {
+			c.respondWithJSON(w, http.StatusOK, c.maskedTransaction(transaction))
+		}
+	case errors.Is(err, ErrNotFound):
+		c.respondWithError(w, ErrNotFound, "Transaction not found")
+	case IsDomainError(err):
+		c.respondError(w, err)
+	default:
+		c.respondWithError(w, ErrInternalError, "Failed to override transaction status")
+		c.log(ctx).Error(ctx, "Failed to override transaction status", err, map[string]interface{}{
+			"token": redactToken(token),
+		})
+	}
+}
+
+// includeSensitive reports whether an admin request asked for unmasked data with
+// include_sensitive=true and may see it. It writes a 403 response and returns false
+// when the sensitive data key is missing, wrong or not configured.
+func (c *Client) includeSensitive(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	if include, _ := strconv.ParseBool(r.URL.Query().Get("include_sensitive")); !include {
+		return false, true
+	}
+
+	key := configValues(c.config).SensitiveDataKey
+	provided := r.Header.Get(SensitiveDataKeyHeader)
+	if key == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(key)) != 1 {
+		c.respondWithError(w, ErrPermission, "Not permitted to include sensitive data")
+		return false, false
+	}
+
+	c.log(r.Context()).Warn(r.Context(), "Admin response includes sensitive data", map[string]interface{}{
+		"path": r.URL.Path,
+	})
+	return true, true
+}
+
+// AdminKeyMiddleware requires the configured admin key in the X-Admin-Key header.
+// Administrative endpoints are disabled when no admin key is configured.
+func AdminKeyMiddleware(config ConfigInterface) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			adminKey := configValues(config).AdminKey
+			if adminKey == "" {
+				writeJSONError(w, r, http.StatusForbidden, ErrPermission, "Administrative endpoints are disabled")
+				return
+			}
+
+			provided := r.Header.Get(AdminKeyHeader)
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(adminKey)) != 1 {
+				writeJSONError(w, r, http.StatusForbidden, ErrPermission, "Invalid admin key")
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
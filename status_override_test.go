@@ -0,0 +1,163 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverrideTransitions(t *testing.T) {
+	tests := []struct {
+		from, to TransactionStatus
+		force    bool
+		allowed  bool
+	}{
+		{StatusInit, StatusPaid, false, true},
+		{StatusExpired, StatusPaid, false, true},
+		{StatusPaid, StatusSuspect, false, true},
+		{StatusSuspect, StatusPaid, false, true},
+		{StatusPaid, StatusInit, false, false},
+		{StatusRefunded, StatusPaid, false, false},
+		{StatusRefunded, StatusPaid, true, true},
+		{StatusPaid, StatusPaid, true, false},
+	}
+
+	for _, tt := range tests {
+		client, storage, _ := newTestClient(t, testConfig(t), nil)
+		storeWebhookPayment(t, storage, tt.from)
+
+		_, err := client.OverrideTransactionStatus(context.Background(), webhookToken, tt.to, "confirmed in the dashboard", "support@shop", WithForce(tt.force))
+		transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+		if tt.allowed {
+			if err != nil || transaction.Status != tt.to {
+				t.Fatalf("%s -> %s (force %v): status %s, %v", tt.from, tt.to, tt.force, transaction.Status, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidTransition) || transaction.Status != tt.from || len(transaction.StatusHistory) != 0 {
+			t.Fatalf("%s -> %s (force %v): status %s, %v", tt.from, tt.to, tt.force, transaction.Status, err)
+		}
+	}
+}
+
+func TestOverrideRecordsAudit(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	var changed []TransactionStatus
+	client, storage, logger := newTestClient(t, testConfig(t), nil, WithClientClock(clock), WithClientHooks(Hooks{
+		OnStatusChange: func(ctx context.Context, transaction *Transaction, from, to TransactionStatus) {
+			changed = append(changed, from, to)
+		},
+	}))
+	storeWebhookPayment(t, storage, StatusRefunded)
+
+	ctx := ContextWithCorrelationID(context.Background(), "corr-1")
+	transaction, err := client.OverrideTransactionStatus(ctx, webhookToken, StatusPaid, "refund bounced", "support@shop", WithForce(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := StatusChange{
+		From:          StatusRefunded,
+		To:            StatusPaid,
+		Actor:         "support@shop",
+		Reason:        "refund bounced",
+		Forced:        true,
+		CorrelationID: "corr-1",
+		At:            clock.Now(),
+	}
+	stored, _ := storage.GetTransaction(context.Background(), webhookToken)
+	for _, got := range []*Transaction{transaction, stored} {
+		if len(got.StatusHistory) != 1 || got.StatusHistory[0] != want {
+			t.Fatalf("status history %+v", got.StatusHistory)
+		}
+		if got.CompletedAt == nil || !got.CompletedAt.Equal(clock.Now()) {
+			t.Fatalf("completed at %v", got.CompletedAt)
+		}
+	}
+
+	if len(changed) != 2 || changed[0] != StatusRefunded || changed[1] != StatusPaid {
+		t.Fatalf("status changes %v", changed)
+	}
+	entry, found := logger.find("Transaction status overridden manually")
+	if !found || entry.level != "warn" || entry.fields["actor"] != "support@shop" || entry.fields["forced"] != true {
+		t.Fatalf("override not logged:\n%s", logger.dump())
+	}
+
+	// A later override is appended to the history
+	if _, err := client.OverrideTransactionStatus(ctx, webhookToken, StatusSuspect, "payer disputes it", "risk@shop"); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = storage.GetTransaction(context.Background(), webhookToken)
+	if len(stored.StatusHistory) != 2 || stored.StatusHistory[1].Actor != "risk@shop" || stored.StatusHistory[1].Forced {
+		t.Fatalf("status history %+v", stored.StatusHistory)
+	}
+}
+
+func TestOverrideValidation(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t), nil)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	tests := []struct {
+		token, reason, actor string
+		status               TransactionStatus
+		want                 error
+	}{
+		{webhookToken, "", "support@shop", StatusPaid, ErrInvalidRequest},
+		{webhookToken, "confirmed", "", StatusPaid, ErrInvalidRequest},
+		{webhookToken, "confirmed", "support@shop", "SETTLED", ErrInvalidRequest},
+		{"", "confirmed", "support@shop", StatusPaid, ErrInvalidRequest},
+		{"tok-missing", "confirmed", "support@shop", StatusPaid, ErrNotFound},
+	}
+	for _, tt := range tests {
+		_, err := client.OverrideTransactionStatus(context.Background(), tt.token, tt.status, tt.reason, tt.actor)
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("%+v: expected %v, got %v", tt, tt.want, err)
+		}
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusInit || len(transaction.StatusHistory) != 0 {
+		t.Fatalf("rejected overrides changed the transaction: %+v", transaction)
+	}
+}
+
+func TestOverrideRoute(t *testing.T) {
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.AdminKey = "admin-key"
+	}), nil)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	override := func(adminKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/transactions/"+webhookToken+"/status", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		req.Header.Set(AdminKeyHeader, adminKey)
+		rec := httptest.NewRecorder()
+		client.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := override("wrong-key", `{"status":"PAID","reason":"confirmed","actor":"support@shop"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("wrong admin key: status %d", rec.Code)
+	}
+	if rec := override("admin-key", `{"status":"PAID","actor":"support@shop"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("missing reason: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := override("admin-key", `{"status":"REFUNDED","reason":"confirmed","actor":"support@shop"}`); rec.Code == http.StatusOK {
+		t.Fatalf("rejected transition answered %s", rec.Body)
+	}
+
+	rec := override("admin-key", `{"status":"PAID","reason":"confirmed","actor":"support@shop"}`)
+	var transaction Transaction
+	if err := json.Unmarshal(rec.Body.Bytes(), &transaction); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if transaction.Status != StatusPaid || len(transaction.StatusHistory) != 1 || transaction.StatusHistory[0].Actor != "support@shop" {
+		t.Fatalf("overridden transaction %+v", transaction)
+	}
+}
@@ -4,22 +4,145 @@ package vandargo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 )
 
+// ErrSimulatedStorageFailure is returned by a MemoryStorage configured with
+// WithStorageErrorRate when it fails an operation on purpose
+var ErrSimulatedStorageFailure = errors.New("simulated storage failure")
+
 // MemoryStorage is a simple in-memory implementation of StorageInterface
 type MemoryStorage struct {
 	transactions map[string]*Transaction
+	refunds      map[string]*Refund
+	transfers    map[string]*Transfer
+	settlements  map[string]*Settlement
+	evidence     map[string]*TransactionEvidence
+	cidIndex     map[string]map[string]struct{}
 	mutex        sync.RWMutex
+
+	// clock stamps updates and times the simulated latency
+	clock Clock
+
+	// latency delays every operation, to simulate a slow storage in tests
+	latency time.Duration
+
+	// errorRate is the fraction of operations failed on purpose, drawn from random
+	errorRate  float64
+	random     *rand.Rand
+	randomLock sync.Mutex
+}
+
+// MemoryStorageOption configures a MemoryStorage
+type MemoryStorageOption func(*MemoryStorage)
+
+// WithStorageLatency delays every operation by d, or until its context ends,
+// to exercise timeout and cancellation paths in tests
+func WithStorageLatency(d time.Duration) MemoryStorageOption {
+	return func(s *MemoryStorage) {
+		s.latency = d
+	}
+}
+
+// WithStorageClock sets the clock used for UpdatedAt timestamps and the simulated
+// latency (RealClock by default)
+func WithStorageClock(clock Clock) MemoryStorageOption {
+	return func(s *MemoryStorage) {
+		if clock != nil {
+			s.clock = clock
+		}
+	}
+}
+
+// WithStorageErrorRate fails a fraction p of operations with
+// ErrSimulatedStorageFailure, to exercise storage failure paths in tests
+func WithStorageErrorRate(p float64) MemoryStorageOption {
+	return func(s *MemoryStorage) {
+		s.errorRate = p
+	}
+}
+
+// WithStorageSeed seeds the random source of WithStorageErrorRate so the
+// sequence of simulated failures is reproducible
+func WithStorageSeed(seed int64) MemoryStorageOption {
+	return func(s *MemoryStorage) {
+		s.random = rand.New(rand.NewSource(seed))
+	}
 }
 
 // NewMemoryStorage creates a new in-memory storage
-func NewMemoryStorage() *MemoryStorage {
-	return &MemoryStorage{
+func NewMemoryStorage(opts ...MemoryStorageOption) *MemoryStorage {
+	s := &MemoryStorage{
 		transactions: make(map[string]*Transaction),
+		refunds:      make(map[string]*Refund),
+		transfers:    make(map[string]*Transfer),
+		settlements:  make(map[string]*Settlement),
+		evidence:     make(map[string]*TransactionEvidence),
+		cidIndex:     make(map[string]map[string]struct{}),
+		clock:        RealClock(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.errorRate > 0 && s.random == nil {
+		s.random = rand.New(rand.NewSource(time.Now().UnixNano()))
 	}
+
+	return s
+}
+
+// enter starts an operation: it checks the context, waits for the simulated
+// latency and draws a simulated failure
+func (s *MemoryStorage) enter(ctx context.Context) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	if s.latency > 0 {
+		timer := s.clock.NewTimer(s.latency)
+		select {
+		case <-contextDone(ctx):
+			timer.Stop()
+			return contextError(ctx)
+		case <-timer.C():
+		}
+	}
+
+	if s.errorRate > 0 {
+		s.randomLock.Lock()
+		failed := s.random.Float64() < s.errorRate
+		s.randomLock.Unlock()
+		if failed {
+			return ErrSimulatedStorageFailure
+		}
+	}
+
+	return nil
+}
+
+// contextError returns the wrapped error of a context that ended
+func contextError(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("storage operation aborted: %w", err)
+	}
+	return nil
+}
+
+// contextDone returns the done channel of a context, or nil for a nil context
+func contextDone(ctx context.Context) <-chan struct{} {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Done()
 }
 
 // StoreTransaction saves a new transaction to storage
@@ -32,12 +155,24 @@ func (s *MemoryStorage) StoreTransaction(ctx context.Context, transaction *Trans
 		return fmt.Errorf("transaction ID cannot be empty")
 	}
 
+	if err := s.enter(ctx); err != nil {
+		return err
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
 	// Store a copy of the transaction to prevent external modifications
 	transactionCopy := *transaction
+	if previous, exists := s.transactions[transaction.Token]; exists {
+		s.reindexCID(transaction.Token, previous.CID, "")
+	}
 	s.transactions[transaction.Token] = &transactionCopy
+	s.reindexCID(transaction.Token, "", transaction.CID)
 
 	return nil
 }
@@ -48,9 +183,17 @@ func (s *MemoryStorage) GetTransaction(ctx context.Context, token string) (*Tran
 		return nil, fmt.Errorf("token cannot be empty")
 	}
 
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
 	transaction, exists := s.transactions[token]
 	if !exists {
 		return nil, fmt.Errorf("transaction not found: %s", token)
@@ -71,31 +214,48 @@ func (s *MemoryStorage) UpdateTransaction(ctx context.Context, transaction *Tran
 		return fmt.Errorf("transaction ID cannot be empty")
 	}
 
+	if err := s.enter(ctx); err != nil {
+		return err
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	_, exists := s.transactions[transaction.Token]
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	previous, exists := s.transactions[transaction.Token]
 	if !exists {
 		return fmt.Errorf("transaction not found: %s", transaction.Token)
 	}
 
 	// Update the transaction
-	transaction.UpdatedAt = time.Now()
+	transaction.UpdatedAt = s.clock.Now()
 	transactionCopy := *transaction
 	s.transactions[transaction.Token] = &transactionCopy
+	s.reindexCID(transaction.Token, previous.CID, transaction.CID)
 
 	return nil
 }
 
 // GetTransactionsByStatus retrieves transactions by their status
 func (s *MemoryStorage) GetTransactionsByStatus(ctx context.Context, status string) ([]*Transaction, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
 	var result []*Transaction
 
 	for _, transaction := range s.transactions {
-		if transaction.Status == status {
+		if string(transaction.Status) == status {
 			// Create a copy to prevent external modifications
 			transactionCopy := *transaction
 			result = append(result, &transactionCopy)
@@ -0,0 +1,186 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorageHonoursContext(t *testing.T) {
+	storage := NewMemoryStorage()
+	storeWebhookPayment(t, storage, StatusInit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	operations := map[string]func() error{
+		"StoreTransaction": func() error {
+			return storage.StoreTransaction(ctx, &Transaction{ID: "tx-2", Token: "sim00000000000000002"})
+		},
+		"GetTransaction": func() error {
+			_, err := storage.GetTransaction(ctx, webhookToken)
+			return err
+		},
+		"UpdateTransaction": func() error {
+			return storage.UpdateTransaction(ctx, &Transaction{ID: "tx-webhook", Token: webhookToken, Status: StatusPaid})
+		},
+		"GetTransactionsByStatus": func() error {
+			_, err := storage.GetTransactionsByStatus(ctx, string(StatusInit))
+			return err
+		},
+		"StoreRefund": func() error {
+			return storage.StoreRefund(ctx, &Refund{ID: "refund-1"})
+		},
+	}
+	for name, operation := range operations {
+		if err := operation(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s with a cancelled context = %v", name, err)
+		}
+	}
+
+	// Nothing changed
+	if transaction, err := storage.GetTransaction(context.Background(), webhookToken); err != nil || transaction.Status != StatusInit {
+		t.Fatalf("transaction %+v, %v", transaction, err)
+	}
+}
+
+func TestMemoryStorageLatency(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	storage := NewMemoryStorage(WithStorageLatency(time.Second), WithStorageClock(clock))
+
+	stored := make(chan error, 1)
+	go func() {
+		stored <- storage.StoreTransaction(context.Background(), &Transaction{ID: "tx-webhook", Token: webhookToken})
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-stored:
+		t.Fatalf("stored before the latency passed: %v", err)
+	default:
+	}
+	clock.Advance(time.Second)
+	if err := <-stored; err != nil {
+		t.Fatal(err)
+	}
+
+	// A deadline ends the wait early
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := storage.GetTransaction(ctx, webhookToken); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetTransaction() past its deadline = %v", err)
+	}
+}
+
+func TestMemoryStorageErrorRate(t *testing.T) {
+	failures := func(opts ...MemoryStorageOption) []bool {
+		storage := NewMemoryStorage(opts...)
+		failed := make([]bool, 50)
+		for i := range failed {
+			_, err := storage.GetTransactionsByStatus(context.Background(), string(StatusInit))
+			if err != nil && !errors.Is(err, ErrSimulatedStorageFailure) {
+				t.Fatal(err)
+			}
+			failed[i] = err != nil
+		}
+		return failed
+	}
+	count := func(failed []bool) (n int) {
+		for _, f := range failed {
+			if f {
+				n++
+			}
+		}
+		return n
+	}
+
+	// The same seed fails the same operations
+	first := failures(WithStorageErrorRate(0.5), WithStorageSeed(42))
+	second := failures(WithStorageErrorRate(0.5), WithStorageSeed(42))
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("operation %d failed differently with the same seed", i)
+		}
+	}
+	if n := count(first); n == 0 || n == len(first) {
+		t.Fatalf("%d of %d operations failed at rate 0.5", n, len(first))
+	}
+
+	if n := count(failures(WithStorageErrorRate(1), WithStorageSeed(1))); n != 50 {
+		t.Fatalf("%d of 50 operations failed at rate 1", n)
+	}
+	if n := count(failures()); n != 0 {
+		t.Fatalf("%d operations failed without an error rate", n)
+	}
+}
+
+func TestInitContinuesWhenStorageFails(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": webhookToken}))
+	client, _, logger := newTestClient(t, testConfig(t), transport)
+	client.storage = NewMemoryStorage(WithStorageErrorRate(1), WithStorageSeed(1))
+	server := httptest.NewServer(client.Handler())
+	defer server.Close()
+
+	// The gateway issued a token, so the payer is sent on regardless
+	resp, body := serverClient{t, server}.do(http.MethodPost, "/payments/init", "application/json",
+		`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+	if resp.StatusCode != http.StatusOK || body["token"] != webhookToken {
+		t.Fatalf("init: status %d: %v", resp.StatusCode, body)
+	}
+	if entry, ok := logger.find("Failed to store transaction"); !ok || !errors.Is(entry.err, ErrSimulatedStorageFailure) {
+		t.Fatalf("storage failure not logged:\n%s", logger.dump())
+	}
+}
+
+func TestWebhookContinuesOnSlowStorage(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	config := testConfig(t, func(c *Config) { c.WebhookSecret = "webhook-secret" })
+	client, _, logger := newTestClient(t, config, newStubTransport(stubStep{status: http.StatusNotFound, body: `{}`}), WithClientClock(clock))
+	storage := NewMemoryStorage(WithStorageLatency(time.Minute), WithStorageClock(clock))
+	client.storage = storage
+
+	settled := func() bool {
+		storage.mutex.RLock()
+		defer storage.mutex.RUnlock()
+		return storage.settlements["st_4821"] != nil
+	}
+
+	body := readWebhookFixture(t, "settlement_done.json")
+	req := httptest.NewRequest(http.MethodPost, webhookPath, strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, SignData(string(body), "webhook-secret"))
+	rec := httptest.NewRecorder()
+	answered := make(chan struct{})
+	go func() {
+		client.Handler().ServeHTTP(rec, req)
+		close(answered)
+	}()
+
+	// The gateway gets its answer once the sync budget is spent
+	for clock.Waiters() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(webhookSyncBudget)
+	<-answered
+	if rec.Code != http.StatusOK || settled() {
+		t.Fatalf("status %d, settled %v: %s", rec.Code, settled(), rec.Body)
+	}
+	if _, ok := logger.find("Webhook processing is slow"); !ok {
+		t.Fatalf("slow processing not logged:\n%s", logger.dump())
+	}
+
+	// and processing still lands in the background
+	for deadline := time.Now().Add(5 * time.Second); !settled(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("settlement never stored:\n%s", logger.dump())
+		}
+		if clock.Waiters() > 0 {
+			clock.Advance(time.Minute)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// timeout.go implements per-operation deadlines for client calls
+package vandargo
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// defaultInitTimeout is used when Config.InitTimeout is not set
+	defaultInitTimeout = 20 * time.Second
+
+	// defaultVerifyTimeout is used when Config.VerifyTimeout is not set
+	defaultVerifyTimeout = 20 * time.Second
+
+	// defaultStatusTimeout is used when Config.StatusTimeout is not set
+	defaultStatusTimeout = 5 * time.Second
+)
+
+// contextKey is the type for context keys defined by this package
+type contextKey string
+
+// timeoutOverrideKey stores a per-call timeout override in the context
+const timeoutOverrideKey contextKey = "timeout_override"
+
+// operation identifies a class of client call with its own default deadline
+type operation int
+
+const (
+	// operationInit covers payment initialization and refunds
+	operationInit operation = iota
+	// operationVerify covers payment verification
+	operationVerify
+	// operationStatus covers status and transaction info lookups
+	operationStatus
+)
+
+// WithTimeout returns a context that overrides the default operation timeout
+// for client calls made with it. A tighter deadline already on ctx still wins.
+func WithTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutOverrideKey, timeout)
+}
+
+// operationTimeout returns the configured default timeout for an operation
+func (c *Client) operationTimeout(op operation) time.Duration {
+	values := configValues(c.config)
+
+	switch op {
+	case operationInit:
+		if values.InitTimeout > 0 {
+			return values.InitTimeout
+		}
+		return defaultInitTimeout
+	case operationVerify:
+		if values.VerifyTimeout > 0 {
+			return values.VerifyTimeout
+		}
+		return defaultVerifyTimeout
+	default:
+		if values.StatusTimeout > 0 {
+			return values.StatusTimeout
+		}
+		return defaultStatusTimeout
+	}
+}
+
+// operationContext derives a context bounded by the operation timeout. The
+// returned cancel function must always be called to release resources.
+func (c *Client) operationContext(ctx context.Context, op operation) (context.Context, context.CancelFunc) {
+	timeout := c.operationTimeout(op)
+	if override, ok := ctx.Value(timeoutOverrideKey).(time.Duration); ok && override > 0 {
+		timeout = override
+	}
+
+	// Never extend a deadline the caller already set
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
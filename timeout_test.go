@@ -0,0 +1,106 @@
+package vandargo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOperationContextDefaults(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.InitTimeout = 7 * time.Second
+		c.VerifyTimeout = 0
+		c.StatusTimeout = 3 * time.Second
+	}), nil)
+
+	tests := []struct {
+		op   operation
+		want time.Duration
+	}{
+		{operationInit, 7 * time.Second},
+		{operationVerify, defaultVerifyTimeout},
+		{operationStatus, 3 * time.Second},
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+		ctx, cancel := client.operationContext(context.Background(), tt.op)
+		deadline, ok := ctx.Deadline()
+		cancel()
+
+		if !ok || deadline.Sub(start) < tt.want || deadline.Sub(start) > tt.want+time.Second {
+			t.Errorf("operation %d: deadline in %v, want %v", tt.op, deadline.Sub(start), tt.want)
+		}
+		if ctx.Err() == nil {
+			t.Errorf("operation %d: context not canceled by cancel", tt.op)
+		}
+	}
+}
+
+func TestOperationContextNeverExtendsDeadline(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), nil)
+
+	parent, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	parentDeadline, _ := parent.Deadline()
+
+	// Neither the default nor a longer override extends the caller's deadline
+	for _, ctx := range []context.Context{parent, WithTimeout(parent, time.Hour)} {
+		derived, cancelDerived := client.operationContext(ctx, operationVerify)
+		deadline, _ := derived.Deadline()
+		cancelDerived()
+
+		if !deadline.Equal(parentDeadline) {
+			t.Errorf("deadline moved from %v to %v", parentDeadline, deadline)
+		}
+	}
+
+	// A shorter override tightens it
+	derived, cancelDerived := client.operationContext(WithTimeout(parent, 10*time.Millisecond), operationVerify)
+	defer cancelDerived()
+	if deadline, _ := derived.Deadline(); !deadline.Before(parentDeadline) {
+		t.Errorf("override of 10ms kept deadline %v", deadline)
+	}
+}
+
+func TestClientCallsApplyDeadlines(t *testing.T) {
+	var deadlines []time.Duration
+	var contexts []context.Context
+	transport := transportFunc(func(req *http.Request) (*http.Response, error) {
+		deadline, ok := req.Context().Deadline()
+		if !ok {
+			t.Errorf("%s sent without a deadline", req.URL.Path)
+		}
+		deadlines = append(deadlines, time.Until(deadline))
+		contexts = append(contexts, req.Context())
+		return stubResponse(req, http.StatusOK, map[string]interface{}{"status": 1, "token": "sim00000000000000001"}), nil
+	})
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.InitTimeout = 9 * time.Second
+		c.Timeout = 30
+		c.MaxRetries = 0
+	}), transport)
+
+	if _, err := client.InitiatePayment(context.Background(), 10000, "Order", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(deadlines) != 1 || deadlines[0] > 9*time.Second || deadlines[0] < 8*time.Second {
+		t.Fatalf("init deadlines %v, want about 9s", deadlines)
+	}
+
+	// The caller's tighter deadline reaches the transport unchanged
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client.InitiatePayment(ctx, 10000, "Order", nil)
+	if deadlines[1] > 2*time.Second {
+		t.Fatalf("init deadline %v extended past the caller's 2s", deadlines[1])
+	}
+
+	// The derived contexts are released when the calls return
+	for i, ctx := range contexts {
+		if ctx.Err() == nil {
+			t.Errorf("context of call %d still live after it returned", i)
+		}
+	}
+}
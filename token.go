@@ -0,0 +1,246 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// token.go implements OAuth access token management for the business API
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTokenRefreshSkew is how long before expiry a token is refreshed proactively
+	defaultTokenRefreshSkew = 5 * time.Minute
+
+	// defaultTokenRefreshTimeout bounds a single token refresh exchange
+	defaultTokenRefreshTimeout = 15 * time.Second
+
+	// defaultAccessTokenLifetime is assumed when the token response omits expires_in
+	defaultAccessTokenLifetime = time.Hour
+)
+
+// tokenResponse represents the response of the token refresh endpoint
+type tokenResponse struct {
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// tokenCall represents an in-flight token refresh shared by concurrent callers
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// RefreshTokenProvider exchanges a refresh token for business API access tokens
+// and caches them until shortly before they expire
+type RefreshTokenProvider struct {
+	baseURL     string
+	endpoint    string
+	httpClient  HTTPClientInterface
+	logger      LoggerInterface
+	refreshSkew time.Duration
+
+	*tokenState
+}
+
+// tokenState holds the tokens of a RefreshTokenProvider, shared with the
+// providers of cloned clients so a rotated refresh token is seen by all of them
+type tokenState struct {
+	mutex        sync.Mutex
+	refreshToken string
+	accessToken  string
+	expiresAt    time.Time
+	inflight     *tokenCall
+}
+
+// NewRefreshTokenProvider creates a token provider using the refresh token from
+// config. A nil logger drops all entries.
+func NewRefreshTokenProvider(config ConfigInterface, httpClient HTTPClientInterface, logger LoggerInterface) (*RefreshTokenProvider, error) {
+	if config == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+
+	if httpClient == nil {
+		return nil, fmt.Errorf("http client cannot be nil")
+	}
+
+	logger = loggerOrDiscard(logger)
+
+	values := configValues(config)
+	if values.RefreshToken == "" {
+		return nil, fmt.Errorf("refresh token is required")
+	}
+
+	endpoint := values.TokenEndpoint
+	if endpoint == "" {
+		endpoint = DefaultConfig().TokenEndpoint
+	}
+
+	return &RefreshTokenProvider{
+		baseURL:     config.GetBaseURL(),
+		endpoint:    endpoint,
+		httpClient:  httpClient,
+		logger:      logger,
+		refreshSkew: defaultTokenRefreshSkew,
+		tokenState:  &tokenState{refreshToken: values.RefreshToken},
+	}, nil
+}
+
+// withTransport returns a provider sharing the tokens of p that sends its
+// refreshes through another HTTP client
+func (p *RefreshTokenProvider) withTransport(httpClient HTTPClientInterface, logger LoggerInterface) *RefreshTokenProvider {
+	provider := *p
+	provider.httpClient = httpClient
+	provider.logger = loggerOrDiscard(logger)
+	return &provider
+}
+
+// Token returns a cached access token or refreshes it when missing or about to expire
+func (p *RefreshTokenProvider) Token(ctx context.Context) (string, error) {
+	p.mutex.Lock()
+
+	now := time.Now()
+	if p.accessToken != "" && now.Before(p.expiresAt) {
+		token := p.accessToken
+
+		// Refresh proactively in the background when the token is close to expiry
+		if p.expiresAt.Sub(now) <= p.refreshSkew {
+			p.startRefreshLocked(ctx)
+		}
+
+		p.mutex.Unlock()
+		return token, nil
+	}
+
+	call := p.startRefreshLocked(ctx)
+	p.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Invalidate discards the cached access token so the next call refreshes it
+func (p *RefreshTokenProvider) Invalidate() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.accessToken = ""
+	p.expiresAt = time.Time{}
+}
+
+// startRefreshLocked starts a refresh unless one is already running; the mutex must be held
+func (p *RefreshTokenProvider) startRefreshLocked(ctx context.Context) *tokenCall {
+	if p.inflight != nil {
+		return p.inflight
+	}
+
+	call := &tokenCall{done: make(chan struct{})}
+	p.inflight = call
+
+	// Detach from the caller's cancellation so other waiters still get a result
+	go p.refresh(context.WithoutCancel(ctx), call)
+
+	return call
+}
+
+// refresh performs the token exchange, retrying once on failure
+func (p *RefreshTokenProvider) refresh(ctx context.Context, call *tokenCall) {
+	resp, err := p.exchange(ctx)
+	if err != nil {
+		p.logger.Warn(ctx, "Access token refresh failed, retrying once", map[string]interface{}{
+			"error": err.Error(),
+		})
+		resp, err = p.exchange(ctx)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err != nil {
+		p.logger.Error(ctx, "Failed to refresh access token", err, nil)
+		call.err = fmt.Errorf("%w: failed to refresh access token: %v", ErrAuthentication, err)
+	} else {
+		lifetime := time.Duration(resp.ExpiresIn) * time.Second
+		if lifetime <= 0 {
+			lifetime = defaultAccessTokenLifetime
+		}
+
+		p.accessToken = resp.AccessToken
+		p.expiresAt = time.Now().Add(lifetime)
+		if resp.RefreshToken != "" {
+			p.refreshToken = resp.RefreshToken
+		}
+		call.token = resp.AccessToken
+	}
+
+	p.inflight = nil
+	close(call.done)
+}
+
+// exchange calls the token endpoint with the current refresh token
+func (p *RefreshTokenProvider) exchange(ctx context.Context) (*tokenResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTokenRefreshTimeout)
+	defer cancel()
+
+	p.mutex.Lock()
+	refreshToken := p.refreshToken
+	p.mutex.Unlock()
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"refreshtoken": refreshToken,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	url, err := joinURL(p.baseURL, p.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token url: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokenResp tokenResponse
+	if err := json.Unmarshal(respBody, &tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("token response did not contain an access token")
+	}
+
+	return &tokenResp, nil
+}
@@ -0,0 +1,177 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// token_expiry.go implements local expiry of payment tokens
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultTokenLifetime is assumed when the gateway doesn't say how long a token lives
+const defaultTokenLifetime = 20 * time.Minute
+
+// tokenLifetime returns the configured fallback lifetime of payment tokens
+func (c *Client) tokenLifetime() time.Duration {
+	if lifetime := configValues(c.config).TokenLifetime; lifetime > 0 {
+		return lifetime
+	}
+	return defaultTokenLifetime
+}
+
+// tokenExpiry returns when a token issued at a time expires, preferring the
+// lifetime reported by the gateway
+func (c *Client) tokenExpiry(resp *PaymentInitResponse, issuedAt time.Time) time.Time {
+	if resp != nil && resp.ExpiresIn.Int64() > 0 {
+		return issuedAt.Add(time.Duration(resp.ExpiresIn.Int64()) * time.Second)
+	}
+	return issuedAt.Add(c.tokenLifetime())
+}
+
+// expiresAt returns when the token of a transaction expires, falling back to the
+// configured lifetime for transactions stored without an expiry
+func (c *Client) expiresAt(transaction *Transaction) time.Time {
+	if transaction.ExpiresAt != nil {
+		return *transaction.ExpiresAt
+	}
+	return transaction.CreatedAt.Add(c.tokenLifetime())
+}
+
+// isExpired reports whether a transaction's token has expired and it may be
+// marked EXPIRED. Payments the gateway reported paid are awaiting verification,
+// whose window outlives the token, and are never expired.
+func (c *Client) isExpired(transaction *Transaction, now time.Time) bool {
+	if awaitsVerification(transaction) || !transaction.Status.CanTransitionTo(StatusExpired) {
+		return false
+	}
+	return now.After(c.expiresAt(transaction))
+}
+
+// expireTransaction marks a transaction whose token expired as EXPIRED. The
+// transaction is read again under the token lock, and false is returned when a
+// concurrent verification or callback changed it so it no longer expires.
+func (c *Client) expireTransaction(ctx context.Context, transaction *Transaction) (bool, error) {
+	release := c.tokenLocks.Lock(transaction.Token)
+	defer release()
+
+	current, err := c.storage.GetTransaction(ctx, transaction.Token)
+	if err != nil {
+		return false, err
+	}
+	if !c.isExpired(current, c.clock.Now()) {
+		return false, nil
+	}
+	*transaction = *current
+
+	previousStatus := transaction.Status
+	status := StatusExpired
+	patch := TransactionPatch{Status: &status}
+	c.applyPatch(&patch, transaction)
+
+	if err := c.patchTransaction(ctx, transaction.Token, patch); err != nil {
+		return false, err
+	}
+	c.invalidateCache(ctx, transaction.Token)
+
+	c.fireStatusChange(ctx, transaction, previousStatus)
+	return true, nil
+}
+
+// expiredStatus returns the status of a stored transaction whose token expired,
+// marking it EXPIRED, so no gateway call is needed. It returns nil when the
+// transaction is unknown or still usable.
+func (c *Client) expiredStatus(ctx context.Context, token string) *PaymentStatusResponse {
+	transaction, err := c.storage.GetTransaction(ctx, token)
+	if err != nil || !c.isExpired(transaction, c.clock.Now()) {
+		return nil
+	}
+
+	expired, err := c.expireTransaction(ctx, transaction)
+	if err != nil {
+		c.log(ctx).Error(ctx, "Failed to mark transaction expired", err, transactionLogFields(transaction))
+	} else if !expired {
+		// Completed meanwhile, the gateway has the current status
+		return nil
+	}
+
+	return &PaymentStatusResponse{
+		Status:            true,
+		Amount:            transaction.Amount,
+		TransactionStatus: string(StatusExpired),
+		Message:           "payment token expired",
+	}
+}
+
+// ExpireTransactions marks stored INIT transactions whose token expired as EXPIRED
+// and returns how many were changed
+func (c *Client) ExpireTransactions(ctx context.Context) (int, error) {
+	transactions, err := c.storage.GetTransactionsByStatus(ctx, string(StatusInit))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pending transactions: %w", err)
+	}
+
+	now := c.clock.Now()
+	expired := 0
+	for _, transaction := range transactions {
+		if err := ctx.Err(); err != nil {
+			return expired, err
+		}
+		if !c.isExpired(transaction, now) {
+			continue
+		}
+
+		changed, err := c.expireTransaction(ctx, transaction)
+		if err != nil {
+			c.log(ctx).Error(ctx, "Failed to mark transaction expired", err, transactionLogFields(transaction))
+			continue
+		}
+		if changed {
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+// RunExpiryJanitor calls ExpireTransactions, CheckVerificationDeadlines and
+// PurgeExpiredEvidence every interval until the context is cancelled
+func (c *Client) RunExpiryJanitor(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+
+		expired, err := c.ExpireTransactions(ctx)
+		if err != nil && ctx.Err() == nil {
+			c.log(ctx).Error(ctx, "Failed to expire transactions", err, nil)
+		}
+		if expired > 0 {
+			c.log(ctx).Info(ctx, "Expired transactions", map[string]interface{}{
+				"count": expired,
+			})
+		}
+
+		if _, err := c.CheckVerificationDeadlines(ctx); err != nil && ctx.Err() == nil {
+			c.log(ctx).Error(ctx, "Failed to check verification deadlines", err, nil)
+		}
+
+		purged, err := c.PurgeExpiredEvidence(ctx)
+		if err != nil && ctx.Err() == nil {
+			c.log(ctx).Error(ctx, "Failed to purge gateway evidence", err, nil)
+		}
+		if purged > 0 {
+			c.log(ctx).Info(ctx, "Purged gateway evidence", map[string]interface{}{
+				"count": purged,
+			})
+		}
+	}
+}
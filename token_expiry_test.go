@@ -0,0 +1,190 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// storeAged stores a transaction created at the clock's current time
+func storeAged(t *testing.T, storage *MemoryStorage, clock *FakeClock, token string, status TransactionStatus, metadata map[string]string) {
+	t.Helper()
+
+	err := storage.StoreTransaction(context.Background(), &Transaction{
+		ID:        "tx-" + token,
+		Token:     token,
+		Amount:    10000,
+		Status:    status,
+		Metadata:  metadata,
+		CreatedAt: clock.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpireTransactions(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t), nil, WithClientClock(clock))
+
+	paidAt := clock.Now().Add(5 * time.Minute).Format(time.RFC3339Nano)
+	storeAged(t, storage, clock, "unpaid", StatusInit, nil)
+	storeAged(t, storage, clock, "awaiting-verify", StatusInit, map[string]string{CallbackSucceededMetadataKey: paidAt})
+	storeAged(t, storage, clock, "verify-pending", StatusVerifyPending, nil)
+	storeAged(t, storage, clock, "paid", StatusPaid, nil)
+
+	clock.Advance(defaultTokenLifetime - time.Second)
+	if expired, err := client.ExpireTransactions(context.Background()); err != nil || expired != 0 {
+		t.Fatalf("ExpireTransactions() before expiry = %d, %v", expired, err)
+	}
+
+	clock.Advance(2 * time.Second)
+	if expired, err := client.ExpireTransactions(context.Background()); err != nil || expired != 1 {
+		t.Fatalf("ExpireTransactions() = %d, %v; want only the unpaid payment", expired, err)
+	}
+
+	want := map[string]TransactionStatus{
+		"unpaid":          StatusExpired,
+		"awaiting-verify": StatusInit,
+		"verify-pending":  StatusVerifyPending,
+		"paid":            StatusPaid,
+	}
+	for token, status := range want {
+		transaction, _ := storage.GetTransaction(context.Background(), token)
+		if transaction.Status != status {
+			t.Errorf("%s: status %s, want %s", token, transaction.Status, status)
+		}
+	}
+}
+
+func TestExpiredStatusSkipsPaymentsAwaitingVerification(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+		"status": true, "amount": 10000, "transactionStatus": "PAID",
+	}))
+	client, storage, _ := newTestClient(t, testConfig(t), transport, WithClientClock(clock))
+
+	paidAt := clock.Now().Add(time.Minute).Format(time.RFC3339Nano)
+	storeAged(t, storage, clock, "unpaid", StatusInit, nil)
+	storeAged(t, storage, clock, "awaiting-verify", StatusInit, map[string]string{CallbackSucceededMetadataKey: paidAt})
+	storeAged(t, storage, clock, "verify-pending", StatusVerifyPending, nil)
+	clock.Advance(defaultTokenLifetime + time.Minute)
+
+	resp, err := client.GetPaymentStatus(context.Background(), "unpaid")
+	if err != nil || resp.TransactionStatus != string(StatusExpired) || transport.count() != 0 {
+		t.Fatalf("unpaid: %+v, %v after %d requests; want EXPIRED without asking the gateway", resp, err, transport.count())
+	}
+
+	for _, token := range []string{"awaiting-verify", "verify-pending"} {
+		if expired := client.expiredStatus(context.Background(), token); expired != nil {
+			t.Fatalf("%s: reported expired", token)
+		}
+		transaction, _ := storage.GetTransaction(context.Background(), token)
+		if transaction.Status == StatusExpired {
+			t.Fatalf("%s: marked EXPIRED while awaiting verification", token)
+		}
+	}
+}
+
+func TestExpiredPaymentAwaitingVerificationIsStillVerified(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+		"status": 1, "amount": "10000", "transId": 42,
+	}))
+	client, storage, _ := newTestClient(t, testConfig(t), transport, WithClientClock(clock))
+
+	// Paid near the end of the token's life, verified after it
+	storeAged(t, storage, clock, "late", StatusInit, map[string]string{
+		CallbackSucceededMetadataKey: clock.Now().Add(defaultTokenLifetime - time.Minute).Format(time.RFC3339Nano),
+	})
+	clock.Advance(defaultTokenLifetime + 5*time.Minute)
+
+	if _, err := client.ExpireTransactions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := client.VerifyPending(context.Background()); err != nil || verified != 1 {
+		t.Fatalf("VerifyPending() = %d, %v; want the paid payment verified", verified, err)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), "late")
+	if transaction.Status != StatusPaid {
+		t.Fatalf("status = %s, want PAID", transaction.Status)
+	}
+}
+
+func TestTokenExpiryRecordedAtInit(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     map[string]interface{}
+		lifetime time.Duration
+		want     time.Duration
+	}{
+		{"reported by the gateway", map[string]interface{}{"status": 1, "token": "tok-expiry", "expires_in": "600"}, 0, 10 * time.Minute},
+		{"default lifetime", map[string]interface{}{"status": 1, "token": "tok-expiry"}, 0, defaultTokenLifetime},
+		{"configured lifetime", map[string]interface{}{"status": 1, "token": "tok-expiry"}, 5 * time.Minute, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+			client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) { c.TokenLifetime = tt.lifetime }),
+				newStubTransport(jsonStep(http.StatusOK, tt.body)), WithClientClock(clock))
+
+			rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/init",
+				`{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`)
+			var resp PaymentInitResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+
+			// The response carries the expiry for a countdown, and the transaction keeps it
+			want := clock.Now().Add(tt.want)
+			if resp.ExpiresAt == nil || !resp.ExpiresAt.Equal(want) {
+				t.Fatalf("response expires at %v, want %v", resp.ExpiresAt, want)
+			}
+			transaction, _ := storage.GetTransaction(context.Background(), "tok-expiry")
+			if transaction.ExpiresAt == nil || !transaction.ExpiresAt.Equal(want) {
+				t.Fatalf("transaction expires at %v, want %v", transaction.ExpiresAt, want)
+			}
+		})
+	}
+}
+
+func TestRunExpiryJanitorUsesExpiresAt(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	client, storage, _ := newTestClient(t, testConfig(t), nil, WithClientClock(clock))
+
+	// A short-lived token expires long before the default lifetime
+	expiresAt := clock.Now().Add(2 * time.Minute)
+	err := storage.StoreTransaction(context.Background(), &Transaction{
+		ID:        "tx-short",
+		Token:     "short",
+		Amount:    10000,
+		Status:    StatusInit,
+		CreatedAt: clock.Now(),
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeAged(t, storage, clock, "default", StatusInit, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- client.RunExpiryJanitor(ctx, time.Minute) }()
+
+	waitForWaiters(t, clock)
+	clock.Advance(3 * time.Minute)
+	waitForStatus(t, storage, "short", StatusExpired)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("janitor returned %v", err)
+	}
+	if transaction, _ := storage.GetTransaction(context.Background(), "default"); transaction.Status != StatusInit {
+		t.Fatalf("default lifetime token %s after 3 minutes", transaction.Status)
+	}
+}
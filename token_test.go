@@ -0,0 +1,234 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenGateway issues access-token-N for every exchange and records the
+// Authorization header of the other requests by path
+type tokenGateway struct {
+	mutex     sync.Mutex
+	exchanges atomic.Int32
+	failures  int32
+	hold      chan struct{}
+	auth      map[string][]string
+	reject    func(path, auth string) bool
+}
+
+func newTokenGateway() *tokenGateway {
+	return &tokenGateway{auth: make(map[string][]string)}
+}
+
+func (g *tokenGateway) Do(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/refreshtoken") {
+		n := g.exchanges.Add(1)
+		if g.hold != nil {
+			<-g.hold
+		}
+		if n <= g.failures {
+			return stubResponse(req, http.StatusInternalServerError, map[string]interface{}{}), nil
+		}
+		body, _ := io.ReadAll(req.Body)
+		var exchange struct {
+			RefreshToken string `json:"refreshtoken"`
+		}
+		json.Unmarshal(body, &exchange)
+		return stubResponse(req, http.StatusOK, map[string]interface{}{
+			"access_token":  "access-token-" + string(rune('0'+n)),
+			"refresh_token": exchange.RefreshToken + "+",
+			"expires_in":    3600,
+		}), nil
+	}
+
+	auth := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	g.mutex.Lock()
+	g.auth[req.URL.Path] = append(g.auth[req.URL.Path], auth)
+	g.mutex.Unlock()
+	if g.reject != nil && g.reject(req.URL.Path, auth) {
+		return stubResponse(req, http.StatusUnauthorized, map[string]interface{}{"status": 0, "message": "Unauthenticated"}), nil
+	}
+	if strings.HasSuffix(req.URL.Path, "/balance") {
+		return stubResponse(req, http.StatusOK, map[string]interface{}{"status": true, "data": map[string]interface{}{"balance": 500000}}), nil
+	}
+	return stubResponse(req, http.StatusOK, map[string]interface{}{"status": 1}), nil
+}
+
+// sent returns the credentials sent to a path
+func (g *tokenGateway) sent(path string) []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return append([]string(nil), g.auth[path]...)
+}
+
+// tokenClient creates a client with a refresh token on a token gateway
+func tokenClient(t *testing.T, gateway *tokenGateway, mutate ...func(*Config)) *Client {
+	t.Helper()
+
+	client, _, _ := newTestClient(t, testConfig(t, append([]func(*Config){func(c *Config) {
+		c.RefreshToken = "refresh-1"
+		c.Business = "shop"
+	}}, mutate...)...), gateway)
+	return client
+}
+
+func TestAuthorizationPerEndpoint(t *testing.T) {
+	gateway := newTokenGateway()
+	client := tokenClient(t, gateway, func(c *Config) { c.APIVersion = APIVersionV3 })
+	ctx := context.Background()
+
+	// The v3 status path shares its prefix with the business API but is an IPG endpoint
+	for _, request := range []struct{ method, endpoint string }{
+		{http.MethodGet, client.statusEndpoint("tok")},
+		{http.MethodPost, client.endpoints().Verify},
+		{http.MethodPost, client.refundEndpoint("160000000001")},
+		{http.MethodGet, client.balanceEndpoint()},
+	} {
+		if _, _, err := client.makeRequest(ctx, request.method, request.endpoint, map[string]string{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{
+		"/v3/tok":        testAPIKey,
+		"/api/v3/verify": testAPIKey,
+		"/v3/business/shop/transaction/160000000001/refund": "access-token-1",
+		"/v2/business/shop/balance":                         "access-token-1",
+	}
+	for path, credential := range want {
+		if sent := gateway.sent(path); len(sent) != 1 || sent[0] != credential {
+			t.Errorf("%s: sent %v, want %s", path, sent, credential)
+		}
+	}
+}
+
+func TestAuthorizationCustomCatalog(t *testing.T) {
+	gateway := newTokenGateway()
+	client := tokenClient(t, gateway, func(c *Config) {
+		c.Endpoints = Endpoints{
+			Status: "/v3/business/status/{token}",
+			Refund: "/wallet/{business}/refunds/{transaction_id}",
+		}
+	})
+	ctx := context.Background()
+
+	// The catalog entry decides, whatever the path looks like
+	client.makeRequest(ctx, http.MethodGet, client.statusEndpoint("tok"), nil)
+	client.makeRequest(ctx, http.MethodPost, client.refundEndpoint("tx1"), map[string]int{"amount": 10000})
+	if sent := gateway.sent("/v3/business/status/tok"); len(sent) != 1 || sent[0] != testAPIKey {
+		t.Fatalf("overridden status path sent %v", sent)
+	}
+	if sent := gateway.sent("/wallet/shop/refunds/tx1"); len(sent) != 1 || sent[0] != "access-token-1" {
+		t.Fatalf("overridden refund path sent %v", sent)
+	}
+}
+
+func TestRefreshTokenProviderSingleRefresh(t *testing.T) {
+	gateway := newTokenGateway()
+	gateway.hold = make(chan struct{})
+	provider, err := NewRefreshTokenProvider(testConfig(t, func(c *Config) { c.RefreshToken = "refresh-1" }), gateway, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent callers share one exchange
+	var wg sync.WaitGroup
+	tokens := make(chan string, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := provider.Token(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			tokens <- token
+		}()
+	}
+	for gateway.exchanges.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(gateway.hold)
+	wg.Wait()
+	close(tokens)
+
+	for token := range tokens {
+		if token != "access-token-1" {
+			t.Fatalf("got %q", token)
+		}
+	}
+	if gateway.exchanges.Load() != 1 {
+		t.Fatalf("%d exchanges, want 1", gateway.exchanges.Load())
+	}
+
+	// The cached token is reused, and the rotated refresh token kept
+	if token, _ := provider.Token(context.Background()); token != "access-token-1" || gateway.exchanges.Load() != 1 {
+		t.Fatalf("cached token %q after %d exchanges", token, gateway.exchanges.Load())
+	}
+	if provider.refreshToken != "refresh-1+" {
+		t.Fatalf("refresh token %q, want the rotated one", provider.refreshToken)
+	}
+}
+
+func TestRefreshTokenProviderRetriesExchange(t *testing.T) {
+	tests := []struct {
+		failures  int32
+		exchanges int32
+		ok        bool
+	}{
+		{1, 2, true},
+		{2, 2, false},
+	}
+	for _, tt := range tests {
+		gateway := newTokenGateway()
+		gateway.failures = tt.failures
+		provider, err := NewRefreshTokenProvider(testConfig(t, func(c *Config) { c.RefreshToken = "refresh-1" }), gateway, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = provider.Token(context.Background())
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrAuthentication)) || gateway.exchanges.Load() != tt.exchanges {
+			t.Errorf("%d failures: %v after %d exchanges", tt.failures, err, gateway.exchanges.Load())
+		}
+	}
+}
+
+func TestAccessTokenRetryOnUnauthorized(t *testing.T) {
+	gateway := newTokenGateway()
+	// The first access token was revoked before its expiry
+	gateway.reject = func(path, auth string) bool { return auth == "access-token-1" }
+	client := tokenClient(t, gateway)
+
+	if _, err := client.GetWalletBalance(context.Background()); err != nil {
+		t.Fatalf("GetWalletBalance() error = %v", err)
+	}
+	if sent := gateway.sent("/v2/business/shop/balance"); len(sent) != 2 || sent[1] != "access-token-2" || gateway.exchanges.Load() != 2 {
+		t.Fatalf("sent %v after %d exchanges", sent, gateway.exchanges.Load())
+	}
+
+	// A rejected fresh token is retried only once
+	gateway.reject = func(path, auth string) bool { return true }
+	if _, err := client.GetWalletBalance(context.Background()); err == nil {
+		t.Fatal("GetWalletBalance() succeeded with every token rejected")
+	}
+	if sent := gateway.sent("/v2/business/shop/balance"); len(sent) != 4 {
+		t.Fatalf("%d balance requests, want 4", len(sent))
+	}
+
+	// IPG endpoints don't refresh on 401
+	exchanges := gateway.exchanges.Load()
+	client.makeRequest(context.Background(), http.MethodGet, client.statusEndpoint("tok"), nil)
+	if gateway.exchanges.Load() != exchanges || len(gateway.sent("/v4/tok")) != 1 {
+		t.Fatalf("status lookup refreshed the access token")
+	}
+}
@@ -0,0 +1,233 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// trace.go implements verbose debug tracing of individual payment tokens
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// traceKey marks a context as belonging to a traced token
+const traceKey contextKey = "trace"
+
+// defaultTraceTTL is how long a token is traced when no TTL is given
+const defaultTraceTTL = 30 * time.Minute
+
+// traceBodyKeys lists body fields replaced before traced bodies are logged
+var traceBodyKeys = map[string]bool{
+	"api_key":       true,
+	"refresh_token": true,
+	"access_token":  true,
+	"password":      true,
+	"secret":        true,
+}
+
+// traceSet holds the traced tokens and when their tracing ends
+type traceSet struct {
+	mutex  sync.Mutex
+	tokens map[string]time.Time
+}
+
+// newTraceSet creates an empty trace set
+func newTraceSet() *traceSet {
+	return &traceSet{tokens: make(map[string]time.Time)}
+}
+
+// add traces a token until a time
+func (s *traceSet) add(token string, until time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tokens[token] = until
+}
+
+// remove stops tracing a token, reporting whether it was traced
+func (s *traceSet) remove(token string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.tokens[token]
+	delete(s.tokens, token)
+	return ok
+}
+
+// traced reports whether a token is traced, removing it once its TTL has passed
+func (s *traceSet) traced(token string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	until, ok := s.tokens[token]
+	if !ok {
+		return false
+	}
+	if now.After(until) {
+		delete(s.tokens, token)
+		return false
+	}
+	return true
+}
+
+// AddTraceToken logs everything touching a token at Debug level, whatever the
+// logger's level, for ttl (30 minutes when not positive). Entries carry
+// "traced": true and gateway calls include their redacted bodies. It returns
+// when tracing ends.
+func (c *Client) AddTraceToken(token string, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		ttl = defaultTraceTTL
+	}
+
+	until := c.clock.Now().Add(ttl)
+	c.traces.add(token, until)
+	return until
+}
+
+// RemoveTraceToken stops tracing a token, reporting whether it was traced
+func (c *Client) RemoveTraceToken(token string) bool {
+	return c.traces.remove(token)
+}
+
+// ContextWithTrace returns a context whose log entries are written at every level
+func ContextWithTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, traceKey, true)
+}
+
+// IsTraced reports whether a context belongs to a traced token. Custom loggers
+// should write Debug entries of traced contexts regardless of their level.
+func IsTraced(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	traced, _ := ctx.Value(traceKey).(bool)
+	return traced
+}
+
+// traceContext marks the context of work on a traced token and adds the traced
+// field to its logger
+func (c *Client) traceContext(ctx context.Context, token string) context.Context {
+	if token == "" || IsTraced(ctx) || !c.traces.traced(token, c.clock.Now()) {
+		return ctx
+	}
+
+	ctx = ContextWithTrace(ctx)
+	return ContextWithLogger(ctx, WithFields(c.log(ctx), map[string]interface{}{
+		"traced": true,
+	}))
+}
+
+// traceRequest returns the request with its context traced when the token is
+func (c *Client) traceRequest(r *http.Request, token string) *http.Request {
+	ctx := c.traceContext(r.Context(), token)
+	if ctx == r.Context() {
+		return r
+	}
+	return r.WithContext(ctx)
+}
+
+// traceBody returns a log-safe form of a traced request or response body. JSON
+// bodies have their credentials replaced; card numbers are masked in any body.
+func traceBody(body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return redactBody(string(body))
+	}
+	return redactTraceValue("", decoded)
+}
+
+// redactTraceValue redacts a decoded JSON value found under a key
+func redactTraceValue(key string, value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			value[k] = redactTraceValue(k, v)
+		}
+		return value
+	case []interface{}:
+		for i, v := range value {
+			value[i] = redactTraceValue(key, v)
+		}
+		return value
+	case string:
+		switch {
+		case traceBodyKeys[key]:
+			return "****"
+		case key == "token":
+			return redactToken(value)
+		default:
+			return redactBody(value)
+		}
+	default:
+		return value
+	}
+}
+
+// TraceTokenRequest is the body of a request to trace a token
+type TraceTokenRequest struct {
+	// TTL is how long the token is traced, e.g. "15m" (30 minutes when empty)
+	TTL string `json:"ttl,omitempty"`
+
+	// Stop ends tracing the token instead
+	Stop bool `json:"stop,omitempty"`
+}
+
+// TraceTokenResponse reports the tracing of a token
+type TraceTokenResponse struct {
+	// Traced reports whether the token is traced
+	Traced bool `json:"traced"`
+
+	// Until is when tracing ends
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// handleTraceToken starts or stops tracing a token
+func (c *Client) handleTraceToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// The transaction is addressed by its token in the path
+	token := pathParam(r, "id")
+	if token == "" {
+		c.respondWithError(w, ErrInvalidRequest, "Transaction token is required")
+		return
+	}
+
+	// The body is optional
+	var req TraceTokenRequest
+	if r.ContentLength != 0 {
+		if err := parseJSONBody(r, &req); err != nil {
+			c.respondInvalid(w, err)
+			return
+		}
+	}
+
+	if req.Stop {
+		c.RemoveTraceToken(token)
+		c.log(ctx).Info(ctx, "Stopped tracing token", map[string]interface{}{
+			"token": redactToken(token),
+		})
+		c.respondWithJSON(w, http.StatusOK, TraceTokenResponse{Traced: false})
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 {
+			c.respondInvalid(w, NewValidationError("ttl", "must be a positive duration such as 15m"))
+			return
+		}
+		ttl = parsed
+	}
+
+	until := c.AddTraceToken(token, ttl)
+	c.log(ctx).Info(ctx, "Started tracing token", map[string]interface{}{
+		"token": redactToken(token),
+		"until": until.Format(time.RFC3339),
+	})
+	c.respondWithJSON(w, http.StatusOK, TraceTokenResponse{Traced: true, Until: &until})
+}
@@ -0,0 +1,149 @@
+package vandargo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// quietToken is a stored payment that is never traced
+const quietToken = "sim00000000000000099"
+
+func TestTraceTokenLogging(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	var out bytes.Buffer
+	storage := NewMemoryStorage()
+	client, err := NewClient(testConfig(t), storage, NewDefaultLoggerWithOutput("ERROR", &out, &out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = client.Clone(WithClientHTTPClient(newStubTransport(verifySuccess())), WithClientClock(clock))
+	storeWebhookPayment(t, storage, StatusInit)
+	storage.StoreTransaction(context.Background(), &Transaction{ID: "tx-quiet", Token: quietToken, Amount: 100000, Status: StatusInit, CreatedAt: clock.Now()})
+
+	// Normal tokens stay quiet at the ERROR level
+	client.VerifyPayment(context.Background(), quietToken)
+	if out.Len() != 0 {
+		t.Fatalf("untraced token logged:\n%s", out.String())
+	}
+
+	// Traced tokens log their gateway calls at Debug with redacted bodies
+	client.AddTraceToken(webhookToken, 10*time.Minute)
+	if _, err := client.VerifyPayment(context.Background(), webhookToken); err != nil {
+		t.Fatal(err)
+	}
+	logged := out.String()
+	for _, want := range []string{`"level":"DEBUG"`, `"traced":true`, `"request_body"`, `"response_body"`, `"transId":160000000001`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("traced output lacks %s:\n%s", want, logged)
+		}
+	}
+	if strings.Contains(logged, testAPIKey) || strings.Contains(logged, webhookToken) {
+		t.Fatalf("traced output reveals the API key or the full token:\n%s", logged)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logged), "\n") {
+		if !strings.Contains(line, `"traced":true`) {
+			t.Fatalf("traced entry without the traced field: %s", line)
+		}
+	}
+
+	// Tracing ends by itself after its TTL
+	out.Reset()
+	clock.Advance(10*time.Minute + time.Second)
+	client.VerifyPayment(context.Background(), webhookToken)
+	if out.Len() != 0 || client.traces.remove(webhookToken) {
+		t.Fatalf("token traced after its TTL:\n%s", out.String())
+	}
+}
+
+func TestTraceTokenSet(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	client, _, _ := newTestClient(t, testConfig(t), nil, WithClientClock(clock))
+
+	// Without a TTL tokens are traced for the default period
+	if until := client.AddTraceToken("tok-a", 0); !until.Equal(clock.Now().Add(defaultTraceTTL)) {
+		t.Fatalf("default trace ends at %v", until)
+	}
+	if !IsTraced(client.traceContext(context.Background(), "tok-a")) || IsTraced(client.traceContext(context.Background(), "tok-b")) {
+		t.Fatal("trace context doesn't follow the trace set")
+	}
+	if !client.RemoveTraceToken("tok-a") || client.RemoveTraceToken("tok-a") {
+		t.Fatal("RemoveTraceToken() should report only a traced token")
+	}
+	if IsTraced(client.traceContext(context.Background(), "tok-a")) || IsTraced(nil) {
+		t.Fatal("removed token still traced")
+	}
+
+	// Concurrent use is safe
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 200; j++ {
+				client.AddTraceToken("tok-c", time.Minute)
+				client.traceContext(context.Background(), "tok-c")
+				client.RemoveTraceToken("tok-c")
+			}
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+}
+
+func TestTraceTokenRoute(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) { c.AdminKey = "admin-key" }), nil, WithClientClock(clock))
+	handler := client.Handler()
+
+	trace := func(body string) (*httptest.ResponseRecorder, TraceTokenResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/payments/transactions/tok-a/trace", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		req.Header.Set(AdminKeyHeader, "admin-key")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var resp TraceTokenResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	rec, resp := trace(`{"ttl":"15m"}`)
+	if rec.Code != http.StatusOK || !resp.Traced || resp.Until == nil || !resp.Until.Equal(clock.Now().Add(15*time.Minute)) {
+		t.Fatalf("start: status %d: %s", rec.Code, rec.Body)
+	}
+	if !client.traces.traced("tok-a", clock.Now()) {
+		t.Fatal("route did not trace the token")
+	}
+
+	if rec, _ := trace(`{"ttl":"soon"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid ttl: status %d", rec.Code)
+	}
+
+	if rec, resp := trace(`{"stop":true}`); rec.Code != http.StatusOK || resp.Traced || client.traces.traced("tok-a", clock.Now()) {
+		t.Fatalf("stop: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTraceBody(t *testing.T) {
+	body := traceBody([]byte(`{"api_key":"key-1","token":"sim00000000000000001","card":"6037991234567890","nested":[{"password":"p"}]}`))
+	data, _ := json.Marshal(body)
+	for _, secret := range []string{"key-1", "sim00000000000000001", fullCardNumber, `"p"`} {
+		if strings.Contains(string(data), secret) {
+			t.Fatalf("traced body %s reveals %s", data, secret)
+		}
+	}
+
+	if traceBody(nil) != nil {
+		t.Fatal("empty body traced")
+	}
+	if text, ok := traceBody([]byte("card 6037991234567890")).(string); !ok || leaksCardNumber(text) {
+		t.Fatalf("plain body traced as %v", text)
+	}
+}
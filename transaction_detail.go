@@ -0,0 +1,80 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// transaction_detail.go implements the admin view of a stored transaction
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// TransactionDetail is a stored transaction with its refunds
+type TransactionDetail struct {
+	Transaction
+
+	// Refunds are the recorded refunds of the transaction, oldest first
+	Refunds []*Refund `json:"refunds"`
+}
+
+// GetTransactionDetail returns a stored transaction with its recorded refunds.
+// Refunds are empty when the storage doesn't implement RefundStorageInterface.
+func (c *Client) GetTransactionDetail(ctx context.Context, token string) (*TransactionDetail, error) {
+	if token == "" {
+		return nil, NewValidationError("token", "token is required")
+	}
+
+	transaction, err := c.storage.GetTransaction(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	detail := &TransactionDetail{Transaction: *transaction, Refunds: []*Refund{}}
+	if storage, ok := c.storage.(RefundStorageInterface); ok {
+		refunds, err := storage.GetRefundsByTransaction(ctx, token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list refunds: %w", err)
+		}
+		if refunds != nil {
+			detail.Refunds = refunds
+		}
+	}
+
+	return detail, nil
+}
+
+// handleTransactionDetail returns a stored transaction with its refunds
+func (c *Client) handleTransactionDetail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// The transaction is addressed by its token in the path
+	token := pathParam(r, "id")
+	if token == "" {
+		c.respondWithError(w, ErrInvalidRequest, "Transaction token is required")
+		return
+	}
+	r = c.traceRequest(r, token)
+	ctx = r.Context()
+
+	// Unmasked data needs its own key on top of the admin key
+	includeSensitive, ok := c.includeSensitive(w, r)
+	if !ok {
+		return
+	}
+
+	detail, err := c.GetTransactionDetail(ctx, token)
+	switch {
+	case err == nil:
+		if !includeSensitive {
+			detail.Transaction = *c.maskedTransaction(&detail.Transaction)
+		}
+		c.respondWithJSON(w, http.StatusOK, detail)
+	case errors.Is(err, ErrNotFound):
+		c.respondWithError(w, ErrNotFound, "Transaction not found")
+	default:
+		c.respondWithError(w, ErrInternalError, "Failed to get transaction")
+		c.log(ctx).Error(ctx, "Failed to get transaction detail", err, map[string]interface{}{
+			"token": redactToken(token),
+		})
+	}
+}
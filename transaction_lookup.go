@@ -0,0 +1,279 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// transaction_lookup.go implements addressing a payment by its local ID or factor number
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// TransactionNotFoundCode is the error code of requests naming a transaction
+// that isn't stored
+const TransactionNotFoundCode = "transaction_not_found"
+
+var (
+	// ErrTransactionNotFound is returned when a transaction ID or factor number
+	// doesn't resolve to a stored transaction; handlers answer it with 404
+	ErrTransactionNotFound = fmt.Errorf("%w: transaction not found", ErrNotFound)
+
+	// ErrAmbiguousIdentifier is returned when a request names a transaction in
+	// more than one way; handlers answer it with 400
+	ErrAmbiguousIdentifier = fmt.Errorf("%w: give only one of token, transaction_id and factor_number", ErrInvalidRequest)
+)
+
+// VerifyBy identifies a payment by exactly one of its token, its local
+// transaction ID or its factor number, so callers that only know their order
+// don't need to handle gateway tokens
+type VerifyBy struct {
+	// Token is the payment token
+	Token string
+
+	// TransactionID is the ID of the stored transaction (Transaction.ID)
+	TransactionID string
+
+	// FactorNumber is the merchant's invoice number; the most recent payment
+	// with it is used
+	FactorNumber string
+}
+
+// count returns how many identifiers are set
+func (b VerifyBy) count() int {
+	count := 0
+	for _, value := range []string{b.Token, b.TransactionID, b.FactorNumber} {
+		if value != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// check returns a validation error when no identifier is set and
+// ErrAmbiguousIdentifier when more than one is
+func (b VerifyBy) check() error {
+	switch b.count() {
+	case 0:
+		return NewValidationError("token", "one of token, transaction_id or factor_number is required")
+	case 1:
+		return nil
+	default:
+		return ErrAmbiguousIdentifier
+	}
+}
+
+// TransactionIDStorageInterface is implemented by storages that can look up a
+// transaction by its local ID; other storages are scanned by status
+type TransactionIDStorageInterface interface {
+	// GetTransactionByID retrieves the transaction with a local ID
+	GetTransactionByID(ctx context.Context, id string) (*Transaction, error)
+}
+
+// GetTransactionByID retrieves the transaction with a local ID
+func (s *MemoryStorage) GetTransactionByID(ctx context.Context, id string) (*Transaction, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, transaction := range s.transactions {
+		if transaction.ID == id {
+			// Return a copy to prevent external modifications
+			transactionCopy := *transaction
+			return &transactionCopy, nil
+		}
+	}
+
+	return nil, fmt.Errorf("transaction not found: %s", id)
+}
+
+// resolveTransaction returns the stored transaction a VerifyBy names. Of several
+// transactions with the factor number, the most recent one accept allows is
+// used; accept may be nil to allow any. Unknown identifiers return
+// ErrTransactionNotFound.
+func (c *Client) resolveTransaction(ctx context.Context, by VerifyBy, accept func(*Transaction) bool) (*Transaction, error) {
+	if err := by.check(); err != nil {
+		return nil, err
+	}
+	if accept == nil {
+		accept = func(*Transaction) bool { return true }
+	}
+
+	var candidates []*Transaction
+	var err error
+	switch {
+	case by.Token != "":
+		var transaction *Transaction
+		if transaction, err = c.storage.GetTransaction(ctx, by.Token); err == nil {
+			candidates = []*Transaction{transaction}
+		} else if ctx.Err() == nil {
+			// Storages report missing tokens in their own words
+			err = nil
+		}
+	case by.TransactionID != "":
+		candidates, err = c.transactionsByID(ctx, by.TransactionID)
+	default:
+		candidates, err = c.transactionsByFactorNumber(ctx, by.FactorNumber)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up transaction: %w", err)
+	}
+
+	var found *Transaction
+	for _, transaction := range candidates {
+		if !accept(transaction) {
+			continue
+		}
+		if found == nil || transaction.CreatedAt.After(found.CreatedAt) {
+			found = transaction
+		}
+	}
+	if found == nil {
+		return nil, ErrTransactionNotFound
+	}
+	return found, nil
+}
+
+// transactionsByID returns the transaction with a local ID, scanning every
+// status when the storage can't look it up directly
+func (c *Client) transactionsByID(ctx context.Context, id string) ([]*Transaction, error) {
+	if lookup, ok := c.storage.(TransactionIDStorageInterface); ok {
+		transaction, err := lookup.GetTransactionByID(ctx, id)
+		if err != nil {
+			// Storages report missing IDs in their own words
+			return nil, ctx.Err()
+		}
+		return []*Transaction{transaction}, nil
+	}
+
+	return c.scanTransactions(ctx, func(transaction *Transaction) bool {
+		return transaction.ID == id
+	})
+}
+
+// transactionsByFactorNumber returns the transactions with a factor number,
+// scanning every status when the storage can't look them up directly
+func (c *Client) transactionsByFactorNumber(ctx context.Context, factorNumber string) ([]*Transaction, error) {
+	if lookup, ok := c.storage.(FactorNumberStorageInterface); ok {
+		return lookup.GetTransactionsByFactorNumber(ctx, factorNumber)
+	}
+
+	return c.scanTransactions(ctx, func(transaction *Transaction) bool {
+		return transaction.FactorNumber == factorNumber
+	})
+}
+
+// scanTransactions returns the stored transactions of every status that match
+func (c *Client) scanTransactions(ctx context.Context, match func(*Transaction) bool) ([]*Transaction, error) {
+	var result []*Transaction
+	for _, status := range transactionStatuses {
+		transactions, err := c.storage.GetTransactionsByStatus(ctx, string(status))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s transactions: %w", status, err)
+		}
+		for _, transaction := range transactions {
+			if match(transaction) {
+				result = append(result, transaction)
+			}
+		}
+	}
+	return result, nil
+}
+
+// hasToken reports whether a transaction was issued a gateway token, which
+// queued initializations haven't
+func hasToken(transaction *Transaction) bool {
+	return transaction.Token != "" && transaction.Status != StatusQueued
+}
+
+// isRefundable reports whether a transaction was paid, so it has a gateway
+// transaction ID to refund
+func isRefundable(transaction *Transaction) bool {
+	switch transaction.Status {
+	case StatusPaid, StatusSuspect, StatusRefunded:
+		return transaction.TransactionID != 0
+	default:
+		return false
+	}
+}
+
+// ResolveToken returns the payment token a VerifyBy names
+func (c *Client) ResolveToken(ctx context.Context, by VerifyBy) (string, error) {
+	if by.Token != "" && by.count() == 1 {
+		return by.Token, nil
+	}
+
+	transaction, err := c.resolveTransaction(ctx, by, hasToken)
+	if err != nil {
+		return "", err
+	}
+	return transaction.Token, nil
+}
+
+// VerifyPaymentBy verifies a payment named by its token, local transaction ID or
+// factor number. Identifiers that don't resolve return ErrTransactionNotFound,
+// more than one identifier ErrAmbiguousIdentifier.
+func (c *Client) VerifyPaymentBy(ctx context.Context, by VerifyBy) (*PaymentVerifyResponse, error) {
+	token, err := c.ResolveToken(ctx, by)
+	if err != nil {
+		return nil, err
+	}
+	return c.VerifyPayment(ctx, token)
+}
+
+// resolveRefundRequest fills the gateway transaction ID of a refund request
+// naming its payment by token or factor number
+func (c *Client) resolveRefundRequest(ctx context.Context, req *RefundRequest) error {
+	if req.Token == "" && req.FactorNumber == "" {
+		return nil
+	}
+	if req.TransactionID != "" {
+		return ErrAmbiguousIdentifier
+	}
+
+	transaction, err := c.resolveTransaction(ctx, VerifyBy{Token: req.Token, FactorNumber: req.FactorNumber}, isRefundable)
+	if err != nil {
+		return err
+	}
+
+	req.TransactionID = strconv.FormatInt(transaction.TransactionID, 10)
+	req.Token = ""
+	req.FactorNumber = ""
+	return nil
+}
+
+// requestTokenBy returns the token of a request naming its payment by token,
+// transaction ID, factor number or, when none is given, the session query
+// parameter. It writes the error response and returns false when the payment
+// cannot be resolved.
+func (c *Client) requestTokenBy(w http.ResponseWriter, r *http.Request, by VerifyBy) (string, bool) {
+	if by.TransactionID == "" && by.FactorNumber == "" {
+		return c.requestToken(w, r, by.Token)
+	}
+
+	ctx := r.Context()
+	token, err := c.ResolveToken(ctx, by)
+	if err != nil {
+		c.respondLookupError(w, r, err)
+		return "", false
+	}
+	return token, true
+}
+
+// respondLookupError answers a request whose transaction couldn't be resolved
+func (c *Client) respondLookupError(w http.ResponseWriter, r *http.Request, err error) {
+	if IsDomainError(err) || IsValidationError(err) {
+		c.respondError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	c.respondWithError(w, ErrInternalError, "Failed to look up transaction")
+	c.log(ctx).Error(ctx, "Failed to look up transaction", err, nil)
+}
@@ -0,0 +1,213 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lookupPayments initiates two payments with factor number 1042 and one with
+// 1043 on the client's simulator and returns their tokens, oldest first
+func lookupPayments(t *testing.T, client *Client, clock *FakeClock) []string {
+	t.Helper()
+
+	var tokens []string
+	for _, factorNumber := range []string{"1042", "1042", "1043"} {
+		resp, err := client.InitiatePaymentWithRequest(context.Background(), &PaymentInitRequest{Amount: 100000, FactorNumber: factorNumber}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, resp.Token)
+		clock.Advance(time.Minute)
+	}
+	return tokens
+}
+
+// lookupClient creates a client on a simulator paying at once, with a fake clock
+func lookupClient(t *testing.T, storage StorageInterface) (*Client, *FakeClock) {
+	t.Helper()
+
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	client, err := NewClient(testConfig(t), storage, &captureLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.Clone(WithClientHTTPClient(NewSimulatorTransport(WithSimulatorPaidAfter(0))), WithClientClock(clock)), clock
+}
+
+// scanOnlyStorage hides the direct lookups of a storage, so transactions are
+// found by scanning every status
+type scanOnlyStorage struct {
+	StorageInterface
+}
+
+func TestVerifyByValidation(t *testing.T) {
+	tests := []struct {
+		by   VerifyBy
+		want func(error) bool
+	}{
+		{VerifyBy{}, IsValidationError},
+		{VerifyBy{Token: "tok"}, func(err error) bool { return err == nil }},
+		{VerifyBy{TransactionID: "tx1"}, func(err error) bool { return err == nil }},
+		{VerifyBy{FactorNumber: "1042"}, func(err error) bool { return err == nil }},
+		{VerifyBy{Token: "tok", FactorNumber: "1042"}, func(err error) bool { return errors.Is(err, ErrAmbiguousIdentifier) }},
+		{VerifyBy{Token: "tok", TransactionID: "tx1", FactorNumber: "1042"}, func(err error) bool { return errors.Is(err, ErrAmbiguousIdentifier) }},
+	}
+
+	for _, tt := range tests {
+		if err := tt.by.check(); !tt.want(err) {
+			t.Errorf("%+v: %v", tt.by, err)
+		}
+	}
+	if errorToStatus(ErrAmbiguousIdentifier) != http.StatusBadRequest || errorToStatus(ErrTransactionNotFound) != http.StatusNotFound {
+		t.Fatal("lookup errors map to the wrong status codes")
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	for _, storage := range []StorageInterface{NewMemoryStorage(), scanOnlyStorage{NewMemoryStorage()}} {
+		client, clock := lookupClient(t, storage)
+		tokens := lookupPayments(t, client, clock)
+		first, _ := storage.GetTransaction(context.Background(), tokens[0])
+
+		tests := []struct {
+			by   VerifyBy
+			want string
+			err  error
+		}{
+			{VerifyBy{Token: tokens[1]}, tokens[1], nil},
+			{VerifyBy{TransactionID: first.ID}, tokens[0], nil},
+			{VerifyBy{FactorNumber: "1042"}, tokens[1], nil},
+			{VerifyBy{FactorNumber: "1043"}, tokens[2], nil},
+			{VerifyBy{FactorNumber: "9999"}, "", ErrTransactionNotFound},
+			{VerifyBy{TransactionID: "tx-unknown"}, "", ErrTransactionNotFound},
+			{VerifyBy{Token: tokens[0], TransactionID: first.ID}, "", ErrAmbiguousIdentifier},
+		}
+		for _, tt := range tests {
+			token, err := client.ResolveToken(context.Background(), tt.by)
+			if token != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("%T %+v: %q, %v; want %q, %v", storage, tt.by, token, err, tt.want, tt.err)
+			}
+		}
+	}
+}
+
+func TestVerifyHandlerIdentifiers(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   func(tokens []string, firstID string) string
+		status int
+		want   int
+		code   string
+	}{
+		{"token", func(tokens []string, _ string) string { return `{"token":"` + tokens[2] + `"}` }, http.StatusOK, 2, ""},
+		{"transaction ID", func(_ []string, firstID string) string { return `{"transaction_id":"` + firstID + `"}` }, http.StatusOK, 0, ""},
+		{"factor number", func([]string, string) string { return `{"factor_number":"1042"}` }, http.StatusOK, 1, ""},
+		{"unknown transaction ID", func([]string, string) string { return `{"transaction_id":"tx-unknown"}` }, http.StatusNotFound, -1, TransactionNotFoundCode},
+		{"unknown factor number", func([]string, string) string { return `{"factor_number":"9999"}` }, http.StatusNotFound, -1, TransactionNotFoundCode},
+		{"two identifiers", func(tokens []string, _ string) string {
+			return `{"token":"` + tokens[0] + `","factor_number":"1042"}`
+		}, http.StatusBadRequest, -1, ""},
+		{"no identifier", func([]string, string) string { return `{}` }, http.StatusUnprocessableEntity, -1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewMemoryStorage()
+			client, clock := lookupClient(t, storage)
+			tokens := lookupPayments(t, client, clock)
+			first, _ := storage.GetTransaction(context.Background(), tokens[0])
+
+			rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/verify", tt.body(tokens, first.ID))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var body struct {
+				Code string `json:"code"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body.Code != tt.code {
+				t.Fatalf("code %q, want %q", body.Code, tt.code)
+			}
+
+			// Only the named payment is verified
+			for i, token := range tokens {
+				transaction, _ := storage.GetTransaction(context.Background(), token)
+				if (transaction.Status == StatusPaid) != (i == tt.want) {
+					t.Errorf("payment %d is %s", i, transaction.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusHandlerIdentifiers(t *testing.T) {
+	storage := NewMemoryStorage()
+	client, clock := lookupClient(t, storage)
+	tokens := lookupPayments(t, client, clock)
+	first, _ := storage.GetTransaction(context.Background(), tokens[0])
+	handler := client.Handler()
+
+	for _, tt := range []struct {
+		query  url.Values
+		status int
+	}{
+		{url.Values{"token": {tokens[0]}}, http.StatusOK},
+		{url.Values{"transaction_id": {first.ID}}, http.StatusOK},
+		{url.Values{"factor_number": {"1043"}}, http.StatusOK},
+		{url.Values{"factor_number": {"9999"}}, http.StatusNotFound},
+		{url.Values{"transaction_id": {first.ID}, "factor_number": {"1042"}}, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/payments/status?"+tt.query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		req.RemoteAddr = "203.0.113.30:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.query.Encode(), rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+func TestRefundHandlerIdentifiers(t *testing.T) {
+	storage := NewMemoryStorage()
+	client, clock := lookupClient(t, storage)
+	tokens := lookupPayments(t, client, clock)
+	for _, token := range tokens[1:] {
+		if _, err := client.VerifyPayment(context.Background(), token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paid, _ := storage.GetTransaction(context.Background(), tokens[2])
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"token":"` + tokens[2] + `","amount":10000}`, http.StatusOK},
+		{`{"factor_number":"1042","amount":10000}`, http.StatusOK},
+		{`{"token":"` + tokens[0] + `","amount":10000}`, http.StatusNotFound},
+		{`{"factor_number":"9999","amount":10000}`, http.StatusNotFound},
+		{`{"transaction_id":"` + strconv.FormatInt(paid.TransactionID, 10) + `","token":"` + tokens[2] + `"}`, http.StatusBadRequest},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/payments/refund", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		// The refund route allows few requests per minute
+		req.RemoteAddr = fmt.Sprintf("203.0.113.%d:1234", 50+i)
+		rec := httptest.NewRecorder()
+		client.Handler().ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.body, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
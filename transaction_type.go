@@ -0,0 +1,84 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// transaction_type.go implements looking up transactions by their type
+package vandargo
+
+import (
+	"context"
+	"fmt"
+)
+
+// TypeStorageInterface is implemented by storages that can look up transactions
+// by type; records without a type must be treated as payments
+type TypeStorageInterface interface {
+	// GetTransactionsByType retrieves the transactions of a type
+	GetTransactionsByType(ctx context.Context, transactionType TransactionType) ([]*Transaction, error)
+}
+
+// TransactionsByType returns the stored transactions of a type. Records stored
+// before transaction types existed count as payments.
+func (c *Client) TransactionsByType(ctx context.Context, transactionType TransactionType) ([]*Transaction, error) {
+	if !transactionType.IsValid() {
+		return nil, NewValidationError("type", fmt.Sprintf("unknown transaction type %q", transactionType))
+	}
+
+	if lookup, ok := c.storage.(TypeStorageInterface); ok {
+		transactions, err := lookup.GetTransactionsByType(ctx, transactionType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up transactions by type: %w", err)
+		}
+		return transactions, nil
+	}
+
+	var result []*Transaction
+	for _, status := range transactionStatuses {
+		transactions, err := c.storage.GetTransactionsByStatus(ctx, string(status))
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up transactions by type: %w", err)
+		}
+		for _, transaction := range transactions {
+			if transaction.Type.OrDefault() == transactionType {
+				result = append(result, transaction)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// CountTransactionsByType returns the number of stored transactions per type
+func (c *Client) CountTransactionsByType(ctx context.Context) (map[TransactionType]int, error) {
+	counts := make(map[TransactionType]int)
+	for _, transactionType := range []TransactionType{TransactionTypePayment, TransactionTypeRefund, TransactionTypeSettlement, TransactionTypeTransfer} {
+		transactions, err := c.TransactionsByType(ctx, transactionType)
+		if err != nil {
+			return nil, err
+		}
+		counts[transactionType] = len(transactions)
+	}
+	return counts, nil
+}
+
+// GetTransactionsByType retrieves the transactions of a type
+func (s *MemoryStorage) GetTransactionsByType(ctx context.Context, transactionType TransactionType) ([]*Transaction, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	var result []*Transaction
+	for _, transaction := range s.transactions {
+		if transaction.Type.OrDefault() == transactionType {
+			// Create a copy to prevent external modifications
+			transactionCopy := *transaction
+			result = append(result, &transactionCopy)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,148 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransactionTypeDefaults(t *testing.T) {
+	tests := []struct {
+		transactionType TransactionType
+		valid           bool
+		orDefault       TransactionType
+	}{
+		{"", false, TransactionTypePayment},
+		{TransactionTypePayment, true, TransactionTypePayment},
+		{TransactionTypeRefund, true, TransactionTypeRefund},
+		{TransactionTypeSettlement, true, TransactionTypeSettlement},
+		{TransactionTypeTransfer, true, TransactionTypeTransfer},
+		{"Payment", false, "Payment"},
+		{"withdrawal", false, "withdrawal"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.transactionType.IsValid(); got != tt.valid {
+			t.Errorf("%q.IsValid() = %v, want %v", tt.transactionType, got, tt.valid)
+		}
+		if got := tt.transactionType.OrDefault(); got != tt.orDefault {
+			t.Errorf("%q.OrDefault() = %q, want %q", tt.transactionType, got, tt.orDefault)
+		}
+	}
+}
+
+// storeTypedTransactions stores a record from before transaction types existed
+// next to records of each type
+func storeTypedTransactions(t *testing.T, storage *MemoryStorage) {
+	t.Helper()
+
+	var legacy Transaction
+	if err := json.Unmarshal([]byte(`{"id":"tx-legacy","token":"sim00000000000000000","amount":100000,"status":"PAID"}`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Type != "" {
+		t.Fatalf("legacy record decoded with type %q", legacy.Type)
+	}
+
+	transactions := []*Transaction{&legacy}
+	for i, transactionType := range []TransactionType{TransactionTypePayment, TransactionTypeRefund, TransactionTypeRefund, TransactionTypeTransfer} {
+		transactions = append(transactions, &Transaction{
+			ID:     fmt.Sprintf("tx-%d", i+1),
+			Token:  fmt.Sprintf("sim%017d", i+1),
+			Amount: 100000,
+			Status: StatusPaid,
+			Type:   transactionType,
+		})
+	}
+	for _, transaction := range transactions {
+		transaction.CreatedAt = time.Now()
+		if err := storage.StoreTransaction(context.Background(), transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTransactionsByType(t *testing.T) {
+	want := map[TransactionType]int{
+		TransactionTypePayment:    2,
+		TransactionTypeRefund:     2,
+		TransactionTypeSettlement: 0,
+		TransactionTypeTransfer:   1,
+	}
+
+	// The storage lookup and the fallback over all statuses agree
+	for _, typed := range []bool{true, false} {
+		t.Run(fmt.Sprintf("type storage %v", typed), func(t *testing.T) {
+			client, storage, _ := newTestClient(t, testConfig(t), nil)
+			storeTypedTransactions(t, storage)
+			if !typed {
+				client.storage = unpatchableStorage{storage}
+			}
+
+			counts, err := client.CountTransactionsByType(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for transactionType, n := range want {
+				if counts[transactionType] != n {
+					t.Errorf("%d %s transactions, want %d", counts[transactionType], transactionType, n)
+				}
+			}
+
+			// Records without a type are payments
+			payments, err := client.TransactionsByType(context.Background(), TransactionTypePayment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			legacy := false
+			for _, transaction := range payments {
+				legacy = legacy || transaction.ID == "tx-legacy"
+			}
+			if !legacy {
+				t.Fatalf("legacy record missing from payments: %v", payments)
+			}
+
+			if _, err := client.TransactionsByType(context.Background(), "withdrawal"); !IsValidationError(err) {
+				t.Fatalf("unknown type: %v", err)
+			}
+		})
+	}
+}
+
+func TestTransactionTypeWrittenAndExposed(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1, "token": webhookToken}))
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) { c.AdminKey = "admin-key" }), transport)
+	storeTypedTransactions(t, storage)
+
+	// New payments are stored with their type
+	if _, err := client.InitiatePayment(context.Background(), 100000, "Order 1042", nil); err != nil {
+		t.Fatal(err)
+	}
+	if transaction, err := storage.GetTransaction(context.Background(), webhookToken); err != nil || transaction.Type != TransactionTypePayment {
+		t.Fatalf("stored %+v, %v", transaction, err)
+	}
+
+	// Admin responses show legacy records as payments
+	for token, want := range map[string]TransactionType{
+		"sim00000000000000000": TransactionTypePayment,
+		"sim00000000000000002": TransactionTypeRefund,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/payments/transactions/"+token, nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		req.Header.Set(AdminKeyHeader, "admin-key")
+		rec := httptest.NewRecorder()
+		client.Handler().ServeHTTP(rec, req)
+
+		var detail TransactionDetail
+		if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if detail.Type != want {
+			t.Errorf("%s shown as %q, want %q", token, detail.Type, want)
+		}
+	}
+}
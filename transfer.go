@@ -0,0 +1,332 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// transfer.go implements wallet-to-wallet (P2P) transfers through the business API
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxPaymentNumberLength is the maximum length of a transfer payment number
+const MaxPaymentNumberLength = 64
+
+// insufficientBalanceHints are fragments of gateway messages reporting a wallet
+// balance too low for the requested amount
+var insufficientBalanceHints = []string{"insufficient", "balance is not enough", "موجودی کافی"}
+
+// TransferRequest represents a request to transfer money from the business wallet
+// to another Vandar business wallet
+type TransferRequest struct {
+	// DestinationBusiness is the slug of the receiving business
+	DestinationBusiness string `json:"destination_business" validate:"required"`
+
+	// Amount is the amount to transfer in Rials
+	Amount int64 `json:"amount" validate:"min=MinAmount,max=MaxAmount" unit:"Rials"`
+
+	// PaymentNumber is the merchant's reference for the transfer, e.g. a payout ID (optional)
+	PaymentNumber string `json:"payment_number,omitempty" validate:"max=MaxPaymentNumberLength"`
+
+	// Description is shown on both wallets' statements (optional)
+	Description string `json:"description,omitempty" validate:"max=MaxDescriptionLength"`
+}
+
+// TransferResponse represents a response to a transfer request
+type TransferResponse struct {
+	// Status indicates if the transfer was successful
+	Status bool `json:"status"`
+
+	// TransferID is the gateway's identifier of the transfer
+	TransferID string `json:"transfer_id,omitempty"`
+
+	// Amount is the transferred amount
+	Amount FlexibleAmount `json:"amount,omitempty"`
+
+	// Balance is the available wallet balance after the transfer
+	Balance FlexibleAmount `json:"balance,omitempty"`
+
+	// BlockedBalance is the part of the wallet balance that can't be spent yet
+	BlockedBalance FlexibleAmount `json:"blocked_balance,omitempty"`
+
+	// Message contains any message from the API
+	Message string `json:"message,omitempty"`
+
+	// Errors contains any error messages
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Transfer is the local record of a wallet transfer
+type Transfer struct {
+	// ID is the gateway's transfer identifier
+	ID string `json:"id"`
+
+	// DestinationBusiness is the slug of the receiving business
+	DestinationBusiness string `json:"destination_business"`
+
+	// Amount is the transferred amount in Rials
+	Amount int64 `json:"amount"`
+
+	// PaymentNumber is the merchant's reference for the transfer
+	PaymentNumber string `json:"payment_number,omitempty"`
+
+	// Description is the transfer description
+	Description string `json:"description,omitempty"`
+
+	// BalanceAfter is the wallet balance reported after the transfer
+	BalanceAfter int64 `json:"balance_after,omitempty"`
+
+	// CreatedAt is when the transfer was made
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TransferStorageInterface is implemented by storages that can keep transfer records;
+// transfers are only recorded when the client's storage implements it
+type TransferStorageInterface interface {
+	// StoreTransfer saves a new transfer
+	StoreTransfer(ctx context.Context, transfer *Transfer) error
+
+	// GetTransfer retrieves a transfer by ID
+	GetTransfer(ctx context.Context, id string) (*Transfer, error)
+}
+
+// ValidateTransferRequest validates a transfer request
+func (v *Validator) ValidateTransferRequest(req *TransferRequest) error {
+	// A destination of only whitespace counts as missing
+	trimmed := *req
+	trimmed.DestinationBusiness = strings.TrimSpace(trimmed.DestinationBusiness)
+
+	return v.validateStruct(&trimmed)
+}
+
+// ValidateTransferRequest validates a transfer request with the default patterns
+func ValidateTransferRequest(req *TransferRequest) error {
+	return defaultValidator.ValidateTransferRequest(req)
+}
+
+// TransferToWallet transfers money from the business wallet to another business's
+// wallet. A wallet without enough balance returns ErrInsufficientBalance.
+func (c *Client) TransferToWallet(ctx context.Context, req TransferRequest) (*TransferResponse, error) {
+	// Validate request
+	req.DestinationBusiness = strings.TrimSpace(req.DestinationBusiness)
+	req.Description = SanitizeInput(req.Description)
+	if err := c.validator().ValidateTransferRequest(&req); err != nil {
+		return nil, err
+	}
+	if req.DestinationBusiness == configValues(c.config).Business {
+		return nil, NewValidationError("destination_business", "cannot transfer to the own business")
+	}
+
+	// The transfer and its local record must not be cut apart by a shutdown
+	ctx, done, err := c.beginOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	ctx, cancel := c.operationContext(ctx, operationInit)
+	defer cancel()
+
+	// Prepare API request body
+	apiReq := map[string]interface{}{
+		"destination": req.DestinationBusiness,
+		"amount":      req.Amount,
+	}
+
+	if req.PaymentNumber != "" {
+		apiReq["payment_number"] = req.PaymentNumber
+	}
+
+	if req.Description != "" {
+		apiReq["description"] = req.Description
+	}
+
+	// Make API request
+	respBody, _, err := c.makeRequest(ctx, http.MethodPost, c.transferEndpoint(), apiReq)
+	if err != nil {
+		if isInsufficientBalance(err) {
+			return nil, fmt.Errorf("%w: %v", ErrInsufficientBalance, err)
+		}
+		return nil, fmt.Errorf("failed to transfer to wallet: %w", err)
+	}
+
+	// Parse API response
+	var apiResp TransferResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		return nil, fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	// Check if the transfer was successful
+	if !apiResp.Status {
+		if containsInsufficientBalanceHint(apiResp.Message) {
+			return &apiResp, fmt.Errorf("%w: %s", ErrInsufficientBalance, apiResp.Message)
+		}
+		return &apiResp, fmt.Errorf("%w: %s", ErrTransferFailed, apiResp.Message)
+	}
+
+	c.recordTransfer(context.WithoutCancel(ctx), &req, &apiResp)
+
+	c.log(ctx).Info(ctx, "Wallet transfer completed", map[string]interface{}{
+		"transfer_id":          apiResp.TransferID,
+		"destination_business": req.DestinationBusiness,
+		"amount":               req.Amount,
+		"payment_number":       req.PaymentNumber,
+	})
+
+	return &apiResp, nil
+}
+
+// recordTransfer stores a completed transfer when the storage supports it
+func (c *Client) recordTransfer(ctx context.Context, req *TransferRequest, resp *TransferResponse) {
+	storage, ok := c.storage.(TransferStorageInterface)
+	if !ok {
+		return
+	}
+
+	if resp.TransferID == "" {
+		c.log(ctx).Warn(ctx, "Transfer completed without a transfer ID, not recording it", map[string]interface{}{
+			"destination_business": req.DestinationBusiness,
+			"payment_number":       req.PaymentNumber,
+		})
+		return
+	}
+
+	transfer := &Transfer{
+		ID:                  resp.TransferID,
+		DestinationBusiness: req.DestinationBusiness,
+		Amount:              req.Amount,
+		PaymentNumber:       req.PaymentNumber,
+		Description:         req.Description,
+		BalanceAfter:        resp.Balance.Int64(),
+		CreatedAt:           c.clock.Now(),
+	}
+
+	if err := storage.StoreTransfer(ctx, transfer); err != nil {
+		c.log(ctx).Error(ctx, "Failed to store transfer", err, map[string]interface{}{
+			"transfer_id": transfer.ID,
+		})
+	}
+}
+
+// isInsufficientBalance reports whether a failed gateway call was rejected for a
+// wallet balance too low for the amount
+func isInsufficientBalance(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	if containsInsufficientBalanceHint(apiErr.Code) || containsInsufficientBalanceHint(apiErr.Message) {
+		return true
+	}
+	for _, message := range apiErr.Errors {
+		if containsInsufficientBalanceHint(message) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsInsufficientBalanceHint reports whether a message mentions an insufficient balance
+func containsInsufficientBalanceHint(message string) bool {
+	message = strings.ToLower(message)
+	for _, hint := range insufficientBalanceHints {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleTransfer handles wallet transfer requests
+func (c *Client) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Parse request body
+	var req TransferRequest
+	if err := parseJSONBody(r, &req); err != nil {
+		c.respondInvalid(w, err)
+		return
+	}
+
+	// Don't move money for a caller that gave up
+	if c.abandoned(ctx, "transfer") {
+		return
+	}
+
+	resp, err := c.TransferToWallet(ctx, req)
+	switch {
+	case err == nil:
+		c.respondWithJSON(w, http.StatusOK, resp)
+	case IsValidationError(err):
+		c.respondInvalid(w, err)
+	case errors.Is(err, ErrInsufficientBalance):
+		c.respondWithError(w, ErrInsufficientBalance, "Insufficient wallet balance")
+	case errors.Is(err, ErrTransferFailed):
+		c.respondWithError(w, ErrTransferFailed, resp.Message)
+	case IsDomainError(err):
+		c.respondError(w, err)
+	default:
+		c.respondWithError(w, upstreamError(err), "Failed to transfer to wallet")
+		c.log(ctx).Error(ctx, "Failed to transfer to wallet", err, map[string]interface{}{
+			"destination_business": req.DestinationBusiness,
+			"amount":               req.Amount,
+		})
+	}
+}
+
+// StoreTransfer saves a new transfer
+func (s *MemoryStorage) StoreTransfer(ctx context.Context, transfer *Transfer) error {
+	if transfer == nil {
+		return fmt.Errorf("transfer cannot be nil")
+	}
+
+	if transfer.ID == "" {
+		return fmt.Errorf("transfer ID cannot be empty")
+	}
+
+	if err := s.enter(ctx); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.transfers[transfer.ID]; exists {
+		return fmt.Errorf("transfer already exists: %s", transfer.ID)
+	}
+
+	transferCopy := *transfer
+	s.transfers[transfer.ID] = &transferCopy
+
+	return nil
+}
+
+// GetTransfer retrieves a transfer by ID
+func (s *MemoryStorage) GetTransfer(ctx context.Context, id string) (*Transfer, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	transfer, exists := s.transfers[id]
+	if !exists {
+		return nil, fmt.Errorf("transfer not found: %s", id)
+	}
+
+	transferCopy := *transfer
+	return &transferCopy, nil
+}
@@ -0,0 +1,190 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// transferClient returns a client of the "shop" business
+func transferClient(t *testing.T, transport HTTPClientInterface, opts ...ClientOption) (*Client, *MemoryStorage, *captureLogger) {
+	t.Helper()
+
+	return newTestClient(t, testConfig(t, func(c *Config) {
+		c.Business = "shop"
+		c.AdminKey = "admin-key"
+		c.MaxRetries = 0
+	}), transport, opts...)
+}
+
+func TestTransferValidation(t *testing.T) {
+	transport := newStubTransport()
+	client, _, _ := transferClient(t, transport)
+
+	tests := []struct {
+		req   TransferRequest
+		field string
+	}{
+		{TransferRequest{Amount: 100000}, "destination_business"},
+		{TransferRequest{DestinationBusiness: "   ", Amount: 100000}, "destination_business"},
+		{TransferRequest{DestinationBusiness: "partner", Amount: MinAmount - 1}, "amount"},
+		{TransferRequest{DestinationBusiness: "partner", Amount: MaxAmount + 1}, "amount"},
+		{TransferRequest{DestinationBusiness: "partner", Amount: 100000, PaymentNumber: strings.Repeat("1", MaxPaymentNumberLength+1)}, "payment_number"},
+		{TransferRequest{DestinationBusiness: " shop ", Amount: 100000}, "destination_business"},
+	}
+	for _, tt := range tests {
+		_, err := client.TransferToWallet(context.Background(), tt.req)
+		errs := ExtractValidationErrors(err)
+		if len(errs) != 1 || errs[0].Field != tt.field {
+			t.Fatalf("%+v: %v", tt.req, errs)
+		}
+	}
+
+	if transport.count() != 0 {
+		t.Fatalf("%d gateway calls for invalid transfers", transport.count())
+	}
+}
+
+func TestTransferToWallet(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+		"status":          true,
+		"transfer_id":     "transfer-7",
+		"amount":          "2500000",
+		"balance":         7500000,
+		"blocked_balance": 0,
+	}))
+	client, storage, _ := transferClient(t, transport, WithClientClock(clock))
+
+	resp, err := client.TransferToWallet(context.Background(), TransferRequest{
+		DestinationBusiness: " partner ",
+		Amount:              2500000,
+		PaymentNumber:       "payout-12",
+		Description:         "Commission March",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TransferID != "transfer-7" || resp.Balance.Int64() != 7500000 {
+		t.Fatalf("response %+v", resp)
+	}
+
+	req, body := transport.request(0)
+	if req.URL.Path != "/v3/business/shop/p2p" {
+		t.Fatalf("path %s", req.URL.Path)
+	}
+	var sent map[string]interface{}
+	if err := json.Unmarshal(body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent["destination"] != "partner" || sent["amount"] != float64(2500000) || sent["payment_number"] != "payout-12" || sent["description"] != "Commission March" {
+		t.Fatalf("sent %v", sent)
+	}
+
+	transfer, err := storage.GetTransfer(context.Background(), "transfer-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Transfer{
+		ID:                  "transfer-7",
+		DestinationBusiness: "partner",
+		Amount:              2500000,
+		PaymentNumber:       "payout-12",
+		Description:         "Commission March",
+		BalanceAfter:        7500000,
+		CreatedAt:           clock.Now(),
+	}
+	if *transfer != want {
+		t.Fatalf("recorded %+v", transfer)
+	}
+}
+
+func TestTransferWithoutIDNotRecorded(t *testing.T) {
+	client, storage, logger := transferClient(t, newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": true})))
+
+	if _, err := client.TransferToWallet(context.Background(), TransferRequest{DestinationBusiness: "partner", Amount: 100000}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.GetTransfer(context.Background(), ""); err == nil {
+		t.Fatal("transfer without an ID recorded")
+	}
+	if entry, found := logger.find("Transfer completed without a transfer ID, not recording it"); !found || entry.level != "warn" {
+		t.Fatalf("missing transfer ID not logged:\n%s", logger.dump())
+	}
+}
+
+func TestTransferErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		step stubStep
+		want error
+	}{
+		{"rejected for the balance", jsonStep(http.StatusUnprocessableEntity, map[string]interface{}{"status": false, "message": "Insufficient balance"}), ErrInsufficientBalance},
+		{"balance field error", jsonStep(http.StatusUnprocessableEntity, map[string]interface{}{"status": false, "message": "invalid data", "errors": map[string]string{"amount": "موجودی کافی نیست"}}), ErrInsufficientBalance},
+		{"declined for the balance", jsonStep(http.StatusOK, map[string]interface{}{"status": false, "message": "Wallet balance is not enough"}), ErrInsufficientBalance},
+		{"declined", jsonStep(http.StatusOK, map[string]interface{}{"status": false, "message": "destination not found"}), ErrTransferFailed},
+	}
+
+	for _, tt := range tests {
+		client, storage, _ := transferClient(t, newStubTransport(tt.step))
+
+		_, err := client.TransferToWallet(context.Background(), TransferRequest{DestinationBusiness: "partner", Amount: 100000})
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+		if len(storage.transfers) != 0 {
+			t.Fatalf("%s: failed transfer recorded", tt.name)
+		}
+	}
+}
+
+func TestTransferRoute(t *testing.T) {
+	transfer := func(handler http.Handler, adminKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, transferPath, strings.NewReader(`{"destination_business":"partner","amount":100000}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		if adminKey != "" {
+			req.Header.Set(AdminKeyHeader, adminKey)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	transport := newStubTransport(
+		jsonStep(http.StatusUnprocessableEntity, map[string]interface{}{"status": false, "message": "Insufficient balance"}),
+		jsonStep(http.StatusOK, map[string]interface{}{"status": true, "transfer_id": "transfer-7"}),
+	)
+	client, _, _ := transferClient(t, transport)
+
+	// The route is only served when enabled, and only to admins
+	if rec := transfer(client.Handler(), "admin-key"); rec.Code == http.StatusOK {
+		t.Fatalf("transfer route served without being enabled: %s", rec.Body)
+	}
+	handler := client.Handler(WithTransferRoute())
+	if rec := transfer(handler, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("without the admin key: status %d", rec.Code)
+	}
+	if rec := transfer(handler, "wrong-key"); rec.Code != http.StatusForbidden {
+		t.Fatalf("wrong admin key: status %d", rec.Code)
+	}
+	if transport.count() != 0 {
+		t.Fatalf("%d gateway calls for rejected callers", transport.count())
+	}
+
+	rec := transfer(handler, "admin-key")
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Insufficient wallet balance") {
+		t.Fatalf("insufficient balance: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = transfer(handler, "admin-key")
+	var resp TransferResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK || resp.TransferID != "transfer-7" {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
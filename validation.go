@@ -5,9 +5,12 @@ package vandargo
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
 )
 
 // Constants for validation
@@ -26,130 +29,201 @@ const (
 )
 
 var (
-	// Regular expressions for validation
-	cardNumberRegex = regexp.MustCompile(`^[0-9]{16}$`)
-	mobileRegex     = regexp.MustCompile(`^09[0-9]{9}$`)
-	emailRegex      = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	ibanRegex       = regexp.MustCompile(`^IR[0-9]{24}$`)
-	urlRegex        = regexp.MustCompile(`^https?://[a-zA-Z0-9][-a-zA-Z0-9_.]+\.[a-zA-Z0-9][-a-zA-Z0-9_]+(/[-a-zA-Z0-9_%$.~#&=]*)?$`)
+	// Regular expressions for validation, compiled when first used
+	cardNumberRegex = newLazyRegexp(`^[0-9]{16}$`)
+	mobileRegex     = newLazyRegexp(`^09[0-9]{9}$`)
+	nationalIDRegex = newLazyRegexp(`^[0-9]{10}$`)
+	emailRegex      = newLazyRegexp(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+	ibanRegex       = newLazyRegexp(`^IR[0-9]{24}$`)
 )
 
-// ValidatePaymentInitRequest validates a payment initialization request
-func ValidatePaymentInitRequest(req *PaymentInitRequest) error {
-	var errors ValidationErrors
-
-	// Validate amount
-	if req.Amount < MinAmount {
-		errors = append(errors, ValidationError{
-			Field:   "amount",
-			Message: fmt.Sprintf("amount must be at least %d Rials", MinAmount),
-		})
-	}
-
-	if req.Amount > MaxAmount {
-		errors = append(errors, ValidationError{
-			Field:   "amount",
-			Message: fmt.Sprintf("amount must be at most %d Rials", MaxAmount),
-		})
-	}
-
-	// Validate callback URL
-	if req.CallbackURL == "" {
-		errors = append(errors, ValidationError{
-			Field:   "callback_url",
-			Message: "callback URL is required",
-		})
-	} else if !urlRegex.MatchString(req.CallbackURL) {
-		errors = append(errors, ValidationError{
-			Field:   "callback_url",
-			Message: "callback URL must be a valid HTTP(S) URL",
-		})
-	}
-
-	// Validate description (optional)
-	if len(req.Description) > MaxDescriptionLength {
-		errors = append(errors, ValidationError{
-			Field:   "description",
-			Message: fmt.Sprintf("description must be at most %d characters", MaxDescriptionLength),
-		})
-	}
-
-	// Validate mobile (optional)
-	if req.Mobile != "" && !mobileRegex.MatchString(req.Mobile) {
-		errors = append(errors, ValidationError{
-			Field:   "mobile",
-			Message: "mobile must be a valid Iranian mobile number (e.g., 09123456789)",
-		})
-	}
-
-	// Validate valid card number (optional)
-	if req.ValidCardNumber != "" {
-		cleanCard := sanitizeCardNumber(req.ValidCardNumber)
-		if !cardNumberRegex.MatchString(cleanCard) {
-			errors = append(errors, ValidationError{
-				Field:   "valid_card_number",
-				Message: "valid card number must be a 16-digit number",
-			})
-		}
-	}
+// lazyRegexp is a regular expression compiled on first use, so importing the
+// package doesn't pay for validation it may never do
+type lazyRegexp struct {
+	pattern  string
+	once     sync.Once
+	compiled *regexp.Regexp
+}
+
+// newLazyRegexp creates a lazily compiled regular expression; the pattern must compile
+func newLazyRegexp(pattern string) *lazyRegexp {
+	return &lazyRegexp{pattern: pattern}
+}
+
+// compile compiles the expression unless done before
+func (r *lazyRegexp) compile() {
+	r.once.Do(func() {
+		r.compiled = regexp.MustCompile(r.pattern)
+	})
+}
+
+// MatchString reports whether s matches the expression
+func (r *lazyRegexp) MatchString(s string) bool {
+	r.compile()
+	return r.compiled.MatchString(s)
+}
 
-	if len(errors) > 0 {
-		return errors
+// compileValidationPatterns compiles the validation expressions ahead of the first request
+func compileValidationPatterns() {
+	for _, pattern := range []*lazyRegexp{cardNumberRegex, mobileRegex, nationalIDRegex, emailRegex, ibanRegex} {
+		pattern.compile()
 	}
+}
 
-	return nil
+// stringMatcher is a compiled or lazily compiled regular expression
+type stringMatcher interface {
+	MatchString(s string) bool
 }
 
-// ValidatePaymentVerifyRequest validates a payment verification request
-func ValidatePaymentVerifyRequest(req *PaymentVerifyRequest) error {
-	if req.Token == "" {
-		return NewValidationError("token", "token is required")
+// Validator validates requests. Its mobile number and card number patterns can
+// be replaced, e.g. to accept 19-digit cards; the zero value uses the defaults.
+// It is safe for concurrent use, and can be given to a client with
+// WithClientValidator.
+type Validator struct {
+	mutex  sync.RWMutex
+	mobile *regexp.Regexp
+	card   *regexp.Regexp
+}
+
+// defaultValidator backs the package-level validation functions
+var defaultValidator = NewValidator()
+
+// NewValidator creates a validator with the default patterns
+func NewValidator() *Validator {
+	return &Validator{}
+}
+
+// SetMobilePattern replaces the pattern mobile numbers must match, such as
+// `^09[0-9]{9}$`. The pattern must compile and be anchored with ^ and $.
+func (v *Validator) SetMobilePattern(pattern string) error {
+	compiled, err := compileAnchoredPattern(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid mobile pattern: %w", err)
 	}
 
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.mobile = compiled
 	return nil
 }
 
-// ValidatePaymentStatusRequest validates a payment status request
-func ValidatePaymentStatusRequest(req *PaymentStatusRequest) error {
-	if req.Token == "" {
-		return NewValidationError("token", "token is required")
+// SetCardPattern replaces the pattern card numbers must match once spaces and
+// dashes are removed, such as `^[0-9]{16}([0-9]{3})?$`. The pattern must compile
+// and be anchored with ^ and $.
+func (v *Validator) SetCardPattern(pattern string) error {
+	compiled, err := compileAnchoredPattern(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid card pattern: %w", err)
 	}
 
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.card = compiled
 	return nil
 }
 
-// ValidateRefundRequest validates a refund request
-func ValidateRefundRequest(req *RefundRequest) error {
-	var errors ValidationErrors
+// compileAnchoredPattern compiles a pattern that must match whole values
+func compileAnchoredPattern(pattern string) (*regexp.Regexp, error) {
+	if !strings.HasPrefix(pattern, "^") || !strings.HasSuffix(pattern, "$") {
+		return nil, errors.New("pattern must be anchored with ^ and $")
+	}
+	return regexp.Compile(pattern)
+}
+
+// mobilePattern returns the mobile number pattern and whether it was replaced
+func (v *Validator) mobilePattern() (stringMatcher, bool) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
 
-	if req.TransactionID == "" {
-		errors = append(errors, ValidationError{
-			Field:   "transaction_id",
-			Message: "transaction ID is required",
-		})
+	if v.mobile != nil {
+		return v.mobile, true
 	}
+	return mobileRegex, false
+}
+
+// cardPattern returns the card number pattern and whether it was replaced
+func (v *Validator) cardPattern() (stringMatcher, bool) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
 
-	if req.Amount < 0 {
-		errors = append(errors, ValidationError{
-			Field:   "amount",
-			Message: "amount must be a positive number",
-		})
+	if v.card != nil {
+		return v.card, true
 	}
+	return cardNumberRegex, false
+}
 
-	if len(errors) > 0 {
-		return errors
+// validator returns the request validator of the client
+func (c *Client) validator() *Validator {
+	if c.requestValidator != nil {
+		return c.requestValidator
 	}
+	return defaultValidator
+}
 
-	return nil
+// ValidatePaymentInitRequest validates a payment initialization request
+func (v *Validator) ValidatePaymentInitRequest(req *PaymentInitRequest) error {
+	return v.validateStruct(req)
+}
+
+// ValidatePaymentVerifyRequest validates a payment verification request
+func (v *Validator) ValidatePaymentVerifyRequest(req *PaymentVerifyRequest) error {
+	return singleValidationError(v.validateStruct(req))
+}
+
+// ValidatePaymentStatusRequest validates a payment status request
+func (v *Validator) ValidatePaymentStatusRequest(req *PaymentStatusRequest) error {
+	return singleValidationError(v.validateStruct(req))
+}
+
+// ValidateRefundRequest validates a refund request
+func (v *Validator) ValidateRefundRequest(req *RefundRequest) error {
+	return v.validateStruct(req)
 }
 
 // ValidateCallbackData validates data received in a callback
+func (v *Validator) ValidateCallbackData(data *CallbackData) error {
+	return singleValidationError(v.validateStruct(data))
+}
+
+// ValidatePaymentInitRequest validates a payment initialization request with
+// the default patterns
+func ValidatePaymentInitRequest(req *PaymentInitRequest) error {
+	return defaultValidator.ValidatePaymentInitRequest(req)
+}
+
+// ValidatePaymentVerifyRequest validates a payment verification request with
+// the default patterns
+func ValidatePaymentVerifyRequest(req *PaymentVerifyRequest) error {
+	return defaultValidator.ValidatePaymentVerifyRequest(req)
+}
+
+// ValidatePaymentStatusRequest validates a payment status request with the
+// default patterns
+func ValidatePaymentStatusRequest(req *PaymentStatusRequest) error {
+	return defaultValidator.ValidatePaymentStatusRequest(req)
+}
+
+// ValidateRefundRequest validates a refund request with the default patterns
+func ValidateRefundRequest(req *RefundRequest) error {
+	return defaultValidator.ValidateRefundRequest(req)
+}
+
+// ValidateCallbackData validates data received in a callback with the default
+// patterns
 func ValidateCallbackData(data *CallbackData) error {
-	if data.Token == "" {
-		return NewValidationError("token", "token is required")
-	}
+	return defaultValidator.ValidateCallbackData(data)
+}
 
-	return nil
+// singleValidationError reduces the result of validating a request with a single
+// checked field to the *ValidationError those requests have always returned
+func singleValidationError(err error) error {
+	var errs ValidationErrors
+	if errors.As(err, &errs) && len(errs) == 1 {
+		return &errs[0]
+	}
+	return err
 }
 
 // ValidateIBAN validates an IBAN (International Bank Account Number)
@@ -161,11 +235,27 @@ func ValidateIBAN(iban string) error {
 	return nil
 }
 
-// SanitizeInput sanitizes a string input to prevent injection attacks
+// isHTTPURL reports whether s is an absolute http or https URL with a host. Paths
+// of any depth, query strings and ports are accepted.
+func isHTTPURL(s string) bool {
+	parsed, err := url.Parse(s)
+	if err != nil || parsed.Hostname() == "" {
+		return false
+	}
+	return strings.EqualFold(parsed.Scheme, "http") || strings.EqualFold(parsed.Scheme, "https")
+}
+
+// zeroWidthNonJoiner is a format character Persian text needs between letters
+const zeroWidthNonJoiner = '\u200c'
+
+// SanitizeInput sanitizes a string input to prevent injection attacks. Control
+// characters and invisible format characters, such as bidi overrides and
+// zero-width spaces that can disguise text in logs, are removed; the zero-width
+// non-joiner used in Persian words is kept.
 func SanitizeInput(input string) string {
-	// Remove any control characters
+	// Remove control and format characters
 	sanitized := strings.Map(func(r rune) rune {
-		if r < 32 || r == 127 {
+		if unicode.IsControl(r) || (unicode.Is(unicode.Cf, r) && r != zeroWidthNonJoiner) {
 			return -1
 		}
 		return r
@@ -177,16 +267,17 @@ func SanitizeInput(input string) string {
 	return sanitized
 }
 
-// ValidateAmount validates that a string represents a valid amount
+// ValidateAmount validates that a string represents a valid amount. Separators
+// such as commas are ignored; negative amounts are rejected.
 func ValidateAmount(amount string) (int64, error) {
-	// Remove any non-digit characters (like commas)
-	cleanAmount := ""
-	for _, r := range amount {
-		if r >= '0' && r <= '9' {
-			cleanAmount += string(r)
-		}
+	// A minus sign must not be dropped along with the separators
+	if strings.ContainsAny(amount, "-\u2212") {
+		return 0, errors.New("amount cannot be negative")
 	}
 
+	// Remove any non-digit characters (like commas)
+	cleanAmount := keepDigits(amount)
+
 	// Convert to int64
 	amountInt, err := strconv.ParseInt(cleanAmount, 10, 64)
 	if err != nil {
@@ -204,3 +295,41 @@ func ValidateAmount(amount string) (int64, error) {
 
 	return amountInt, nil
 }
+
+// ValidateNationalCode checks the format and check digit of an Iranian national code
+func ValidateNationalCode(code string) bool {
+	if !nationalIDRegex.MatchString(code) {
+		return false
+	}
+
+	// Codes made of a single repeated digit pass the checksum but are never issued
+	if strings.Count(code, code[:1]) == len(code) {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(code[i]-'0') * (10 - i)
+	}
+
+	remainder := sum % 11
+	check := int(code[9] - '0')
+	if remainder < 2 {
+		return check == remainder
+	}
+	return check == 11-remainder
+}
+
+// keepDigits returns the ASCII digits of a string
+func keepDigits(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
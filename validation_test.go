@@ -0,0 +1,268 @@
+package vandargo
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestSanitizeInput(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"  Order 1042  ", "Order 1042"},
+		{"Order\x00 1042\r\n", "Order 1042"},
+		{"pay\u202emoc.evil", "paymoc.evil"},
+		{"zero\u200bwidth\ufeff", "zerowidth"},
+		{"می\u200cخواهم", "می\u200cخواهم"},
+		{"\t\u200b\n", ""},
+	}
+
+	for _, tt := range tests {
+		if got := SanitizeInput(tt.input); got != tt.want {
+			t.Errorf("SanitizeInput(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAmount(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+		ok    bool
+	}{
+		{"100000", 100000, true},
+		{"1,000,000", 1000000, true},
+		{" 10000 ", 10000, true},
+		{"5000000000", MaxAmount, true},
+		{"-5000", 0, false},
+		{"-100000", 0, false},
+		{"100000-", 0, false},
+		{"\u2212100000", 0, false},
+		{"9999", 0, false},
+		{"5000000001", 0, false},
+		{"99999999999999999999", 0, false},
+		{"", 0, false},
+		{"abc", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ValidateAmount(tt.input)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ValidateAmount(%q) = %d, %v; want %d, ok %v", tt.input, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestValidatorPatterns(t *testing.T) {
+	validator := NewValidator()
+	for _, pattern := range []string{`[0-9]{16}`, `^[0-9]{16}`, `^[0-9$`} {
+		if err := validator.SetCardPattern(pattern); err == nil {
+			t.Errorf("card pattern %q accepted", pattern)
+		}
+		if err := validator.SetMobilePattern(pattern); err == nil {
+			t.Errorf("mobile pattern %q accepted", pattern)
+		}
+	}
+
+	longCard := &PaymentInitRequest{Amount: 100000, CallbackURL: "https://shop.example.com/callback", ValidCardNumber: "6037-9912-3456-7890-123"}
+	if err := validator.ValidatePaymentInitRequest(longCard); err == nil {
+		t.Fatal("19-digit card accepted by the default pattern")
+	}
+	if err := validator.SetCardPattern(`^[0-9]{16}([0-9]{3})?$`); err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.ValidatePaymentInitRequest(longCard); err != nil {
+		t.Fatalf("19-digit card rejected: %v", err)
+	}
+
+	// The package-level functions keep the default patterns
+	if err := ValidatePaymentInitRequest(longCard); err == nil {
+		t.Fatal("customized pattern leaked into the default validator")
+	}
+}
+
+func TestClientValidatorInHandler(t *testing.T) {
+	validator := NewValidator()
+	if err := validator.SetMobilePattern(`^(09|\+989)[0-9]{9}$`); err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.SetCardPattern(`^[0-9]{16}([0-9]{3})?$`); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"amount":100000,"callback_url":"https://shop.example.com/callback","description":"Order 1042","mobile":"+989123456789","valid_card_number":"6037991234567890123"}`
+	tests := []struct {
+		name   string
+		opts   []ClientOption
+		status int
+	}{
+		{"default patterns", nil, http.StatusUnprocessableEntity},
+		{"custom patterns", []ClientOption{WithClientValidator(validator)}, http.StatusOK},
+		{"reset", []ClientOption{WithClientValidator(validator), WithClientValidator(nil)}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(), tt.opts...)
+			rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/init", body)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK && (!strings.Contains(rec.Body.String(), `"mobile"`) || !strings.Contains(rec.Body.String(), `"valid_card_number"`)) {
+				t.Fatalf("errors don't name both fields: %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestCardOwnerMatchValidation(t *testing.T) {
+	req := &PaymentInitRequest{
+		Amount:                100000,
+		CallbackURL:           "https://shop.example.com/callback",
+		RequireCardOwnerMatch: true,
+	}
+
+	// Both missing fields are reported, each on its own
+	var errs ValidationErrors
+	if err := ValidatePaymentInitRequest(req); !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+	if len(errs) != 2 || errs[0].Field != "mobile" || errs[1].Field != "national_code" {
+		t.Fatalf("errors %+v", errs)
+	}
+
+	req.Mobile = "09123456789"
+	req.NationalCode = "0012345670"
+	if err := ValidatePaymentInitRequest(req); !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "national_code" {
+		t.Fatalf("bad check digit: %v", err)
+	}
+
+	req.NationalCode = "0012345679"
+	if err := ValidatePaymentInitRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the match neither field is required
+	if err := ValidatePaymentInitRequest(&PaymentInitRequest{Amount: 100000, CallbackURL: "https://shop.example.com/callback"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidatorConcurrentUse(t *testing.T) {
+	validator := NewValidator()
+	req := &PaymentInitRequest{Amount: 100000, CallbackURL: "https://shop.example.com/callback", Mobile: "09123456789"}
+
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 100; j++ {
+				if i%2 == 0 {
+					validator.SetMobilePattern(`^09[0-9]{9}$`)
+					continue
+				}
+				if err := validator.ValidatePaymentInitRequest(req); err != nil {
+					t.Errorf("validation failed: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+}
+
+func FuzzSanitizeInput(f *testing.F) {
+	for _, seed := range []string{"", "  Order 1042  ", "a\x00b", "pay\u202emoc.evil", "می\u200cخواهم", "\xff\xfe", "  x "} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		out := SanitizeInput(input)
+
+		if utf8.RuneCountInString(out) > utf8.RuneCountInString(input) {
+			t.Fatalf("SanitizeInput(%q) grew to %q", input, out)
+		}
+		if again := SanitizeInput(out); again != out {
+			t.Fatalf("SanitizeInput is not idempotent: %q then %q", out, again)
+		}
+		if strings.TrimSpace(out) != out {
+			t.Fatalf("SanitizeInput(%q) = %q keeps surrounding space", input, out)
+		}
+		if utf8.ValidString(input) && !utf8.ValidString(out) {
+			t.Fatalf("SanitizeInput(%q) = %q is not valid UTF-8", input, out)
+		}
+		for _, r := range out {
+			if unicode.IsControl(r) || (unicode.Is(unicode.Cf, r) && r != zeroWidthNonJoiner) {
+				t.Fatalf("SanitizeInput(%q) = %q keeps %U", input, out, r)
+			}
+		}
+	})
+}
+
+func FuzzSanitizeCardNumber(f *testing.F) {
+	for _, seed := range []string{"", fullCardNumber, "6037-9912 3456-7890", "603799******1234", "۶۰۳۷", "\xff1\xfe2"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		out := sanitizeCardNumber(input)
+
+		// Exactly the ASCII digits of the input are kept, in order
+		want := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, input)
+		if out != want {
+			t.Fatalf("sanitizeCardNumber(%q) = %q, want %q", input, out, want)
+		}
+		if again := sanitizeCardNumber(out); again != out {
+			t.Fatalf("sanitizeCardNumber is not idempotent: %q then %q", out, again)
+		}
+
+		// Masking never reveals more than the last four digits
+		want = "****"
+		if len(out) >= 4 {
+			want = strings.Repeat("*", len(out)-4) + out[len(out)-4:]
+		}
+		if masked := MaskCardNumber(input); masked != want {
+			t.Fatalf("MaskCardNumber(%q) = %q, want %q", input, masked, want)
+		}
+	})
+}
+
+func FuzzValidateAmount(f *testing.F) {
+	for _, seed := range []string{"100000", "1,000,000", "-5000", "\u2212100000", "5000000001", "99999999999999999999", "", "1e9", "10000.5"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		amount, err := ValidateAmount(input)
+		if err != nil {
+			if amount != 0 {
+				t.Fatalf("ValidateAmount(%q) = %d with error %v", input, amount, err)
+			}
+			return
+		}
+
+		// Accepted amounts are in range, never negative and round-trip
+		if amount < MinAmount || amount > MaxAmount {
+			t.Fatalf("ValidateAmount(%q) = %d is out of range", input, amount)
+		}
+		if strings.ContainsAny(input, "-\u2212") {
+			t.Fatalf("ValidateAmount(%q) = %d dropped the sign", input, amount)
+		}
+		if again, err := ValidateAmount(strconv.FormatInt(amount, 10)); err != nil || again != amount {
+			t.Fatalf("ValidateAmount(%d) = %d, %v", amount, again, err)
+		}
+	})
+}
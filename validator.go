@@ -0,0 +1,367 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// validator.go implements validation of request structs driven by struct tags
+package vandargo
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Request structs declare their checks in a validate tag, a comma separated list
+// of rules applied in order until one fails:
+//
+//	Amount int64 `json:"amount" validate:"min=MinAmount,max=MaxAmount" unit:"Rials"`
+//
+// Rules other than required and required_if skip empty strings, so optional
+// fields are only checked when set. Errors name the field by its JSON name and
+// describe it by its label tag, which defaults to the JSON name with spaces.
+//
+// Built-in rules:
+//
+//	required          the string is not empty
+//	required_if=F     the string is not empty when the bool field F is true
+//	min=N, max=N      integer bounds, or the maximum length of a string
+//	nonnegative       the integer is not negative
+//	http_url          an HTTP(S) URL
+//	iran_mobile       an Iranian mobile number such as 09123456789, or the
+//	                  Validator's mobile pattern
+//	national_code     a valid Iranian national code
+//	card              a 16-digit card number, spaces and dashes allowed, or the
+//	                  Validator's card pattern
+//	iban              an Iranian IBAN
+
+// validationRule checks a field against a rule and returns the violation message
+// without the field label, or "" when the value is valid
+type validationRule func(check ruleCheck) string
+
+// ruleCheck is the field value a rule is applied to and its surroundings
+type ruleCheck struct {
+	validator *Validator
+	value     reflect.Value
+	rule      *fieldRule
+	field     *validatedField
+	parent    reflect.Value
+	fields    []validatedField
+}
+
+// validationConstants are the named limits rule parameters may refer to
+var validationConstants = map[string]int64{
+	"MinAmount":              MinAmount,
+	"MaxAmount":              MaxAmount,
+	"MaxDescriptionLength":   MaxDescriptionLength,
+	"MaxPaymentNumberLength": MaxPaymentNumberLength,
+}
+
+var (
+	validationRulesMutex sync.RWMutex
+	validationRules      = map[string]validationRule{}
+
+	// validatedTypes caches the parsed tags of each struct type as a *validatedType
+	validatedTypes sync.Map
+)
+
+// validatedType is a struct type's parsed validate tags, or the error in them
+type validatedType struct {
+	fields []validatedField
+	err    error
+}
+
+// validatedField is a struct field with its parsed validate tag
+type validatedField struct {
+	index int
+	name  string
+	label string
+	unit  string
+	rules []fieldRule
+}
+
+// fieldRule is one rule of a validate tag, resolved when the type is parsed
+type fieldRule struct {
+	name  string
+	param string
+	apply validationRule
+
+	// limit is the parsed parameter of min and max
+	limit int64
+
+	// target is the position in the type's fields of the field named by required_if
+	target int
+}
+
+func init() {
+	registerValidationRule("required", ruleRequired)
+	registerValidationRule("required_if", ruleRequiredIf)
+	registerValidationRule("min", ruleMin)
+	registerValidationRule("max", ruleMax)
+	registerValidationRule("nonnegative", ruleNonNegative)
+	registerValidationRule("http_url", stringRule(isHTTPURL, "must be a valid HTTP(S) URL"))
+	registerValidationRule("iran_mobile", ruleMobile)
+	registerValidationRule("national_code", stringRule(ValidateNationalCode, "must be a valid 10-digit Iranian national code"))
+	registerValidationRule("card", ruleCard)
+	registerValidationRule("iban", stringRule(func(s string) bool { return ibanRegex.MatchString(s) }, "must start with IR followed by 24 digits"))
+}
+
+// registerValidationRule makes a rule available to validate tags
+func registerValidationRule(name string, rule validationRule) {
+	validationRulesMutex.Lock()
+	defer validationRulesMutex.Unlock()
+
+	validationRules[name] = rule
+}
+
+// lookupValidationRule returns a registered rule
+func lookupValidationRule(name string) (validationRule, bool) {
+	validationRulesMutex.RLock()
+	defer validationRulesMutex.RUnlock()
+
+	rule, ok := validationRules[name]
+	return rule, ok
+}
+
+// validateStruct checks a struct, or a pointer to one, against its validate tags
+// with the default patterns
+func validateStruct(v interface{}) error {
+	return defaultValidator.validateStruct(v)
+}
+
+// validateStruct checks a struct, or a pointer to one, against its validate tags
+// and returns ValidationErrors listing every invalid field, or nil
+func (v *Validator) validateStruct(target interface{}) error {
+	value := reflect.ValueOf(target)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return NewValidationError("request", "request is required")
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("%w: cannot validate a %s", ErrInternalError, value.Kind())
+	}
+
+	fields, err := parseValidatedType(value.Type())
+	if err != nil {
+		return err
+	}
+
+	var errors ValidationErrors
+	for i := range fields {
+		field := &fields[i]
+		fieldValue := value.Field(field.index)
+
+		for j := range field.rules {
+			rule := &field.rules[j]
+			check := ruleCheck{validator: v, value: fieldValue, rule: rule, field: field, parent: value, fields: fields}
+			if message := rule.apply(check); message != "" {
+				errors = append(errors, ValidationError{
+					Field:   field.name,
+					Message: field.label + " " + message,
+				})
+				break
+			}
+		}
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+
+	return nil
+}
+
+// parseValidatedType parses and caches the validate tags of a struct type. Unknown
+// rules, limits that don't parse and required_if rules naming no bool field are
+// reported when the type is first parsed and on every later validation.
+func parseValidatedType(t reflect.Type) ([]validatedField, error) {
+	if cached, ok := validatedTypes.Load(t); ok {
+		parsed := cached.(*validatedType)
+		return parsed.fields, parsed.err
+	}
+
+	fields, err := parseValidateTags(t)
+	if err != nil {
+		err = fmt.Errorf("%w: %s: %w", ErrInternalError, t.Name(), err)
+	}
+
+	validatedTypes.Store(t, &validatedType{fields: fields, err: err})
+	return fields, err
+}
+
+// parseValidateTags parses the validate tags of a struct type and resolves their rules
+func parseValidateTags(t reflect.Type) ([]validatedField, error) {
+	var fields []validatedField
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+
+		name := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			name = structField.Name
+		}
+
+		label := structField.Tag.Get("label")
+		if label == "" {
+			label = strings.ReplaceAll(name, "_", " ")
+		}
+
+		field := validatedField{
+			index: i,
+			name:  name,
+			label: label,
+			unit:  structField.Tag.Get("unit"),
+		}
+
+		if tag := structField.Tag.Get("validate"); tag != "" {
+			for _, part := range strings.Split(tag, ",") {
+				ruleName, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+				apply, ok := lookupValidationRule(ruleName)
+				if !ok {
+					return nil, fmt.Errorf("unknown validation rule %q on field %s", ruleName, structField.Name)
+				}
+				field.rules = append(field.rules, fieldRule{name: ruleName, param: param, apply: apply})
+			}
+		}
+
+		fields = append(fields, field)
+	}
+
+	for i := range fields {
+		for j := range fields[i].rules {
+			if err := resolveRuleParam(t, fields, &fields[i].rules[j]); err != nil {
+				return nil, fmt.Errorf("field %s: %w", t.Field(fields[i].index).Name, err)
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// resolveRuleParam parses the parameter of a rule that takes one
+func resolveRuleParam(t reflect.Type, fields []validatedField, rule *fieldRule) error {
+	switch rule.name {
+	case "min", "max":
+		limit, err := validationLimit(rule.param)
+		if err != nil {
+			return err
+		}
+		rule.limit = limit
+	case "required_if":
+		for i := range fields {
+			target := t.Field(fields[i].index)
+			if target.Name != rule.param {
+				continue
+			}
+			if target.Type.Kind() != reflect.Bool {
+				return fmt.Errorf("required_if refers to %s, which is not a bool", rule.param)
+			}
+			rule.target = i
+			return nil
+		}
+		return fmt.Errorf("required_if refers to unknown field %q", rule.param)
+	}
+	return nil
+}
+
+// validationLimit parses a numeric rule parameter or the name of a validation constant
+func validationLimit(param string) (int64, error) {
+	if limit, ok := validationConstants[param]; ok {
+		return limit, nil
+	}
+
+	limit, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid validation limit %q", param)
+	}
+	return limit, nil
+}
+
+// withUnit appends the field's unit to a limit
+func withUnit(limit int64, field *validatedField) string {
+	if field.unit == "" {
+		return strconv.FormatInt(limit, 10)
+	}
+	return fmt.Sprintf("%d %s", limit, field.unit)
+}
+
+// stringRule builds a rule checking non-empty strings with a predicate
+func stringRule(valid func(string) bool, message string) validationRule {
+	return func(check ruleCheck) string {
+		value := check.value
+		if value.Kind() != reflect.String || value.String() == "" || valid(value.String()) {
+			return ""
+		}
+		return message
+	}
+}
+
+// ruleMobile checks mobile numbers against the validator's pattern
+func ruleMobile(check ruleCheck) string {
+	pattern, custom := check.validator.mobilePattern()
+	message := "must be a valid Iranian mobile number (e.g., 09123456789)"
+	if custom {
+		message = "must be a valid mobile number"
+	}
+	return stringRule(pattern.MatchString, message)(check)
+}
+
+// ruleCard checks card numbers, without spaces and dashes, against the
+// validator's pattern
+func ruleCard(check ruleCheck) string {
+	pattern, custom := check.validator.cardPattern()
+	message := "must be a 16-digit number"
+	if custom {
+		message = "must be a valid card number"
+	}
+	return stringRule(func(s string) bool { return pattern.MatchString(sanitizeCardNumber(s)) }, message)(check)
+}
+
+// ruleRequired rejects empty strings
+func ruleRequired(check ruleCheck) string {
+	if check.value.Kind() == reflect.String && check.value.String() == "" {
+		return "is required"
+	}
+	return ""
+}
+
+// ruleRequiredIf rejects empty strings when the named bool field is true
+func ruleRequiredIf(check ruleCheck) string {
+	other := &check.fields[check.rule.target]
+	if check.parent.Field(other.index).Bool() && check.value.String() == "" {
+		return "is required when " + other.label + " is required"
+	}
+	return ""
+}
+
+// ruleMin checks the lower bound of integers
+func ruleMin(check ruleCheck) string {
+	limit := check.rule.limit
+	if check.value.CanInt() && check.value.Int() < limit {
+		return "must be at least " + withUnit(limit, check.field)
+	}
+	return ""
+}
+
+// ruleMax checks the upper bound of integers and the length of strings
+func ruleMax(check ruleCheck) string {
+	limit := check.rule.limit
+	switch {
+	case check.value.CanInt() && check.value.Int() > limit:
+		return "must be at most " + withUnit(limit, check.field)
+	case check.value.Kind() == reflect.String && int64(len(check.value.String())) > limit:
+		return fmt.Sprintf("must be at most %d characters", limit)
+	default:
+		return ""
+	}
+}
+
+// ruleNonNegative rejects negative integers
+func ruleNonNegative(check ruleCheck) string {
+	if check.value.CanInt() && check.value.Int() < 0 {
+		return "must be a positive number"
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package vandargo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// referencePaymentInitErrors is the hand-written validation of payment
+// initializations the validate tags replaced
+func referencePaymentInitErrors(req *PaymentInitRequest) []ValidationError {
+	var errs []ValidationError
+	add := func(field, message string) {
+		errs = append(errs, ValidationError{Field: field, Message: message})
+	}
+
+	if req.Amount < MinAmount {
+		add("amount", fmt.Sprintf("amount must be at least %d Rials", MinAmount))
+	} else if req.Amount > MaxAmount {
+		add("amount", fmt.Sprintf("amount must be at most %d Rials", MaxAmount))
+	}
+
+	if req.CallbackURL == "" {
+		add("callback_url", "callback URL is required")
+	} else if !isHTTPURL(req.CallbackURL) {
+		add("callback_url", "callback URL must be a valid HTTP(S) URL")
+	}
+
+	if len(req.Description) > MaxDescriptionLength {
+		add("description", fmt.Sprintf("description must be at most %d characters", MaxDescriptionLength))
+	}
+
+	if req.Mobile != "" && !mobileRegex.MatchString(req.Mobile) {
+		add("mobile", "mobile must be a valid Iranian mobile number (e.g., 09123456789)")
+	} else if req.Mobile == "" && req.RequireCardOwnerMatch {
+		add("mobile", "mobile is required when card owner match is required")
+	}
+
+	if req.ValidCardNumber != "" && !cardNumberRegex.MatchString(sanitizeCardNumber(req.ValidCardNumber)) {
+		add("valid_card_number", "valid card number must be a 16-digit number")
+	}
+
+	if req.NationalCode != "" && !ValidateNationalCode(req.NationalCode) {
+		add("national_code", "national code must be a valid 10-digit Iranian national code")
+	} else if req.NationalCode == "" && req.RequireCardOwnerMatch {
+		add("national_code", "national code is required when card owner match is required")
+	}
+
+	return errs
+}
+
+// checkAgainstReference compares the tag validation of a request with the reference
+func checkAgainstReference(t *testing.T, req *PaymentInitRequest) {
+	t.Helper()
+
+	want := referencePaymentInitErrors(req)
+	got := ExtractValidationErrors(ValidatePaymentInitRequest(req))
+	if len(want) == 0 && len(got) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%+v:\n got  %v\n want %v", *req, got, want)
+	}
+}
+
+func TestPaymentInitValidationMatchesReference(t *testing.T) {
+	amounts := []int64{-1, 0, MinAmount - 1, MinAmount, 250000, MaxAmount, MaxAmount + 1}
+	callbacks := []string{"", "https://shop.example.com/callback", "http://shop.example.com", "ftp://shop.example.com", "shop.example.com/callback", "https://localhost"}
+	descriptions := []string{"", "Order #1", strings.Repeat("x", MaxDescriptionLength), strings.Repeat("x", MaxDescriptionLength+1)}
+	mobiles := []string{"", "09123456789", "9123456789", "0912345678", "+989123456789"}
+	cards := []string{"", "6037997012345678", "6037-9970-1234-5678", "6037 9970 1234 5678", "603799701234567"}
+	nationalCodes := []string{"", "0499370899", "0499370890", "123"}
+
+	for _, amount := range amounts {
+		for _, callback := range callbacks {
+			for _, description := range descriptions {
+				for _, mobile := range mobiles {
+					for _, card := range cards {
+						for _, nationalCode := range nationalCodes {
+							for _, ownerMatch := range []bool{false, true} {
+								checkAgainstReference(t, &PaymentInitRequest{
+									Amount:                amount,
+									CallbackURL:           callback,
+									Description:           description,
+									Mobile:                mobile,
+									ValidCardNumber:       card,
+									NationalCode:          nationalCode,
+									RequireCardOwnerMatch: ownerMatch,
+								})
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func FuzzPaymentInitValidation(f *testing.F) {
+	f.Add(int64(MinAmount), "https://shop.example.com/callback", "Order", "09123456789", "6037997012345678", "0499370899", false)
+	f.Add(int64(-5), "", strings.Repeat("x", 300), "0912", "1234", "123", true)
+
+	f.Fuzz(func(t *testing.T, amount int64, callback, description, mobile, card, nationalCode string, ownerMatch bool) {
+		checkAgainstReference(t, &PaymentInitRequest{
+			Amount:                amount,
+			CallbackURL:           callback,
+			Description:           description,
+			Mobile:                mobile,
+			ValidCardNumber:       card,
+			NationalCode:          nationalCode,
+			RequireCardOwnerMatch: ownerMatch,
+		})
+	})
+}
+
+func TestRefundValidation(t *testing.T) {
+	err := ValidateRefundRequest(&RefundRequest{Amount: -1})
+	want := []ValidationError{
+		{Field: "transaction_id", Message: "transaction ID is required"},
+		{Field: "amount", Message: "amount must be a positive number"},
+	}
+	if got := ExtractValidationErrors(err); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if err := ValidateRefundRequest(&RefundRequest{TransactionID: "tx1"}); err != nil {
+		t.Fatalf("valid refund: %v", err)
+	}
+}
+
+func TestSingleFieldValidationReturnsValidationError(t *testing.T) {
+	var validationErr *ValidationError
+	if err := ValidatePaymentVerifyRequest(&PaymentVerifyRequest{}); !errors.As(err, &validationErr) || validationErr.Message != "token is required" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+type unknownRuleRequest struct {
+	Name string `json:"name" validate:"required,shiny"`
+}
+
+type badLimitRequest struct {
+	Amount int64 `json:"amount" validate:"min=lots"`
+}
+
+type missingTargetRequest struct {
+	Mobile string `json:"mobile" validate:"required_if=Verified"`
+}
+
+type nonBoolTargetRequest struct {
+	Mobile string `json:"mobile" validate:"required_if=Name"`
+	Name   string `json:"name"`
+}
+
+func TestBrokenValidateTagsReturnErrors(t *testing.T) {
+	tests := []struct {
+		target  interface{}
+		message string
+	}{
+		{&unknownRuleRequest{Name: "x"}, `unknown validation rule "shiny"`},
+		{&badLimitRequest{Amount: 1}, `invalid validation limit "lots"`},
+		{&missingTargetRequest{}, `unknown field "Verified"`},
+		{&nonBoolTargetRequest{}, "Name, which is not a bool"},
+		{"not a struct", "cannot validate a string"},
+	}
+
+	for _, tt := range tests {
+		// The error is kept with the cached type and reported every time
+		for i := 0; i < 2; i++ {
+			err := defaultValidator.validateStruct(tt.target)
+			if !errors.Is(err, ErrInternalError) || IsValidationError(err) || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("%T: got %v, want an internal error containing %q", tt.target, err, tt.message)
+			}
+		}
+	}
+}
+
+func TestRequestTypesHaveValidTags(t *testing.T) {
+	types := []interface{}{
+		PaymentInitRequest{},
+		PaymentVerifyRequest{},
+		PaymentStatusRequest{},
+		RefundRequest{},
+		CallbackData{},
+		TransferRequest{},
+	}
+	for _, target := range types {
+		if _, err := parseValidatedType(reflect.TypeOf(target)); err != nil {
+			t.Errorf("%T: %v", target, err)
+		}
+	}
+}
+
+func TestBrokenValidatorRespondsInternalError(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport())
+
+	rec := httptest.NewRecorder()
+	client.respondInvalid(rec, defaultValidator.validateStruct(&unknownRuleRequest{}))
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "shiny") {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body.String())
+	}
+}
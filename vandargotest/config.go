@@ -0,0 +1,72 @@
+// Package vandargotest provides fakes of the vandargo interfaces for tests
+// config.go implements a fixed configuration
+package vandargotest
+
+// Defaults of NewStaticConfig
+const (
+	// APIKey is the API key of NewStaticConfig; routes accept it as a bearer token
+	APIKey = "vandargotest-api-key"
+
+	// BaseURL is the base URL of NewStaticConfig; requests to it only reach a
+	// ScriptedHTTPClient or simulator
+	BaseURL = "https://api.vandar.io"
+
+	// CallbackURL is the callback URL of NewStaticConfig
+	CallbackURL = "https://shop.example.com/payments/callback"
+)
+
+// StaticConfig is a vandargo.ConfigInterface returning its fields. Optional
+// features configured through vandargo.Config are off with it; use
+// vandargo.NewConfig for those. It is safe for concurrent use as long as the
+// fields aren't changed.
+type StaticConfig struct {
+	// APIKey is the Vandar API key
+	APIKey string
+
+	// BaseURL is the base URL of the Vandar API
+	BaseURL string
+
+	// CallbackURL is the URL for payment callbacks
+	CallbackURL string
+
+	// SandboxMode reports whether the integration is in sandbox mode
+	SandboxMode bool
+
+	// Timeout is the HTTP client timeout in seconds
+	Timeout int
+}
+
+// NewStaticConfig returns a StaticConfig with APIKey, BaseURL, CallbackURL and a 5 second timeout
+func NewStaticConfig() *StaticConfig {
+	return &StaticConfig{
+		APIKey:      APIKey,
+		BaseURL:     BaseURL,
+		CallbackURL: CallbackURL,
+		Timeout:     5,
+	}
+}
+
+// GetAPIKey returns the API key
+func (c *StaticConfig) GetAPIKey() string {
+	return c.APIKey
+}
+
+// GetBaseURL returns the base URL
+func (c *StaticConfig) GetBaseURL() string {
+	return c.BaseURL
+}
+
+// IsSandboxMode returns whether sandbox mode is on
+func (c *StaticConfig) IsSandboxMode() bool {
+	return c.SandboxMode
+}
+
+// GetTimeout returns the HTTP client timeout in seconds
+func (c *StaticConfig) GetTimeout() int {
+	return c.Timeout
+}
+
+// GetCallbackURL returns the callback URL
+func (c *StaticConfig) GetCallbackURL() string {
+	return c.CallbackURL
+}
@@ -0,0 +1,41 @@
+// Package vandargotest provides fakes of the vandargo interfaces for tests
+// doc.go documents the package with a complete handler test
+//
+// FakeStorage, FakeLogger, StaticConfig and ScriptedHTTPClient implement
+// vandargo.StorageInterface, LoggerInterface, ConfigInterface and
+// HTTPClientInterface. Each records what it was asked, so tests can assert on
+// storage calls, log entries and gateway requests, and each is safe for
+// concurrent use. A test of the verify route:
+//
+//	func TestVerifyHandler(t *testing.T) {
+//		gateway := vandargotest.NewScriptedHTTPClient(vandargotest.JSONResponse(http.StatusOK, map[string]interface{}{
+//			"status": 1, "amount": "10000", "transId": 1601, "factorNumber": "F1",
+//		}))
+//		storage := vandargotest.NewFakeStorage(&vandargo.Transaction{
+//			ID: "tx1", Token: "tok", Amount: 10000, FactorNumber: "F1", Status: vandargo.StatusInit,
+//		})
+//		logger := vandargotest.NewFakeLogger()
+//		client, err := vandargo.NewClient(vandargotest.NewStaticConfig(), storage, logger)
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		mux := http.NewServeMux()
+//		client.WithHTTPClient(gateway).RegisterRoutes(vandargo.NewServeMuxRouter(mux))
+//
+//		req := httptest.NewRequest(http.MethodPost, "/payments/verify", strings.NewReader(`{"token":"tok"}`))
+//		req.Header.Set("Authorization", "Bearer "+vandargotest.APIKey)
+//		req.Header.Set("Content-Type", "application/json")
+//		rec := httptest.NewRecorder()
+//		mux.ServeHTTP(rec, req)
+//
+//		if tx, _ := storage.Transaction("tok"); rec.Code != http.StatusOK || tx.Status != vandargo.StatusPaid {
+//			t.Fatalf("got %d with transaction %s: %s", rec.Code, tx.Status, rec.Body)
+//		}
+//		logger.AssertNotLogged(t, vandargotest.LevelError)
+//		gateway.AssertExhausted(t)
+//	}
+//
+// Failures are programmed per storage method, e.g.
+// storage.FailNext(vandargotest.MethodUpdateTransaction, errors.New("disk full")),
+// and a gateway outage with vandargotest.ErrorResponse.
+package vandargotest
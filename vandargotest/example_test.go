@@ -0,0 +1,41 @@
+package vandargotest_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/uussoop/vandargo"
+	"github.com/uussoop/vandargo/vandargotest"
+)
+
+// A handler test verifying a payment against a scripted gateway
+func Example() {
+	gateway := vandargotest.NewScriptedHTTPClient(vandargotest.JSONResponse(http.StatusOK, map[string]interface{}{
+		"status": 1, "amount": "10000", "transId": 1601, "factorNumber": "F1",
+	}))
+	storage := vandargotest.NewFakeStorage(&vandargo.Transaction{
+		ID: "tx1", Token: "tok", Amount: 10000, FactorNumber: "F1", Status: vandargo.StatusInit,
+	})
+	logger := vandargotest.NewFakeLogger()
+	client, err := vandargo.NewClient(vandargotest.NewStaticConfig(), storage, logger)
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	client.WithHTTPClient(gateway).RegisterRoutes(vandargo.NewServeMuxRouter(mux))
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/verify", strings.NewReader(`{"token":"tok"}`))
+	req.Header.Set("Authorization", "Bearer "+vandargotest.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	tx, _ := storage.Transaction("tok")
+	fmt.Println(rec.Code, tx.Status, tx.TransactionID)
+	fmt.Println(len(gateway.Requests()), gateway.Remaining(), len(logger.EntriesAt(vandargotest.LevelError)))
+	// Output:
+	// 200 PAID 1601
+	// 1 0 0
+}
@@ -0,0 +1,176 @@
+// Package vandargotest provides fakes of the vandargo interfaces for tests
+// http.go implements an HTTP client answering with canned responses
+package vandargotest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// ErrNoScriptedResponse is returned by ScriptedHTTPClient when its queue is empty
+var ErrNoScriptedResponse = errors.New("vandargotest: no scripted response left")
+
+// ScriptedResponse is a canned answer of a ScriptedHTTPClient
+type ScriptedResponse struct {
+	// StatusCode is the HTTP status code, 200 when not set
+	StatusCode int
+
+	// Body is the response body
+	Body string
+
+	// Header are the response headers; JSON bodies get a Content-Type
+	Header http.Header
+
+	// Err makes the request fail in transit instead of answering
+	Err error
+}
+
+// JSONResponse returns a ScriptedResponse with body marshaled to JSON. It
+// panics when body can't be marshaled, which is a mistake in the test.
+func JSONResponse(statusCode int, body interface{}) ScriptedResponse {
+	data, err := json.Marshal(body)
+	if err != nil {
+		panic(fmt.Sprintf("vandargotest: failed to marshal response: %v", err))
+	}
+
+	return ScriptedResponse{
+		StatusCode: statusCode,
+		Body:       string(data),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+	}
+}
+
+// ErrorResponse returns a ScriptedResponse failing the request with err, like a network error
+func ErrorResponse(err error) ScriptedResponse {
+	return ScriptedResponse{Err: err}
+}
+
+// RecordedRequest is a request a ScriptedHTTPClient received
+type RecordedRequest struct {
+	// Method is the HTTP method
+	Method string
+
+	// URL is the full request URL
+	URL string
+
+	// Path is the path of the request URL
+	Path string
+
+	// Header are the request headers
+	Header http.Header
+
+	// Body is the request body
+	Body []byte
+}
+
+// DecodeJSON unmarshals the request body into v
+func (r RecordedRequest) DecodeJSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// ScriptedHTTPClient is a vandargo.HTTPClientInterface answering requests with
+// a queue of canned responses, in order, and capturing the requests. Once the
+// queue is empty requests fail with ErrNoScriptedResponse. It is safe for
+// concurrent use; concurrent requests get the responses in arrival order.
+type ScriptedHTTPClient struct {
+	mutex     sync.Mutex
+	responses []ScriptedResponse
+	requests  []RecordedRequest
+}
+
+// NewScriptedHTTPClient creates a ScriptedHTTPClient answering with responses
+func NewScriptedHTTPClient(responses ...ScriptedResponse) *ScriptedHTTPClient {
+	return &ScriptedHTTPClient{responses: responses}
+}
+
+// Enqueue appends responses to the queue
+func (c *ScriptedHTTPClient) Enqueue(responses ...ScriptedResponse) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.responses = append(c.responses, responses...)
+}
+
+// Requests returns the received requests in order
+func (c *ScriptedHTTPClient) Requests() []RecordedRequest {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]RecordedRequest(nil), c.requests...)
+}
+
+// Remaining returns how many responses are left in the queue
+func (c *ScriptedHTTPClient) Remaining() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.responses)
+}
+
+// AssertExhausted fails the test unless every scripted response was used
+func (c *ScriptedHTTPClient) AssertExhausted(t testing.TB) {
+	t.Helper()
+
+	if remaining := c.Remaining(); remaining > 0 {
+		t.Errorf("%d scripted responses were not requested", remaining)
+	}
+}
+
+// Do records the request and answers it with the next scripted response
+func (c *ScriptedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	recorded := RecordedRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Path:   req.URL.Path,
+		Header: req.Header.Clone(),
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("vandargotest: failed to read request body: %w", err)
+		}
+		recorded.Body = body
+	}
+
+	c.mutex.Lock()
+	c.requests = append(c.requests, recorded)
+	if len(c.responses) == 0 {
+		c.mutex.Unlock()
+		return nil, fmt.Errorf("%w for %s %s", ErrNoScriptedResponse, req.Method, req.URL.Path)
+	}
+	scripted := c.responses[0]
+	c.responses = c.responses[1:]
+	c.mutex.Unlock()
+
+	if scripted.Err != nil {
+		return nil, scripted.Err
+	}
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	statusCode := scripted.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	header := scripted.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		StatusCode:    statusCode,
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(scripted.Body))),
+		ContentLength: int64(len(scripted.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,154 @@
+// Package vandargotest provides fakes of the vandargo interfaces for tests
+// logger.go implements a logger capturing its entries
+package vandargotest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Log levels of captured entries
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// LogEntry is a captured log entry
+type LogEntry struct {
+	// Level is one of LevelDebug, LevelInfo, LevelWarn and LevelError
+	Level string
+
+	// Message is the logged message
+	Message string
+
+	// Err is the logged error, only set for LevelError
+	Err error
+
+	// Fields are the logged fields
+	Fields map[string]interface{}
+}
+
+// FakeLogger is a vandargo.LoggerInterface capturing every entry for assertions.
+// It is safe for concurrent use.
+type FakeLogger struct {
+	mutex   sync.Mutex
+	entries []LogEntry
+}
+
+// NewFakeLogger creates a FakeLogger
+func NewFakeLogger() *FakeLogger {
+	return &FakeLogger{}
+}
+
+// Debug captures a debug entry
+func (l *FakeLogger) Debug(ctx context.Context, message string, fields map[string]interface{}) {
+	l.capture(LogEntry{Level: LevelDebug, Message: message, Fields: fields})
+}
+
+// Info captures an informational entry
+func (l *FakeLogger) Info(ctx context.Context, message string, fields map[string]interface{}) {
+	l.capture(LogEntry{Level: LevelInfo, Message: message, Fields: fields})
+}
+
+// Warn captures a warning entry
+func (l *FakeLogger) Warn(ctx context.Context, message string, fields map[string]interface{}) {
+	l.capture(LogEntry{Level: LevelWarn, Message: message, Fields: fields})
+}
+
+// Error captures an error entry
+func (l *FakeLogger) Error(ctx context.Context, message string, err error, fields map[string]interface{}) {
+	l.capture(LogEntry{Level: LevelError, Message: message, Err: err, Fields: fields})
+}
+
+// capture appends an entry with a copy of its fields
+func (l *FakeLogger) capture(entry LogEntry) {
+	if entry.Fields != nil {
+		fields := make(map[string]interface{}, len(entry.Fields))
+		for key, value := range entry.Fields {
+			fields[key] = value
+		}
+		entry.Fields = fields
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.entries = append(l.entries, entry)
+}
+
+// Entries returns the captured entries in order
+func (l *FakeLogger) Entries() []LogEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return append([]LogEntry(nil), l.entries...)
+}
+
+// EntriesAt returns the captured entries of a level in order
+func (l *FakeLogger) EntriesAt(level string) []LogEntry {
+	var result []LogEntry
+	for _, entry := range l.Entries() {
+		if entry.Level == level {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Find returns the first entry of a level whose message contains substr
+func (l *FakeLogger) Find(level, substr string) (LogEntry, bool) {
+	for _, entry := range l.EntriesAt(level) {
+		if strings.Contains(entry.Message, substr) {
+			return entry, true
+		}
+	}
+	return LogEntry{}, false
+}
+
+// Reset discards the captured entries
+func (l *FakeLogger) Reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.entries = nil
+}
+
+// AssertLogged fails the test unless an entry of a level contains substr in its message
+func (l *FakeLogger) AssertLogged(t testing.TB, level, substr string) {
+	t.Helper()
+
+	if _, ok := l.Find(level, substr); !ok {
+		t.Errorf("no %s entry containing %q was logged; entries: %s", level, substr, l.describe())
+	}
+}
+
+// AssertNotLogged fails the test if any entry of a level was logged
+func (l *FakeLogger) AssertNotLogged(t testing.TB, level string) {
+	t.Helper()
+
+	if entries := l.EntriesAt(level); len(entries) > 0 {
+		t.Errorf("%d unexpected %s entries were logged; entries: %s", len(entries), level, l.describe())
+	}
+}
+
+// describe formats the captured entries for failure messages
+func (l *FakeLogger) describe() string {
+	entries := l.Entries()
+	if len(entries) == 0 {
+		return "none"
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		line := entry.Level + ": " + entry.Message
+		if entry.Err != nil {
+			line += " (" + entry.Err.Error() + ")"
+		}
+		lines = append(lines, line)
+	}
+	return "\n\t" + strings.Join(lines, "\n\t")
+}
@@ -0,0 +1,264 @@
+// Package vandargotest provides fakes of the vandargo interfaces for tests
+// storage.go implements an in-memory storage recording its calls
+package vandargotest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/uussoop/vandargo"
+)
+
+// Storage method names, for recorded calls and programmed errors
+const (
+	MethodStoreTransaction        = "StoreTransaction"
+	MethodGetTransaction          = "GetTransaction"
+	MethodUpdateTransaction       = "UpdateTransaction"
+	MethodGetTransactionsByStatus = "GetTransactionsByStatus"
+)
+
+// StorageCall is a recorded call of a FakeStorage method
+type StorageCall struct {
+	// Method is the name of the called method, e.g. MethodGetTransaction
+	Method string
+
+	// Token is the token the call was made for, empty for status lookups
+	Token string
+
+	// Status is the status looked up by GetTransactionsByStatus
+	Status string
+
+	// Err is the error the call returned
+	Err error
+}
+
+// FakeStorage is an in-memory vandargo.StorageInterface that records its calls
+// and can be told to fail. Transactions are stored by token and copied in and
+// out, like vandargo.MemoryStorage. It is safe for concurrent use.
+type FakeStorage struct {
+	mutex        sync.Mutex
+	transactions map[string]*vandargo.Transaction
+	calls        []StorageCall
+	errs         map[string]error
+	nextErrs     map[string][]error
+}
+
+// NewFakeStorage creates a FakeStorage holding transactions
+func NewFakeStorage(transactions ...*vandargo.Transaction) *FakeStorage {
+	s := &FakeStorage{
+		transactions: make(map[string]*vandargo.Transaction),
+		errs:         make(map[string]error),
+		nextErrs:     make(map[string][]error),
+	}
+	for _, transaction := range transactions {
+		s.transactions[transaction.Token] = cloneTransaction(transaction)
+	}
+	return s
+}
+
+// SetError makes every call of a method fail with err until it is set to nil
+func (s *FakeStorage) SetError(method string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err == nil {
+		delete(s.errs, method)
+		return
+	}
+	s.errs[method] = err
+}
+
+// FailNext makes the next call of a method fail with err; repeated calls queue
+// errors for the calls after it. Queued errors take precedence over SetError.
+func (s *FakeStorage) FailNext(method string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.nextErrs[method] = append(s.nextErrs[method], err)
+}
+
+// Calls returns the recorded calls in order
+func (s *FakeStorage) Calls() []StorageCall {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]StorageCall(nil), s.calls...)
+}
+
+// CallCount returns how often a method was called
+func (s *FakeStorage) CallCount(method string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for _, call := range s.calls {
+		if call.Method == method {
+			count++
+		}
+	}
+	return count
+}
+
+// Transaction returns a copy of the stored transaction with a token
+func (s *FakeStorage) Transaction(token string) (*vandargo.Transaction, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	transaction, ok := s.transactions[token]
+	if !ok {
+		return nil, false
+	}
+	return cloneTransaction(transaction), true
+}
+
+// Transactions returns copies of every stored transaction, ordered by token
+func (s *FakeStorage) Transactions() []*vandargo.Transaction {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.collect(func(*vandargo.Transaction) bool { return true })
+}
+
+// Reset forgets the recorded calls and programmed errors, keeping the transactions
+func (s *FakeStorage) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.calls = nil
+	s.errs = make(map[string]error)
+	s.nextErrs = make(map[string][]error)
+}
+
+// StoreTransaction saves a new transaction
+func (s *FakeStorage) StoreTransaction(ctx context.Context, transaction *vandargo.Transaction) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	call := StorageCall{Method: MethodStoreTransaction}
+	if transaction != nil {
+		call.Token = transaction.Token
+	}
+	if err := s.begin(ctx, &call); err != nil {
+		return err
+	}
+
+	if transaction == nil {
+		return s.fail(&call, fmt.Errorf("transaction cannot be nil"))
+	}
+	s.transactions[transaction.Token] = cloneTransaction(transaction)
+	return nil
+}
+
+// GetTransaction retrieves a transaction by token
+func (s *FakeStorage) GetTransaction(ctx context.Context, token string) (*vandargo.Transaction, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	call := StorageCall{Method: MethodGetTransaction, Token: token}
+	if err := s.begin(ctx, &call); err != nil {
+		return nil, err
+	}
+
+	transaction, ok := s.transactions[token]
+	if !ok {
+		return nil, s.fail(&call, fmt.Errorf("transaction not found: %s", token))
+	}
+	return cloneTransaction(transaction), nil
+}
+
+// UpdateTransaction replaces a stored transaction
+func (s *FakeStorage) UpdateTransaction(ctx context.Context, transaction *vandargo.Transaction) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	call := StorageCall{Method: MethodUpdateTransaction}
+	if transaction != nil {
+		call.Token = transaction.Token
+	}
+	if err := s.begin(ctx, &call); err != nil {
+		return err
+	}
+
+	if transaction == nil {
+		return s.fail(&call, fmt.Errorf("transaction cannot be nil"))
+	}
+	if _, ok := s.transactions[transaction.Token]; !ok {
+		return s.fail(&call, fmt.Errorf("transaction not found: %s", transaction.Token))
+	}
+	s.transactions[transaction.Token] = cloneTransaction(transaction)
+	return nil
+}
+
+// GetTransactionsByStatus retrieves the transactions with a status, ordered by token
+func (s *FakeStorage) GetTransactionsByStatus(ctx context.Context, status string) ([]*vandargo.Transaction, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	call := StorageCall{Method: MethodGetTransactionsByStatus, Status: status}
+	if err := s.begin(ctx, &call); err != nil {
+		return nil, err
+	}
+
+	return s.collect(func(transaction *vandargo.Transaction) bool {
+		return string(transaction.Status) == status
+	}), nil
+}
+
+// begin records a call and returns the error it must fail with, if any. The
+// mutex must be held.
+func (s *FakeStorage) begin(ctx context.Context, call *StorageCall) error {
+	s.calls = append(s.calls, *call)
+
+	err := ctx.Err()
+	if err == nil {
+		if queued := s.nextErrs[call.Method]; len(queued) > 0 {
+			err = queued[0]
+			s.nextErrs[call.Method] = queued[1:]
+		} else {
+			err = s.errs[call.Method]
+		}
+	}
+	if err != nil {
+		return s.fail(call, err)
+	}
+	return nil
+}
+
+// fail records the error of the last call and returns it. The mutex must be held.
+func (s *FakeStorage) fail(call *StorageCall, err error) error {
+	call.Err = err
+	s.calls[len(s.calls)-1] = *call
+	return err
+}
+
+// collect returns copies of the matching transactions ordered by token. The
+// mutex must be held.
+func (s *FakeStorage) collect(match func(*vandargo.Transaction) bool) []*vandargo.Transaction {
+	tokens := make([]string, 0, len(s.transactions))
+	for token, transaction := range s.transactions {
+		if match(transaction) {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Strings(tokens)
+
+	result := make([]*vandargo.Transaction, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, cloneTransaction(s.transactions[token]))
+	}
+	return result
+}
+
+// cloneTransaction copies a transaction including its metadata
+func cloneTransaction(transaction *vandargo.Transaction) *vandargo.Transaction {
+	transactionCopy := *transaction
+	if transaction.Metadata != nil {
+		transactionCopy.Metadata = make(map[string]string, len(transaction.Metadata))
+		for key, value := range transaction.Metadata {
+			transactionCopy.Metadata[key] = value
+		}
+	}
+	return &transactionCopy
+}
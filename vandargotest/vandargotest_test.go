@@ -0,0 +1,173 @@
+package vandargotest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/uussoop/vandargo"
+	"github.com/uussoop/vandargo/vandargotest"
+)
+
+func TestFakeStorageErrors(t *testing.T) {
+	diskFull := errors.New("disk full")
+	storage := vandargotest.NewFakeStorage(&vandargo.Transaction{Token: "tok", Status: vandargo.StatusInit})
+	ctx := context.Background()
+
+	// Queued errors come first, then the persistent one, until it is cleared
+	storage.SetError(vandargotest.MethodGetTransaction, diskFull)
+	storage.FailNext(vandargotest.MethodGetTransaction, context.DeadlineExceeded)
+	for _, want := range []error{context.DeadlineExceeded, diskFull, diskFull} {
+		if _, err := storage.GetTransaction(ctx, "tok"); !errors.Is(err, want) {
+			t.Fatalf("GetTransaction() error = %v, want %v", err, want)
+		}
+	}
+	storage.SetError(vandargotest.MethodGetTransaction, nil)
+	if _, err := storage.GetTransaction(ctx, "tok"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other methods are unaffected
+	if err := storage.UpdateTransaction(ctx, &vandargo.Transaction{Token: "tok", Status: vandargo.StatusPaid}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := storage.Calls()
+	if len(calls) != 5 || calls[0].Err == nil || calls[3].Err != nil || calls[4].Method != vandargotest.MethodUpdateTransaction {
+		t.Fatalf("calls %+v", calls)
+	}
+	if storage.CallCount(vandargotest.MethodGetTransaction) != 4 {
+		t.Fatalf("%d GetTransaction calls", storage.CallCount(vandargotest.MethodGetTransaction))
+	}
+
+	// Cancelled contexts fail before the programmed errors
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := storage.GetTransactionsByStatus(cancelled, "PAID"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetTransactionsByStatus() error = %v", err)
+	}
+
+	storage.Reset()
+	if len(storage.Calls()) != 0 {
+		t.Fatal("calls kept after Reset")
+	}
+	if transaction, ok := storage.Transaction("tok"); !ok || transaction.Status != vandargo.StatusPaid {
+		t.Fatal("transactions lost on Reset")
+	}
+}
+
+func TestFakeStorageCopies(t *testing.T) {
+	original := &vandargo.Transaction{Token: "tok", Metadata: map[string]string{"order": "1042"}}
+	storage := vandargotest.NewFakeStorage(original)
+
+	original.Metadata["order"] = "changed"
+	transaction, _ := storage.Transaction("tok")
+	transaction.Metadata["order"] = "changed"
+	if stored, _ := storage.Transaction("tok"); stored.Metadata["order"] != "1042" {
+		t.Fatalf("stored transaction shares its metadata: %v", stored.Metadata)
+	}
+}
+
+func TestScriptedHTTPClient(t *testing.T) {
+	gateway := vandargotest.NewScriptedHTTPClient(
+		vandargotest.JSONResponse(http.StatusOK, map[string]interface{}{"status": 1, "token": "tok"}),
+		vandargotest.ErrorResponse(errors.New("connection refused")),
+	)
+	storage := vandargotest.NewFakeStorage()
+	client, err := vandargo.NewClient(vandargotest.NewStaticConfig(), storage, vandargotest.NewFakeLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = client.WithHTTPClient(gateway)
+
+	if _, err := client.InitiatePayment(context.Background(), 10000, "Order 1042", nil); err != nil {
+		t.Fatal(err)
+	}
+	requests := gateway.Requests()
+	var body map[string]interface{}
+	if len(requests) != 1 || requests[0].Method != http.MethodPost || requests[0].DecodeJSON(&body) != nil || body["api_key"] != vandargotest.APIKey {
+		t.Fatalf("requests %+v", requests)
+	}
+	if _, ok := storage.Transaction("tok"); !ok {
+		t.Fatal("initiated payment not stored")
+	}
+
+	// A network error, then an empty queue
+	for i := 0; i < 2; i++ {
+		if _, err := gateway.Do(newRequest(t)); err == nil {
+			t.Fatal("request answered")
+		}
+	}
+	if _, err := gateway.Do(newRequest(t)); !errors.Is(err, vandargotest.ErrNoScriptedResponse) {
+		t.Fatalf("Do() error = %v", err)
+	}
+	gateway.AssertExhausted(t)
+
+	gateway.Enqueue(vandargotest.ScriptedResponse{Body: "plain"})
+	resp, err := gateway.Do(newRequest(t))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Do() = %v, %v", resp, err)
+	}
+}
+
+func TestFakeLogger(t *testing.T) {
+	logger := vandargotest.NewFakeLogger()
+	ctx := context.Background()
+	logger.Info(ctx, "Payment initiated", map[string]interface{}{"token": "tok"})
+	logger.Error(ctx, "Verification failed", errors.New("timeout"), nil)
+
+	logger.AssertLogged(t, vandargotest.LevelInfo, "initiated")
+	logger.AssertNotLogged(t, vandargotest.LevelWarn)
+	if entry, ok := logger.Find(vandargotest.LevelError, "Verification"); !ok || entry.Err == nil {
+		t.Fatalf("error entry %+v", entry)
+	}
+	if entry, ok := logger.Find(vandargotest.LevelInfo, "Verification"); ok {
+		t.Fatalf("found %+v at the wrong level", entry)
+	}
+
+	logger.Reset()
+	if len(logger.Entries()) != 0 {
+		t.Fatal("entries kept after Reset")
+	}
+}
+
+func TestFakesConcurrentUse(t *testing.T) {
+	storage := vandargotest.NewFakeStorage()
+	logger := vandargotest.NewFakeLogger()
+	gateway := vandargotest.NewScriptedHTTPClient()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token := string(rune('a'+i%26)) + string(rune('a'+i/26))
+			gateway.Enqueue(vandargotest.JSONResponse(http.StatusOK, map[string]interface{}{"status": 1}))
+			storage.StoreTransaction(ctx, &vandargo.Transaction{Token: token})
+			storage.GetTransaction(ctx, token)
+			logger.Info(ctx, "stored", nil)
+			if resp, err := gateway.Do(newRequest(t)); err == nil {
+				resp.Body.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(storage.Transactions()) != 50 || len(storage.Calls()) != 100 || len(logger.Entries()) != 50 || len(gateway.Requests()) != 50 {
+		t.Fatalf("%d transactions, %d calls, %d entries, %d requests",
+			len(storage.Transactions()), len(storage.Calls()), len(logger.Entries()), len(gateway.Requests()))
+	}
+	gateway.AssertExhausted(t)
+}
+
+// newRequest creates a request for a ScriptedHTTPClient
+func newRequest(t *testing.T) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, vandargotest.BaseURL+"/v3/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
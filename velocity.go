@@ -0,0 +1,435 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// velocity.go implements velocity checks limiting payment initializations per payer
+package vandargo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// VelocityLimitCode is the error code of payment initializations refused by a velocity rule
+const VelocityLimitCode = "velocity_limit_exceeded"
+
+// VelocitySubject is what a velocity rule counts per
+type VelocitySubject string
+
+const (
+	// VelocityByMobile counts per customer mobile number
+	VelocityByMobile VelocitySubject = "mobile"
+
+	// VelocityByNationalCode counts per customer national code
+	VelocityByNationalCode VelocitySubject = "national_code"
+
+	// VelocityByClientIP counts per payer IP address, known for initializations
+	// through the HTTP handler
+	VelocityByClientIP VelocitySubject = "client_ip"
+
+	// VelocityByCID counts per card fingerprint, known for initializations
+	// restricted to a card with ValidCardNumber and for verified payments
+	VelocityByCID VelocitySubject = "cid"
+)
+
+// VelocityMeasure is what a velocity rule sums
+type VelocityMeasure string
+
+const (
+	// VelocityInitiations counts payment initializations
+	VelocityInitiations VelocityMeasure = "initiations"
+
+	// VelocityAmount sums the amounts of payment initializations, in Rials
+	VelocityAmount VelocityMeasure = "amount"
+
+	// VelocityFailedVerifications counts verifications the gateway declined. It
+	// is only recorded per VelocityByCID; once Limit is reached, initializations
+	// with the card are refused for the rest of the window.
+	VelocityFailedVerifications VelocityMeasure = "failed_verifications"
+)
+
+// VelocityRule limits a measure per subject within a fixed time window, e.g. at
+// most 5 initiations per mobile number per hour
+type VelocityRule struct {
+	// Name identifies the rule in counters, logs and violations; letters,
+	// digits, dashes and underscores
+	Name string
+
+	// Subject is what the rule counts per
+	Subject VelocitySubject
+
+	// Measure is what the rule sums
+	Measure VelocityMeasure
+
+	// Limit is the largest total allowed within a window
+	Limit int64
+
+	// Window is the length of the counting windows
+	Window time.Duration
+
+	// Block refuses violations with 403 instead of 429, for rules that flag
+	// fraud rather than excess traffic
+	Block bool
+}
+
+// validate checks a rule's settings
+func (r VelocityRule) validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	for _, ch := range r.Name {
+		if !(ch == '-' || ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')) {
+			return fmt.Errorf("name %q may only contain letters, digits, dashes and underscores", r.Name)
+		}
+	}
+
+	switch r.Subject {
+	case VelocityByMobile, VelocityByNationalCode, VelocityByClientIP, VelocityByCID:
+	default:
+		return fmt.Errorf("unknown subject %q", r.Subject)
+	}
+
+	switch r.Measure {
+	case VelocityInitiations, VelocityAmount:
+	case VelocityFailedVerifications:
+		if r.Subject != VelocityByCID {
+			return errors.New("failed verifications are only counted per cid")
+		}
+	default:
+		return fmt.Errorf("unknown measure %q", r.Measure)
+	}
+
+	if r.Limit <= 0 {
+		return errors.New("limit must be positive")
+	}
+	if r.Window <= 0 {
+		return errors.New("window must be positive")
+	}
+
+	return nil
+}
+
+// VelocityStore keeps the counters of velocity rules. Each key counts one fixed
+// window and can be dropped after its TTL, which maps onto Redis INCRBY and
+// PEXPIRE, so several instances can share the counters.
+type VelocityStore interface {
+	// IncrBy adds delta to a counter, creating it with the TTL, and returns its new value
+	IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+
+	// Get returns a counter, or 0 when it doesn't exist or expired
+	Get(ctx context.Context, key string) (int64, error)
+}
+
+// velocityCounter is a counter of a MemoryVelocityStore
+type velocityCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+// velocityPruneInterval is how often a MemoryVelocityStore drops expired counters
+const velocityPruneInterval = time.Minute
+
+// MemoryVelocityStore is an in-memory VelocityStore for a single instance
+type MemoryVelocityStore struct {
+	counters   map[string]*velocityCounter
+	mutex      sync.Mutex
+	clock      Clock
+	lastPruned time.Time
+}
+
+// NewMemoryVelocityStore creates an in-memory velocity store expiring counters by
+// clock, the real clock when nil
+func NewMemoryVelocityStore(clock Clock) *MemoryVelocityStore {
+	if clock == nil {
+		clock = RealClock()
+	}
+	return &MemoryVelocityStore{
+		counters: make(map[string]*velocityCounter),
+		clock:    clock,
+	}
+}
+
+// IncrBy adds delta to a counter, creating it with the TTL, and returns its new value
+func (s *MemoryVelocityStore) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	if now.Sub(s.lastPruned) >= velocityPruneInterval {
+		for k, counter := range s.counters {
+			if !now.Before(counter.expiresAt) {
+				delete(s.counters, k)
+			}
+		}
+		s.lastPruned = now
+	}
+
+	counter, exists := s.counters[key]
+	if !exists || !now.Before(counter.expiresAt) {
+		counter = &velocityCounter{expiresAt: now.Add(ttl)}
+		s.counters[key] = counter
+	}
+	counter.value += delta
+
+	return counter.value, nil
+}
+
+// Get returns a counter, or 0 when it doesn't exist or expired
+func (s *MemoryVelocityStore) Get(ctx context.Context, key string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	counter, exists := s.counters[key]
+	if !exists || !s.clock.Now().Before(counter.expiresAt) {
+		return 0, nil
+	}
+	return counter.value, nil
+}
+
+// VelocityChecker evaluates velocity rules before payment initializations and
+// records their counters. Give it to a client with WithClientVelocityChecker.
+type VelocityChecker struct {
+	store VelocityStore
+	rules []VelocityRule
+
+	// memoryStore reports whether the checker was created without a store, so a
+	// client's KV store may replace the in-memory one
+	memoryStore bool
+}
+
+// NewVelocityChecker creates a checker of rules counting in store, an in-memory
+// store when nil
+func NewVelocityChecker(store VelocityStore, rules ...VelocityRule) (*VelocityChecker, error) {
+	memoryStore := store == nil
+	if memoryStore {
+		store = NewMemoryVelocityStore(nil)
+	}
+
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("%w: velocity rule %q: %w", ErrInvalidConfig, rule.Name, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%w: duplicate velocity rule %q", ErrInvalidConfig, rule.Name)
+		}
+		names[rule.Name] = true
+	}
+
+	return &VelocityChecker{
+		store:       store,
+		rules:       append([]VelocityRule(nil), rules...),
+		memoryStore: memoryStore,
+	}, nil
+}
+
+// withKVStore returns a copy of a checker created without a store counting in
+// kv instead; other checkers, and any checker when kv is nil, are returned as is
+func (v *VelocityChecker) withKVStore(kv KVStore) *VelocityChecker {
+	if v == nil || kv == nil || !v.memoryStore {
+		return v
+	}
+
+	checker := *v
+	checker.store = NewKVVelocityStore(kv)
+	return &checker
+}
+
+// VelocityViolation describes a payment initialization refused by a velocity rule
+type VelocityViolation struct {
+	// Rule is the violated rule
+	Rule VelocityRule
+
+	// Total is the rule's counter including the refused initialization
+	Total int64
+
+	// RetryAfter is how long until the rule's current window ends
+	RetryAfter time.Duration
+
+	// Amount is the amount of the refused payment
+	Amount int64
+
+	// Mobile is the customer's mobile number, when sent
+	Mobile string
+
+	// ClientIP is the payer's IP address, when known
+	ClientIP string
+
+	// CID is the fingerprint of the card the payment was restricted to, when set
+	CID string
+
+	// FactorNumber is the merchant's invoice number, when sent
+	FactorNumber string
+}
+
+// VelocityError is returned for a payment initialization refused by a velocity
+// rule. It matches ErrPermission for blocking rules and ErrRateLimited otherwise.
+type VelocityError struct {
+	Violation *VelocityViolation
+}
+
+// Error implements the error interface
+func (e *VelocityError) Error() string {
+	return fmt.Sprintf("velocity rule %q exceeded: %d of %d", e.Violation.Rule.Name, e.Violation.Total, e.Violation.Rule.Limit)
+}
+
+// Is reports the error as ErrPermission or ErrRateLimited
+func (e *VelocityError) Is(target error) bool {
+	if e.Violation.Rule.Block {
+		return target == ErrPermission
+	}
+	return target == ErrRateLimited
+}
+
+// velocitySubjects returns the values a payment initialization is counted under
+func velocitySubjects(req *PaymentInitRequest) map[VelocitySubject]string {
+	subjects := map[VelocitySubject]string{
+		VelocityByMobile:       req.Mobile,
+		VelocityByNationalCode: req.NationalCode,
+		VelocityByClientIP:     req.ClientInfo.normalized().IP,
+	}
+	if req.ValidCardNumber != "" {
+		subjects[VelocityByCID] = HashCardNumber(req.ValidCardNumber)
+	}
+	return subjects
+}
+
+// velocityKey returns the counter key of a rule for a subject value in the window
+// containing now. Values are hashed so counters don't hold personal data.
+func velocityKey(rule VelocityRule, value string, now time.Time) string {
+	digest := sha256.Sum256([]byte(value))
+	window := now.UnixNano() / int64(rule.Window)
+	return fmt.Sprintf("vandar:velocity:%s:%d:%s", rule.Name, window, hex.EncodeToString(digest[:16]))
+}
+
+// windowRemaining returns how long until the window of a rule containing now ends
+func windowRemaining(rule VelocityRule, now time.Time) time.Duration {
+	elapsed := time.Duration(now.UnixNano() % int64(rule.Window))
+	return rule.Window - elapsed
+}
+
+// check evaluates the rules for a payment initialization at now. Nothing is
+// recorded when a rule is violated; otherwise the initialization is counted.
+func (v *VelocityChecker) check(ctx context.Context, now time.Time, req *PaymentInitRequest) (*VelocityViolation, error) {
+	subjects := velocitySubjects(req)
+
+	type increment struct {
+		key   string
+		delta int64
+		ttl   time.Duration
+	}
+	var increments []increment
+
+	for _, rule := range v.rules {
+		value := subjects[rule.Subject]
+		if value == "" {
+			continue
+		}
+
+		key := velocityKey(rule, value, now)
+		current, err := v.store.Get(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read velocity counter of rule %q: %w", rule.Name, err)
+		}
+
+		var delta int64
+		switch rule.Measure {
+		case VelocityInitiations:
+			delta = 1
+		case VelocityAmount:
+			delta = req.Amount
+		}
+
+		// Failed verifications refuse the card once the limit is reached
+		total := current + delta
+		if total > rule.Limit || (delta == 0 && current >= rule.Limit) {
+			return &VelocityViolation{
+				Rule:         rule,
+				Total:        total,
+				RetryAfter:   windowRemaining(rule, now),
+				Amount:       req.Amount,
+				Mobile:       req.Mobile,
+				ClientIP:     subjects[VelocityByClientIP],
+				CID:          subjects[VelocityByCID],
+				FactorNumber: req.FactorNumber,
+			}, nil
+		}
+
+		if delta != 0 {
+			increments = append(increments, increment{key: key, delta: delta, ttl: windowRemaining(rule, now)})
+		}
+	}
+
+	for _, inc := range increments {
+		if _, err := v.store.IncrBy(ctx, inc.key, inc.delta, inc.ttl); err != nil {
+			return nil, fmt.Errorf("failed to record velocity counter: %w", err)
+		}
+	}
+
+	return nil, nil
+}
+
+// recordFailedVerification counts a declined verification of a card
+func (v *VelocityChecker) recordFailedVerification(ctx context.Context, now time.Time, cid string) error {
+	for _, rule := range v.rules {
+		if rule.Measure != VelocityFailedVerifications {
+			continue
+		}
+		if _, err := v.store.IncrBy(ctx, velocityKey(rule, cid, now), 1, windowRemaining(rule, now)); err != nil {
+			return fmt.Errorf("failed to record failed verification of rule %q: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkVelocity evaluates the client's velocity rules for a payment
+// initialization. A failing counter store lets the payment through.
+func (c *Client) checkVelocity(ctx context.Context, req *PaymentInitRequest) error {
+	if c.velocity == nil {
+		return nil
+	}
+
+	violation, err := c.velocity.check(ctx, c.clock.Now(), req)
+	if err != nil {
+		c.log(ctx).Error(ctx, "Velocity check failed", err, c.paymentInitLogFields(req))
+		return nil
+	}
+	if violation == nil {
+		return nil
+	}
+
+	c.metrics.IncCounter(MetricVelocityViolations, map[string]string{"rule": violation.Rule.Name})
+
+	fields := c.paymentInitLogFields(req)
+	fields["rule"] = violation.Rule.Name
+	fields["total"] = violation.Total
+	if violation.ClientIP != "" {
+		fields["client_ip"] = violation.ClientIP
+	}
+	c.log(ctx).Warn(ctx, "Payment initialization refused by velocity rule", fields)
+
+	c.fireVelocityViolation(ctx, violation)
+
+	return &VelocityError{Violation: violation}
+}
+
+// recordVelocityFailure counts a declined verification against the card of a transaction
+func (c *Client) recordVelocityFailure(ctx context.Context, transaction *Transaction) {
+	if c.velocity == nil || transaction.CID == "" {
+		return
+	}
+
+	if err := c.velocity.recordFailedVerification(ctx, c.clock.Now(), normalizeCID(transaction.CID)); err != nil {
+		c.log(ctx).Error(ctx, "Failed to record failed verification", err, transactionLogFields(transaction))
+	}
+}
@@ -0,0 +1,231 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// velocityClient creates a client checking rules against a simulator, with a
+// fake clock at the start of an hour
+func velocityClient(t *testing.T, transport HTTPClientInterface, rules ...VelocityRule) (*Client, *FakeClock, *[]*VelocityViolation) {
+	t.Helper()
+
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	checker, err := NewVelocityChecker(NewMemoryVelocityStore(clock), rules...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var violations []*VelocityViolation
+	client, _, _ := newTestClient(t, testConfig(t), transport, WithClientClock(clock), WithClientVelocityChecker(checker), WithClientHooks(Hooks{
+		OnVelocityViolation: func(ctx context.Context, violation *VelocityViolation) {
+			violations = append(violations, violation)
+		},
+	}))
+	return client, clock, &violations
+}
+
+func TestVelocityInitiationsPerMobile(t *testing.T) {
+	client, clock, violations := velocityClient(t, NewSimulatorTransport(), VelocityRule{
+		Name: "mobile-hourly", Subject: VelocityByMobile, Measure: VelocityInitiations, Limit: 3, Window: time.Hour,
+	})
+	initiate := func(mobile string) error {
+		_, err := client.InitiatePaymentWithRequest(context.Background(), &PaymentInitRequest{Amount: 10000, Mobile: mobile}, nil)
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := initiate("09123456789"); err != nil {
+			t.Fatalf("initiation %d: %v", i+1, err)
+		}
+	}
+	clock.Advance(20 * time.Minute)
+	err := initiate("09123456789")
+	var velocityErr *VelocityError
+	if !errors.As(err, &velocityErr) || !errors.Is(err, ErrRateLimited) || errors.Is(err, ErrPermission) {
+		t.Fatalf("fourth initiation: %v", err)
+	}
+	if violation := velocityErr.Violation; violation.Total != 4 || violation.RetryAfter != 40*time.Minute || violation.Mobile != "09123456789" {
+		t.Fatalf("violation %+v", violation)
+	}
+	if len(*violations) != 1 || (*violations)[0].Rule.Name != "mobile-hourly" {
+		t.Fatalf("hook called with %v", *violations)
+	}
+
+	// Other mobile numbers and requests without one aren't limited
+	if err := initiate("09350000000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := initiate(""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refused initiations aren't counted, and the next window starts over
+	clock.Advance(40 * time.Minute)
+	for i := 0; i < 3; i++ {
+		if err := initiate("09123456789"); err != nil {
+			t.Fatalf("next window, initiation %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestVelocityAmountPerClientIP(t *testing.T) {
+	client, clock, violations := velocityClient(t, NewSimulatorTransport(), VelocityRule{
+		Name: "ip-daily-amount", Subject: VelocityByClientIP, Measure: VelocityAmount, Limit: 250000, Window: 24 * time.Hour,
+	})
+	handler := client.Handler()
+	initiate := func(ip string, amount int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/init", strings.NewReader(fmt.Sprintf(`{"amount":%d,"callback_url":"https://shop.example.com/callback","description":"Order 1042"}`, amount)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testAPIKey)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, amount := range []int64{100000, 100000} {
+		if rec := initiate("203.0.113.5", amount); rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	rec := initiate("203.0.113.5", 60000)
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), VelocityLimitCode) {
+		t.Fatalf("over the limit: status %d: %s", rec.Code, rec.Body)
+	}
+	if len(*violations) != 1 || (*violations)[0].ClientIP != "203.0.113.5" || (*violations)[0].Amount != 60000 {
+		t.Fatalf("hook called with %+v", *violations)
+	}
+
+	// What is left of the limit can still be used
+	if rec := initiate("203.0.113.5", 50000); rec.Code != http.StatusOK {
+		t.Fatalf("within the limit: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := initiate("198.51.100.5", 200000); rec.Code != http.StatusOK {
+		t.Fatalf("other IP: status %d: %s", rec.Code, rec.Body)
+	}
+
+	clock.Advance(24 * time.Hour)
+	if rec := initiate("203.0.113.5", 200000); rec.Code != http.StatusOK {
+		t.Fatalf("next day: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestVelocityFailedVerificationsPerCID(t *testing.T) {
+	const card = "6037991234567890"
+	declined := jsonStep(http.StatusUnprocessableEntity, map[string]interface{}{"status": 0, "message": "token is expired"})
+	client, clock, violations := velocityClient(t, newStubTransport(declined), VelocityRule{
+		Name: "card-failures", Subject: VelocityByCID, Measure: VelocityFailedVerifications, Limit: 2, Window: time.Hour, Block: true,
+	})
+	ctx := context.Background()
+
+	// Two declined verifications of the card reach the limit
+	for i := 0; i < 2; i++ {
+		token := fmt.Sprintf("sim0000000000000010%d", i)
+		client.storage.StoreTransaction(ctx, &Transaction{ID: token, Token: token, Amount: 10000, Status: StatusInit, CID: strings.ToUpper(HashCardNumber(card)), CreatedAt: clock.Now()})
+		if _, err := client.VerifyPayment(ctx, token); !errors.Is(err, ErrVerificationFailed) {
+			t.Fatalf("verification %d: %v", i+1, err)
+		}
+	}
+
+	client = client.Clone(WithClientHTTPClient(NewSimulatorTransport()))
+	_, err := client.InitiatePaymentWithRequest(ctx, &PaymentInitRequest{Amount: 10000, ValidCardNumber: card}, nil)
+	if !errors.Is(err, ErrPermission) || errors.Is(err, ErrRateLimited) {
+		t.Fatalf("initiation with the card: %v", err)
+	}
+	if len(*violations) != 1 || (*violations)[0].CID != HashCardNumber(card) {
+		t.Fatalf("hook called with %+v", *violations)
+	}
+
+	// Other cards pass, and so does the card once the window ends
+	if _, err := client.InitiatePaymentWithRequest(ctx, &PaymentInitRequest{Amount: 10000, ValidCardNumber: "6219861034529007"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := client.InitiatePaymentWithRequest(ctx, &PaymentInitRequest{Amount: 10000, ValidCardNumber: card}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingVelocityStore is a VelocityStore that is down
+type failingVelocityStore struct{}
+
+func (failingVelocityStore) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (failingVelocityStore) Get(ctx context.Context, key string) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestVelocityStoreFailureAllowsPayments(t *testing.T) {
+	checker, err := NewVelocityChecker(failingVelocityStore{}, VelocityRule{
+		Name: "mobile-hourly", Subject: VelocityByMobile, Measure: VelocityInitiations, Limit: 1, Window: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _, logger := newTestClient(t, testConfig(t), NewSimulatorTransport(), WithClientVelocityChecker(checker))
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.InitiatePaymentWithRequest(context.Background(), &PaymentInitRequest{Amount: 10000, Mobile: "09123456789"}, nil); err != nil {
+			t.Fatalf("initiation %d: %v", i+1, err)
+		}
+	}
+	if _, found := logger.find("Velocity check failed"); !found {
+		t.Fatalf("store failure not logged:\n%s", logger.dump())
+	}
+}
+
+func TestMemoryVelocityStoreExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	store := NewMemoryVelocityStore(clock)
+	ctx := context.Background()
+
+	store.IncrBy(ctx, "a", 2, time.Minute)
+	if value, _ := store.IncrBy(ctx, "a", 3, time.Hour); value != 5 {
+		t.Fatalf("value %d, want 5", value)
+	}
+
+	// The TTL is set when the counter is created
+	clock.Advance(time.Minute)
+	if value, _ := store.Get(ctx, "a"); value != 0 {
+		t.Fatalf("expired counter read as %d", value)
+	}
+	if value, _ := store.IncrBy(ctx, "a", 1, time.Minute); value != 1 {
+		t.Fatalf("expired counter incremented to %d", value)
+	}
+}
+
+func TestVelocityRuleValidation(t *testing.T) {
+	valid := VelocityRule{Name: "rule", Subject: VelocityByMobile, Measure: VelocityInitiations, Limit: 1, Window: time.Hour}
+	tests := []struct {
+		name   string
+		mutate func(*VelocityRule)
+	}{
+		{"no name", func(r *VelocityRule) { r.Name = "" }},
+		{"bad name", func(r *VelocityRule) { r.Name = "rule:1" }},
+		{"unknown subject", func(r *VelocityRule) { r.Subject = "email" }},
+		{"unknown measure", func(r *VelocityRule) { r.Measure = "refunds" }},
+		{"failures per mobile", func(r *VelocityRule) { r.Measure = VelocityFailedVerifications }},
+		{"no limit", func(r *VelocityRule) { r.Limit = 0 }},
+		{"no window", func(r *VelocityRule) { r.Window = 0 }},
+	}
+
+	for _, tt := range tests {
+		rule := valid
+		tt.mutate(&rule)
+		if _, err := NewVelocityChecker(nil, rule); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+
+	if _, err := NewVelocityChecker(nil, valid, valid); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("duplicate rules: %v", err)
+	}
+}
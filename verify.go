@@ -0,0 +1,66 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// verify.go implements verification with optional transaction info enrichment
+package vandargo
+
+import (
+	"context"
+	"fmt"
+)
+
+// verifyOptions holds per-call verification settings
+type verifyOptions struct {
+	enrich bool
+}
+
+// VerifyOption configures a single VerifyPaymentDetailed call
+type VerifyOption func(*verifyOptions)
+
+// WithEnrichment overrides Config.EnrichAfterVerify for a single call
+func WithEnrichment(enabled bool) VerifyOption {
+	return func(o *verifyOptions) {
+		o.enrich = enabled
+	}
+}
+
+// VerifyPaymentDetailed verifies a payment and, when enrichment is enabled, fetches
+// the transaction info for the same token to fill in tracking code, ref number and wages.
+// Enrichment failures never fail the verification; the base result is returned with Enriched unset.
+func (c *Client) VerifyPaymentDetailed(ctx context.Context, token string, opts ...VerifyOption) (*VerifyResult, error) {
+	options := verifyOptions{
+		enrich: configValues(c.config).EnrichAfterVerify,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	resp, err := c.VerifyPayment(ctx, token)
+	if resp == nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{PaymentVerifyResponse: *resp}
+	if err != nil || !options.enrich {
+		return result, err
+	}
+
+	// Fetch transaction info; this also merges it into the stored transaction
+	info, infoErr := c.GetTransactionInfo(ctx, token)
+	if infoErr == nil && info.Status != 1 {
+		infoErr = fmt.Errorf("transaction info returned status %d: %s", info.Status, info.Message)
+	}
+	if infoErr != nil {
+		c.log(ctx).Warn(ctx, "Failed to enrich verification result", map[string]interface{}{
+			"token": redactToken(token),
+			"error": infoErr.Error(),
+		})
+		return result, nil
+	}
+
+	result.TrackingCode = info.TrackingCode
+	result.RefNumber = info.RefNumber
+	result.Wage = info.Wage.Int64()
+	result.ShaparakWage = info.ShaparakWage.Int64()
+	result.Enriched = true
+
+	return result, nil
+}
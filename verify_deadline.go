@@ -0,0 +1,192 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// verify_deadline.go implements the alarm for paid payments left unverified
+package vandargo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultVerifyWarningThreshold is used when Config.VerifyWarningThreshold is not set.
+// Vandar reverses payments left unverified for about 30 minutes.
+const defaultVerifyWarningThreshold = 10 * time.Minute
+
+const (
+	// CallbackSucceededMetadataKey is the transaction metadata key recording when
+	// the gateway first reported the payment successful, in RFC 3339 format
+	CallbackSucceededMetadataKey = "callback_succeeded_at"
+
+	// VerificationAtRiskMetadataKey is the transaction metadata key recording when
+	// the payment was reported at risk of reversal, in RFC 3339 format
+	VerificationAtRiskMetadataKey = "verification_at_risk_at"
+)
+
+// verifyWarningThreshold returns how long a paid payment may stay unverified
+// before it is reported at risk
+func (c *Client) verifyWarningThreshold() time.Duration {
+	if threshold := configValues(c.config).VerifyWarningThreshold; threshold > 0 {
+		return threshold
+	}
+	return defaultVerifyWarningThreshold
+}
+
+// callbackSucceededAt returns when the gateway reported a transaction paid, or
+// false when no successful callback was recorded
+func callbackSucceededAt(transaction *Transaction) (time.Time, bool) {
+	value, ok := transaction.Metadata[CallbackSucceededMetadataKey]
+	if !ok {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// awaitsVerification reports whether a transaction was paid but not verified yet
+func awaitsVerification(transaction *Transaction) bool {
+	if transaction.Status == StatusVerifyPending {
+		return true
+	}
+	if transaction.Status != StatusInit {
+		return false
+	}
+	_, ok := callbackSucceededAt(transaction)
+	return ok
+}
+
+// unverifiedSince returns when a transaction awaiting verification was paid. A
+// VERIFY_PENDING transaction without a recorded callback counts from its creation.
+func unverifiedSince(transaction *Transaction) time.Time {
+	if paidAt, ok := callbackSucceededAt(transaction); ok {
+		return paidAt
+	}
+	return transaction.CreatedAt
+}
+
+// isVerificationAtRisk reports whether a paid transaction has stayed unverified
+// past the threshold and hasn't been reported yet
+func (c *Client) isVerificationAtRisk(transaction *Transaction, now time.Time) bool {
+	if _, reported := transaction.Metadata[VerificationAtRiskMetadataKey]; reported {
+		return false
+	}
+	if !awaitsVerification(transaction) {
+		return false
+	}
+	return now.Sub(unverifiedSince(transaction)) >= c.verifyWarningThreshold()
+}
+
+// unverifiedTransactions lists the stored transactions that may await verification
+func (c *Client) unverifiedTransactions(ctx context.Context) ([]*Transaction, error) {
+	transactions, err := c.storage.GetTransactionsByStatus(ctx, string(StatusInit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending transactions: %w", err)
+	}
+	indeterminate, err := c.storage.GetTransactionsByStatus(ctx, string(StatusVerifyPending))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending transactions: %w", err)
+	}
+	return append(transactions, indeterminate...), nil
+}
+
+// markVerificationAtRisk records that a transaction was reported at risk. The
+// transaction is read again under the token lock, and false is returned when a
+// concurrent verification or callback changed it or another check reported it.
+func (c *Client) markVerificationAtRisk(ctx context.Context, transaction *Transaction, now time.Time) (bool, error) {
+	release := c.tokenLocks.Lock(transaction.Token)
+	defer release()
+
+	current, err := c.storage.GetTransaction(ctx, transaction.Token)
+	if err != nil {
+		return false, err
+	}
+	if !c.isVerificationAtRisk(current, now) {
+		return false, nil
+	}
+	*transaction = *current
+
+	patch := TransactionPatch{Metadata: map[string]string{
+		VerificationAtRiskMetadataKey: now.UTC().Format(time.RFC3339Nano),
+	}}
+	c.applyPatch(&patch, transaction)
+
+	if err := c.patchTransaction(ctx, transaction.Token, patch); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CheckVerificationDeadlines reports stored payments whose callback succeeded, or
+// whose verification had an unknown outcome, and which are still unverified
+// VerifyWarningThreshold later, before the gateway reverses them. Each one is
+// logged, counted and passed to the OnVerificationAtRisk hook once. It returns
+// how many were reported.
+func (c *Client) CheckVerificationDeadlines(ctx context.Context) (int, error) {
+	transactions, err := c.unverifiedTransactions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := c.clock.Now()
+	reported := 0
+	for _, transaction := range transactions {
+		if err := ctx.Err(); err != nil {
+			return reported, err
+		}
+		if !c.isVerificationAtRisk(transaction, now) {
+			continue
+		}
+
+		marked, err := c.markVerificationAtRisk(ctx, transaction, now)
+		if err != nil {
+			c.log(ctx).Error(ctx, "Failed to mark verification at risk", err, transactionLogFields(transaction))
+			continue
+		}
+		if !marked {
+			continue
+		}
+
+		fields := transactionLogFields(transaction)
+		fields["unverified_for"] = now.Sub(unverifiedSince(transaction)).Round(time.Second).String()
+		c.log(ctx).Warn(ctx, "Paid transaction still unverified, gateway may reverse it", fields)
+		c.metrics.IncCounter(MetricVerificationAtRisk, nil)
+		c.fireVerificationAtRisk(ctx, transaction)
+		reported++
+	}
+
+	return reported, nil
+}
+
+// VerifyPending verifies stored payments whose callback succeeded but which are
+// still unverified, and VERIFY_PENDING payments whose verification had an unknown
+// outcome, e.g. from the OnVerificationAtRisk hook or after an outage. Failed
+// verifications are logged and skipped. It returns how many were verified.
+func (c *Client) VerifyPending(ctx context.Context) (int, error) {
+	transactions, err := c.unverifiedTransactions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	verified := 0
+	for _, transaction := range transactions {
+		if err := ctx.Err(); err != nil {
+			return verified, err
+		}
+		if !awaitsVerification(transaction) {
+			continue
+		}
+
+		if _, err := c.VerifyPayment(ctx, transaction.Token); err != nil {
+			c.log(ctx).Warn(ctx, "Failed to verify pending transaction", map[string]interface{}{
+				"token": redactToken(transaction.Token),
+				"error": err.Error(),
+			})
+			continue
+		}
+		verified++
+	}
+
+	return verified, nil
+}
@@ -0,0 +1,119 @@
+package vandargo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storeUnverifiedPayment stores a payment whose callback succeeded at paidAt
+func storeUnverifiedPayment(t *testing.T, storage *MemoryStorage, token string, status TransactionStatus, paidAt time.Time) {
+	t.Helper()
+
+	err := storage.StoreTransaction(context.Background(), &Transaction{
+		ID:        "tx-" + token,
+		Token:     token,
+		Amount:    100000,
+		Status:    status,
+		CreatedAt: paidAt.Add(-time.Minute),
+		Metadata:  map[string]string{CallbackSucceededMetadataKey: paidAt.UTC().Format(time.RFC3339Nano)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deadlineClient returns a client on a fake clock with a 10 minute warning
+// threshold, recording the tokens passed to the OnVerificationAtRisk hook
+func deadlineClient(t *testing.T) (*Client, *MemoryStorage, *FakeClock, *recordingMetrics, func() []string) {
+	t.Helper()
+
+	var mutex sync.Mutex
+	var atRisk []string
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	metrics := newRecordingMetrics()
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.VerifyWarningThreshold = 10 * time.Minute
+	}), nil, WithClientClock(clock), WithClientMetrics(metrics), WithClientHooks(Hooks{
+		OnVerificationAtRisk: func(ctx context.Context, transaction *Transaction) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			atRisk = append(atRisk, transaction.Token)
+		},
+	}))
+
+	reported := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), atRisk...)
+	}
+	return client, storage, clock, metrics, reported
+}
+
+func TestCheckVerificationDeadlines(t *testing.T) {
+	client, storage, clock, metrics, reported := deadlineClient(t)
+	storeUnverifiedPayment(t, storage, "tok-paid", StatusInit, clock.Now())
+	storeUnverifiedPayment(t, storage, "tok-verified", StatusPaid, clock.Now())
+
+	// Below the threshold nothing is reported
+	clock.Advance(10*time.Minute - time.Second)
+	count, err := client.CheckVerificationDeadlines(context.Background())
+	if err != nil || count != 0 {
+		t.Fatalf("reported %d below the threshold: %v", count, err)
+	}
+
+	// At the threshold the unverified payment is reported, the verified one isn't
+	clock.Advance(time.Second)
+	count, err = client.CheckVerificationDeadlines(context.Background())
+	if err != nil || count != 1 {
+		t.Fatalf("reported %d at the threshold: %v", count, err)
+	}
+	if got := reported(); len(got) != 1 || got[0] != "tok-paid" {
+		t.Fatalf("hook called for %v", got)
+	}
+	if metrics.counter(MetricVerificationAtRisk) != 1 {
+		t.Fatalf("at-risk counter %d", metrics.counter(MetricVerificationAtRisk))
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), "tok-paid")
+	if transaction.Metadata[VerificationAtRiskMetadataKey] != clock.Now().UTC().Format(time.RFC3339Nano) {
+		t.Fatalf("metadata %v", transaction.Metadata)
+	}
+
+	// An already reported payment is not reported again
+	clock.Advance(time.Minute)
+	count, err = client.CheckVerificationDeadlines(context.Background())
+	if err != nil || count != 0 {
+		t.Fatalf("reported %d again: %v", count, err)
+	}
+}
+
+func TestCheckVerificationDeadlinesVerifyPending(t *testing.T) {
+	client, storage, clock, _, reported := deadlineClient(t)
+	storeUnverifiedPayment(t, storage, "tok-pending", StatusVerifyPending, clock.Now())
+
+	// A VERIFY_PENDING payment without a callback counts from its creation
+	err := storage.StoreTransaction(context.Background(), &Transaction{
+		ID:        "tx-no-callback",
+		Token:     "tok-no-callback",
+		Amount:    100000,
+		Status:    StatusVerifyPending,
+		CreatedAt: clock.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An INIT payment without a callback was never paid
+	storeWebhookPayment(t, storage, StatusInit)
+
+	clock.Advance(10 * time.Minute)
+	count, err := client.CheckVerificationDeadlines(context.Background())
+	if err != nil || count != 2 {
+		t.Fatalf("reported %d VERIFY_PENDING payments: %v", count, err)
+	}
+	if got := reported(); len(got) != 2 {
+		t.Fatalf("hook called for %v", got)
+	}
+}
@@ -0,0 +1,301 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// verify_failure.go implements the classification of failed verifications and their retry
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultVerifyRetryDelay is used when Config.VerifyRetryDelay is not set
+const defaultVerifyRetryDelay = 30 * time.Second
+
+// VerifyFailureReason classifies why a verification failed
+type VerifyFailureReason string
+
+const (
+	// VerifyDeclined means the gateway refused the verification, e.g. because the
+	// token is invalid or expired; the payment is marked FAILED
+	VerifyDeclined VerifyFailureReason = "declined"
+
+	// VerifyAlreadyVerified means the gateway had verified the payment before. It
+	// is only reported when the transaction info confirming it can't be fetched.
+	VerifyAlreadyVerified VerifyFailureReason = "already_verified"
+
+	// VerifyIndeterminate means the outcome is unknown, e.g. after a timeout, a
+	// network failure or a 5xx response; the money may have moved
+	VerifyIndeterminate VerifyFailureReason = "indeterminate"
+)
+
+// alreadyVerifiedPhrases are the gateway messages for a payment verified before,
+// after Arabic letter forms are normalized
+var alreadyVerifiedPhrases = []string{
+	"already verified",
+	"قبلا تایید",
+	"قبلاً تایید",
+	"قبلا وریفای",
+	"قبلاً وریفای",
+}
+
+// VerifyError is returned when a verification fails. It matches
+// ErrVerificationFailed when the gateway declined the payment.
+type VerifyError struct {
+	// Reason classifies the failure
+	Reason VerifyFailureReason
+
+	// RetryScheduled reports whether the verification will be retried after
+	// Config.VerifyRetryDelay
+	RetryScheduled bool
+
+	// Err is the underlying error
+	Err error
+}
+
+// Error implements the error interface
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("verification %s: %v", e.Reason, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports a declined verification as ErrVerificationFailed
+func (e *VerifyError) Is(target error) bool {
+	return target == ErrVerificationFailed && e.Reason == VerifyDeclined
+}
+
+// VerifyFailureReasonOf returns the classification of a verification error, or
+// an empty reason when err isn't one
+func VerifyFailureReasonOf(err error) VerifyFailureReason {
+	var verifyErr *VerifyError
+	if errors.As(err, &verifyErr) {
+		return verifyErr.Reason
+	}
+	return ""
+}
+
+// isAlreadyVerifiedMessage reports whether a gateway message says the payment
+// was verified before
+func isAlreadyVerifiedMessage(messages ...string) bool {
+	for _, message := range messages {
+		message = persianLetters.Replace(strings.ToLower(message))
+		for _, phrase := range alreadyVerifiedPhrases {
+			if strings.Contains(message, phrase) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// classifyVerifyRequestError classifies a verify request that failed with the
+// given HTTP status code. Only client errors the gateway explained decline the
+// payment; anything else may have reached the gateway and is indeterminate.
+func classifyVerifyRequestError(statusCode int, err error) VerifyFailureReason {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) ||
+		statusCode < 400 || statusCode >= 500 ||
+		statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests {
+		return VerifyIndeterminate
+	}
+
+	messages := []string{apiErr.Message}
+	for _, message := range apiErr.Errors {
+		messages = append(messages, message)
+	}
+	if isAlreadyVerifiedMessage(messages...) {
+		return VerifyAlreadyVerified
+	}
+	return VerifyDeclined
+}
+
+// classifyVerifyResponse classifies a verify response whose status isn't 1
+func classifyVerifyResponse(resp *PaymentVerifyResponse) VerifyFailureReason {
+	messages := []string{resp.Message}
+	for _, message := range resp.Errors {
+		messages = append(messages, message)
+	}
+	if isAlreadyVerifiedMessage(messages...) {
+		return VerifyAlreadyVerified
+	}
+	return VerifyDeclined
+}
+
+// verifyResponseFromInfo builds the verification result of a payment the gateway
+// had verified before from its transaction info
+func verifyResponseFromInfo(info *TransactionInfoResponse) *PaymentVerifyResponse {
+	resp := &PaymentVerifyResponse{
+		Status:          1,
+		Amount:          strconv.FormatInt(info.Amount.Int64(), 10),
+		TransID:         info.TransID,
+		FactorNumber:    info.FactorNumber,
+		Mobile:          info.Mobile,
+		Description:     info.Description,
+		CardNumber:      info.CardNumber,
+		PaymentDate:     info.PaymentDate,
+		CID:             info.CID,
+		Message:         info.Message,
+		AlreadyVerified: true,
+	}
+	if net := info.Net(); net > 0 {
+		resp.RealAmount = net
+	}
+	return resp
+}
+
+// verifyAlreadyVerified completes the verification of a payment the gateway had
+// verified before, e.g. when the first response was lost, from its transaction
+// info. It is called with the token lock held.
+func (c *Client) verifyAlreadyVerified(ctx context.Context, token string, cause error) (*PaymentVerifyResponse, error) {
+	// A cached lookup may predate the verification
+	c.invalidateCache(ctx, token)
+
+	// The echo of the result is checked by VerifyPayment once the lock is released
+	info, err := c.transactionInfo(ctx, token)
+	if err == nil && info.Status != 1 {
+		err = fmt.Errorf("transaction info returned status %d: %s", info.Status, info.Message)
+	}
+	if err != nil {
+		return nil, c.verifyFailed(ctx, token, VerifyIndeterminate, fmt.Errorf("%w; confirming it failed: %w", cause, err))
+	}
+
+	c.log(ctx).Info(ctx, "Payment was verified before, recording it from transaction info", map[string]interface{}{
+		"token": redactToken(token),
+	})
+
+	resp := verifyResponseFromInfo(info)
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode verification result: %w", err)
+	}
+	c.recordVerification(ctx, token, resp, body)
+
+	return resp, nil
+}
+
+// verifyFailed records a failed verification and returns its VerifyError. A
+// declined payment is marked FAILED. A payment with an unknown outcome is marked
+// VERIFY_PENDING and retried once; when the retry's outcome is unknown too, it
+// stays VERIFY_PENDING and the OnVerificationIndeterminate hook is called. It is
+// called with the token lock held.
+func (c *Client) verifyFailed(ctx context.Context, token string, reason VerifyFailureReason, err error) error {
+	verifyErr := &VerifyError{Reason: reason, Err: err}
+	c.metrics.IncCounter(MetricVerifyFailures, map[string]string{"reason": string(reason)})
+
+	// The state must follow the gateway even if the caller gave up
+	storeCtx := context.WithoutCancel(ctx)
+
+	transaction, getErr := c.storage.GetTransaction(storeCtx, token)
+	if getErr != nil {
+		return verifyErr
+	}
+
+	switch reason {
+	case VerifyDeclined:
+		c.recordVerifyOutcome(storeCtx, transaction, StatusFailed)
+		c.recordVelocityFailure(storeCtx, transaction)
+	case VerifyIndeterminate:
+		if transaction.Status == StatusVerifyPending {
+			c.log(ctx).Error(ctx, "Verification outcome still unknown after retry, reconcile the payment", err, transactionLogFields(transaction))
+			c.fireVerificationIndeterminate(ctx, transaction, verifyErr)
+			break
+		}
+		if c.recordVerifyOutcome(storeCtx, transaction, StatusVerifyPending) {
+			verifyErr.RetryScheduled = c.scheduleReverify(ctx, token)
+		}
+	}
+
+	return verifyErr
+}
+
+// recordVerifyOutcome moves a transaction to the status a failed verification
+// implies, reporting whether it moved
+func (c *Client) recordVerifyOutcome(ctx context.Context, transaction *Transaction, status TransactionStatus) bool {
+	if !transaction.Status.CanTransitionTo(status) {
+		return false
+	}
+
+	previousStatus := transaction.Status
+	patch := TransactionPatch{Status: &status}
+	if status.IsTerminal() {
+		completedAt := c.clock.Now()
+		patch.CompletedAt = &completedAt
+	}
+	c.applyPatch(&patch, transaction)
+
+	if err := c.patchTransaction(ctx, transaction.Token, patch); err != nil {
+		c.log(ctx).Error(ctx, "Failed to update transaction", err, transactionLogFields(transaction))
+		return false
+	}
+	c.invalidateCache(ctx, transaction.Token)
+
+	c.fireStatusChange(ctx, transaction, previousStatus)
+	return true
+}
+
+// verifyRetryDelay returns how long to wait before retrying a verification with
+// an unknown outcome, or a negative value when retries are disabled
+func (c *Client) verifyRetryDelay() time.Duration {
+	if delay := configValues(c.config).VerifyRetryDelay; delay != 0 {
+		return delay
+	}
+	return defaultVerifyRetryDelay
+}
+
+// scheduleReverify verifies a token again after VerifyRetryDelay, reporting
+// whether a retry was scheduled. A retry due after shutdown fails with
+// ErrShuttingDown; VerifyPending picks the payment up later.
+func (c *Client) scheduleReverify(ctx context.Context, token string) bool {
+	delay := c.verifyRetryDelay()
+	if delay < 0 {
+		return false
+	}
+
+	// The retry is its own operation and outlives the caller
+	retryCtx := context.WithValue(context.WithoutCancel(ctx), inflightKey, nil)
+	untrack := c.health.trackRetry()
+	go func() {
+		defer untrack()
+		<-c.clock.After(delay)
+
+		if _, err := c.VerifyPayment(retryCtx, token); err != nil {
+			c.log(retryCtx).Warn(retryCtx, "Verification retry failed", map[string]interface{}{
+				"token":  redactToken(token),
+				"reason": string(VerifyFailureReasonOf(err)),
+				"error":  err.Error(),
+			})
+		}
+	}()
+
+	return true
+}
+
+// respondVerifyError responds to a failed verification: a declined payment with
+// the gateway's reason, other failures with the upstream error and their
+// classification
+func (c *Client) respondVerifyError(w http.ResponseWriter, r *http.Request, token string, err error, message string) {
+	if errors.Is(err, ErrVerificationFailed) {
+		c.respondWithError(w, err, message)
+		return
+	}
+
+	respErr := upstreamError(err)
+	var verifyErr *VerifyError
+	if errors.As(err, &verifyErr) {
+		respErr = &VerifyError{Reason: verifyErr.Reason, RetryScheduled: verifyErr.RetryScheduled, Err: respErr}
+	}
+
+	ctx := r.Context()
+	c.respondWithError(w, respErr, "Failed to verify payment")
+	c.log(ctx).Error(ctx, "Failed to verify payment", err, map[string]interface{}{
+		"token": redactToken(token),
+	})
+}
@@ -0,0 +1,265 @@
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// faultyVerifyGateway is a simulator whose first verify requests fail with fault
+func faultyVerifyGateway(sim *SimulatorTransport, failures int32, fault func(req *http.Request) (*http.Response, error)) (transportFunc, *atomic.Int32) {
+	var verifies atomic.Int32
+	return func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, DefaultEndpoints(APIVersionV4).Verify) && verifies.Add(1) <= failures {
+			return fault(req)
+		}
+		return sim.Do(req)
+	}, &verifies
+}
+
+// waitForStatus waits for a background verification to move a payment to status
+func waitForStatus(t *testing.T, storage *MemoryStorage, token string, status TransactionStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		transaction, err := storage.GetTransaction(context.Background(), token)
+		if err == nil && transaction.Status == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("transaction %+v, %v; want %s", transaction, err, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestVerifyFailureClasses(t *testing.T) {
+	unavailable := func(req *http.Request) (*http.Response, error) {
+		return stubResponse(req, http.StatusServiceUnavailable, map[string]interface{}{"status": 0, "message": "maintenance"}), nil
+	}
+	alreadyVerified := func(req *http.Request) (*http.Response, error) {
+		return stubResponse(req, http.StatusUnprocessableEntity, map[string]interface{}{"status": 0, "message": "تراکنش قبلا تایید شده است"}), nil
+	}
+	declined := func(req *http.Request) (*http.Response, error) {
+		return stubResponse(req, http.StatusUnprocessableEntity, map[string]interface{}{"status": 0, "message": "token is expired"}), nil
+	}
+
+	tests := []struct {
+		name      string
+		paidAfter int
+		fault     func(req *http.Request) (*http.Response, error)
+		reason    VerifyFailureReason
+		status    TransactionStatus
+		retry     bool
+	}{
+		{"unpaid payment", 5, nil, VerifyDeclined, StatusFailed, false},
+		{"expired token", 0, declined, VerifyDeclined, StatusFailed, false},
+		{"already verified", 0, alreadyVerified, "", StatusPaid, false},
+		{"gateway unavailable", 0, unavailable, VerifyIndeterminate, StatusVerifyPending, true},
+		{"network failure", 0, func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection reset by peer")
+		}, VerifyIndeterminate, StatusVerifyPending, true},
+		{"timeout", 0, func(*http.Request) (*http.Response, error) {
+			return nil, context.DeadlineExceeded
+		}, VerifyIndeterminate, StatusVerifyPending, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := NewSimulatorTransport(WithSimulatorPaidAfter(tt.paidAfter))
+			var transport HTTPClientInterface = sim
+			if tt.fault != nil {
+				transport, _ = faultyVerifyGateway(sim, 1, tt.fault)
+			}
+			metrics := newRecordingMetrics()
+			client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+				c.MaxRetries = 0
+				c.VerifyRetryDelay = time.Hour
+			}), transport, WithClientMetrics(metrics), WithClientClock(NewFakeClock(time.Now())))
+			token := initSimulatedPayment(t, client)
+
+			resp, err := client.VerifyPayment(context.Background(), token)
+			if reason := VerifyFailureReasonOf(err); reason != tt.reason {
+				t.Fatalf("reason %q (%v), want %q", reason, err, tt.reason)
+			}
+			if errors.Is(err, ErrVerificationFailed) != (tt.reason == VerifyDeclined) {
+				t.Fatalf("errors.Is(%v, ErrVerificationFailed) = %v", err, !(tt.reason == VerifyDeclined))
+			}
+			var verifyErr *VerifyError
+			if errors.As(err, &verifyErr) && verifyErr.RetryScheduled != tt.retry {
+				t.Fatalf("retry scheduled %v, want %v", verifyErr.RetryScheduled, tt.retry)
+			}
+
+			transaction, _ := storage.GetTransaction(context.Background(), token)
+			if transaction.Status != tt.status {
+				t.Fatalf("status %s, want %s", transaction.Status, tt.status)
+			}
+			if tt.reason == "" {
+				if resp == nil || !resp.AlreadyVerified || resp.TransID == 0 || transaction.TransactionID != resp.TransID {
+					t.Fatalf("already verified result %+v, transaction %+v", resp, transaction)
+				}
+				return
+			}
+			if metrics.counter(MetricVerifyFailures) != 1 {
+				t.Fatalf("%d verify failures counted", metrics.counter(MetricVerifyFailures))
+			}
+		})
+	}
+}
+
+func TestVerifyIndeterminateRetry(t *testing.T) {
+	for _, failures := range []int32{1, 2} {
+		t.Run(fmt.Sprintf("%d failures", failures), func(t *testing.T) {
+			clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+			transport, verifies := faultyVerifyGateway(NewSimulatorTransport(WithSimulatorPaidAfter(0)), failures, func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("connection reset by peer")
+			})
+			indeterminate := make(chan error, 1)
+			client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+				c.MaxRetries = 0
+				c.VerifyRetryDelay = 2 * time.Minute
+			}), transport, WithClientClock(clock), WithClientHooks(Hooks{
+				OnVerificationIndeterminate: func(ctx context.Context, transaction *Transaction, err error) {
+					indeterminate <- err
+				},
+			}))
+			token := initSimulatedPayment(t, client)
+
+			if _, err := client.VerifyPayment(context.Background(), token); VerifyFailureReasonOf(err) != VerifyIndeterminate {
+				t.Fatalf("VerifyPayment() error = %v", err)
+			}
+
+			// Nothing is retried before the delay
+			for clock.Waiters() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			clock.Advance(time.Minute)
+			if verifies.Load() != 1 {
+				t.Fatalf("%d verify requests before the delay", verifies.Load())
+			}
+			clock.Advance(time.Minute)
+
+			if failures == 1 {
+				waitForStatus(t, storage, token, StatusPaid)
+				if len(indeterminate) != 0 {
+					t.Fatal("OnVerificationIndeterminate called for a verified payment")
+				}
+				return
+			}
+
+			// A retry with an unknown outcome leaves the payment to a person
+			select {
+			case err := <-indeterminate:
+				if VerifyFailureReasonOf(err) != VerifyIndeterminate {
+					t.Fatalf("hook error %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("OnVerificationIndeterminate not called")
+			}
+			transaction, _ := storage.GetTransaction(context.Background(), token)
+			if transaction.Status != StatusVerifyPending || verifies.Load() != 2 {
+				t.Fatalf("status %s after %d verify requests", transaction.Status, verifies.Load())
+			}
+			if clock.Waiters() != 0 {
+				t.Fatal("a second retry was scheduled")
+			}
+		})
+	}
+}
+
+func TestVerifyRetryDisabled(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	transport, _ := faultyVerifyGateway(NewSimulatorTransport(WithSimulatorPaidAfter(0)), 1, func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection reset by peer")
+	})
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.MaxRetries = 0
+		c.VerifyRetryDelay = -1
+	}), transport, WithClientClock(clock))
+	token := initSimulatedPayment(t, client)
+
+	var verifyErr *VerifyError
+	if _, err := client.VerifyPayment(context.Background(), token); !errors.As(err, &verifyErr) || verifyErr.RetryScheduled {
+		t.Fatalf("VerifyPayment() error = %v", err)
+	}
+	if clock.Waiters() != 0 {
+		t.Fatal("retry scheduled while disabled")
+	}
+	transaction, _ := storage.GetTransaction(context.Background(), token)
+	if transaction.Status != StatusVerifyPending {
+		t.Fatalf("status %s", transaction.Status)
+	}
+}
+
+func TestVerifyHandlerReportsReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		fault  func(req *http.Request) (*http.Response, error)
+		reason string
+		retry  bool
+	}{
+		{"declined", func(req *http.Request) (*http.Response, error) {
+			return stubResponse(req, http.StatusUnprocessableEntity, map[string]interface{}{"status": 0, "message": "token is invalid"}), nil
+		}, "declined", false},
+		{"indeterminate", func(req *http.Request) (*http.Response, error) {
+			return stubResponse(req, http.StatusBadGateway, map[string]interface{}{"status": 0, "message": "bad gateway"}), nil
+		}, "indeterminate", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, _ := faultyVerifyGateway(NewSimulatorTransport(WithSimulatorPaidAfter(0)), 1, tt.fault)
+			client, _, _ := newTestClient(t, testConfig(t, func(c *Config) {
+				c.MaxRetries = 0
+				c.VerifyRetryDelay = time.Hour
+			}), transport, WithClientClock(NewFakeClock(time.Now())))
+			token := initSimulatedPayment(t, client)
+
+			rec := routeRequestBody(client.Handler(), http.MethodPost, "/payments/verify", `{"token":"`+token+`"}`)
+			var body struct {
+				Message        string `json:"message"`
+				Reason         string `json:"reason"`
+				RetryScheduled bool   `json:"retry_scheduled"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if rec.Code < 400 || body.Reason != tt.reason || body.RetryScheduled != tt.retry {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if tt.reason == "declined" && body.Message != "token is invalid" {
+				t.Fatalf("declined without the gateway's reason: %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestClassifyVerifyRequestError(t *testing.T) {
+	tests := []struct {
+		status int
+		err    error
+		want   VerifyFailureReason
+	}{
+		{http.StatusUnprocessableEntity, &APIError{Message: "token is expired"}, VerifyDeclined},
+		{http.StatusNotFound, &APIError{Message: "not found"}, VerifyDeclined},
+		{http.StatusUnprocessableEntity, &APIError{Message: "Transaction Already Verified"}, VerifyAlreadyVerified},
+		{http.StatusBadRequest, &APIError{Errors: map[string]string{"token": "قبلاً وریفای شده"}}, VerifyAlreadyVerified},
+		{http.StatusUnprocessableEntity, &APIError{Message: "\u0642\u0628\u0644\u0627 \u062a\u0627\u064a\u064a\u062f \u0634\u062f\u0647"}, VerifyAlreadyVerified}, // Arabic yeh
+		{http.StatusRequestTimeout, &APIError{Message: "timeout"}, VerifyIndeterminate},
+		{http.StatusTooManyRequests, &APIError{Message: "slow down"}, VerifyIndeterminate},
+		{http.StatusInternalServerError, &APIError{Message: "already verified"}, VerifyIndeterminate},
+		{0, errors.New("connection reset by peer"), VerifyIndeterminate},
+		{http.StatusUnprocessableEntity, errors.New("not an API error"), VerifyIndeterminate},
+	}
+
+	for _, tt := range tests {
+		if got := classifyVerifyRequestError(tt.status, fmt.Errorf("failed to verify payment: %w", tt.err)); got != tt.want {
+			t.Errorf("classifyVerifyRequestError(%d, %v) = %q, want %q", tt.status, tt.err, got, tt.want)
+		}
+	}
+}
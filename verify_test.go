@@ -0,0 +1,92 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestVerifyEnrichmentFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		info stubStep
+	}{
+		{"network error", stubStep{err: errors.New("connection reset")}},
+		{"declined", jsonStep(http.StatusOK, map[string]interface{}{"status": 0, "message": "not found"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newStubTransport(verifySuccess(), tt.info)
+			client, storage, logger := newTestClient(t, testConfig(t, func(c *Config) {
+				c.EnrichAfterVerify = true
+				c.MaxRetries = 0
+			}), transport)
+			storeWebhookPayment(t, storage, StatusInit)
+
+			// The verification stands without the details
+			result, err := client.VerifyPaymentDetailed(context.Background(), webhookToken)
+			if err != nil || result == nil || result.Enriched || result.TransID != 160000000001 {
+				t.Fatalf("VerifyPaymentDetailed() = %+v, %v", result, err)
+			}
+			if entry, found := logger.find("Failed to enrich"); !found || entry.level != "warn" {
+				t.Fatalf("enrichment failure not logged:\n%s", logger.dump())
+			}
+			if transaction, _ := storage.GetTransaction(context.Background(), webhookToken); transaction.Status != StatusPaid {
+				t.Fatalf("transaction %s", transaction.Status)
+			}
+		})
+	}
+}
+
+func TestVerifyEnrichmentOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		config bool
+		opts   []VerifyOption
+		calls  int
+	}{
+		{"off", false, nil, 1},
+		{"from config", true, nil, 2},
+		{"disabled per call", true, []VerifyOption{WithEnrichment(false)}, 1},
+		{"enabled per call", false, []VerifyOption{WithEnrichment(true)}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newStubTransport(verifySuccess(), jsonStep(http.StatusOK, map[string]interface{}{
+				"status": 1, "amount": "100000", "transId": 160000000001, "refnumber": "ref-1", "trackingCode": "212475",
+			}))
+			client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) { c.EnrichAfterVerify = tt.config }), transport)
+			storeWebhookPayment(t, storage, StatusInit)
+
+			result, err := client.VerifyPaymentDetailed(context.Background(), webhookToken, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if transport.count() != tt.calls || result.Enriched != (tt.calls == 2) {
+				t.Fatalf("%d gateway calls, enriched %v", transport.count(), result.Enriched)
+			}
+			if result.Enriched && (result.TrackingCode != "212475" || result.RefNumber != "ref-1") {
+				t.Fatalf("result %+v", result)
+			}
+		})
+	}
+}
+
+func TestVerifyEnrichmentSkippedOnFailure(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusUnprocessableEntity, map[string]interface{}{"status": 0, "errors": []string{"payment is not completed"}}))
+	client, storage, _ := newTestClient(t, testConfig(t, func(c *Config) {
+		c.EnrichAfterVerify = true
+		c.VerifyRetryDelay = -1
+	}), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	if _, err := client.VerifyPaymentDetailed(context.Background(), webhookToken); err == nil {
+		t.Fatal("unpaid payment verified")
+	}
+	if transport.count() != 1 {
+		t.Fatalf("%d gateway calls for a failed verification", transport.count())
+	}
+}
@@ -0,0 +1,184 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// wait.go implements blocking until a payment reaches a terminal state
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	// defaultWaitInterval is the time between polls of WaitForPayment
+	defaultWaitInterval = 2 * time.Second
+
+	// defaultWaitJitter is the fraction by which each poll interval is randomized
+	defaultWaitJitter = 0.2
+)
+
+// WaitOption configures WaitForPayment
+type WaitOption func(*waitOptions)
+
+// waitOptions holds the settings of WaitForPayment
+type waitOptions struct {
+	interval time.Duration
+	jitter   float64
+	backoff  BackoffStrategy
+	timeout  time.Duration
+	progress func(attempt int, result *PaymentResult, err error)
+}
+
+// WithWaitInterval sets the time between polls (2 seconds by default)
+func WithWaitInterval(interval time.Duration) WaitOption {
+	return func(o *waitOptions) {
+		if interval > 0 {
+			o.interval = interval
+		}
+	}
+}
+
+// WithWaitJitter randomizes each interval by up to the given fraction, e.g. 0.2 for
+// ±20% (the default), so many waiters don't poll in lockstep; 0 disables jitter
+func WithWaitJitter(fraction float64) WaitOption {
+	return func(o *waitOptions) {
+		if fraction >= 0 && fraction < 1 {
+			o.jitter = fraction
+		}
+	}
+}
+
+// WithWaitBackoff sets the strategy deciding the time between polls, replacing
+// the interval and jitter; a strategy reporting done ends the wait with ErrTimeout
+func WithWaitBackoff(strategy BackoffStrategy) WaitOption {
+	return func(o *waitOptions) {
+		o.backoff = strategy
+	}
+}
+
+// WithWaitTimeout bounds the whole wait (the token lifetime by default)
+func WithWaitTimeout(timeout time.Duration) WaitOption {
+	return func(o *waitOptions) {
+		if timeout > 0 {
+			o.timeout = timeout
+		}
+	}
+}
+
+// WithWaitProgress calls fn after every poll with the attempt number and either the
+// current result or the error of a failed poll
+func WithWaitProgress(fn func(attempt int, result *PaymentResult, err error)) WaitOption {
+	return func(o *waitOptions) {
+		o.progress = fn
+	}
+}
+
+// WaitForPayment polls a payment until it is paid, failed, expired or refunded and
+// returns the final result. Completed transactions in storage, e.g. updated by a
+// callback, are used without asking the gateway. Failed polls are retried. When ctx
+// or the wait timeout ends first, the last known result is returned with ErrTimeout.
+func (c *Client) WaitForPayment(ctx context.Context, token string, opts ...WaitOption) (*PaymentResult, error) {
+	if token == "" {
+		return nil, fmt.Errorf("%w: token is required", ErrInvalidRequest)
+	}
+
+	options := waitOptions{
+		interval: defaultWaitInterval,
+		jitter:   defaultWaitJitter,
+		timeout:  c.tokenLifetime(),
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	backoff := options.backoff
+	if backoff == nil {
+		backoff = &FixedBackoff{Delay: options.interval, Jitter: options.jitter}
+	}
+	backoff = backoffSequence(backoff)
+
+	ctx, cancel := context.WithTimeout(ctx, options.timeout)
+	defer cancel()
+
+	var last *PaymentResult
+	for attempt := 1; ; attempt++ {
+		result, err := c.pollPayment(ctx, token)
+		if options.progress != nil {
+			options.progress(attempt, result, err)
+		}
+
+		switch {
+		case err == nil:
+			last = result
+			if result.State.IsTerminal() {
+				return result, nil
+			}
+		case IsValidationError(err) || errors.Is(err, ErrInvalidRequest):
+			// Polling again won't fix a bad token
+			return nil, err
+		default:
+			c.log(ctx).Debug(ctx, "Payment poll failed, retrying", map[string]interface{}{
+				"token":   redactToken(token),
+				"attempt": attempt,
+				"error":   err.Error(),
+			})
+		}
+
+		delay, done := backoff.NextDelay(attempt, err)
+		if done {
+			return last, fmt.Errorf("%w: payment still pending after %d polls", ErrTimeout, attempt)
+		}
+
+		timer := c.clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return last, fmt.Errorf("%w: payment still pending after %d polls: %v", ErrTimeout, attempt, ctx.Err())
+		case <-timer.C():
+		}
+	}
+}
+
+// pollPayment returns the current state of a payment, preferring a completed
+// transaction in storage over a gateway status lookup
+func (c *Client) pollPayment(ctx context.Context, token string) (*PaymentResult, error) {
+	if transaction, err := c.storage.GetTransaction(ctx, token); err == nil && transaction.Status.IsTerminal() {
+		return paymentResultFromTransaction(transaction), nil
+	}
+
+	status, err := c.GetPaymentStatus(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return PaymentResultFromStatus(token, status), nil
+}
+
+// paymentResultFromTransaction normalizes a stored transaction
+func paymentResultFromTransaction(transaction *Transaction) *PaymentResult {
+	result := &PaymentResult{
+		Token:        transaction.Token,
+		State:        transaction.Status,
+		AmountRials:  transaction.Amount,
+		RefNumber:    transaction.RefNumber,
+		TrackingCode: transaction.TrackingCode,
+		TransID:      transaction.TransactionID,
+		CardMask:     transaction.CardNumber,
+	}
+
+	if transaction.Status == StatusPaid || transaction.Status == StatusSuspect {
+		result.PaidAt = transaction.CompletedAt
+	}
+
+	return result
+}
+
+// jitterInterval randomizes an interval by up to ±fraction
+func jitterInterval(interval time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return interval
+	}
+	delta := (rand.Float64()*2 - 1) * fraction * float64(interval)
+	return interval + time.Duration(delta)
+}
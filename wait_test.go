@@ -0,0 +1,122 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// initSimulatedPayment starts a payment on the client's simulator and returns its token
+func initSimulatedPayment(t *testing.T, client *Client) string {
+	t.Helper()
+
+	init, err := client.InitiatePayment(context.Background(), 100000, "Order 1042", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return init.Token
+}
+
+func TestWaitForPaymentSimulator(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(WithSimulatorPaidAfter(3)))
+	token := initSimulatedPayment(t, client)
+
+	var states []TransactionStatus
+	result, err := client.WaitForPayment(context.Background(), token,
+		WithWaitInterval(time.Millisecond),
+		WithWaitJitter(0),
+		WithWaitProgress(func(attempt int, result *PaymentResult, err error) {
+			if err != nil || attempt != len(states)+1 {
+				t.Errorf("poll %d: %v", attempt, err)
+				return
+			}
+			states = append(states, result.State)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The simulator flips to paid on the third lookup
+	if result.State != StatusPaid || result.Token != token || result.AmountRials != 100000 {
+		t.Fatalf("WaitForPayment() = %+v", result)
+	}
+	if want := []TransactionStatus{StatusInit, StatusInit, StatusPaid}; len(states) != len(want) || states[0] != want[0] || states[2] != want[2] {
+		t.Fatalf("polled states %v, want %v", states, want)
+	}
+}
+
+func TestWaitForPaymentUsesStorage(t *testing.T) {
+	transport := newStubTransport(stubStep{err: errors.New("gateway must not be asked")})
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storeWebhookPayment(t, storage, StatusFailed)
+
+	result, err := client.WaitForPayment(context.Background(), webhookToken)
+	if err != nil || result.State != StatusFailed || transport.count() != 0 {
+		t.Fatalf("WaitForPayment() = %+v, %v after %d gateway requests", result, err, transport.count())
+	}
+}
+
+func TestWaitForPaymentRetriesFailedPolls(t *testing.T) {
+	simulator := NewSimulatorTransport(WithSimulatorPaidAfter(1))
+	failures := 2
+	transport := transportFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodGet && failures > 0 {
+			failures--
+			return nil, errors.New("connection reset")
+		}
+		return simulator.Do(req)
+	})
+	client, _, _ := newTestClient(t, testConfig(t, func(c *Config) { c.MaxRetries = 0 }), transport)
+	token := initSimulatedPayment(t, client)
+
+	var failed int
+	result, err := client.WaitForPayment(context.Background(), token,
+		WithWaitInterval(time.Millisecond),
+		WithWaitProgress(func(attempt int, result *PaymentResult, err error) {
+			if err != nil {
+				failed++
+			}
+		}))
+	if err != nil || result.State != StatusPaid || failed != 2 {
+		t.Fatalf("WaitForPayment() = %+v, %v after %d failed polls", result, err, failed)
+	}
+}
+
+func TestWaitForPaymentTimeout(t *testing.T) {
+	client, _, _ := newTestClient(t, testConfig(t), NewSimulatorTransport(WithSimulatorPaidAfter(1000)))
+	token := initSimulatedPayment(t, client)
+
+	// The wait timeout ends the wait with the last known result
+	result, err := client.WaitForPayment(context.Background(), token, WithWaitInterval(time.Millisecond), WithWaitTimeout(20*time.Millisecond))
+	if !errors.Is(err, ErrTimeout) || result == nil || result.State != StatusInit {
+		t.Fatalf("WaitForPayment() = %+v, %v", result, err)
+	}
+
+	// and so does cancelling ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	if _, err := client.WaitForPayment(ctx, token, WithWaitInterval(time.Hour)); !errors.Is(err, ErrTimeout) || time.Since(start) > 5*time.Second {
+		t.Fatalf("WaitForPayment() after cancellation = %v", err)
+	}
+
+	if _, err := client.WaitForPayment(context.Background(), ""); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("WaitForPayment without a token = %v", err)
+	}
+}
+
+func TestJitterInterval(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if got := jitterInterval(time.Second, 0.2); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("jitterInterval() = %v, want within 20%% of 1s", got)
+		}
+	}
+	if got := jitterInterval(time.Second, 0); got != time.Second {
+		t.Fatalf("jitterInterval() without jitter = %v", got)
+	}
+}
@@ -0,0 +1,91 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// warmup.go implements paying connection and token costs before the first payment
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultWarmupTimeout bounds the warm-up Serve runs before accepting requests
+const defaultWarmupTimeout = 10 * time.Second
+
+// ErrWarmupFailed is returned by Warmup when a step failed
+var ErrWarmupFailed = errors.New("warm-up failed")
+
+// Warmup pays the costs the first payment after a start would otherwise pay:
+// it compiles the validation patterns, resolves the gateway host and opens a
+// connection to it that the HTTP client keeps for reuse, and fetches the
+// access token when one is used. Failed steps are logged and the others still
+// run; the returned error joins them. Serve calls it before accepting requests.
+func (c *Client) Warmup(ctx context.Context) error {
+	start := c.clock.Now()
+	var errs []error
+
+	fail := func(step string, err error) {
+		c.log(ctx).Warn(ctx, "Warm-up step failed", map[string]interface{}{
+			"step":  step,
+			"error": err.Error(),
+		})
+		errs = append(errs, fmt.Errorf("%s: %w", step, err))
+	}
+
+	compileValidationPatterns()
+
+	// The simulator has no connection to open
+	if !c.simulated {
+		if err := c.warmConnection(ctx); err != nil {
+			fail("connection", err)
+		}
+	}
+
+	if c.tokenProvider != nil {
+		if _, err := c.tokenProvider.Token(ctx); err != nil {
+			fail("access_token", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrWarmupFailed, errors.Join(errs...))
+	}
+
+	c.log(ctx).Info(ctx, "Client warmed up", map[string]interface{}{
+		"duration_ms": c.clock.Now().Sub(start).Milliseconds(),
+	})
+	return nil
+}
+
+// warmConnection sends a HEAD request to the base URL, so the DNS lookup and
+// TLS handshake are done and the connection waits in the client's pool. Any
+// response will do.
+func (c *Client) warmConnection(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.config.GetBaseURL(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNetworkFailure, err)
+	}
+
+	// Drain the body so the connection goes back to the pool
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// warmupBeforeServe warms the client up for Serve, failing only with StrictWarmup
+func (c *Client) warmupBeforeServe(ctx context.Context) error {
+	warmCtx, cancel := context.WithTimeout(ctx, defaultWarmupTimeout)
+	defer cancel()
+
+	err := c.Warmup(warmCtx)
+	if err != nil && configValues(c.config).StrictWarmup {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// countingGateway serves the gateway over TLS and returns an HTTP client for it
+// counting the connections it dials
+func countingGateway(t *testing.T) (*httptest.Server, *http.Client, *atomic.Int32) {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":true,"amount":100000,"transactionStatus":"PAID"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	var dials atomic.Int32
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	dialer := &net.Dialer{}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		return dialer.DialContext(ctx, network, addr)
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+	return server, &http.Client{Transport: transport}, &dials
+}
+
+// staticTokenProvider returns a fixed token or error and counts the calls
+type staticTokenProvider struct {
+	err   error
+	calls atomic.Int32
+}
+
+func (p *staticTokenProvider) Token(ctx context.Context) (string, error) {
+	p.calls.Add(1)
+	return "access-token", p.err
+}
+
+func (p *staticTokenProvider) Invalidate() {}
+
+func TestWarmupSkipsFirstDial(t *testing.T) {
+	for _, warm := range []bool{false, true} {
+		server, httpClient, dials := countingGateway(t)
+		client, _, logger := newTestClient(t, testConfig(t, func(c *Config) { c.BaseURL = server.URL }), httpClient)
+
+		if warm {
+			if err := client.Warmup(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if _, found := logger.find("Client warmed up"); !found || dials.Load() != 1 {
+				t.Fatalf("warm-up dialed %d times:\n%s", dials.Load(), logger.dump())
+			}
+		}
+
+		// The first request dials only on a cold client
+		if _, err := client.GetPaymentStatus(context.Background(), webhookToken); err != nil {
+			t.Fatal(err)
+		}
+		if dials.Load() != 1 {
+			t.Fatalf("warm %v: %d dials after the first request", warm, dials.Load())
+		}
+	}
+}
+
+func TestWarmupFailures(t *testing.T) {
+	server, httpClient, _ := countingGateway(t)
+	server.Close()
+	tokens := &staticTokenProvider{err: errors.New("refresh token revoked")}
+	client, _, logger := newTestClient(t, testConfig(t, func(c *Config) { c.BaseURL = server.URL }), httpClient, WithClientTokenProvider(tokens))
+
+	// Every step runs and each failure is reported
+	err := client.Warmup(context.Background())
+	if !errors.Is(err, ErrWarmupFailed) || !errors.Is(err, ErrNetworkFailure) || tokens.calls.Load() != 1 {
+		t.Fatalf("Warmup() error = %v, token fetched %d times", err, tokens.calls.Load())
+	}
+	if failures := logger.at("warn"); len(failures) != 2 {
+		t.Fatalf("%d failed steps logged:\n%s", len(failures), logger.dump())
+	}
+	if _, found := logger.find("Client warmed up"); found {
+		t.Fatal("failed warm-up logged as done")
+	}
+}
+
+func TestWarmupSimulator(t *testing.T) {
+	config := devConfig("", true, true)
+	values, err := NewConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(values, NewMemoryStorage(), &captureLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The simulator has no connection to warm
+	if err := client.Warmup(context.Background()); err != nil {
+		t.Fatalf("Warmup() error = %v", err)
+	}
+}
+
+func TestServeWarmup(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		server, httpClient, _ := countingGateway(t)
+		server.Close()
+		client, _, logger := newTestClient(t, testConfig(t, func(c *Config) {
+			c.BaseURL = server.URL
+			c.StrictWarmup = strict
+		}), httpClient)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		addr, result := startServe(t, client, ctx)
+		if strict {
+			// A strict warm-up keeps the server from starting
+			if err := <-result; !errors.Is(err, ErrWarmupFailed) {
+				t.Fatalf("Serve() error = %v", err)
+			}
+			cancel()
+			continue
+		}
+
+		// Otherwise the failure is logged and requests are served
+		status, err := statusRequest(http.DefaultClient, "http://"+addr)
+		cancel()
+		<-result
+		if err != nil || status == 0 {
+			t.Fatalf("serving after a failed warm-up: %d, %v", status, err)
+		}
+		if _, found := logger.find("Warm-up step failed"); !found {
+			t.Fatalf("failed warm-up not logged:\n%s", logger.dump())
+		}
+	}
+}
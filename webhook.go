@@ -0,0 +1,484 @@
+// Package vandargo provides a secure integration with the Vandar payment gateway
+// webhook.go implements receiving Vandar business webhooks
+package vandargo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader carries the hex HMAC-SHA256 of the webhook body keyed
+	// with the webhook secret
+	WebhookSignatureHeader = "X-Vandar-Signature"
+
+	// webhookPath is the path of the webhook route
+	webhookPath = "/payments/webhooks"
+
+	// webhookRateLimit is the per-IP limit of webhook requests per minute
+	webhookRateLimit = 120
+
+	// webhookSyncBudget is how long a webhook is processed before it is answered
+	// and processing continues in the background
+	webhookSyncBudget = 2 * time.Second
+)
+
+// WebhookEventType discriminates the payload of a webhook event
+type WebhookEventType string
+
+const (
+	// WebhookTransactionCreated reports a new transaction on the business account
+	WebhookTransactionCreated WebhookEventType = "transaction.created"
+
+	// WebhookSettlementDone reports a settlement paid out to the business IBAN
+	WebhookSettlementDone WebhookEventType = "settlement.done"
+
+	// WebhookSettlementFailed reports a settlement rejected by the bank
+	WebhookSettlementFailed WebhookEventType = "settlement.failed"
+)
+
+// IsKnown reports whether the event type has a typed payload
+func (t WebhookEventType) IsKnown() bool {
+	switch t {
+	case WebhookTransactionCreated, WebhookSettlementDone, WebhookSettlementFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+// WebhookEvent is a business webhook pushed by Vandar. Type selects which of the
+// typed payloads is set; events of unknown types only carry the raw Data.
+// Representative payloads are in testdata/webhooks.
+type WebhookEvent struct {
+	// ID is the gateway's event identifier
+	ID string `json:"id"`
+
+	// Type is the event type
+	Type WebhookEventType `json:"type"`
+
+	// CreatedAt is when the gateway created the event
+	CreatedAt string `json:"created_at,omitempty"`
+
+	// Data is the raw event payload
+	Data json.RawMessage `json:"data"`
+
+	// Transaction is the payload of transaction events
+	Transaction *WebhookTransaction `json:"-"`
+
+	// Settlement is the payload of settlement events
+	Settlement *WebhookSettlement `json:"-"`
+}
+
+// WebhookTransaction is the payload of transaction webhook events
+type WebhookTransaction struct {
+	// Token is the payment token, empty for transactions not made through the gateway
+	Token string `json:"token,omitempty"`
+
+	// TransID is the transaction ID assigned by the gateway
+	TransID int64 `json:"trans_id"`
+
+	// Amount is the transaction amount in Rials
+	Amount FlexibleAmount `json:"amount"`
+
+	// Wage is the gateway fee
+	Wage FlexibleAmount `json:"wage,omitempty"`
+
+	// Status is the gateway transaction status
+	Status string `json:"status"`
+
+	// FactorNumber is the merchant's invoice number
+	FactorNumber string `json:"factor_number,omitempty"`
+
+	// CardNumber is the masked card number
+	CardNumber string `json:"card_number,omitempty"`
+
+	// RefNumber is the bank reference number
+	RefNumber string `json:"ref_number,omitempty"`
+
+	// TrackingCode is the Shaparak tracking code
+	TrackingCode string `json:"tracking_code,omitempty"`
+
+	// PaymentDate is when the payment was made
+	PaymentDate string `json:"payment_date,omitempty"`
+
+	// Description is the transaction description
+	Description string `json:"description,omitempty"`
+}
+
+// WebhookSettlement is the payload of settlement webhook events
+type WebhookSettlement struct {
+	// SettlementID is the gateway's settlement identifier
+	SettlementID string `json:"settlement_id"`
+
+	// Amount is the settled amount in Rials
+	Amount FlexibleAmount `json:"amount"`
+
+	// Status is the gateway settlement status
+	Status string `json:"status"`
+
+	// IBAN is the destination account
+	IBAN string `json:"iban,omitempty"`
+
+	// TrackingCode is the bank's tracking code
+	TrackingCode string `json:"tracking_code,omitempty"`
+
+	// SettledAt is when the settlement was paid out
+	SettledAt string `json:"settled_at,omitempty"`
+
+	// Description is the settlement description
+	Description string `json:"description,omitempty"`
+}
+
+// Settlement is the local record of a settlement reported by webhook
+type Settlement struct {
+	// ID is the gateway's settlement identifier
+	ID string `json:"id"`
+
+	// Amount is the settled amount in Rials
+	Amount int64 `json:"amount"`
+
+	// Status is the last gateway settlement status
+	Status string `json:"status"`
+
+	// IBAN is the destination account
+	IBAN string `json:"iban,omitempty"`
+
+	// TrackingCode is the bank's tracking code
+	TrackingCode string `json:"tracking_code,omitempty"`
+
+	// SettledAt is when the settlement was paid out
+	SettledAt *time.Time `json:"settled_at,omitempty"`
+
+	// UpdatedAt is when the record was last changed
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SettlementStorageInterface is implemented by storages that can keep settlement
+// records; settlement webhooks are only recorded when the client's storage implements it
+type SettlementStorageInterface interface {
+	// SaveSettlement creates or replaces a settlement
+	SaveSettlement(ctx context.Context, settlement *Settlement) error
+
+	// GetSettlement retrieves a settlement by ID
+	GetSettlement(ctx context.Context, id string) (*Settlement, error)
+}
+
+// ParseWebhookEvent parses a webhook body and its typed payload
+func ParseWebhookEvent(body []byte) (*WebhookEvent, error) {
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("%w: invalid webhook JSON: %v", ErrInvalidRequest, err)
+	}
+
+	if event.Type == "" {
+		return nil, NewValidationError("type", "event type is required")
+	}
+
+	switch event.Type {
+	case WebhookTransactionCreated:
+		var payload WebhookTransaction
+		if err := json.Unmarshal(event.Data, &payload); err != nil {
+			return nil, fmt.Errorf("%w: invalid transaction payload: %v", ErrInvalidRequest, err)
+		}
+		event.Transaction = &payload
+	case WebhookSettlementDone, WebhookSettlementFailed:
+		var payload WebhookSettlement
+		if err := json.Unmarshal(event.Data, &payload); err != nil {
+			return nil, fmt.Errorf("%w: invalid settlement payload: %v", ErrInvalidRequest, err)
+		}
+		if payload.SettlementID == "" {
+			return nil, NewValidationError("data.settlement_id", "settlement ID is required")
+		}
+		event.Settlement = &payload
+	}
+
+	return &event, nil
+}
+
+// VerifyWebhookSignature reports whether a signature is the HMAC of a webhook body
+func VerifyWebhookSignature(body []byte, signature, secret string) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+	return VerifySignature(strings.ToLower(strings.TrimSpace(signature)), string(body), secret)
+}
+
+// HandleWebhookEvent applies a webhook event to the local records and fires the
+// OnWebhookEvent hook. Events of unknown types are only logged.
+func (c *Client) HandleWebhookEvent(ctx context.Context, event *WebhookEvent) error {
+	fields := map[string]interface{}{
+		"event_id":   event.ID,
+		"event_type": string(event.Type),
+	}
+
+	var err error
+	switch {
+	case event.Transaction != nil:
+		err = c.applyWebhookTransaction(ctx, event.Transaction)
+	case event.Settlement != nil:
+		err = c.applyWebhookSettlement(ctx, event.Settlement)
+	default:
+		c.log(ctx).Warn(ctx, "Ignoring webhook event of unknown type", fields)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.fireWebhookEvent(ctx, event)
+	return nil
+}
+
+// applyWebhookTransaction records a transaction event on the matching stored
+// transaction. A payment reported paid is verified rather than marked paid: the
+// gateway reverses payments that aren't verified in time.
+func (c *Client) applyWebhookTransaction(ctx context.Context, payload *WebhookTransaction) error {
+	if payload.Token == "" {
+		return nil
+	}
+
+	verify, err := c.recordWebhookTransaction(ctx, payload)
+	if err != nil || !verify {
+		return err
+	}
+
+	// A failed verification leaves the payment to the pending verification scans
+	if _, err := c.VerifyPayment(ctx, payload.Token); err != nil {
+		c.log(ctx).Warn(ctx, "Failed to verify payment reported paid by webhook", map[string]interface{}{
+			"token": redactToken(payload.Token),
+			"error": err.Error(),
+		})
+	}
+	return nil
+}
+
+// recordWebhookTransaction stores the details of a transaction event and reports
+// whether the event says the payment was paid before it was verified
+func (c *Client) recordWebhookTransaction(ctx context.Context, payload *WebhookTransaction) (bool, error) {
+	release := c.tokenLocks.Lock(payload.Token)
+	defer release()
+
+	transaction, err := c.storage.GetTransaction(ctx, payload.Token)
+	if err != nil {
+		c.log(ctx).Debug(ctx, "No stored transaction for webhook event", map[string]interface{}{
+			"token": redactToken(payload.Token),
+		})
+		return false, nil
+	}
+
+	patch := TransactionPatch{}
+	changed := false
+	if payload.TransID != 0 && transaction.TransactionID == 0 {
+		patch.TransactionID = &payload.TransID
+		changed = true
+	}
+	if payload.RefNumber != "" && transaction.RefNumber == "" {
+		patch.RefNumber = &payload.RefNumber
+		changed = true
+	}
+	if payload.TrackingCode != "" && transaction.TrackingCode == "" {
+		patch.TrackingCode = &payload.TrackingCode
+		changed = true
+	}
+	if payload.CardNumber != "" && transaction.CardNumber == "" {
+		patch.CardNumber = &payload.CardNumber
+		changed = true
+	}
+
+	// The status only moves along the state machine, so a late event can't undo
+	// a verification or refund
+	previousStatus := transaction.Status
+	status, known := NormalizeTransactionStatus(payload.Status)
+	if !known && payload.Status != "" {
+		c.log(ctx).Warn(ctx, "Unrecognized gateway transaction status", map[string]interface{}{
+			"token":      redactToken(payload.Token),
+			"raw_status": payload.Status,
+		})
+	}
+
+	// Only a verification may mark the payment paid; one already in progress
+	// records its own outcome
+	verify := status == StatusPaid && previousStatus != StatusVerifyPending && previousStatus.CanTransitionTo(StatusPaid)
+	if status != StatusPaid && status != previousStatus && previousStatus.CanTransitionTo(status) {
+		patch.Status = &status
+		changed = true
+		if status.IsTerminal() && transaction.CompletedAt == nil {
+			completedAt := c.clock.Now()
+			if paidAt := parsePaymentDate(payload.PaymentDate); paidAt != nil {
+				completedAt = *paidAt
+			}
+			patch.CompletedAt = &completedAt
+		}
+	}
+
+	if !changed {
+		return verify, nil
+	}
+
+	if err := c.patchTransaction(ctx, payload.Token, patch); err != nil {
+		return false, fmt.Errorf("failed to update transaction: %w", err)
+	}
+	c.applyPatch(&patch, transaction)
+	c.invalidateCache(ctx, payload.Token)
+
+	c.fireStatusChange(ctx, transaction, previousStatus)
+	return verify, nil
+}
+
+// applyWebhookSettlement records a settlement event when the storage supports it
+func (c *Client) applyWebhookSettlement(ctx context.Context, payload *WebhookSettlement) error {
+	storage, ok := c.storage.(SettlementStorageInterface)
+	if !ok {
+		return nil
+	}
+
+	settlement := &Settlement{
+		ID:           payload.SettlementID,
+		Amount:       payload.Amount.Int64(),
+		Status:       payload.Status,
+		IBAN:         payload.IBAN,
+		TrackingCode: payload.TrackingCode,
+		SettledAt:    parsePaymentDate(payload.SettledAt),
+		UpdatedAt:    c.clock.Now(),
+	}
+
+	if err := storage.SaveSettlement(ctx, settlement); err != nil {
+		return fmt.Errorf("failed to store settlement: %w", err)
+	}
+	return nil
+}
+
+// handleWebhook handles Vandar business webhooks. The event is answered once
+// processed, or after a short budget while processing continues in the background,
+// so slow storage doesn't make the gateway retry.
+func (c *Client) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	secret := configValues(c.config).WebhookSecret
+	if secret == "" {
+		c.respondWithError(w, ErrPermission, "Webhooks are disabled")
+		return
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		c.respondWithError(w, ErrInvalidRequest, "Invalid request body")
+		return
+	}
+
+	if !VerifyWebhookSignature(body, r.Header.Get(WebhookSignatureHeader), secret) {
+		c.respondWithError(w, ErrAuthentication, "Invalid webhook signature")
+		return
+	}
+
+	event, err := ParseWebhookEvent(body)
+	if err != nil {
+		c.respondInvalid(w, err)
+		return
+	}
+
+	c.log(ctx).Info(ctx, "Received webhook event", map[string]interface{}{
+		"event_id":   event.ID,
+		"event_type": string(event.Type),
+	})
+
+	// Process outside the request so it can outlive the response; the operation is
+	// tracked on its own, since the request's tracking ends with the response
+	processCtx := context.WithValue(context.WithoutCancel(ctx), inflightKey, nil)
+	processCtx, done, err := c.beginOperation(processCtx)
+	if err != nil {
+		c.respondError(w, err)
+		return
+	}
+
+	result := make(chan error, 1)
+	untrack := c.health.trackWebhook()
+	go func() {
+		defer done()
+		defer untrack()
+		result <- c.HandleWebhookEvent(processCtx, event)
+	}()
+
+	timer := c.clock.NewTimer(webhookSyncBudget)
+	defer timer.Stop()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			// The gateway redelivers events that weren't acknowledged
+			c.respondWithError(w, ErrInternalError, "Failed to process webhook event")
+			c.log(ctx).Error(ctx, "Failed to process webhook event", err, map[string]interface{}{
+				"event_id":   event.ID,
+				"event_type": string(event.Type),
+			})
+			return
+		}
+	case <-timer.C():
+		c.log(ctx).Warn(ctx, "Webhook processing is slow, continuing in the background", map[string]interface{}{
+			"event_id": event.ID,
+		})
+		go func() {
+			if err := <-result; err != nil {
+				c.log(processCtx).Error(processCtx, "Failed to process webhook event", err, map[string]interface{}{
+					"event_id":   event.ID,
+					"event_type": string(event.Type),
+				})
+			}
+		}()
+	}
+
+	c.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  true,
+		"message": "Webhook received",
+	})
+}
+
+// SaveSettlement creates or replaces a settlement
+func (s *MemoryStorage) SaveSettlement(ctx context.Context, settlement *Settlement) error {
+	if settlement == nil || settlement.ID == "" {
+		return errors.New("settlement ID cannot be empty")
+	}
+
+	if err := s.enter(ctx); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	settlementCopy := *settlement
+	s.settlements[settlement.ID] = &settlementCopy
+
+	return nil
+}
+
+// GetSettlement retrieves a settlement by ID
+func (s *MemoryStorage) GetSettlement(ctx context.Context, id string) (*Settlement, error) {
+	if err := s.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	settlement, exists := s.settlements[id]
+	if !exists {
+		return nil, fmt.Errorf("settlement not found: %s", id)
+	}
+
+	settlementCopy := *settlement
+	return &settlementCopy, nil
+}
@@ -0,0 +1,231 @@
+package vandargo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webhookToken is the token of the payment in testdata/webhooks/transaction_created.json
+const webhookToken = "sim00000000000000001"
+
+func readWebhookFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	body, err := os.ReadFile(filepath.Join("testdata", "webhooks", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestParseWebhookEventFixtures(t *testing.T) {
+	transaction, err := ParseWebhookEvent(readWebhookFixture(t, "transaction_created.json"))
+	if err != nil {
+		t.Fatalf("transaction_created: %v", err)
+	}
+	if transaction.Type != WebhookTransactionCreated || transaction.Transaction == nil {
+		t.Fatalf("transaction_created parsed as %+v", transaction)
+	}
+	if got := transaction.Transaction; got.Token != webhookToken || got.TransID != 160000000001 ||
+		got.Amount.Int64() != 100000 || got.Wage.Int64() != 1500 || got.Status != "PAID" {
+		t.Fatalf("transaction payload = %+v", got)
+	}
+
+	for _, name := range []string{"settlement_done.json", "settlement_failed.json"} {
+		event, err := ParseWebhookEvent(readWebhookFixture(t, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if event.Settlement == nil || event.Settlement.SettlementID == "" || event.Transaction != nil {
+			t.Fatalf("%s parsed as %+v", name, event)
+		}
+	}
+
+	unknown, err := ParseWebhookEvent(readWebhookFixture(t, "unknown_type.json"))
+	if err != nil {
+		t.Fatalf("unknown_type: %v", err)
+	}
+	if unknown.Type.IsKnown() || unknown.Transaction != nil || unknown.Settlement != nil || len(unknown.Data) == 0 {
+		t.Fatalf("unknown_type parsed as %+v", unknown)
+	}
+}
+
+func TestParseWebhookEventErrors(t *testing.T) {
+	tests := map[string]string{
+		"invalid JSON":          `{"type":`,
+		"missing type":          `{"id":"evt","data":{}}`,
+		"bad transaction":       `{"type":"transaction.created","data":{"trans_id":"x"}}`,
+		"missing settlement ID": `{"type":"settlement.done","data":{"amount":1}}`,
+	}
+
+	for name, body := range tests {
+		if _, err := ParseWebhookEvent([]byte(body)); err == nil {
+			t.Errorf("%s: ParseWebhookEvent() accepted %s", name, body)
+		}
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := readWebhookFixture(t, "settlement_done.json")
+	signature := SignData(string(body), "secret")
+
+	if !VerifyWebhookSignature(body, strings.ToUpper(signature), "secret") {
+		t.Error("valid signature rejected")
+	}
+	if VerifyWebhookSignature(body, signature, "other") {
+		t.Error("signature accepted with the wrong secret")
+	}
+	if VerifyWebhookSignature(append(body, ' '), signature, "secret") {
+		t.Error("signature accepted for a changed body")
+	}
+	if VerifyWebhookSignature(body, "", "") {
+		t.Error("empty signature accepted without a secret")
+	}
+}
+
+// storeWebhookPayment stores the INIT payment the transaction fixture reports on
+func storeWebhookPayment(t *testing.T, storage *MemoryStorage, status TransactionStatus) {
+	t.Helper()
+
+	err := storage.StoreTransaction(context.Background(), &Transaction{
+		ID:           "tx-webhook",
+		Token:        webhookToken,
+		Amount:       100000,
+		Status:       status,
+		FactorNumber: "1042",
+		Description:  "Order 1042",
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookPaidEventVerifiesPayment(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{
+		"status":       1,
+		"amount":       "100000",
+		"transId":      160000000001,
+		"factorNumber": "1042",
+		"description":  "Order 1042",
+	}))
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	event, err := ParseWebhookEvent(readWebhookFixture(t, "transaction_created.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.HandleWebhookEvent(context.Background(), event); err != nil {
+		t.Fatalf("HandleWebhookEvent() error = %v", err)
+	}
+
+	if transport.count() != 1 {
+		t.Fatalf("sent %d gateway requests, want one verification", transport.count())
+	}
+	if req, _ := transport.request(0); req.URL.Path != client.endpoints().Verify {
+		t.Fatalf("requested %s, want the verify endpoint", req.URL.Path)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusPaid || transaction.RefNumber != "160000000001" || transaction.TrackingCode != "000001" {
+		t.Fatalf("transaction = %+v, want PAID with the webhook details", transaction)
+	}
+}
+
+func TestWebhookPaidEventNeverMarksUnverifiedPaymentPaid(t *testing.T) {
+	transport := newStubTransport(stubStep{err: errors.New("connection reset")})
+	config := testConfig(t, func(c *Config) {
+		c.MaxRetries = 0
+		c.VerifyRetryDelay = -1
+	})
+	client, storage, logger := newTestClient(t, config, transport)
+	storeWebhookPayment(t, storage, StatusInit)
+
+	event, err := ParseWebhookEvent(readWebhookFixture(t, "transaction_created.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.HandleWebhookEvent(context.Background(), event); err != nil {
+		t.Fatalf("HandleWebhookEvent() error = %v", err)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status == StatusPaid {
+		t.Fatal("webhook marked an unverified payment PAID")
+	}
+	if transaction.TransactionID != 160000000001 || transaction.RefNumber == "" {
+		t.Fatalf("webhook details weren't recorded: %+v", transaction)
+	}
+	if _, found := logger.find("Failed to verify payment reported paid by webhook"); !found {
+		t.Fatal("failed verification wasn't logged")
+	}
+
+	// The payment stays with the verification scans
+	pending, _ := storage.GetTransactionsByStatus(context.Background(), string(StatusVerifyPending))
+	if len(pending) != 1 {
+		t.Fatalf("transaction status = %s, want it left for verification", transaction.Status)
+	}
+}
+
+func TestWebhookPaidEventLeavesVerificationInProgress(t *testing.T) {
+	transport := newStubTransport(jsonStep(http.StatusOK, map[string]interface{}{"status": 1}))
+	client, storage, _ := newTestClient(t, testConfig(t), transport)
+	storeWebhookPayment(t, storage, StatusVerifyPending)
+
+	event, err := ParseWebhookEvent(readWebhookFixture(t, "transaction_created.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.HandleWebhookEvent(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	transaction, _ := storage.GetTransaction(context.Background(), webhookToken)
+	if transaction.Status != StatusVerifyPending || transport.count() != 0 {
+		t.Fatalf("status %s after %d requests, want VERIFY_PENDING untouched", transaction.Status, transport.count())
+	}
+}
+
+func TestWebhookHandlerSignature(t *testing.T) {
+	config := testConfig(t, func(c *Config) { c.WebhookSecret = "webhook-secret" })
+	client, storage, _ := newTestClient(t, config, newStubTransport(stubStep{status: http.StatusNotFound, body: `{}`}))
+	handler := client.Handler()
+
+	body := readWebhookFixture(t, "settlement_done.json")
+	tests := []struct {
+		name      string
+		signature string
+		want      int
+	}{
+		{"valid", SignData(string(body), "webhook-secret"), http.StatusOK},
+		{"wrong secret", SignData(string(body), "other"), http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, webhookPath, strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.signature != "" {
+			req.Header.Set(WebhookSignatureHeader, tt.signature)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s signature: status %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	settlement, err := storage.GetSettlement(context.Background(), "st_4821")
+	if err != nil || settlement.Amount != 12500000 {
+		t.Fatalf("settlement = %+v, %v", settlement, err)
+	}
+}